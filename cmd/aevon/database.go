@@ -0,0 +1,111 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/aevon-lab/project-aevon/internal/aggregation"
+	"github.com/aevon-lab/project-aevon/internal/archive"
+	corecfg "github.com/aevon-lab/project-aevon/internal/core/config"
+	"github.com/aevon-lab/project-aevon/internal/core/storage"
+	"github.com/aevon-lab/project-aevon/internal/core/storage/memory"
+	"github.com/aevon-lab/project-aevon/internal/core/storage/postgres"
+	"github.com/aevon-lab/project-aevon/internal/core/storage/sqlite"
+	"github.com/aevon-lab/project-aevon/internal/migrations"
+)
+
+// preAggregateStore is what aevon needs of a pre-aggregate store. Every backend tracks
+// rule versions, so changed rules are rebuilt from the event log.
+type preAggregateStore interface {
+	aggregation.PreAggregateStore
+	aggregation.RuleVersionStore
+}
+
+// database holds the stores of the configured database.type.
+type database struct {
+	dbType        string
+	db            *sql.DB // nil for the memory database
+	events        storage.EventStore
+	preAggregates preAggregateStore
+	archive       *archive.Archive // nil unless events.archive is enabled
+	close         func() error
+}
+
+// openDatabase opens the database of cfg. Other stores of PostgreSQL (leases, webhook
+// thresholds) are created from db.
+func openDatabase(cfg corecfg.DatabaseConfig) (*database, error) {
+	switch cfg.Type {
+	case corecfg.DatabaseTypeSQLite:
+		adapter, err := sqlite.NewAdapter(cfg.DSN, cfg.MaxOpenConns, cfg.MaxIdleConns)
+		if err != nil {
+			return nil, err
+		}
+		return &database{
+			dbType:        corecfg.DatabaseTypeSQLite,
+			db:            adapter.DB(),
+			events:        adapter,
+			preAggregates: sqlite.NewPreAggregateAdapter(adapter.DB()),
+			close:         adapter.Close,
+		}, nil
+	case corecfg.DatabaseTypeMemory:
+		return &database{
+			dbType:        corecfg.DatabaseTypeMemory,
+			events:        memory.NewEventStore(),
+			preAggregates: memory.NewPreAggregateStore(),
+			close:         func() error { return nil },
+		}, nil
+	}
+
+	adapter, err := postgres.NewAdapter(cfg.DSN, cfg.MaxOpenConns, cfg.MaxIdleConns)
+	if err != nil {
+		return nil, err
+	}
+	return &database{
+		dbType:        corecfg.DatabaseTypePostgres,
+		db:            adapter.DB(),
+		events:        adapter,
+		preAggregates: postgres.NewPreAggregateAdapter(adapter.DB()),
+		close:         adapter.Close,
+	}, nil
+}
+
+// embedded reports whether the database belongs to this process alone, so there are no
+// other replicas to elect a leader with.
+func (d *database) embedded() bool {
+	return d.dbType == corecfg.DatabaseTypeSQLite || d.dbType == corecfg.DatabaseTypeMemory
+}
+
+// requireSharedDatabase rejects the memory database for the command: it only exists
+// inside the running aevon process, so a command of its own cannot reach it.
+func requireSharedDatabase(cfg corecfg.DatabaseConfig, command string) error {
+	if cfg.Type == corecfg.DatabaseTypeMemory {
+		return fmt.Errorf("aevon %s needs database.type postgres or sqlite; the memory database only exists inside the running aevon process", command)
+	}
+	return nil
+}
+
+// openArchive opens the event archive if it is enabled. Event reads below the hot range
+// are then served from the archive.
+func (d *database) openArchive(cfg corecfg.ArchiveConfig) error {
+	if !cfg.Enabled {
+		return nil
+	}
+	eventArchive, err := archive.Open(cfg.Path)
+	if err != nil {
+		return err
+	}
+	d.archive = eventArchive
+	d.events = archive.NewEventStore(d.events, eventArchive)
+	return nil
+}
+
+// migrate runs the migrations of the database type.
+func (d *database) migrate(autoMigrate bool) error {
+	switch d.dbType {
+	case corecfg.DatabaseTypeSQLite:
+		return migrations.RunSQLiteMigrations(d.db, autoMigrate)
+	case corecfg.DatabaseTypeMemory:
+		return nil
+	}
+	return migrations.RunMigrations(d.db, autoMigrate)
+}
@@ -9,22 +9,35 @@ import (
 	"os/signal"
 	"syscall"
 	"time"
+	_ "time/tzdata" // Quota check periods resolve IANA timezones; the runtime image has no zoneinfo
 
 	"github.com/aevon-lab/project-aevon/internal/aggregation"
+	"github.com/aevon-lab/project-aevon/internal/archive"
+	coreagg "github.com/aevon-lab/project-aevon/internal/core/aggregation"
 	corecfg "github.com/aevon-lab/project-aevon/internal/core/config"
 	"github.com/aevon-lab/project-aevon/internal/core/storage/postgres"
 	"github.com/aevon-lab/project-aevon/internal/ingestion"
-	"github.com/aevon-lab/project-aevon/internal/migrations"
 	"github.com/aevon-lab/project-aevon/internal/projection"
+	"github.com/aevon-lab/project-aevon/internal/retention"
 	"github.com/aevon-lab/project-aevon/internal/schema"
 	schemaapi "github.com/aevon-lab/project-aevon/internal/schema/api"
 	"github.com/aevon-lab/project-aevon/internal/schema/formats/protobuf"
 	"github.com/aevon-lab/project-aevon/internal/schema/formats/yaml"
 	schemaStorage "github.com/aevon-lab/project-aevon/internal/schema/storage"
 	"github.com/aevon-lab/project-aevon/internal/server"
+	"github.com/aevon-lab/project-aevon/internal/threshold"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "verify":
+			os.Exit(runVerify(os.Args[2:]))
+		case "rebuild":
+			os.Exit(runRebuild(os.Args[2:]))
+		}
+	}
+
 	configPath := flag.String("config", "aevon.yaml", "Path to configuration file")
 	flag.Parse()
 
@@ -45,24 +58,29 @@ func main() {
 		slog.Error("Invalid aggregation interval", "value", cfg.Aggregation.EffectiveCronInterval(), "error", err)
 		os.Exit(1)
 	}
+	leaseTTL, err := time.ParseDuration(cfg.Aggregation.LeaseTTL)
+	if err != nil {
+		slog.Error("Invalid aggregation lease TTL", "value", cfg.Aggregation.LeaseTTL, "error", err)
+		os.Exit(1)
+	}
 
-	// 2. Initialize Storage (PostgreSQL)
-	dbAdapter, err := postgres.NewAdapter(
-		cfg.Database.DSN,
-		cfg.Database.MaxOpenConns,
-		cfg.Database.MaxIdleConns,
-	)
+	// 2. Initialize Storage (PostgreSQL, embedded SQLite or in-memory)
+	database, err := openDatabase(cfg.Database)
 	if err != nil {
 		slog.Error("Failed to initialize database", "error", err)
 		os.Exit(1)
 	}
-	defer dbAdapter.Close()
+	defer database.close()
 
 	// 2.1. Run Database Migrations
-	if err := migrations.RunMigrations(dbAdapter.DB(), cfg.Database.AutoMigrate); err != nil {
+	if err := database.migrate(cfg.Database.AutoMigrate); err != nil {
 		slog.Error("Failed to run database migrations", "error", err)
 		os.Exit(1)
 	}
+	if err := database.openArchive(cfg.Events.Archive); err != nil {
+		slog.Error("Failed to open event archive", "error", err)
+		os.Exit(1)
+	}
 
 	// 3. Initialize Schema Registry
 	var schemaRepo schemaStorage.Repository
@@ -82,69 +100,286 @@ func main() {
 	validator := schema.NewValidator(formatRegistry)
 
 	// 4. Initialize Aggregation (Cron-based batch processing)
-	preAggStore := postgres.NewPreAggregateAdapter(dbAdapter.DB())
+	preAggStore := database.preAggregates
 
-	schedulers := make([]*aggregation.Scheduler, 0, 1)
+	var (
+		schedulerGroup *aggregation.SchedulerGroup
+		ruleRebuilder  *aggregation.RuleRebuilder
+		leaderElector  *aggregation.LeaderElector
+	)
 	if cfg.Aggregation.Enabled && len(cfg.RuleLoading.Rules) == 0 {
-		slog.Warn("Aggregation is enabled but no rules were loaded; scheduler will not start")
+		slog.Warn("Aggregation is enabled but no rules were loaded; schedulers start once rules are reloaded")
 	}
-	if cfg.Aggregation.Enabled && len(cfg.RuleLoading.Rules) > 0 {
-		// MVP: aggregation runs on fixed 1-minute buckets.
-		schedulers = append(schedulers, aggregation.NewScheduler(
+	if cfg.Aggregation.Enabled {
+		// One scheduler per bucket size declared by the rules, each with its own checkpoint.
+		schedulerGroup = aggregation.NewSchedulerGroup(
 			cronInterval,
-			dbAdapter, // EventStore
+			database.events,
 			preAggStore,
 			cfg.RuleLoading.Rules,
 			aggregation.BatchJobParameter{
 				BatchSize:   cfg.Aggregation.BatchSize,
 				WorkerCount: cfg.Aggregation.WorkerCount,
-				BucketSize:  time.Minute,
-				BucketLabel: "1m",
 			},
-		))
+		)
+
+		// Each bucket size is drained by one scheduler per partition range.
+		schedulerGroup.UsePartitionShards(cfg.Aggregation.PartitionShards)
+
+		// Streaming mode folds ingested events in memory; the cron interval keeps draining
+		// the event log for everything this process did not ingest itself.
+		if cfg.Aggregation.Mode == corecfg.AggregationModeStreaming {
+			flushInterval, err := time.ParseDuration(cfg.Aggregation.FlushInterval)
+			if err != nil {
+				slog.Error("Invalid aggregation flush interval", "value", cfg.Aggregation.FlushInterval, "error", err)
+				os.Exit(1)
+			}
+			gapTimeout, err := time.ParseDuration(cfg.Aggregation.GapTimeout)
+			if err != nil {
+				slog.Error("Invalid aggregation gap timeout", "value", cfg.Aggregation.GapTimeout, "error", err)
+				os.Exit(1)
+			}
+			schedulerGroup.UseStreaming(aggregation.StreamingOptions{
+				FlushInterval: flushInterval,
+				GapTimeout:    gapTimeout,
+			})
+		}
+
+		// Rebuilds pre-aggregates of rules whose definition changed since they were built.
+		ruleRebuilder = aggregation.NewRuleRebuilder(
+			cronInterval,
+			database.events,
+			preAggStore,
+			cfg.RuleLoading.Rules,
+			aggregation.BatchJobParameter{
+				BatchSize:   cfg.Aggregation.BatchSize,
+				WorkerCount: cfg.Aggregation.WorkerCount,
+			},
+		)
+
+		// Replicas sharing the database elect one leader per partition range and for rebuilds.
+		// An embedded database has a single replica.
+		if cfg.Aggregation.LeaderElection && database.embedded() {
+			slog.Info("Leader election disabled for the embedded database", "type", database.dbType)
+		} else if cfg.Aggregation.LeaderElection {
+			leaderElector = aggregation.NewLeaderElector(
+				postgres.NewLeaseAdapter(database.db),
+				cfg.Aggregation.EffectiveReplicaID(),
+				leaseTTL,
+			)
+			leaderElector.LimitLeases(cfg.Aggregation.MaxLeasesPerReplica)
+			schedulerGroup.UseLeaderElector(leaderElector)
+			ruleRebuilder.UseLeaderElector(leaderElector)
+		}
+
+		bucketLabels := make([]string, 0, 1)
+		for _, bucketSize := range aggregation.BucketSizes(cfg.RuleLoading.Rules) {
+			bucketLabels = append(bucketLabels, coreagg.BucketLabel(bucketSize))
+		}
 
 		slog.Info("Aggregation scheduler(s) initialized",
 			"interval", cronInterval,
+			"mode", cfg.Aggregation.Mode,
 			"enabled", cfg.Aggregation.Enabled,
-			"bucket_sizes", []string{"1m"},
+			"bucket_sizes", bucketLabels,
 			"batch_size", cfg.Aggregation.BatchSize,
 			"worker_count", cfg.Aggregation.WorkerCount,
+			"partition_shards", cfg.Aggregation.PartitionShards,
 			"rules_loaded", len(cfg.RuleLoading.Rules),
+			"leader_election", leaderElector != nil,
+			"replica_id", cfg.Aggregation.EffectiveReplicaID(),
 		)
 	}
 
 	// 5. Initialize Ingestion (no event channel - just write to DB)
-	ingestionSvc := ingestion.NewService(registry, validator, dbAdapter, cfg.Server.MaxBodySizeMB)
+	ingestionSvc := ingestion.NewService(registry, validator, database.events, cfg.Server.MaxBodySizeMB)
+	ingestionSvc.SetRules(cfg.RuleLoading.Rules)
+	if schedulerGroup != nil {
+		// Hands persisted events to the schedulers in streaming mode; a no-op in batch mode.
+		ingestionSvc.UseEventObserver(schedulerGroup)
+	}
 
 	// 6. Initialize Projection (query API)
-	projectionSvc := projection.NewService(preAggStore, dbAdapter, cfg.RuleLoading.Rules)
+	projectionSvc := projection.NewService(preAggStore, database.events, cfg.RuleLoading.Rules)
+	// State streams are pushed on ingested events and scheduler flushes.
+	ingestionSvc.UseEventObserver(projectionSvc)
+	if schedulerGroup != nil {
+		schedulerGroup.UseFlushObserver(projectionSvc)
+	}
 	schemaAPISvc := schemaapi.NewService(registry, validator)
 
+	// 6.1. Threshold webhooks are evaluated after every scheduler flush and delivered
+	// from a durable outbox.
+	var (
+		thresholdSvc      *threshold.Service
+		webhookDispatcher *threshold.Dispatcher
+	)
+	if cfg.Webhooks.Enabled {
+		staticThresholds, err := threshold.StaticThresholds(cfg.Webhooks.Thresholds, cfg.RuleLoading.Rules)
+		if err != nil {
+			slog.Error("Invalid webhook threshold", "error", err)
+			os.Exit(1)
+		}
+		thresholdAdapter := postgres.NewThresholdAdapter(database.db)
+		thresholdSvc = threshold.NewService(thresholdAdapter, projectionSvc, staticThresholds, cfg.RuleLoading.Rules)
+		if schedulerGroup != nil {
+			schedulerGroup.UseFlushObserver(thresholdSvc)
+		} else {
+			slog.Warn("Webhooks are enabled but aggregation is disabled; thresholds are never evaluated")
+		}
+
+		webhookTimeout, err := time.ParseDuration(cfg.Webhooks.Timeout)
+		if err != nil {
+			slog.Error("Invalid webhook timeout", "value", cfg.Webhooks.Timeout, "error", err)
+			os.Exit(1)
+		}
+		webhookPollInterval, err := time.ParseDuration(cfg.Webhooks.PollInterval)
+		if err != nil {
+			slog.Error("Invalid webhook poll interval", "value", cfg.Webhooks.PollInterval, "error", err)
+			os.Exit(1)
+		}
+		webhookDispatcher = threshold.NewDispatcher(thresholdAdapter, threshold.DispatcherOptions{
+			Secret:       []byte(string(cfg.Webhooks.SigningSecret)),
+			Timeout:      webhookTimeout,
+			PollInterval: webhookPollInterval,
+			MaxAttempts:  cfg.Webhooks.MaxAttempts,
+		})
+		slog.Info("Threshold webhooks initialized",
+			"static_thresholds", len(staticThresholds),
+			"timeout", webhookTimeout,
+			"poll_interval", webhookPollInterval,
+			"max_attempts", cfg.Webhooks.MaxAttempts,
+		)
+	}
+
+	// 6.2. Verification recomputes pre-aggregates from the event log to audit them.
+	verifier := aggregation.NewVerifier(database.events, preAggStore, cfg.RuleLoading.Rules, aggregation.BatchJobParameter{
+		BatchSize: cfg.Aggregation.BatchSize,
+	})
+
+	// 6.3. The PostgreSQL event log is partitioned by ingestion month; partitions are
+	// created ahead, archived once ended if enabled, and retired once aggregated past the
+	// retention period.
+	var partitionMaintainer *retention.Maintainer
+	if database.dbType == corecfg.DatabaseTypePostgres {
+		maintenanceInterval, err := time.ParseDuration(cfg.Events.MaintenanceInterval)
+		if err != nil {
+			slog.Error("Invalid events maintenance interval", "value", cfg.Events.MaintenanceInterval, "error", err)
+			os.Exit(1)
+		}
+		partitionAdapter := postgres.NewEventPartitionAdapter(database.db)
+		partitionMaintainer = retention.NewMaintainer(partitionAdapter, cfg.RuleLoading.Rules, retention.Options{
+			PartitionsAhead: cfg.Events.PartitionsAhead,
+			RetentionMonths: cfg.Events.RetentionMonths,
+			Drop:            cfg.Events.RetentionAction == corecfg.RetentionActionDrop,
+			Interval:        maintenanceInterval,
+		})
+		if database.archive != nil {
+			partitionMaintainer.UseArchiver(archive.NewExporter(database.archive, partitionAdapter, cfg.Events.Archive.RowsPerFile))
+		}
+	}
+
+	// 6.4. Rule reload swaps new rule sets into the projection and the schedulers.
+	ruleSubscribers := []aggregation.RuleSubscriber{projectionSvc, ingestionSvc, verifier}
+	if schedulerGroup != nil {
+		ruleSubscribers = append(ruleSubscribers, schedulerGroup, ruleRebuilder)
+	}
+	if thresholdSvc != nil {
+		ruleSubscribers = append(ruleSubscribers, thresholdSvc)
+	}
+	if partitionMaintainer != nil {
+		ruleSubscribers = append(ruleSubscribers, partitionMaintainer)
+	}
+	ruleReloader := aggregation.NewRuleReloader(cfg.RuleLoading.Repository, cfg.Aggregation.RequireRules, ruleSubscribers...)
+
 	// 7. Initialize Server
-	srv := server.New(fmtAddr(cfg.Server.Host, cfg.Server.Port), dbAdapter.DB(), cfg.Server.Mode)
+	srv := server.New(fmtAddr(cfg.Server.Host, cfg.Server.Port), database.db, cfg.Server.Mode)
 	ingestionSvc.RegisterRoutes(srv.Engine)
 	projectionSvc.RegisterRoutes(srv.Engine)
+	srv.RegisterOnShutdown(projectionSvc.CloseStreams)
 	schemaAPISvc.RegisterRoutes(srv.Engine)
+	ruleReloader.RegisterRoutes(srv.Engine)
+	verifier.RegisterRoutes(srv.Engine)
+	if thresholdSvc != nil {
+		thresholdSvc.RegisterRoutes(srv.Engine)
+	}
+	if leaderElector != nil {
+		srv.RegisterHealthReporter("leadership", leaderElector)
+	}
 
 	// 8. Start Services
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	// Start aggregation scheduler(s) in background if enabled
-	if cfg.Aggregation.Enabled && len(schedulers) > 0 {
-		for _, scheduler := range schedulers {
-			go func(s *aggregation.Scheduler) {
-				if err := s.Start(ctx); err != nil {
-					slog.Error("Scheduler stopped with error", "error", err)
+	if schedulerGroup != nil {
+		go func() {
+			if err := schedulerGroup.Start(ctx); err != nil {
+				slog.Error("Scheduler group stopped with error", "error", err)
+			}
+		}()
+		go func() {
+			if err := ruleRebuilder.Start(ctx); err != nil {
+				slog.Error("Rule rebuilder stopped with error", "error", err)
+			}
+		}()
+	} else {
+		slog.Info("Aggregation scheduler disabled by config")
+	}
+
+	if thresholdSvc != nil && schedulerGroup != nil {
+		go func() {
+			if err := thresholdSvc.Start(ctx); err != nil {
+				slog.Error("Threshold evaluation stopped with error", "error", err)
+			}
+		}()
+	}
+
+	if webhookDispatcher != nil {
+		go func() {
+			if err := webhookDispatcher.Start(ctx); err != nil {
+				slog.Error("Webhook dispatcher stopped with error", "error", err)
+			}
+		}()
+	}
+
+	if partitionMaintainer != nil {
+		go func() {
+			if err := partitionMaintainer.Start(ctx); err != nil {
+				slog.Error("Event partition maintenance stopped with error", "error", err)
+			}
+		}()
+	}
+
+	// Watch the rule directory for changes if enabled.
+	if cfg.Aggregation.WatchRules {
+		if _, err := os.Stat(cfg.RuleLoading.ConfigDir); err != nil {
+			slog.Warn("Rule directory not accessible; file watching disabled", "dir", cfg.RuleLoading.ConfigDir, "error", err)
+		} else {
+			go func() {
+				if err := ruleReloader.Watch(ctx); err != nil {
+					slog.Error("Rule watcher stopped with error", "error", err)
 				}
-			}(scheduler)
+			}()
 		}
-	} else if !cfg.Aggregation.Enabled {
-		slog.Info("Aggregation scheduler disabled by config")
-	} else {
-		slog.Info("Aggregation scheduler not started because no rules were loaded")
 	}
 
+	// SIGHUP → reload aggregation rules.
+	go func() {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		for {
+			select {
+			case <-hup:
+				slog.Info("SIGHUP received, reloading aggregation rules...")
+				_, _ = ruleReloader.Reload()
+			case <-ctx.Done():
+				signal.Stop(hup)
+				return
+			}
+		}
+	}()
+
 	// Signal handler → triggers the shutdown sequence below.
 	go func() {
 		quit := make(chan os.Signal, 1)
@@ -0,0 +1,38 @@
+#!/bin/sh
+# Starts the aevon image with database.type sqlite and checks that it opens, migrates
+# and reads its database file. Usage: deploy/smoke-sqlite.sh <image>
+set -eu
+
+IMAGE="${1:?usage: $0 <image>}"
+NAME="aevon-smoke-sqlite-$$"
+PORT="${SMOKE_PORT:-18080}"
+ROOT="$(cd "$(dirname "$0")/.." && pwd)"
+
+cleanup() {
+	docker logs "$NAME" 2>&1 | tail -n 50 || true
+	docker rm -f "$NAME" > /dev/null 2>&1 || true
+}
+trap cleanup EXIT
+
+docker run -d --name "$NAME" -p "$PORT:8080" \
+	-e AEVON_DATABASE__TYPE=sqlite \
+	-e AEVON_DATABASE__DSN=/tmp/aevon.db \
+	-v "$ROOT/aevon.yaml:/app/config/aevon.yaml:ro" \
+	-v "$ROOT/config/aggregations:/app/config/aggregations:ro" \
+	-v "$ROOT/schemas:/app/schemas:ro" \
+	"$IMAGE" > /dev/null
+
+for _ in $(seq 1 30); do
+	if curl -fsS "http://localhost:$PORT/health" > /dev/null 2>&1; then
+		curl -fsS "http://localhost:$PORT/v1/events/user:smoke?start=2026-01-01T00:00:00Z&end=2026-01-02T00:00:00Z" > /dev/null
+		echo "SQLite smoke test passed"
+		exit 0
+	fi
+	if [ "$(docker inspect -f '{{.State.Running}}' "$NAME")" != "true" ]; then
+		echo "aevon exited before it was healthy" >&2
+		exit 1
+	fi
+	sleep 1
+done
+echo "aevon did not become healthy" >&2
+exit 1
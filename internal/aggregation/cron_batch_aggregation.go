@@ -11,7 +11,6 @@ import (
 	"github.com/aevon-lab/project-aevon/internal/core/aggregation"
 	"github.com/aevon-lab/project-aevon/internal/core/partition"
 	"github.com/aevon-lab/project-aevon/internal/core/storage"
-	"github.com/shopspring/decimal"
 )
 
 const (
@@ -25,6 +24,7 @@ type BatchJobParameter struct {
 	WorkerCount int
 	BucketSize  time.Duration
 	BucketLabel string
+	Partitions  partition.Range // partitions aggregated by the job; zero value means all
 }
 
 // DefaultBatchJobOptions returns safe defaults for cron-based processing.
@@ -49,11 +49,23 @@ func (o BatchJobParameter) normalized() BatchJobParameter {
 		n.BucketSize = time.Minute
 	}
 	if n.BucketLabel == "" {
-		n.BucketLabel = windowSizeLabel(n.BucketSize)
+		n.BucketLabel = aggregation.BucketLabel(n.BucketSize)
+	}
+	if n.Partitions.IsZero() {
+		n.Partitions = partition.Full()
 	}
 	return n
 }
 
+// streamLabel names the event stream drained with these parameters: the bucket label,
+// plus the partition range if the bucket size is sharded (e.g. "1m/p0-128").
+func (o BatchJobParameter) streamLabel() string {
+	if o.Partitions.IsZero() || o.Partitions.IsFull() {
+		return o.BucketLabel
+	}
+	return o.BucketLabel + "/" + o.Partitions.String()
+}
+
 // RunBatchAggregation processes events since last checkpoint and updates aggregates.
 // Uses default options: 50K batch size, 10 workers, 1-minute buckets.
 func RunBatchAggregation(
@@ -66,7 +78,7 @@ func RunBatchAggregation(
 }
 
 // RunBatchAggregationWithOptions processes events since last checkpoint with configurable
-// batch size, worker count and bucket duration.
+// batch size, worker count, bucket duration and partition range.
 func RunBatchAggregationWithOptions(
 	ctx context.Context,
 	eventStore storage.EventStore,
@@ -74,101 +86,139 @@ func RunBatchAggregationWithOptions(
 	rules []aggregation.AggregationRule,
 	jobParameter BatchJobParameter,
 ) error {
-	jobParameter = jobParameter.normalized()
+	_, err := RunBatchAggregationWithOptionsReturningCount(ctx, eventStore, preAggStore, rules, jobParameter)
+	return err
+}
 
-	cursor, err := preAggStore.ReadCheckpoint(ctx, jobParameter.BucketLabel)
-	if err != nil {
-		return fmt.Errorf("read checkpoint: %w", err)
+// RunBatchAggregationWithOptionsReturningCount is like RunBatchAggregationWithOptions
+// but returns the number of events processed. This is used by the scheduler to
+// determine if there's more backlog to drain.
+func RunBatchAggregationWithOptionsReturningCount(
+	ctx context.Context,
+	eventStore storage.EventStore,
+	preAggregateStore PreAggregateStore,
+	rules []aggregation.AggregationRule,
+	opts BatchJobParameter,
+) (int, error) {
+	processed, _, err := runBatch(ctx, eventStore, preAggregateStore, rules, opts)
+	return processed, err
+}
+
+// runBatch aggregates one batch and returns the number of events read together with
+// the aggregates it flushed.
+func runBatch(
+	ctx context.Context,
+	eventStore storage.EventStore,
+	preAggregateStore PreAggregateStore,
+	rules []aggregation.AggregationRule,
+	opts BatchJobParameter,
+) (int, map[aggregation.AggregateKey]aggregation.AggregateState, error) {
+	opts = opts.normalized()
+
+	if partitioned, ok := preAggregateStore.(PartitionedPreAggregateStore); ok {
+		return runPartitionBatch(ctx, eventStore, partitioned, rules, opts)
+	}
+	if !opts.Partitions.IsFull() {
+		return 0, nil, fmt.Errorf("pre-aggregate store has no partition checkpoints; cannot aggregate partitions %s", opts.Partitions)
 	}
 
-	slog.Info("[BatchJob] Starting batch aggregation",
-		"cursor", cursor,
-		"bucket_size", jobParameter.BucketLabel,
-		"batch_size", jobParameter.BatchSize,
-		"workers", jobParameter.WorkerCount,
-	)
+	cursor, err := preAggregateStore.ReadCheckpoint(ctx, opts.BucketLabel)
+	if err != nil {
+		return 0, nil, fmt.Errorf("read checkpoint: %w", err)
+	}
 
-	events, err := eventStore.RetrieveEventsAfterCursor(ctx, cursor, jobParameter.BatchSize)
+	events, err := eventStore.RetrieveEventsAfterCursor(ctx, cursor, opts.BatchSize)
 	if err != nil {
-		return fmt.Errorf("query events: %w", err)
+		return 0, nil, fmt.Errorf("query events: %w", err)
 	}
 
 	if len(events) == 0 {
-		slog.Debug("[BatchJob] No new events to process", "bucket_size", jobParameter.BucketLabel)
-		return nil
+		return 0, nil, nil
 	}
 
-	slog.Info("[BatchJob] Processing events",
-		"count", len(events),
-		"from_cursor", cursor,
-		"bucket_size", jobParameter.BucketLabel,
-	)
-
-	ruleMap := toCompiledRuleMap(rules)
-	aggregates := buildPreAggregatesConcurrently(events, ruleMap, jobParameter)
-
-	slog.Info("[BatchJob] Computed aggregates",
-		"aggregate_count", len(aggregates),
-		"bucket_size", jobParameter.BucketLabel,
-	)
+	ruleMap := toCompiledRuleMap(rules, opts.BucketSize)
+	aggregates := buildPreAggregatesConcurrently(events, ruleMap, opts)
 
 	newCursor := events[len(events)-1].IngestSeq
-	if err := preAggStore.Flush(ctx, aggregates, newCursor, jobParameter.BucketLabel); err != nil {
-		return fmt.Errorf("flush aggregates: %w", err)
+	if err := preAggregateStore.Flush(ctx, aggregates, newCursor, opts.BucketLabel); err != nil {
+		return 0, nil, fmt.Errorf("flush aggregates: %w", err)
 	}
 
 	slog.Info("[BatchJob] Batch complete",
 		"events_processed", len(events),
 		"aggregates_computed", len(aggregates),
 		"cursor_advanced", fmt.Sprintf("%d -> %d", cursor, newCursor),
-		"bucket_size", jobParameter.BucketLabel,
+		"bucket_size", opts.BucketLabel,
 	)
 
-	return nil
+	return len(events), aggregates, nil
 }
 
-// RunBatchAggregationWithOptionsReturningCount is like RunBatchAggregationWithOptions
-// but returns the number of events processed. This is used by the scheduler to
-// determine if there's more backlog to drain.
-func RunBatchAggregationWithOptionsReturningCount(
+// runPartitionBatch aggregates one batch of the partition range opts.Partitions. The range
+// is read from its lowest partition checkpoint; events of partitions that are further
+// ahead (after the ranges were re-split) are skipped up to their own checkpoint.
+func runPartitionBatch(
 	ctx context.Context,
 	eventStore storage.EventStore,
-	preAggregateStore PreAggregateStore,
+	preAggregateStore PartitionedPreAggregateStore,
 	rules []aggregation.AggregationRule,
 	opts BatchJobParameter,
-) (int, error) {
-	opts = opts.normalized()
-
-	cursor, err := preAggregateStore.ReadCheckpoint(ctx, opts.BucketLabel)
+) (int, map[aggregation.AggregateKey]aggregation.AggregateState, error) {
+	from, err := preAggregateStore.ReadPartitionCheckpoints(ctx, opts.BucketLabel, opts.Partitions)
 	if err != nil {
-		return 0, fmt.Errorf("read checkpoint: %w", err)
+		return 0, nil, fmt.Errorf("read checkpoint: %w", err)
 	}
+	cursor := from.Min()
 
-	events, err := eventStore.RetrieveEventsAfterCursor(ctx, cursor, opts.BatchSize)
+	events, err := retrievePartitionEvents(ctx, eventStore, cursor, opts.Partitions, opts.BatchSize)
 	if err != nil {
-		return 0, fmt.Errorf("query events: %w", err)
+		return 0, nil, fmt.Errorf("query events: %w", err)
 	}
 
 	if len(events) == 0 {
-		return 0, nil
+		return 0, nil, nil
 	}
 
-	ruleMap := toCompiledRuleMap(rules)
-	aggregates := buildPreAggregatesConcurrently(events, ruleMap, opts)
+	pending := make([]*v1.Event, 0, len(events))
+	for _, evt := range events {
+		partitionID := partition.For(evt.PrincipalID)
+		if opts.Partitions.Contains(partitionID) && evt.IngestSeq > from.Cursor(partitionID) {
+			pending = append(pending, evt)
+		}
+	}
+
+	ruleMap := toCompiledRuleMap(rules, opts.BucketSize)
+	aggregates := buildPreAggregatesConcurrently(pending, ruleMap, opts)
 
 	newCursor := events[len(events)-1].IngestSeq
-	if err := preAggregateStore.Flush(ctx, aggregates, newCursor, opts.BucketLabel); err != nil {
-		return 0, fmt.Errorf("flush aggregates: %w", err)
+	if err := preAggregateStore.FlushPartitions(ctx, aggregates, opts.BucketLabel, from, newCursor); err != nil {
+		return 0, nil, fmt.Errorf("flush aggregates: %w", err)
 	}
 
 	slog.Info("[BatchJob] Batch complete",
-		"events_processed", len(events),
+		"events_processed", len(pending),
 		"aggregates_computed", len(aggregates),
 		"cursor_advanced", fmt.Sprintf("%d -> %d", cursor, newCursor),
 		"bucket_size", opts.BucketLabel,
+		"partitions", opts.Partitions.String(),
 	)
 
-	return len(events), nil
+	return len(events), aggregates, nil
+}
+
+// retrievePartitionEvents reads the events after cursor, restricted to partitions if the
+// event store can filter by partition. Callers still filter the result.
+func retrievePartitionEvents(
+	ctx context.Context,
+	eventStore storage.EventStore,
+	cursor int64,
+	partitions partition.Range,
+	limit int,
+) ([]*v1.Event, error) {
+	if reader, ok := eventStore.(storage.PartitionedEventReader); ok && !partitions.IsFull() {
+		return reader.RetrievePartitionEventsAfterCursor(ctx, cursor, partitions, limit)
+	}
+	return eventStore.RetrieveEventsAfterCursor(ctx, cursor, limit)
 }
 
 type eventGroupKey struct {
@@ -180,9 +230,13 @@ type compiledRule struct {
 	agg  aggregation.Aggregator
 }
 
-func toCompiledRuleMap(rules []aggregation.AggregationRule) map[string][]compiledRule {
+// toCompiledRuleMap indexes the rules pre-aggregated at bucketSize by source event.
+func toCompiledRuleMap(rules []aggregation.AggregationRule, bucketSize time.Duration) map[string][]compiledRule {
 	ruleMap := make(map[string][]compiledRule)
 	for _, r := range rules {
+		if !r.HasBucket(bucketSize) {
+			continue
+		}
 		agg, ok := aggregation.Operators[r.Operator]
 		if !ok {
 			slog.Warn("[BatchJob] Skip rule with unknown operator", "rule", r.Name, "operator", r.Operator)
@@ -222,6 +276,7 @@ func buildPreAggregatesConcurrently(
 			for groupEvents := range jobs {
 				mergeGroupAggregates(local, groupEvents, ruleMap, jobParameter, now)
 			}
+			finalizeAggregates(local)
 			results <- local
 		}()
 	}
@@ -238,7 +293,7 @@ func buildPreAggregatesConcurrently(
 	for local := range results {
 		for key, state := range local {
 			if existing, ok := merged[key]; ok {
-				existing.Value = mergeValueByOperator(existing.Operator, existing.Value, state.Value)
+				existing = mergeValueByOperator(existing, state)
 				existing.EventCount += state.EventCount
 				existing.LastEventID = state.LastEventID
 				existing.RuleFingerprint = state.RuleFingerprint
@@ -267,30 +322,42 @@ func mergeGroupAggregates(
 		}
 
 		for _, cr := range rulesForEvent {
-			windowStart := aggregation.BucketFor(evt.OccurredAt, opts.BucketSize)
+			if !cr.rule.Matches(evt.Data, evt.Metadata) {
+				continue
+			}
+			eventTime, ok := cr.rule.EventTime(evt.OccurredAt, evt.IngestedAt)
+			if !ok {
+				continue
+			}
+			windowStart := aggregation.BucketFor(eventTime, opts.BucketSize)
 			key := aggregation.AggregateKey{
 				PartitionID: partition.For(evt.PrincipalID),
 				PrincipalID: evt.PrincipalID,
 				RuleName:    cr.rule.Name,
 				BucketSize:  opts.BucketLabel,
 				WindowStart: windowStart,
+				Dimensions:  cr.rule.DimensionsFor(evt.Data, evt.Metadata),
 			}
 
-			incoming := aggregation.ExtractDecimal(evt.Data, cr.rule.Field)
+			obs := aggregation.Observation{
+				Value:      aggregation.ExtractDecimal(evt.Data, cr.rule.Field),
+				OccurredAt: evt.OccurredAt,
+				Key:        aggregation.ExtractKey(evt.Data, cr.rule.Field),
+			}
 			state, exists := target[key]
 			if !exists {
-				target[key] = aggregation.AggregateState{
-					Operator:        cr.rule.Operator,
-					Value:           cr.agg.Initial(incoming),
-					EventCount:      1,
-					LastEventID:     evt.ID,
-					RuleFingerprint: cr.rule.Fingerprint,
-					UpdatedAt:       now,
-				}
+				state = cr.agg.Initial(obs)
+				state.Operator = cr.rule.Operator
+				state.EventCount = 1
+				state.LastEventID = evt.ID
+				state.RuleFingerprint = cr.rule.Fingerprint
+				state.Dimensions = key.Dimensions
+				state.UpdatedAt = now
+				target[key] = state
 				continue
 			}
 
-			state.Value = cr.agg.Apply(state.Value, incoming)
+			state = cr.agg.Apply(state, obs)
 			state.EventCount++
 			state.LastEventID = evt.ID
 			state.UpdatedAt = now
@@ -299,23 +366,23 @@ func mergeGroupAggregates(
 	}
 }
 
-func mergeValueByOperator(operator string, current, incoming decimal.Decimal) decimal.Decimal {
-	switch operator {
-	case aggregation.OpCount, aggregation.OpSum:
-		return current.Add(incoming)
-	case aggregation.OpMin:
-		if incoming.LessThan(current) {
-			return incoming
-		}
-		return current
-	case aggregation.OpMax:
-		if incoming.GreaterThan(current) {
-			return incoming
-		}
+// finalizeAggregates derives the values that Apply left stale, once events are folded.
+func finalizeAggregates(aggregates map[aggregation.AggregateKey]aggregation.AggregateState) {
+	for key, state := range aggregates {
+		aggregates[key] = aggregation.Finalize(state)
+	}
+}
+
+// mergeValueByOperator merges the value fields of two partial states for the same key
+// using the operator's registered Merge semantics. Both sides still carry their own
+// EventCount; callers add counts after merging.
+func mergeValueByOperator(current, incoming aggregation.AggregateState) aggregation.AggregateState {
+	agg, ok := aggregation.Operators[current.Operator]
+	if !ok {
+		current.Value = incoming.Value
 		return current
-	default:
-		return incoming
 	}
+	return agg.Merge(current, incoming)
 }
 
 func maxTime(a, b time.Time) time.Time {
@@ -331,19 +398,3 @@ func minInt(a, b int) int {
 	}
 	return b
 }
-
-func windowSizeLabel(d time.Duration) string {
-	if d%(24*time.Hour) == 0 {
-		return fmt.Sprintf("%dd", d/(24*time.Hour))
-	}
-	if d%time.Hour == 0 {
-		return fmt.Sprintf("%dh", d/time.Hour)
-	}
-	if d%time.Minute == 0 {
-		return fmt.Sprintf("%dm", d/time.Minute)
-	}
-	if d%time.Second == 0 {
-		return fmt.Sprintf("%ds", d/time.Second)
-	}
-	return d.String()
-}
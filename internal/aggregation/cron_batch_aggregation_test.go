@@ -2,11 +2,13 @@ package aggregation
 
 import (
 	"context"
+	"fmt"
 	"testing"
 	"time"
 
 	v1 "github.com/aevon-lab/project-aevon/internal/api/v1"
 	"github.com/aevon-lab/project-aevon/internal/core/aggregation"
+	"github.com/aevon-lab/project-aevon/internal/core/partition"
 	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -22,6 +24,11 @@ func (m *mockEventStore) SaveEvent(ctx context.Context, event *v1.Event) error {
 	return nil
 }
 
+func (m *mockEventStore) SaveEvents(ctx context.Context, events []*v1.Event) ([]error, error) {
+	m.events = append(m.events, events...)
+	return make([]error, len(events)), nil
+}
+
 func (m *mockEventStore) RetrieveEventsAfter(ctx context.Context, afterTime time.Time, limit int) ([]*v1.Event, error) {
 	return nil, nil // Not used in batch job
 }
@@ -56,6 +63,7 @@ func (m *mockEventStore) RetrieveScopedEventsAfterCursor(
 	eventType string,
 	startOccurredAt time.Time,
 	endOccurredAt time.Time,
+	includeIngested bool,
 	limit int,
 ) ([]*v1.Event, error) {
 	return nil, nil
@@ -105,6 +113,38 @@ func (m *mockPreAggStore) QueryRange(ctx context.Context, principalID string, ru
 	return results, nil
 }
 
+// mockPartitionedPreAggStore keeps one checkpoint per partition of the 1m bucket.
+type mockPartitionedPreAggStore struct {
+	mockPreAggStore
+	partitionCheckpoints [partition.Count]int64
+}
+
+func (m *mockPartitionedPreAggStore) ReadPartitionCheckpoints(
+	ctx context.Context,
+	bucketSize string,
+	partitions partition.Range,
+) (aggregation.PartitionCheckpoints, error) {
+	cursors := make([]int64, partitions.Len())
+	copy(cursors, m.partitionCheckpoints[partitions.From:partitions.To])
+	return aggregation.PartitionCheckpoints{Range: partitions, Cursors: cursors}, nil
+}
+
+func (m *mockPartitionedPreAggStore) FlushPartitions(
+	ctx context.Context,
+	aggregates map[aggregation.AggregateKey]aggregation.AggregateState,
+	bucketSize string,
+	from aggregation.PartitionCheckpoints,
+	cursor int64,
+) error {
+	for k, v := range aggregates {
+		m.aggregates[k] = v
+	}
+	for p := from.Range.From; p < from.Range.To; p++ {
+		m.partitionCheckpoints[p] = cursor
+	}
+	return nil
+}
+
 func TestBatchJob_NoEvents(t *testing.T) {
 	ctx := context.Background()
 	eventStore := &mockEventStore{}
@@ -252,6 +292,141 @@ func TestBatchJob_SumAggregation(t *testing.T) {
 	assert.Equal(t, int64(2), state.EventCount)
 }
 
+func TestBatchJob_CountDistinctAggregation(t *testing.T) {
+	ctx := context.Background()
+
+	now := time.Now().UTC().Truncate(time.Minute)
+	var events []*v1.Event
+	for i, model := range []string{"gpt", "claude", "gpt", "llama"} {
+		events = append(events, &v1.Event{
+			ID:          fmt.Sprintf("evt-%d", i+1),
+			PrincipalID: "user:alice",
+			Type:        "api.request",
+			OccurredAt:  now,
+			IngestSeq:   int64(i + 1),
+			Data:        map[string]interface{}{"model": model},
+		})
+	}
+
+	eventStore := &mockEventStore{events: events}
+	preAggStore := &mockPreAggStore{
+		checkpoints: map[string]int64{"1m": 0},
+		aggregates:  make(map[aggregation.AggregateKey]aggregation.AggregateState),
+	}
+	rules := []aggregation.AggregationRule{
+		{
+			Name:        "distinct_models",
+			SourceEvent: "api.request",
+			Operator:    aggregation.OpCountDistinct,
+			Field:       "model",
+			WindowSize:  time.Minute,
+			Fingerprint: "fp1",
+		},
+	}
+
+	require.NoError(t, RunBatchAggregation(ctx, eventStore, preAggStore, rules))
+
+	require.Len(t, preAggStore.aggregates, 1)
+	for _, state := range preAggStore.aggregates {
+		// The estimate is derived once the batch is folded, not per event.
+		assert.Equal(t, "3", state.Value.String())
+		assert.Equal(t, int64(4), state.EventCount)
+	}
+}
+
+func TestBatchJob_FilterSkipsNonMatchingEvents(t *testing.T) {
+	ctx := context.Background()
+
+	now := time.Now().UTC().Truncate(time.Minute)
+	events := []*v1.Event{
+		{ID: "evt-1", PrincipalID: "user:alice", Type: "api.request", OccurredAt: now, IngestSeq: 1,
+			Data: map[string]interface{}{"status": 200.0}, Metadata: map[string]string{"region": "eu"}},
+		{ID: "evt-2", PrincipalID: "user:alice", Type: "api.request", OccurredAt: now, IngestSeq: 2,
+			Data: map[string]interface{}{"status": 503.0}, Metadata: map[string]string{"region": "eu"}},
+		{ID: "evt-3", PrincipalID: "user:alice", Type: "api.request", OccurredAt: now, IngestSeq: 3,
+			Data: map[string]interface{}{"status": 500.0}, Metadata: map[string]string{"region": "us"}},
+	}
+
+	eventStore := &mockEventStore{events: events}
+	preAggStore := &mockPreAggStore{
+		checkpoints: map[string]int64{"1m": 0},
+		aggregates:  make(map[aggregation.AggregateKey]aggregation.AggregateState),
+	}
+
+	filter, err := aggregation.CompileFilter(`data.status >= 500 && metadata.region == "eu"`)
+	require.NoError(t, err)
+
+	rules := []aggregation.AggregationRule{
+		{
+			Name:        "count_eu_errors",
+			SourceEvent: "api.request",
+			Operator:    aggregation.OpCount,
+			WindowSize:  time.Minute,
+			Filter:      filter,
+			Fingerprint: "fp1",
+		},
+	}
+
+	err = RunBatchAggregation(ctx, eventStore, preAggStore, rules)
+	require.NoError(t, err)
+
+	// Checkpoint covers filtered-out events too.
+	assert.Equal(t, int64(3), preAggStore.checkpoints["1m"])
+	require.Len(t, preAggStore.aggregates, 1)
+	for _, state := range preAggStore.aggregates {
+		assert.Equal(t, "1", state.Value.String())
+		assert.Equal(t, "evt-2", state.LastEventID)
+	}
+}
+
+func TestBatchJob_GroupBySeparatesDimensions(t *testing.T) {
+	ctx := context.Background()
+
+	now := time.Now().UTC().Truncate(time.Minute)
+	events := []*v1.Event{
+		{ID: "evt-1", PrincipalID: "user:alice", Type: "llm.completion", OccurredAt: now, IngestSeq: 1,
+			Data: map[string]interface{}{"model": "gpt-4", "tokens": 10.0}},
+		{ID: "evt-2", PrincipalID: "user:alice", Type: "llm.completion", OccurredAt: now, IngestSeq: 2,
+			Data: map[string]interface{}{"model": "claude", "tokens": 5.0}},
+		{ID: "evt-3", PrincipalID: "user:alice", Type: "llm.completion", OccurredAt: now, IngestSeq: 3,
+			Data: map[string]interface{}{"model": "gpt-4", "tokens": 7.0}},
+		{ID: "evt-4", PrincipalID: "user:alice", Type: "llm.completion", OccurredAt: now, IngestSeq: 4,
+			Data: map[string]interface{}{"tokens": 1.0}},
+	}
+
+	eventStore := &mockEventStore{events: events}
+	preAggStore := &mockPreAggStore{
+		checkpoints: map[string]int64{"1m": 0},
+		aggregates:  make(map[aggregation.AggregateKey]aggregation.AggregateState),
+	}
+
+	rules := []aggregation.AggregationRule{
+		{
+			Name:        "tokens_by_model",
+			SourceEvent: "llm.completion",
+			Operator:    aggregation.OpSum,
+			Field:       "tokens",
+			WindowSize:  time.Minute,
+			GroupBy:     []string{"data.model"},
+			Fingerprint: "fp1",
+		},
+	}
+
+	err := RunBatchAggregation(ctx, eventStore, preAggStore, rules)
+	require.NoError(t, err)
+
+	got := make(map[string]string)
+	for key, state := range preAggStore.aggregates {
+		assert.Equal(t, key.Dimensions, state.Dimensions)
+		got[key.Dimensions] = state.Value.String()
+	}
+	assert.Equal(t, map[string]string{
+		`{"data.model":"gpt-4"}`:  "17",
+		`{"data.model":"claude"}`: "5",
+		`{"data.model":null}`:     "1",
+	}, got)
+}
+
 func TestBatchJob_MultipleWindows(t *testing.T) {
 	ctx := context.Background()
 
@@ -392,6 +567,7 @@ func TestBatchJob_BucketScopedCheckpoint(t *testing.T) {
 			SourceEvent: "api.request",
 			Operator:    aggregation.OpCount,
 			WindowSize:  time.Minute,
+			BucketSizes: []time.Duration{time.Minute, 10 * time.Minute},
 			Fingerprint: "fp1",
 		},
 	}
@@ -426,3 +602,220 @@ func TestBatchJob_BucketScopedCheckpoint(t *testing.T) {
 	require.True(t, has1m)
 	require.True(t, has10m)
 }
+
+func TestBatchJob_PartitionShardsAggregateDisjointPrincipals(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Minute)
+	shards := partition.Split(2)
+
+	// Find one principal per shard.
+	principals := make([]string, len(shards))
+	for i := 0; principals[0] == "" || principals[1] == ""; i++ {
+		principal := fmt.Sprintf("user:%d", i)
+		for s, shard := range shards {
+			if shard.Contains(partition.For(principal)) && principals[s] == "" {
+				principals[s] = principal
+			}
+		}
+	}
+
+	var events []*v1.Event
+	for seq := int64(1); seq <= 4; seq++ {
+		events = append(events, &v1.Event{
+			ID:          fmt.Sprintf("evt-%d", seq),
+			PrincipalID: principals[seq%2],
+			Type:        "api.request",
+			OccurredAt:  now,
+			IngestSeq:   seq,
+			Data:        map[string]interface{}{},
+		})
+	}
+
+	eventStore := &mockEventStore{events: events}
+	preAggStore := &mockPartitionedPreAggStore{mockPreAggStore: mockPreAggStore{
+		checkpoints: map[string]int64{},
+		aggregates:  make(map[aggregation.AggregateKey]aggregation.AggregateState),
+	}}
+	rules := []aggregation.AggregationRule{
+		{Name: "count_requests", SourceEvent: "api.request", Operator: aggregation.OpCount, WindowSize: time.Minute},
+	}
+
+	// Only the first shard has run: the second shard's principal is not aggregated yet.
+	params := BatchJobParameter{BatchSize: 50000, WorkerCount: 2, BucketSize: time.Minute, Partitions: shards[0]}
+	_, err := RunBatchAggregationWithOptionsReturningCount(ctx, eventStore, preAggStore, rules, params)
+	require.NoError(t, err)
+	require.Len(t, preAggStore.aggregates, 1)
+	require.Equal(t, int64(4), preAggStore.partitionCheckpoints[shards[0].From])
+	require.Zero(t, preAggStore.partitionCheckpoints[shards[1].From])
+
+	params.Partitions = shards[1]
+	_, err = RunBatchAggregationWithOptionsReturningCount(ctx, eventStore, preAggStore, rules, params)
+	require.NoError(t, err)
+	require.Len(t, preAggStore.aggregates, 2)
+	for key, state := range preAggStore.aggregates {
+		require.Equal(t, partition.For(key.PrincipalID), key.PartitionID)
+		require.Equal(t, int64(2), state.EventCount)
+	}
+	require.Empty(t, preAggStore.checkpoints, "partitioned stores never use the bucket-wide checkpoint")
+}
+
+func TestBatchJob_LatePolicy(t *testing.T) {
+	ctx := context.Background()
+	ingestedAt := time.Date(2026, 3, 10, 12, 0, 30, 0, time.UTC)
+	occurredAt := ingestedAt.Add(-21 * 24 * time.Hour)
+
+	for _, tc := range []struct {
+		policy     string
+		wantWindow time.Time // zero if the event is dropped
+	}{
+		{policy: aggregation.LatePolicyAccept, wantWindow: occurredAt.Truncate(time.Minute)},
+		{policy: aggregation.LatePolicyReject},
+		{policy: aggregation.LatePolicyCorrection, wantWindow: ingestedAt.Truncate(time.Minute)},
+	} {
+		t.Run(tc.policy, func(t *testing.T) {
+			eventStore := &mockEventStore{events: []*v1.Event{{
+				ID:          "evt-late",
+				PrincipalID: "user:alice",
+				Type:        "api.request",
+				OccurredAt:  occurredAt,
+				IngestedAt:  ingestedAt,
+				IngestSeq:   1,
+				Data:        map[string]interface{}{},
+			}}}
+			preAggStore := &mockPreAggStore{
+				checkpoints: map[string]int64{},
+				aggregates:  make(map[aggregation.AggregateKey]aggregation.AggregateState),
+			}
+			rules := []aggregation.AggregationRule{{
+				Name:            "count_requests",
+				SourceEvent:     "api.request",
+				Operator:        aggregation.OpCount,
+				WindowSize:      time.Minute,
+				AllowedLateness: 72 * time.Hour,
+				LatePolicy:      tc.policy,
+			}}
+
+			require.NoError(t, RunBatchAggregation(ctx, eventStore, preAggStore, rules))
+			require.Equal(t, int64(1), preAggStore.checkpoints["1m"])
+
+			if tc.wantWindow.IsZero() {
+				require.Empty(t, preAggStore.aggregates)
+				return
+			}
+			require.Len(t, preAggStore.aggregates, 1)
+			for key := range preAggStore.aggregates {
+				require.Equal(t, tc.wantWindow, key.WindowStart)
+			}
+		})
+	}
+}
+
+func TestBatchJob_SkipsRulesWithoutBucket(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Hour)
+
+	eventStore := &mockEventStore{events: []*v1.Event{{
+		ID:          "evt-1",
+		PrincipalID: "user:alice",
+		Type:        "api.request",
+		OccurredAt:  now.Add(5 * time.Minute),
+		IngestSeq:   1,
+		Data:        map[string]interface{}{},
+	}}}
+	preAggStore := &mockPreAggStore{
+		checkpoints: map[string]int64{},
+		aggregates:  make(map[aggregation.AggregateKey]aggregation.AggregateState),
+	}
+	rules := []aggregation.AggregationRule{
+		{Name: "per_minute", SourceEvent: "api.request", Operator: aggregation.OpCount, WindowSize: time.Minute},
+		{Name: "per_hour", SourceEvent: "api.request", Operator: aggregation.OpCount, WindowSize: time.Hour, BucketSizes: []time.Duration{time.Hour}},
+	}
+
+	err := RunBatchAggregationWithOptions(ctx, eventStore, preAggStore, rules, BatchJobParameter{
+		BatchSize:   50000,
+		WorkerCount: 10,
+		BucketSize:  time.Hour,
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(1), preAggStore.checkpoints["1h"])
+	require.Len(t, preAggStore.aggregates, 1)
+	for key := range preAggStore.aggregates {
+		assert.Equal(t, "per_hour", key.RuleName)
+		assert.Equal(t, "1h", key.BucketSize)
+		assert.Equal(t, now, key.WindowStart)
+	}
+}
+
+func TestNewSchedulers_OnePerBucketSize(t *testing.T) {
+	rules := []aggregation.AggregationRule{
+		{Name: "default", SourceEvent: "api.request", Operator: aggregation.OpCount},
+		{Name: "multi", SourceEvent: "api.request", Operator: aggregation.OpCount, WindowSize: time.Minute,
+			BucketSizes: []time.Duration{time.Minute, time.Hour, 24 * time.Hour}},
+		{Name: "daily", SourceEvent: "api.request", Operator: aggregation.OpCount, WindowSize: 24 * time.Hour,
+			BucketSizes: []time.Duration{24 * time.Hour}},
+	}
+
+	require.Equal(t, []time.Duration{time.Minute, time.Hour, 24 * time.Hour}, BucketSizes(rules))
+
+	schedulers := NewSchedulers(time.Minute, &mockEventStore{}, &mockPreAggStore{}, rules, BatchJobParameter{BatchSize: 10})
+	require.Len(t, schedulers, 3)
+
+	want := []struct {
+		label string
+		rules []string
+	}{
+		{label: "1m", rules: []string{"default", "multi"}},
+		{label: "1h", rules: []string{"multi"}},
+		{label: "1d", rules: []string{"multi", "daily"}},
+	}
+	for i, s := range schedulers {
+		assert.Equal(t, want[i].label, s.opts.BucketLabel)
+		assert.Equal(t, 10, s.opts.BatchSize)
+		names := make([]string, 0, len(s.rules))
+		for _, r := range s.rules {
+			names = append(names, r.Name)
+		}
+		assert.Equal(t, want[i].rules, names)
+	}
+}
+
+type recordingFlushObserver struct {
+	bucketLabels []string
+	scopes       [][]aggregation.RangeScope
+}
+
+func (o *recordingFlushObserver) AfterFlush(_ context.Context, bucketLabel string, scopes []aggregation.RangeScope) {
+	o.bucketLabels = append(o.bucketLabels, bucketLabel)
+	o.scopes = append(o.scopes, scopes)
+}
+
+func TestScheduler_NotifiesFlushObserver(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Minute)
+	eventStore := &mockEventStore{events: []*v1.Event{
+		{ID: "evt-1", PrincipalID: "user:bob", Type: "api.request", OccurredAt: now, IngestSeq: 1, Data: map[string]interface{}{}},
+		{ID: "evt-2", PrincipalID: "user:alice", Type: "api.request", OccurredAt: now, IngestSeq: 2, Data: map[string]interface{}{}},
+		{ID: "evt-3", PrincipalID: "user:alice", Type: "api.request", OccurredAt: now.Add(time.Minute), IngestSeq: 3, Data: map[string]interface{}{}},
+	}}
+	preAggStore := &mockPreAggStore{
+		checkpoints: map[string]int64{},
+		aggregates:  make(map[aggregation.AggregateKey]aggregation.AggregateState),
+	}
+	rules := []aggregation.AggregationRule{
+		{Name: "count_requests", SourceEvent: "api.request", Operator: aggregation.OpCount, WindowSize: time.Minute},
+	}
+
+	observer := &recordingFlushObserver{}
+	scheduler := NewScheduler(time.Minute, eventStore, preAggStore, rules, bucketParameter(BatchJobParameter{BatchSize: 10}, time.Minute))
+	scheduler.observers = []FlushObserver{observer}
+
+	scheduler.drainBacklog(context.Background())
+	require.Equal(t, []string{"1m"}, observer.bucketLabels)
+	require.Equal(t, []aggregation.RangeScope{
+		{PrincipalID: "user:alice", RuleName: "count_requests"},
+		{PrincipalID: "user:bob", RuleName: "count_requests"},
+	}, observer.scopes[0])
+
+	// Nothing new to flush, nothing to observe.
+	scheduler.drainBacklog(context.Background())
+	require.Len(t, observer.bucketLabels, 1)
+}
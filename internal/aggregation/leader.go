@@ -0,0 +1,197 @@
+package aggregation
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/aevon-lab/project-aevon/internal/core/aggregation"
+)
+
+// RebuilderLease is the lease held by the replica that runs rule rebuilds.
+const RebuilderLease = "rule_rebuilder"
+
+// SchedulerLease returns the lease held by the replica that drains stream, a bucket
+// label optionally followed by a partition range (e.g. "1m" or "1m/p0-128").
+func SchedulerLease(stream string) string {
+	return "scheduler/" + stream
+}
+
+// LeaseStore persists leases shared by all replicas of the service.
+type LeaseStore interface {
+	// TryAcquireLease takes the lease for holder if it is free, expired or already held
+	// by holder, and extends it to ttl from now. It reports whether holder owns the lease.
+	TryAcquireLease(ctx context.Context, name string, holder string, ttl time.Duration) (bool, error)
+
+	// ReleaseLease gives up the lease if holder still owns it.
+	ReleaseLease(ctx context.Context, name string, holder string) error
+
+	// ListLeases returns every lease, expired or not, ordered by name. Lease.Active is
+	// computed on the store's clock, the one TryAcquireLease checks expiry against.
+	ListLeases(ctx context.Context) ([]aggregation.Lease, error)
+}
+
+// LeaderElector lets replicas sharing one database agree on which of them runs each
+// aggregation stream. Every stream is guarded by a lease that its leader renews every
+// ttl/3; when the leader dies, the lease expires and another replica takes over.
+//
+// Leadership is advisory: a leader that stalls past its lease may still finish a batch,
+// which Flush then discards as stale. The lease only prevents the wasted work.
+type LeaderElector struct {
+	store     LeaseStore
+	replicaID string
+	ttl       time.Duration
+
+	maxLeases int // 0 means no limit
+
+	mu        sync.Mutex
+	heldUntil map[string]time.Time // lease name -> local deadline of the last successful renewal
+}
+
+// NewLeaderElector creates an elector that campaigns as replicaID with leases of ttl.
+func NewLeaderElector(store LeaseStore, replicaID string, ttl time.Duration) *LeaderElector {
+	return &LeaderElector{
+		store:     store,
+		replicaID: replicaID,
+		ttl:       ttl,
+		heldUntil: make(map[string]time.Time),
+	}
+}
+
+// LimitLeases caps the number of leases this replica holds at once so the streams of a
+// sharded bucket size spread across replicas instead of all landing on the first one.
+// Held leases are still renewed; 0 means no limit. Must be called before Campaign.
+func (e *LeaderElector) LimitLeases(n int) {
+	e.maxLeases = n
+}
+
+// ReplicaID returns the identity this replica campaigns with.
+func (e *LeaderElector) ReplicaID() string {
+	return e.replicaID
+}
+
+// Campaign tries to acquire the lease once, then keeps acquiring or renewing it in the
+// background until ctx is cancelled.
+func (e *LeaderElector) Campaign(ctx context.Context, name string) {
+	e.tryAcquire(ctx, name)
+
+	go func() {
+		ticker := time.NewTicker(e.ttl / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				e.tryAcquire(ctx, name)
+			}
+		}
+	}()
+}
+
+// Resign releases the lease so another replica can take over without waiting for expiry.
+func (e *LeaderElector) Resign(name string) {
+	e.mu.Lock()
+	delete(e.heldUntil, name)
+	e.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := e.store.ReleaseLease(ctx, name, e.replicaID); err != nil {
+		slog.Warn("[LeaderElector] Failed to release lease; it expires on its own", "lease", name, "error", err)
+	}
+}
+
+// IsLeader reports whether this replica holds the lease. A lease whose renewal has not
+// succeeded within ttl counts as lost even if no other replica took it yet.
+func (e *LeaderElector) IsLeader(name string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return time.Now().Before(e.heldUntil[name])
+}
+
+func (e *LeaderElector) tryAcquire(ctx context.Context, name string) {
+	if !e.IsLeader(name) && e.atLeaseLimit() {
+		return
+	}
+
+	// The local deadline is measured from before the request so it never outlives the stored lease.
+	deadline := time.Now().Add(e.ttl)
+	acquired, err := e.store.TryAcquireLease(ctx, name, e.replicaID, e.ttl)
+	if err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		slog.Warn("[LeaderElector] Lease renewal failed", "lease", name, "error", err)
+	}
+
+	e.mu.Lock()
+	wasLeader := time.Now().Before(e.heldUntil[name])
+	switch {
+	case acquired:
+		e.heldUntil[name] = deadline
+	case err == nil:
+		delete(e.heldUntil, name)
+	}
+	isLeader := time.Now().Before(e.heldUntil[name])
+	e.mu.Unlock()
+
+	if isLeader && !wasLeader {
+		slog.Info("[LeaderElector] Acquired lease", "lease", name, "replica_id", e.replicaID)
+	} else if wasLeader && !isLeader {
+		slog.Warn("[LeaderElector] Lost lease", "lease", name, "replica_id", e.replicaID)
+	}
+}
+
+func (e *LeaderElector) atLeaseLimit() bool {
+	if e.maxLeases <= 0 {
+		return false
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	now := time.Now()
+	held := 0
+	for _, until := range e.heldUntil {
+		if now.Before(until) {
+			held++
+		}
+	}
+	return held >= e.maxLeases
+}
+
+// LeaderStatus is the leadership section of the health output.
+type LeaderStatus struct {
+	ReplicaID string        `json:"replica_id"`
+	Leases    []LeaseStatus `json:"leases"`
+}
+
+// LeaseStatus describes the current holder of one lease.
+type LeaseStatus struct {
+	Name      string    `json:"name"`
+	Leader    string    `json:"leader"` // empty if the lease expired and nobody took it over yet
+	ExpiresAt time.Time `json:"expires_at"`
+	IsSelf    bool      `json:"is_self"`
+}
+
+// Health reports the current leader of every lease.
+func (e *LeaderElector) Health(ctx context.Context) (interface{}, error) {
+	leases, err := e.store.ListLeases(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Expiry comes from the store's clock: the local clock of this replica may be skewed.
+	status := LeaderStatus{ReplicaID: e.replicaID, Leases: make([]LeaseStatus, 0, len(leases))}
+	for _, lease := range leases {
+		s := LeaseStatus{Name: lease.Name, ExpiresAt: lease.ExpiresAt}
+		if lease.Active {
+			s.Leader = lease.Holder
+		}
+		s.IsSelf = s.Leader == e.replicaID && e.IsLeader(lease.Name)
+		status.Leases = append(status.Leases, s)
+	}
+	return status, nil
+}
@@ -0,0 +1,128 @@
+package aggregation
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	v1 "github.com/aevon-lab/project-aevon/internal/api/v1"
+	"github.com/aevon-lab/project-aevon/internal/core/aggregation"
+	"github.com/stretchr/testify/require"
+)
+
+// mockLeaseStore keeps leases in memory; now stands in for the database clock.
+type mockLeaseStore struct {
+	mu     sync.Mutex
+	now    time.Time
+	leases map[string]aggregation.Lease
+}
+
+func newMockLeaseStore() *mockLeaseStore {
+	return &mockLeaseStore{now: time.Now(), leases: make(map[string]aggregation.Lease)}
+}
+
+func (m *mockLeaseStore) TryAcquireLease(ctx context.Context, name string, holder string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if l, ok := m.leases[name]; ok && l.Holder != holder && l.ExpiresAt.After(m.now) {
+		return false, nil
+	}
+	m.leases[name] = aggregation.Lease{Name: name, Holder: holder, RenewedAt: m.now, ExpiresAt: m.now.Add(ttl)}
+	return true, nil
+}
+
+func (m *mockLeaseStore) ReleaseLease(ctx context.Context, name string, holder string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if l, ok := m.leases[name]; ok && l.Holder == holder {
+		delete(m.leases, name)
+	}
+	return nil
+}
+
+func (m *mockLeaseStore) ListLeases(ctx context.Context) ([]aggregation.Lease, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []aggregation.Lease
+	for _, l := range m.leases {
+		l.Active = l.ExpiresAt.After(m.now)
+		out = append(out, l)
+	}
+	return out, nil
+}
+
+func (m *mockLeaseStore) advance(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.now = m.now.Add(d)
+}
+
+func TestLeaderElector_OneLeaderAndFailover(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := newMockLeaseStore()
+	lease := SchedulerLease("1m")
+	a := NewLeaderElector(store, "replica-a", time.Hour)
+	b := NewLeaderElector(store, "replica-b", time.Hour)
+
+	a.Campaign(ctx, lease)
+	b.Campaign(ctx, lease)
+	require.True(t, a.IsLeader(lease))
+	require.False(t, b.IsLeader(lease))
+
+	status, err := b.Health(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []LeaseStatus{{Name: lease, Leader: "replica-a", ExpiresAt: store.leases[lease].ExpiresAt}},
+		status.(LeaderStatus).Leases)
+
+	// The leader dies without releasing: the lease is taken over once it expires.
+	store.advance(2 * time.Hour)
+	status, err = b.Health(ctx)
+	require.NoError(t, err)
+	require.Empty(t, status.(LeaderStatus).Leases[0].Leader)
+	b.tryAcquire(ctx, lease)
+	require.True(t, b.IsLeader(lease))
+
+	// A clean shutdown hands over without waiting for expiry.
+	b.Resign(lease)
+	require.False(t, b.IsLeader(lease))
+	a.tryAcquire(ctx, lease)
+	require.True(t, a.IsLeader(lease))
+}
+
+func TestScheduler_FollowerDoesNotDrain(t *testing.T) {
+	ctx := context.Background()
+	store := newMockLeaseStore()
+	leader := NewLeaderElector(store, "replica-a", time.Hour)
+	leader.Campaign(ctx, SchedulerLease("1m"))
+	follower := NewLeaderElector(store, "replica-b", time.Hour)
+	follower.Campaign(ctx, SchedulerLease("1m"))
+
+	events := []*v1.Event{{
+		ID:          "evt-1",
+		PrincipalID: "user:alice",
+		Type:        "api.request",
+		OccurredAt:  time.Now().UTC(),
+		IngestSeq:   1,
+		Data:        map[string]interface{}{},
+	}}
+	rules := []aggregation.AggregationRule{{Name: "count_requests", SourceEvent: "api.request", Operator: aggregation.OpCount}}
+
+	newStore := func() *mockPreAggStore {
+		return &mockPreAggStore{checkpoints: map[string]int64{}, aggregates: make(map[aggregation.AggregateKey]aggregation.AggregateState)}
+	}
+
+	followerStore := newStore()
+	s := NewScheduler(time.Hour, &mockEventStore{events: events}, followerStore, rules, bucketParameter(BatchJobParameter{BatchSize: 10}, time.Minute))
+	s.leader = follower
+	s.drainBacklog(ctx)
+	require.Zero(t, followerStore.checkpoints["1m"])
+
+	leaderStore := newStore()
+	s = NewScheduler(time.Hour, &mockEventStore{events: events}, leaderStore, rules, bucketParameter(BatchJobParameter{BatchSize: 10}, time.Minute))
+	s.leader = leader
+	s.drainBacklog(ctx)
+	require.Equal(t, int64(1), leaderStore.checkpoints["1m"])
+}
@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/aevon-lab/project-aevon/internal/core/aggregation"
+	"github.com/aevon-lab/project-aevon/internal/core/partition"
 )
 
 // PreAggregateStore is the interface for durable pre-aggregate persistence.
@@ -51,3 +52,104 @@ type PreAggregateStore interface {
 		endTime time.Time,
 	) ([]aggregation.AggregateState, error)
 }
+
+// RuleVersionStore persists the active fingerprint of every rule per bucket size and
+// the progress of rebuilding pre-aggregates after a fingerprint change.
+//
+// Flush implementations that also implement RuleVersionStore reject aggregates whose
+// RuleFingerprint differs from the active version with aggregation.ErrRuleFingerprintMismatch,
+// so a batch computed with a replaced rule definition never reaches durable state.
+type RuleVersionStore interface {
+	// ReconcileRuleVersion makes fingerprint the active version of ruleName at bucketSize.
+	// If another fingerprint was active, or the rule is new to a bucket whose checkpoint
+	// already moved past events it never saw, the rule's pre-aggregates at bucketSize are
+	// deleted and a rebuild up to the current bucket checkpoint is started, atomically.
+	ReconcileRuleVersion(ctx context.Context, ruleName string, bucketSize string, fingerprint string) (aggregation.RuleVersion, error)
+
+	// ListRuleVersions returns the versions of ruleName, or of every rule if ruleName is empty.
+	ListRuleVersions(ctx context.Context, ruleName string) ([]aggregation.RuleVersion, error)
+
+	// FlushRebuild upserts rebuilt aggregates of one rule version and advances its rebuild
+	// cursor in one transaction. It fails with aggregation.ErrRuleFingerprintMismatch if
+	// version is no longer active.
+	FlushRebuild(
+		ctx context.Context,
+		aggregates map[aggregation.AggregateKey]aggregation.AggregateState,
+		version aggregation.RuleVersion,
+		cursor int64,
+	) error
+}
+
+// PartitionedPreAggregateStore keeps one checkpoint per (bucket_size, partition), so
+// partition ranges of one bucket size can be aggregated independently by different
+// workers or replicas. A range's checkpoint is the checkpoint of its partitions.
+type PartitionedPreAggregateStore interface {
+	// ReadPartitionCheckpoints returns the checkpoint of every partition in partitions.
+	// Partitions without a checkpoint yet report 0.
+	ReadPartitionCheckpoints(ctx context.Context, bucketSize string, partitions partition.Range) (aggregation.PartitionCheckpoints, error)
+
+	// FlushPartitions upserts aggregates of principals in from.Range and advances the
+	// checkpoint of every partition in the range to cursor, atomically. The flush is
+	// skipped as stale unless the durable checkpoints still equal from, the checkpoints
+	// the batch started at.
+	FlushPartitions(
+		ctx context.Context,
+		aggregates map[aggregation.AggregateKey]aggregation.AggregateState,
+		bucketSize string,
+		from aggregation.PartitionCheckpoints,
+		cursor int64,
+	) error
+}
+
+// CheckpointedRangeReader reads pre-aggregates of many principals of one rule together
+// with the checkpoint of each principal's partition, in one snapshot.
+type CheckpointedRangeReader interface {
+	QueryRangesWithCheckpoint(
+		ctx context.Context,
+		principalIDs []string,
+		ruleNames []string,
+		bucketSize string,
+		startTime time.Time,
+		endTime time.Time,
+	) (map[aggregation.RangeScope]aggregation.ScopedRange, error)
+}
+
+// AggregateRepairer replaces pre-aggregates that verification found to differ from the
+// event log.
+type AggregateRepairer interface {
+	// RepairAggregates replaces the pre-aggregates of keys at bucketSize with their entry
+	// in aggregates, deleting keys without one, in one transaction. It fails with
+	// aggregation.ErrCheckpointMoved unless every partition in checkpoints is still at
+	// its checkpoint, so no flush merged into the rows since they were verified.
+	RepairAggregates(
+		ctx context.Context,
+		bucketSize string,
+		checkpoints map[int]int64,
+		keys []aggregation.AggregateKey,
+		aggregates map[aggregation.AggregateKey]aggregation.AggregateState,
+	) error
+}
+
+// ShadowAggregateStore stages pre-aggregates recomputed by a range rebuild in shadow rows
+// and swaps them in for the live rows of the range.
+type ShadowAggregateStore interface {
+	// StageShadowAggregates replaces the shadow rows of rebuildID with aggregates.
+	StageShadowAggregates(
+		ctx context.Context,
+		rebuildID string,
+		bucketSize string,
+		aggregates map[aggregation.AggregateKey]aggregation.AggregateState,
+	) error
+
+	// SwapShadowAggregates replaces the live pre-aggregates of swap's rule, bucket size
+	// and range with the shadow rows of swap.RebuildID and deletes the shadow rows, in one
+	// transaction, returning the number of live rows replaced. It fails with
+	// aggregation.ErrCheckpointMoved unless every partition is still at swap.Checkpoints,
+	// with aggregation.ErrRuleFingerprintMismatch if swap.RuleFingerprint is no longer the
+	// active rule version and with aggregation.ErrRuleRebuilding while the rule rebuilder
+	// replays the rule.
+	SwapShadowAggregates(ctx context.Context, swap aggregation.ShadowSwap) (int64, error)
+
+	// DiscardShadowAggregates deletes the shadow rows of rebuildID.
+	DiscardShadowAggregates(ctx context.Context, rebuildID string) error
+}
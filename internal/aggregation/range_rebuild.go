@@ -0,0 +1,283 @@
+package aggregation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/aevon-lab/project-aevon/internal/core/aggregation"
+	"github.com/aevon-lab/project-aevon/internal/core/partition"
+	"github.com/aevon-lab/project-aevon/internal/core/storage"
+	"github.com/google/uuid"
+)
+
+// maxSwapAttempts bounds how often a range rebuild catches up with flushes that moved the
+// checkpoints before its swap.
+const maxSwapAttempts = 5
+
+// ErrInvalidRebuildRequest is returned for range rebuilds that cannot be run.
+var ErrInvalidRebuildRequest = errors.New("invalid rebuild request")
+
+// RangeRebuildRequest selects the pre-aggregates to rebuild: one rule over [From, To).
+// The range must be aligned to every rebuilt bucket size.
+type RangeRebuildRequest struct {
+	Rule       string
+	From, To   time.Time
+	BucketSize string // default: every bucket size of the rule
+}
+
+// RangeRebuildReport is the result of a range rebuild.
+type RangeRebuildReport struct {
+	Rule    string               `json:"rule"`
+	From    time.Time            `json:"from"`
+	To      time.Time            `json:"to"`
+	Buckets []RangeRebuildBucket `json:"buckets"`
+}
+
+// RangeRebuildBucket is the rebuild result of one bucket size.
+type RangeRebuildBucket struct {
+	BucketSize string `json:"bucket_size"`
+	Events     int    `json:"events"`     // events replayed
+	Rows       int    `json:"rows"`       // rebuilt rows swapped in
+	Replaced   int64  `json:"replaced"`   // live rows they replaced
+	Checkpoint int64  `json:"checkpoint"` // highest partition checkpoint the rows include
+	Attempts   int    `json:"attempts"`   // swaps tried; more than one if flushes raced the rebuild
+}
+
+// RangeRebuilder recomputes a rule's pre-aggregates over a time range from the event log
+// with the batch aggregation code, stages them in shadow rows and swaps them in for the
+// live rows atomically. Schedulers keep flushing meanwhile: the rebuilt rows include
+// every event up to the partition checkpoints at the swap, so readers see the old or the
+// rebuilt range, both consistent with the checkpoints.
+type RangeRebuilder struct {
+	eventStore storage.EventStore
+	store      PreAggregateStore
+	rules      []aggregation.AggregationRule
+	opts       BatchJobParameter
+	nowFn      func() time.Time
+}
+
+// NewRangeRebuilder creates a range rebuilder for rules. opts supplies the batch size of
+// event reads.
+func NewRangeRebuilder(
+	eventStore storage.EventStore,
+	store PreAggregateStore,
+	rules []aggregation.AggregationRule,
+	opts BatchJobParameter,
+) *RangeRebuilder {
+	return &RangeRebuilder{
+		eventStore: eventStore,
+		store:      store,
+		rules:      rules,
+		opts:       opts.normalized(),
+		nowFn:      func() time.Time { return time.Now().UTC() },
+	}
+}
+
+// Rebuild rebuilds the pre-aggregates selected by req, one bucket size at a time.
+func (r *RangeRebuilder) Rebuild(ctx context.Context, req RangeRebuildRequest) (*RangeRebuildReport, error) {
+	var rule aggregation.AggregationRule
+	found := false
+	for _, candidate := range r.rules {
+		if candidate.Name == req.Rule {
+			rule, found = candidate, true
+			break
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("%w: unknown rule: %q", ErrInvalidRebuildRequest, req.Rule)
+	}
+	req.From, req.To = req.From.UTC(), req.To.UTC()
+	bucketSizes, err := rangeBucketSizes(rule, req.From, req.To, req.BucketSize)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRebuildRequest, err)
+	}
+
+	shadows, ok := r.store.(ShadowAggregateStore)
+	if !ok {
+		return nil, fmt.Errorf("pre-aggregate store cannot stage shadow pre-aggregates")
+	}
+	partitioned, ok := r.store.(PartitionedPreAggregateStore)
+	if !ok {
+		return nil, fmt.Errorf("pre-aggregate store cannot read partition checkpoints")
+	}
+	reader, ok := r.eventStore.(storage.TypeScopedEventReader)
+	if !ok {
+		return nil, fmt.Errorf("event store cannot read events by type")
+	}
+
+	report := &RangeRebuildReport{Rule: rule.Name, From: req.From, To: req.To}
+	for _, bucketSize := range bucketSizes {
+		if err := r.checkRuleVersion(ctx, rule, aggregation.BucketLabel(bucketSize)); err != nil {
+			return report, err
+		}
+		result, err := r.rebuildBucket(ctx, shadows, partitioned, reader, rule, bucketSize, req)
+		if err != nil {
+			return report, fmt.Errorf("rebuild %s pre-aggregates of rule %s: %w", aggregation.BucketLabel(bucketSize), rule.Name, err)
+		}
+		report.Buckets = append(report.Buckets, result)
+	}
+	return report, nil
+}
+
+// checkRuleVersion fails early if the swap would be rejected for the rule version.
+func (r *RangeRebuilder) checkRuleVersion(ctx context.Context, rule aggregation.AggregationRule, bucketLabel string) error {
+	versions, ok := r.store.(RuleVersionStore)
+	if !ok {
+		return nil
+	}
+	list, err := versions.ListRuleVersions(ctx, rule.Name)
+	if err != nil {
+		return fmt.Errorf("list rule versions: %w", err)
+	}
+	for _, version := range list {
+		if version.BucketSize != bucketLabel {
+			continue
+		}
+		if version.Fingerprint != rule.Fingerprint {
+			return fmt.Errorf("rule %s (bucket=%s) differs from the active definition; reload the rules first: %w",
+				rule.Name, bucketLabel, aggregation.ErrRuleFingerprintMismatch)
+		}
+		if version.Rebuilding() {
+			return fmt.Errorf("rule %s (bucket=%s): %w", rule.Name, bucketLabel, aggregation.ErrRuleRebuilding)
+		}
+	}
+	return nil
+}
+
+func (r *RangeRebuilder) rebuildBucket(
+	ctx context.Context,
+	shadows ShadowAggregateStore,
+	partitioned PartitionedPreAggregateStore,
+	reader storage.TypeScopedEventReader,
+	rule aggregation.AggregationRule,
+	bucketSize time.Duration,
+	req RangeRebuildRequest,
+) (RangeRebuildBucket, error) {
+	opts := bucketParameter(r.opts, bucketSize)
+	ruleMap := toCompiledRuleMap([]aggregation.AggregationRule{rule}, bucketSize)
+	rebuildID := uuid.NewString()
+	result := RangeRebuildBucket{BucketSize: opts.BucketLabel}
+
+	swapped := false
+	defer func() {
+		if swapped {
+			return
+		}
+		cleanupCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := shadows.DiscardShadowAggregates(cleanupCtx, rebuildID); err != nil {
+			slog.Warn("[RangeRebuilder] Failed to discard shadow pre-aggregates",
+				"rebuild_id", rebuildID,
+				"error", err,
+			)
+		}
+	}()
+
+	read := uniformCheckpoints(partition.Full(), 0)
+	checkpoints, err := partitioned.ReadPartitionCheckpoints(ctx, opts.BucketLabel, partition.Full())
+	if err != nil {
+		return result, fmt.Errorf("read checkpoints: %w", err)
+	}
+	aggregates := make(map[aggregation.AggregateKey]aggregation.AggregateState)
+
+	for attempt := 1; ; attempt++ {
+		result.Attempts = attempt
+		events, err := r.replay(ctx, reader, rule, ruleMap, opts, req, read, checkpoints, aggregates)
+		if err != nil {
+			return result, err
+		}
+		result.Events += events
+
+		rows := make(map[aggregation.AggregateKey]aggregation.AggregateState, len(aggregates))
+		for key, state := range aggregates {
+			if !key.WindowStart.Before(req.From) && key.WindowStart.Before(req.To) {
+				rows[key] = aggregation.Finalize(state)
+			}
+		}
+		if err := shadows.StageShadowAggregates(ctx, rebuildID, opts.BucketLabel, rows); err != nil {
+			return result, fmt.Errorf("stage shadow pre-aggregates: %w", err)
+		}
+
+		replaced, err := shadows.SwapShadowAggregates(ctx, aggregation.ShadowSwap{
+			RebuildID:       rebuildID,
+			RuleName:        rule.Name,
+			RuleFingerprint: rule.Fingerprint,
+			BucketSize:      opts.BucketLabel,
+			Start:           req.From,
+			End:             req.To,
+			Checkpoints:     checkpoints,
+		})
+		if errors.Is(err, aggregation.ErrCheckpointMoved) && attempt < maxSwapAttempts {
+			// Replay only the events flushed since, then stage and swap again.
+			read = checkpoints
+			checkpoints, err = partitioned.ReadPartitionCheckpoints(ctx, opts.BucketLabel, partition.Full())
+			if err != nil {
+				return result, fmt.Errorf("read checkpoints: %w", err)
+			}
+			continue
+		}
+		if err != nil {
+			return result, fmt.Errorf("swap shadow pre-aggregates: %w", err)
+		}
+
+		swapped = true
+		result.Rows = len(rows)
+		result.Replaced = replaced
+		result.Checkpoint = checkpoints.Max()
+		slog.Info("[RangeRebuilder] Rebuilt pre-aggregates",
+			"rule", rule.Name,
+			"bucket_size", opts.BucketLabel,
+			"from", req.From,
+			"to", req.To,
+			"rows", result.Rows,
+			"replaced", replaced,
+			"attempts", attempt,
+		)
+		return result, nil
+	}
+}
+
+// replay merges the rule's events in the range with an ingest_seq in (from, to] of their
+// partition into aggregates and returns the number of events merged.
+func (r *RangeRebuilder) replay(
+	ctx context.Context,
+	reader storage.TypeScopedEventReader,
+	rule aggregation.AggregationRule,
+	ruleMap map[string][]compiledRule,
+	opts BatchJobParameter,
+	req RangeRebuildRequest,
+	from aggregation.PartitionCheckpoints,
+	to aggregation.PartitionCheckpoints,
+	aggregates map[aggregation.AggregateKey]aggregation.AggregateState,
+) (int, error) {
+	merged := 0
+	cursor, last := from.Min(), to.Max()
+	for cursor < last {
+		page, err := reader.RetrieveTypeScopedEventsAfterCursor(ctx, cursor, rule.SourceEvent, req.From, req.To, rule.BucketsByIngestion(), opts.BatchSize)
+		if err != nil {
+			return merged, fmt.Errorf("read events after %d: %w", cursor, err)
+		}
+		if len(page) == 0 {
+			break
+		}
+
+		batch := page[:0:0]
+		for _, evt := range page {
+			p := partition.For(evt.PrincipalID)
+			if evt.IngestSeq > from.Cursor(p) && evt.IngestSeq <= to.Cursor(p) {
+				batch = append(batch, evt)
+			}
+		}
+		mergeGroupAggregates(aggregates, batch, ruleMap, opts, r.nowFn())
+		merged += len(batch)
+
+		cursor = page[len(page)-1].IngestSeq
+		if len(page) < opts.BatchSize {
+			break
+		}
+	}
+	return merged, nil
+}
@@ -0,0 +1,159 @@
+package aggregation
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v1 "github.com/aevon-lab/project-aevon/internal/api/v1"
+	"github.com/aevon-lab/project-aevon/internal/core/aggregation"
+	"github.com/stretchr/testify/require"
+)
+
+// typeScopedEventStore serves type-scoped event reads from its events.
+type typeScopedEventStore struct {
+	mockEventStore
+}
+
+func (m *typeScopedEventStore) RetrieveTypeScopedEventsAfterCursor(
+	ctx context.Context,
+	cursor int64,
+	eventType string,
+	startOccurredAt time.Time,
+	endOccurredAt time.Time,
+	includeIngested bool,
+	limit int,
+) ([]*v1.Event, error) {
+	var result []*v1.Event
+	for _, evt := range m.events {
+		if evt.IngestSeq <= cursor || evt.Type != eventType {
+			continue
+		}
+		if evt.OccurredAt.Before(startOccurredAt) || !evt.OccurredAt.Before(endOccurredAt) {
+			continue
+		}
+		result = append(result, evt)
+		if len(result) >= limit {
+			break
+		}
+	}
+	return result, nil
+}
+
+// shadowPreAggStore stages shadow rows in memory and swaps them into aggregates.
+type shadowPreAggStore struct {
+	mockPartitionedPreAggStore
+	shadows    map[string]map[aggregation.AggregateKey]aggregation.AggregateState
+	swaps      int
+	beforeSwap func() // runs before each swap, e.g. to flush concurrently
+}
+
+func (m *shadowPreAggStore) StageShadowAggregates(
+	ctx context.Context,
+	rebuildID string,
+	bucketSize string,
+	aggregates map[aggregation.AggregateKey]aggregation.AggregateState,
+) error {
+	m.shadows[rebuildID] = aggregates
+	return nil
+}
+
+func (m *shadowPreAggStore) SwapShadowAggregates(ctx context.Context, swap aggregation.ShadowSwap) (int64, error) {
+	m.swaps++
+	if m.beforeSwap != nil {
+		m.beforeSwap()
+	}
+	for i, cursor := range swap.Checkpoints.Cursors {
+		if m.partitionCheckpoints[swap.Checkpoints.Range.From+i] != cursor {
+			return 0, aggregation.ErrCheckpointMoved
+		}
+	}
+	var replaced int64
+	for key := range m.aggregates {
+		if key.RuleName == swap.RuleName && key.BucketSize == swap.BucketSize &&
+			!key.WindowStart.Before(swap.Start) && key.WindowStart.Before(swap.End) {
+			delete(m.aggregates, key)
+			replaced++
+		}
+	}
+	for key, state := range m.shadows[swap.RebuildID] {
+		m.aggregates[key] = state
+	}
+	delete(m.shadows, swap.RebuildID)
+	return replaced, nil
+}
+
+func (m *shadowPreAggStore) DiscardShadowAggregates(ctx context.Context, rebuildID string) error {
+	delete(m.shadows, rebuildID)
+	return nil
+}
+
+func setPartitionCheckpoints(store *shadowPreAggStore, cursor int64) {
+	for p := range store.partitionCheckpoints {
+		store.partitionCheckpoints[p] = cursor
+	}
+}
+
+func TestRangeRebuilder_RebuildsRangeAndCatchesUpWithFlushes(t *testing.T) {
+	from := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	eventStore := &typeScopedEventStore{mockEventStore{events: []*v1.Event{
+		streamingTestEvent(1, "user:alice", from),
+		streamingTestEvent(2, "user:alice", from.Add(-time.Minute)), // before the range
+		streamingTestEvent(3, "user:bob", from.Add(time.Minute)),
+		streamingTestEvent(4, "user:bob", from.Add(time.Minute)), // flushed during the rebuild
+	}}}
+	outside := verifyTestKey("user:alice", from.Add(-time.Minute))
+	preAggStore := &shadowPreAggStore{
+		mockPartitionedPreAggStore: mockPartitionedPreAggStore{mockPreAggStore: mockPreAggStore{
+			aggregates: map[aggregation.AggregateKey]aggregation.AggregateState{
+				verifyTestKey("user:alice", from):                  verifyTestState(9, from),
+				verifyTestKey("user:carol", from.Add(time.Minute)): verifyTestState(1, from.Add(time.Minute)),
+				outside: verifyTestState(1, outside.WindowStart),
+			},
+		}},
+		shadows: make(map[string]map[aggregation.AggregateKey]aggregation.AggregateState),
+	}
+	setPartitionCheckpoints(preAggStore, 3)
+	preAggStore.beforeSwap = func() {
+		setPartitionCheckpoints(preAggStore, 4)
+		preAggStore.beforeSwap = nil
+	}
+
+	rebuilder := NewRangeRebuilder(eventStore, preAggStore, verifyTestRules, BatchJobParameter{BatchSize: 2})
+	report, err := rebuilder.Rebuild(context.Background(), RangeRebuildRequest{
+		Rule: "count_requests",
+		From: from,
+		To:   from.Add(10 * time.Minute),
+	})
+	require.NoError(t, err)
+	require.Equal(t, []RangeRebuildBucket{{
+		BucketSize: "1m",
+		Events:     3,
+		Rows:       2,
+		Replaced:   2,
+		Checkpoint: 4,
+		Attempts:   2,
+	}}, report.Buckets)
+	require.Empty(t, preAggStore.shadows)
+
+	require.Len(t, preAggStore.aggregates, 3)
+	require.Equal(t, int64(1), preAggStore.aggregates[verifyTestKey("user:alice", from)].EventCount)
+	require.Equal(t, int64(2), preAggStore.aggregates[verifyTestKey("user:bob", from.Add(time.Minute))].EventCount)
+	require.Contains(t, preAggStore.aggregates, outside)
+}
+
+func TestRangeRebuilder_RejectsInvalidRequests(t *testing.T) {
+	from := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	preAggStore := &shadowPreAggStore{shadows: make(map[string]map[aggregation.AggregateKey]aggregation.AggregateState)}
+	rebuilder := NewRangeRebuilder(&typeScopedEventStore{}, preAggStore, verifyTestRules, BatchJobParameter{})
+
+	for name, req := range map[string]RangeRebuildRequest{
+		"unknown rule": {Rule: "missing", From: from, To: from.Add(time.Hour)},
+		"empty range":  {Rule: "count_requests", From: from, To: from},
+		"misaligned":   {Rule: "count_requests", From: from.Add(time.Second), To: from.Add(time.Hour)},
+	} {
+		_, err := rebuilder.Rebuild(context.Background(), req)
+		require.ErrorIs(t, err, ErrInvalidRebuildRequest, name)
+	}
+	require.Zero(t, preAggStore.swaps)
+}
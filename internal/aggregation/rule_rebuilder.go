@@ -0,0 +1,207 @@
+package aggregation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	v1 "github.com/aevon-lab/project-aevon/internal/api/v1"
+	"github.com/aevon-lab/project-aevon/internal/core/aggregation"
+	"github.com/aevon-lab/project-aevon/internal/core/partition"
+	"github.com/aevon-lab/project-aevon/internal/core/storage"
+)
+
+// RuleRebuilder detects rule fingerprint drift at startup and on every rule reload and
+// rebuilds the affected pre-aggregates from the event log in the background. Each
+// (rule, bucket size) rebuild keeps its own cursor, so it resumes after a restart.
+type RuleRebuilder struct {
+	interval   time.Duration
+	eventStore storage.EventStore
+	store      RuleVersionStore
+	opts       BatchJobParameter
+	leader     *LeaderElector // nil runs without coordination
+
+	mu      sync.Mutex
+	rules   []aggregation.AggregationRule
+	trigger chan struct{}
+}
+
+// NewRuleRebuilder creates a rebuilder for rules. interval is the retry period after a
+// failed pass; opts supplies batch size and worker count.
+func NewRuleRebuilder(
+	interval time.Duration,
+	eventStore storage.EventStore,
+	store RuleVersionStore,
+	rules []aggregation.AggregationRule,
+	opts BatchJobParameter,
+) *RuleRebuilder {
+	return &RuleRebuilder{
+		interval:   interval,
+		eventStore: eventStore,
+		store:      store,
+		opts:       opts.normalized(),
+		rules:      rules,
+		trigger:    make(chan struct{}, 1),
+	}
+}
+
+// SetRules replaces the rule set and schedules a drift check.
+func (r *RuleRebuilder) SetRules(rules []aggregation.AggregationRule) {
+	r.mu.Lock()
+	r.rules = rules
+	r.mu.Unlock()
+
+	select {
+	case r.trigger <- struct{}{}:
+	default:
+	}
+}
+
+func (r *RuleRebuilder) currentRules() []aggregation.AggregationRule {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rules
+}
+
+// UseLeaderElector makes the rebuilder campaign for RebuilderLease and run passes only
+// while it leads. Must be called before Start.
+func (r *RuleRebuilder) UseLeaderElector(leader *LeaderElector) {
+	r.leader = leader
+}
+
+// Start reconciles rule versions and runs pending rebuilds until ctx is cancelled.
+// A failed pass is retried after interval.
+func (r *RuleRebuilder) Start(ctx context.Context) error {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	if r.leader != nil {
+		r.leader.Campaign(ctx, RebuilderLease)
+		defer r.leader.Resign(RebuilderLease)
+	}
+
+	for {
+		// Followers check again on the next tick whether the leader is gone.
+		if r.leader == nil || r.leader.IsLeader(RebuilderLease) {
+			if err := r.RunOnce(ctx); err != nil && ctx.Err() == nil {
+				slog.Error("[RuleRebuilder] Rebuild pass failed; retrying on next tick", "error", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-r.trigger:
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce registers the current rule fingerprints and drives every pending rebuild of
+// a current rule to completion.
+func (r *RuleRebuilder) RunOnce(ctx context.Context) error {
+	rules := r.currentRules()
+	byName := make(map[string]aggregation.AggregationRule, len(rules))
+
+	for _, rule := range rules {
+		byName[rule.Name] = rule
+		for _, bucketSize := range rule.Buckets() {
+			version, err := r.store.ReconcileRuleVersion(ctx, rule.Name, aggregation.BucketLabel(bucketSize), rule.Fingerprint)
+			if err != nil {
+				return fmt.Errorf("reconcile rule %s: %w", rule.Name, err)
+			}
+			if version.Rebuilding() {
+				slog.Info("[RuleRebuilder] Rule pre-aggregates need rebuild",
+					"rule", rule.Name,
+					"bucket_size", version.BucketSize,
+					"rebuild_cursor", version.RebuildCursor,
+					"rebuild_target", version.RebuildTarget,
+				)
+			}
+		}
+	}
+
+	versions, err := r.store.ListRuleVersions(ctx, "")
+	if err != nil {
+		return err
+	}
+	for _, version := range versions {
+		rule, ok := byName[version.RuleName]
+		if !ok || rule.Fingerprint != version.Fingerprint || !version.Rebuilding() {
+			continue
+		}
+		if err := r.rebuild(ctx, rule, version); err != nil {
+			if errors.Is(err, aggregation.ErrRuleFingerprintMismatch) {
+				// The rule changed again mid-rebuild; the next pass starts over.
+				slog.Info("[RuleRebuilder] Rule changed during rebuild", "rule", rule.Name, "bucket_size", version.BucketSize)
+				continue
+			}
+			return fmt.Errorf("rebuild rule %s (bucket=%s): %w", rule.Name, version.BucketSize, err)
+		}
+	}
+	return nil
+}
+
+// rebuild replays events up to the version's rebuild target into the rule's aggregates.
+// Events after the target are the regular scheduler's responsibility.
+func (r *RuleRebuilder) rebuild(ctx context.Context, rule aggregation.AggregationRule, version aggregation.RuleVersion) error {
+	bucketSize, err := aggregation.ParseWindowSize(version.BucketSize)
+	if err != nil {
+		return err
+	}
+	opts := bucketParameter(r.opts, bucketSize.Size)
+	ruleMap := toCompiledRuleMap([]aggregation.AggregationRule{rule}, opts.BucketSize)
+
+	slog.Info("[RuleRebuilder] Rebuilding rule pre-aggregates",
+		"rule", rule.Name,
+		"bucket_size", version.BucketSize,
+		"from_cursor", version.RebuildCursor,
+		"target", version.RebuildTarget,
+	)
+
+	cursor := version.RebuildCursor
+	for cursor < version.RebuildTarget {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		events, err := r.eventStore.RetrieveEventsAfterCursor(ctx, cursor, opts.BatchSize)
+		if err != nil {
+			return fmt.Errorf("fetch events: %w", err)
+		}
+
+		// The last page or an event past the target ends the rebuild at the target.
+		newCursor := version.RebuildTarget
+		inRange := events
+		for i, event := range events {
+			if event.IngestSeq > version.RebuildTarget {
+				inRange = events[:i]
+				break
+			}
+		}
+		if len(inRange) == opts.BatchSize {
+			newCursor = inRange[len(inRange)-1].IngestSeq
+		}
+
+		// Partitions whose checkpoint was behind the target are covered by their scheduler
+		// from that checkpoint on.
+		pending := make([]*v1.Event, 0, len(inRange))
+		for _, event := range inRange {
+			if event.IngestSeq <= version.TargetFor(partition.For(event.PrincipalID)) {
+				pending = append(pending, event)
+			}
+		}
+
+		aggregates := buildPreAggregatesConcurrently(pending, ruleMap, opts)
+		if err := r.store.FlushRebuild(ctx, aggregates, version, newCursor); err != nil {
+			return err
+		}
+		cursor = newCursor
+	}
+
+	slog.Info("[RuleRebuilder] Rule rebuild complete", "rule", rule.Name, "bucket_size", version.BucketSize)
+	return nil
+}
@@ -0,0 +1,142 @@
+package aggregation
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v1 "github.com/aevon-lab/project-aevon/internal/api/v1"
+	"github.com/aevon-lab/project-aevon/internal/core/aggregation"
+	"github.com/stretchr/testify/require"
+)
+
+// mockRuleVersionStore keeps rule versions and rebuilt aggregates in memory.
+// checkpoint stands in for the bucket checkpoint a reconcile would lock.
+type mockRuleVersionStore struct {
+	checkpoint int64
+	versions   map[string]aggregation.RuleVersion // keyed by rule name + bucket size
+	aggregates map[aggregation.AggregateKey]aggregation.AggregateState
+	flushes    int
+}
+
+func newMockRuleVersionStore(checkpoint int64) *mockRuleVersionStore {
+	return &mockRuleVersionStore{
+		checkpoint: checkpoint,
+		versions:   make(map[string]aggregation.RuleVersion),
+		aggregates: make(map[aggregation.AggregateKey]aggregation.AggregateState),
+	}
+}
+
+func (m *mockRuleVersionStore) ReconcileRuleVersion(ctx context.Context, ruleName, bucketSize, fingerprint string) (aggregation.RuleVersion, error) {
+	id := ruleName + "/" + bucketSize
+	if v, ok := m.versions[id]; ok && v.Fingerprint == fingerprint {
+		return v, nil
+	}
+	for key := range m.aggregates {
+		if key.RuleName == ruleName && key.BucketSize == bucketSize {
+			delete(m.aggregates, key)
+		}
+	}
+	v := aggregation.RuleVersion{RuleName: ruleName, BucketSize: bucketSize, Fingerprint: fingerprint, RebuildTarget: m.checkpoint}
+	m.versions[id] = v
+	return v, nil
+}
+
+func (m *mockRuleVersionStore) ListRuleVersions(ctx context.Context, ruleName string) ([]aggregation.RuleVersion, error) {
+	var out []aggregation.RuleVersion
+	for _, v := range m.versions {
+		if ruleName == "" || v.RuleName == ruleName {
+			out = append(out, v)
+		}
+	}
+	return out, nil
+}
+
+func (m *mockRuleVersionStore) FlushRebuild(
+	ctx context.Context,
+	aggregates map[aggregation.AggregateKey]aggregation.AggregateState,
+	version aggregation.RuleVersion,
+	cursor int64,
+) error {
+	id := version.RuleName + "/" + version.BucketSize
+	active, ok := m.versions[id]
+	if !ok || active.Fingerprint != version.Fingerprint {
+		return aggregation.ErrRuleFingerprintMismatch
+	}
+	for k, v := range aggregates {
+		if existing, ok := m.aggregates[k]; ok {
+			eventCount := existing.EventCount + v.EventCount
+			v = aggregation.Operators[v.Operator].Merge(existing, v)
+			v.EventCount = eventCount
+		}
+		m.aggregates[k] = v
+	}
+	active.RebuildCursor = cursor
+	m.versions[id] = active
+	m.flushes++
+	return nil
+}
+
+func TestRuleRebuilder_RebuildsDriftedRuleUpToCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	window := time.Now().UTC().Truncate(time.Minute)
+
+	var events []*v1.Event
+	for seq := int64(1); seq <= 5; seq++ {
+		events = append(events, &v1.Event{
+			ID:          "evt",
+			PrincipalID: "user:alice",
+			Type:        "api.request",
+			OccurredAt:  window,
+			IngestSeq:   seq,
+			Data:        map[string]interface{}{},
+		})
+	}
+
+	store := newMockRuleVersionStore(4) // event 5 is left to the regular scheduler
+	store.versions["count_requests/1m"] = aggregation.RuleVersion{RuleName: "count_requests", BucketSize: "1m", Fingerprint: "fp-old"}
+
+	rule := aggregation.AggregationRule{
+		Name:        "count_requests",
+		SourceEvent: "api.request",
+		Operator:    aggregation.OpCount,
+		WindowSize:  time.Minute,
+		Fingerprint: "fp-new",
+	}
+	rebuilder := NewRuleRebuilder(time.Minute, &mockEventStore{events: events}, store, []aggregation.AggregationRule{rule},
+		BatchJobParameter{BatchSize: 2, WorkerCount: 2})
+
+	require.NoError(t, rebuilder.RunOnce(ctx))
+
+	version := store.versions["count_requests/1m"]
+	require.Equal(t, "fp-new", version.Fingerprint)
+	require.False(t, version.Rebuilding())
+	require.Equal(t, int64(4), version.RebuildCursor)
+	require.Equal(t, 2, store.flushes)
+
+	require.Len(t, store.aggregates, 1)
+	for key, state := range store.aggregates {
+		require.Equal(t, "1m", key.BucketSize)
+		require.Equal(t, int64(4), state.EventCount)
+		require.Equal(t, "fp-new", state.RuleFingerprint)
+	}
+
+	// A second pass with unchanged rules has nothing to do.
+	require.NoError(t, rebuilder.RunOnce(ctx))
+	require.Equal(t, 2, store.flushes)
+}
+
+func TestRuleRebuilder_NoRebuildWhenFingerprintMatches(t *testing.T) {
+	store := newMockRuleVersionStore(100)
+	store.versions["count_requests/1m"] = aggregation.RuleVersion{RuleName: "count_requests", BucketSize: "1m", Fingerprint: "fp-1"}
+
+	rebuilder := NewRuleRebuilder(time.Minute, &mockEventStore{}, store, []aggregation.AggregationRule{{
+		Name:        "count_requests",
+		SourceEvent: "api.request",
+		Operator:    aggregation.OpCount,
+		Fingerprint: "fp-1",
+	}}, BatchJobParameter{BatchSize: 10, WorkerCount: 1})
+
+	require.NoError(t, rebuilder.RunOnce(context.Background()))
+	require.Zero(t, store.flushes)
+}
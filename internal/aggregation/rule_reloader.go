@@ -0,0 +1,203 @@
+package aggregation
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aevon-lab/project-aevon/internal/core/aggregation"
+	httperr "github.com/aevon-lab/project-aevon/internal/core/errors"
+	"github.com/fsnotify/fsnotify"
+	"github.com/gin-gonic/gin"
+)
+
+// defaultReloadDebounce coalesces the burst of file events an editor or a
+// ConfigMap update produces into one reload.
+const defaultReloadDebounce = 500 * time.Millisecond
+
+// RuleSubscriber receives the complete rule set after every successful reload.
+type RuleSubscriber interface {
+	SetRules(rules []aggregation.AggregationRule)
+}
+
+// ReloadStatus describes the active rule set and the outcome of the last reload attempt.
+type ReloadStatus struct {
+	Rules         []string  `json:"rules"`
+	LoadedAt      time.Time `json:"loaded_at"`
+	LastAttemptAt time.Time `json:"last_attempt_at"`
+	LastError     string    `json:"last_error,omitempty"`
+}
+
+// RuleReloader re-reads aggregation rules from disk and swaps them into subscribers
+// (schedulers, projection service). A rejected rule set leaves the old one running.
+// Reloads are triggered by Watch, the admin endpoint, or directly (e.g. on SIGHUP).
+type RuleReloader struct {
+	repo         *aggregation.FileSystemRuleRepository
+	requireRules bool
+	subscribers  []RuleSubscriber
+	debounce     time.Duration
+	nowFn        func() time.Time
+
+	mu     sync.Mutex // serializes reloads and guards status
+	status ReloadStatus
+}
+
+// NewRuleReloader creates a reloader for repo. With requireRules, a reload that
+// would leave zero rules is rejected.
+func NewRuleReloader(
+	repo *aggregation.FileSystemRuleRepository,
+	requireRules bool,
+	subscribers ...RuleSubscriber,
+) *RuleReloader {
+	now := time.Now().UTC()
+	return &RuleReloader{
+		repo:         repo,
+		requireRules: requireRules,
+		subscribers:  subscribers,
+		debounce:     defaultReloadDebounce,
+		nowFn:        func() time.Time { return time.Now().UTC() },
+		status: ReloadStatus{
+			Rules:         ruleNames(repo.GetRules()),
+			LoadedAt:      now,
+			LastAttemptAt: now,
+		},
+	}
+}
+
+// Reload validates every rule file and, if all are valid, swaps the new set into
+// the subscribers. The returned status reflects the attempt either way.
+func (r *RuleReloader) Reload() (ReloadStatus, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.nowFn()
+	r.status.LastAttemptAt = now
+
+	rules, err := r.repo.Reload(r.validate)
+	if err != nil {
+		r.status.LastError = err.Error()
+		slog.Error("[RuleReloader] Rule reload rejected; keeping current rules",
+			"dir", r.repo.Dir(),
+			"error", err,
+			"active_rules", len(r.status.Rules),
+		)
+		return r.status, err
+	}
+
+	for _, subscriber := range r.subscribers {
+		subscriber.SetRules(rules)
+	}
+
+	r.status.Rules = ruleNames(rules)
+	r.status.LoadedAt = now
+	r.status.LastError = ""
+	slog.Info("[RuleReloader] Aggregation rules reloaded",
+		"dir", r.repo.Dir(),
+		"rules", r.status.Rules,
+	)
+	return r.status, nil
+}
+
+// Status returns the active rule set and the outcome of the last reload attempt.
+func (r *RuleReloader) Status() ReloadStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status
+}
+
+func (r *RuleReloader) validate(rules []aggregation.AggregationRule) error {
+	if r.requireRules && len(rules) == 0 {
+		return fmt.Errorf("no aggregation rules found in %q (aggregation.require_rules is set)", r.repo.Dir())
+	}
+	return nil
+}
+
+// Watch reloads rules whenever a rule file in the repository directory changes.
+// Runs until ctx is cancelled. The directory must exist when Watch starts.
+func (r *RuleReloader) Watch(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("create rule watcher: %w", err)
+	}
+	defer watcher.Close()
+
+	if err := watcher.Add(r.repo.Dir()); err != nil {
+		return fmt.Errorf("watch rule dir %q: %w", r.repo.Dir(), err)
+	}
+	slog.Info("[RuleReloader] Watching aggregation rules", "dir", r.repo.Dir())
+
+	timer := time.NewTimer(r.debounce)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if isRuleFileEvent(event) {
+				timer.Reset(r.debounce)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			slog.Warn("[RuleReloader] Rule watcher error", "error", err)
+		case <-timer.C:
+			_, _ = r.Reload()
+		}
+	}
+}
+
+// isRuleFileEvent filters out chmod-only events and files the loader ignores.
+// Kubernetes ConfigMap volumes swap a "..data" symlink, so dot-dot entries count too.
+func isRuleFileEvent(event fsnotify.Event) bool {
+	if event.Op == fsnotify.Chmod {
+		return false
+	}
+	name := filepath.Base(event.Name)
+	return strings.HasSuffix(name, ".yaml") || strings.HasSuffix(name, ".yml") || strings.HasPrefix(name, "..")
+}
+
+// RegisterRoutes registers the rule admin API routes on the given router.
+func (r *RuleReloader) RegisterRoutes(router gin.IRouter) {
+	router.GET("/admin/rules", r.HandleStatus)
+	router.POST("/admin/rules/reload", r.HandleReload)
+}
+
+// HandleStatus handles GET /admin/rules
+func (r *RuleReloader) HandleStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, r.Status())
+}
+
+// HandleReload handles POST /admin/rules/reload
+func (r *RuleReloader) HandleReload(c *gin.Context) {
+	status, err := r.Reload()
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, httperr.ErrorResponse{
+			ErrorType: httperr.HttpRuleValidationError,
+			Message:   "Rule reload rejected; current rules are still active",
+			Details:   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+func ruleNames(rules []aggregation.AggregationRule) []string {
+	names := make([]string, 0, len(rules))
+	for _, rule := range rules {
+		names = append(names, rule.Name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,154 @@
+package aggregation
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aevon-lab/project-aevon/internal/core/aggregation"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingSubscriber struct {
+	mu    sync.Mutex
+	calls [][]aggregation.AggregationRule
+}
+
+func (s *recordingSubscriber) SetRules(rules []aggregation.AggregationRule) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = append(s.calls, rules)
+}
+
+func (s *recordingSubscriber) callCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.calls)
+}
+
+func writeRuleFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+}
+
+func newTestReloader(t *testing.T, requireRules bool) (*RuleReloader, *recordingSubscriber, string) {
+	t.Helper()
+	dir := t.TempDir()
+	writeRuleFile(t, dir, "count.yaml", "name: count_requests\nsource_event: api.request\noperator: count\n")
+
+	repo, err := aggregation.NewFileSystemRuleRepository(dir)
+	require.NoError(t, err)
+
+	subscriber := &recordingSubscriber{}
+	return NewRuleReloader(repo, requireRules, subscriber), subscriber, dir
+}
+
+func TestRuleReloader_ReloadSwapsRulesIntoSubscribers(t *testing.T) {
+	reloader, subscriber, dir := newTestReloader(t, false)
+	writeRuleFile(t, dir, "sum.yaml", "name: sum_bytes\nsource_event: api.request\noperator: sum\nfield: bytes\n")
+
+	status, err := reloader.Reload()
+	require.NoError(t, err)
+	require.Equal(t, []string{"count_requests", "sum_bytes"}, status.Rules)
+	require.Empty(t, status.LastError)
+	require.Len(t, subscriber.calls, 1)
+	require.Len(t, subscriber.calls[0], 2)
+}
+
+func TestRuleReloader_RejectedReloadKeepsRules(t *testing.T) {
+	reloader, subscriber, dir := newTestReloader(t, true)
+	writeRuleFile(t, dir, "bad.yaml", "name: bad\nsource_event: api.request\noperator: median\n")
+
+	status, err := reloader.Reload()
+	require.Error(t, err)
+	require.Contains(t, status.LastError, "unsupported operator")
+	require.Equal(t, []string{"count_requests"}, status.Rules)
+	require.Empty(t, subscriber.calls)
+
+	// require_rules rejects a reload that would leave no rules.
+	require.NoError(t, os.Remove(filepath.Join(dir, "bad.yaml")))
+	require.NoError(t, os.Remove(filepath.Join(dir, "count.yaml")))
+	_, err = reloader.Reload()
+	require.Error(t, err)
+	require.Empty(t, subscriber.calls)
+}
+
+func TestRuleReloader_AdminEndpoints(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	reloader, _, dir := newTestReloader(t, false)
+
+	r := gin.New()
+	reloader.RegisterRoutes(r)
+
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/admin/rules", nil))
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	var status ReloadStatus
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &status))
+	require.Equal(t, []string{"count_requests"}, status.Rules)
+
+	writeRuleFile(t, dir, "bad.yaml", "name: bad\nsource_event: ''\noperator: count\n")
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/admin/rules/reload", nil))
+	require.Equal(t, http.StatusUnprocessableEntity, resp.Code)
+	require.Contains(t, resp.Body.String(), "source_event must not be empty")
+
+	require.NoError(t, os.Remove(filepath.Join(dir, "bad.yaml")))
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/admin/rules/reload", nil))
+	require.Equal(t, http.StatusOK, resp.Code)
+}
+
+func TestRuleReloader_WatchReloadsOnFileChange(t *testing.T) {
+	reloader, subscriber, dir := newTestReloader(t, false)
+	reloader.debounce = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- reloader.Watch(ctx) }()
+	defer func() {
+		cancel()
+		require.NoError(t, <-done)
+	}()
+
+	// Give the watcher a moment to register the directory before writing.
+	time.Sleep(50 * time.Millisecond)
+	writeRuleFile(t, dir, "sum.yaml", "name: sum_bytes\nsource_event: api.request\noperator: sum\nfield: bytes\n")
+
+	require.Eventually(t, func() bool { return subscriber.callCount() > 0 }, 2*time.Second, 10*time.Millisecond)
+	require.Equal(t, []string{"count_requests", "sum_bytes"}, reloader.Status().Rules)
+}
+
+func TestSchedulerGroup_SetRulesStartsAndStopsBucketSchedulers(t *testing.T) {
+	preAggStore := &mockPreAggStore{
+		checkpoints: map[string]int64{},
+		aggregates:  make(map[aggregation.AggregateKey]aggregation.AggregateState),
+	}
+	group := NewSchedulerGroup(time.Hour, &mockEventStore{}, preAggStore, []aggregation.AggregationRule{
+		{Name: "per_minute", SourceEvent: "api.request", Operator: aggregation.OpCount},
+	}, BatchJobParameter{BatchSize: 10, WorkerCount: 1})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- group.Start(ctx) }()
+
+	require.Eventually(t, func() bool { return len(group.BucketLabels()) == 1 }, time.Second, 5*time.Millisecond)
+	require.Equal(t, []string{"1m"}, group.BucketLabels())
+
+	group.SetRules([]aggregation.AggregationRule{
+		{Name: "per_hour", SourceEvent: "api.request", Operator: aggregation.OpCount,
+			WindowSize: time.Hour, BucketSizes: []time.Duration{time.Hour}},
+	})
+	require.Equal(t, []string{"1h"}, group.BucketLabels())
+
+	cancel()
+	require.NoError(t, <-done)
+}
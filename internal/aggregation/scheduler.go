@@ -2,21 +2,38 @@ package aggregation
 
 import (
 	"context"
+	"errors"
 	"log/slog"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/aevon-lab/project-aevon/internal/core/aggregation"
 	"github.com/aevon-lab/project-aevon/internal/core/storage"
 )
 
+// FlushObserver is notified after a scheduler flushed a batch of pre-aggregates, with
+// the distinct (principal, rule) scopes the batch touched. It runs on the scheduler's
+// goroutine, between batches; failures must be handled by the observer.
+type FlushObserver interface {
+	AfterFlush(ctx context.Context, bucketLabel string, scopes []aggregation.RangeScope)
+}
+
 // Scheduler runs batch aggregation jobs on a periodic interval.
 // It is stateless: each tick independently fetches events since last checkpoint.
+// In streaming mode it additionally keeps hot buckets of ingested events (see StreamingOptions).
 type Scheduler struct {
 	interval    time.Duration
 	eventStore  storage.EventStore
 	preAggStore PreAggregateStore
-	rules       []aggregation.AggregationRule
 	opts        BatchJobParameter
+	leader      *LeaderElector // nil runs without coordination
+	observers   []FlushObserver
+	streaming   StreamingOptions
+	hot         *hotBuckets // nil in batch mode
+
+	mu    sync.RWMutex // guards rules, which are swapped on rule reload
+	rules []aggregation.AggregationRule
 }
 
 // NewScheduler creates a cron scheduler for one bucket_size stream.
@@ -36,15 +53,100 @@ func NewScheduler(
 	}
 }
 
+// NewSchedulers creates one scheduler per distinct bucket size declared by rules.
+// Each scheduler only aggregates the rules that declare its bucket size and keeps its
+// own checkpoint, so a newly added bucket size backfills without affecting the others.
+// opts supplies batch size and worker count; its bucket fields are overridden.
+func NewSchedulers(
+	interval time.Duration,
+	eventStore storage.EventStore,
+	preAggStore PreAggregateStore,
+	rules []aggregation.AggregationRule,
+	opts BatchJobParameter,
+) []*Scheduler {
+	schedulers := make([]*Scheduler, 0, 1)
+	for _, bucketSize := range BucketSizes(rules) {
+		schedulers = append(schedulers, NewScheduler(
+			interval,
+			eventStore,
+			preAggStore,
+			rulesForBucket(rules, bucketSize),
+			bucketParameter(opts, bucketSize),
+		))
+	}
+	return schedulers
+}
+
+func rulesForBucket(rules []aggregation.AggregationRule, bucketSize time.Duration) []aggregation.AggregationRule {
+	bucketRules := make([]aggregation.AggregationRule, 0, len(rules))
+	for _, rule := range rules {
+		if rule.HasBucket(bucketSize) {
+			bucketRules = append(bucketRules, rule)
+		}
+	}
+	return bucketRules
+}
+
+func bucketParameter(opts BatchJobParameter, bucketSize time.Duration) BatchJobParameter {
+	opts.BucketSize = bucketSize
+	opts.BucketLabel = aggregation.BucketLabel(bucketSize)
+	return opts
+}
+
+// BucketSizes returns the distinct bucket sizes declared by rules, ascending.
+func BucketSizes(rules []aggregation.AggregationRule) []time.Duration {
+	seen := make(map[time.Duration]struct{})
+	var sizes []time.Duration
+	for _, rule := range rules {
+		for _, bucketSize := range rule.Buckets() {
+			if _, ok := seen[bucketSize]; ok {
+				continue
+			}
+			seen[bucketSize] = struct{}{}
+			sizes = append(sizes, bucketSize)
+		}
+	}
+	sort.Slice(sizes, func(i, j int) bool { return sizes[i] < sizes[j] })
+	return sizes
+}
+
+// SetRules replaces the rules aggregated by the scheduler. The batch in flight keeps
+// the rules it started with; the swap applies from the next batch on.
+func (s *Scheduler) SetRules(rules []aggregation.AggregationRule) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rules = rules
+	if s.hot != nil {
+		s.hot.setRules(rules)
+	}
+}
+
+// useStreaming switches the scheduler to streaming mode. Must be called before Start.
+func (s *Scheduler) useStreaming(opts StreamingOptions) {
+	s.streaming = opts
+	s.hot = newHotBuckets(s.currentRules(), s.opts)
+}
+
+func (s *Scheduler) currentRules() []aggregation.AggregationRule {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.rules
+}
+
 // Start begins periodic batch aggregation.
 // Runs until context is cancelled.
 func (s *Scheduler) Start(ctx context.Context) error {
+	if s.hot != nil {
+		return s.startStreaming(ctx)
+	}
+
 	ticker := time.NewTicker(s.interval)
 	defer ticker.Stop()
 
 	slog.Info("[Scheduler] Starting batch aggregation scheduler",
 		"interval", s.interval,
 		"bucket_size", s.opts.BucketLabel,
+		"partitions", s.opts.Partitions.String(),
 		"batch_size", s.opts.BatchSize,
 		"workers", s.opts.WorkerCount,
 	)
@@ -89,8 +191,19 @@ func (s *Scheduler) drainBacklog(ctx context.Context) {
 		default:
 		}
 
+		// Only the leader of this stream drains; other replicas stand by.
+		if s.leader != nil && !s.leader.IsLeader(SchedulerLease(s.opts.streamLabel())) {
+			return
+		}
+
 		// Run one batch
-		eventsProcessed, err := RunBatchAggregationWithOptionsReturningCount(ctx, s.eventStore, s.preAggStore, s.rules, s.opts)
+		eventsProcessed, flushed, err := runBatch(ctx, s.eventStore, s.preAggStore, s.currentRules(), s.opts)
+		if errors.Is(err, aggregation.ErrRuleFingerprintMismatch) {
+			slog.Info("[Scheduler] Rules changed during batch; retrying with current rules on next tick",
+				"bucket_size", s.opts.BucketLabel,
+			)
+			return
+		}
 		if err != nil {
 			slog.Error("[Scheduler] Batch aggregation failed",
 				"error", err,
@@ -101,6 +214,12 @@ func (s *Scheduler) drainBacklog(ctx context.Context) {
 		}
 
 		batchCount++
+		if len(s.observers) > 0 && len(flushed) > 0 {
+			scopes := flushedScopes(flushed)
+			for _, observer := range s.observers {
+				observer.AfterFlush(ctx, s.opts.BucketLabel, scopes)
+			}
+		}
 
 		// If batch processed fewer events than batch size, backlog is drained
 		if eventsProcessed < s.opts.BatchSize {
@@ -127,3 +246,24 @@ func (s *Scheduler) drainBacklog(ctx context.Context) {
 		"note", "Will resume on next tick",
 	)
 }
+
+// flushedScopes returns the distinct (principal, rule) pairs of aggregates, sorted.
+func flushedScopes(aggregates map[aggregation.AggregateKey]aggregation.AggregateState) []aggregation.RangeScope {
+	seen := make(map[aggregation.RangeScope]struct{}, len(aggregates))
+	scopes := make([]aggregation.RangeScope, 0, len(aggregates))
+	for key := range aggregates {
+		scope := aggregation.RangeScope{PrincipalID: key.PrincipalID, RuleName: key.RuleName}
+		if _, ok := seen[scope]; ok {
+			continue
+		}
+		seen[scope] = struct{}{}
+		scopes = append(scopes, scope)
+	}
+	sort.Slice(scopes, func(i, j int) bool {
+		if scopes[i].RuleName != scopes[j].RuleName {
+			return scopes[i].RuleName < scopes[j].RuleName
+		}
+		return scopes[i].PrincipalID < scopes[j].PrincipalID
+	})
+	return scopes
+}
@@ -0,0 +1,202 @@
+package aggregation
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	v1 "github.com/aevon-lab/project-aevon/internal/api/v1"
+	"github.com/aevon-lab/project-aevon/internal/core/aggregation"
+	"github.com/aevon-lab/project-aevon/internal/core/partition"
+	"github.com/aevon-lab/project-aevon/internal/core/storage"
+)
+
+// SchedulerGroup runs one Scheduler per bucket size declared by the rules, or one per
+// partition range of each bucket size when sharded, and keeps that set in step with rule
+// reloads: schedulers for new bucket sizes are started, schedulers whose bucket size is
+// no longer declared are stopped after a final drain.
+type SchedulerGroup struct {
+	interval    time.Duration
+	eventStore  storage.EventStore
+	preAggStore PreAggregateStore
+	opts        BatchJobParameter
+	leader      *LeaderElector // nil runs without coordination
+	observers   []FlushObserver
+	streaming   *StreamingOptions // nil runs batch schedulers
+	shards      []partition.Range // partition ranges drained independently per bucket size
+
+	mu      sync.Mutex
+	ctx     context.Context // set by Start; nil until the group runs
+	wg      sync.WaitGroup
+	rules   []aggregation.AggregationRule
+	running map[time.Duration]*groupMember
+}
+
+type groupMember struct {
+	schedulers []*Scheduler // one per shard
+	cancel     context.CancelFunc
+}
+
+// NewSchedulerGroup creates a group for rules. opts supplies batch size and worker
+// count; bucket fields are set per scheduler.
+func NewSchedulerGroup(
+	interval time.Duration,
+	eventStore storage.EventStore,
+	preAggStore PreAggregateStore,
+	rules []aggregation.AggregationRule,
+	opts BatchJobParameter,
+) *SchedulerGroup {
+	return &SchedulerGroup{
+		interval:    interval,
+		eventStore:  eventStore,
+		preAggStore: preAggStore,
+		opts:        opts,
+		rules:       rules,
+		shards:      partition.Split(1),
+		running:     make(map[time.Duration]*groupMember),
+	}
+}
+
+// UsePartitionShards splits every bucket size into n partition ranges, each drained by
+// its own scheduler with its own checkpoints. Must be called before Start.
+func (g *SchedulerGroup) UsePartitionShards(n int) {
+	g.shards = partition.Split(n)
+}
+
+// UseLeaderElector makes every scheduler campaign for the lease of its bucket size and
+// drain only while it leads. Must be called before Start.
+func (g *SchedulerGroup) UseLeaderElector(leader *LeaderElector) {
+	g.leader = leader
+}
+
+// UseFlushObserver adds an observer notified after every batch flushed by any scheduler
+// of the group. Must be called before Start.
+func (g *SchedulerGroup) UseFlushObserver(observer FlushObserver) {
+	g.observers = append(g.observers, observer)
+}
+
+// UseStreaming runs every scheduler in streaming mode; events must then be handed to
+// the group through EventsIngested. Must be called before Start.
+func (g *SchedulerGroup) UseStreaming(opts StreamingOptions) {
+	g.streaming = &opts
+}
+
+// EventsIngested hands persisted events to the schedulers of the group. It implements
+// ingestion.EventObserver and is a no-op in batch mode.
+func (g *SchedulerGroup) EventsIngested(events []*v1.Event) {
+	if g.streaming == nil {
+		return
+	}
+
+	g.mu.Lock()
+	schedulers := make([]*Scheduler, 0, len(g.running)*len(g.shards))
+	for _, member := range g.running {
+		schedulers = append(schedulers, member.schedulers...)
+	}
+	g.mu.Unlock()
+
+	for _, scheduler := range schedulers {
+		scheduler.EventsIngested(events)
+	}
+}
+
+// Start runs the schedulers until ctx is cancelled, then waits for their final drains.
+func (g *SchedulerGroup) Start(ctx context.Context) error {
+	g.mu.Lock()
+	g.ctx = ctx
+	g.reconcileLocked()
+	g.mu.Unlock()
+
+	<-ctx.Done()
+	g.wg.Wait()
+	return nil
+}
+
+// SetRules swaps the rule set of every scheduler, starting and stopping schedulers
+// as bucket sizes appear and disappear.
+func (g *SchedulerGroup) SetRules(rules []aggregation.AggregationRule) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.rules = rules
+	if g.ctx != nil {
+		g.reconcileLocked()
+	}
+}
+
+// BucketLabels returns the bucket sizes currently being aggregated, ascending.
+func (g *SchedulerGroup) BucketLabels() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	labels := make([]string, 0, len(g.running))
+	for _, bucketSize := range BucketSizes(g.rules) {
+		if _, ok := g.running[bucketSize]; ok {
+			labels = append(labels, aggregation.BucketLabel(bucketSize))
+		}
+	}
+	return labels
+}
+
+func (g *SchedulerGroup) reconcileLocked() {
+	if g.ctx.Err() != nil {
+		return
+	}
+
+	wanted := make(map[time.Duration]struct{})
+	for _, bucketSize := range BucketSizes(g.rules) {
+		wanted[bucketSize] = struct{}{}
+		bucketRules := rulesForBucket(g.rules, bucketSize)
+
+		if member, ok := g.running[bucketSize]; ok {
+			for _, scheduler := range member.schedulers {
+				scheduler.SetRules(bucketRules)
+			}
+			continue
+		}
+
+		bucketCtx, cancel := context.WithCancel(g.ctx)
+		member := &groupMember{cancel: cancel}
+		for _, shard := range g.shards {
+			opts := bucketParameter(g.opts, bucketSize)
+			opts.Partitions = shard
+			scheduler := NewScheduler(g.interval, g.eventStore, g.preAggStore, bucketRules, opts)
+			scheduler.leader = g.leader
+			scheduler.observers = g.observers
+			if g.streaming != nil {
+				scheduler.useStreaming(*g.streaming)
+			}
+			member.schedulers = append(member.schedulers, scheduler)
+			g.startScheduler(bucketCtx, scheduler)
+		}
+		g.running[bucketSize] = member
+	}
+
+	for bucketSize, member := range g.running {
+		if _, ok := wanted[bucketSize]; ok {
+			continue
+		}
+		slog.Info("[Scheduler] Bucket size no longer declared by any rule, stopping",
+			"bucket_size", aggregation.BucketLabel(bucketSize),
+		)
+		member.cancel()
+		delete(g.running, bucketSize)
+	}
+}
+
+func (g *SchedulerGroup) startScheduler(ctx context.Context, scheduler *Scheduler) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		lease := SchedulerLease(scheduler.opts.streamLabel())
+		if g.leader != nil {
+			g.leader.Campaign(ctx, lease)
+			// Released only after the final drain, so it still runs under the lease.
+			defer g.leader.Resign(lease)
+		}
+		if err := scheduler.Start(ctx); err != nil {
+			slog.Error("Scheduler stopped with error", "stream", scheduler.opts.streamLabel(), "error", err)
+		}
+	}()
+}
@@ -0,0 +1,407 @@
+package aggregation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	v1 "github.com/aevon-lab/project-aevon/internal/api/v1"
+	"github.com/aevon-lab/project-aevon/internal/core/aggregation"
+	"github.com/aevon-lab/project-aevon/internal/core/partition"
+)
+
+// StreamingOptions switches schedulers to streaming mode: events persisted by this
+// process are folded into in-memory hot buckets as they are ingested and flushed every
+// FlushInterval, instead of being read back from the event log on the next tick.
+//
+// Hot buckets only ever hold a contiguous run of ingest_seq after the durable
+// checkpoint, so flushing them keeps the checkpoint invariant. Everything the process
+// did not see itself (events ingested by other replicas, events from before a restart)
+// is still aggregated by the batch drain on every scheduler interval. A gap in
+// ingest_seq that persists for GapTimeout is read from the event log: most are holes
+// left by duplicate inserts and rolled back transactions, which the hot buckets skip;
+// events found in the gap are folded. Only gaps too large to read that way, or a failed
+// read, fall back to the batch drain.
+type StreamingOptions struct {
+	FlushInterval time.Duration
+	GapTimeout    time.Duration
+}
+
+// hotBuckets holds the aggregates of events ingested by this process since the last
+// flush. Flushes merge them into durable pre-aggregates as a delta, so recovery needs
+// only the checkpoint: unflushed events are re-read from the event log.
+type hotBuckets struct {
+	opts    BatchJobParameter
+	maxSize int // pending events kept while waiting for a gap or a resync
+
+	mu         sync.Mutex
+	synced     bool  // cursor continues the durable checkpoint; false until resync
+	flushed    int64 // durable checkpoint the aggregates are a delta on
+	cursor     int64 // last ingest_seq folded into aggregates
+	aggregates map[aggregation.AggregateKey]aggregation.AggregateState
+	pending    map[int64]*v1.Event // ingested after a gap in ingest_seq, by ingest_seq
+	gapSince   time.Time           // when the gap before pending was first seen
+	ruleMap    map[string][]compiledRule
+}
+
+func newHotBuckets(rules []aggregation.AggregationRule, opts BatchJobParameter) *hotBuckets {
+	return &hotBuckets{
+		opts:       opts,
+		maxSize:    opts.BatchSize,
+		aggregates: make(map[aggregation.AggregateKey]aggregation.AggregateState),
+		pending:    make(map[int64]*v1.Event),
+		ruleMap:    toCompiledRuleMap(rules, opts.BucketSize),
+	}
+}
+
+// add folds events into the hot buckets, keeping events after a gap pending.
+func (h *hotBuckets) add(events []*v1.Event, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, evt := range events {
+		if evt.IngestSeq <= 0 || (h.synced && evt.IngestSeq <= h.cursor) {
+			continue
+		}
+		h.pending[evt.IngestSeq] = evt
+	}
+	if len(h.pending) > h.maxSize {
+		// The batch drain reads them back from the event log.
+		h.desyncLocked()
+		return
+	}
+	if h.synced {
+		h.foldLocked(now)
+	}
+}
+
+// foldLocked folds the pending events that continue cursor.
+func (h *hotBuckets) foldLocked(now time.Time) {
+	for {
+		evt, ok := h.pending[h.cursor+1]
+		if !ok {
+			break
+		}
+		h.foldEventLocked(evt, now)
+	}
+
+	if len(h.pending) == 0 {
+		h.gapSince = time.Time{}
+	} else if h.gapSince.IsZero() {
+		h.gapSince = now
+	}
+}
+
+// foldEventLocked folds evt and moves cursor to it.
+func (h *hotBuckets) foldEventLocked(evt *v1.Event, now time.Time) {
+	delete(h.pending, evt.IngestSeq)
+	h.cursor = evt.IngestSeq
+	if h.opts.Partitions.Contains(partition.For(evt.PrincipalID)) {
+		mergeGroupAggregates(h.aggregates, []*v1.Event{evt}, h.ruleMap, h.opts, now)
+	}
+}
+
+// sync restarts the hot buckets at the durable checkpoint.
+func (h *hotBuckets) sync(checkpoint int64, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.synced = true
+	h.flushed = checkpoint
+	h.cursor = checkpoint
+	h.aggregates = make(map[aggregation.AggregateKey]aggregation.AggregateState)
+	for seq := range h.pending {
+		if seq <= checkpoint {
+			delete(h.pending, seq)
+		}
+	}
+	h.gapSince = time.Time{}
+	h.foldLocked(now)
+}
+
+// desync drops the hot aggregates; events ingested from now on are kept pending until
+// the next resync.
+func (h *hotBuckets) desync() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.desyncLocked()
+}
+
+func (h *hotBuckets) desyncLocked() {
+	h.synced = false
+	h.aggregates = make(map[aggregation.AggregateKey]aggregation.AggregateState)
+	h.pending = make(map[int64]*v1.Event)
+	h.gapSince = time.Time{}
+}
+
+func (h *hotBuckets) setRules(rules []aggregation.AggregationRule) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.ruleMap = toCompiledRuleMap(rules, h.opts.BucketSize)
+	// Folded aggregates carry the fingerprints of the replaced rules.
+	h.desyncLocked()
+}
+
+// take hands out the folded aggregates as the delta from the durable checkpoint from
+// to cursor to. ok is false if there is nothing to flush.
+func (h *hotBuckets) take() (aggregates map[aggregation.AggregateKey]aggregation.AggregateState, from, to int64, ok bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.synced || h.cursor == h.flushed {
+		return nil, 0, 0, false
+	}
+	aggregates, from, to = h.aggregates, h.flushed, h.cursor
+	finalizeAggregates(aggregates)
+	h.aggregates = make(map[aggregation.AggregateKey]aggregation.AggregateState)
+	h.flushed = h.cursor
+	return aggregates, from, to, true
+}
+
+// needsCatchUp reports whether the batch drain has to run: the hot buckets are not
+// synced, or a gap in ingest_seq was not filled within gapTimeout.
+func (h *hotBuckets) needsCatchUp(now time.Time, gapTimeout time.Duration) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return !h.synced || (!h.gapSince.IsZero() && now.Sub(h.gapSince) >= gapTimeout)
+}
+
+// timedOutGap returns the gap in ingest_seq after cursor that was not filled within
+// gapTimeout; next is the first pending ingest_seq after it.
+func (h *hotBuckets) timedOutGap(now time.Time, gapTimeout time.Duration) (cursor, next int64, ok bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.synced || h.gapSince.IsZero() || now.Sub(h.gapSince) < gapTimeout {
+		return 0, 0, false
+	}
+	for seq := range h.pending {
+		if next == 0 || seq < next {
+			next = seq
+		}
+	}
+	return h.cursor, next, next > 0
+}
+
+// closeGap folds the events read from the event log in the gap between cursor and next
+// and moves past the ingest_seq no event holds. It does nothing if the hot buckets moved
+// on since the gap was read; the next flush tick looks at them again.
+func (h *hotBuckets) closeGap(cursor, next int64, events []*v1.Event, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.synced || h.cursor != cursor {
+		return
+	}
+	for _, evt := range events {
+		if evt.IngestSeq > cursor && evt.IngestSeq < next {
+			h.foldEventLocked(evt, now)
+		}
+	}
+	h.cursor = next - 1
+	// A gap after next is timed from now.
+	h.gapSince = time.Time{}
+	h.foldLocked(now)
+}
+
+// EventsIngested folds persisted events into the scheduler's hot buckets. It is a no-op
+// unless the scheduler runs in streaming mode and leads its stream.
+func (s *Scheduler) EventsIngested(events []*v1.Event) {
+	if s.hot == nil {
+		return
+	}
+	if s.leader != nil && !s.leader.IsLeader(SchedulerLease(s.opts.streamLabel())) {
+		s.hot.desync()
+		return
+	}
+	s.hot.add(events, time.Now().UTC())
+}
+
+// startStreaming runs the scheduler in streaming mode: hot buckets are flushed every
+// flush interval, and the batch drain runs on the scheduler interval and whenever the
+// hot buckets fall out of step with the event log.
+func (s *Scheduler) startStreaming(ctx context.Context) error {
+	flushTicker := time.NewTicker(s.streaming.FlushInterval)
+	defer flushTicker.Stop()
+	drainTicker := time.NewTicker(s.interval)
+	defer drainTicker.Stop()
+
+	slog.Info("[Scheduler] Starting streaming aggregation",
+		"flush_interval", s.streaming.FlushInterval,
+		"gap_timeout", s.streaming.GapTimeout,
+		"drain_interval", s.interval,
+		"bucket_size", s.opts.BucketLabel,
+		"partitions", s.opts.Partitions.String(),
+	)
+
+	s.catchUp(ctx)
+
+	for {
+		select {
+		case <-flushTicker.C:
+			s.flushTick(ctx, time.Now().UTC())
+		case <-drainTicker.C:
+			s.catchUp(ctx)
+		case <-ctx.Done():
+			slog.Info("[Scheduler] Stopping (context cancelled)", "bucket_size", s.opts.BucketLabel)
+
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			s.flushHot(shutdownCtx)
+			s.hot.desync()
+			s.drainBacklog(shutdownCtx)
+			slog.Info("[Scheduler] Final drain complete", "bucket_size", s.opts.BucketLabel)
+			return nil
+		}
+	}
+}
+
+// flushTick flushes the hot buckets and brings them back in step with the event log if
+// they fell out of it.
+func (s *Scheduler) flushTick(ctx context.Context, now time.Time) {
+	s.flushHot(ctx)
+	if !s.hot.needsCatchUp(now, s.streaming.GapTimeout) {
+		return
+	}
+	if !s.skipGap(ctx, now) {
+		s.catchUp(ctx)
+	}
+}
+
+// skipGap reads a gap in ingest_seq that outlived the gap timeout from the event log and
+// moves the hot buckets past it, folding any event found in it. Duplicate inserts and
+// rolled back transactions leave such holes for good. It returns false if the gap has to
+// be left to the batch drain.
+func (s *Scheduler) skipGap(ctx context.Context, now time.Time) bool {
+	if s.leader != nil && !s.leader.IsLeader(SchedulerLease(s.opts.streamLabel())) {
+		return false
+	}
+	cursor, next, ok := s.hot.timedOutGap(now, s.streaming.GapTimeout)
+	if !ok || next-cursor-1 > int64(s.hot.maxSize) {
+		return false
+	}
+	// The gap holds at most next-cursor-1 events, so one read sees all of them.
+	events, err := s.eventStore.RetrieveEventsAfterCursor(ctx, cursor, int(next-cursor-1))
+	if err != nil {
+		slog.Warn("[Scheduler] Failed to read an ingest_seq gap from the event log; draining it",
+			"bucket_size", s.opts.BucketLabel,
+			"cursor", cursor,
+			"error", err,
+		)
+		return false
+	}
+	s.hot.closeGap(cursor, next, events, now)
+	slog.Debug("[Scheduler] Closed ingest_seq gap from the event log",
+		"gap", fmt.Sprintf("%d -> %d", cursor, next),
+		"bucket_size", s.opts.BucketLabel,
+	)
+	return true
+}
+
+// catchUp flushes the hot buckets, drains the event log like a batch scheduler and
+// restarts the hot buckets at the new checkpoint.
+func (s *Scheduler) catchUp(ctx context.Context) {
+	if s.leader != nil && !s.leader.IsLeader(SchedulerLease(s.opts.streamLabel())) {
+		s.hot.desync()
+		return
+	}
+
+	s.flushHot(ctx)
+	s.hot.desync()
+	s.drainBacklog(ctx)
+
+	checkpoint, ok, err := s.readStreamCheckpoint(ctx)
+	if err != nil {
+		slog.Warn("[Scheduler] Failed to read checkpoint; streaming resumes after the next drain",
+			"bucket_size", s.opts.BucketLabel,
+			"error", err,
+		)
+		return
+	}
+	if !ok {
+		// Partitions of the range are at different checkpoints after a re-split; the
+		// batch drain evens them out.
+		return
+	}
+	s.hot.sync(checkpoint, time.Now().UTC())
+}
+
+// flushHot flushes the hot buckets through the pre-aggregate store.
+func (s *Scheduler) flushHot(ctx context.Context) {
+	aggregates, from, to, ok := s.hot.take()
+	if !ok {
+		return
+	}
+
+	var err error
+	if partitioned, isPartitioned := s.preAggStore.(PartitionedPreAggregateStore); isPartitioned {
+		err = partitioned.FlushPartitions(ctx, aggregates, s.opts.BucketLabel, uniformCheckpoints(s.opts.Partitions, from), to)
+	} else {
+		err = s.preAggStore.Flush(ctx, aggregates, to, s.opts.BucketLabel)
+	}
+	if errors.Is(err, aggregation.ErrRuleFingerprintMismatch) {
+		slog.Info("[Scheduler] Rules changed since events were folded; re-reading them from the event log",
+			"bucket_size", s.opts.BucketLabel,
+		)
+		s.hot.desync()
+		return
+	}
+	if err != nil {
+		slog.Error("[Scheduler] Hot bucket flush failed; re-reading events from the event log",
+			"error", err,
+			"bucket_size", s.opts.BucketLabel,
+		)
+		s.hot.desync()
+		return
+	}
+
+	// Stores skip stale flushes without an error; only the checkpoint tells.
+	checkpoint, consistent, err := s.readStreamCheckpoint(ctx)
+	if err != nil || !consistent || checkpoint != to {
+		slog.Warn("[Scheduler] Hot bucket flush did not advance the checkpoint; re-reading events from the event log",
+			"bucket_size", s.opts.BucketLabel,
+			"cursor", to,
+			"checkpoint", checkpoint,
+		)
+		s.hot.desync()
+		return
+	}
+
+	slog.Debug("[Scheduler] Hot buckets flushed",
+		"aggregates", len(aggregates),
+		"cursor_advanced", fmt.Sprintf("%d -> %d", from, to),
+		"bucket_size", s.opts.BucketLabel,
+	)
+	if len(s.observers) > 0 && len(aggregates) > 0 {
+		scopes := flushedScopes(aggregates)
+		for _, observer := range s.observers {
+			observer.AfterFlush(ctx, s.opts.BucketLabel, scopes)
+		}
+	}
+}
+
+// readStreamCheckpoint returns the checkpoint of the scheduler's partitions. ok is false
+// if the partitions are at different checkpoints.
+func (s *Scheduler) readStreamCheckpoint(ctx context.Context) (checkpoint int64, ok bool, err error) {
+	if partitioned, isPartitioned := s.preAggStore.(PartitionedPreAggregateStore); isPartitioned {
+		checkpoints, err := partitioned.ReadPartitionCheckpoints(ctx, s.opts.BucketLabel, s.opts.Partitions)
+		if err != nil {
+			return 0, false, err
+		}
+		return checkpoints.Min(), checkpoints.Min() == checkpoints.Max(), nil
+	}
+	checkpoint, err = s.preAggStore.ReadCheckpoint(ctx, s.opts.BucketLabel)
+	return checkpoint, err == nil, err
+}
+
+func uniformCheckpoints(partitions partition.Range, cursor int64) aggregation.PartitionCheckpoints {
+	cursors := make([]int64, partitions.Len())
+	for i := range cursors {
+		cursors[i] = cursor
+	}
+	return aggregation.PartitionCheckpoints{Range: partitions, Cursors: cursors}
+}
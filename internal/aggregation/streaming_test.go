@@ -0,0 +1,180 @@
+package aggregation
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v1 "github.com/aevon-lab/project-aevon/internal/api/v1"
+	"github.com/aevon-lab/project-aevon/internal/core/aggregation"
+	"github.com/stretchr/testify/require"
+)
+
+// countingEventStore counts reads of the event log.
+type countingEventStore struct {
+	mockEventStore
+	reads int
+}
+
+func (m *countingEventStore) RetrieveEventsAfterCursor(ctx context.Context, cursor int64, limit int) ([]*v1.Event, error) {
+	m.reads++
+	return m.mockEventStore.RetrieveEventsAfterCursor(ctx, cursor, limit)
+}
+
+func newStreamingTestScheduler(eventStore *countingEventStore, preAggStore *mockPreAggStore) *Scheduler {
+	rules := []aggregation.AggregationRule{
+		{Name: "count_requests", SourceEvent: "api.request", Operator: aggregation.OpCount, WindowSize: time.Minute},
+	}
+	scheduler := NewScheduler(time.Minute, eventStore, preAggStore, rules, bucketParameter(BatchJobParameter{BatchSize: 100}, time.Minute))
+	scheduler.useStreaming(StreamingOptions{FlushInterval: time.Second, GapTimeout: 5 * time.Second})
+	return scheduler
+}
+
+func streamingTestEvent(seq int64, principalID string, occurredAt time.Time) *v1.Event {
+	return &v1.Event{
+		ID:          "evt-" + principalID,
+		PrincipalID: principalID,
+		Type:        "api.request",
+		OccurredAt:  occurredAt,
+		IngestSeq:   seq,
+		Data:        map[string]interface{}{},
+	}
+}
+
+func TestScheduler_StreamingFlushesContiguousEventsWithoutReadingTheLog(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Minute)
+	eventStore := &countingEventStore{}
+	preAggStore := &mockPreAggStore{
+		checkpoints: map[string]int64{},
+		aggregates:  make(map[aggregation.AggregateKey]aggregation.AggregateState),
+	}
+	scheduler := newStreamingTestScheduler(eventStore, preAggStore)
+	observer := &recordingFlushObserver{}
+	scheduler.observers = []FlushObserver{observer}
+
+	scheduler.catchUp(context.Background())
+	require.Equal(t, 1, eventStore.reads)
+
+	// Out of order within the run: seq 2 waits for seq 1.
+	scheduler.EventsIngested([]*v1.Event{streamingTestEvent(2, "user:alice", now)})
+	scheduler.EventsIngested([]*v1.Event{streamingTestEvent(1, "user:bob", now)})
+	scheduler.flushHot(context.Background())
+
+	require.Equal(t, 1, eventStore.reads)
+	require.Equal(t, int64(2), preAggStore.checkpoints["1m"])
+	require.Len(t, preAggStore.aggregates, 2)
+	require.Equal(t, []aggregation.RangeScope{
+		{PrincipalID: "user:alice", RuleName: "count_requests"},
+		{PrincipalID: "user:bob", RuleName: "count_requests"},
+	}, observer.scopes[0])
+
+	// Already flushed events are ignored.
+	scheduler.EventsIngested([]*v1.Event{streamingTestEvent(2, "user:alice", now)})
+	scheduler.flushHot(context.Background())
+	require.Len(t, observer.scopes, 1)
+	require.False(t, scheduler.hot.needsCatchUp(time.Now(), time.Second))
+}
+
+func TestScheduler_StreamingReadsGapsFromTheLog(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Minute)
+	eventStore := &countingEventStore{}
+	preAggStore := &mockPreAggStore{
+		checkpoints: map[string]int64{},
+		aggregates:  make(map[aggregation.AggregateKey]aggregation.AggregateState),
+	}
+	scheduler := newStreamingTestScheduler(eventStore, preAggStore)
+	scheduler.catchUp(context.Background())
+
+	// seq 2 was ingested by another replica; this process only saw 1 and 3.
+	events := []*v1.Event{
+		streamingTestEvent(1, "user:alice", now),
+		streamingTestEvent(2, "user:bob", now),
+		streamingTestEvent(3, "user:carol", now),
+	}
+	eventStore.events = events
+	scheduler.EventsIngested([]*v1.Event{events[0], events[2]})
+
+	scheduler.flushHot(context.Background())
+	require.Equal(t, int64(1), preAggStore.checkpoints["1m"])
+	require.False(t, scheduler.hot.needsCatchUp(time.Now().UTC(), time.Minute))
+	require.True(t, scheduler.hot.needsCatchUp(time.Now().UTC().Add(time.Minute), time.Minute))
+
+	scheduler.catchUp(context.Background())
+	require.Equal(t, int64(3), preAggStore.checkpoints["1m"])
+	require.Len(t, preAggStore.aggregates, 3)
+	require.False(t, scheduler.hot.needsCatchUp(time.Now().UTC().Add(time.Minute), time.Minute))
+
+	// Streaming resumes after the drained checkpoint.
+	scheduler.EventsIngested([]*v1.Event{streamingTestEvent(4, "user:dave", now)})
+	reads := eventStore.reads
+	scheduler.flushHot(context.Background())
+	require.Equal(t, int64(4), preAggStore.checkpoints["1m"])
+	require.Equal(t, reads, eventStore.reads)
+}
+
+func TestScheduler_StreamingSkipsHolesWithoutDrainingTheLog(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Minute)
+	eventStore := &countingEventStore{}
+	preAggStore := &mockPreAggStore{
+		checkpoints: map[string]int64{},
+		aggregates:  make(map[aggregation.AggregateKey]aggregation.AggregateState),
+	}
+	scheduler := newStreamingTestScheduler(eventStore, preAggStore)
+	scheduler.catchUp(context.Background())
+
+	first := streamingTestEvent(1, "user:alice", now)
+	eventStore.events = []*v1.Event{first}
+	scheduler.EventsIngested([]*v1.Event{first})
+	scheduler.flushTick(context.Background(), time.Now().UTC())
+	require.Equal(t, int64(1), preAggStore.checkpoints["1m"])
+
+	// A duplicate insert took seq 2 without storing an event.
+	third := streamingTestEvent(3, "user:bob", now)
+	eventStore.events = append(eventStore.events, third)
+	scheduler.EventsIngested([]*v1.Event{third})
+	scheduler.flushTick(context.Background(), time.Now().UTC())
+	require.Equal(t, int64(1), preAggStore.checkpoints["1m"])
+
+	// After the gap timeout one read of the gap confirms the hole. No drain runs: the
+	// checkpoint only moves with the next hot flush.
+	reads := eventStore.reads
+	scheduler.flushTick(context.Background(), time.Now().UTC().Add(time.Minute))
+	require.Equal(t, reads+1, eventStore.reads)
+	require.Equal(t, int64(1), preAggStore.checkpoints["1m"])
+	require.False(t, scheduler.hot.needsCatchUp(time.Now().UTC().Add(time.Minute), time.Minute))
+	scheduler.flushHot(context.Background())
+	require.Equal(t, int64(3), preAggStore.checkpoints["1m"])
+	require.Len(t, preAggStore.aggregates, 2)
+
+	// An event of another replica found in a gap is folded as well.
+	fourth, sixth := streamingTestEvent(4, "user:carol", now), streamingTestEvent(6, "user:dave", now)
+	eventStore.events = append(eventStore.events, fourth, sixth)
+	scheduler.EventsIngested([]*v1.Event{sixth})
+	scheduler.flushTick(context.Background(), time.Now().UTC().Add(time.Minute))
+	require.Equal(t, int64(3), preAggStore.checkpoints["1m"])
+	scheduler.flushHot(context.Background())
+	require.Equal(t, reads+2, eventStore.reads)
+	require.Equal(t, int64(6), preAggStore.checkpoints["1m"])
+	require.Len(t, preAggStore.aggregates, 4)
+}
+
+func TestScheduler_StreamingRuleChangeResyncs(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Minute)
+	eventStore := &countingEventStore{}
+	preAggStore := &mockPreAggStore{
+		checkpoints: map[string]int64{},
+		aggregates:  make(map[aggregation.AggregateKey]aggregation.AggregateState),
+	}
+	scheduler := newStreamingTestScheduler(eventStore, preAggStore)
+	scheduler.catchUp(context.Background())
+
+	scheduler.EventsIngested([]*v1.Event{streamingTestEvent(1, "user:alice", now)})
+	scheduler.SetRules([]aggregation.AggregationRule{
+		{Name: "count_requests", SourceEvent: "api.request", Operator: aggregation.OpCount, WindowSize: time.Minute, Fingerprint: "v2"},
+	})
+
+	// Events folded with the replaced rules are not flushed.
+	scheduler.flushHot(context.Background())
+	require.Zero(t, preAggStore.checkpoints["1m"])
+	require.True(t, scheduler.hot.needsCatchUp(time.Now().UTC(), time.Minute))
+}
@@ -0,0 +1,527 @@
+package aggregation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "github.com/aevon-lab/project-aevon/internal/api/v1"
+	"github.com/aevon-lab/project-aevon/internal/core/aggregation"
+	httperr "github.com/aevon-lab/project-aevon/internal/core/errors"
+	"github.com/aevon-lab/project-aevon/internal/core/partition"
+	"github.com/aevon-lab/project-aevon/internal/core/storage"
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	maxVerifyPrincipals = 1000
+
+	// maxRepairAttempts bounds how often a repair is re-verified after a flush moved the
+	// checkpoint in between.
+	maxRepairAttempts = 3
+)
+
+// Drift kinds.
+const (
+	DriftMismatch   = "mismatch"   // stored and recomputed bucket differ
+	DriftMissing    = "missing"    // events exist, but no bucket is stored
+	DriftUnexpected = "unexpected" // a bucket is stored, but no events count towards it
+)
+
+// ErrInvalidVerifyRequest is returned for verification requests that cannot be run.
+var ErrInvalidVerifyRequest = errors.New("invalid verify request")
+
+// ErrVerifyUnsupported is returned when the configured stores cannot verify or repair
+// pre-aggregates.
+var ErrVerifyUnsupported = errors.New("verification is not supported by the configured stores")
+
+// VerifyRequest selects the pre-aggregates to verify: one rule, a set of principals and
+// a range of buckets. The range must be aligned to every verified bucket size.
+type VerifyRequest struct {
+	Rule         string    `json:"rule"`
+	PrincipalIDs []string  `json:"principal_ids"`
+	Start        time.Time `json:"start"`
+	End          time.Time `json:"end"`
+	BucketSize   string    `json:"bucket_size,omitempty"` // default: every bucket size of the rule
+	Repair       bool      `json:"repair,omitempty"`      // replace drifted buckets with the recomputed ones
+}
+
+// DriftReport is the result of a verification.
+type DriftReport struct {
+	Rule         string         `json:"rule"`
+	PrincipalIDs []string       `json:"principal_ids"`
+	Start        time.Time      `json:"start"`
+	End          time.Time      `json:"end"`
+	Buckets      []BucketReport `json:"buckets"`
+	Drifted      int            `json:"drifted"`  // drifted buckets over all bucket sizes
+	Repaired     int            `json:"repaired"` // drifted buckets replaced by Repair
+}
+
+// BucketReport is the verification result of one bucket size.
+type BucketReport struct {
+	BucketSize string  `json:"bucket_size"`
+	Checked    int     `json:"checked"`           // buckets stored or recomputed
+	Skipped    string  `json:"skipped,omitempty"` // why the bucket size was not verified
+	Repaired   bool    `json:"repaired,omitempty"`
+	Drift      []Drift `json:"drift"`
+}
+
+// Drift is one bucket whose stored pre-aggregate differs from the event log.
+type Drift struct {
+	PrincipalID string      `json:"principal_id"`
+	WindowStart time.Time   `json:"window_start"`
+	Dimensions  string      `json:"dimensions,omitempty"`
+	Kind        string      `json:"kind"`
+	Checkpoint  int64       `json:"checkpoint"` // events up to this ingest_seq were recomputed
+	Stored      *DriftValue `json:"stored,omitempty"`
+	Expected    *DriftValue `json:"expected,omitempty"`
+}
+
+// DriftValue is the compared part of a bucket.
+type DriftValue struct {
+	Value      decimal.Decimal `json:"value"`
+	EventCount int64           `json:"event_count"`
+}
+
+// Verifier recomputes pre-aggregates from the event log with the same aggregators the
+// scheduler uses and compares them with the stored rows. Only events up to the
+// checkpoint the rows were read at are recomputed, so verification can run while the
+// schedulers keep flushing.
+type Verifier struct {
+	eventStore storage.EventStore
+	store      PreAggregateStore
+	opts       BatchJobParameter
+	nowFn      func() time.Time
+
+	mu    sync.RWMutex
+	rules []aggregation.AggregationRule
+}
+
+// NewVerifier creates a verifier for rules. opts supplies the batch size of event reads.
+func NewVerifier(
+	eventStore storage.EventStore,
+	store PreAggregateStore,
+	rules []aggregation.AggregationRule,
+	opts BatchJobParameter,
+) *Verifier {
+	return &Verifier{
+		eventStore: eventStore,
+		store:      store,
+		opts:       opts.normalized(),
+		nowFn:      func() time.Time { return time.Now().UTC() },
+		rules:      rules,
+	}
+}
+
+// SetRules replaces the rules verifications run against.
+func (v *Verifier) SetRules(rules []aggregation.AggregationRule) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.rules = rules
+}
+
+func (v *Verifier) rule(name string) (aggregation.AggregationRule, bool) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	for _, rule := range v.rules {
+		if rule.Name == name {
+			return rule, true
+		}
+	}
+	return aggregation.AggregationRule{}, false
+}
+
+func invalidVerifyf(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrInvalidVerifyRequest, fmt.Sprintf(format, args...))
+}
+
+// Verify compares the stored pre-aggregates selected by req with the event log and, if
+// req.Repair is set, replaces the drifted buckets.
+func (v *Verifier) Verify(ctx context.Context, req VerifyRequest) (*DriftReport, error) {
+	rule, bucketSizes, err := v.normalize(&req)
+	if err != nil {
+		return nil, err
+	}
+	reader, ok := v.store.(CheckpointedRangeReader)
+	if !ok {
+		return nil, fmt.Errorf("%w: pre-aggregate store cannot read pre-aggregates with checkpoints", ErrVerifyUnsupported)
+	}
+	repairer, canRepair := v.store.(AggregateRepairer)
+	if req.Repair && !canRepair {
+		return nil, fmt.Errorf("%w: pre-aggregate store cannot repair pre-aggregates", ErrVerifyUnsupported)
+	}
+
+	report := &DriftReport{
+		Rule:         rule.Name,
+		PrincipalIDs: req.PrincipalIDs,
+		Start:        req.Start,
+		End:          req.End,
+		Buckets:      make([]BucketReport, 0, len(bucketSizes)),
+	}
+	events := newVerifyEventCache(v.eventStore, rule, req.Start, req.End, v.opts.BatchSize)
+
+	for _, bucketSize := range bucketSizes {
+		bucketReport := BucketReport{BucketSize: aggregation.BucketLabel(bucketSize), Drift: []Drift{}}
+		if reason, err := v.skipReason(ctx, rule, bucketReport.BucketSize); err != nil {
+			return nil, err
+		} else if reason != "" {
+			bucketReport.Skipped = reason
+			report.Buckets = append(report.Buckets, bucketReport)
+			continue
+		}
+
+		for attempt := 1; ; attempt++ {
+			result, err := v.verifyBucket(ctx, reader, events, rule, bucketSize, req)
+			if err != nil {
+				return nil, err
+			}
+			bucketReport.Checked = result.checked
+			bucketReport.Drift = result.drift
+			if !req.Repair || len(result.drift) == 0 {
+				break
+			}
+
+			err = repairer.RepairAggregates(ctx, bucketReport.BucketSize, result.checkpoints, result.driftKeys, result.repairs)
+			if errors.Is(err, aggregation.ErrCheckpointMoved) && attempt < maxRepairAttempts {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("repair %s pre-aggregates of rule %s: %w", bucketReport.BucketSize, rule.Name, err)
+			}
+			bucketReport.Repaired = true
+			report.Repaired += len(result.drift)
+			slog.Info("[Verifier] Repaired drifted pre-aggregates",
+				"rule", rule.Name,
+				"bucket_size", bucketReport.BucketSize,
+				"buckets", len(result.drift),
+			)
+			break
+		}
+		report.Drifted += len(bucketReport.Drift)
+		report.Buckets = append(report.Buckets, bucketReport)
+	}
+	return report, nil
+}
+
+// normalize validates req, deduplicates its principals and returns the rule and the
+// bucket sizes to verify.
+func (v *Verifier) normalize(req *VerifyRequest) (aggregation.AggregationRule, []time.Duration, error) {
+	rule, ok := v.rule(req.Rule)
+	if !ok {
+		return rule, nil, invalidVerifyf("unknown rule: %q", req.Rule)
+	}
+
+	seen := make(map[string]struct{}, len(req.PrincipalIDs))
+	principalIDs := make([]string, 0, len(req.PrincipalIDs))
+	for _, principalID := range req.PrincipalIDs {
+		principalID = strings.TrimSpace(principalID)
+		if principalID == "" {
+			return rule, nil, invalidVerifyf("principal_ids must not contain empty values")
+		}
+		if _, ok := seen[principalID]; ok {
+			continue
+		}
+		seen[principalID] = struct{}{}
+		principalIDs = append(principalIDs, principalID)
+	}
+	if len(principalIDs) == 0 {
+		return rule, nil, invalidVerifyf("principal_ids is required")
+	}
+	if len(principalIDs) > maxVerifyPrincipals {
+		return rule, nil, invalidVerifyf("too many principals: %d (at most %d)", len(principalIDs), maxVerifyPrincipals)
+	}
+	sort.Strings(principalIDs)
+	req.PrincipalIDs = principalIDs
+
+	req.Start, req.End = req.Start.UTC(), req.End.UTC()
+	bucketSizes, err := rangeBucketSizes(rule, req.Start, req.End, req.BucketSize)
+	if err != nil {
+		return rule, nil, invalidVerifyf("%v", err)
+	}
+	return rule, bucketSizes, nil
+}
+
+// rangeBucketSizes returns the bucket sizes of rule to process over [start, end): the
+// one named by bucketLabel, or every bucket size of the rule if it is empty. The range
+// must be aligned to each of them.
+func rangeBucketSizes(rule aggregation.AggregationRule, start, end time.Time, bucketLabel string) ([]time.Duration, error) {
+	if start.IsZero() || end.IsZero() {
+		return nil, fmt.Errorf("start and end are required")
+	}
+	if !end.After(start) {
+		return nil, fmt.Errorf("end must be after start")
+	}
+
+	bucketSizes := rule.Buckets()
+	if bucketLabel != "" {
+		spec, err := aggregation.ParseWindowSize(bucketLabel)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bucket size %q: %v", bucketLabel, err)
+		}
+		if !rule.HasBucket(spec.Size) {
+			return nil, fmt.Errorf("rule %s has no %s buckets", rule.Name, bucketLabel)
+		}
+		bucketSizes = []time.Duration{spec.Size}
+	}
+	for _, bucketSize := range bucketSizes {
+		if !aggregation.BucketFor(start, bucketSize).Equal(start) || !aggregation.BucketFor(end, bucketSize).Equal(end) {
+			return nil, fmt.Errorf("start and end must be aligned to %s buckets", aggregation.BucketLabel(bucketSize))
+		}
+	}
+	return bucketSizes, nil
+}
+
+// skipReason returns why the rule's pre-aggregates at bucketLabel cannot be verified
+// right now, or "" if they can.
+func (v *Verifier) skipReason(ctx context.Context, rule aggregation.AggregationRule, bucketLabel string) (string, error) {
+	versions, ok := v.store.(RuleVersionStore)
+	if !ok {
+		return "", nil
+	}
+	list, err := versions.ListRuleVersions(ctx, rule.Name)
+	if err != nil {
+		return "", fmt.Errorf("list rule versions: %w", err)
+	}
+	for _, version := range list {
+		if version.BucketSize != bucketLabel {
+			continue
+		}
+		if version.Fingerprint != rule.Fingerprint {
+			return "rule definition changed; pre-aggregates are rebuilt once the new version is active", nil
+		}
+		if version.Rebuilding() {
+			return "pre-aggregates are being rebuilt", nil
+		}
+	}
+	return "", nil
+}
+
+// bucketResult is the comparison of one bucket size.
+type bucketResult struct {
+	checked     int
+	drift       []Drift
+	checkpoints map[int]int64 // partition checkpoints the stored rows were read at
+	driftKeys   []aggregation.AggregateKey
+	repairs     map[aggregation.AggregateKey]aggregation.AggregateState // recomputed drifted buckets
+}
+
+func (v *Verifier) verifyBucket(
+	ctx context.Context,
+	reader CheckpointedRangeReader,
+	events *verifyEventCache,
+	rule aggregation.AggregationRule,
+	bucketSize time.Duration,
+	req VerifyRequest,
+) (bucketResult, error) {
+	opts := bucketParameter(v.opts, bucketSize)
+	ruleMap := toCompiledRuleMap([]aggregation.AggregationRule{rule}, bucketSize)
+
+	snapshot, err := reader.QueryRangesWithCheckpoint(ctx, req.PrincipalIDs, []string{rule.Name}, opts.BucketLabel, req.Start, req.End)
+	if err != nil {
+		return bucketResult{}, fmt.Errorf("read %s pre-aggregates: %w", opts.BucketLabel, err)
+	}
+
+	result := bucketResult{
+		checkpoints: make(map[int]int64),
+		repairs:     make(map[aggregation.AggregateKey]aggregation.AggregateState),
+	}
+	for _, principalID := range req.PrincipalIDs {
+		scoped := snapshot[aggregation.RangeScope{PrincipalID: principalID, RuleName: rule.Name}]
+		result.checkpoints[partition.For(principalID)] = scoped.Checkpoint
+
+		principalEvents, err := events.through(ctx, principalID, scoped.Checkpoint)
+		if err != nil {
+			return bucketResult{}, err
+		}
+		recomputed := make(map[aggregation.AggregateKey]aggregation.AggregateState)
+		mergeGroupAggregates(recomputed, principalEvents, ruleMap, opts, v.nowFn())
+		finalizeAggregates(recomputed)
+
+		expected := make(map[aggregation.AggregateKey]aggregation.AggregateState, len(recomputed))
+		for key, state := range recomputed {
+			key.WindowStart = key.WindowStart.UTC()
+			if key.WindowStart.Before(req.Start) || !key.WindowStart.Before(req.End) {
+				continue
+			}
+			expected[key] = state
+		}
+		stored := make(map[aggregation.AggregateKey]aggregation.AggregateState, len(scoped.States))
+		for _, state := range scoped.States {
+			stored[aggregation.AggregateKey{
+				PartitionID: partition.For(principalID),
+				PrincipalID: principalID,
+				RuleName:    rule.Name,
+				BucketSize:  opts.BucketLabel,
+				WindowStart: state.WindowStart.UTC(),
+				Dimensions:  state.Dimensions,
+			}] = state
+		}
+
+		for _, key := range unionKeys(expected, stored) {
+			result.checked++
+			want, hasWant := expected[key]
+			got, hasGot := stored[key]
+			drift := Drift{
+				PrincipalID: principalID,
+				WindowStart: key.WindowStart,
+				Dimensions:  key.Dimensions,
+				Checkpoint:  scoped.Checkpoint,
+			}
+			switch {
+			case hasWant && hasGot:
+				if want.Value.Equal(got.Value) && want.EventCount == got.EventCount {
+					continue
+				}
+				drift.Kind = DriftMismatch
+			case hasWant:
+				drift.Kind = DriftMissing
+			default:
+				drift.Kind = DriftUnexpected
+			}
+			if hasGot {
+				drift.Stored = &DriftValue{Value: got.Value, EventCount: got.EventCount}
+			}
+			if hasWant {
+				drift.Expected = &DriftValue{Value: want.Value, EventCount: want.EventCount}
+				result.repairs[key] = want
+			}
+			result.drift = append(result.drift, drift)
+			result.driftKeys = append(result.driftKeys, key)
+		}
+	}
+	if result.drift == nil {
+		result.drift = []Drift{}
+	}
+	return result, nil
+}
+
+// unionKeys returns the keys of a and b, ordered by window start and dimensions.
+func unionKeys(a, b map[aggregation.AggregateKey]aggregation.AggregateState) []aggregation.AggregateKey {
+	keys := make([]aggregation.AggregateKey, 0, len(a)+len(b))
+	for key := range a {
+		keys = append(keys, key)
+	}
+	for key := range b {
+		if _, ok := a[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].WindowStart.Equal(keys[j].WindowStart) {
+			return keys[i].WindowStart.Before(keys[j].WindowStart)
+		}
+		return keys[i].Dimensions < keys[j].Dimensions
+	})
+	return keys
+}
+
+// verifyEventCache reads the scoped events of each principal once, extending them as
+// later bucket sizes or repair attempts need events up to a higher checkpoint.
+type verifyEventCache struct {
+	eventStore      storage.EventStore
+	eventType       string
+	includeIngested bool // the rule buckets late events at their ingestion time
+	start, end      time.Time
+	batchSize       int
+
+	events map[string][]*v1.Event // by principal, ordered by ingest_seq
+	cursor map[string]int64       // ingest_seq up to which each principal's events were read
+}
+
+func newVerifyEventCache(eventStore storage.EventStore, rule aggregation.AggregationRule, start, end time.Time, batchSize int) *verifyEventCache {
+	return &verifyEventCache{
+		eventStore:      eventStore,
+		eventType:       rule.SourceEvent,
+		includeIngested: rule.BucketsByIngestion(),
+		start:           start,
+		end:             end,
+		batchSize:       batchSize,
+		events:          make(map[string][]*v1.Event),
+		cursor:          make(map[string]int64),
+	}
+}
+
+// through returns the principal's events with an ingest_seq up to checkpoint.
+func (c *verifyEventCache) through(ctx context.Context, principalID string, checkpoint int64) ([]*v1.Event, error) {
+	for c.cursor[principalID] < checkpoint {
+		page, err := c.eventStore.RetrieveScopedEventsAfterCursor(
+			ctx, c.cursor[principalID], principalID, c.eventType, c.start, c.end, c.includeIngested, c.batchSize,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("read events of %s: %w", principalID, err)
+		}
+		c.events[principalID] = append(c.events[principalID], page...)
+		if len(page) < c.batchSize {
+			// Every event up to checkpoint is persisted, so the scope is read through it.
+			c.cursor[principalID] = max(c.cursor[principalID], checkpoint, lastIngestSeq(page))
+			break
+		}
+		c.cursor[principalID] = lastIngestSeq(page)
+	}
+
+	all := c.events[principalID]
+	n := sort.Search(len(all), func(i int) bool { return all[i].IngestSeq > checkpoint })
+	return all[:n], nil
+}
+
+func lastIngestSeq(events []*v1.Event) int64 {
+	if len(events) == 0 {
+		return 0
+	}
+	return events[len(events)-1].IngestSeq
+}
+
+// RegisterRoutes registers the verification admin API route on the given router.
+func (v *Verifier) RegisterRoutes(router gin.IRouter) {
+	router.POST("/admin/verify", v.HandleVerify)
+}
+
+// HandleVerify handles POST /admin/verify
+func (v *Verifier) HandleVerify(c *gin.Context) {
+	var req VerifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, httperr.ErrorResponse{
+			ErrorType: httperr.HttpInvalidJsonError,
+			Message:   "Invalid verify request",
+			Details:   err.Error(),
+		})
+		return
+	}
+
+	report, err := v.Verify(c.Request.Context(), req)
+	switch {
+	case errors.Is(err, ErrInvalidVerifyRequest):
+		c.JSON(http.StatusBadRequest, httperr.ErrorResponse{
+			ErrorType: httperr.HttpVerifyValidationError,
+			Message:   "Invalid verify request",
+			Details:   err.Error(),
+		})
+	case errors.Is(err, ErrVerifyUnsupported):
+		c.JSON(http.StatusNotImplemented, httperr.ErrorResponse{
+			ErrorType: httperr.HttpUnsupportedError,
+			Message:   "Verification is not supported",
+			Details:   err.Error(),
+		})
+	case errors.Is(err, aggregation.ErrCheckpointMoved):
+		c.JSON(http.StatusConflict, httperr.ErrorResponse{
+			ErrorType: httperr.HttpVerifyConflictError,
+			Message:   "Pre-aggregates kept changing during repair; retry",
+			Details:   err.Error(),
+		})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, httperr.ErrorResponse{
+			ErrorType: httperr.HttpInternalError,
+			Message:   "Verification failed",
+			Details:   err.Error(),
+		})
+	default:
+		c.JSON(http.StatusOK, report)
+	}
+}
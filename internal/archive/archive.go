@@ -0,0 +1,310 @@
+package archive
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "github.com/aevon-lab/project-aevon/internal/api/v1"
+	"github.com/klauspost/compress/zstd"
+)
+
+// FormatNDJSONZstd is the format of archive files: one JSON event per line, zstd
+// compressed. The manifest records the format so others, e.g. Parquet, can be added.
+const FormatNDJSONZstd = "ndjson+zstd"
+
+const manifestName = "manifest.json"
+
+// Manifest describes the archive of one event partition. It is written after all of
+// its files, so an archive without a manifest is incomplete and ignored.
+type Manifest struct {
+	Partition      string     `json:"partition"`
+	From           time.Time  `json:"from"`
+	To             time.Time  `json:"to"`
+	Format         string     `json:"format"`
+	Rows           int64      `json:"rows"`
+	FirstIngestSeq int64      `json:"first_ingest_seq"`
+	LastIngestSeq  int64      `json:"last_ingest_seq"`
+	Files          []FileInfo `json:"files"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// FileInfo describes one archive file. Events of a file are ordered by ingest_seq.
+type FileInfo struct {
+	Name           string  `json:"name"`
+	Rows           int64   `json:"rows"`
+	FirstIngestSeq int64   `json:"first_ingest_seq"`
+	LastIngestSeq  int64   `json:"last_ingest_seq"`
+	Bytes          int64   `json:"bytes"`
+	SHA256         string  `json:"sha256"` // of the compressed file
+	Blocks         []Block `json:"blocks,omitempty"`
+}
+
+// Block is one zstd frame of an archive file. A file is a series of frames, so a read
+// starts decompressing at the block holding its cursor instead of at the file start.
+type Block struct {
+	Offset         int64 `json:"offset"` // in the compressed file
+	FirstIngestSeq int64 `json:"first_ingest_seq"`
+}
+
+// record is the archived form of an event. Unlike the API form it keeps ingest_seq.
+type record struct {
+	v1.Event
+	IngestSeq int64 `json:"ingest_seq"`
+}
+
+// fileRef locates an archive file.
+type fileRef struct {
+	partition string
+	info      FileInfo
+	// maxLastSeq is the highest LastIngestSeq of this and all earlier files of the index,
+	// so the first file after a cursor is found by binary search.
+	maxLastSeq int64
+}
+
+// name is the object name of the file.
+func (r fileRef) name() string {
+	return r.partition + "/" + r.info.Name
+}
+
+// key identifies the content of the file for checksum verification.
+func (r fileRef) key() string {
+	return r.name() + "@" + r.info.SHA256
+}
+
+// Archive holds archived event partitions in an ObjectStore, one prefix per partition
+// holding its files and manifest.
+type Archive struct {
+	store ObjectStore
+
+	mu        sync.RWMutex
+	manifests map[string]Manifest // by partition name
+	files     []fileRef           // ordered by FirstIngestSeq
+	lastSeq   int64
+	verified  map[string]bool // files whose checksum matched, by fileRef.key
+}
+
+// Open opens the archive in dir, creating the directory if needed.
+func Open(dir string) (*Archive, error) {
+	store, err := NewDirStore(dir)
+	if err != nil {
+		return nil, err
+	}
+	return New(context.Background(), store)
+}
+
+// New opens the archive held by store.
+func New(ctx context.Context, store ObjectStore) (*Archive, error) {
+	a := &Archive{store: store, verified: make(map[string]bool)}
+	if err := a.Refresh(ctx); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Refresh reloads the manifests, picking up partitions archived by other processes.
+func (a *Archive) Refresh(ctx context.Context) error {
+	names, err := a.store.List(ctx, "")
+	if err != nil {
+		return fmt.Errorf("list archive manifests: %w", err)
+	}
+	manifests := make(map[string]Manifest)
+	for _, name := range names {
+		if partition, base := path.Split(name); base != manifestName || strings.Count(partition, "/") != 1 {
+			continue
+		}
+		raw, err := readObject(ctx, a.store, name)
+		if err != nil {
+			return fmt.Errorf("read archive manifest: %w", err)
+		}
+		var m Manifest
+		if err := json.Unmarshal(raw, &m); err != nil {
+			return fmt.Errorf("decode archive manifest %s: %w", name, err)
+		}
+		if m.Format != FormatNDJSONZstd {
+			return fmt.Errorf("archive manifest %s: unsupported format %q", name, m.Format)
+		}
+		manifests[m.Partition] = m
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.manifests = manifests
+	a.reindex()
+	return nil
+}
+
+// Manifest returns the manifest of an archived partition.
+func (a *Archive) Manifest(partition string) (Manifest, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	m, ok := a.manifests[partition]
+	return m, ok
+}
+
+// LastIngestSeq returns the highest archived ingest_seq, 0 if nothing is archived.
+func (a *Archive) LastIngestSeq() int64 {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.lastSeq
+}
+
+// add records a manifest written by this process.
+func (a *Archive) add(m Manifest) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.manifests[m.Partition] = m
+	a.reindex()
+}
+
+// reindex rebuilds the file index from the manifests into a new slice, so reads keep
+// the index they started with. The caller holds a.mu.
+func (a *Archive) reindex() {
+	var files []fileRef
+	a.lastSeq = 0
+	for _, m := range a.manifests {
+		for _, info := range m.Files {
+			files = append(files, fileRef{partition: m.Partition, info: info})
+		}
+		a.lastSeq = max(a.lastSeq, m.LastIngestSeq)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].info.FirstIngestSeq < files[j].info.FirstIngestSeq
+	})
+	var maxLastSeq int64
+	for i := range files {
+		maxLastSeq = max(maxLastSeq, files[i].info.LastIngestSeq)
+		files[i].maxLastSeq = maxLastSeq
+	}
+	a.files = files
+}
+
+// RetrieveEventsAfterCursor returns up to limit archived events after cursor (ingest_seq)
+// that match, in strict total order. Partitions may overlap in ingest_seq at month
+// boundaries, so files are read until no later file can hold an earlier event.
+func (a *Archive) RetrieveEventsAfterCursor(
+	ctx context.Context,
+	cursor int64,
+	limit int,
+	match func(*v1.Event) bool,
+) ([]*v1.Event, error) {
+	a.mu.RLock()
+	files := a.files
+	a.mu.RUnlock()
+
+	first := sort.Search(len(files), func(i int) bool { return files[i].maxLastSeq > cursor })
+	var events []*v1.Event
+	for _, ref := range files[first:] {
+		if ref.info.LastIngestSeq <= cursor {
+			continue
+		}
+		if len(events) >= limit && ref.info.FirstIngestSeq > events[limit-1].IngestSeq {
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		read, err := a.readFile(ctx, ref, cursor, limit, match)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, read...)
+		sort.Slice(events, func(i, j int) bool { return events[i].IngestSeq < events[j].IngestSeq })
+		if len(events) > limit {
+			events = events[:limit]
+		}
+	}
+	return events, nil
+}
+
+// readFile returns up to limit matching events of one file after cursor. The checksum of
+// a file is verified on its first read by this process; reads then decompress from the
+// block holding the cursor and stop once limit events are found.
+func (a *Archive) readFile(ctx context.Context, ref fileRef, cursor int64, limit int, match func(*v1.Event) bool) ([]*v1.Event, error) {
+	name := ref.name()
+	if err := a.verify(ctx, ref); err != nil {
+		return nil, fmt.Errorf("archive file %s: %w", name, err)
+	}
+	f, err := a.store.Open(ctx, name, blockOffset(ref.info.Blocks, cursor))
+	if err != nil {
+		return nil, fmt.Errorf("open archive file: %w", err)
+	}
+	defer f.Close()
+
+	zr, err := zstd.NewReader(bufio.NewReader(f), zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, fmt.Errorf("read archive file %s: %w", name, err)
+	}
+	defer zr.Close()
+	var events []*v1.Event
+	decoder := json.NewDecoder(zr)
+	for len(events) < limit {
+		var rec record
+		err := decoder.Decode(&rec)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("decode archive file %s: %w", name, err)
+		}
+		if rec.IngestSeq <= cursor {
+			continue
+		}
+		evt := rec.Event
+		evt.IngestSeq = rec.IngestSeq
+		if match(&evt) {
+			events = append(events, &evt)
+		}
+	}
+	return events, nil
+}
+
+// verify checks the checksum of the file against the manifest unless this process
+// already did.
+func (a *Archive) verify(ctx context.Context, ref fileRef) error {
+	key := ref.key()
+	a.mu.RLock()
+	verified := a.verified[key]
+	a.mu.RUnlock()
+	if verified {
+		return nil
+	}
+
+	f, err := a.store.Open(ctx, ref.name(), 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return err
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != ref.info.SHA256 {
+		return fmt.Errorf("checksum %s does not match manifest %s", sum, ref.info.SHA256)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.verified[key] = true
+	return nil
+}
+
+// blockOffset returns the offset of the last block starting at or before cursor, where
+// the first event after cursor is, or 0 for files without blocks.
+func blockOffset(blocks []Block, cursor int64) int64 {
+	i := sort.Search(len(blocks), func(i int) bool { return blocks[i].FirstIngestSeq > cursor })
+	if i == 0 {
+		return 0
+	}
+	return blocks[i-1].Offset
+}
@@ -0,0 +1,265 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "github.com/aevon-lab/project-aevon/internal/api/v1"
+	"github.com/aevon-lab/project-aevon/internal/core/retention"
+	"github.com/stretchr/testify/require"
+)
+
+var march = retention.EventPartition{
+	Name: "events_p202603",
+	From: time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC),
+	To:   time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC),
+}
+
+// sliceSource serves partitions from events ordered by ingest_seq.
+type sliceSource []*v1.Event
+
+func (s sliceSource) RetrieveEventPartitionAfterCursor(ctx context.Context, p retention.EventPartition, cursor int64, limit int) ([]*v1.Event, error) {
+	var events []*v1.Event
+	for _, evt := range s {
+		if evt.IngestSeq > cursor && !evt.IngestedAt.Before(p.From) && evt.IngestedAt.Before(p.To) && len(events) < limit {
+			events = append(events, evt)
+		}
+	}
+	return events, nil
+}
+
+func testEvents(from, to int, eventType string) []*v1.Event {
+	var events []*v1.Event
+	for i := from; i <= to; i++ {
+		at := march.From.Add(time.Duration(i) * time.Hour)
+		events = append(events, &v1.Event{
+			ID:            fmt.Sprintf("evt-%d", i),
+			PrincipalID:   "user:alice",
+			Type:          eventType,
+			SchemaVersion: 1,
+			OccurredAt:    at,
+			IngestedAt:    at.Add(time.Second),
+			Metadata:      map[string]string{"region": "eu"},
+			Data:          map[string]interface{}{"tokens": float64(i)},
+			IngestSeq:     int64(i),
+		})
+	}
+	return events
+}
+
+func all(*v1.Event) bool { return true }
+
+func TestExporter_ArchivePartitionWritesManifestAndFiles(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	eventArchive, err := Open(dir)
+	require.NoError(t, err)
+	exporter := NewExporter(eventArchive, sliceSource(testEvents(1, 5, "api.request")), 2)
+
+	require.False(t, exporter.Archived(march))
+	require.NoError(t, exporter.ArchivePartition(ctx, march))
+	require.True(t, exporter.Archived(march))
+
+	manifest, ok := eventArchive.Manifest(march.Name)
+	require.True(t, ok)
+	require.Equal(t, int64(5), manifest.Rows)
+	require.Equal(t, int64(1), manifest.FirstIngestSeq)
+	require.Equal(t, int64(5), manifest.LastIngestSeq)
+	require.Len(t, manifest.Files, 3)
+	require.Equal(t, "part-00002.ndjson.zst", manifest.Files[2].Name)
+	require.Equal(t, int64(1), manifest.Files[2].Rows)
+	require.Equal(t, int64(5), eventArchive.LastIngestSeq())
+
+	// Another process opening the archive sees the same manifest.
+	reopened, err := Open(dir)
+	require.NoError(t, err)
+	reread, ok := reopened.Manifest(march.Name)
+	require.True(t, ok)
+	require.Equal(t, manifest.Files, reread.Files)
+
+	events, err := reopened.RetrieveEventsAfterCursor(ctx, 1, 3, all)
+	require.NoError(t, err)
+	require.Len(t, events, 3)
+	require.Equal(t, int64(2), events[0].IngestSeq)
+	require.Equal(t, int64(4), events[2].IngestSeq)
+	require.Equal(t, "evt-2", events[0].ID)
+	require.Equal(t, "eu", events[0].Metadata["region"])
+	require.Equal(t, float64(2), events[0].Data["tokens"])
+	require.True(t, events[0].OccurredAt.Equal(march.From.Add(2*time.Hour)))
+}
+
+func TestArchive_ReadRejectsCorruptFile(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	eventArchive, err := Open(dir)
+	require.NoError(t, err)
+	require.NoError(t, NewExporter(eventArchive, sliceSource(testEvents(1, 2, "api.request")), 10).ArchivePartition(ctx, march))
+
+	// A file that still decompresses but is not the exported one fails its checksum.
+	manifest, _ := eventArchive.Manifest(march.Name)
+	manifest.Files[0].SHA256 = strings.Repeat("0", 64)
+	raw, err := json.Marshal(manifest)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, march.Name, manifestName), raw, 0o644))
+	require.NoError(t, eventArchive.Refresh(ctx))
+
+	_, err = eventArchive.RetrieveEventsAfterCursor(ctx, 0, 10, all)
+	require.ErrorContains(t, err, "does not match manifest")
+}
+
+func TestArchive_PagesThroughBlocksAndFiles(t *testing.T) {
+	ctx := context.Background()
+	eventArchive, err := Open(t.TempDir())
+	require.NoError(t, err)
+	exporter := NewExporter(eventArchive, sliceSource(testEvents(1, 10, "api.request")), 4)
+	exporter.blockRows = 2
+	require.NoError(t, exporter.ArchivePartition(ctx, march))
+
+	manifest, _ := eventArchive.Manifest(march.Name)
+	require.Len(t, manifest.Files, 3)
+	require.Len(t, manifest.Files[0].Blocks, 2)
+	require.Equal(t, int64(0), manifest.Files[0].Blocks[0].Offset)
+	require.Equal(t, int64(3), manifest.Files[0].Blocks[1].FirstIngestSeq)
+	require.Greater(t, manifest.Files[0].Blocks[1].Offset, int64(0))
+	require.Len(t, manifest.Files[2].Blocks, 1)
+
+	page := func(match func(*v1.Event) bool) []int64 {
+		var seqs []int64
+		var cursor int64
+		for {
+			events, err := eventArchive.RetrieveEventsAfterCursor(ctx, cursor, 3, match)
+			require.NoError(t, err)
+			for _, evt := range events {
+				seqs = append(seqs, evt.IngestSeq)
+			}
+			if len(events) < 3 {
+				return seqs
+			}
+			cursor = events[len(events)-1].IngestSeq
+		}
+	}
+	require.Equal(t, []int64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, page(all))
+	even := func(evt *v1.Event) bool { return evt.IngestSeq%2 == 0 }
+	require.Equal(t, []int64{2, 4, 6, 8, 10}, page(even))
+
+	// A cursor inside a block starts at the next event.
+	events, err := eventArchive.RetrieveEventsAfterCursor(ctx, 6, 2, all)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	require.Equal(t, int64(7), events[0].IngestSeq)
+	require.Equal(t, int64(8), events[1].IngestSeq)
+}
+
+// memStore is an ObjectStore in memory, standing in for an object store bucket.
+type memStore struct {
+	objects map[string][]byte
+}
+
+type memWriter struct {
+	store *memStore
+	name  string
+	buf   bytes.Buffer
+}
+
+func (w *memWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+func (w *memWriter) Commit() error {
+	w.store.objects[w.name] = w.buf.Bytes()
+	return nil
+}
+
+func (w *memWriter) Abort() {}
+
+func (s *memStore) Create(_ context.Context, name string) (ObjectWriter, error) {
+	return &memWriter{store: s, name: name}, nil
+}
+
+func (s *memStore) Open(_ context.Context, name string, offset int64) (io.ReadCloser, error) {
+	data, ok := s.objects[name]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return io.NopCloser(bytes.NewReader(data[offset:])), nil
+}
+
+func (s *memStore) List(_ context.Context, prefix string) ([]string, error) {
+	var names []string
+	for name := range s.objects {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func TestArchive_ReadsAndWritesThroughObjectStore(t *testing.T) {
+	ctx := context.Background()
+	store := &memStore{objects: make(map[string][]byte)}
+	eventArchive, err := New(ctx, store)
+	require.NoError(t, err)
+	exporter := NewExporter(eventArchive, sliceSource(testEvents(1, 5, "api.request")), 4)
+	exporter.blockRows = 2
+	require.NoError(t, exporter.ArchivePartition(ctx, march))
+
+	names, err := store.List(ctx, "")
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"events_p202603/manifest.json",
+		"events_p202603/part-00000.ndjson.zst",
+		"events_p202603/part-00001.ndjson.zst",
+	}, names)
+
+	reopened, err := New(ctx, store)
+	require.NoError(t, err)
+	events, err := reopened.RetrieveEventsAfterCursor(ctx, 3, 10, all)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	require.Equal(t, int64(4), events[0].IngestSeq)
+	require.Equal(t, int64(5), events[1].IngestSeq)
+}
+
+func TestDirStore_PublishesObjectsOnCommit(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewDirStore(t.TempDir())
+	require.NoError(t, err)
+
+	w, err := store.Create(ctx, "events_p202603/part-00000.ndjson.zst")
+	require.NoError(t, err)
+	_, err = w.Write([]byte("0123456789"))
+	require.NoError(t, err)
+	names, err := store.List(ctx, "")
+	require.NoError(t, err)
+	require.Empty(t, names)
+
+	require.NoError(t, w.Commit())
+	w.Abort() // after Commit: keeps the object
+	names, err = store.List(ctx, "events_p202603/")
+	require.NoError(t, err)
+	require.Equal(t, []string{"events_p202603/part-00000.ndjson.zst"}, names)
+
+	r, err := store.Open(ctx, names[0], 4)
+	require.NoError(t, err)
+	defer r.Close()
+	rest, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, "456789", string(rest))
+
+	aborted, err := store.Create(ctx, "events_p202603/manifest.json")
+	require.NoError(t, err)
+	aborted.Abort()
+	names, err = store.List(ctx, "")
+	require.NoError(t, err)
+	require.Len(t, names, 1)
+}
@@ -0,0 +1,213 @@
+package archive
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	v1 "github.com/aevon-lab/project-aevon/internal/api/v1"
+	"github.com/aevon-lab/project-aevon/internal/core/partition"
+	"github.com/aevon-lab/project-aevon/internal/core/storage"
+)
+
+// EventStore serves cursor reads from the archive where they reach below the hot event
+// store, so replay and verification see events whose partitions were retired. Reads
+// after the last archived ingest_seq, like the projection tail, go to the hot store
+// alone. Everything else is the hot store's.
+type EventStore struct {
+	storage.EventStore
+	archive *Archive
+}
+
+// NewEventStore wraps hot with the archive.
+func NewEventStore(hot storage.EventStore, archive *Archive) *EventStore {
+	return &EventStore{EventStore: hot, archive: archive}
+}
+
+// RetrieveEventsAfterCursor fetches events after a cursor (ingest_seq) in strict total
+// order from the archive and the hot store.
+func (s *EventStore) RetrieveEventsAfterCursor(ctx context.Context, cursor int64, limit int) ([]*v1.Event, error) {
+	return s.retrieve(ctx, cursor, limit, func(*v1.Event) bool { return true }, func() ([]*v1.Event, error) {
+		return s.EventStore.RetrieveEventsAfterCursor(ctx, cursor, limit)
+	})
+}
+
+// RetrieveScopedEventsAfterCursor fetches events of one query scope after a cursor from
+// the archive and the hot store.
+func (s *EventStore) RetrieveScopedEventsAfterCursor(
+	ctx context.Context,
+	cursor int64,
+	principalID string,
+	eventType string,
+	startOccurredAt time.Time,
+	endOccurredAt time.Time,
+	includeIngested bool,
+	limit int,
+) ([]*v1.Event, error) {
+	match := func(evt *v1.Event) bool {
+		return evt.PrincipalID == principalID && evt.Type == eventType && inScope(evt, startOccurredAt, endOccurredAt, includeIngested)
+	}
+	return s.retrieve(ctx, cursor, limit, match, func() ([]*v1.Event, error) {
+		return s.EventStore.RetrieveScopedEventsAfterCursor(ctx, cursor, principalID, eventType, startOccurredAt, endOccurredAt, includeIngested, limit)
+	})
+}
+
+// RetrievePartitionEventsAfterCursor fetches events of a partition range after a cursor
+// from the archive and the hot store.
+func (s *EventStore) RetrievePartitionEventsAfterCursor(
+	ctx context.Context,
+	cursor int64,
+	partitions partition.Range,
+	limit int,
+) ([]*v1.Event, error) {
+	reader, ok := s.EventStore.(storage.PartitionedEventReader)
+	if !ok {
+		return nil, fmt.Errorf("event store cannot read events by partition")
+	}
+	match := func(evt *v1.Event) bool { return partitions.Contains(partition.For(evt.PrincipalID)) }
+	return s.retrieve(ctx, cursor, limit, match, func() ([]*v1.Event, error) {
+		return reader.RetrievePartitionEventsAfterCursor(ctx, cursor, partitions, limit)
+	})
+}
+
+// RetrieveBatchScopedEventsAfterCursor fetches events of many principals after a cursor
+// from the archive and the hot store.
+func (s *EventStore) RetrieveBatchScopedEventsAfterCursor(
+	ctx context.Context,
+	cursor int64,
+	principalIDs []string,
+	eventType string,
+	startOccurredAt time.Time,
+	endOccurredAt time.Time,
+	includeIngested bool,
+	limit int,
+) ([]*v1.Event, error) {
+	reader, ok := s.EventStore.(storage.BatchScopedEventReader)
+	if !ok {
+		return nil, fmt.Errorf("event store cannot read events of many principals")
+	}
+	principals := make(map[string]struct{}, len(principalIDs))
+	for _, principalID := range principalIDs {
+		principals[principalID] = struct{}{}
+	}
+	match := func(evt *v1.Event) bool {
+		_, ok := principals[evt.PrincipalID]
+		return ok && evt.Type == eventType && inScope(evt, startOccurredAt, endOccurredAt, includeIngested)
+	}
+	return s.retrieve(ctx, cursor, limit, match, func() ([]*v1.Event, error) {
+		return reader.RetrieveBatchScopedEventsAfterCursor(ctx, cursor, principalIDs, eventType, startOccurredAt, endOccurredAt, includeIngested, limit)
+	})
+}
+
+// RetrieveTypeScopedEventsAfterCursor fetches events of one type after a cursor from the
+// archive and the hot store.
+func (s *EventStore) RetrieveTypeScopedEventsAfterCursor(
+	ctx context.Context,
+	cursor int64,
+	eventType string,
+	startOccurredAt time.Time,
+	endOccurredAt time.Time,
+	includeIngested bool,
+	limit int,
+) ([]*v1.Event, error) {
+	reader, ok := s.EventStore.(storage.TypeScopedEventReader)
+	if !ok {
+		return nil, fmt.Errorf("event store cannot read events by type")
+	}
+	match := func(evt *v1.Event) bool {
+		return evt.Type == eventType && inScope(evt, startOccurredAt, endOccurredAt, includeIngested)
+	}
+	return s.retrieve(ctx, cursor, limit, match, func() ([]*v1.Event, error) {
+		return reader.RetrieveTypeScopedEventsAfterCursor(ctx, cursor, eventType, startOccurredAt, endOccurredAt, includeIngested, limit)
+	})
+}
+
+// FirstIngestSeqAfter is answered by the hot store, see firstHotSeqAfter.
+func (s *EventStore) FirstIngestSeqAfter(ctx context.Context, principalID string, t time.Time) (int64, error) {
+	reader, ok := s.EventStore.(storage.IngestCursorReader)
+	if !ok {
+		return 0, fmt.Errorf("event store cannot map ingestion times to cursors")
+	}
+	return s.firstHotSeqAfter(t, func(t time.Time) (int64, error) {
+		return reader.FirstIngestSeqAfter(ctx, principalID, t)
+	})
+}
+
+// FirstTypeIngestSeqAfter is answered by the hot store, see firstHotSeqAfter.
+func (s *EventStore) FirstTypeIngestSeqAfter(ctx context.Context, eventType string, t time.Time) (int64, error) {
+	reader, ok := s.EventStore.(storage.TypeIngestCursorReader)
+	if !ok {
+		return 0, fmt.Errorf("event store cannot map ingestion times to cursors")
+	}
+	return s.firstHotSeqAfter(t, func(t time.Time) (int64, error) {
+		return reader.FirstTypeIngestSeqAfter(ctx, eventType, t)
+	})
+}
+
+// firstHotSeqAfter answers an ingestion time lookup from the hot store. Archived events
+// were ingested before every hot event, so the hot answer holds unless t precedes all hot
+// events of the scope: archived events may then have been ingested after t, and the
+// lowest possible ingest_seq is returned so callers read the archive too.
+func (s *EventStore) firstHotSeqAfter(t time.Time, firstAfter func(time.Time) (int64, error)) (int64, error) {
+	first, err := firstAfter(t)
+	if err != nil || first == 0 || s.archive.LastIngestSeq() == 0 {
+		return first, err
+	}
+	firstHot, err := firstAfter(time.Time{})
+	if err != nil {
+		return 0, err
+	}
+	if first == firstHot {
+		return 1, nil
+	}
+	return first, nil
+}
+
+// retrieve merges the first limit matching events after cursor of the archive and of the
+// hot store. Partitions are archived before they are retired, so an event can be in
+// both; the hot copy wins.
+func (s *EventStore) retrieve(
+	ctx context.Context,
+	cursor int64,
+	limit int,
+	match func(*v1.Event) bool,
+	hot func() ([]*v1.Event, error),
+) ([]*v1.Event, error) {
+	if cursor >= s.archive.LastIngestSeq() {
+		return hot()
+	}
+
+	archived, err := s.archive.RetrieveEventsAfterCursor(ctx, cursor, limit, match)
+	if err != nil {
+		return nil, fmt.Errorf("read archived events after %d: %w", cursor, err)
+	}
+	stored, err := hot()
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]*v1.Event, 0, min(limit, len(archived)+len(stored)))
+	for len(events) < limit && (len(archived) > 0 || len(stored) > 0) {
+		switch {
+		case len(archived) == 0:
+			events, stored = append(events, stored[0]), stored[1:]
+		case len(stored) == 0 || archived[0].IngestSeq < stored[0].IngestSeq:
+			events, archived = append(events, archived[0]), archived[1:]
+		default:
+			if archived[0].IngestSeq == stored[0].IngestSeq {
+				archived = archived[1:]
+			}
+			events, stored = append(events, stored[0]), stored[1:]
+		}
+	}
+	return events, nil
+}
+
+// inScope reports whether evt occurred in [start, end), or with includeIngested was
+// ingested in it, the time scope of the scoped event reads.
+func inScope(evt *v1.Event, start, end time.Time, includeIngested bool) bool {
+	if !evt.OccurredAt.Before(start) && evt.OccurredAt.Before(end) {
+		return true
+	}
+	return includeIngested && !evt.IngestedAt.Before(start) && evt.IngestedAt.Before(end)
+}
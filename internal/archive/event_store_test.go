@@ -0,0 +1,113 @@
+package archive
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v1 "github.com/aevon-lab/project-aevon/internal/api/v1"
+	"github.com/aevon-lab/project-aevon/internal/core/storage/memory"
+	"github.com/stretchr/testify/require"
+)
+
+// retiredStore is a hot store whose events up to retired were retired.
+type retiredStore struct {
+	*memory.EventStore
+	retired int64
+}
+
+func (s *retiredStore) RetrieveEventsAfterCursor(ctx context.Context, cursor int64, limit int) ([]*v1.Event, error) {
+	return s.EventStore.RetrieveEventsAfterCursor(ctx, max(cursor, s.retired), limit)
+}
+
+func (s *retiredStore) RetrieveTypeScopedEventsAfterCursor(
+	ctx context.Context,
+	cursor int64,
+	eventType string,
+	startOccurredAt time.Time,
+	endOccurredAt time.Time,
+	includeIngested bool,
+	limit int,
+) ([]*v1.Event, error) {
+	return s.EventStore.RetrieveTypeScopedEventsAfterCursor(ctx, max(cursor, s.retired), eventType, startOccurredAt, endOccurredAt, false, limit)
+}
+
+func (s *retiredStore) FirstTypeIngestSeqAfter(ctx context.Context, eventType string, t time.Time) (int64, error) {
+	events, err := s.EventStore.RetrieveEventsAfterCursor(ctx, s.retired, 100)
+	if err != nil {
+		return 0, err
+	}
+	for _, evt := range events {
+		if evt.Type == eventType && evt.IngestedAt.After(t) {
+			return evt.IngestSeq, nil
+		}
+	}
+	return 0, nil
+}
+
+func ingestSeqs(events []*v1.Event) []int64 {
+	var seqs []int64
+	for _, evt := range events {
+		seqs = append(seqs, evt.IngestSeq)
+	}
+	return seqs
+}
+
+func TestEventStore_MergesArchivedAndHotEvents(t *testing.T) {
+	ctx := context.Background()
+	events := append(testEvents(1, 4, "api.request"), testEvents(5, 6, "api.login")...)
+	hot := memory.NewEventStore()
+	_, err := hot.SaveEvents(ctx, events)
+	require.NoError(t, err)
+
+	eventArchive, err := Open(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, NewExporter(eventArchive, sliceSource(events[:4]), 3).ArchivePartition(ctx, march))
+
+	// Events 3 and 4 are archived and still hot; the store returns them once.
+	store := NewEventStore(&retiredStore{EventStore: hot, retired: 2}, eventArchive)
+
+	read, err := store.RetrieveEventsAfterCursor(ctx, 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, []int64{1, 2, 3, 4, 5, 6}, ingestSeqs(read))
+
+	read, err = store.RetrieveEventsAfterCursor(ctx, 1, 2)
+	require.NoError(t, err)
+	require.Equal(t, []int64{2, 3}, ingestSeqs(read))
+
+	typed, err := store.RetrieveTypeScopedEventsAfterCursor(ctx, 0, "api.request", march.From, march.To, false, 10)
+	require.NoError(t, err)
+	require.Equal(t, []int64{1, 2, 3, 4}, ingestSeqs(typed))
+
+	// Reads past the archive only touch the hot store.
+	read, err = store.RetrieveEventsAfterCursor(ctx, 4, 10)
+	require.NoError(t, err)
+	require.Equal(t, []int64{5, 6}, ingestSeqs(read))
+}
+
+func TestEventStore_FirstTypeIngestSeqAfterReachesIntoArchive(t *testing.T) {
+	ctx := context.Background()
+	events := append(testEvents(1, 4, "api.request"), testEvents(5, 6, "api.login")...)
+	hot := memory.NewEventStore()
+	_, err := hot.SaveEvents(ctx, events)
+	require.NoError(t, err)
+
+	eventArchive, err := Open(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, NewExporter(eventArchive, sliceSource(events[:4]), 3).ArchivePartition(ctx, march))
+	store := NewEventStore(&retiredStore{EventStore: hot, retired: 2}, eventArchive)
+
+	// A time after a hot event of the type is answered by the hot store.
+	first, err := store.FirstTypeIngestSeqAfter(ctx, "api.request", march.From.Add(3*time.Hour+time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, int64(4), first)
+
+	// Before every hot event of the type, archived events may have been ingested after it.
+	first, err = store.FirstTypeIngestSeqAfter(ctx, "api.request", march.From)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), first)
+
+	first, err = store.FirstTypeIngestSeqAfter(ctx, "api.request", march.From.Add(5*time.Hour))
+	require.NoError(t, err)
+	require.Zero(t, first)
+}
@@ -0,0 +1,229 @@
+package archive
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"time"
+
+	v1 "github.com/aevon-lab/project-aevon/internal/api/v1"
+	"github.com/aevon-lab/project-aevon/internal/core/retention"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	exportBatchSize = 10000
+	// blockRows is the number of events per zstd frame of an archive file, bounding how
+	// much a cursor read decompresses before its first event.
+	blockRows = 5000
+)
+
+// PartitionReader reads the events of one event partition.
+type PartitionReader interface {
+	// RetrieveEventPartitionAfterCursor fetches the events of p after a cursor
+	// (ingest_seq) in strict total order.
+	RetrieveEventPartitionAfterCursor(ctx context.Context, p retention.EventPartition, cursor int64, limit int) ([]*v1.Event, error)
+}
+
+// Exporter writes event partitions from source to the archive.
+type Exporter struct {
+	archive     *Archive
+	source      PartitionReader
+	rowsPerFile int
+	blockRows   int
+	nowFn       func() time.Time
+}
+
+// NewExporter creates an exporter of source into archive writing up to rowsPerFile
+// events per file.
+func NewExporter(archive *Archive, source PartitionReader, rowsPerFile int) *Exporter {
+	return &Exporter{
+		archive:     archive,
+		source:      source,
+		rowsPerFile: rowsPerFile,
+		blockRows:   blockRows,
+		nowFn:       time.Now,
+	}
+}
+
+// Archived reports whether p has a complete archive.
+func (e *Exporter) Archived(p retention.EventPartition) bool {
+	_, ok := e.archive.Manifest(p.Name)
+	return ok
+}
+
+// ArchivePartition exports every event of p, then writes its manifest. Files are
+// committed to the object store once complete; exporting a partition again rewrites the
+// same files.
+func (e *Exporter) ArchivePartition(ctx context.Context, p retention.EventPartition) error {
+	manifest := Manifest{Partition: p.Name, From: p.From, To: p.To, Format: FormatNDJSONZstd, Files: []FileInfo{}}
+	var w *fileWriter
+	defer func() {
+		if w != nil {
+			w.abort()
+		}
+	}()
+
+	var cursor int64
+	for {
+		events, err := e.source.RetrieveEventPartitionAfterCursor(ctx, p, cursor, exportBatchSize)
+		if err != nil {
+			return err
+		}
+		for _, evt := range events {
+			if w == nil {
+				if w, err = createFile(ctx, e.archive.store, p.Name, fmt.Sprintf("part-%05d.ndjson.zst", len(manifest.Files)), e.blockRows); err != nil {
+					return err
+				}
+			}
+			if err := w.write(evt); err != nil {
+				return err
+			}
+			if w.info.Rows == int64(e.rowsPerFile) {
+				info, err := w.close()
+				w = nil
+				if err != nil {
+					return err
+				}
+				manifest.Files = append(manifest.Files, info)
+			}
+		}
+		if len(events) < exportBatchSize {
+			break
+		}
+		cursor = events[len(events)-1].IngestSeq
+	}
+	if w != nil {
+		info, err := w.close()
+		w = nil
+		if err != nil {
+			return err
+		}
+		manifest.Files = append(manifest.Files, info)
+	}
+
+	for i, info := range manifest.Files {
+		if i == 0 {
+			manifest.FirstIngestSeq = info.FirstIngestSeq
+		}
+		manifest.Rows += info.Rows
+		manifest.LastIngestSeq = info.LastIngestSeq
+	}
+	manifest.CreatedAt = e.nowFn().UTC()
+
+	raw, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode archive manifest of %s: %w", p.Name, err)
+	}
+	if err := writeObject(ctx, e.archive.store, p.Name+"/"+manifestName, raw); err != nil {
+		return fmt.Errorf("write archive manifest of %s: %w", p.Name, err)
+	}
+	e.archive.add(manifest)
+
+	slog.Info("[Archive] Archived event partition",
+		"partition", p.Name,
+		"rows", manifest.Rows,
+		"files", len(manifest.Files),
+	)
+	return nil
+}
+
+// fileWriter writes one archive file to the object store, as one zstd frame per block
+// of events.
+type fileWriter struct {
+	name      string
+	obj       ObjectWriter
+	buf       *bufio.Writer
+	hash      hash.Hash
+	out       *countWriter // compressed bytes written
+	blockRows int
+	zw        *zstd.Encoder
+	enc       *json.Encoder
+	info      FileInfo
+}
+
+func createFile(ctx context.Context, store ObjectStore, partition, name string, blockRows int) (*fileWriter, error) {
+	obj, err := store.Create(ctx, partition+"/"+name)
+	if err != nil {
+		return nil, fmt.Errorf("create archive file: %w", err)
+	}
+	w := &fileWriter{name: partition + "/" + name, obj: obj, hash: sha256.New(), blockRows: blockRows, info: FileInfo{Name: name}}
+	w.buf = bufio.NewWriter(io.MultiWriter(obj, w.hash))
+	w.out = &countWriter{w: w.buf}
+	return w, nil
+}
+
+func (w *fileWriter) write(evt *v1.Event) error {
+	if w.info.Rows%int64(w.blockRows) == 0 {
+		if err := w.startBlock(evt.IngestSeq); err != nil {
+			return fmt.Errorf("write archive file %s: %w", w.name, err)
+		}
+	}
+	if err := w.enc.Encode(record{Event: *evt, IngestSeq: evt.IngestSeq}); err != nil {
+		return fmt.Errorf("write archive file %s: %w", w.name, err)
+	}
+	if w.info.Rows == 0 {
+		w.info.FirstIngestSeq = evt.IngestSeq
+	}
+	w.info.Rows++
+	w.info.LastIngestSeq = evt.IngestSeq
+	return nil
+}
+
+// startBlock ends the current zstd frame and starts the next at firstSeq.
+func (w *fileWriter) startBlock(firstSeq int64) error {
+	if w.zw == nil {
+		zw, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return err
+		}
+		w.zw = zw
+		w.enc = json.NewEncoder(w.zw)
+	} else if err := w.zw.Close(); err != nil {
+		return err
+	}
+	w.info.Blocks = append(w.info.Blocks, Block{Offset: w.out.n, FirstIngestSeq: firstSeq})
+	w.zw.Reset(w.out)
+	return nil
+}
+
+// close completes the file and commits it.
+func (w *fileWriter) close() (FileInfo, error) {
+	err := w.zw.Close()
+	if err == nil {
+		err = w.buf.Flush()
+	}
+	if err != nil {
+		w.obj.Abort()
+		return FileInfo{}, fmt.Errorf("write archive file %s: %w", w.name, err)
+	}
+	if err := w.obj.Commit(); err != nil {
+		return FileInfo{}, fmt.Errorf("write archive file %s: %w", w.name, err)
+	}
+	w.info.Bytes = w.out.n
+	w.info.SHA256 = hex.EncodeToString(w.hash.Sum(nil))
+	return w.info, nil
+}
+
+// abort discards an unfinished file.
+func (w *fileWriter) abort() {
+	w.obj.Abort()
+}
+
+// countWriter counts the bytes written through it.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,166 @@
+package archive
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ObjectStore holds the objects of an archive under slash-separated names, e.g.
+// "events_p202609/part-00000.ndjson.zst". An object is only visible under its name once
+// committed, so readers never see a partial file. DirStore keeps objects in a directory;
+// an S3 or GCS client implements the same methods with multipart uploads, ranged GETs and
+// prefix listings.
+type ObjectStore interface {
+	// Create starts writing the object name. Committing replaces any object of that name.
+	Create(ctx context.Context, name string) (ObjectWriter, error)
+	// Open reads the object name from byte offset on.
+	Open(ctx context.Context, name string, offset int64) (io.ReadCloser, error)
+	// List returns the names of the committed objects starting with prefix, in order.
+	List(ctx context.Context, prefix string) ([]string, error)
+}
+
+// ObjectWriter writes one object of an ObjectStore.
+type ObjectWriter interface {
+	io.Writer
+	// Commit publishes the written bytes under the object's name.
+	Commit() error
+	// Abort discards the written bytes. It does nothing after Commit.
+	Abort()
+}
+
+// tmpSuffix marks the files of DirStore objects being written.
+const tmpSuffix = ".tmp"
+
+// DirStore is an ObjectStore in a local directory, or in a bucket mounted as one. Objects
+// are written under a temporary name and renamed on commit.
+type DirStore struct {
+	dir string
+}
+
+// NewDirStore returns the store of dir, creating the directory if needed.
+func NewDirStore(dir string) (*DirStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create archive directory: %w", err)
+	}
+	return &DirStore{dir: dir}, nil
+}
+
+func (d *DirStore) path(name string) string {
+	return filepath.Join(d.dir, filepath.FromSlash(name))
+}
+
+// Create implements ObjectStore.
+func (d *DirStore) Create(_ context.Context, name string) (ObjectWriter, error) {
+	path := d.path(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	f, err := os.Create(path + tmpSuffix)
+	if err != nil {
+		return nil, err
+	}
+	return &dirObjectWriter{f: f, path: path}, nil
+}
+
+// Open implements ObjectStore.
+func (d *DirStore) Open(_ context.Context, name string, offset int64) (io.ReadCloser, error) {
+	f, err := os.Open(d.path(name))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close() //nolint:errcheck
+		return nil, err
+	}
+	return f, nil
+}
+
+// List implements ObjectStore.
+func (d *DirStore) List(_ context.Context, prefix string) ([]string, error) {
+	var names []string
+	err := filepath.WalkDir(d.dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || strings.HasSuffix(path, tmpSuffix) {
+			return nil
+		}
+		rel, err := filepath.Rel(d.dir, path)
+		if err != nil {
+			return err
+		}
+		if name := filepath.ToSlash(rel); strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// dirObjectWriter writes one DirStore object to its temporary file.
+type dirObjectWriter struct {
+	f    *os.File
+	path string
+	done bool
+}
+
+func (w *dirObjectWriter) Write(p []byte) (int, error) {
+	return w.f.Write(p)
+}
+
+func (w *dirObjectWriter) Commit() error {
+	err := w.f.Sync()
+	if closeErr := w.f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(w.f.Name(), w.path)
+	}
+	w.done = true
+	if err != nil {
+		os.Remove(w.f.Name()) //nolint:errcheck
+	}
+	return err
+}
+
+func (w *dirObjectWriter) Abort() {
+	if w.done {
+		return
+	}
+	w.done = true
+	w.f.Close()           //nolint:errcheck
+	os.Remove(w.f.Name()) //nolint:errcheck
+}
+
+// writeObject writes data as the object name in one commit.
+func writeObject(ctx context.Context, store ObjectStore, name string, data []byte) error {
+	w, err := store.Create(ctx, name)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		w.Abort()
+		return err
+	}
+	return w.Commit()
+}
+
+// readObject reads the whole object name.
+func readObject(ctx context.Context, store ObjectStore, name string) ([]byte, error) {
+	r, err := store.Open(ctx, name, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
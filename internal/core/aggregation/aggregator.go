@@ -4,16 +4,56 @@ import (
 	"github.com/shopspring/decimal"
 )
 
+// avgScale is the number of decimal places kept for avg means.
+// The SQL upsert rounds to the same scale so Go and PostgreSQL agree bit-for-bit.
+const avgScale = 16
+
 // Aggregator defines the reduce semantics of an aggregation operator.
 // To add a new operator: implement this interface and register it in Operators.
 // The worker's hot path becomes a single map lookup — no switch.
+//
+// Implementations only touch the value fields of AggregateState (Value, Sum, ValueAt, Sketch, Digest).
+// EventCount, LastEventID, RuleFingerprint and timestamps are maintained by callers.
 type Aggregator interface {
-	// Initial returns the aggregate value after the very first event for a key.
-	// count → 1; sum/min/max → the incoming value itself.
-	Initial(incoming decimal.Decimal) decimal.Decimal
+	// Initial returns the value state after the very first event for a key.
+	// count → 1; sum/min/max/avg/first/last → the incoming value itself.
+	// Aggregators that implement Finalizer may leave Value stale.
+	Initial(obs Observation) AggregateState
+
+	// Apply folds one more event into an existing state.
+	// state.EventCount is the count *before* this event is added.
+	// The caller owns state: count_distinct and quantile update their sketch in place.
+	// Aggregators that implement Finalizer may leave Value stale.
+	Apply(state AggregateState, obs Observation) AggregateState
+
+	// Merge combines two partial states of the same key (worker-local maps, raw tail,
+	// rollups). Each side carries its own EventCount; b is the more recent partial.
+	// Returns a with its value fields replaced by the merged result; neither input is mutated.
+	Merge(a, b AggregateState) AggregateState
+}
 
-	// Apply folds an incoming value into an existing aggregate.
-	Apply(current, incoming decimal.Decimal) decimal.Decimal
+// Finalizer is implemented by aggregators whose Initial and Apply leave Value stale
+// because it is costly to derive from the state, like the count_distinct estimate or a
+// quantile. Code that folds events calls Finalize once folding ends, before the states
+// are read, merged or flushed. Merge always returns an up-to-date Value.
+type Finalizer interface {
+	Finalize(state AggregateState) AggregateState
+}
+
+// Retractor is implemented by aggregators that can take events back out of a state, like
+// count and sum. Retract removes the partial b, folded from events a already holds, from
+// a; like Merge, each side carries its own EventCount. Point-in-time queries use it to
+// remove events ingested after the requested time from pre-aggregates.
+type Retractor interface {
+	Retract(a, b AggregateState) AggregateState
+}
+
+// Finalize derives the Value of state if its operator leaves it stale in Apply.
+func Finalize(state AggregateState) AggregateState {
+	if finalizer, ok := Operators[state.Operator].(Finalizer); ok {
+		return finalizer.Finalize(state)
+	}
+	return state
 }
 
 // Operators is the registry of all supported aggregation operators.
@@ -24,6 +64,12 @@ var Operators = map[string]Aggregator{
 	OpSum:   sumAgg{},
 	OpMin:   minAgg{},
 	OpMax:   maxAgg{},
+	OpAvg:   avgAgg{},
+	OpFirst: firstAgg{},
+	OpLast:  lastAgg{},
+
+	OpCountDistinct: countDistinctAgg{},
+	OpQuantile:      quantileAgg{},
 }
 
 // ValidOperator reports whether op is a registered aggregation operator.
@@ -35,33 +81,265 @@ func ValidOperator(op string) bool {
 // countAgg increments by 1 per event. The incoming value is ignored.
 type countAgg struct{}
 
-func (countAgg) Initial(_ decimal.Decimal) decimal.Decimal    { return decimal.NewFromInt(1) }
-func (countAgg) Apply(cur, _ decimal.Decimal) decimal.Decimal { return cur.Add(decimal.NewFromInt(1)) }
+func (countAgg) Initial(_ Observation) AggregateState {
+	return AggregateState{Value: decimal.NewFromInt(1)}
+}
+
+func (countAgg) Apply(s AggregateState, _ Observation) AggregateState {
+	s.Value = s.Value.Add(decimal.NewFromInt(1))
+	return s
+}
+
+func (countAgg) Merge(a, b AggregateState) AggregateState {
+	a.Value = a.Value.Add(b.Value)
+	return a
+}
+
+func (countAgg) Retract(a, b AggregateState) AggregateState {
+	a.Value = a.Value.Sub(b.Value)
+	return a
+}
 
 // sumAgg accumulates the sum of incoming values.
 type sumAgg struct{}
 
-func (sumAgg) Initial(v decimal.Decimal) decimal.Decimal      { return v }
-func (sumAgg) Apply(cur, inc decimal.Decimal) decimal.Decimal { return cur.Add(inc) }
+func (sumAgg) Initial(obs Observation) AggregateState {
+	return AggregateState{Value: obs.Value}
+}
+
+func (sumAgg) Apply(s AggregateState, obs Observation) AggregateState {
+	s.Value = s.Value.Add(obs.Value)
+	return s
+}
+
+func (sumAgg) Merge(a, b AggregateState) AggregateState {
+	a.Value = a.Value.Add(b.Value)
+	return a
+}
+
+func (sumAgg) Retract(a, b AggregateState) AggregateState {
+	a.Value = a.Value.Sub(b.Value)
+	return a
+}
 
 // minAgg tracks the minimum value seen.
 type minAgg struct{}
 
-func (minAgg) Initial(v decimal.Decimal) decimal.Decimal { return v }
-func (minAgg) Apply(cur, inc decimal.Decimal) decimal.Decimal {
-	if inc.LessThan(cur) {
-		return inc
+func (minAgg) Initial(obs Observation) AggregateState {
+	return AggregateState{Value: obs.Value}
+}
+
+func (minAgg) Apply(s AggregateState, obs Observation) AggregateState {
+	if obs.Value.LessThan(s.Value) {
+		s.Value = obs.Value
+	}
+	return s
+}
+
+func (minAgg) Merge(a, b AggregateState) AggregateState {
+	if b.Value.LessThan(a.Value) {
+		a.Value = b.Value
 	}
-	return cur
+	return a
 }
 
 // maxAgg tracks the maximum value seen.
 type maxAgg struct{}
 
-func (maxAgg) Initial(v decimal.Decimal) decimal.Decimal { return v }
-func (maxAgg) Apply(cur, inc decimal.Decimal) decimal.Decimal {
-	if inc.GreaterThan(cur) {
-		return inc
+func (maxAgg) Initial(obs Observation) AggregateState {
+	return AggregateState{Value: obs.Value}
+}
+
+func (maxAgg) Apply(s AggregateState, obs Observation) AggregateState {
+	if obs.Value.GreaterThan(s.Value) {
+		s.Value = obs.Value
+	}
+	return s
+}
+
+func (maxAgg) Merge(a, b AggregateState) AggregateState {
+	if b.Value.GreaterThan(a.Value) {
+		a.Value = b.Value
+	}
+	return a
+}
+
+// avgAgg keeps the running sum in Sum and derives the mean from Sum / EventCount.
+// Value always holds the mean rounded to avgScale so readers never divide.
+type avgAgg struct{}
+
+func (avgAgg) Initial(obs Observation) AggregateState {
+	return AggregateState{Value: obs.Value, Sum: obs.Value}
+}
+
+func (avgAgg) Apply(s AggregateState, obs Observation) AggregateState {
+	s.Sum = s.Sum.Add(obs.Value)
+	s.Value = meanOf(s.Sum, s.EventCount+1)
+	return s
+}
+
+func (avgAgg) Merge(a, b AggregateState) AggregateState {
+	a.Sum = a.Sum.Add(b.Sum)
+	a.Value = meanOf(a.Sum, a.EventCount+b.EventCount)
+	return a
+}
+
+func (avgAgg) Retract(a, b AggregateState) AggregateState {
+	a.Sum = a.Sum.Sub(b.Sum)
+	a.Value = meanOf(a.Sum, a.EventCount-b.EventCount)
+	return a
+}
+
+func meanOf(sum decimal.Decimal, count int64) decimal.Decimal {
+	if count <= 0 {
+		return decimal.Zero
 	}
-	return cur
+	return sum.DivRound(decimal.NewFromInt(count), avgScale)
+}
+
+// firstAgg keeps the value of the event with the earliest occurred_at.
+// Ties keep the value that was seen first.
+type firstAgg struct{}
+
+func (firstAgg) Initial(obs Observation) AggregateState {
+	return AggregateState{Value: obs.Value, ValueAt: obs.OccurredAt}
+}
+
+func (firstAgg) Apply(s AggregateState, obs Observation) AggregateState {
+	if obs.OccurredAt.Before(s.ValueAt) {
+		s.Value = obs.Value
+		s.ValueAt = obs.OccurredAt
+	}
+	return s
+}
+
+func (firstAgg) Merge(a, b AggregateState) AggregateState {
+	if b.ValueAt.Before(a.ValueAt) {
+		a.Value = b.Value
+		a.ValueAt = b.ValueAt
+	}
+	return a
+}
+
+// lastAgg keeps the value of the event with the latest occurred_at.
+// Ties go to the more recently ingested value.
+type lastAgg struct{}
+
+func (lastAgg) Initial(obs Observation) AggregateState {
+	return AggregateState{Value: obs.Value, ValueAt: obs.OccurredAt}
+}
+
+func (lastAgg) Apply(s AggregateState, obs Observation) AggregateState {
+	if !obs.OccurredAt.Before(s.ValueAt) {
+		s.Value = obs.Value
+		s.ValueAt = obs.OccurredAt
+	}
+	return s
+}
+
+func (lastAgg) Merge(a, b AggregateState) AggregateState {
+	if !b.ValueAt.Before(a.ValueAt) {
+		a.Value = b.Value
+		a.ValueAt = b.ValueAt
+	}
+	return a
+}
+
+// countDistinctAgg estimates the number of distinct Observation.Key values with a
+// HyperLogLog sketch. Events without a key still count towards EventCount. Initial and
+// Apply only add to the sketch; the estimate walks every register, so Finalize derives it.
+type countDistinctAgg struct{}
+
+func (countDistinctAgg) Initial(obs Observation) AggregateState {
+	sketch := NewSketch()
+	if obs.Key != "" {
+		sketch.Add(obs.Key)
+	}
+	return AggregateState{Sketch: sketch}
+}
+
+func (countDistinctAgg) Apply(s AggregateState, obs Observation) AggregateState {
+	if s.Sketch == nil {
+		s.Sketch = NewSketch()
+	}
+	if obs.Key != "" {
+		s.Sketch.Add(obs.Key)
+	}
+	return s
+}
+
+func (countDistinctAgg) Finalize(s AggregateState) AggregateState {
+	if s.Sketch != nil {
+		s.Value = estimateOf(s.Sketch)
+	}
+	return s
+}
+
+func (countDistinctAgg) Merge(a, b AggregateState) AggregateState {
+	var merged *Sketch
+	if a.Sketch != nil {
+		merged = a.Sketch.Clone()
+	} else {
+		merged = NewSketch()
+	}
+	merged.Merge(b.Sketch)
+	a.Sketch = merged
+	a.Value = estimateOf(merged)
+	return a
+}
+
+func estimateOf(sketch *Sketch) decimal.Decimal {
+	return decimal.NewFromInt(int64(sketch.Estimate()))
+}
+
+// quantileAgg tracks the distribution of incoming values in a DDSketch.
+// Value holds the DefaultQuantile estimate; other quantiles are read from Digest.
+// Initial and Apply only add to the digest; Finalize derives the estimate.
+type quantileAgg struct{}
+
+func (quantileAgg) Initial(obs Observation) AggregateState {
+	digest := NewQuantileSketch()
+	digest.Add(obs.Value.InexactFloat64())
+	return AggregateState{Digest: digest}
+}
+
+func (quantileAgg) Apply(s AggregateState, obs Observation) AggregateState {
+	if s.Digest == nil {
+		s.Digest = NewQuantileSketch()
+	}
+	s.Digest.Add(obs.Value.InexactFloat64())
+	return s
+}
+
+func (quantileAgg) Finalize(s AggregateState) AggregateState {
+	if s.Digest != nil {
+		s.Value = quantileOf(s.Digest, DefaultQuantile)
+	}
+	return s
+}
+
+func (quantileAgg) Merge(a, b AggregateState) AggregateState {
+	var merged *QuantileSketch
+	if a.Digest != nil {
+		merged = a.Digest.Clone()
+	} else {
+		merged = NewQuantileSketch()
+	}
+	merged.Merge(b.Digest)
+	a.Digest = merged
+	a.Value = quantileOf(merged, DefaultQuantile)
+	return a
+}
+
+// QuantileValue returns the estimate for q from a quantile digest as a decimal.
+// A nil digest yields zero.
+func QuantileValue(digest *QuantileSketch, q float64) decimal.Decimal {
+	if digest == nil {
+		return decimal.Zero
+	}
+	return quantileOf(digest, q)
+}
+
+func quantileOf(digest *QuantileSketch, q float64) decimal.Decimal {
+	return decimal.NewFromFloat(digest.Quantile(q))
 }
@@ -2,6 +2,7 @@ package aggregation
 
 import (
 	"testing"
+	"time"
 
 	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/require"
@@ -77,17 +78,113 @@ func TestOperators_InitialAndApply(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			agg, ok := Operators[tc.op]
 			require.True(t, ok)
-			require.True(t, tc.wantInitial.Equal(agg.Initial(tc.incoming)))
-			require.True(t, tc.wantApply.Equal(agg.Apply(tc.current, tc.next)))
+			require.True(t, tc.wantInitial.Equal(agg.Initial(Observation{Value: tc.incoming}).Value))
+
+			applied := agg.Apply(AggregateState{Value: tc.current}, Observation{Value: tc.next})
+			require.True(t, tc.wantApply.Equal(applied.Value))
 		})
 	}
 }
 
+func TestAvgAgg_TracksSumAndMean(t *testing.T) {
+	agg := Operators[OpAvg]
+
+	state := agg.Initial(Observation{Value: decimal.NewFromInt(10)})
+	state.EventCount = 1
+	require.True(t, decimal.NewFromInt(10).Equal(state.Value))
+	require.True(t, decimal.NewFromInt(10).Equal(state.Sum))
+
+	state = agg.Apply(state, Observation{Value: decimal.NewFromInt(20)})
+	state.EventCount++
+	state = agg.Apply(state, Observation{Value: decimal.NewFromInt(0)})
+	state.EventCount++
+	require.True(t, decimal.NewFromInt(30).Equal(state.Sum))
+	require.True(t, decimal.NewFromInt(10).Equal(state.Value))
+
+	// Merging weights by event count, not by bucket: (30 + 5) / (3 + 1).
+	other := AggregateState{Value: decimal.NewFromInt(5), Sum: decimal.NewFromInt(5), EventCount: 1}
+	merged := agg.Merge(state, other)
+	require.True(t, decimal.NewFromInt(35).Equal(merged.Sum))
+	require.True(t, decimal.RequireFromString("8.75").Equal(merged.Value))
+}
+
+func TestRetractor_UndoesMerge(t *testing.T) {
+	base := AggregateState{Value: decimal.NewFromInt(3), Sum: decimal.NewFromInt(6), EventCount: 2}
+	part := AggregateState{Value: decimal.NewFromInt(4), Sum: decimal.NewFromInt(4), EventCount: 1}
+
+	for _, op := range []string{OpCount, OpSum, OpAvg} {
+		agg := Operators[op]
+		retractor, ok := agg.(Retractor)
+		require.True(t, ok, op)
+
+		merged := agg.Merge(base, part)
+		merged.EventCount += part.EventCount
+		got := retractor.Retract(merged, part)
+		require.True(t, base.Value.Equal(got.Value), "%s: got %s", op, got.Value)
+	}
+
+	for _, op := range []string{OpMin, OpMax, OpFirst, OpLast, OpCountDistinct, OpQuantile} {
+		_, ok := Operators[op].(Retractor)
+		require.False(t, ok, op)
+	}
+}
+
+func TestAvgAgg_RoundsMeanToFixedScale(t *testing.T) {
+	agg := Operators[OpAvg]
+
+	state := AggregateState{Sum: decimal.NewFromInt(1), Value: decimal.NewFromInt(1), EventCount: 1}
+	state = agg.Apply(state, Observation{Value: decimal.Zero})
+	state.EventCount++
+	state = agg.Apply(state, Observation{Value: decimal.Zero})
+
+	require.Equal(t, "0.3333333333333333", state.Value.String())
+}
+
+func TestFirstLastAgg_OrderByOccurredAt(t *testing.T) {
+	base := time.Date(2026, 2, 1, 10, 0, 0, 0, time.UTC)
+	early := Observation{Value: decimal.NewFromInt(1), OccurredAt: base}
+	late := Observation{Value: decimal.NewFromInt(2), OccurredAt: base.Add(time.Minute)}
+	tie := Observation{Value: decimal.NewFromInt(3), OccurredAt: base.Add(time.Minute)}
+
+	first := Operators[OpFirst]
+	last := Operators[OpLast]
+
+	t.Run("apply out of order", func(t *testing.T) {
+		firstState := first.Apply(first.Initial(late), early)
+		require.True(t, early.Value.Equal(firstState.Value))
+		require.Equal(t, early.OccurredAt, firstState.ValueAt)
+
+		lastState := last.Apply(last.Initial(late), early)
+		require.True(t, late.Value.Equal(lastState.Value))
+		require.Equal(t, late.OccurredAt, lastState.ValueAt)
+	})
+
+	t.Run("ties keep first seen for first and latest seen for last", func(t *testing.T) {
+		require.True(t, late.Value.Equal(first.Apply(first.Initial(late), tie).Value))
+		require.True(t, tie.Value.Equal(last.Apply(last.Initial(late), tie).Value))
+	})
+
+	t.Run("merge compares timestamps", func(t *testing.T) {
+		a := first.Initial(late)
+		b := first.Initial(early)
+		require.True(t, early.Value.Equal(first.Merge(a, b).Value))
+
+		a = last.Initial(late)
+		b = last.Initial(early)
+		require.True(t, late.Value.Equal(last.Merge(a, b).Value))
+	})
+}
+
 func TestValidOperator(t *testing.T) {
 	require.True(t, ValidOperator(OpCount))
 	require.True(t, ValidOperator(OpSum))
 	require.True(t, ValidOperator(OpMin))
 	require.True(t, ValidOperator(OpMax))
-	require.False(t, ValidOperator("avg"))
+	require.True(t, ValidOperator(OpAvg))
+	require.True(t, ValidOperator(OpFirst))
+	require.True(t, ValidOperator(OpLast))
+	require.True(t, ValidOperator(OpCountDistinct))
+	require.True(t, ValidOperator(OpQuantile))
+	require.False(t, ValidOperator("median"))
 	require.False(t, ValidOperator(""))
 }
@@ -0,0 +1,68 @@
+package aggregation
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// DimensionsFor returns the group_by values of an event for rule r, encoded
+// canonically so they can be part of AggregateKey and the pre_aggregates primary key.
+// The encoding is a JSON object keyed by group_by path with string values (null when
+// the event lacks the field), e.g. {"data.model":"gpt-4","metadata.region":"eu"}.
+// Rules without group_by return the empty string.
+func (r AggregationRule) DimensionsFor(data map[string]interface{}, metadata map[string]string) string {
+	if len(r.GroupBy) == 0 {
+		return ""
+	}
+
+	values := make(map[string]interface{}, len(r.GroupBy))
+	for _, path := range r.GroupBy {
+		node, err := parsePath(path)
+		if err != nil {
+			values[path] = nil
+			continue
+		}
+		if v, ok := scalarString(node.lookup(data, metadata)); ok {
+			values[path] = v
+		} else {
+			values[path] = nil
+		}
+	}
+
+	// Map keys are marshalled in sorted order, which keeps the encoding canonical.
+	encoded, err := json.Marshal(values)
+	if err != nil {
+		return ""
+	}
+	return string(encoded)
+}
+
+// DecodeDimensions parses an encoded dimension tuple. Values are strings or nil.
+// The empty string decodes to an empty map.
+func DecodeDimensions(encoded string) (map[string]interface{}, error) {
+	values := make(map[string]interface{})
+	if encoded == "" {
+		return values, nil
+	}
+	if err := json.Unmarshal([]byte(encoded), &values); err != nil {
+		return nil, fmt.Errorf("decode dimensions %q: %w", encoded, err)
+	}
+	return values, nil
+}
+
+// validateGroupBy checks that every group_by entry is a valid, unique event path.
+func validateGroupBy(groupBy []string) error {
+	seen := make(map[string]struct{}, len(groupBy))
+	for _, path := range groupBy {
+		path = strings.TrimSpace(path)
+		if _, err := parsePath(path); err != nil {
+			return fmt.Errorf("group_by: %w", err)
+		}
+		if _, dup := seen[path]; dup {
+			return fmt.Errorf("group_by: duplicate path %q", path)
+		}
+		seen[path] = struct{}{}
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package aggregation
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDimensionsFor_CanonicalEncoding(t *testing.T) {
+	rule := AggregationRule{GroupBy: []string{"metadata.region", "data.model", "data.user.tier"}}
+
+	encoded := rule.DimensionsFor(
+		map[string]interface{}{
+			"model": "gpt-4",
+			"user":  map[string]interface{}{"tier": 2.0},
+		},
+		map[string]string{"region": "eu"},
+	)
+	require.Equal(t, `{"data.model":"gpt-4","data.user.tier":"2","metadata.region":"eu"}`, encoded)
+
+	missing := rule.DimensionsFor(map[string]interface{}{"model": "gpt-4"}, nil)
+	require.Equal(t, `{"data.model":"gpt-4","data.user.tier":null,"metadata.region":null}`, missing)
+
+	require.Equal(t, "", AggregationRule{}.DimensionsFor(map[string]interface{}{"model": "gpt-4"}, nil))
+}
+
+func TestDecodeDimensions(t *testing.T) {
+	values, err := DecodeDimensions(`{"data.model":"gpt-4","metadata.region":null}`)
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"data.model": "gpt-4", "metadata.region": nil}, values)
+
+	empty, err := DecodeDimensions("")
+	require.NoError(t, err)
+	require.Empty(t, empty)
+
+	_, err = DecodeDimensions("{")
+	require.Error(t, err)
+}
@@ -0,0 +1,524 @@
+package aggregation
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/shopspring/decimal"
+)
+
+// Filter is a compiled rule filter expression. Rules only aggregate events for which
+// the filter matches. The language is intentionally small:
+//
+//	data.status >= 500 && metadata.region == "eu"
+//	data.method in ["POST", "PUT"] || !(data.cached == true)
+//
+// Operands are literals (numbers, "strings" or 'strings', true, false, null, [lists])
+// or paths rooted at data (nested with dots) or metadata. Comparison operators are
+// == != < <= > >= and in; logical operators are && || and !. Numbers compare exactly
+// as decimals, and numeric strings compare as numbers against numbers, so metadata
+// values can be compared numerically. Missing fields evaluate to null.
+type Filter struct {
+	source string
+	root   filterNode
+}
+
+// CompileFilter parses expr into a Filter. Rules compile their filter once at load time.
+func CompileFilter(expr string) (*Filter, error) {
+	p := &filterParser{lexer: filterLexer{src: expr}}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, p.errorf("unexpected %q", p.tok.text)
+	}
+	return &Filter{source: expr, root: root}, nil
+}
+
+// String returns the source expression.
+func (f *Filter) String() string {
+	return f.source
+}
+
+// Match evaluates the filter against an event's data and metadata.
+func (f *Filter) Match(data map[string]interface{}, metadata map[string]string) bool {
+	return isTrue(f.root.eval(filterEnv{data: data, metadata: metadata}))
+}
+
+type filterEnv struct {
+	data     map[string]interface{}
+	metadata map[string]string
+}
+
+// filterNode evaluates to nil, bool, string, decimal.Decimal or []interface{}.
+type filterNode interface {
+	eval(env filterEnv) interface{}
+}
+
+type literalNode struct{ value interface{} }
+
+func (n literalNode) eval(filterEnv) interface{} { return n.value }
+
+type listNode struct{ items []filterNode }
+
+func (n listNode) eval(env filterEnv) interface{} {
+	out := make([]interface{}, len(n.items))
+	for i, item := range n.items {
+		out[i] = item.eval(env)
+	}
+	return out
+}
+
+type pathNode struct {
+	root string // "data" or "metadata"
+	path []string
+}
+
+func (n pathNode) eval(env filterEnv) interface{} {
+	return normalizeFilterValue(n.lookup(env.data, env.metadata))
+}
+
+// lookup returns the raw value at the path, or nil if any segment is missing.
+func (n pathNode) lookup(data map[string]interface{}, metadata map[string]string) interface{} {
+	if n.root == "metadata" {
+		v, ok := metadata[n.path[0]]
+		if !ok {
+			return nil
+		}
+		return v
+	}
+
+	var current interface{} = data
+	for _, segment := range n.path {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current = m[segment]
+	}
+	return current
+}
+
+type notNode struct{ operand filterNode }
+
+func (n notNode) eval(env filterEnv) interface{} { return !isTrue(n.operand.eval(env)) }
+
+type logicalNode struct {
+	and         bool
+	left, right filterNode
+}
+
+func (n logicalNode) eval(env filterEnv) interface{} {
+	left := isTrue(n.left.eval(env))
+	if n.and {
+		return left && isTrue(n.right.eval(env))
+	}
+	return left || isTrue(n.right.eval(env))
+}
+
+type compareNode struct {
+	op          string
+	left, right filterNode
+}
+
+func (n compareNode) eval(env filterEnv) interface{} {
+	left, right := n.left.eval(env), n.right.eval(env)
+	switch n.op {
+	case "==":
+		return filterEqual(left, right)
+	case "!=":
+		return !filterEqual(left, right)
+	case "in":
+		items, ok := right.([]interface{})
+		if !ok {
+			return false
+		}
+		for _, item := range items {
+			if filterEqual(left, item) {
+				return true
+			}
+		}
+		return false
+	}
+
+	cmp, ok := filterCompare(left, right)
+	if !ok {
+		return false
+	}
+	switch n.op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
+
+func isTrue(v interface{}) bool {
+	b, ok := v.(bool)
+	return ok && b
+}
+
+// normalizeFilterValue maps JSON-decoded event data onto the filter value model.
+func normalizeFilterValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case float64:
+		return decimal.NewFromFloat(val)
+	case float32:
+		return decimal.NewFromFloat(float64(val))
+	case int:
+		return decimal.NewFromInt(int64(val))
+	case int64:
+		return decimal.NewFromInt(val)
+	case int32:
+		return decimal.NewFromInt(int64(val))
+	case []interface{}:
+		out := make([]interface{}, len(val))
+		for i, item := range val {
+			out[i] = normalizeFilterValue(item)
+		}
+		return out
+	}
+	return v
+}
+
+func filterEqual(a, b interface{}) bool {
+	if cmp, ok := filterCompare(a, b); ok {
+		return cmp == 0
+	}
+	switch av := a.(type) {
+	case nil:
+		return b == nil
+	case bool:
+		bv, ok := b.(bool)
+		return ok && av == bv
+	}
+	return false
+}
+
+// filterCompare orders two numbers (numeric strings count as numbers when compared
+// with a number) or two strings. ok is false for any other combination.
+func filterCompare(a, b interface{}) (int, bool) {
+	if as, ok := a.(string); ok {
+		if bs, ok := b.(string); ok {
+			return strings.Compare(as, bs), true
+		}
+	}
+	ad, aok := asFilterNumber(a)
+	bd, bok := asFilterNumber(b)
+	if !aok || !bok {
+		return 0, false
+	}
+	_, aNum := a.(decimal.Decimal)
+	_, bNum := b.(decimal.Decimal)
+	if !aNum && !bNum {
+		return 0, false
+	}
+	return ad.Cmp(bd), true
+}
+
+func asFilterNumber(v interface{}) (decimal.Decimal, bool) {
+	switch val := v.(type) {
+	case decimal.Decimal:
+		return val, true
+	case string:
+		d, err := decimal.NewFromString(val)
+		return d, err == nil
+	}
+	return decimal.Zero, false
+}
+
+// --- lexer -------------------------------------------------------------------
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokNumber
+	tokString
+	tokOp
+)
+
+type filterToken struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+type filterLexer struct {
+	src string
+	pos int
+}
+
+func (l *filterLexer) next() (filterToken, error) {
+	for l.pos < len(l.src) && unicode.IsSpace(rune(l.src[l.pos])) {
+		l.pos++
+	}
+	start := l.pos
+	if l.pos >= len(l.src) {
+		return filterToken{kind: tokEOF, pos: start}, nil
+	}
+
+	c := l.src[l.pos]
+	switch {
+	case c == '"' || c == '\'':
+		l.pos++
+		var b strings.Builder
+		for l.pos < len(l.src) && l.src[l.pos] != c {
+			if l.src[l.pos] == '\\' && l.pos+1 < len(l.src) {
+				l.pos++
+			}
+			b.WriteByte(l.src[l.pos])
+			l.pos++
+		}
+		if l.pos >= len(l.src) {
+			return filterToken{}, fmt.Errorf("filter: unterminated string at position %d", start)
+		}
+		l.pos++
+		return filterToken{kind: tokString, text: b.String(), pos: start}, nil
+	case isDigit(c) || (c == '-' && l.pos+1 < len(l.src) && isDigit(l.src[l.pos+1])):
+		l.pos++
+		for l.pos < len(l.src) && (isDigit(l.src[l.pos]) || l.src[l.pos] == '.') {
+			l.pos++
+		}
+		return filterToken{kind: tokNumber, text: l.src[start:l.pos], pos: start}, nil
+	case isIdentStart(c):
+		for l.pos < len(l.src) && (isIdentStart(l.src[l.pos]) || isDigit(l.src[l.pos]) || l.src[l.pos] == '.') {
+			l.pos++
+		}
+		return filterToken{kind: tokIdent, text: l.src[start:l.pos], pos: start}, nil
+	}
+
+	for _, op := range []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "(", ")", "[", "]", ","} {
+		if strings.HasPrefix(l.src[l.pos:], op) {
+			l.pos += len(op)
+			return filterToken{kind: tokOp, text: op, pos: start}, nil
+		}
+	}
+	return filterToken{}, fmt.Errorf("filter: unexpected character %q at position %d", c, start)
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// --- parser ------------------------------------------------------------------
+
+var comparisonOps = map[string]bool{"==": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true}
+
+type filterParser struct {
+	lexer filterLexer
+	tok   filterToken
+}
+
+func (p *filterParser) advance() error {
+	tok, err := p.lexer.next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+func (p *filterParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("filter: %s at position %d", fmt.Sprintf(format, args...), p.tok.pos)
+}
+
+func (p *filterParser) isOp(text string) bool {
+	return p.tok.kind == tokOp && p.tok.text == text
+}
+
+func (p *filterParser) expectOp(text string) error {
+	if !p.isOp(text) {
+		if p.tok.kind == tokEOF {
+			return p.errorf("expected %q, got end of expression", text)
+		}
+		return p.errorf("expected %q, got %q", text, p.tok.text)
+	}
+	return p.advance()
+}
+
+func (p *filterParser) parseOr() (filterNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("||") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = logicalNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (filterNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("&&") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = logicalNode{and: true, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseUnary() (filterNode, error) {
+	if p.isOp("!") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{operand: operand}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *filterParser) parseComparison() (filterNode, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	var op string
+	switch {
+	case p.tok.kind == tokOp && comparisonOps[p.tok.text]:
+		op = p.tok.text
+	case p.tok.kind == tokIdent && p.tok.text == "in":
+		op = "in"
+	default:
+		return left, nil
+	}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	if _, isList := right.(listNode); op == "in" && !isList {
+		return nil, p.errorf("in requires a [list]")
+	}
+	return compareNode{op: op, left: left, right: right}, nil
+}
+
+func (p *filterParser) parseOperand() (filterNode, error) {
+	tok := p.tok
+	switch tok.kind {
+	case tokEOF:
+		return nil, p.errorf("unexpected end of expression")
+	case tokNumber:
+		d, err := decimal.NewFromString(tok.text)
+		if err != nil {
+			return nil, p.errorf("invalid number %q", tok.text)
+		}
+		return literalNode{value: d}, p.advance()
+	case tokString:
+		return literalNode{value: tok.text}, p.advance()
+	case tokIdent:
+		node, err := identNode(tok.text)
+		if err != nil {
+			return nil, fmt.Errorf("%w at position %d", err, tok.pos)
+		}
+		return node, p.advance()
+	}
+
+	switch tok.text {
+	case "(":
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return inner, p.expectOp(")")
+	case "[":
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		var items []filterNode
+		for !p.isOp("]") {
+			item, err := p.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+			if !p.isOp(",") {
+				break
+			}
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+		}
+		return listNode{items: items}, p.expectOp("]")
+	}
+	return nil, p.errorf("unexpected %q", tok.text)
+}
+
+func identNode(text string) (filterNode, error) {
+	switch text {
+	case "true":
+		return literalNode{value: true}, nil
+	case "false":
+		return literalNode{value: false}, nil
+	case "null":
+		return literalNode{value: nil}, nil
+	}
+
+	node, err := parsePath(text)
+	if err != nil {
+		return nil, fmt.Errorf("filter: %w", err)
+	}
+	return node, nil
+}
+
+// parsePath validates an event path (data.<field>[.<field>...] or metadata.<key>).
+func parsePath(text string) (pathNode, error) {
+	parts := strings.Split(text, ".")
+	for _, part := range parts {
+		if part == "" {
+			return pathNode{}, fmt.Errorf("invalid path %q", text)
+		}
+	}
+	switch parts[0] {
+	case "data":
+		if len(parts) < 2 {
+			return pathNode{}, fmt.Errorf("path %q must name a field (data.<field>)", text)
+		}
+	case "metadata":
+		if len(parts) != 2 {
+			return pathNode{}, fmt.Errorf("path %q must be metadata.<key>", text)
+		}
+	default:
+		return pathNode{}, fmt.Errorf("unknown identifier %q (paths start with data. or metadata.)", text)
+	}
+	return pathNode{root: parts[0], path: parts[1:]}, nil
+}
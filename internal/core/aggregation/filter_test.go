@@ -0,0 +1,72 @@
+package aggregation
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilter_Match(t *testing.T) {
+	data := map[string]interface{}{
+		"status":  503.0,
+		"method":  "POST",
+		"cached":  false,
+		"latency": "12.5",
+		"client":  map[string]interface{}{"tier": "gold"},
+	}
+	metadata := map[string]string{"region": "eu", "attempt": "3"}
+
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{`data.status >= 500`, true},
+		{`data.status < 500`, false},
+		{`data.status == 503 && metadata.region == "eu"`, true},
+		{`data.status == 503 && metadata.region == 'us'`, false},
+		{`metadata.region == "us" || data.method == "POST"`, true},
+		{`data.method in ["PUT", "POST"]`, true},
+		{`data.method in []`, false},
+		{`!(data.cached == true)`, true},
+		{`data.cached`, false},
+		{`data.client.tier == "gold"`, true},
+		{`data.missing == null`, true},
+		{`data.missing > 0`, false},
+		{`data.missing != "x"`, true},
+		{`metadata.attempt > 2`, true},
+		{`data.latency == 12.50`, true},
+		{`data.method > "GET"`, true},
+		{`data.status == "503"`, true},
+		{`metadata.region == 1`, false},
+		{`data.status >= -1 && (data.status < 600 || false)`, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.expr, func(t *testing.T) {
+			f, err := CompileFilter(tc.expr)
+			require.NoError(t, err)
+			require.Equal(t, tc.want, f.Match(data, metadata))
+			require.Equal(t, tc.expr, f.String())
+		})
+	}
+}
+
+func TestCompileFilter_Errors(t *testing.T) {
+	for _, expr := range []string{
+		``,
+		`data.status >=`,
+		`status == 1`,
+		`data == 1`,
+		`metadata.a.b == "x"`,
+		`data.method in "POST"`,
+		`(data.status == 1`,
+		`data.method == "POST`,
+		`data.status = 1`,
+		`data.status == 1 data.method`,
+	} {
+		t.Run(expr, func(t *testing.T) {
+			_, err := CompileFilter(expr)
+			require.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package aggregation
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/bits"
+	"strconv"
+)
+
+// HyperLogLog parameters. Precision 12 gives 4096 registers and a standard error
+// of ~1.6%, small enough to keep one sketch per principal per minute bucket.
+const (
+	sketchPrecision = 12
+	sketchRegisters = 1 << sketchPrecision
+
+	sketchFormatSparse byte = 1
+	sketchFormatDense  byte = 2
+)
+
+var errInvalidSketch = errors.New("invalid hll sketch encoding")
+
+// Sketch is a HyperLogLog distinct-count sketch. Sketches are mergeable:
+// the union of two sketches is the register-wise maximum, so minute buckets
+// roll up into hour/day/total buckets without losing distinctness.
+//
+// Hashing is deterministic across processes so sketches persisted by one
+// instance merge correctly with sketches built by another.
+type Sketch struct {
+	registers []uint8
+}
+
+// NewSketch returns an empty sketch.
+func NewSketch() *Sketch {
+	return &Sketch{registers: make([]uint8, sketchRegisters)}
+}
+
+// Add records one key in the sketch.
+func (s *Sketch) Add(key string) {
+	h := hashKey(key)
+	idx := h >> (64 - sketchPrecision)
+	// Leading zeros of the remaining bits; the sentinel bit caps the rank.
+	rank := uint8(bits.LeadingZeros64(h<<sketchPrecision|1<<(sketchPrecision-1)) + 1)
+	if rank > s.registers[idx] {
+		s.registers[idx] = rank
+	}
+}
+
+// Merge folds other into s (set union).
+func (s *Sketch) Merge(other *Sketch) {
+	if other == nil {
+		return
+	}
+	for i, r := range other.registers {
+		if r > s.registers[i] {
+			s.registers[i] = r
+		}
+	}
+}
+
+// Clone returns an independent copy of the sketch.
+func (s *Sketch) Clone() *Sketch {
+	registers := make([]uint8, len(s.registers))
+	copy(registers, s.registers)
+	return &Sketch{registers: registers}
+}
+
+// Estimate returns the approximate number of distinct keys added.
+// Small cardinalities use linear counting, which is exact-ish while most
+// registers are still empty.
+func (s *Sketch) Estimate() uint64 {
+	m := float64(sketchRegisters)
+	var sum float64
+	var zeros int
+	for _, r := range s.registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+
+	alpha := 0.7213 / (1 + 1.079/m)
+	estimate := alpha * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(math.Round(estimate))
+}
+
+// MarshalBinary encodes the sketch. Sparse sketches (few non-zero registers)
+// are stored as (index, rank) pairs; dense sketches store every register.
+func (s *Sketch) MarshalBinary() ([]byte, error) {
+	nonZero := 0
+	for _, r := range s.registers {
+		if r != 0 {
+			nonZero++
+		}
+	}
+
+	if nonZero*3 < sketchRegisters {
+		buf := make([]byte, 0, 2+binary.MaxVarintLen32+nonZero*3)
+		buf = append(buf, sketchFormatSparse, sketchPrecision)
+		buf = binary.AppendUvarint(buf, uint64(nonZero))
+		for i, r := range s.registers {
+			if r == 0 {
+				continue
+			}
+			buf = binary.BigEndian.AppendUint16(buf, uint16(i))
+			buf = append(buf, r)
+		}
+		return buf, nil
+	}
+
+	buf := make([]byte, 0, 2+sketchRegisters)
+	buf = append(buf, sketchFormatDense, sketchPrecision)
+	buf = append(buf, s.registers...)
+	return buf, nil
+}
+
+// UnmarshalBinary decodes a sketch produced by MarshalBinary.
+func (s *Sketch) UnmarshalBinary(data []byte) error {
+	if len(data) < 2 {
+		return errInvalidSketch
+	}
+	if data[1] != sketchPrecision {
+		return fmt.Errorf("%w: unsupported precision %d", errInvalidSketch, data[1])
+	}
+
+	registers := make([]uint8, sketchRegisters)
+	body := data[2:]
+	switch data[0] {
+	case sketchFormatSparse:
+		n, read := binary.Uvarint(body)
+		if read <= 0 || uint64(len(body)-read) != n*3 {
+			return errInvalidSketch
+		}
+		body = body[read:]
+		for i := uint64(0); i < n; i++ {
+			idx := binary.BigEndian.Uint16(body[i*3:])
+			if int(idx) >= sketchRegisters {
+				return errInvalidSketch
+			}
+			registers[idx] = body[i*3+2]
+		}
+	case sketchFormatDense:
+		if len(body) != sketchRegisters {
+			return errInvalidSketch
+		}
+		copy(registers, body)
+	default:
+		return fmt.Errorf("%w: unknown format %d", errInvalidSketch, data[0])
+	}
+
+	s.registers = registers
+	return nil
+}
+
+// hashKey is FNV-1a followed by the murmur3 64-bit finalizer; FNV alone
+// does not spread short keys well enough across the high bits HLL relies on.
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// ExtractKey pulls the distinct key for count_distinct from the event's Data map.
+// Strings are used as-is; numbers and booleans use their canonical text form.
+// Returns "" if the field is missing, null, or not a scalar.
+func ExtractKey(data map[string]interface{}, field string) string {
+	if field == "" {
+		return ""
+	}
+	key, _ := scalarString(data[field])
+	return key
+}
+
+// scalarString renders a JSON scalar as text. ok is false for null and non-scalars.
+func scalarString(v interface{}) (string, bool) {
+	switch val := v.(type) {
+	case string:
+		return val, true
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64), true
+	case float32:
+		return strconv.FormatFloat(float64(val), 'f', -1, 32), true
+	case int:
+		return strconv.Itoa(val), true
+	case int64:
+		return strconv.FormatInt(val, 10), true
+	case int32:
+		return strconv.FormatInt(int64(val), 10), true
+	case bool:
+		return strconv.FormatBool(val), true
+	}
+	return "", false
+}
@@ -0,0 +1,110 @@
+package aggregation
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSketch_EstimateWithinErrorBound(t *testing.T) {
+	for _, n := range []int{1, 10, 1000, 50000} {
+		t.Run(fmt.Sprintf("n=%d", n), func(t *testing.T) {
+			s := NewSketch()
+			for i := 0; i < n; i++ {
+				s.Add(fmt.Sprintf("device-%d", i))
+				s.Add(fmt.Sprintf("device-%d", i)) // duplicates must not count
+			}
+			got := float64(s.Estimate())
+			require.InDelta(t, float64(n), got, math.Max(1, float64(n)*0.05))
+		})
+	}
+}
+
+func TestSketch_MergeIsUnion(t *testing.T) {
+	a, b, union := NewSketch(), NewSketch(), NewSketch()
+	for i := 0; i < 3000; i++ {
+		key := fmt.Sprintf("k-%d", i)
+		if i < 2000 {
+			a.Add(key)
+		}
+		if i >= 1000 {
+			b.Add(key)
+		}
+		union.Add(key)
+	}
+
+	merged := a.Clone()
+	merged.Merge(b)
+	require.Equal(t, union.Estimate(), merged.Estimate())
+	require.NotEqual(t, a.Estimate(), merged.Estimate(), "clone must not alias the original")
+}
+
+func TestSketch_BinaryRoundTrip(t *testing.T) {
+	for _, n := range []int{0, 5, 5000} {
+		t.Run(fmt.Sprintf("n=%d", n), func(t *testing.T) {
+			s := NewSketch()
+			for i := 0; i < n; i++ {
+				s.Add(fmt.Sprintf("k-%d", i))
+			}
+			data, err := s.MarshalBinary()
+			require.NoError(t, err)
+
+			var decoded Sketch
+			require.NoError(t, decoded.UnmarshalBinary(data))
+			require.Equal(t, s.registers, decoded.registers)
+		})
+	}
+
+	var s Sketch
+	require.Error(t, s.UnmarshalBinary([]byte{9, sketchPrecision}))
+	require.Error(t, s.UnmarshalBinary([]byte{sketchFormatSparse, sketchPrecision, 2, 0, 1}))
+}
+
+func TestCountDistinctAgg_MergeDoesNotMutateInputs(t *testing.T) {
+	agg := Operators[OpCountDistinct]
+
+	a := agg.Initial(Observation{Key: "x"})
+	b := agg.Apply(agg.Initial(Observation{Key: "y"}), Observation{Key: "z"})
+	// Events without a key count as events but not as distinct values.
+	b = agg.Apply(b, Observation{})
+
+	merged := agg.Merge(a, b)
+	require.Equal(t, "3", merged.Value.String())
+	require.True(t, a.Value.IsZero())
+	require.Equal(t, uint64(1), a.Sketch.Estimate())
+}
+
+func TestCountDistinctAgg_FinalizeDerivesEstimate(t *testing.T) {
+	agg := Operators[OpCountDistinct]
+
+	state := agg.Initial(Observation{Key: "x"})
+	state.Operator = OpCountDistinct
+	for _, key := range []string{"y", "z", "x"} {
+		state = agg.Apply(state, Observation{Key: key})
+	}
+	// Initial and Apply only add to the sketch.
+	require.True(t, state.Value.IsZero())
+	require.Equal(t, "3", Finalize(state).Value.String())
+
+	// Operators that keep Value current are returned as they are.
+	count := AggregateState{Operator: OpCount, Value: decimal.NewFromInt(4)}
+	require.Equal(t, count, Finalize(count))
+}
+
+func TestExtractKey(t *testing.T) {
+	data := map[string]interface{}{
+		"s": "abc",
+		"f": float64(42),
+		"b": true,
+		"o": map[string]interface{}{},
+	}
+	require.Equal(t, "abc", ExtractKey(data, "s"))
+	require.Equal(t, "42", ExtractKey(data, "f"))
+	require.Equal(t, "true", ExtractKey(data, "b"))
+	require.Equal(t, "", ExtractKey(data, "o"))
+	require.Equal(t, "", ExtractKey(data, "missing"))
+	require.Equal(t, "", ExtractKey(data, ""))
+}
@@ -0,0 +1,97 @@
+package aggregation
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Late event policies. An event is late for a rule when it was ingested more than the
+// rule's allowed_lateness after it occurred.
+const (
+	// LatePolicyAccept aggregates late events into the bucket they occurred in.
+	LatePolicyAccept = "accept"
+	// LatePolicyReject refuses late events at ingestion and ignores any in the event log.
+	LatePolicyReject = "reject"
+	// LatePolicyCorrection aggregates late events into the bucket they were ingested in,
+	// so closed periods never change and the correction is billed in the open one.
+	LatePolicyCorrection = "correction"
+)
+
+// ValidLatePolicy reports whether policy is a known late event policy.
+func ValidLatePolicy(policy string) bool {
+	switch policy {
+	case LatePolicyAccept, LatePolicyReject, LatePolicyCorrection:
+		return true
+	}
+	return false
+}
+
+// IsLate reports whether an event that occurred at occurredAt and was ingested at
+// ingestedAt is past the rule's allowed lateness. Rules without a watermark never see
+// late events.
+func (r AggregationRule) IsLate(occurredAt, ingestedAt time.Time) bool {
+	if r.AllowedLateness <= 0 || ingestedAt.IsZero() {
+		return false
+	}
+	return ingestedAt.Sub(occurredAt) > r.AllowedLateness
+}
+
+// EventTime returns the time an event is bucketed at for rule r, applying the late
+// event policy. ok is false if the rule drops the event.
+func (r AggregationRule) EventTime(occurredAt, ingestedAt time.Time) (t time.Time, ok bool) {
+	if !r.IsLate(occurredAt, ingestedAt) {
+		return occurredAt, true
+	}
+	switch r.LatePolicy {
+	case LatePolicyReject:
+		return time.Time{}, false
+	case LatePolicyCorrection:
+		return ingestedAt, true
+	default:
+		return occurredAt, true
+	}
+}
+
+// BucketsByIngestion reports whether r buckets late events at their ingestion time. Raw
+// event scans of a range must then also read the events ingested in it.
+func (r AggregationRule) BucketsByIngestion() bool {
+	return r.AllowedLateness > 0 && r.LatePolicy == LatePolicyCorrection
+}
+
+// FinalizedThrough returns the end of the last bucket that can no longer change at
+// time now: every event that occurred before it and arrives from now on is late. Only
+// rules that keep late events out of closed buckets have one.
+func (r AggregationRule) FinalizedThrough(now time.Time) (time.Time, bool) {
+	if r.AllowedLateness <= 0 || r.LatePolicy == LatePolicyAccept || r.LatePolicy == "" {
+		return time.Time{}, false
+	}
+	return BucketFor(now.Add(-r.AllowedLateness), r.Buckets()[0]), true
+}
+
+// parseLatePolicy validates the allowed_lateness and late_policy fields of a rule.
+// A policy requires a watermark; a watermark without a policy accepts late events.
+func parseLatePolicy(allowedLateness, policy string) (time.Duration, string, error) {
+	allowedLateness = strings.TrimSpace(allowedLateness)
+	policy = strings.TrimSpace(policy)
+
+	if allowedLateness == "" {
+		if policy != "" {
+			return 0, "", fmt.Errorf("late_policy requires allowed_lateness")
+		}
+		return 0, "", nil
+	}
+
+	spec, err := ParseWindowSize(allowedLateness)
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid allowed_lateness %q (e.g. 30m, 72h, 7d)", allowedLateness)
+	}
+
+	if policy == "" {
+		policy = LatePolicyAccept
+	}
+	if !ValidLatePolicy(policy) {
+		return 0, "", fmt.Errorf("unsupported late_policy %q (must be accept, reject or correction)", policy)
+	}
+	return spec.Size, policy, nil
+}
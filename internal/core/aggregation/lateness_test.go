@@ -0,0 +1,55 @@
+package aggregation
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAggregationRule_EventTime(t *testing.T) {
+	ingestedAt := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	onTime := ingestedAt.Add(-time.Hour)
+	late := ingestedAt.Add(-21 * 24 * time.Hour)
+
+	tests := []struct {
+		name       string
+		policy     string
+		lateness   time.Duration
+		occurredAt time.Time
+		wantTime   time.Time
+		wantOK     bool
+	}{
+		{name: "no watermark", policy: "", lateness: 0, occurredAt: late, wantTime: late, wantOK: true},
+		{name: "on time", policy: LatePolicyReject, lateness: 72 * time.Hour, occurredAt: onTime, wantTime: onTime, wantOK: true},
+		{name: "late accepted", policy: LatePolicyAccept, lateness: 72 * time.Hour, occurredAt: late, wantTime: late, wantOK: true},
+		{name: "late rejected", policy: LatePolicyReject, lateness: 72 * time.Hour, occurredAt: late, wantOK: false},
+		{name: "late corrected", policy: LatePolicyCorrection, lateness: 72 * time.Hour, occurredAt: late, wantTime: ingestedAt, wantOK: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rule := AggregationRule{AllowedLateness: tc.lateness, LatePolicy: tc.policy}
+			got, ok := rule.EventTime(tc.occurredAt, ingestedAt)
+			require.Equal(t, tc.wantOK, ok)
+			if ok {
+				require.Equal(t, tc.wantTime, got)
+			}
+		})
+	}
+}
+
+func TestAggregationRule_FinalizedThrough(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 34, 56, 0, time.UTC)
+
+	_, ok := AggregationRule{AllowedLateness: time.Hour, LatePolicy: LatePolicyAccept}.FinalizedThrough(now)
+	require.False(t, ok, "accepted late events can still change closed buckets")
+
+	_, ok = AggregationRule{}.FinalizedThrough(now)
+	require.False(t, ok)
+
+	rule := AggregationRule{AllowedLateness: time.Hour, LatePolicy: LatePolicyCorrection, BucketSizes: []time.Duration{time.Hour, 24 * time.Hour}}
+	through, ok := rule.FinalizedThrough(now)
+	require.True(t, ok)
+	require.Equal(t, time.Date(2026, 3, 10, 11, 0, 0, 0, time.UTC), through)
+}
@@ -0,0 +1,246 @@
+package aggregation
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// DDSketch parameters. Every quantile estimate is within 1% of the true value
+// (relative error), independent of the distribution. Bins beyond
+// quantileMaxBins collapse into the lowest bin, which only affects the
+// extreme low quantiles of very wide distributions.
+const (
+	quantileRelativeAccuracy = 0.01
+	quantileMaxBins          = 2048
+	quantileMinIndexable     = 1e-9
+
+	quantileSketchFormat byte = 1
+)
+
+var (
+	quantileGamma    = (1 + quantileRelativeAccuracy) / (1 - quantileRelativeAccuracy)
+	quantileLogGamma = math.Log(quantileGamma)
+
+	errInvalidQuantileSketch = errors.New("invalid quantile sketch encoding")
+)
+
+// QuantileSketch is a DDSketch: values are counted in logarithmically sized bins,
+// so merging two sketches is exact (bin counts add) and quantiles keep the same
+// relative accuracy after minute buckets are rolled up into hours and days.
+type QuantileSketch struct {
+	positive  map[int32]uint64
+	negative  map[int32]uint64 // keyed by the index of |v|
+	zeroCount uint64
+	count     uint64
+}
+
+// NewQuantileSketch returns an empty sketch.
+func NewQuantileSketch() *QuantileSketch {
+	return &QuantileSketch{
+		positive: make(map[int32]uint64),
+		negative: make(map[int32]uint64),
+	}
+}
+
+// Add records one value.
+func (s *QuantileSketch) Add(v float64) {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return
+	}
+	s.count++
+	switch {
+	case v > quantileMinIndexable:
+		addBin(s.positive, quantileIndex(v), 1)
+	case v < -quantileMinIndexable:
+		addBin(s.negative, quantileIndex(-v), 1)
+	default:
+		s.zeroCount++
+	}
+}
+
+// Merge folds other into s. Bin counts add, so the result equals a sketch
+// built from both inputs' values.
+func (s *QuantileSketch) Merge(other *QuantileSketch) {
+	if other == nil {
+		return
+	}
+	for idx, c := range other.positive {
+		addBin(s.positive, idx, c)
+	}
+	for idx, c := range other.negative {
+		addBin(s.negative, idx, c)
+	}
+	s.zeroCount += other.zeroCount
+	s.count += other.count
+}
+
+// Clone returns an independent copy of the sketch.
+func (s *QuantileSketch) Clone() *QuantileSketch {
+	clone := &QuantileSketch{
+		positive:  make(map[int32]uint64, len(s.positive)),
+		negative:  make(map[int32]uint64, len(s.negative)),
+		zeroCount: s.zeroCount,
+		count:     s.count,
+	}
+	for idx, c := range s.positive {
+		clone.positive[idx] = c
+	}
+	for idx, c := range s.negative {
+		clone.negative[idx] = c
+	}
+	return clone
+}
+
+// Count returns the number of values added.
+func (s *QuantileSketch) Count() uint64 {
+	return s.count
+}
+
+// Quantile returns the estimated value at quantile q (0 ≤ q ≤ 1).
+// Returns 0 for an empty sketch.
+func (s *QuantileSketch) Quantile(q float64) float64 {
+	if s.count == 0 {
+		return 0
+	}
+	q = math.Min(math.Max(q, 0), 1)
+	rank := q * float64(s.count-1)
+
+	var cumulative float64
+	// Most negative values first: highest |v| index down to the lowest.
+	negative := sortedBins(s.negative)
+	for i := len(negative) - 1; i >= 0; i-- {
+		cumulative += float64(s.negative[negative[i]])
+		if cumulative > rank {
+			return -quantileValue(negative[i])
+		}
+	}
+	cumulative += float64(s.zeroCount)
+	if cumulative > rank {
+		return 0
+	}
+	positive := sortedBins(s.positive)
+	for _, idx := range positive {
+		cumulative += float64(s.positive[idx])
+		if cumulative > rank {
+			return quantileValue(idx)
+		}
+	}
+	if len(positive) == 0 {
+		return 0
+	}
+	return quantileValue(positive[len(positive)-1])
+}
+
+// MarshalBinary encodes the sketch as varint (index, count) pairs.
+func (s *QuantileSketch) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, 0, 1+binary.MaxVarintLen64*(2+2*(len(s.positive)+len(s.negative))))
+	buf = append(buf, quantileSketchFormat)
+	buf = binary.AppendUvarint(buf, s.zeroCount)
+	buf = appendBins(buf, s.positive)
+	buf = appendBins(buf, s.negative)
+	return buf, nil
+}
+
+// UnmarshalBinary decodes a sketch produced by MarshalBinary.
+func (s *QuantileSketch) UnmarshalBinary(data []byte) error {
+	if len(data) < 1 || data[0] != quantileSketchFormat {
+		return errInvalidQuantileSketch
+	}
+	body := data[1:]
+
+	zeroCount, n := binary.Uvarint(body)
+	if n <= 0 {
+		return errInvalidQuantileSketch
+	}
+	body = body[n:]
+
+	decoded := NewQuantileSketch()
+	decoded.zeroCount = zeroCount
+	decoded.count = zeroCount
+
+	var err error
+	if body, err = readBins(body, decoded.positive, &decoded.count); err != nil {
+		return err
+	}
+	if body, err = readBins(body, decoded.negative, &decoded.count); err != nil {
+		return err
+	}
+	if len(body) != 0 {
+		return fmt.Errorf("%w: %d trailing bytes", errInvalidQuantileSketch, len(body))
+	}
+
+	*s = *decoded
+	return nil
+}
+
+func quantileIndex(v float64) int32 {
+	return int32(math.Ceil(math.Log(v) / quantileLogGamma))
+}
+
+// quantileValue returns the representative value of a bin: the point whose
+// relative distance to both bin bounds is equal.
+func quantileValue(idx int32) float64 {
+	return 2 * math.Pow(quantileGamma, float64(idx)) / (quantileGamma + 1)
+}
+
+func addBin(bins map[int32]uint64, idx int32, c uint64) {
+	bins[idx] += c
+	if len(bins) > quantileMaxBins {
+		collapseLowest(bins)
+	}
+}
+
+// collapseLowest folds the lowest bins into one so the store stays within quantileMaxBins.
+func collapseLowest(bins map[int32]uint64) {
+	keys := sortedBins(bins)
+	excess := len(keys) - quantileMaxBins
+	target := keys[excess]
+	for _, idx := range keys[:excess] {
+		bins[target] += bins[idx]
+		delete(bins, idx)
+	}
+}
+
+func sortedBins(bins map[int32]uint64) []int32 {
+	keys := make([]int32, 0, len(bins))
+	for idx := range bins {
+		keys = append(keys, idx)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
+
+func appendBins(buf []byte, bins map[int32]uint64) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(bins)))
+	for _, idx := range sortedBins(bins) {
+		buf = binary.AppendVarint(buf, int64(idx))
+		buf = binary.AppendUvarint(buf, bins[idx])
+	}
+	return buf
+}
+
+func readBins(body []byte, bins map[int32]uint64, count *uint64) ([]byte, error) {
+	n, read := binary.Uvarint(body)
+	if read <= 0 || n > quantileMaxBins {
+		return nil, errInvalidQuantileSketch
+	}
+	body = body[read:]
+	for i := uint64(0); i < n; i++ {
+		idx, read := binary.Varint(body)
+		if read <= 0 || idx < math.MinInt32 || idx > math.MaxInt32 {
+			return nil, errInvalidQuantileSketch
+		}
+		body = body[read:]
+		c, read := binary.Uvarint(body)
+		if read <= 0 {
+			return nil, errInvalidQuantileSketch
+		}
+		body = body[read:]
+		bins[int32(idx)] = c
+		*count += c
+	}
+	return body, nil
+}
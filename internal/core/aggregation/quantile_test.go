@@ -0,0 +1,98 @@
+package aggregation
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuantileSketch_RelativeAccuracy(t *testing.T) {
+	rng := rand.New(rand.NewSource(42))
+	values := make([]float64, 20000)
+	s := NewQuantileSketch()
+	for i := range values {
+		values[i] = math.Exp(rng.NormFloat64()*2 + 3) // long-tailed, latency-like
+		s.Add(values[i])
+	}
+	sort.Float64s(values)
+
+	for _, q := range []float64{0, 0.5, 0.9, 0.95, 0.99, 1} {
+		want := values[int(q*float64(len(values)-1))]
+		require.InEpsilon(t, want, s.Quantile(q), 2*quantileRelativeAccuracy, "q=%v", q)
+	}
+	require.Equal(t, uint64(len(values)), s.Count())
+}
+
+func TestQuantileSketch_MergeEqualsCombined(t *testing.T) {
+	a, b, combined := NewQuantileSketch(), NewQuantileSketch(), NewQuantileSketch()
+	for i := 1; i <= 1000; i++ {
+		v := float64(i)
+		if i%3 == 0 {
+			v = -v
+		}
+		if i%2 == 0 {
+			a.Add(v)
+		} else {
+			b.Add(v)
+		}
+		combined.Add(v)
+	}
+	a.Add(0)
+	combined.Add(0)
+
+	merged := a.Clone()
+	merged.Merge(b)
+	for _, q := range []float64{0, 0.1, 0.5, 0.99, 1} {
+		require.Equal(t, combined.Quantile(q), merged.Quantile(q))
+	}
+	require.NotEqual(t, a.Count(), merged.Count(), "clone must not alias the original")
+}
+
+func TestQuantileSketch_BinaryRoundTrip(t *testing.T) {
+	s := NewQuantileSketch()
+	for _, v := range []float64{-5, 0, 0.25, 3, 3, 1e6} {
+		s.Add(v)
+	}
+	data, err := s.MarshalBinary()
+	require.NoError(t, err)
+
+	var decoded QuantileSketch
+	require.NoError(t, decoded.UnmarshalBinary(data))
+	require.Equal(t, s.Count(), decoded.Count())
+	for _, q := range []float64{0, 0.3, 0.5, 1} {
+		require.Equal(t, s.Quantile(q), decoded.Quantile(q))
+	}
+
+	require.Error(t, decoded.UnmarshalBinary(nil))
+	require.Error(t, decoded.UnmarshalBinary(append(data, 0)))
+}
+
+func TestQuantileSketch_CollapsesLowestBins(t *testing.T) {
+	s := NewQuantileSketch()
+	for i := 0; i < quantileMaxBins*2; i++ {
+		s.Add(math.Pow(quantileGamma, float64(i)))
+	}
+	require.LessOrEqual(t, len(s.positive), quantileMaxBins)
+	require.Equal(t, uint64(quantileMaxBins*2), s.Count())
+	// The top of the distribution is unaffected by collapsing.
+	require.InEpsilon(t, math.Pow(quantileGamma, float64(quantileMaxBins*2-1)), s.Quantile(1), quantileRelativeAccuracy)
+}
+
+func TestQuantileAgg_ValueIsMedian(t *testing.T) {
+	agg := Operators[OpQuantile]
+	state := agg.Initial(Observation{})
+	state.Operator = OpQuantile
+	for _, v := range []int64{1, 2, 100} {
+		state = agg.Apply(state, Observation{Value: decimal.NewFromInt(v)})
+	}
+	// Initial and Apply only add to the digest; Finalize derives the estimate.
+	require.True(t, state.Value.IsZero())
+	state = Finalize(state)
+	// Observed 0, 1, 2, 100: the median rank falls in the bin of 1.
+	require.InEpsilon(t, 1, state.Value.InexactFloat64(), quantileRelativeAccuracy)
+	require.True(t, QuantileValue(nil, 0.5).IsZero())
+}
@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"gopkg.in/yaml.v3"
@@ -14,24 +16,63 @@ import (
 
 // AggregationRule defines a single aggregation rule.
 // Rules are loaded at startup from YAML files and fingerprinted for staleness detection.
-// No CEL or dynamic evaluation in MVP — rules match on SourceEvent only.
+// Rules match on SourceEvent and, optionally, a filter expression over Data and Metadata.
 type AggregationRule struct {
-	Name        string        `yaml:"name"`
-	SourceEvent string        `yaml:"source_event"`
-	WindowSize  time.Duration // Fixed to 1m in MVP
-	Operator    string        `yaml:"operator"` // count, sum, min, max
-	Field       string        `yaml:"field"`    // event data field to aggregate; empty for count
-	Fingerprint string        // SHA-256 of the raw YAML file; computed at load time
+	Name        string          `yaml:"name"`
+	SourceEvent string          `yaml:"source_event"`
+	WindowSize  time.Duration   // finest bucket size of the rule
+	BucketSizes []time.Duration // bucket sizes pre-aggregated for the rule, ascending; empty means WindowSize only
+	Operator    string          `yaml:"operator"` // count, sum, min, max, avg, first, last, count_distinct, quantile
+	Field       string          `yaml:"field"`    // event data field to aggregate; empty for count, the distinct key for count_distinct
+	Filter      *Filter         // optional predicate compiled from the filter expression; nil matches every event
+	GroupBy     []string        // optional event paths (data.<field>, metadata.<key>) that split aggregates into dimensions
+	Fingerprint string          // SHA-256 of the raw YAML file (including filter); computed at load time
+
+	AllowedLateness time.Duration // watermark: how long after occurred_at an event may arrive; 0 means no watermark
+	LatePolicy      string        // accept, reject or correction; applies to events past AllowedLateness
+}
+
+// Buckets returns the bucket sizes the rule is pre-aggregated at, ascending.
+// Rules built without BucketSizes use WindowSize, defaulting to 1m.
+func (r AggregationRule) Buckets() []time.Duration {
+	if len(r.BucketSizes) > 0 {
+		return r.BucketSizes
+	}
+	if r.WindowSize > 0 {
+		return []time.Duration{r.WindowSize}
+	}
+	return []time.Duration{time.Minute}
+}
+
+// HasBucket reports whether the rule is pre-aggregated at bucket size d.
+func (r AggregationRule) HasBucket(d time.Duration) bool {
+	for _, b := range r.Buckets() {
+		if b == d {
+			return true
+		}
+	}
+	return false
+}
+
+// Matches reports whether an event of the rule's SourceEvent passes the rule filter.
+func (r AggregationRule) Matches(data map[string]interface{}, metadata map[string]string) bool {
+	return r.Filter == nil || r.Filter.Match(data, metadata)
 }
 
 // rawRule is the on-disk YAML shape.
-// window_size is optional and locked to "1m" in MVP.
+// bucket_sizes is optional and defaults to ["1m"]; window_size is shorthand for a single bucket size.
 type rawRule struct {
-	Name        string `yaml:"name"`
-	SourceEvent string `yaml:"source_event"`
-	WindowSize  string `yaml:"window_size"` // optional; must be "1m" if provided
-	Operator    string `yaml:"operator"`
-	Field       string `yaml:"field"`
+	Name        string   `yaml:"name"`
+	SourceEvent string   `yaml:"source_event"`
+	WindowSize  string   `yaml:"window_size"`  // optional, e.g. "1h"; mutually exclusive with bucket_sizes
+	BucketSizes []string `yaml:"bucket_sizes"` // optional, e.g. ["1m", "1h", "1d"]
+	Operator    string   `yaml:"operator"`
+	Field       string   `yaml:"field"`
+	Filter      string   `yaml:"filter"`   // optional, e.g. data.status >= 500 && metadata.region == "eu"
+	GroupBy     []string `yaml:"group_by"` // optional, e.g. [data.model, metadata.region]
+
+	AllowedLateness string `yaml:"allowed_lateness"` // optional, e.g. "72h" or "7d"
+	LatePolicy      string `yaml:"late_policy"`      // optional: accept (default), reject or correction
 }
 
 // RuleRepository defines the interface for loading aggregation rules.
@@ -47,41 +88,67 @@ type RuleRepository interface {
 }
 
 // FileSystemRuleRepository loads aggregation rules from *.yaml files in a directory.
-// Each file contains exactly one rule at the top level. Rules are loaded once at
-// startup and cached in memory — no hot reload in MVP.
+// Each file contains exactly one rule at the top level. Rules are cached in memory;
+// Reload re-reads the directory and swaps the whole set only if every file is valid.
 type FileSystemRuleRepository struct {
 	dir   string
+	mu    sync.RWMutex
 	rules map[string]AggregationRule // keyed by Name
 }
 
 // NewFileSystemRuleRepository creates a new repository and eagerly loads all rules
 // from dir. Returns an error if any rule file is malformed or invalid.
 func NewFileSystemRuleRepository(dir string) (*FileSystemRuleRepository, error) {
-	repo := &FileSystemRuleRepository{
-		dir:   dir,
-		rules: make(map[string]AggregationRule),
+	rules, err := loadRules(dir)
+	if err != nil {
+		return nil, err
 	}
-	if err := repo.load(); err != nil {
+	return &FileSystemRuleRepository{dir: dir, rules: rules}, nil
+}
+
+// Dir returns the directory the rules are loaded from.
+func (r *FileSystemRuleRepository) Dir() string {
+	return r.dir
+}
+
+// Reload re-reads every rule file and atomically replaces the cached rule set.
+// validate, if non-nil, vets the complete new set before the swap. If any file is
+// malformed or the set is rejected, the current set is kept and the error returned.
+func (r *FileSystemRuleRepository) Reload(validate func([]AggregationRule) error) ([]AggregationRule, error) {
+	rules, err := loadRules(r.dir)
+	if err != nil {
 		return nil, err
 	}
-	return repo, nil
+	if validate != nil {
+		if err := validate(rulesSlice(rules)); err != nil {
+			return nil, err
+		}
+	}
+
+	r.mu.Lock()
+	r.rules = rules
+	r.mu.Unlock()
+	return r.GetRules(), nil
 }
 
-func (r *FileSystemRuleRepository) load() error {
-	info, err := os.Stat(r.dir)
+// loadRules parses and validates every rule file in dir.
+func loadRules(dir string) (map[string]AggregationRule, error) {
+	rules := make(map[string]AggregationRule)
+
+	info, err := os.Stat(dir)
 	if os.IsNotExist(err) {
-		return nil // no rules directory — valid (zero rules configured)
+		return rules, nil // no rules directory — valid (zero rules configured)
 	}
 	if err != nil {
-		return fmt.Errorf("aggregation rule dir: %w", err)
+		return nil, fmt.Errorf("aggregation rule dir: %w", err)
 	}
 	if !info.IsDir() {
-		return fmt.Errorf("aggregation rule path %q is not a directory", r.dir)
+		return nil, fmt.Errorf("aggregation rule path %q is not a directory", dir)
 	}
 
-	entries, err := os.ReadDir(r.dir)
+	entries, err := os.ReadDir(dir)
 	if err != nil {
-		return fmt.Errorf("reading aggregation rule dir: %w", err)
+		return nil, fmt.Errorf("reading aggregation rule dir: %w", err)
 	}
 
 	for _, e := range entries {
@@ -89,52 +156,89 @@ func (r *FileSystemRuleRepository) load() error {
 			continue
 		}
 
-		path := filepath.Join(r.dir, e.Name())
+		path := filepath.Join(dir, e.Name())
 		data, err := os.ReadFile(path)
 		if err != nil {
-			return fmt.Errorf("reading rule file %s: %w", path, err)
+			return nil, fmt.Errorf("reading rule file %s: %w", path, err)
 		}
 
 		var raw rawRule
 		if err := yaml.Unmarshal(data, &raw); err != nil {
-			return fmt.Errorf("parsing rule file %s: %w", path, err)
+			return nil, fmt.Errorf("parsing rule file %s: %w", path, err)
 		}
 		if raw.Name == "" {
 			continue // skip empty / comment-only files
 		}
 
 		if raw.SourceEvent == "" {
-			return fmt.Errorf("rule %q: source_event must not be empty", raw.Name)
+			return nil, fmt.Errorf("rule %q: source_event must not be empty", raw.Name)
 		}
 
 		if !ValidOperator(raw.Operator) {
-			return fmt.Errorf("rule %q: unsupported operator %q", raw.Name, raw.Operator)
+			return nil, fmt.Errorf("rule %q: unsupported operator %q", raw.Name, raw.Operator)
+		}
+
+		if (raw.Operator == OpCountDistinct || raw.Operator == OpQuantile) && raw.Field == "" {
+			return nil, fmt.Errorf("rule %q: %s requires field", raw.Name, raw.Operator)
+		}
+
+		bucketSizes, err := parseBucketSizes(raw.WindowSize, raw.BucketSizes)
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %w", raw.Name, err)
 		}
 
-		if raw.WindowSize != "" && raw.WindowSize != "1m" {
-			return fmt.Errorf("rule %q: window_size customization is disabled in MVP (use 1m)", raw.Name)
+		var filter *Filter
+		if strings.TrimSpace(raw.Filter) != "" {
+			filter, err = CompileFilter(raw.Filter)
+			if err != nil {
+				return nil, fmt.Errorf("rule %q: %w", raw.Name, err)
+			}
 		}
 
+		if err := validateGroupBy(raw.GroupBy); err != nil {
+			return nil, fmt.Errorf("rule %q: %w", raw.Name, err)
+		}
+		var groupBy []string
+		for _, path := range raw.GroupBy {
+			groupBy = append(groupBy, strings.TrimSpace(path))
+		}
+
+		allowedLateness, latePolicy, err := parseLatePolicy(raw.AllowedLateness, raw.LatePolicy)
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %w", raw.Name, err)
+		}
+
+		// The fingerprint hashes the whole file, so editing the filter marks the
+		// rule's durable aggregates as stale just like any other definition change.
 		fingerprint := fmt.Sprintf("%x", sha256.Sum256(data))
 
-		if _, exists := r.rules[raw.Name]; exists {
-			return fmt.Errorf("rule %q: duplicate rule name (check multiple YAML files)", raw.Name)
+		if _, exists := rules[raw.Name]; exists {
+			return nil, fmt.Errorf("rule %q: duplicate rule name (check multiple YAML files)", raw.Name)
 		}
 
-		r.rules[raw.Name] = AggregationRule{
+		rules[raw.Name] = AggregationRule{
 			Name:        raw.Name,
 			SourceEvent: raw.SourceEvent,
-			WindowSize:  time.Minute,
+			WindowSize:  bucketSizes[0],
+			BucketSizes: bucketSizes,
 			Operator:    raw.Operator,
 			Field:       raw.Field,
+			Filter:      filter,
+			GroupBy:     groupBy,
 			Fingerprint: fingerprint,
+
+			AllowedLateness: allowedLateness,
+			LatePolicy:      latePolicy,
 		}
 	}
-	return nil
+	return rules, nil
 }
 
 // Get returns the rule with the given name, or an error if not found.
 func (r *FileSystemRuleRepository) Get(_ context.Context, name string) (*AggregationRule, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
 	rule, ok := r.rules[name]
 	if !ok {
 		return nil, fmt.Errorf("aggregation rule %q not found", name)
@@ -144,6 +248,9 @@ func (r *FileSystemRuleRepository) Get(_ context.Context, name string) (*Aggrega
 
 // List returns all loaded rules, optionally filtered by source event type.
 func (r *FileSystemRuleRepository) List(_ context.Context, sourceEvent string) ([]AggregationRule, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
 	var out []AggregationRule
 	for _, rule := range r.rules {
 		if sourceEvent != "" && rule.SourceEvent != sourceEvent {
@@ -156,9 +263,50 @@ func (r *FileSystemRuleRepository) List(_ context.Context, sourceEvent string) (
 
 // GetRules returns all rules as a slice (for batch processing).
 func (r *FileSystemRuleRepository) GetRules() []AggregationRule {
-	rules := make([]AggregationRule, 0, len(r.rules))
-	for _, rule := range r.rules {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return rulesSlice(r.rules)
+}
+
+func rulesSlice(byName map[string]AggregationRule) []AggregationRule {
+	rules := make([]AggregationRule, 0, len(byName))
+	for _, rule := range byName {
 		rules = append(rules, rule)
 	}
 	return rules
 }
+
+// parseBucketSizes resolves the window_size/bucket_sizes fields of a rule into
+// validated, de-duplicated bucket sizes in ascending order.
+func parseBucketSizes(windowSize string, bucketSizes []string) ([]time.Duration, error) {
+	if windowSize != "" && len(bucketSizes) > 0 {
+		return nil, fmt.Errorf("window_size and bucket_sizes are mutually exclusive")
+	}
+	labels := bucketSizes
+	if windowSize != "" {
+		labels = []string{windowSize}
+	}
+	if len(labels) == 0 {
+		return []time.Duration{time.Minute}, nil
+	}
+
+	sizes := make([]time.Duration, 0, len(labels))
+	seen := make(map[time.Duration]struct{}, len(labels))
+	for _, label := range labels {
+		spec, err := ParseWindowSize(strings.TrimSpace(label))
+		if err != nil {
+			return nil, err
+		}
+		if err := ValidateBucketSize(spec.Size); err != nil {
+			return nil, err
+		}
+		if _, dup := seen[spec.Size]; dup {
+			return nil, fmt.Errorf("duplicate bucket size %q", label)
+		}
+		seen[spec.Size] = struct{}{}
+		sizes = append(sizes, spec.Size)
+	}
+	sort.Slice(sizes, func(i, j int) bool { return sizes[i] < sizes[j] })
+	return sizes, nil
+}
@@ -145,18 +145,238 @@ operator: "average"
 	}
 }
 
-func TestFileSystemRuleRepository_WindowSizeCustomizationDisabled(t *testing.T) {
+func TestFileSystemRuleRepository_CountDistinctRequiresField(t *testing.T) {
 	dir := t.TempDir()
-	writeRule(t, dir, "bad_window.yaml", `
-name: "bad_window"
-source_event: "x"
-window_size: "5m"
+	writeRule(t, dir, "distinct.yaml", `
+name: "distinct_devices"
+source_event: "device.seen"
+operator: "count_distinct"
+`)
+
+	_, err := NewFileSystemRuleRepository(dir)
+	if err == nil {
+		t.Fatal("expected error for count_distinct without field, got nil")
+	}
+}
+
+func TestFileSystemRuleRepository_CompilesFilter(t *testing.T) {
+	dir := t.TempDir()
+	writeRule(t, dir, "errors.yaml", `
+name: "count_errors"
+source_event: "api.request"
 operator: "count"
+filter: 'data.status >= 500'
+`)
+
+	repo, err := NewFileSystemRuleRepository(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rule, _ := repo.Get(context.Background(), "count_errors")
+	if rule.Filter == nil {
+		t.Fatal("expected compiled filter")
+	}
+	if rule.Matches(map[string]interface{}{"status": 200.0}, nil) {
+		t.Error("filter should reject status 200")
+	}
+	if !rule.Matches(map[string]interface{}{"status": 502.0}, nil) {
+		t.Error("filter should accept status 502")
+	}
+
+	// Changing only the filter must change the fingerprint.
+	writeRule(t, dir, "errors.yaml", `
+name: "count_errors"
+source_event: "api.request"
+operator: "count"
+filter: 'data.status >= 400'
+`)
+	repo2, err := NewFileSystemRuleRepository(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rule2, _ := repo2.Get(context.Background(), "count_errors")
+	if rule.Fingerprint == rule2.Fingerprint {
+		t.Error("Fingerprint did not change after filter modification")
+	}
+}
+
+func TestFileSystemRuleRepository_InvalidFilter(t *testing.T) {
+	dir := t.TempDir()
+	writeRule(t, dir, "bad_filter.yaml", `
+name: "bad_filter"
+source_event: "api.request"
+operator: "count"
+filter: 'data.status >='
 `)
 
 	_, err := NewFileSystemRuleRepository(dir)
 	if err == nil {
-		t.Fatal("expected error for window_size customization, got nil")
+		t.Fatal("expected error for invalid filter, got nil")
+	}
+}
+
+func TestFileSystemRuleRepository_GroupBy(t *testing.T) {
+	dir := t.TempDir()
+	writeRule(t, dir, "tokens.yaml", `
+name: "tokens_by_model"
+source_event: "llm.completion"
+operator: "sum"
+field: "tokens"
+group_by: ["data.model", " metadata.region "]
+`)
+
+	repo, err := NewFileSystemRuleRepository(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rule, _ := repo.Get(context.Background(), "tokens_by_model")
+	if len(rule.GroupBy) != 2 || rule.GroupBy[0] != "data.model" || rule.GroupBy[1] != "metadata.region" {
+		t.Errorf("GroupBy = %v, want [data.model metadata.region]", rule.GroupBy)
+	}
+}
+
+func TestFileSystemRuleRepository_InvalidGroupBy(t *testing.T) {
+	cases := map[string]string{
+		"bad_root":  `["tokens"]`,
+		"duplicate": `["data.model", "data.model"]`,
+	}
+	for name, groupBy := range cases {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeRule(t, dir, "bad.yaml", `
+name: "bad_group_by"
+source_event: "llm.completion"
+operator: "count"
+group_by: `+groupBy+`
+`)
+			if _, err := NewFileSystemRuleRepository(dir); err == nil {
+				t.Fatal("expected error for invalid group_by, got nil")
+			}
+		})
+	}
+}
+
+func TestFileSystemRuleRepository_BucketSizes(t *testing.T) {
+	dir := t.TempDir()
+	writeRule(t, dir, "hourly.yaml", `
+name: "hourly"
+source_event: "x"
+window_size: "1h"
+operator: "count"
+`)
+	writeRule(t, dir, "multi.yaml", `
+name: "multi"
+source_event: "x"
+bucket_sizes: ["1d", "1m", "1h"]
+operator: "count"
+`)
+
+	repo, err := NewFileSystemRuleRepository(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hourly, _ := repo.Get(context.Background(), "hourly")
+	if hourly.WindowSize != time.Hour {
+		t.Errorf("hourly WindowSize = %v, want 1h", hourly.WindowSize)
+	}
+	if got := hourly.Buckets(); len(got) != 1 || got[0] != time.Hour {
+		t.Errorf("hourly Buckets = %v, want [1h]", got)
+	}
+
+	multi, _ := repo.Get(context.Background(), "multi")
+	want := []time.Duration{time.Minute, time.Hour, 24 * time.Hour}
+	got := multi.Buckets()
+	if len(got) != len(want) {
+		t.Fatalf("multi Buckets = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("multi Buckets[%d] = %v, want %v", i, got[i], want[i])
+		}
+	}
+	if multi.WindowSize != time.Minute {
+		t.Errorf("multi WindowSize = %v, want 1m", multi.WindowSize)
+	}
+	if !multi.HasBucket(time.Hour) || multi.HasBucket(5*time.Minute) {
+		t.Errorf("multi HasBucket mismatch for %v", got)
+	}
+}
+
+func TestFileSystemRuleRepository_InvalidBucketSizes(t *testing.T) {
+	cases := map[string]string{
+		"not_dividing_day": `window_size: "7m"`,
+		"sub_minute":       `window_size: "30s"`,
+		"multi_day":        `bucket_sizes: ["1m", "2d"]`,
+		"duplicate":        `bucket_sizes: ["1h", "60m"]`,
+		"both_fields":      "window_size: \"1m\"\nbucket_sizes: [\"1h\"]",
+		"garbage":          `bucket_sizes: ["soon"]`,
+	}
+	for name, field := range cases {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeRule(t, dir, "bad.yaml", `
+name: "bad_window"
+source_event: "x"
+operator: "count"
+`+field+`
+`)
+			if _, err := NewFileSystemRuleRepository(dir); err == nil {
+				t.Fatal("expected error for invalid bucket sizes, got nil")
+			}
+		})
+	}
+}
+
+func TestFileSystemRuleRepository_LatePolicy(t *testing.T) {
+	dir := t.TempDir()
+	writeRule(t, dir, "billing.yaml", `
+name: "billable_requests"
+source_event: "api.request"
+operator: "count"
+allowed_lateness: "3d"
+late_policy: "correction"
+`)
+	writeRule(t, dir, "watermark_only.yaml", `
+name: "watermark_only"
+source_event: "api.request"
+operator: "count"
+allowed_lateness: "1h"
+`)
+
+	repo, err := NewFileSystemRuleRepository(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rule, _ := repo.Get(context.Background(), "billable_requests")
+	if rule.AllowedLateness != 72*time.Hour || rule.LatePolicy != LatePolicyCorrection {
+		t.Errorf("late policy = %s/%q, want 72h/correction", rule.AllowedLateness, rule.LatePolicy)
+	}
+	rule, _ = repo.Get(context.Background(), "watermark_only")
+	if rule.LatePolicy != LatePolicyAccept {
+		t.Errorf("LatePolicy = %q, want accept by default", rule.LatePolicy)
+	}
+}
+
+func TestFileSystemRuleRepository_InvalidLatePolicy(t *testing.T) {
+	cases := map[string]string{
+		"policy_without_watermark": `late_policy: "reject"`,
+		"unknown_policy":           "allowed_lateness: \"1h\"\nlate_policy: \"drop\"",
+		"bad_lateness":             `allowed_lateness: "soon"`,
+	}
+	for name, field := range cases {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeRule(t, dir, "bad.yaml", `
+name: "bad_late_policy"
+source_event: "x"
+operator: "count"
+`+field+`
+`)
+			if _, err := NewFileSystemRuleRepository(dir); err == nil {
+				t.Fatal("expected error for invalid late policy, got nil")
+			}
+		})
 	}
 }
 
@@ -249,3 +469,77 @@ field: "amount"
 		t.Fatalf("expected 2 rules, got %d", len(rules))
 	}
 }
+
+func TestFileSystemRuleRepository_ReloadSwapsRuleSet(t *testing.T) {
+	dir := t.TempDir()
+	writeRule(t, dir, "a.yaml", `
+name: "rule_a"
+source_event: "x"
+operator: "count"
+`)
+	repo, err := NewFileSystemRuleRepository(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeRule(t, dir, "b.yaml", `
+name: "rule_b"
+source_event: "x"
+operator: "sum"
+field: "n"
+`)
+	rules, err := repo.Reload(nil)
+	if err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if len(rules) != 2 || len(repo.GetRules()) != 2 {
+		t.Fatalf("Reload: got %d rules (repo %d), want 2", len(rules), len(repo.GetRules()))
+	}
+	if _, err := repo.Get(context.Background(), "rule_b"); err != nil {
+		t.Errorf("Get rule_b after reload: %v", err)
+	}
+}
+
+func TestFileSystemRuleRepository_ReloadKeepsRuleSetOnError(t *testing.T) {
+	dir := t.TempDir()
+	writeRule(t, dir, "a.yaml", `
+name: "rule_a"
+source_event: "x"
+operator: "count"
+`)
+	repo, err := NewFileSystemRuleRepository(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeRule(t, dir, "bad.yaml", `
+name: "rule_bad"
+source_event: "x"
+operator: "median"
+`)
+	if _, err := repo.Reload(nil); err == nil {
+		t.Fatal("expected error for invalid operator, got nil")
+	}
+	if rules := repo.GetRules(); len(rules) != 1 || rules[0].Name != "rule_a" {
+		t.Errorf("rules after rejected reload = %v, want [rule_a]", rules)
+	}
+
+	if err := os.Remove(filepath.Join(dir, "bad.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "a.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	rejectEmpty := func(rules []AggregationRule) error {
+		if len(rules) == 0 {
+			return os.ErrNotExist
+		}
+		return nil
+	}
+	if _, err := repo.Reload(rejectEmpty); err == nil {
+		t.Fatal("expected validator error for empty rule set, got nil")
+	}
+	if len(repo.GetRules()) != 1 {
+		t.Errorf("rules after rejected reload = %d, want 1", len(repo.GetRules()))
+	}
+}
@@ -1,20 +1,34 @@
 package aggregation
 
 import (
+	"errors"
 	"time"
 
+	"github.com/aevon-lab/project-aevon/internal/core/partition"
 	"github.com/shopspring/decimal"
 )
 
-// Supported aggregation operators for MVP.
-// avg and last are deferred — they require composite state (sum+count, value+timestamp).
+// Supported aggregation operators.
+// avg, first and last carry composite state: avg keeps sum+count (Sum + EventCount),
+// first/last keep value+timestamp (Value + ValueAt), count_distinct keeps a
+// HyperLogLog sketch (Sketch) and exposes its estimate as Value, quantile keeps a
+// DDSketch (Digest) and exposes the median as Value.
 const (
 	OpCount = "count"
 	OpSum   = "sum"
 	OpMin   = "min"
 	OpMax   = "max"
+	OpAvg   = "avg"
+	OpFirst = "first"
+	OpLast  = "last"
+
+	OpCountDistinct = "count_distinct"
+	OpQuantile      = "quantile"
 )
 
+// DefaultQuantile is the quantile reported as Value for the quantile operator.
+const DefaultQuantile = 0.5
+
 // AggregateKey uniquely identifies a pre-aggregate bucket.
 // Partition-scoped from day one: PartitionID is always present,
 // even when running as a single instance.
@@ -24,15 +38,167 @@ type AggregateKey struct {
 	RuleName    string
 	BucketSize  string    // e.g. "1m", "10m", "1h"
 	WindowStart time.Time // truncated to bucket boundary
+	Dimensions  string    // encoded group_by values (see DimensionsFor); "" for rules without group_by
 }
 
 // AggregateState holds the current materialized value of a pre-aggregate.
 type AggregateState struct {
-	Operator        string          // count, sum, min, max
-	Value           decimal.Decimal // the aggregate value (exact arithmetic)
+	Operator        string          // count, sum, min, max, avg, first, last, count_distinct, quantile
+	Value           decimal.Decimal // the aggregate value (exact arithmetic); the mean for avg, the estimate for count_distinct, the median for quantile
+	Sum             decimal.Decimal // avg only: running sum; Value = Sum / EventCount
+	ValueAt         time.Time       // first/last only: occurred_at of the event that produced Value
+	Sketch          *Sketch         // count_distinct only: HLL sketch of the distinct keys
+	Digest          *QuantileSketch // quantile only: DDSketch of the observed values
 	EventCount      int64           // monotonically increasing; idempotency marker for upsert
 	LastEventID     string          // most recent event ID that updated this aggregate
 	RuleFingerprint string          // SHA-256 of the rule definition; staleness detection at query time
 	WindowStart     time.Time       // bucket timestamp (truncated to 1-min boundary)
+	Dimensions      string          // encoded group_by values of the bucket; "" for rules without group_by
 	UpdatedAt       time.Time       // last update timestamp
 }
+
+// Observation is one event's contribution to an aggregate.
+type Observation struct {
+	Value      decimal.Decimal // extracted from the rule's field; ignored by count
+	OccurredAt time.Time       // event business time; orders first/last
+	Key        string          // distinct key from the rule's field; count_distinct only, empty if absent
+}
+
+// ErrRuleFingerprintMismatch is returned when aggregates computed with one rule definition
+// are flushed after another definition of the rule became active.
+var ErrRuleFingerprintMismatch = errors.New("rule fingerprint does not match the active rule version")
+
+// ErrCheckpointMoved is returned when pre-aggregates are replaced after a flush advanced
+// the checkpoint they were verified or recomputed at.
+var ErrCheckpointMoved = errors.New("checkpoint moved since the pre-aggregates were computed")
+
+// ErrRuleRebuilding is returned when pre-aggregates of a rule are replaced while the rule
+// rebuilder replays them after a rule change.
+var ErrRuleRebuilding = errors.New("rule pre-aggregates are being rebuilt")
+
+// RuleVersion is the active definition of a rule at one bucket size. When a rule's
+// fingerprint changes, its pre-aggregates are dropped and rebuilt from the event log:
+// events up to RebuildTarget (the bucket checkpoint at the time of the change) are
+// replayed by the rebuild, later events by the regular scheduler.
+type RuleVersion struct {
+	RuleName      string
+	BucketSize    string
+	Fingerprint   string
+	RebuildCursor int64 // last ingest_seq replayed by the rebuild
+	RebuildTarget int64 // rebuild is complete once RebuildCursor reaches it; 0 if nothing to rebuild
+	// PartitionTargets holds the checkpoint of every partition when the rebuild started,
+	// indexed by partition ID. Only set if partition checkpoints differed; the rebuild
+	// then replays events of a partition only up to its own checkpoint.
+	PartitionTargets []int64
+	UpdatedAt        time.Time
+}
+
+// Rebuilding reports whether the version's pre-aggregates are still incomplete.
+func (v RuleVersion) Rebuilding() bool {
+	return v.RebuildCursor < v.RebuildTarget
+}
+
+// TargetFor returns the last ingest_seq the rebuild replays for partitionID.
+func (v RuleVersion) TargetFor(partitionID int) int64 {
+	if partitionID >= 0 && partitionID < len(v.PartitionTargets) {
+		return v.PartitionTargets[partitionID]
+	}
+	return v.RebuildTarget
+}
+
+// PartitionCheckpoints holds the checkpoint cursor of every partition in a range at one
+// bucket size. Partitions of one range normally share a cursor; they differ after the
+// partition ranges were re-split, until the range catches up.
+type PartitionCheckpoints struct {
+	Range   partition.Range
+	Cursors []int64 // Cursors[i] is the checkpoint of partition Range.From+i
+}
+
+// Cursor returns the checkpoint of partitionID, which must lie in c.Range.
+func (c PartitionCheckpoints) Cursor(partitionID int) int64 {
+	return c.Cursors[partitionID-c.Range.From]
+}
+
+// Min returns the lowest checkpoint in the range: every event up to it is aggregated
+// for all partitions of the range.
+func (c PartitionCheckpoints) Min() int64 {
+	var lowest int64
+	for i, cursor := range c.Cursors {
+		if i == 0 || cursor < lowest {
+			lowest = cursor
+		}
+	}
+	return lowest
+}
+
+// Max returns the highest checkpoint in the range.
+func (c PartitionCheckpoints) Max() int64 {
+	var highest int64
+	for _, cursor := range c.Cursors {
+		if cursor > highest {
+			highest = cursor
+		}
+	}
+	return highest
+}
+
+// RangeScope identifies the pre-aggregates of one principal and rule, e.g. in a batch
+// read or a flushed batch.
+type RangeScope struct {
+	PrincipalID string
+	RuleName    string
+}
+
+// ScopedRange holds the pre-aggregates of one RangeScope over a query range and the
+// checkpoint of the principal's partition they were read with.
+type ScopedRange struct {
+	States     []AggregateState // ordered by window_start, dimensions
+	Checkpoint int64
+}
+
+// RankQuery asks a pre-aggregate store to rank the principals of one rule by their total
+// over [Start, End) at one bucket size.
+type RankQuery struct {
+	RuleName        string
+	Operator        string
+	RuleFingerprint string // totals built with another definition make the ranking stale
+	BucketSize      string
+	Start, End      time.Time
+	Ascending       bool
+	Limit           int      // number of top principals returned
+	PrincipalIDs    []string // principals whose totals are returned whether they rank or not
+}
+
+// Ranking is the answer to a RankQuery, read in one snapshot.
+type Ranking struct {
+	// Totals holds the merged state of the top principals and of the requested ones
+	// that have pre-aggregates in the range.
+	Totals map[string]AggregateState
+	// Stale is set if any pre-aggregate in the range was built with another rule definition.
+	Stale bool
+	// Checkpoints holds the checkpoint of every partition the totals were read with.
+	Checkpoints PartitionCheckpoints
+}
+
+// ShadowSwap replaces the live pre-aggregates of one rule and bucket size over
+// [Start, End) with the shadow rows staged by a range rebuild.
+type ShadowSwap struct {
+	RebuildID       string
+	RuleName        string
+	RuleFingerprint string // rule definition the shadow rows were computed with
+	BucketSize      string
+	Start, End      time.Time
+	// Checkpoints of every partition the shadow rows were computed up to.
+	Checkpoints PartitionCheckpoints
+}
+
+// Lease is a time-bound claim by one replica on a unit of exclusive work, such as
+// draining one bucket size. A lease whose ExpiresAt has passed may be taken over.
+type Lease struct {
+	Name       string
+	Holder     string // replica ID of the current holder
+	AcquiredAt time.Time
+	RenewedAt  time.Time
+	ExpiresAt  time.Time
+	Active     bool // ExpiresAt had not passed on the store's clock when the lease was read
+}
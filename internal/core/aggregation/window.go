@@ -45,3 +45,33 @@ func ParseWindowSize(s string) (WindowSpec, error) {
 func BucketFor(t time.Time, granularity time.Duration) time.Time {
 	return t.Truncate(granularity)
 }
+
+// ValidateBucketSize checks that d can be used as a pre-aggregate bucket: whole
+// minutes that divide a UTC day evenly, so hour/day rollups never split a bucket.
+func ValidateBucketSize(d time.Duration) error {
+	if d < time.Minute || d%time.Minute != 0 {
+		return fmt.Errorf("bucket size %s must be a whole number of minutes", BucketLabel(d))
+	}
+	if (24*time.Hour)%d != 0 {
+		return fmt.Errorf("bucket size %s must divide 24h evenly", BucketLabel(d))
+	}
+	return nil
+}
+
+// BucketLabel formats a bucket duration the way it is stored in bucket_size columns,
+// e.g. 1m, 15m, 1h, 1d.
+func BucketLabel(d time.Duration) string {
+	if d%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	}
+	if d%time.Hour == 0 {
+		return fmt.Sprintf("%dh", d/time.Hour)
+	}
+	if d%time.Minute == 0 {
+		return fmt.Sprintf("%dm", d/time.Minute)
+	}
+	if d%time.Second == 0 {
+		return fmt.Sprintf("%ds", d/time.Second)
+	}
+	return d.String()
+}
@@ -53,3 +53,19 @@ func TestBucketFor(t *testing.T) {
 		BucketFor(ts, 24*time.Hour),
 	)
 }
+
+func TestValidateBucketSize(t *testing.T) {
+	for _, d := range []time.Duration{time.Minute, 15 * time.Minute, time.Hour, 6 * time.Hour, 24 * time.Hour} {
+		require.NoError(t, ValidateBucketSize(d), BucketLabel(d))
+	}
+	for _, d := range []time.Duration{30 * time.Second, 90 * time.Second, 7 * time.Minute, 48 * time.Hour} {
+		require.Error(t, ValidateBucketSize(d), BucketLabel(d))
+	}
+}
+
+func TestBucketLabel(t *testing.T) {
+	require.Equal(t, "1m", BucketLabel(time.Minute))
+	require.Equal(t, "15m", BucketLabel(15*time.Minute))
+	require.Equal(t, "1h", BucketLabel(time.Hour))
+	require.Equal(t, "1d", BucketLabel(24*time.Hour))
+}
@@ -7,10 +7,12 @@ import (
 	"time"
 
 	coreagg "github.com/aevon-lab/project-aevon/internal/core/aggregation"
+	"github.com/aevon-lab/project-aevon/internal/core/partition"
 	"github.com/knadh/koanf/parsers/yaml"
 	"github.com/knadh/koanf/providers/env"
 	"github.com/knadh/koanf/providers/file"
 	"github.com/knadh/koanf/v2"
+	"github.com/shopspring/decimal"
 )
 
 // Config represents the top-level application config plus resolved rule-loading config.
@@ -19,6 +21,8 @@ type Config struct {
 	Database    DatabaseConfig    `koanf:"database"`
 	Schema      SchemaConfig      `koanf:"schema"`
 	Aggregation AggregationConfig `koanf:"aggregation"`
+	Webhooks    WebhooksConfig    `koanf:"webhooks"`
+	Events      EventsConfig      `koanf:"events"`
 
 	// RuleLoading is populated by Load after parsing rule files.
 	RuleLoading RuleLoadingConfig `koanf:"-"`
@@ -39,25 +43,100 @@ type DatabaseConfig struct {
 	AutoMigrate  bool   `koanf:"auto_migrate"`
 }
 
+// Database types. SQLite embeds the database in the aevon process: one replica owns
+// the file, so leader election, partition shards and webhooks need PostgreSQL. Memory
+// keeps everything in the process until it exits, for tests and demos, with the same
+// limits as SQLite.
+const (
+	DatabaseTypePostgres = "postgres"
+	DatabaseTypeSQLite   = "sqlite"
+	DatabaseTypeMemory   = "memory"
+)
+
 type SchemaConfig struct {
 	SourceType string `koanf:"source_type"`
 	Path       string `koanf:"path"`
 }
 
 type AggregationConfig struct {
-	ConfigDir         string `koanf:"config_dir"`
-	RequireRules      bool   `koanf:"require_rules"`
-	Enabled           bool   `koanf:"enabled"`
-	CronInterval      string `koanf:"cron_interval"`  // parsed and validated on startup
-	SweepInterval     string `koanf:"sweep_interval"` // legacy alias for cron_interval
-	BatchSize         int    `koanf:"batch_size"`
-	WorkerCount       int    `koanf:"worker_count"`
-	ChannelBufferSize int    `koanf:"channel_buffer_size"`
+	ConfigDir           string `koanf:"config_dir"`
+	RequireRules        bool   `koanf:"require_rules"`
+	WatchRules          bool   `koanf:"watch_rules"` // reload rules when files in config_dir change
+	Enabled             bool   `koanf:"enabled"`
+	CronInterval        string `koanf:"cron_interval"`  // parsed and validated on startup
+	SweepInterval       string `koanf:"sweep_interval"` // legacy alias for cron_interval
+	BatchSize           int    `koanf:"batch_size"`
+	WorkerCount         int    `koanf:"worker_count"`
+	ChannelBufferSize   int    `koanf:"channel_buffer_size"`
+	LeaderElection      bool   `koanf:"leader_election"`        // coordinate schedulers across replicas sharing one database
+	LeaseTTL            string `koanf:"lease_ttl"`              // failover time after the leader dies
+	ReplicaID           string `koanf:"replica_id"`             // defaults to hostname-pid
+	PartitionShards     int    `koanf:"partition_shards"`       // partition ranges per bucket size, each with its own checkpoints
+	MaxLeasesPerReplica int    `koanf:"max_leases_per_replica"` // 0 means no limit
+	Mode                string `koanf:"mode"`                   // batch | streaming
+	FlushInterval       string `koanf:"flush_interval"`         // streaming: how often hot buckets are flushed
+	GapTimeout          string `koanf:"gap_timeout"`            // streaming: wait for a missing ingest_seq before reading the event log
+}
+
+// Aggregation modes. Streaming folds events ingested by this process into in-memory
+// buckets and flushes them every flush_interval; batch reads them back from the event
+// log every cron_interval, which streaming mode keeps doing as a safety net.
+const (
+	AggregationModeBatch     = "batch"
+	AggregationModeStreaming = "streaming"
+)
+
+type WebhooksConfig struct {
+	Enabled       bool              `koanf:"enabled"`
+	SigningSecret Secret            `koanf:"signing_secret"` // HMAC-SHA256 key of X-Aevon-Signature
+	Timeout       string            `koanf:"timeout"`        // per delivery attempt
+	PollInterval  string            `koanf:"poll_interval"`  // how often the outbox is polled
+	MaxAttempts   int               `koanf:"max_attempts"`   // attempts before a delivery is given up
+	Thresholds    []ThresholdConfig `koanf:"thresholds"`     // read-only thresholds; more can be registered via the API
+}
+
+// EventsConfig configures the monthly partitions of the PostgreSQL event log.
+type EventsConfig struct {
+	PartitionsAhead     int           `koanf:"partitions_ahead"`     // months created ahead of the current one
+	RetentionMonths     int           `koanf:"retention_months"`     // full months kept before the current one; 0 keeps every event
+	RetentionAction     string        `koanf:"retention_action"`     // detach | drop
+	MaintenanceInterval string        `koanf:"maintenance_interval"` // how often partitions are created and retired
+	Archive             ArchiveConfig `koanf:"archive"`
+}
+
+// ArchiveConfig configures the export of ended event partitions to compressed files.
+type ArchiveConfig struct {
+	Enabled     bool   `koanf:"enabled"`
+	Path        string `koanf:"path"`          // archive directory; may be a mounted object store
+	RowsPerFile int    `koanf:"rows_per_file"` // events per archive file
+}
+
+// Retention actions. Detached partitions stay in the database as standalone tables for
+// archiving; dropped partitions are gone.
+const (
+	RetentionActionDetach = "detach"
+	RetentionActionDrop   = "drop"
+)
+
+// Secret is a config value that is redacted when the config is logged.
+type Secret string
+
+func (Secret) String() string { return "[redacted]" }
+
+type ThresholdConfig struct {
+	ID          string `koanf:"id"`
+	Rule        string `koanf:"rule"`
+	PrincipalID string `koanf:"principal_id"`
+	Period      string `koanf:"period"` // current_hour, current_day, current_week, current_month or last_<duration>
+	Timezone    string `koanf:"timezone"`
+	Value       string `koanf:"value"` // decimal
+	URL         string `koanf:"url"`
 }
 
 type RuleLoadingConfig struct {
-	ConfigDir string
-	Rules     []coreagg.AggregationRule
+	ConfigDir  string
+	Rules      []coreagg.AggregationRule
+	Repository *coreagg.FileSystemRuleRepository // reloads Rules at runtime
 }
 
 func (c AggregationConfig) EffectiveCronInterval() string {
@@ -70,6 +149,19 @@ func (c AggregationConfig) EffectiveCronInterval() string {
 	return "2m"
 }
 
+// EffectiveReplicaID returns the configured replica ID or, if unset, one derived
+// from the hostname and process ID.
+func (c AggregationConfig) EffectiveReplicaID() string {
+	if c.ReplicaID != "" {
+		return c.ReplicaID
+	}
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "aevon"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
 func (c *Config) Validate() error {
 	if c.Server.Port <= 0 || c.Server.Port > 65535 {
 		return fmt.Errorf("invalid server.port %d (must be 1-65535)", c.Server.Port)
@@ -84,7 +176,7 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("invalid server.mode %q (must be debug or release)", c.Server.Mode)
 	}
 
-	if strings.TrimSpace(c.Database.DSN) == "" {
+	if strings.TrimSpace(c.Database.DSN) == "" && c.Database.Type != DatabaseTypeMemory {
 		return fmt.Errorf("database.dsn is required")
 	}
 	if c.Database.MaxOpenConns <= 0 {
@@ -93,8 +185,11 @@ func (c *Config) Validate() error {
 	if c.Database.MaxIdleConns <= 0 {
 		return fmt.Errorf("database.max_idle_conns must be > 0")
 	}
-	if c.Database.Type != "" && c.Database.Type != "postgres" {
-		return fmt.Errorf("unsupported database.type %q", c.Database.Type)
+	switch c.Database.Type {
+	case "", DatabaseTypePostgres, DatabaseTypeSQLite, DatabaseTypeMemory:
+	default:
+		return fmt.Errorf("unsupported database.type %q (must be %s, %s or %s)",
+			c.Database.Type, DatabaseTypePostgres, DatabaseTypeSQLite, DatabaseTypeMemory)
 	}
 
 	if c.Schema.SourceType != "filesystem" {
@@ -126,7 +221,131 @@ func (c *Config) Validate() error {
 	if c.Aggregation.ChannelBufferSize < 0 {
 		return fmt.Errorf("aggregation.channel_buffer_size must be >= 0")
 	}
+	leaseTTL, err := time.ParseDuration(c.Aggregation.LeaseTTL)
+	if err != nil {
+		return fmt.Errorf("invalid aggregation.lease_ttl %q: %w", c.Aggregation.LeaseTTL, err)
+	}
+	if leaseTTL < 3*time.Second {
+		return fmt.Errorf("aggregation.lease_ttl must be >= 3s")
+	}
+	if c.Aggregation.PartitionShards < 1 || c.Aggregation.PartitionShards > partition.Count {
+		return fmt.Errorf("aggregation.partition_shards must be between 1 and %d", partition.Count)
+	}
+	if c.Aggregation.MaxLeasesPerReplica < 0 {
+		return fmt.Errorf("aggregation.max_leases_per_replica must be >= 0")
+	}
+	switch c.Aggregation.Mode {
+	case AggregationModeBatch:
+	case AggregationModeStreaming:
+		for _, setting := range []struct{ name, value string }{
+			{name: "flush_interval", value: c.Aggregation.FlushInterval},
+			{name: "gap_timeout", value: c.Aggregation.GapTimeout},
+		} {
+			d, err := time.ParseDuration(setting.value)
+			if err != nil {
+				return fmt.Errorf("invalid aggregation.%s %q: %w", setting.name, setting.value, err)
+			}
+			if d <= 0 {
+				return fmt.Errorf("aggregation.%s must be > 0", setting.name)
+			}
+		}
+	default:
+		return fmt.Errorf("invalid aggregation.mode %q (must be %s or %s)", c.Aggregation.Mode, AggregationModeBatch, AggregationModeStreaming)
+	}
+
+	if c.Webhooks.Enabled {
+		if err := c.Webhooks.validate(); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Events.validate(); err != nil {
+		return err
+	}
 
+	if c.Database.Type == DatabaseTypeSQLite || c.Database.Type == DatabaseTypeMemory {
+		if c.Aggregation.PartitionShards != 1 {
+			return fmt.Errorf("aggregation.partition_shards must be 1 with database.type %s", c.Database.Type)
+		}
+		if c.Webhooks.Enabled {
+			return fmt.Errorf("webhooks require database.type %s", DatabaseTypePostgres)
+		}
+		if c.Events.RetentionMonths != 0 {
+			return fmt.Errorf("events.retention_months requires database.type %s", DatabaseTypePostgres)
+		}
+		if c.Events.Archive.Enabled {
+			return fmt.Errorf("events.archive requires database.type %s", DatabaseTypePostgres)
+		}
+	}
+
+	return nil
+}
+
+// validate checks the webhook settings. Threshold definitions are only checked for
+// unique IDs and numeric values here; periods and URLs are validated at startup.
+func (c WebhooksConfig) validate() error {
+	if strings.TrimSpace(string(c.SigningSecret)) == "" {
+		return fmt.Errorf("webhooks.signing_secret is required when webhooks are enabled")
+	}
+	for _, setting := range []struct{ name, value string }{
+		{name: "timeout", value: c.Timeout},
+		{name: "poll_interval", value: c.PollInterval},
+	} {
+		d, err := time.ParseDuration(setting.value)
+		if err != nil {
+			return fmt.Errorf("invalid webhooks.%s %q: %w", setting.name, setting.value, err)
+		}
+		if d <= 0 {
+			return fmt.Errorf("webhooks.%s must be > 0", setting.name)
+		}
+	}
+	if c.MaxAttempts < 1 {
+		return fmt.Errorf("webhooks.max_attempts must be >= 1")
+	}
+
+	seen := make(map[string]struct{}, len(c.Thresholds))
+	for i, t := range c.Thresholds {
+		if strings.TrimSpace(t.ID) == "" {
+			return fmt.Errorf("webhooks.thresholds[%d].id is required", i)
+		}
+		if _, ok := seen[t.ID]; ok {
+			return fmt.Errorf("duplicate webhooks.thresholds id %q", t.ID)
+		}
+		seen[t.ID] = struct{}{}
+		if _, err := decimal.NewFromString(t.Value); err != nil {
+			return fmt.Errorf("invalid webhooks.thresholds[%d].value %q: %w", i, t.Value, err)
+		}
+	}
+	return nil
+}
+
+// validate checks the partition settings. They are only used with PostgreSQL.
+func (c EventsConfig) validate() error {
+	if c.PartitionsAhead < 0 {
+		return fmt.Errorf("events.partitions_ahead must be >= 0")
+	}
+	if c.RetentionMonths < 0 {
+		return fmt.Errorf("events.retention_months must be >= 0")
+	}
+	if c.RetentionAction != RetentionActionDetach && c.RetentionAction != RetentionActionDrop {
+		return fmt.Errorf("invalid events.retention_action %q (must be %s or %s)",
+			c.RetentionAction, RetentionActionDetach, RetentionActionDrop)
+	}
+	d, err := time.ParseDuration(c.MaintenanceInterval)
+	if err != nil {
+		return fmt.Errorf("invalid events.maintenance_interval %q: %w", c.MaintenanceInterval, err)
+	}
+	if d <= 0 {
+		return fmt.Errorf("events.maintenance_interval must be > 0")
+	}
+	if c.Archive.Enabled {
+		if strings.TrimSpace(c.Archive.Path) == "" {
+			return fmt.Errorf("events.archive.path is required when the archive is enabled")
+		}
+		if c.Archive.RowsPerFile <= 0 {
+			return fmt.Errorf("events.archive.rows_per_file must be > 0")
+		}
+	}
 	return nil
 }
 
@@ -135,25 +354,46 @@ func Load(configPath string) (*Config, error) {
 	k := koanf.New(".")
 
 	defaults := map[string]interface{}{
-		"server.port":                     8080,
-		"server.host":                     "0.0.0.0",
-		"server.max_body_size_mb":         1,
-		"server.mode":                     "release",
-		"database.type":                   "postgres",
-		"database.dsn":                    "aevon.db",
-		"database.max_open_conns":         25,
-		"database.max_idle_conns":         25,
-		"database.auto_migrate":           true,
-		"schema.source_type":              "filesystem",
-		"schema.path":                     "./schemas",
-		"aggregation.config_dir":          "./config/aggregations",
-		"aggregation.require_rules":       false,
-		"aggregation.enabled":             true,
-		"aggregation.cron_interval":       "2m",
-		"aggregation.sweep_interval":      "",
-		"aggregation.batch_size":          50000,
-		"aggregation.worker_count":        10,
-		"aggregation.channel_buffer_size": 1024,
+		"server.port":                        8080,
+		"server.host":                        "0.0.0.0",
+		"server.max_body_size_mb":            1,
+		"server.mode":                        "release",
+		"database.type":                      DatabaseTypePostgres,
+		"database.dsn":                       "aevon.db",
+		"database.max_open_conns":            25,
+		"database.max_idle_conns":            25,
+		"database.auto_migrate":              true,
+		"schema.source_type":                 "filesystem",
+		"schema.path":                        "./schemas",
+		"aggregation.config_dir":             "./config/aggregations",
+		"aggregation.require_rules":          false,
+		"aggregation.watch_rules":            true,
+		"aggregation.enabled":                true,
+		"aggregation.cron_interval":          "2m",
+		"aggregation.sweep_interval":         "",
+		"aggregation.batch_size":             50000,
+		"aggregation.worker_count":           10,
+		"aggregation.channel_buffer_size":    1024,
+		"aggregation.leader_election":        true,
+		"aggregation.lease_ttl":              "30s",
+		"aggregation.replica_id":             "",
+		"aggregation.partition_shards":       1,
+		"aggregation.max_leases_per_replica": 0,
+		"aggregation.mode":                   AggregationModeBatch,
+		"aggregation.flush_interval":         "1s",
+		"aggregation.gap_timeout":            "5s",
+		"webhooks.enabled":                   false,
+		"webhooks.signing_secret":            "",
+		"webhooks.timeout":                   "10s",
+		"webhooks.poll_interval":             "1s",
+		"webhooks.max_attempts":              10,
+		"events.partitions_ahead":            3,
+		"events.retention_months":            0,
+		"events.retention_action":            RetentionActionDetach,
+		"events.maintenance_interval":        "1h",
+		"events.archive.enabled":             false,
+		"events.archive.path":                "",
+		"events.archive.rows_per_file":       100000,
 	}
 	for key, value := range defaults {
 		k.Set(key, value)
@@ -189,8 +429,9 @@ func Load(configPath string) (*Config, error) {
 	}
 
 	cfg.RuleLoading = RuleLoadingConfig{
-		ConfigDir: cfg.Aggregation.ConfigDir,
-		Rules:     rules,
+		ConfigDir:  cfg.Aggregation.ConfigDir,
+		Rules:      rules,
+		Repository: repo,
 	}
 
 	return &cfg, nil
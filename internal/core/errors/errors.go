@@ -1,11 +1,19 @@
 package errors
 
 const (
-	HttpInternalError         = "internal_error"
-	HttpInvalidJsonError      = "invalid_json"
-	HttpSchemaNotFoundError   = "schema_not_found"
-	HttpSchemaValidationError = "schema_validation_failed"
-	HttpDuplicateEventError   = "duplicate_event"
+	HttpInternalError            = "internal_error"
+	HttpInvalidJsonError         = "invalid_json"
+	HttpSchemaNotFoundError      = "schema_not_found"
+	HttpSchemaValidationError    = "schema_validation_failed"
+	HttpDuplicateEventError      = "duplicate_event"
+	HttpRuleValidationError      = "rule_validation_failed"
+	HttpLateEventError           = "late_event"
+	HttpThresholdValidationError = "threshold_validation_failed"
+	HttpThresholdNotFoundError   = "threshold_not_found"
+	HttpThresholdConflictError   = "threshold_conflict"
+	HttpVerifyValidationError    = "verify_validation_failed"
+	HttpVerifyConflictError      = "verify_conflict"
+	HttpUnsupportedError         = "unsupported"
 )
 
 // ErrorResponse is the error response body for ingestion errors.
@@ -38,3 +38,25 @@ func TestFor_Distribution(t *testing.T) {
 		t.Errorf("only %d distinct partitions from 1000 inputs, want >= 100", len(seen))
 	}
 }
+
+func TestSplit_CoversAllPartitionsOnce(t *testing.T) {
+	for _, n := range []int{1, 3, 4, 256} {
+		ranges := Split(n)
+		if len(ranges) != n {
+			t.Fatalf("Split(%d) returned %d ranges", n, len(ranges))
+		}
+		next := 0
+		for _, r := range ranges {
+			if r.From != next || r.Len() < 1 {
+				t.Fatalf("Split(%d): range %v does not continue at %d", n, r, next)
+			}
+			next = r.To
+		}
+		if next != Count {
+			t.Fatalf("Split(%d) ends at %d, want %d", n, next, Count)
+		}
+	}
+	if !Split(1)[0].IsFull() {
+		t.Errorf("Split(1) = %v, want the full range", Split(1))
+	}
+}
@@ -0,0 +1,56 @@
+package partition
+
+import "fmt"
+
+// Range is a half-open range [From, To) of partition IDs.
+// The zero value is empty; Full covers every partition.
+type Range struct {
+	From int
+	To   int
+}
+
+// Full returns the range of all partitions.
+func Full() Range {
+	return Range{From: 0, To: Count}
+}
+
+// IsZero reports whether r is the zero (empty) range.
+func (r Range) IsZero() bool {
+	return r == Range{}
+}
+
+// IsFull reports whether r covers every partition.
+func (r Range) IsFull() bool {
+	return r == Full()
+}
+
+// Len returns the number of partitions in r.
+func (r Range) Len() int {
+	return r.To - r.From
+}
+
+// Contains reports whether partitionID lies in r.
+func (r Range) Contains(partitionID int) bool {
+	return partitionID >= r.From && partitionID < r.To
+}
+
+// String renders r as "p<from>-<to>", e.g. "p0-128".
+func (r Range) String() string {
+	return fmt.Sprintf("p%d-%d", r.From, r.To)
+}
+
+// Split divides all partitions into n contiguous ranges of near-equal size.
+// n is clamped to [1, Count].
+func Split(n int) []Range {
+	if n < 1 {
+		n = 1
+	}
+	if n > Count {
+		n = Count
+	}
+	ranges := make([]Range, n)
+	for i := range ranges {
+		ranges[i] = Range{From: i * Count / n, To: (i + 1) * Count / n}
+	}
+	return ranges
+}
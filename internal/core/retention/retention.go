@@ -0,0 +1,24 @@
+package retention
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrPartitionNotAggregated is returned when a partition still holds events after the
+// checkpoint of a bucket size or the cursor of a running rule rebuild.
+var ErrPartitionNotAggregated = errors.New("event partition not aggregated yet")
+
+// EventPartition is the monthly partition of the event log holding the events ingested
+// in [From, To). Bounds are UTC month starts.
+type EventPartition struct {
+	Name string
+	From time.Time
+	To   time.Time
+}
+
+// MonthStart returns the start of the UTC month of t.
+func MonthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
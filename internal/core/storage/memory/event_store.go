@@ -0,0 +1,304 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	v1 "github.com/aevon-lab/project-aevon/internal/api/v1"
+	"github.com/aevon-lab/project-aevon/internal/core/partition"
+	"github.com/aevon-lab/project-aevon/internal/core/storage"
+)
+
+// eventKey is the idempotency key of an event.
+type eventKey struct {
+	principalID string
+	id          string
+}
+
+// EventStore implements storage.EventStore in memory. Events are lost when the process
+// exits; the store is meant for tests, demos and embedding Aevon in integration tests.
+//
+// Saved events are copies, and reads return copies, so callers may modify events on
+// either side. ingest_seq is assigned under the store lock when an event is saved, so
+// the log has no gaps and a reader never sees a later ingest_seq before an earlier one.
+type EventStore struct {
+	mu     sync.RWMutex
+	events []*v1.Event // ordered by IngestSeq, which starts at 1
+	keys   map[eventKey]struct{}
+}
+
+// NewEventStore creates an empty in-memory event store.
+func NewEventStore() *EventStore {
+	return &EventStore{keys: make(map[eventKey]struct{})}
+}
+
+// SaveEvent persists an event and populates IngestSeq.
+// Returns storage.ErrDuplicate if an event with the same (principal_id, id) exists.
+func (s *EventStore) SaveEvent(ctx context.Context, event *v1.Event) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.insert(event) {
+		return storage.ErrDuplicate
+	}
+	return nil
+}
+
+// SaveEvents persists a batch of events atomically: readers see all inserted events of
+// the batch or none. The returned slice is index-aligned with events: nil for inserted
+// events (IngestSeq populated), storage.ErrDuplicate for events that already existed or
+// repeat an earlier event in the same batch.
+func (s *EventStore) SaveEvents(ctx context.Context, events []*v1.Event) ([]error, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	results := make([]error, len(events))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, event := range events {
+		if !s.insert(event) {
+			results[i] = storage.ErrDuplicate
+		}
+	}
+	return results, nil
+}
+
+// insert appends a copy of event with the next ingest_seq and sets it on event. It
+// reports false if (principal_id, id) already exists. The caller holds s.mu.
+func (s *EventStore) insert(event *v1.Event) bool {
+	key := eventKey{principalID: event.PrincipalID, id: event.ID}
+	if _, ok := s.keys[key]; ok {
+		return false
+	}
+	s.keys[key] = struct{}{}
+
+	event.IngestSeq = int64(len(s.events)) + 1
+	s.events = append(s.events, copyEvent(event))
+	return true
+}
+
+// RetrieveEventsAfter fetches events ingested after a given timestamp, ordered by
+// ingested_at ASC.
+func (s *EventStore) RetrieveEventsAfter(ctx context.Context, afterTime time.Time, limit int) ([]*v1.Event, error) {
+	s.mu.RLock()
+	matches := s.filter(0, func(e *v1.Event) bool { return e.IngestedAt.After(afterTime) })
+	s.mu.RUnlock()
+
+	sortByIngestedAt(matches)
+	return copyEvents(matches, limit), nil
+}
+
+// RetrieveEventsByPrincipalAndIngestedRange fetches raw events for one principal
+// in an ingested_at time range [start, end], ordered by ingested_at ASC.
+func (s *EventStore) RetrieveEventsByPrincipalAndIngestedRange(
+	ctx context.Context,
+	principalID string,
+	startIngestedAt time.Time,
+	endIngestedAt time.Time,
+	limit int,
+) ([]*v1.Event, error) {
+	s.mu.RLock()
+	matches := s.filter(0, func(e *v1.Event) bool {
+		return e.PrincipalID == principalID &&
+			!e.IngestedAt.Before(startIngestedAt) && !e.IngestedAt.After(endIngestedAt)
+	})
+	s.mu.RUnlock()
+
+	sortByIngestedAt(matches)
+	return copyEvents(matches, limit), nil
+}
+
+// RetrieveEventsAfterCursor fetches events after a cursor (ingest_seq) in strict total
+// order. cursor=0 means "from the beginning".
+func (s *EventStore) RetrieveEventsAfterCursor(ctx context.Context, cursor int64, limit int) ([]*v1.Event, error) {
+	return s.scan(cursor, limit, func(*v1.Event) bool { return true }), nil
+}
+
+// RetrievePartitionEventsAfterCursor fetches events of principals in partitions after a
+// cursor (ingest_seq) in strict total order.
+func (s *EventStore) RetrievePartitionEventsAfterCursor(
+	ctx context.Context,
+	cursor int64,
+	partitions partition.Range,
+	limit int,
+) ([]*v1.Event, error) {
+	return s.scan(cursor, limit, func(e *v1.Event) bool {
+		return partitions.Contains(partition.For(e.PrincipalID))
+	}), nil
+}
+
+// FirstIngestSeqAfter returns the lowest ingest_seq of the principal's events ingested
+// after t, or 0 if there is none.
+func (s *EventStore) FirstIngestSeqAfter(ctx context.Context, principalID string, t time.Time) (int64, error) {
+	first := s.scan(0, 1, func(e *v1.Event) bool {
+		return e.PrincipalID == principalID && e.IngestedAt.After(t)
+	})
+	if len(first) == 0 {
+		return 0, nil
+	}
+	return first[0].IngestSeq, nil
+}
+
+// FirstTypeIngestSeqAfter returns the lowest ingest_seq of the events of eventType
+// ingested after t, or 0 if there is none.
+func (s *EventStore) FirstTypeIngestSeqAfter(ctx context.Context, eventType string, t time.Time) (int64, error) {
+	first := s.scan(0, 1, func(e *v1.Event) bool {
+		return e.Type == eventType && e.IngestedAt.After(t)
+	})
+	if len(first) == 0 {
+		return 0, nil
+	}
+	return first[0].IngestSeq, nil
+}
+
+// RetrieveScopedEventsAfterCursor fetches events in strict order for one projection
+// query scope: events of the principal and type that occurred in [startOccurredAt,
+// endOccurredAt), or with includeIngested were ingested in it.
+func (s *EventStore) RetrieveScopedEventsAfterCursor(
+	ctx context.Context,
+	cursor int64,
+	principalID string,
+	eventType string,
+	startOccurredAt time.Time,
+	endOccurredAt time.Time,
+	includeIngested bool,
+	limit int,
+) ([]*v1.Event, error) {
+	return s.scan(cursor, limit, func(e *v1.Event) bool {
+		return e.PrincipalID == principalID && e.Type == eventType && inScope(e, startOccurredAt, endOccurredAt, includeIngested)
+	}), nil
+}
+
+// RetrieveBatchScopedEventsAfterCursor fetches events of several principals in strict
+// order for one batch projection query scope.
+func (s *EventStore) RetrieveBatchScopedEventsAfterCursor(
+	ctx context.Context,
+	cursor int64,
+	principalIDs []string,
+	eventType string,
+	startOccurredAt time.Time,
+	endOccurredAt time.Time,
+	includeIngested bool,
+	limit int,
+) ([]*v1.Event, error) {
+	principals := make(map[string]struct{}, len(principalIDs))
+	for _, principalID := range principalIDs {
+		principals[principalID] = struct{}{}
+	}
+	return s.scan(cursor, limit, func(e *v1.Event) bool {
+		_, ok := principals[e.PrincipalID]
+		return ok && e.Type == eventType && inScope(e, startOccurredAt, endOccurredAt, includeIngested)
+	}), nil
+}
+
+// RetrieveTypeScopedEventsAfterCursor fetches events of one type of every principal in
+// strict order for a leaderboard query scope.
+func (s *EventStore) RetrieveTypeScopedEventsAfterCursor(
+	ctx context.Context,
+	cursor int64,
+	eventType string,
+	startOccurredAt time.Time,
+	endOccurredAt time.Time,
+	includeIngested bool,
+	limit int,
+) ([]*v1.Event, error) {
+	return s.scan(cursor, limit, func(e *v1.Event) bool {
+		return e.Type == eventType && inScope(e, startOccurredAt, endOccurredAt, includeIngested)
+	}), nil
+}
+
+// scan returns copies of the first limit events after cursor that match, in ingest_seq
+// order.
+func (s *EventStore) scan(cursor int64, limit int, match func(*v1.Event) bool) []*v1.Event {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var result []*v1.Event
+	for _, event := range s.after(cursor) {
+		if len(result) >= limit {
+			break
+		}
+		if match(event) {
+			result = append(result, copyEvent(event))
+		}
+	}
+	return result
+}
+
+// filter returns the stored events after cursor that match, in ingest_seq order. The
+// caller holds s.mu and must copy the events before handing them out.
+func (s *EventStore) filter(cursor int64, match func(*v1.Event) bool) []*v1.Event {
+	var result []*v1.Event
+	for _, event := range s.after(cursor) {
+		if match(event) {
+			result = append(result, event)
+		}
+	}
+	return result
+}
+
+// after returns the stored events with an ingest_seq above cursor. The caller holds s.mu.
+func (s *EventStore) after(cursor int64) []*v1.Event {
+	if cursor < 0 {
+		cursor = 0
+	}
+	if cursor >= int64(len(s.events)) {
+		return nil
+	}
+	return s.events[cursor:]
+}
+
+// inScope reports whether e occurred in [start, end), or with includeIngested was
+// ingested in it.
+func inScope(e *v1.Event, start, end time.Time, includeIngested bool) bool {
+	if !e.OccurredAt.Before(start) && e.OccurredAt.Before(end) {
+		return true
+	}
+	return includeIngested && !e.IngestedAt.Before(start) && e.IngestedAt.Before(end)
+}
+
+// sortByIngestedAt orders events by ingested_at, then ingest_seq.
+func sortByIngestedAt(events []*v1.Event) {
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].IngestedAt.Before(events[j].IngestedAt)
+	})
+}
+
+// copyEvents copies the first limit events.
+func copyEvents(events []*v1.Event, limit int) []*v1.Event {
+	if limit < len(events) {
+		events = events[:max(limit, 0)]
+	}
+	var result []*v1.Event
+	for _, event := range events {
+		result = append(result, copyEvent(event))
+	}
+	return result
+}
+
+// copyEvent copies event with its metadata and top-level data fields. Nested data values
+// are shared; events are never modified once saved.
+func copyEvent(event *v1.Event) *v1.Event {
+	c := *event
+	if event.Metadata != nil {
+		c.Metadata = make(map[string]string, len(event.Metadata))
+		for k, v := range event.Metadata {
+			c.Metadata[k] = v
+		}
+	}
+	if event.Data != nil {
+		c.Data = make(map[string]interface{}, len(event.Data))
+		for k, v := range event.Data {
+			c.Data[k] = v
+		}
+	}
+	return &c
+}
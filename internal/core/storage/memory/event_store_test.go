@@ -0,0 +1,14 @@
+package memory
+
+import (
+	"testing"
+
+	"github.com/aevon-lab/project-aevon/internal/core/storage"
+	"github.com/aevon-lab/project-aevon/internal/core/storage/storetest"
+)
+
+func TestEventStore(t *testing.T) {
+	storetest.RunEventStore(t, func(t *testing.T) storage.EventStore {
+		return NewEventStore()
+	})
+}
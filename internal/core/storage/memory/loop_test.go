@@ -0,0 +1,245 @@
+package memory_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	aggjob "github.com/aevon-lab/project-aevon/internal/aggregation"
+	v1 "github.com/aevon-lab/project-aevon/internal/api/v1"
+	"github.com/aevon-lab/project-aevon/internal/core/aggregation"
+	"github.com/aevon-lab/project-aevon/internal/core/partition"
+	"github.com/aevon-lab/project-aevon/internal/core/storage/memory"
+	"github.com/aevon-lab/project-aevon/internal/projection"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+)
+
+// TestIngestAggregateQuery runs the ingest, aggregate and query loop on the in-memory
+// stores: a query merges flushed pre-aggregates with the raw tail after the checkpoint.
+func TestIngestAggregateQuery(t *testing.T) {
+	ctx := context.Background()
+	events := memory.NewEventStore()
+	preAggregates := memory.NewPreAggregateStore()
+	rules := []aggregation.AggregationRule{{
+		Name:        "sum_tokens",
+		SourceEvent: "api.request",
+		Operator:    aggregation.OpSum,
+		Field:       "tokens",
+		WindowSize:  time.Minute,
+		Fingerprint: "fp-1",
+	}}
+	start := time.Now().UTC().Truncate(time.Minute).Add(-time.Hour)
+
+	save := func(from, to int) {
+		var batch []*v1.Event
+		for i := from; i < to; i++ {
+			batch = append(batch, &v1.Event{
+				ID:          fmt.Sprintf("evt-%d", i),
+				PrincipalID: "user:alice",
+				Type:        "api.request",
+				OccurredAt:  start.Add(time.Duration(i) * time.Minute),
+				IngestedAt:  start.Add(time.Duration(i)*time.Minute + time.Second),
+				Data:        map[string]interface{}{"tokens": float64(10)},
+			})
+		}
+		_, err := events.SaveEvents(ctx, batch)
+		require.NoError(t, err)
+	}
+
+	save(0, 3)
+	_, err := preAggregates.ReconcileRuleVersion(ctx, "sum_tokens", "1m", "fp-1")
+	require.NoError(t, err)
+	require.NoError(t, aggjob.RunBatchAggregation(ctx, events, preAggregates, rules))
+	save(3, 5) // not aggregated yet
+
+	checkpoint, err := preAggregates.ReadCheckpoint(ctx, "1m")
+	require.NoError(t, err)
+	require.Equal(t, int64(3), checkpoint)
+
+	resp, err := projection.NewService(preAggregates, events, rules).QueryAggregates(ctx, projection.AggregateQueryRequest{
+		PrincipalID: "user:alice",
+		Rule:        "sum_tokens",
+		Start:       start,
+		End:         start.Add(time.Hour),
+	})
+	require.NoError(t, err)
+	require.Len(t, resp.Values, 1)
+	require.Equal(t, "50", resp.Values[0].Value.String())
+	require.Equal(t, int64(5), resp.Values[0].EventCount)
+}
+
+// TestQueryStableAcrossFlush checks that flushing the raw tail of an hourly rule does not
+// change query answers, also for ranges that straddle an hour: their partial hours are
+// folded from raw events before and after the flush.
+func TestQueryStableAcrossFlush(t *testing.T) {
+	ctx := context.Background()
+	events := memory.NewEventStore()
+	preAggregates := memory.NewPreAggregateStore()
+	rules := []aggregation.AggregationRule{{
+		Name:        "hourly_requests",
+		SourceEvent: "api.request",
+		Operator:    aggregation.OpCount,
+		WindowSize:  time.Hour,
+		BucketSizes: []time.Duration{time.Hour},
+		Fingerprint: "fp-1",
+	}}
+	hour := time.Now().UTC().Truncate(time.Hour).Add(-3 * time.Hour)
+
+	var batch []*v1.Event
+	for i, offset := range []time.Duration{10 * time.Minute, 50 * time.Minute, 80 * time.Minute} {
+		batch = append(batch, &v1.Event{
+			ID:          fmt.Sprintf("evt-%d", i),
+			PrincipalID: "user:alice",
+			Type:        "api.request",
+			OccurredAt:  hour.Add(offset),
+			IngestedAt:  hour.Add(offset + time.Second),
+		})
+	}
+	_, err := events.SaveEvents(ctx, batch)
+	require.NoError(t, err)
+	_, err = preAggregates.ReconcileRuleVersion(ctx, "hourly_requests", "1h", "fp-1")
+	require.NoError(t, err)
+
+	svc := projection.NewService(preAggregates, events, rules)
+	query := func(start, end time.Time) int64 {
+		resp, err := svc.QueryAggregates(ctx, projection.AggregateQueryRequest{
+			PrincipalID: "user:alice",
+			Rule:        "hourly_requests",
+			Start:       start,
+			End:         end,
+		})
+		require.NoError(t, err)
+		require.Len(t, resp.Values, 1)
+
+		batch, err := svc.QueryAggregatesBatch(ctx, projection.BatchQueryRequest{
+			Principals: []string{"user:alice"},
+			Rules:      []string{"hourly_requests"},
+			Start:      start,
+			End:        end,
+		})
+		require.NoError(t, err)
+		require.Equal(t, resp.Values[0].EventCount, batch.Results["user:alice"]["hourly_requests"].Values[0].EventCount)
+		return resp.Values[0].EventCount
+	}
+
+	for _, flushed := range []bool{false, true} {
+		if flushed {
+			opts := aggjob.DefaultBatchJobOptions()
+			opts.BucketSize, opts.BucketLabel = time.Hour, "1h"
+			require.NoError(t, aggjob.RunBatchAggregationWithOptions(ctx, events, preAggregates, rules, opts))
+			checkpoint, err := preAggregates.ReadCheckpoint(ctx, "1h")
+			require.NoError(t, err)
+			require.Equal(t, int64(3), checkpoint)
+		}
+
+		require.Equal(t, int64(3), query(hour, hour.Add(2*time.Hour)), "flushed=%v", flushed)
+		require.Equal(t, int64(2), query(hour.Add(30*time.Minute), hour.Add(2*time.Hour)), "flushed=%v", flushed)
+		require.Equal(t, int64(2), query(hour.Add(30*time.Minute), hour.Add(90*time.Minute)), "flushed=%v", flushed)
+		require.Equal(t, int64(1), query(hour.Add(5*time.Minute), hour.Add(30*time.Minute)), "flushed=%v", flushed)
+		require.Equal(t, int64(2), query(hour, hour.Add(70*time.Minute+30*time.Second)), "flushed=%v", flushed)
+	}
+}
+
+// TestLeaderboardVerifyAndRebuild runs leaderboards, verification with repair and range
+// rebuilds on the in-memory stores, which serve them like PostgreSQL.
+func TestLeaderboardVerifyAndRebuild(t *testing.T) {
+	ctx := context.Background()
+	events := memory.NewEventStore()
+	preAggregates := memory.NewPreAggregateStore()
+	rules := []aggregation.AggregationRule{{
+		Name:        "count_requests",
+		SourceEvent: "api.request",
+		Operator:    aggregation.OpCount,
+		WindowSize:  time.Minute,
+		Fingerprint: "fp-1",
+	}}
+	start := time.Now().UTC().Truncate(time.Hour).Add(-2 * time.Hour)
+	end := start.Add(time.Hour)
+
+	var batch []*v1.Event
+	for i, principalID := range []string{"user:alice", "user:bob", "user:alice", "user:alice"} {
+		batch = append(batch, &v1.Event{
+			ID:          fmt.Sprintf("evt-%d", i),
+			PrincipalID: principalID,
+			Type:        "api.request",
+			OccurredAt:  start.Add(time.Duration(i) * time.Minute),
+			IngestedAt:  start.Add(time.Duration(i)*time.Minute + time.Second),
+		})
+	}
+	_, err := events.SaveEvents(ctx, batch)
+	require.NoError(t, err)
+	_, err = preAggregates.ReconcileRuleVersion(ctx, "count_requests", "1m", "fp-1")
+	require.NoError(t, err)
+	require.NoError(t, aggjob.RunBatchAggregation(ctx, events, preAggregates, rules))
+
+	top, err := projection.NewService(preAggregates, events, rules).QueryTop(ctx, projection.TopQueryRequest{
+		Rule:  "count_requests",
+		Start: start,
+		End:   end,
+	})
+	require.NoError(t, err)
+	require.Len(t, top.Principals, 2)
+	require.Equal(t, "user:alice", top.Principals[0].PrincipalID)
+	require.Equal(t, "3", top.Principals[0].Value.String())
+
+	// Partial minutes at the range edges are counted from raw events.
+	top, err = projection.NewService(preAggregates, events, rules).QueryTop(ctx, projection.TopQueryRequest{
+		Rule:  "count_requests",
+		Start: start.Add(90 * time.Second),
+		End:   start.Add(3*time.Minute + 30*time.Second),
+	})
+	require.NoError(t, err)
+	require.Len(t, top.Principals, 1)
+	require.Equal(t, "2", top.Principals[0].Value.String())
+
+	// Overwrite alice's first bucket with a wrong count.
+	corrupt := func() {
+		key := aggregation.AggregateKey{
+			PartitionID: partition.For("user:alice"),
+			PrincipalID: "user:alice",
+			RuleName:    "count_requests",
+			BucketSize:  "1m",
+			WindowStart: start,
+		}
+		checkpoints, err := preAggregates.ReadPartitionCheckpoints(ctx, "1m", partition.Full())
+		require.NoError(t, err)
+		require.NoError(t, preAggregates.RepairAggregates(ctx, "1m",
+			map[int]int64{key.PartitionID: checkpoints.Cursor(key.PartitionID)},
+			[]aggregation.AggregateKey{key},
+			map[aggregation.AggregateKey]aggregation.AggregateState{key: {
+				Operator:        aggregation.OpCount,
+				Value:           decimal.NewFromInt(5),
+				EventCount:      5,
+				RuleFingerprint: "fp-1",
+			}},
+		))
+	}
+	verifier := aggjob.NewVerifier(events, preAggregates, rules, aggjob.DefaultBatchJobOptions())
+	verify := func(repair bool) *aggjob.DriftReport {
+		report, err := verifier.Verify(ctx, aggjob.VerifyRequest{
+			Rule:         "count_requests",
+			PrincipalIDs: []string{"user:alice", "user:bob"},
+			Start:        start,
+			End:          end,
+			Repair:       repair,
+		})
+		require.NoError(t, err)
+		return report
+	}
+
+	corrupt()
+	report := verify(true)
+	require.Equal(t, 1, report.Drifted)
+	require.Equal(t, 1, report.Repaired)
+	require.Zero(t, verify(false).Drifted)
+
+	corrupt()
+	rebuilt, err := aggjob.NewRangeRebuilder(events, preAggregates, rules, aggjob.DefaultBatchJobOptions()).Rebuild(ctx,
+		aggjob.RangeRebuildRequest{Rule: "count_requests", From: start, To: end})
+	require.NoError(t, err)
+	require.Len(t, rebuilt.Buckets, 1)
+	require.Equal(t, 4, rebuilt.Buckets[0].Events)
+	require.Zero(t, verify(false).Drifted)
+}
@@ -0,0 +1,482 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aevon-lab/project-aevon/internal/core/aggregation"
+	"github.com/aevon-lab/project-aevon/internal/core/partition"
+)
+
+const defaultBucketSize = "1m"
+
+// rowScope groups the pre-aggregates one range query reads.
+type rowScope struct {
+	principalID string
+	ruleName    string
+	bucketSize  string
+}
+
+// ruleVersionKey identifies a rule version.
+type ruleVersionKey struct {
+	ruleName   string
+	bucketSize string
+}
+
+// PreAggregateStore implements aggregation.PreAggregateStore in memory, together with
+// the partition checkpoints, verification, repair and shadow rebuild extensions of the
+// PostgreSQL store.
+//
+// Every method runs under one lock, so a flush and its checkpoints are applied
+// atomically and a range read sees the checkpoints of the rows it returns. Like on
+// PostgreSQL, checkpoints are kept per bucket size and partition.
+type PreAggregateStore struct {
+	mu           sync.RWMutex
+	rows         map[rowScope]map[aggregation.AggregateKey]aggregation.AggregateState
+	checkpoints  map[string][]int64 // by bucket size, indexed by partition
+	ruleVersions map[ruleVersionKey]aggregation.RuleVersion
+	shadows      map[string]map[aggregation.AggregateKey]aggregation.AggregateState // by rebuild ID
+}
+
+// NewPreAggregateStore creates an empty in-memory pre-aggregate store.
+func NewPreAggregateStore() *PreAggregateStore {
+	return &PreAggregateStore{
+		rows:         make(map[rowScope]map[aggregation.AggregateKey]aggregation.AggregateState),
+		checkpoints:  make(map[string][]int64),
+		ruleVersions: make(map[ruleVersionKey]aggregation.RuleVersion),
+		shadows:      make(map[string]map[aggregation.AggregateKey]aggregation.AggregateState),
+	}
+}
+
+// Flush merges all pre-aggregates into the stored ones and advances the checkpoint of
+// every partition to cursor, atomically. Flushes with a cursor at or behind the
+// checkpoint are skipped as stale, and nothing is written if any aggregate is rejected.
+// Partitions at different checkpoints must be flushed per range with FlushPartitions.
+func (s *PreAggregateStore) Flush(
+	ctx context.Context,
+	aggregates map[aggregation.AggregateKey]aggregation.AggregateState,
+	cursor int64,
+	bucketSize string,
+) error {
+	if bucketSize == "" {
+		bucketSize = defaultBucketSize
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	checkpoints := s.partitionCheckpoints(bucketSize, partition.Full())
+	if checkpoints.Min() != checkpoints.Max() {
+		return fmt.Errorf(
+			"pre_aggregate flush: partition checkpoints of bucket %s differ (%d..%d); flush per partition range",
+			bucketSize, checkpoints.Min(), checkpoints.Max(),
+		)
+	}
+	if durableCursor := checkpoints.Max(); cursor <= durableCursor {
+		slog.Warn("[PreAggregateStore] Skipping stale/no-op flush",
+			"cursor", cursor,
+			"durable_cursor", durableCursor,
+			"aggregates", len(aggregates))
+		return nil
+	}
+
+	if err := s.checkRuleFingerprints(aggregates, bucketSize); err != nil {
+		return fmt.Errorf("pre_aggregate flush: %w", err)
+	}
+	if err := s.upsertAggregates(aggregates, bucketSize); err != nil {
+		return fmt.Errorf("pre_aggregate flush: %w", err)
+	}
+	s.writeCheckpoints(bucketSize, partition.Full(), cursor)
+
+	slog.Info("[PreAggregateStore] Flushed",
+		"aggregates", len(aggregates),
+		"cursor", cursor,
+		"bucket_size", bucketSize,
+	)
+	return nil
+}
+
+// FlushPartitions merges aggregates of one partition range and advances the checkpoints
+// of its partitions atomically. The flush is skipped as stale if any checkpoint moved
+// since the batch read from.
+func (s *PreAggregateStore) FlushPartitions(
+	ctx context.Context,
+	aggregates map[aggregation.AggregateKey]aggregation.AggregateState,
+	bucketSize string,
+	from aggregation.PartitionCheckpoints,
+	cursor int64,
+) error {
+	if bucketSize == "" {
+		bucketSize = defaultBucketSize
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	durable := s.partitionCheckpoints(bucketSize, from.Range)
+	for i, durableCursor := range durable.Cursors {
+		if durableCursor != from.Cursors[i] {
+			slog.Warn("[PreAggregateStore] Skipping stale flush; partition checkpoint moved",
+				"partition", from.Range.From+i,
+				"cursor", cursor,
+				"read_cursor", from.Cursors[i],
+				"durable_cursor", durableCursor,
+				"aggregates", len(aggregates))
+			return nil
+		}
+	}
+
+	for key := range aggregates {
+		if !from.Range.Contains(key.PartitionID) {
+			return fmt.Errorf("pre_aggregate flush: aggregate partition %d outside range %s", key.PartitionID, from.Range)
+		}
+	}
+	if err := s.checkRuleFingerprints(aggregates, bucketSize); err != nil {
+		return fmt.Errorf("pre_aggregate flush: %w", err)
+	}
+	if err := s.upsertAggregates(aggregates, bucketSize); err != nil {
+		return fmt.Errorf("pre_aggregate flush: %w", err)
+	}
+	s.writeCheckpoints(bucketSize, from.Range, cursor)
+
+	slog.Info("[PreAggregateStore] Flushed",
+		"aggregates", len(aggregates),
+		"cursor", cursor,
+		"bucket_size", bucketSize,
+		"partitions", from.Range.String(),
+	)
+	return nil
+}
+
+// ReadPartitionCheckpoints returns the checkpoint of every partition in partitions.
+// Partitions that were never flushed report 0.
+func (s *PreAggregateStore) ReadPartitionCheckpoints(
+	ctx context.Context,
+	bucketSize string,
+	partitions partition.Range,
+) (aggregation.PartitionCheckpoints, error) {
+	if bucketSize == "" {
+		bucketSize = defaultBucketSize
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.partitionCheckpoints(bucketSize, partitions), nil
+}
+
+// RepairAggregates replaces the rows of keys with aggregates atomically; keys without an
+// aggregate are deleted. It fails with aggregation.ErrCheckpointMoved unless every
+// partition is still at the checkpoint the replacements were computed at.
+func (s *PreAggregateStore) RepairAggregates(
+	ctx context.Context,
+	bucketSize string,
+	checkpoints map[int]int64,
+	keys []aggregation.AggregateKey,
+	aggregates map[aggregation.AggregateKey]aggregation.AggregateState,
+) error {
+	if bucketSize == "" {
+		bucketSize = defaultBucketSize
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	partitionIDs := make([]int, 0, len(checkpoints))
+	for partitionID := range checkpoints {
+		partitionIDs = append(partitionIDs, partitionID)
+	}
+	sort.Ints(partitionIDs)
+	for _, partitionID := range partitionIDs {
+		durable := s.partitionCheckpoints(bucketSize, partition.Range{From: partitionID, To: partitionID + 1})
+		if durable.Cursors[0] != checkpoints[partitionID] {
+			return fmt.Errorf("pre_aggregate repair: partition %d at %d, verified at %d: %w",
+				partitionID, durable.Cursors[0], checkpoints[partitionID], aggregation.ErrCheckpointMoved)
+		}
+	}
+
+	for _, key := range keys {
+		if _, ok := checkpoints[key.PartitionID]; !ok {
+			return fmt.Errorf("pre_aggregate repair: partition %d of %v was not verified", key.PartitionID, key)
+		}
+	}
+	if err := s.checkRuleFingerprints(aggregates, bucketSize); err != nil {
+		return fmt.Errorf("pre_aggregate repair: %w", err)
+	}
+	if err := checkBucketSizes(aggregates, bucketSize); err != nil {
+		return fmt.Errorf("pre_aggregate repair: %w", err)
+	}
+
+	for _, key := range keys {
+		key = rowKey(key, bucketSize)
+		delete(s.rows[rowScope{principalID: key.PrincipalID, ruleName: key.RuleName, bucketSize: bucketSize}], key)
+	}
+	if err := s.upsertAggregates(aggregates, bucketSize); err != nil {
+		return fmt.Errorf("pre_aggregate repair: %w", err)
+	}
+
+	slog.Info("[PreAggregateStore] Repaired",
+		"rows", len(keys),
+		"replaced", len(aggregates),
+		"bucket_size", bucketSize,
+	)
+	return nil
+}
+
+// partitionCheckpoints returns a copy of the checkpoints of partitions at bucketSize.
+// The caller holds s.mu.
+func (s *PreAggregateStore) partitionCheckpoints(bucketSize string, partitions partition.Range) aggregation.PartitionCheckpoints {
+	checkpoints := aggregation.PartitionCheckpoints{Range: partitions, Cursors: make([]int64, partitions.Len())}
+	if cursors := s.checkpoints[bucketSize]; cursors != nil {
+		copy(checkpoints.Cursors, cursors[partitions.From:partitions.To])
+	}
+	return checkpoints
+}
+
+// writeCheckpoints advances the checkpoint of every partition in partitions to cursor.
+// The caller holds s.mu.
+func (s *PreAggregateStore) writeCheckpoints(bucketSize string, partitions partition.Range, cursor int64) {
+	cursors := s.checkpoints[bucketSize]
+	if cursors == nil {
+		cursors = make([]int64, partition.Count)
+		s.checkpoints[bucketSize] = cursors
+	}
+	for i := partitions.From; i < partitions.To; i++ {
+		cursors[i] = cursor
+	}
+}
+
+// upsertAggregates merges aggregates into the stored rows. Every key is checked before
+// the first row is written. The caller holds s.mu.
+func (s *PreAggregateStore) upsertAggregates(
+	aggregates map[aggregation.AggregateKey]aggregation.AggregateState,
+	bucketSize string,
+) error {
+	if err := checkBucketSizes(aggregates, bucketSize); err != nil {
+		return err
+	}
+
+	for key, state := range aggregates {
+		key = rowKey(key, bucketSize)
+		state.WindowStart = key.WindowStart
+		state.Dimensions = key.Dimensions
+
+		scope := rowScope{principalID: key.PrincipalID, ruleName: key.RuleName, bucketSize: bucketSize}
+		rows := s.rows[scope]
+		if rows == nil {
+			rows = make(map[aggregation.AggregateKey]aggregation.AggregateState)
+			s.rows[scope] = rows
+		}
+		if durable, ok := rows[key]; ok {
+			state = mergeDurable(durable, state)
+		}
+		rows[key] = cloneState(state)
+	}
+	return nil
+}
+
+// checkBucketSizes rejects aggregates keyed with a bucket size other than bucketSize.
+func checkBucketSizes(aggregates map[aggregation.AggregateKey]aggregation.AggregateState, bucketSize string) error {
+	for key := range aggregates {
+		if key.BucketSize != "" && key.BucketSize != bucketSize {
+			return fmt.Errorf("aggregate bucket mismatch: expected %s, got %s for key %v", bucketSize, key.BucketSize, key)
+		}
+	}
+	return nil
+}
+
+// rowKey returns the key of key's stored row: equal windows must be equal map keys,
+// whatever their location.
+func rowKey(key aggregation.AggregateKey, bucketSize string) aggregation.AggregateKey {
+	key.BucketSize = bucketSize
+	key.WindowStart = key.WindowStart.UTC()
+	return key
+}
+
+// mergeDurable merges a flushed partial into the stored state of its row, the way the
+// PostgreSQL upsert merges them: values by operator, counts added, the partial's
+// metadata winning.
+func mergeDurable(durable, partial aggregation.AggregateState) aggregation.AggregateState {
+	merged := partial
+	if agg, ok := aggregation.Operators[partial.Operator]; ok {
+		merged = agg.Merge(durable, partial)
+		merged.Operator = partial.Operator
+	}
+	merged.EventCount = durable.EventCount + partial.EventCount
+	merged.LastEventID = partial.LastEventID
+	merged.RuleFingerprint = partial.RuleFingerprint
+	merged.UpdatedAt = partial.UpdatedAt
+	return merged
+}
+
+// ReadCheckpoint returns the bucket-scoped checkpoint cursor, the lowest checkpoint of
+// its partitions. Returns 0 if no checkpoint exists yet (meaning "replay from beginning").
+func (s *PreAggregateStore) ReadCheckpoint(ctx context.Context, bucketSize string) (int64, error) {
+	if bucketSize == "" {
+		bucketSize = defaultBucketSize
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.partitionCheckpoints(bucketSize, partition.Full()).Min(), nil
+}
+
+// LoadAggregates returns copies of all stored pre-aggregates.
+func (s *PreAggregateStore) LoadAggregates(ctx context.Context) (map[aggregation.AggregateKey]aggregation.AggregateState, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	aggregates := make(map[aggregation.AggregateKey]aggregation.AggregateState)
+	for _, rows := range s.rows {
+		for key, state := range rows {
+			aggregates[key] = cloneState(state)
+		}
+	}
+	return aggregates, nil
+}
+
+// QueryRange fetches pre-aggregates for a time range.
+// Used by projection API to serve usage queries.
+// Returns aggregates ordered by window_start ASC.
+func (s *PreAggregateStore) QueryRange(
+	ctx context.Context,
+	principalID string,
+	ruleName string,
+	bucketSize string,
+	startTime time.Time,
+	endTime time.Time,
+) ([]aggregation.AggregateState, error) {
+	if bucketSize == "" {
+		bucketSize = defaultBucketSize
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.queryRange(rowScope{principalID: principalID, ruleName: ruleName, bucketSize: bucketSize}, startTime, endTime), nil
+}
+
+// QueryRangeWithCheckpoint fetches pre-aggregates and the checkpoint of the principal's
+// partition under one lock, so both come from the same flush.
+func (s *PreAggregateStore) QueryRangeWithCheckpoint(
+	ctx context.Context,
+	principalID string,
+	ruleName string,
+	bucketSize string,
+	startTime time.Time,
+	endTime time.Time,
+) ([]aggregation.AggregateState, int64, error) {
+	if bucketSize == "" {
+		bucketSize = defaultBucketSize
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	results := s.queryRange(rowScope{principalID: principalID, ruleName: ruleName, bucketSize: bucketSize}, startTime, endTime)
+	return results, s.partitionCheckpoint(bucketSize, principalID), nil
+}
+
+// QueryRangesWithCheckpoint is the batch form of QueryRangeWithCheckpoint. The result
+// holds an entry for every principal and rule, empty if the range has no pre-aggregates.
+func (s *PreAggregateStore) QueryRangesWithCheckpoint(
+	ctx context.Context,
+	principalIDs []string,
+	ruleNames []string,
+	bucketSize string,
+	startTime time.Time,
+	endTime time.Time,
+) (map[aggregation.RangeScope]aggregation.ScopedRange, error) {
+	if bucketSize == "" {
+		bucketSize = defaultBucketSize
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	results := make(map[aggregation.RangeScope]aggregation.ScopedRange, len(principalIDs)*len(ruleNames))
+	for _, principalID := range principalIDs {
+		checkpoint := s.partitionCheckpoint(bucketSize, principalID)
+		for _, ruleName := range ruleNames {
+			results[aggregation.RangeScope{PrincipalID: principalID, RuleName: ruleName}] = aggregation.ScopedRange{
+				States:     s.queryRange(rowScope{principalID: principalID, ruleName: ruleName, bucketSize: bucketSize}, startTime, endTime),
+				Checkpoint: checkpoint,
+			}
+		}
+	}
+	return results, nil
+}
+
+// ScanRuleRangeWithCheckpoints passes the pre-aggregates of one rule over a range for
+// every principal to fn, ordered by principal, and returns the checkpoints of all
+// partitions they were read with. fn runs under the store's read lock and must not
+// call back into the store.
+func (s *PreAggregateStore) ScanRuleRangeWithCheckpoints(
+	ctx context.Context,
+	ruleName string,
+	bucketSize string,
+	startTime time.Time,
+	endTime time.Time,
+	fn func(principalID string, state aggregation.AggregateState),
+) (aggregation.PartitionCheckpoints, error) {
+	if bucketSize == "" {
+		bucketSize = defaultBucketSize
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var scopes []rowScope
+	for scope := range s.rows {
+		if scope.ruleName == ruleName && scope.bucketSize == bucketSize {
+			scopes = append(scopes, scope)
+		}
+	}
+	sort.Slice(scopes, func(i, j int) bool { return scopes[i].principalID < scopes[j].principalID })
+	for _, scope := range scopes {
+		for _, state := range s.queryRange(scope, startTime, endTime) {
+			fn(scope.principalID, state)
+		}
+	}
+	return s.partitionCheckpoints(bucketSize, partition.Full()), nil
+}
+
+// partitionCheckpoint returns the checkpoint of principalID's partition. The caller
+// holds s.mu.
+func (s *PreAggregateStore) partitionCheckpoint(bucketSize, principalID string) int64 {
+	if cursors := s.checkpoints[bucketSize]; cursors != nil {
+		return cursors[partition.For(principalID)]
+	}
+	return 0
+}
+
+// queryRange returns copies of the rows of scope with a window_start in
+// [startTime, endTime), ordered by window_start and dimensions. The caller holds s.mu.
+func (s *PreAggregateStore) queryRange(scope rowScope, startTime, endTime time.Time) []aggregation.AggregateState {
+	var results []aggregation.AggregateState
+	for key, state := range s.rows[scope] {
+		if !key.WindowStart.Before(startTime) && key.WindowStart.Before(endTime) {
+			results = append(results, cloneState(state))
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if !results[i].WindowStart.Equal(results[j].WindowStart) {
+			return results[i].WindowStart.Before(results[j].WindowStart)
+		}
+		return results[i].Dimensions < results[j].Dimensions
+	})
+	return results
+}
+
+// cloneState copies state with its sketches, which are the only parts of a state that
+// aggregators modify in place.
+func cloneState(state aggregation.AggregateState) aggregation.AggregateState {
+	if state.Sketch != nil {
+		state.Sketch = state.Sketch.Clone()
+	}
+	if state.Digest != nil {
+		state.Digest = state.Digest.Clone()
+	}
+	return state
+}
@@ -0,0 +1,13 @@
+package memory
+
+import (
+	"testing"
+
+	"github.com/aevon-lab/project-aevon/internal/core/storage/storetest"
+)
+
+func TestPreAggregateStore(t *testing.T) {
+	storetest.RunPreAggregateStore(t, func(t *testing.T) storetest.PreAggregateStore {
+		return NewPreAggregateStore()
+	})
+}
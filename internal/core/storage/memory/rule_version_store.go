@@ -0,0 +1,141 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/aevon-lab/project-aevon/internal/core/aggregation"
+	"github.com/aevon-lab/project-aevon/internal/core/partition"
+)
+
+// ReconcileRuleVersion makes fingerprint the active version of ruleName at bucketSize.
+// If another fingerprint was active, or the rule is new to a bucket whose checkpoint
+// already moved, the rule's pre-aggregates are deleted and a rebuild up to the
+// partition checkpoints is started.
+func (s *PreAggregateStore) ReconcileRuleVersion(
+	ctx context.Context,
+	ruleName string,
+	bucketSize string,
+	fingerprint string,
+) (aggregation.RuleVersion, error) {
+	if bucketSize == "" {
+		bucketSize = defaultBucketSize
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := ruleVersionKey{ruleName: ruleName, bucketSize: bucketSize}
+	active, ok := s.ruleVersions[key]
+	if ok && active.Fingerprint == fingerprint {
+		return active, nil
+	}
+
+	for scope := range s.rows {
+		if scope.ruleName == ruleName && scope.bucketSize == bucketSize {
+			delete(s.rows, scope)
+		}
+	}
+	checkpoints := s.partitionCheckpoints(bucketSize, partition.Full())
+	checkpoint := checkpoints.Max()
+	var partitionTargets []int64
+	if checkpoints.Min() != checkpoint {
+		partitionTargets = checkpoints.Cursors
+	}
+	version := aggregation.RuleVersion{
+		RuleName:         ruleName,
+		BucketSize:       bucketSize,
+		Fingerprint:      fingerprint,
+		RebuildTarget:    checkpoint,
+		PartitionTargets: partitionTargets,
+		UpdatedAt:        time.Now().UTC(),
+	}
+	s.ruleVersions[key] = version
+
+	slog.Info("[PreAggregateStore] Rule version changed",
+		"rule", ruleName,
+		"bucket_size", bucketSize,
+		"previous_fingerprint", active.Fingerprint,
+		"rebuild_target", checkpoint,
+	)
+	return version, nil
+}
+
+// ListRuleVersions returns the versions of ruleName, or of every rule if ruleName is empty,
+// ordered by rule name and bucket size.
+func (s *PreAggregateStore) ListRuleVersions(ctx context.Context, ruleName string) ([]aggregation.RuleVersion, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var versions []aggregation.RuleVersion
+	for key, version := range s.ruleVersions {
+		if ruleName == "" || key.ruleName == ruleName {
+			versions = append(versions, version)
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		if versions[i].RuleName != versions[j].RuleName {
+			return versions[i].RuleName < versions[j].RuleName
+		}
+		return versions[i].BucketSize < versions[j].BucketSize
+	})
+	return versions, nil
+}
+
+// FlushRebuild merges rebuilt aggregates of one rule version and advances its rebuild
+// cursor atomically. Stale cursors are skipped like in Flush.
+func (s *PreAggregateStore) FlushRebuild(
+	ctx context.Context,
+	aggregates map[aggregation.AggregateKey]aggregation.AggregateState,
+	version aggregation.RuleVersion,
+	cursor int64,
+) error {
+	bucketSize := version.BucketSize
+	if bucketSize == "" {
+		bucketSize = defaultBucketSize
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := ruleVersionKey{ruleName: version.RuleName, bucketSize: bucketSize}
+	active, ok := s.ruleVersions[key]
+	if !ok || active.Fingerprint != version.Fingerprint {
+		return fmt.Errorf("rebuild flush: rule %s (bucket=%s): %w", version.RuleName, bucketSize, aggregation.ErrRuleFingerprintMismatch)
+	}
+	if cursor <= active.RebuildCursor {
+		slog.Warn("[PreAggregateStore] Skipping stale/no-op rebuild flush",
+			"rule", version.RuleName,
+			"cursor", cursor,
+			"rebuild_cursor", active.RebuildCursor,
+		)
+		return nil
+	}
+
+	if err := s.upsertAggregates(aggregates, bucketSize); err != nil {
+		return fmt.Errorf("rebuild flush: %w", err)
+	}
+	active.RebuildCursor = cursor
+	active.UpdatedAt = time.Now().UTC()
+	s.ruleVersions[key] = active
+	return nil
+}
+
+// checkRuleFingerprints rejects aggregates computed with a rule definition other than
+// the active rule version, e.g. a batch that started before a rule reload. The caller
+// holds s.mu.
+func (s *PreAggregateStore) checkRuleFingerprints(
+	aggregates map[aggregation.AggregateKey]aggregation.AggregateState,
+	bucketSize string,
+) error {
+	for key, state := range aggregates {
+		active, ok := s.ruleVersions[ruleVersionKey{ruleName: key.RuleName, bucketSize: bucketSize}]
+		if ok && active.Fingerprint != state.RuleFingerprint {
+			return fmt.Errorf("rule %s (bucket=%s): %w", key.RuleName, bucketSize, aggregation.ErrRuleFingerprintMismatch)
+		}
+	}
+	return nil
+}
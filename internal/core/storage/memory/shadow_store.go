@@ -0,0 +1,109 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/aevon-lab/project-aevon/internal/core/aggregation"
+)
+
+// StageShadowAggregates replaces the shadow rows of rebuildID with aggregates.
+func (s *PreAggregateStore) StageShadowAggregates(
+	ctx context.Context,
+	rebuildID string,
+	bucketSize string,
+	aggregates map[aggregation.AggregateKey]aggregation.AggregateState,
+) error {
+	if bucketSize == "" {
+		bucketSize = defaultBucketSize
+	}
+	if err := checkBucketSizes(aggregates, bucketSize); err != nil {
+		return fmt.Errorf("stage shadow aggregates: %w", err)
+	}
+
+	rows := make(map[aggregation.AggregateKey]aggregation.AggregateState, len(aggregates))
+	for key, state := range aggregates {
+		key = rowKey(key, bucketSize)
+		state.WindowStart = key.WindowStart
+		state.Dimensions = key.Dimensions
+		rows[key] = cloneState(state)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.shadows[rebuildID] = rows
+	return nil
+}
+
+// SwapShadowAggregates replaces the live pre-aggregates of swap's range with its shadow
+// rows atomically, unless a flush moved a checkpoint of the bucket size or the rule
+// changed since the shadow rows were computed.
+func (s *PreAggregateStore) SwapShadowAggregates(ctx context.Context, swap aggregation.ShadowSwap) (int64, error) {
+	bucketSize := swap.BucketSize
+	if bucketSize == "" {
+		bucketSize = defaultBucketSize
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	durable := s.partitionCheckpoints(bucketSize, swap.Checkpoints.Range)
+	for i, durableCursor := range durable.Cursors {
+		if durableCursor != swap.Checkpoints.Cursors[i] {
+			return 0, fmt.Errorf("swap shadow aggregates: partition %d at %d, rebuilt up to %d: %w",
+				swap.Checkpoints.Range.From+i, durableCursor, swap.Checkpoints.Cursors[i], aggregation.ErrCheckpointMoved)
+		}
+	}
+
+	// The rule rebuilder merges into the rule's rows while it replays a changed rule.
+	if active, ok := s.ruleVersions[ruleVersionKey{ruleName: swap.RuleName, bucketSize: bucketSize}]; ok {
+		if active.Fingerprint != swap.RuleFingerprint {
+			return 0, fmt.Errorf("swap shadow aggregates: rule %s (bucket=%s): %w",
+				swap.RuleName, bucketSize, aggregation.ErrRuleFingerprintMismatch)
+		}
+		if active.Rebuilding() {
+			return 0, fmt.Errorf("swap shadow aggregates: rule %s (bucket=%s): %w",
+				swap.RuleName, bucketSize, aggregation.ErrRuleRebuilding)
+		}
+	}
+
+	var replaced int64
+	for scope, rows := range s.rows {
+		if scope.ruleName != swap.RuleName || scope.bucketSize != bucketSize {
+			continue
+		}
+		for key := range rows {
+			if !key.WindowStart.Before(swap.Start) && key.WindowStart.Before(swap.End) {
+				delete(rows, key)
+				replaced++
+			}
+		}
+	}
+	for key, state := range s.shadows[swap.RebuildID] {
+		scope := rowScope{principalID: key.PrincipalID, ruleName: key.RuleName, bucketSize: key.BucketSize}
+		rows := s.rows[scope]
+		if rows == nil {
+			rows = make(map[aggregation.AggregateKey]aggregation.AggregateState)
+			s.rows[scope] = rows
+		}
+		rows[key] = state
+	}
+	delete(s.shadows, swap.RebuildID)
+
+	slog.Info("[PreAggregateStore] Swapped shadow aggregates",
+		"rebuild_id", swap.RebuildID,
+		"rule", swap.RuleName,
+		"bucket_size", bucketSize,
+		"replaced", replaced,
+	)
+	return replaced, nil
+}
+
+// DiscardShadowAggregates deletes the shadow rows of rebuildID.
+func (s *PreAggregateStore) DiscardShadowAggregates(ctx context.Context, rebuildID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.shadows, rebuildID)
+	return nil
+}
@@ -0,0 +1,226 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	v1 "github.com/aevon-lab/project-aevon/internal/api/v1"
+	"github.com/aevon-lab/project-aevon/internal/core/partition"
+	"github.com/aevon-lab/project-aevon/internal/core/retention"
+	"github.com/lib/pq"
+)
+
+const (
+	// eventPartitionPrefix names the monthly partitions of events: events_p202610.
+	eventPartitionPrefix = "events_p"
+	eventPartitionLayout = "200601"
+
+	// eventPartitionLockKey serializes partition maintenance of all replicas.
+	eventPartitionLockKey = 0x6165766f6e // "aevon"
+
+	queryLockEventPartitions = `SELECT pg_advisory_xact_lock($1)`
+
+	// queryListEventPartitions lists the partitions attached to events, including the
+	// default partition, which callers skip by name.
+	queryListEventPartitions = `
+		SELECT c.relname
+		FROM pg_inherits i
+		JOIN pg_class c ON c.oid = i.inhrelid
+		WHERE i.inhparent = 'events'::regclass
+		ORDER BY c.relname ASC
+	`
+
+	// queryRetainedCursor returns the highest ingest_seq every configured bucket size has
+	// aggregated and no running rule rebuild still has to replay. A bucket size without a
+	// checkpoint row for every partition has not aggregated anything everywhere.
+	queryRetainedCursor = `
+		SELECT COALESCE(MIN(cursor), 0)
+		FROM (
+			SELECT CASE
+				WHEN COUNT(c.partition_id) < $2 THEN 0
+				ELSE MIN(c.checkpoint_cursor)
+			END AS cursor
+			FROM unnest($1::TEXT[]) AS b(bucket_size)
+			LEFT JOIN sweep_checkpoints c ON c.bucket_size = b.bucket_size
+			GROUP BY b.bucket_size
+			UNION ALL
+			SELECT MIN(rebuild_cursor)
+			FROM rule_versions
+			WHERE rebuild_cursor < rebuild_target
+		) cursors
+	`
+
+	// queryRetrieveEventPartitionAfterCursor reads one month of events through the parent
+	// table, so the ingested_at bounds prune every other partition.
+	queryRetrieveEventPartitionAfterCursor = `
+		SELECT
+			id, principal_id, type, schema_version,
+			occurred_at, ingested_at, metadata, data, ingest_seq
+		FROM events
+		WHERE ingested_at >= $1
+		  AND ingested_at < $2
+		  AND ingest_seq > $3
+		ORDER BY ingest_seq ASC
+		LIMIT $4
+	`
+
+	queryDeleteEventKeys = `
+		DELETE FROM event_keys
+		WHERE ingested_at >= $1
+		  AND ingested_at < $2
+	`
+)
+
+// EventPartitionAdapter maintains the monthly partitions of the events table.
+type EventPartitionAdapter struct {
+	db *sql.DB
+}
+
+// NewEventPartitionAdapter creates a new EventPartitionAdapter sharing the given connection.
+func NewEventPartitionAdapter(db *sql.DB) *EventPartitionAdapter {
+	return &EventPartitionAdapter{db: db}
+}
+
+// ListEventPartitions returns the monthly partitions attached to events, oldest first.
+// The default partition and partitions not named by CreateEventPartition are skipped.
+func (a *EventPartitionAdapter) ListEventPartitions(ctx context.Context) ([]retention.EventPartition, error) {
+	rows, err := a.db.QueryContext(ctx, queryListEventPartitions)
+	if err != nil {
+		return nil, fmt.Errorf("list event partitions: %w", err)
+	}
+	defer rows.Close()
+
+	var partitions []retention.EventPartition
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("scan event partition: %w", err)
+		}
+		if !strings.HasPrefix(name, eventPartitionPrefix) {
+			continue
+		}
+		from, err := time.Parse(eventPartitionLayout, strings.TrimPrefix(name, eventPartitionPrefix))
+		if err != nil {
+			continue
+		}
+		partitions = append(partitions, retention.EventPartition{Name: name, From: from, To: from.AddDate(0, 1, 0)})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate event partitions: %w", err)
+	}
+	return partitions, nil
+}
+
+// CreateEventPartition creates the partition of the UTC month of month unless it exists.
+// It fails if the default partition already holds events of that month.
+func (a *EventPartitionAdapter) CreateEventPartition(ctx context.Context, month time.Time) (retention.EventPartition, error) {
+	p := eventPartitionFor(month)
+
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return p, fmt.Errorf("begin create event partition tx: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	if _, err := tx.ExecContext(ctx, queryLockEventPartitions, eventPartitionLockKey); err != nil {
+		return p, fmt.Errorf("lock event partitions: %w", err)
+	}
+	// DDL takes no bind parameters; the name and bounds are formatted from month.
+	ddl := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s PARTITION OF events FOR VALUES FROM (%s) TO (%s)",
+		pq.QuoteIdentifier(p.Name), pq.QuoteLiteral(p.From.Format(time.RFC3339)), pq.QuoteLiteral(p.To.Format(time.RFC3339)))
+	if _, err := tx.ExecContext(ctx, ddl); err != nil {
+		return p, fmt.Errorf("create event partition %s: %w", p.Name, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return p, fmt.Errorf("commit create event partition tx: %w", err)
+	}
+	return p, nil
+}
+
+// RetrieveEventPartitionAfterCursor fetches the events of p after a cursor (ingest_seq)
+// in strict total order. Used to export a partition to the archive.
+func (a *EventPartitionAdapter) RetrieveEventPartitionAfterCursor(
+	ctx context.Context,
+	p retention.EventPartition,
+	cursor int64,
+	limit int,
+) ([]*v1.Event, error) {
+	rows, err := a.db.QueryContext(ctx, queryRetrieveEventPartitionAfterCursor, p.From, p.To, cursor, limit)
+	if err != nil {
+		return nil, fmt.Errorf("query events of %s: %w", p.Name, err)
+	}
+	defer rows.Close()
+
+	var events []*v1.Event
+	for rows.Next() {
+		event, err := scanEventRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate events of %s: %w", p.Name, err)
+	}
+	return events, nil
+}
+
+// RetireEventPartition detaches p from events, or drops it if drop is set, together with
+// the idempotency keys of its events. It fails with retention.ErrPartitionNotAggregated,
+// changing nothing, unless every event of p is at or before the checkpoint of every
+// bucket size in bucketSizes and the cursor of every running rule rebuild.
+//
+// A detached partition is left as a standalone table for the operator to archive.
+func (a *EventPartitionAdapter) RetireEventPartition(ctx context.Context, p retention.EventPartition, drop bool, bucketSizes []string) error {
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin retire event partition tx: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	if _, err := tx.ExecContext(ctx, queryLockEventPartitions, eventPartitionLockKey); err != nil {
+		return fmt.Errorf("lock event partitions: %w", err)
+	}
+
+	var maxSeq int64
+	if err := tx.QueryRowContext(ctx,
+		fmt.Sprintf("SELECT COALESCE(MAX(ingest_seq), 0) FROM %s", pq.QuoteIdentifier(p.Name)),
+	).Scan(&maxSeq); err != nil {
+		return fmt.Errorf("read last ingest_seq of %s: %w", p.Name, err)
+	}
+	var retained int64
+	if err := tx.QueryRowContext(ctx, queryRetainedCursor, pq.Array(bucketSizes), partition.Count).Scan(&retained); err != nil {
+		return fmt.Errorf("read aggregated cursor: %w", err)
+	}
+	if maxSeq > retained {
+		return fmt.Errorf("%s holds events up to %d, aggregated up to %d: %w", p.Name, maxSeq, retained, retention.ErrPartitionNotAggregated)
+	}
+
+	ddl := fmt.Sprintf("ALTER TABLE events DETACH PARTITION %s", pq.QuoteIdentifier(p.Name))
+	if drop {
+		ddl = fmt.Sprintf("DROP TABLE %s", pq.QuoteIdentifier(p.Name))
+	}
+	if _, err := tx.ExecContext(ctx, ddl); err != nil {
+		return fmt.Errorf("retire event partition %s: %w", p.Name, err)
+	}
+	if _, err := tx.ExecContext(ctx, queryDeleteEventKeys, p.From, p.To); err != nil {
+		return fmt.Errorf("delete event keys of %s: %w", p.Name, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit retire event partition tx: %w", err)
+	}
+	return nil
+}
+
+// eventPartitionFor returns the partition of the UTC month of t.
+func eventPartitionFor(t time.Time) retention.EventPartition {
+	from := retention.MonthStart(t)
+	return retention.EventPartition{
+		Name: eventPartitionPrefix + from.Format(eventPartitionLayout),
+		From: from,
+		To:   from.AddDate(0, 1, 0),
+	}
+}
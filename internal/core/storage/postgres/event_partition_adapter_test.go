@@ -0,0 +1,117 @@
+package postgres
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/aevon-lab/project-aevon/internal/core/retention"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventPartitionAdapter_ListEventPartitions(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta(queryListEventPartitions)).
+		WillReturnRows(sqlmock.NewRows([]string{"relname"}).
+			AddRow("events_default").
+			AddRow("events_p202609").
+			AddRow("events_p202610"))
+
+	partitions, err := NewEventPartitionAdapter(db).ListEventPartitions(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []retention.EventPartition{
+		{Name: "events_p202609", From: time.Date(2026, 9, 1, 0, 0, 0, 0, time.UTC), To: time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)},
+		{Name: "events_p202610", From: time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC), To: time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)},
+	}, partitions)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestEventPartitionAdapter_CreateEventPartition(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(queryLockEventPartitions)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(`CREATE TABLE IF NOT EXISTS "events_p202612" PARTITION OF events FOR VALUES FROM ('2026-12-01T00:00:00Z') TO ('2027-01-01T00:00:00Z')`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	// Months are UTC: half past midnight on January 1st in CET is still December.
+	month := time.Date(2027, 1, 1, 0, 30, 0, 0, time.FixedZone("CET", 3600))
+	p, err := NewEventPartitionAdapter(db).CreateEventPartition(context.Background(), month)
+	require.NoError(t, err)
+	require.Equal(t, "events_p202612", p.Name)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestEventPartitionAdapter_RetrieveEventPartitionAfterCursor(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	p := eventPartitionFor(time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC))
+	ingestedAt := time.Date(2026, 3, 8, 10, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(regexp.QuoteMeta(queryRetrieveEventPartitionAfterCursor)).
+		WithArgs(p.From, p.To, int64(100), 10).
+		WillReturnRows(sqlmock.NewRows(eventRowColumns()).
+			AddRow("evt-101", "user-1", "api.request", 1, ingestedAt, ingestedAt, nil, []byte(`{"count":3}`), int64(101)),
+		).RowsWillBeClosed()
+
+	events, err := NewEventPartitionAdapter(db).RetrieveEventPartitionAfterCursor(context.Background(), p, 100, 10)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.Equal(t, int64(101), events[0].IngestSeq)
+	require.Equal(t, float64(3), events[0].Data["count"])
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestEventPartitionAdapter_RetireEventPartition(t *testing.T) {
+	p := eventPartitionFor(time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC))
+
+	t.Run("drops an aggregated partition and its keys", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		defer db.Close()
+
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(queryLockEventPartitions)).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT COALESCE(MAX(ingest_seq), 0) FROM "events_p202603"`)).
+			WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(int64(40)))
+		mock.ExpectQuery(regexp.QuoteMeta(queryRetainedCursor)).
+			WithArgs(sqlmock.AnyArg(), 256).
+			WillReturnRows(sqlmock.NewRows([]string{"cursor"}).AddRow(int64(40)))
+		mock.ExpectExec(regexp.QuoteMeta(`DROP TABLE "events_p202603"`)).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(regexp.QuoteMeta(queryDeleteEventKeys)).
+			WithArgs(p.From, p.To).
+			WillReturnResult(sqlmock.NewResult(0, 40))
+		mock.ExpectCommit()
+
+		require.NoError(t, NewEventPartitionAdapter(db).RetireEventPartition(context.Background(), p, true, []string{"1m", "1h"}))
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("keeps a partition past a checkpoint", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		defer db.Close()
+
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(queryLockEventPartitions)).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT COALESCE(MAX(ingest_seq), 0) FROM "events_p202603"`)).
+			WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(int64(40)))
+		mock.ExpectQuery(regexp.QuoteMeta(queryRetainedCursor)).
+			WithArgs(sqlmock.AnyArg(), 256).
+			WillReturnRows(sqlmock.NewRows([]string{"cursor"}).AddRow(int64(39)))
+		mock.ExpectRollback()
+
+		err = NewEventPartitionAdapter(db).RetireEventPartition(context.Background(), p, false, []string{"1m"})
+		require.ErrorIs(t, err, retention.ErrPartitionNotAggregated)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -8,11 +8,18 @@ import (
 	"time"
 
 	v1 "github.com/aevon-lab/project-aevon/internal/api/v1"
+	"github.com/aevon-lab/project-aevon/internal/core/partition"
 	"github.com/aevon-lab/project-aevon/internal/core/storage"
-	_ "github.com/lib/pq" // Register postgres driver
+	"github.com/lib/pq" // Also registers the postgres driver
 )
 
-const connectPingTimeout = 5 * time.Second
+const (
+	connectPingTimeout = 5 * time.Second
+
+	// saveEventsChunkSize caps rows per multi-row insert (8 params/row keeps us far
+	// below PostgreSQL's 65535 bind-parameter limit).
+	saveEventsChunkSize = 1000
+)
 
 // Adapter implements storage.EventStore for PostgreSQL.
 type Adapter struct {
@@ -175,6 +182,109 @@ func (a *Adapter) SaveEvent(ctx context.Context, event *v1.Event) error {
 	return nil
 }
 
+// SaveEvents persists a batch of events in one transaction using multi-row inserts.
+// Rows are written in chunks of saveEventsChunkSize to stay under the PostgreSQL
+// bind-parameter limit; all chunks commit or roll back together.
+//
+// The returned slice is index-aligned with events: nil for inserted events (IngestSeq
+// populated), storage.ErrDuplicate for events that already existed or repeat an earlier
+// event in the same batch.
+func (a *Adapter) SaveEvents(ctx context.Context, events []*v1.Event) ([]error, error) {
+	results := make([]error, len(events))
+	if len(events) == 0 {
+		return results, nil
+	}
+
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin save events tx: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	inserted := 0
+	for chunkStart := 0; chunkStart < len(events); chunkStart += saveEventsChunkSize {
+		chunkEnd := minInt(chunkStart+saveEventsChunkSize, len(events))
+		chunk := events[chunkStart:chunkEnd]
+
+		args := make([]interface{}, 0, len(chunk)*saveEventsColumnCount)
+		for _, event := range chunk {
+			metadataJSON, dataJSON, err := marshalEventJSON(event)
+			if err != nil {
+				return nil, fmt.Errorf("event %q: %w", event.ID, err)
+			}
+			args = append(args,
+				event.ID,
+				event.PrincipalID,
+				event.Type,
+				event.SchemaVersion,
+				event.OccurredAt,
+				event.IngestedAt,
+				metadataJSON,
+				dataJSON,
+			)
+		}
+
+		seqs, err := insertEventChunk(ctx, tx, len(chunk), args)
+		if err != nil {
+			return nil, err
+		}
+
+		// The first occurrence of a key in the chunk is the row PostgreSQL inserted;
+		// later repeats and pre-existing keys hit ON CONFLICT DO NOTHING.
+		for i, event := range chunk {
+			key := eventKey{principalID: event.PrincipalID, id: event.ID}
+			seq, ok := seqs[key]
+			if !ok {
+				results[chunkStart+i] = storage.ErrDuplicate
+				continue
+			}
+			delete(seqs, key)
+			event.IngestSeq = seq
+			inserted++
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit save events tx: %w", err)
+	}
+
+	slog.Debug("[Postgres] Saved event batch",
+		"events", len(events),
+		"inserted", inserted,
+		"duplicates", len(events)-inserted)
+	return results, nil
+}
+
+type eventKey struct {
+	principalID string
+	id          string
+}
+
+// insertEventChunk runs one multi-row insert and returns ingest_seq per inserted key.
+func insertEventChunk(ctx context.Context, tx *sql.Tx, rowCount int, args []interface{}) (map[eventKey]int64, error) {
+	rows, err := tx.QueryContext(ctx, buildSaveEventsQuery(rowCount), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save events: %w", err)
+	}
+	defer rows.Close()
+
+	seqs := make(map[eventKey]int64, rowCount)
+	for rows.Next() {
+		var (
+			key eventKey
+			seq int64
+		)
+		if err := rows.Scan(&key.principalID, &key.id, &seq); err != nil {
+			return nil, fmt.Errorf("failed to scan saved event: %w", err)
+		}
+		seqs[key] = seq
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating saved events: %w", err)
+	}
+	return seqs, nil
+}
+
 // RetrieveEventsAfter fetches events ingested after a given timestamp.
 // Returns events ordered by ingested_at ASC (chronological).
 // Used by the aggregation sweeper to process events in batches.
@@ -272,6 +382,57 @@ func (a *Adapter) RetrieveEventsAfterCursor(ctx context.Context, cursor int64, l
 	return events, nil
 }
 
+// RetrievePartitionEventsAfterCursor fetches events of principals in partitions after a
+// cursor (ingest_seq), ordered by ingest_seq ASC. partition_id is derived from
+// principal_id by the database, matching partition.For.
+func (a *Adapter) RetrievePartitionEventsAfterCursor(
+	ctx context.Context,
+	cursor int64,
+	partitions partition.Range,
+	limit int,
+) ([]*v1.Event, error) {
+	rows, err := a.db.QueryContext(ctx, queryRetrievePartitionEventsAfterCursor, cursor, partitions.From, partitions.To, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query partition events by cursor: %w", err)
+	}
+	defer rows.Close()
+
+	var events []*v1.Event
+	for rows.Next() {
+		event, err := scanEventRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating events: %w", err)
+	}
+
+	return events, nil
+}
+
+// FirstIngestSeqAfter returns the lowest ingest_seq of the principal's events ingested
+// after t, or 0 if there is none.
+func (a *Adapter) FirstIngestSeqAfter(ctx context.Context, principalID string, t time.Time) (int64, error) {
+	var seq int64
+	if err := a.db.QueryRowContext(ctx, queryFirstIngestSeqAfter, principalID, t).Scan(&seq); err != nil {
+		return 0, fmt.Errorf("failed to query first ingest_seq after %s: %w", t.Format(time.RFC3339), err)
+	}
+	return seq, nil
+}
+
+// FirstTypeIngestSeqAfter returns the lowest ingest_seq of the events of eventType
+// ingested after t, or 0 if there is none.
+func (a *Adapter) FirstTypeIngestSeqAfter(ctx context.Context, eventType string, t time.Time) (int64, error) {
+	var seq int64
+	if err := a.db.QueryRowContext(ctx, queryFirstTypeIngestSeqAfter, eventType, t).Scan(&seq); err != nil {
+		return 0, fmt.Errorf("failed to query first %s ingest_seq after %s: %w", eventType, t.Format(time.RFC3339), err)
+	}
+	return seq, nil
+}
+
 // RetrieveScopedEventsAfterCursor fetches events in strict order for one projection query scope.
 func (a *Adapter) RetrieveScopedEventsAfterCursor(
 	ctx context.Context,
@@ -280,19 +441,66 @@ func (a *Adapter) RetrieveScopedEventsAfterCursor(
 	eventType string,
 	startOccurredAt time.Time,
 	endOccurredAt time.Time,
+	includeIngested bool,
+	limit int,
+) ([]*v1.Event, error) {
+	args := []interface{}{cursor, principalID, eventType, startOccurredAt, endOccurredAt, limit}
+	var rows *sql.Rows
+	var err error
+	if includeIngested {
+		rows, err = a.db.QueryContext(ctx, queryRetrieveScopedEventsAfterCursorWithIngested, args...)
+	} else {
+		rows, err = a.stmtRetrieveScopedCursor.QueryContext(ctx, args...)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query scoped events by cursor: %w", err)
+	}
+	defer rows.Close()
+
+	var events []*v1.Event
+	for rows.Next() {
+		event, scanErr := scanEventRow(rows)
+		if scanErr != nil {
+			return nil, scanErr
+		}
+		events = append(events, event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating scoped events: %w", err)
+	}
+
+	return events, nil
+}
+
+// RetrieveBatchScopedEventsAfterCursor fetches events of several principals in strict
+// order for one batch projection query scope.
+func (a *Adapter) RetrieveBatchScopedEventsAfterCursor(
+	ctx context.Context,
+	cursor int64,
+	principalIDs []string,
+	eventType string,
+	startOccurredAt time.Time,
+	endOccurredAt time.Time,
+	includeIngested bool,
 	limit int,
 ) ([]*v1.Event, error) {
-	rows, err := a.stmtRetrieveScopedCursor.QueryContext(
+	query := queryRetrieveBatchScopedEventsAfterCursor
+	if includeIngested {
+		query = queryRetrieveBatchScopedEventsAfterCursorWithIngested
+	}
+	rows, err := a.db.QueryContext(
 		ctx,
+		query,
 		cursor,
-		principalID,
+		pq.Array(principalIDs),
 		eventType,
 		startOccurredAt,
 		endOccurredAt,
 		limit,
 	)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query scoped events by cursor: %w", err)
+		return nil, fmt.Errorf("failed to query batch scoped events by cursor: %w", err)
 	}
 	defer rows.Close()
 
@@ -306,7 +514,52 @@ func (a *Adapter) RetrieveScopedEventsAfterCursor(
 	}
 
 	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating scoped events: %w", err)
+		return nil, fmt.Errorf("error iterating batch scoped events: %w", err)
+	}
+
+	return events, nil
+}
+
+// RetrieveTypeScopedEventsAfterCursor fetches events of one type of every principal in
+// strict order for a leaderboard query scope.
+func (a *Adapter) RetrieveTypeScopedEventsAfterCursor(
+	ctx context.Context,
+	cursor int64,
+	eventType string,
+	startOccurredAt time.Time,
+	endOccurredAt time.Time,
+	includeIngested bool,
+	limit int,
+) ([]*v1.Event, error) {
+	query := queryRetrieveTypeScopedEventsAfterCursor
+	if includeIngested {
+		query = queryRetrieveTypeScopedEventsAfterCursorWithIngested
+	}
+	rows, err := a.db.QueryContext(
+		ctx,
+		query,
+		cursor,
+		eventType,
+		startOccurredAt,
+		endOccurredAt,
+		limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query type scoped events by cursor: %w", err)
+	}
+	defer rows.Close()
+
+	var events []*v1.Event
+	for rows.Next() {
+		event, scanErr := scanEventRow(rows)
+		if scanErr != nil {
+			return nil, scanErr
+		}
+		events = append(events, event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating type scoped events: %w", err)
 	}
 
 	return events, nil
@@ -354,3 +607,10 @@ func (a *Adapter) Close() error {
 	slog.Info("[Postgres] Adapter closed gracefully")
 	return nil
 }
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/DATA-DOG/go-sqlmock"
 	v1 "github.com/aevon-lab/project-aevon/internal/api/v1"
 	"github.com/aevon-lab/project-aevon/internal/core/storage"
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/require"
 )
 
@@ -126,6 +127,72 @@ func TestAdapter_SaveEvent(t *testing.T) {
 	}
 }
 
+func TestAdapter_SaveEvents(t *testing.T) {
+	now := time.Date(2026, 2, 8, 12, 0, 0, 0, time.UTC)
+
+	newEvent := func(id string) *v1.Event {
+		return &v1.Event{
+			ID:            id,
+			PrincipalID:   "user-1",
+			Type:          "api.request",
+			SchemaVersion: 1,
+			OccurredAt:    now,
+			IngestedAt:    now,
+			Data:          map[string]interface{}{"count": 1},
+		}
+	}
+
+	t.Run("maps returned rows back to events and marks the rest duplicate", func(t *testing.T) {
+		adapter, mock, db := newMockAdapter(t)
+		defer db.Close()
+
+		// evt-1 is new, evt-2 already exists, third entry repeats evt-1 within the batch.
+		events := []*v1.Event{newEvent("evt-1"), newEvent("evt-2"), newEvent("evt-1")}
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta(buildSaveEventsQuery(3))).
+			WillReturnRows(sqlmock.NewRows([]string{"principal_id", "id", "ingest_seq"}).
+				AddRow("user-1", "evt-1", int64(7)))
+		mock.ExpectCommit()
+
+		results, err := adapter.SaveEvents(context.Background(), events)
+		require.NoError(t, err)
+		require.Len(t, results, 3)
+		require.NoError(t, results[0])
+		require.ErrorIs(t, results[1], storage.ErrDuplicate)
+		require.ErrorIs(t, results[2], storage.ErrDuplicate)
+		require.Equal(t, int64(7), events[0].IngestSeq)
+		require.Equal(t, int64(0), events[2].IngestSeq)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("insert failure rolls back the whole batch", func(t *testing.T) {
+		adapter, mock, db := newMockAdapter(t)
+		defer db.Close()
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta(buildSaveEventsQuery(1))).
+			WillReturnError(errors.New("db down"))
+		mock.ExpectRollback()
+
+		results, err := adapter.SaveEvents(context.Background(), []*v1.Event{newEvent("evt-1")})
+		require.Error(t, err)
+		require.ErrorContains(t, err, "failed to save events")
+		require.Nil(t, results)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("empty batch is a no-op", func(t *testing.T) {
+		adapter, mock, db := newMockAdapter(t)
+		defer db.Close()
+
+		results, err := adapter.SaveEvents(context.Background(), nil)
+		require.NoError(t, err)
+		require.Empty(t, results)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
 func TestAdapter_RetrieveEventsAfterCursor(t *testing.T) {
 	adapter, mock, db := newMockAdapter(t)
 	defer db.Close()
@@ -253,6 +320,7 @@ func TestAdapter_RetrieveScopedEventsAfterCursor(t *testing.T) {
 		"api.request",
 		start,
 		end,
+		false,
 		5000,
 	)
 	require.NoError(t, err)
@@ -264,6 +332,105 @@ func TestAdapter_RetrieveScopedEventsAfterCursor(t *testing.T) {
 	require.NoError(t, mock.ExpectationsWereMet())
 }
 
+func TestAdapter_RetrieveScopedEventsAfterCursor_IncludesIngested(t *testing.T) {
+	adapter, mock, db := newMockAdapter(t)
+	defer db.Close()
+
+	start := time.Date(2026, 2, 8, 0, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+
+	// Only rules bucketing late events by ingestion time read the ingested_at branch.
+	require.NotContains(t, queryRetrieveScopedEventsAfterCursor, "ingested_at >=")
+	require.Contains(t, queryRetrieveScopedEventsAfterCursorWithIngested,
+		"OR (ingested_at >= $4 AND ingested_at < $5)")
+
+	mock.ExpectQuery(regexp.QuoteMeta(queryRetrieveScopedEventsAfterCursorWithIngested)).
+		WithArgs(int64(42), "user-1", "api.request", start, end, 5000).
+		WillReturnRows(sqlmock.NewRows(eventRowColumns()).
+			AddRow("evt-43", "user-1", "api.request", 1, start.Add(-24*time.Hour), start.Add(time.Minute), []byte(`{}`), []byte(`{"count":1}`), int64(43)),
+		).RowsWillBeClosed()
+
+	events, err := adapter.RetrieveScopedEventsAfterCursor(context.Background(), 42, "user-1", "api.request", start, end, true, 5000)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.Equal(t, "evt-43", events[0].ID)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAdapter_RetrieveBatchScopedEventsAfterCursor(t *testing.T) {
+	adapter, mock, db := newMockAdapter(t)
+	defer db.Close()
+
+	start := time.Date(2026, 2, 8, 0, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+	principals := []string{"user-1", "user-2"}
+
+	mock.ExpectQuery(regexp.QuoteMeta(queryRetrieveBatchScopedEventsAfterCursor)).
+		WithArgs(int64(42), pq.Array(principals), "api.request", start, end, 5000).
+		WillReturnRows(sqlmock.NewRows(eventRowColumns()).
+			AddRow("evt-43", "user-2", "api.request", 1, start, start.Add(time.Second), []byte(`{}`), []byte(`{"count":1}`), int64(43)).
+			AddRow("evt-44", "user-1", "api.request", 1, start, start.Add(time.Second), []byte(`{}`), []byte(`{"count":2}`), int64(44)),
+		).RowsWillBeClosed()
+
+	events, err := adapter.RetrieveBatchScopedEventsAfterCursor(context.Background(), 42, principals, "api.request", start, end, false, 5000)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	require.Equal(t, "user-2", events[0].PrincipalID)
+	require.Equal(t, int64(44), events[1].IngestSeq)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAdapter_RetrieveTypeScopedEventsAfterCursor(t *testing.T) {
+	adapter, mock, db := newMockAdapter(t)
+	defer db.Close()
+
+	start := time.Date(2026, 2, 8, 0, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+
+	mock.ExpectQuery(regexp.QuoteMeta(queryRetrieveTypeScopedEventsAfterCursor)).
+		WithArgs(int64(7), "api.request", start, end, 5000).
+		WillReturnRows(sqlmock.NewRows(eventRowColumns()).
+			AddRow("evt-8", "user-9", "api.request", 1, start, start.Add(time.Second), []byte(`{}`), []byte(`{"count":1}`), int64(8)),
+		).RowsWillBeClosed()
+
+	events, err := adapter.RetrieveTypeScopedEventsAfterCursor(context.Background(), 7, "api.request", start, end, false, 5000)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.Equal(t, "user-9", events[0].PrincipalID)
+	require.Equal(t, int64(8), events[0].IngestSeq)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAdapter_FirstIngestSeqAfter(t *testing.T) {
+	adapter, mock, db := newMockAdapter(t)
+	defer db.Close()
+
+	asOf := time.Date(2026, 2, 8, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(regexp.QuoteMeta(queryFirstIngestSeqAfter)).
+		WithArgs("user-1", asOf).
+		WillReturnRows(sqlmock.NewRows([]string{"coalesce"}).AddRow(int64(57)))
+
+	seq, err := adapter.FirstIngestSeqAfter(context.Background(), "user-1", asOf)
+	require.NoError(t, err)
+	require.Equal(t, int64(57), seq)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAdapter_FirstTypeIngestSeqAfter(t *testing.T) {
+	adapter, mock, db := newMockAdapter(t)
+	defer db.Close()
+
+	edge := time.Date(2026, 2, 8, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(regexp.QuoteMeta(queryFirstTypeIngestSeqAfter)).
+		WithArgs("api.request", edge).
+		WillReturnRows(sqlmock.NewRows([]string{"coalesce"}).AddRow(int64(91)))
+
+	seq, err := adapter.FirstTypeIngestSeqAfter(context.Background(), "api.request", edge)
+	require.NoError(t, err)
+	require.Equal(t, int64(91), seq)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
 func TestAdapter_CloseReturnsDBCloseError(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	require.NoError(t, err)
@@ -3,8 +3,10 @@ package postgres
 import (
 	"encoding/json"
 	"fmt"
+	"time"
 
 	v1 "github.com/aevon-lab/project-aevon/internal/api/v1"
+	"github.com/aevon-lab/project-aevon/internal/core/aggregation"
 )
 
 // marshalEventJSON marshals an event's metadata and data fields to JSON.
@@ -65,3 +67,62 @@ func scanEventRow(row scanner) (*v1.Event, error) {
 
 	return &evt, nil
 }
+
+// nullTime maps a zero time to SQL NULL so optional timestamp columns stay NULL
+// instead of storing 0001-01-01.
+func nullTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
+
+// hasSketchState reports whether op keeps its state in the sketch column
+// (count_distinct: HLL, quantile: DDSketch).
+func hasSketchState(op string) bool {
+	return op == aggregation.OpCountDistinct || op == aggregation.OpQuantile
+}
+
+// marshalSketch encodes the sketch of a count_distinct or quantile state;
+// states without a sketch stay SQL NULL.
+func marshalSketch(state aggregation.AggregateState) (interface{}, error) {
+	var (
+		data []byte
+		err  error
+	)
+	switch {
+	case state.Sketch != nil:
+		data, err = state.Sketch.MarshalBinary()
+	case state.Digest != nil:
+		data, err = state.Digest.MarshalBinary()
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal sketch: %w", err)
+	}
+	return data, nil
+}
+
+// unmarshalSketch decodes the sketch column into state according to state.Operator.
+// NULL (empty) leaves the state without a sketch.
+func unmarshalSketch(state *aggregation.AggregateState, data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	switch state.Operator {
+	case aggregation.OpCountDistinct:
+		var sketch aggregation.Sketch
+		if err := sketch.UnmarshalBinary(data); err != nil {
+			return fmt.Errorf("failed to unmarshal sketch: %w", err)
+		}
+		state.Sketch = &sketch
+	case aggregation.OpQuantile:
+		var digest aggregation.QuantileSketch
+		if err := digest.UnmarshalBinary(data); err != nil {
+			return fmt.Errorf("failed to unmarshal quantile sketch: %w", err)
+		}
+		state.Digest = &digest
+	}
+	return nil
+}
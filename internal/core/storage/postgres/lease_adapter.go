@@ -0,0 +1,98 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/aevon-lab/project-aevon/internal/core/aggregation"
+)
+
+const (
+	// queryAcquireLease takes a free or expired lease, or renews one the holder already owns.
+	// Expiry uses the database clock so replicas with skewed clocks agree on it.
+	// No row is returned when another holder owns an unexpired lease.
+	queryAcquireLease = `
+		INSERT INTO aggregation_leases (lease_name, holder, acquired_at, renewed_at, expires_at)
+		VALUES ($1, $2, NOW(), NOW(), NOW() + make_interval(secs => $3))
+		ON CONFLICT (lease_name)
+		DO UPDATE SET
+			holder      = EXCLUDED.holder,
+			acquired_at = CASE
+				WHEN aggregation_leases.holder = EXCLUDED.holder THEN aggregation_leases.acquired_at
+				ELSE EXCLUDED.acquired_at
+			END,
+			renewed_at  = EXCLUDED.renewed_at,
+			expires_at  = EXCLUDED.expires_at
+		WHERE aggregation_leases.holder = EXCLUDED.holder
+		   OR aggregation_leases.expires_at < NOW()
+		RETURNING holder
+	`
+
+	queryReleaseLease = `
+		DELETE FROM aggregation_leases
+		WHERE lease_name = $1
+		  AND holder = $2
+	`
+
+	// queryListLeases reports expiry on the database clock, like queryAcquireLease.
+	queryListLeases = `
+		SELECT lease_name, holder, acquired_at, renewed_at, expires_at, expires_at > NOW()
+		FROM aggregation_leases
+		ORDER BY lease_name ASC
+	`
+)
+
+// LeaseAdapter implements aggregation.LeaseStore using PostgreSQL.
+type LeaseAdapter struct {
+	db *sql.DB
+}
+
+// NewLeaseAdapter creates a new LeaseAdapter sharing the given connection.
+func NewLeaseAdapter(db *sql.DB) *LeaseAdapter {
+	return &LeaseAdapter{db: db}
+}
+
+// TryAcquireLease takes or renews the lease for holder and reports whether holder owns it.
+func (a *LeaseAdapter) TryAcquireLease(ctx context.Context, name string, holder string, ttl time.Duration) (bool, error) {
+	var owner string
+	err := a.db.QueryRowContext(ctx, queryAcquireLease, name, holder, ttl.Seconds()).Scan(&owner)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("acquire lease %s: %w", name, err)
+	}
+	return owner == holder, nil
+}
+
+// ReleaseLease deletes the lease if holder still owns it.
+func (a *LeaseAdapter) ReleaseLease(ctx context.Context, name string, holder string) error {
+	if _, err := a.db.ExecContext(ctx, queryReleaseLease, name, holder); err != nil {
+		return fmt.Errorf("release lease %s: %w", name, err)
+	}
+	return nil
+}
+
+// ListLeases returns every lease ordered by name, marking those unexpired on the database clock as active.
+func (a *LeaseAdapter) ListLeases(ctx context.Context) ([]aggregation.Lease, error) {
+	rows, err := a.db.QueryContext(ctx, queryListLeases)
+	if err != nil {
+		return nil, fmt.Errorf("list leases: %w", err)
+	}
+	defer rows.Close()
+
+	var leases []aggregation.Lease
+	for rows.Next() {
+		var l aggregation.Lease
+		if err := rows.Scan(&l.Name, &l.Holder, &l.AcquiredAt, &l.RenewedAt, &l.ExpiresAt, &l.Active); err != nil {
+			return nil, fmt.Errorf("list leases: scan row: %w", err)
+		}
+		leases = append(leases, l)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list leases: iterate rows: %w", err)
+	}
+	return leases, nil
+}
@@ -0,0 +1,58 @@
+package postgres
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLeaseAdapter_TryAcquireLease(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	adapter := NewLeaseAdapter(db)
+
+	mock.ExpectQuery(regexp.QuoteMeta(queryAcquireLease)).
+		WithArgs("scheduler/1m", "replica-a", float64(30)).
+		WillReturnRows(sqlmock.NewRows([]string{"holder"}).AddRow("replica-a"))
+	acquired, err := adapter.TryAcquireLease(context.Background(), "scheduler/1m", "replica-a", 30*time.Second)
+	require.NoError(t, err)
+	require.True(t, acquired)
+
+	// Another replica holds an unexpired lease: the conditional upsert returns no row.
+	mock.ExpectQuery(regexp.QuoteMeta(queryAcquireLease)).
+		WithArgs("scheduler/1m", "replica-b", float64(30)).
+		WillReturnRows(sqlmock.NewRows([]string{"holder"}))
+	acquired, err = adapter.TryAcquireLease(context.Background(), "scheduler/1m", "replica-b", 30*time.Second)
+	require.NoError(t, err)
+	require.False(t, acquired)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestLeaseAdapter_ListLeases(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	adapter := NewLeaseAdapter(db)
+
+	// Expiry is decided by the database: an expires_at ahead of the local clock can still be expired.
+	expiresAt := time.Now().Add(time.Hour)
+	mock.ExpectQuery(regexp.QuoteMeta(queryListLeases)).
+		WillReturnRows(sqlmock.NewRows([]string{"lease_name", "holder", "acquired_at", "renewed_at", "expires_at", "active"}).
+			AddRow("scheduler/1m", "replica-a", expiresAt, expiresAt, expiresAt, false).
+			AddRow("scheduler/1h", "replica-b", expiresAt, expiresAt, expiresAt, true))
+	leases, err := adapter.ListLeases(context.Background())
+	require.NoError(t, err)
+	require.Len(t, leases, 2)
+	require.False(t, leases[0].Active)
+	require.True(t, leases[1].Active)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
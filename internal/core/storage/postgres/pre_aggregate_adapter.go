@@ -5,68 +5,152 @@ import (
 	"database/sql"
 	"fmt"
 	"log/slog"
+	"sort"
 	"time"
 
 	"github.com/aevon-lab/project-aevon/internal/core/aggregation"
+	"github.com/aevon-lab/project-aevon/internal/core/partition"
+	"github.com/lib/pq"
 	"github.com/shopspring/decimal"
 )
 
 const (
 	defaultBucketSize = "1m"
 
+	// querySelectCheckpointForUpdate locks the checkpoint rows of a partition range.
+	// Rows are locked in partition order so concurrent flushes cannot deadlock.
 	querySelectCheckpointForUpdate = `
-		SELECT checkpoint_cursor
+		SELECT partition_id, checkpoint_cursor
 		FROM sweep_checkpoints
 		WHERE bucket_size = $1
+		  AND partition_id >= $2
+		  AND partition_id < $3
+		ORDER BY partition_id ASC
 		FOR UPDATE
 	`
 
-	queryInitCheckpointRow = `
-		INSERT INTO sweep_checkpoints (bucket_size, checkpoint_cursor, updated_at)
-		VALUES ($1, 0, $2)
-		ON CONFLICT (bucket_size) DO NOTHING
+	// queryInitCheckpointRows creates the checkpoint row of every partition of a bucket size.
+	queryInitCheckpointRows = `
+		INSERT INTO sweep_checkpoints (bucket_size, partition_id, checkpoint_cursor, updated_at)
+		SELECT $1, p, 0, $2
+		FROM generate_series(0, $3::INT - 1) AS p
+		ON CONFLICT (bucket_size, partition_id) DO NOTHING
 	`
 
+	// queryUpsertPreAggregate merges a flushed partial into the durable row.
+	// SET expressions all read the pre-update row, so avg recomputes the mean from
+	// the combined aux_sum/event_count, rounded to the same scale as the Go aggregator.
+	// first/last keep the value whose value_at wins (ties go to the newer flush for last).
+	// count_distinct and quantile sketches cannot be merged in SQL; Flush merges them with
+	// the durable row first (see mergeDurableSketch), so for sketch operators the flushed
+	// sketch and value already cover the durable row and the ELSE branch takes them as-is.
 	queryUpsertPreAggregate = `
 		INSERT INTO pre_aggregates (
 			partition_id, principal_id, rule_name, rule_fingerprint,
-			bucket_size, window_start, operator, value, event_count, last_event_id, updated_at
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
-		ON CONFLICT (partition_id, principal_id, rule_name, bucket_size, window_start)
+			bucket_size, window_start, dimensions, operator, value, aux_sum, value_at, sketch,
+			event_count, last_event_id, updated_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+		ON CONFLICT (partition_id, principal_id, rule_name, bucket_size, window_start, dimensions)
 		DO UPDATE SET
 			value = CASE EXCLUDED.operator
 				WHEN 'count' THEN pre_aggregates.value + EXCLUDED.value
 				WHEN 'sum' THEN pre_aggregates.value + EXCLUDED.value
 				WHEN 'min' THEN LEAST(pre_aggregates.value, EXCLUDED.value)
 				WHEN 'max' THEN GREATEST(pre_aggregates.value, EXCLUDED.value)
+				WHEN 'avg' THEN ROUND(
+					(pre_aggregates.aux_sum + EXCLUDED.aux_sum)
+						/ NULLIF(pre_aggregates.event_count + EXCLUDED.event_count, 0),
+					16
+				)
+				WHEN 'first' THEN CASE
+					WHEN EXCLUDED.value_at < pre_aggregates.value_at THEN EXCLUDED.value
+					ELSE pre_aggregates.value
+				END
+				WHEN 'last' THEN CASE
+					WHEN EXCLUDED.value_at >= pre_aggregates.value_at THEN EXCLUDED.value
+					ELSE pre_aggregates.value
+				END
 				ELSE EXCLUDED.value
 			END,
+			aux_sum = pre_aggregates.aux_sum + EXCLUDED.aux_sum,
+			value_at = CASE EXCLUDED.operator
+				WHEN 'first' THEN LEAST(pre_aggregates.value_at, EXCLUDED.value_at)
+				WHEN 'last' THEN GREATEST(pre_aggregates.value_at, EXCLUDED.value_at)
+				ELSE EXCLUDED.value_at
+			END,
+			sketch           = EXCLUDED.sketch,
 			event_count      = pre_aggregates.event_count + EXCLUDED.event_count,
 			last_event_id    = EXCLUDED.last_event_id,
 			rule_fingerprint = EXCLUDED.rule_fingerprint,
 			updated_at       = EXCLUDED.updated_at
 	`
 
+	querySelectSketchForUpdate = `
+		SELECT sketch
+		FROM pre_aggregates
+		WHERE partition_id = $1
+		  AND principal_id = $2
+		  AND rule_name = $3
+		  AND bucket_size = $4
+		  AND window_start = $5
+		  AND dimensions = $6
+		FOR UPDATE
+	`
+
+	queryDeletePreAggregate = `
+		DELETE FROM pre_aggregates
+		WHERE partition_id = $1
+		  AND principal_id = $2
+		  AND rule_name = $3
+		  AND bucket_size = $4
+		  AND window_start = $5
+		  AND dimensions = $6
+	`
+
+	// queryUpdateCheckpoint never moves a partition backwards: after a re-split, some
+	// partitions of a range may already be ahead of the range's batch.
 	queryUpdateCheckpoint = `
 		UPDATE sweep_checkpoints
-		SET checkpoint_cursor = $1, updated_at = $2
+		SET checkpoint_cursor = GREATEST(checkpoint_cursor, $1), updated_at = $2
 		WHERE bucket_size = $3
+		  AND partition_id >= $4
+		  AND partition_id < $5
 	`
 
-	queryReadCheckpoint = `SELECT checkpoint_cursor FROM sweep_checkpoints WHERE bucket_size = $1`
+	// queryReadCheckpoint returns the lowest partition checkpoint: every event up to it is
+	// aggregated for all partitions.
+	queryReadCheckpoint = `
+		SELECT COALESCE(MIN(checkpoint_cursor), 0)
+		FROM sweep_checkpoints
+		WHERE bucket_size = $1
+	`
+
+	queryReadPartitionCheckpoints = `
+		SELECT partition_id, checkpoint_cursor
+		FROM sweep_checkpoints
+		WHERE bucket_size = $1
+		  AND partition_id >= $2
+		  AND partition_id < $3
+		ORDER BY partition_id ASC
+	`
 
 	queryLoadAggregates = `
 		SELECT
 			partition_id, principal_id, rule_name, rule_fingerprint,
-			bucket_size, window_start, operator, value, event_count, last_event_id, updated_at
+			bucket_size, window_start, dimensions, operator, value, aux_sum, value_at, sketch,
+			event_count, last_event_id, updated_at
 		FROM pre_aggregates
 	`
 
 	queryRangePreAggregates = `
 		SELECT
 			window_start,
+			dimensions,
 			operator,
 			value,
+			aux_sum,
+			value_at,
+			sketch,
 			event_count,
 			last_event_id,
 			rule_fingerprint,
@@ -78,21 +162,25 @@ const (
 		  AND bucket_size = $4
 		  AND window_start >= $5
 		  AND window_start < $6
-		ORDER BY window_start ASC
+		ORDER BY window_start ASC, dimensions ASC
 	`
 
 	queryRangePreAggregatesWithCheckpoint = `
 		WITH checkpoint AS (
 			SELECT COALESCE(
-				(SELECT checkpoint_cursor FROM sweep_checkpoints WHERE bucket_size = $4),
+				(SELECT checkpoint_cursor FROM sweep_checkpoints WHERE bucket_size = $4 AND partition_id = $1),
 				0
 			) AS checkpoint_cursor
 		),
 		scoped AS (
 			SELECT
 				window_start,
+				dimensions,
 				operator,
 				value,
+				aux_sum,
+				value_at,
+				sketch,
 				event_count,
 				last_event_id,
 				rule_fingerprint,
@@ -108,18 +196,219 @@ const (
 		SELECT
 			checkpoint.checkpoint_cursor,
 			scoped.window_start,
+			scoped.dimensions,
 			scoped.operator,
 			scoped.value,
+			scoped.aux_sum,
+			scoped.value_at,
+			scoped.sketch,
 			scoped.event_count,
 			scoped.last_event_id,
 			scoped.rule_fingerprint,
 			scoped.updated_at
 		FROM checkpoint
 		LEFT JOIN scoped ON TRUE
-		ORDER BY scoped.window_start ASC NULLS LAST
+		ORDER BY scoped.window_start ASC NULLS LAST, scoped.dimensions ASC
+	`
+
+	// queryRangesPreAggregatesWithCheckpoint is the batch form of
+	// queryRangePreAggregatesWithCheckpoint: it returns one checkpoint row per partition
+	// in $2, joined with the pre-aggregates of every principal in $3 and rule in $4.
+	queryRangesPreAggregatesWithCheckpoint = `
+		WITH checkpoints AS (
+			SELECT
+				p.partition_id,
+				COALESCE(c.checkpoint_cursor, 0) AS checkpoint_cursor
+			FROM unnest($2::int[]) AS p(partition_id)
+			LEFT JOIN sweep_checkpoints c
+			  ON c.bucket_size = $1
+			 AND c.partition_id = p.partition_id
+		),
+		scoped AS (
+			SELECT
+				partition_id,
+				principal_id,
+				rule_name,
+				window_start,
+				dimensions,
+				operator,
+				value,
+				aux_sum,
+				value_at,
+				sketch,
+				event_count,
+				last_event_id,
+				rule_fingerprint,
+				updated_at
+			FROM pre_aggregates
+			WHERE partition_id = ANY($2::int[])
+			  AND principal_id = ANY($3::text[])
+			  AND rule_name = ANY($4::text[])
+			  AND bucket_size = $1
+			  AND window_start >= $5
+			  AND window_start < $6
+		)
+		SELECT
+			checkpoints.partition_id,
+			checkpoints.checkpoint_cursor,
+			scoped.principal_id,
+			scoped.rule_name,
+			scoped.window_start,
+			scoped.dimensions,
+			scoped.operator,
+			scoped.value,
+			scoped.aux_sum,
+			scoped.value_at,
+			scoped.sketch,
+			scoped.event_count,
+			scoped.last_event_id,
+			scoped.rule_fingerprint,
+			scoped.updated_at
+		FROM checkpoints
+		LEFT JOIN scoped ON scoped.partition_id = checkpoints.partition_id
+		ORDER BY checkpoints.partition_id ASC, scoped.principal_id ASC, scoped.rule_name ASC,
+			scoped.window_start ASC NULLS LAST, scoped.dimensions ASC
+	`
+
+	// queryRulePreAggregatesWithCheckpoints reads one rule's pre-aggregates of every
+	// principal together with the checkpoint of every partition ($5 partitions).
+	// Served by idx_pre_aggregates_rule_window.
+	queryRulePreAggregatesWithCheckpoints = `
+		WITH checkpoints AS (
+			SELECT
+				p.partition_id,
+				COALESCE(c.checkpoint_cursor, 0) AS checkpoint_cursor
+			FROM generate_series(0, $5::INT - 1) AS p(partition_id)
+			LEFT JOIN sweep_checkpoints c
+			  ON c.bucket_size = $2
+			 AND c.partition_id = p.partition_id
+		),
+		scoped AS (
+			SELECT
+				partition_id,
+				principal_id,
+				window_start,
+				dimensions,
+				operator,
+				value,
+				aux_sum,
+				value_at,
+				sketch,
+				event_count,
+				last_event_id,
+				rule_fingerprint,
+				updated_at
+			FROM pre_aggregates
+			WHERE rule_name = $1
+			  AND bucket_size = $2
+			  AND window_start >= $3
+			  AND window_start < $4
+		)
+		SELECT
+			checkpoints.partition_id,
+			checkpoints.checkpoint_cursor,
+			scoped.principal_id,
+			scoped.window_start,
+			scoped.dimensions,
+			scoped.operator,
+			scoped.value,
+			scoped.aux_sum,
+			scoped.value_at,
+			scoped.sketch,
+			scoped.event_count,
+			scoped.last_event_id,
+			scoped.rule_fingerprint,
+			scoped.updated_at
+		FROM checkpoints
+		LEFT JOIN scoped ON scoped.partition_id = checkpoints.partition_id
+		ORDER BY checkpoints.partition_id ASC, scoped.principal_id ASC
 	`
 )
 
+// rankTotalExpressions are the value and value_at expressions that merge the
+// pre-aggregates of one principal into its total, by operator. They match the Merge of the
+// operator's Go aggregator; sketch operators cannot be merged in SQL and are not ranked.
+var rankTotalExpressions = map[string][2]string{
+	aggregation.OpCount: {"SUM(value)", "NULL::TIMESTAMPTZ"},
+	aggregation.OpSum:   {"SUM(value)", "NULL::TIMESTAMPTZ"},
+	aggregation.OpMin:   {"MIN(value)", "NULL::TIMESTAMPTZ"},
+	aggregation.OpMax:   {"MAX(value)", "NULL::TIMESTAMPTZ"},
+	aggregation.OpAvg:   {"COALESCE(ROUND(SUM(aux_sum) / NULLIF(SUM(event_count), 0), 16), 0)", "NULL::TIMESTAMPTZ"},
+	aggregation.OpFirst: {"(ARRAY_AGG(value ORDER BY value_at ASC, window_start ASC))[1]", "MIN(value_at)"},
+	aggregation.OpLast:  {"(ARRAY_AGG(value ORDER BY value_at DESC, window_start DESC))[1]", "MAX(value_at)"},
+}
+
+// buildRankRulePreAggregatesQuery returns the statement of RankRuleRangeWithCheckpoints
+// for operator: the totals of the top $7 principals of a rule in order, and of the
+// principals in $8, with the checkpoint of every partition. A partition without selected
+// totals yields one row of NULLs. Ties rank in byte order of principal_id, as in Go. stale
+// reports whether any total in the range mixes in pre-aggregates of a rule fingerprint
+// other than a non-empty $6.
+func buildRankRulePreAggregatesQuery(operator string, ascending bool) (string, error) {
+	expressions, ok := rankTotalExpressions[operator]
+	if !ok {
+		return "", fmt.Errorf("operator %s cannot be ranked in SQL", operator)
+	}
+	direction := "DESC"
+	if ascending {
+		direction = "ASC"
+	}
+	return fmt.Sprintf(`
+		WITH checkpoints AS (
+			SELECT
+				p.partition_id,
+				COALESCE(c.checkpoint_cursor, 0) AS checkpoint_cursor
+			FROM generate_series(0, $5::INT - 1) AS p(partition_id)
+			LEFT JOIN sweep_checkpoints c
+			  ON c.bucket_size = $2
+			 AND c.partition_id = p.partition_id
+		),
+		totals AS (
+			SELECT
+				partition_id,
+				principal_id,
+				%s AS value,
+				SUM(aux_sum) AS aux_sum,
+				%s AS value_at,
+				SUM(event_count)::BIGINT AS event_count,
+				MAX(updated_at) AS updated_at,
+				BOOL_OR($6 <> '' AND rule_fingerprint <> '' AND rule_fingerprint <> $6) AS stale
+			FROM pre_aggregates
+			WHERE rule_name = $1
+			  AND bucket_size = $2
+			  AND window_start >= $3
+			  AND window_start < $4
+			GROUP BY partition_id, principal_id
+		),
+		ranked AS (
+			SELECT principal_id
+			FROM totals
+			WHERE event_count > 0
+			ORDER BY value %s, principal_id COLLATE "C" ASC
+			LIMIT $7
+		),
+		selected AS (
+			SELECT *
+			FROM totals
+			WHERE principal_id IN (SELECT principal_id FROM ranked)
+			   OR principal_id = ANY($8::text[])
+		)
+		SELECT
+			checkpoints.partition_id,
+			checkpoints.checkpoint_cursor,
+			(SELECT COALESCE(BOOL_OR(stale), FALSE) FROM totals) AS stale,
+			selected.principal_id,
+			selected.value,
+			selected.aux_sum,
+			selected.value_at,
+			selected.event_count,
+			selected.updated_at
+		FROM checkpoints
+		LEFT JOIN selected ON selected.partition_id = checkpoints.partition_id
+		ORDER BY checkpoints.partition_id ASC, selected.principal_id ASC
+	`, expressions[0], expressions[1], direction), nil
+}
+
 // PreAggregateAdapter implements aggregation.PreAggregateStore using PostgreSQL.
 // Flush and checkpoint writes are in a single transaction — the atomicity
 // contract that makes crash recovery safe.
@@ -150,24 +439,19 @@ func (a *PreAggregateAdapter) Flush(
 	}
 	defer tx.Rollback() //nolint:errcheck
 
-	// Lock checkpoint row first and enforce monotonic checkpoint writes.
+	// Lock checkpoint rows first and enforce monotonic checkpoint writes.
 	// This prevents stale, out-of-order flushes from overwriting newer durable state.
-	var durableCursor int64
-	err = tx.QueryRowContext(ctx, querySelectCheckpointForUpdate, bucketSize).Scan(&durableCursor)
-	if err == sql.ErrNoRows {
-		_, err = tx.ExecContext(ctx, queryInitCheckpointRow, bucketSize, time.Now().UTC())
-		if err != nil {
-			return fmt.Errorf("pre_aggregate flush: init checkpoint row: %w", err)
-		}
-
-		err = tx.QueryRowContext(ctx, querySelectCheckpointForUpdate, bucketSize).Scan(&durableCursor)
-		if err != nil {
-			return fmt.Errorf("pre_aggregate flush: read initialized checkpoint for update: %w", err)
-		}
-	}
+	checkpoints, err := lockCheckpoints(ctx, tx, bucketSize, partition.Full())
 	if err != nil {
-		return fmt.Errorf("pre_aggregate flush: read checkpoint for update: %w", err)
+		return fmt.Errorf("pre_aggregate flush: %w", err)
+	}
+	if checkpoints.Min() != checkpoints.Max() {
+		return fmt.Errorf(
+			"pre_aggregate flush: partition checkpoints of bucket %s differ (%d..%d); flush per partition range",
+			bucketSize, checkpoints.Min(), checkpoints.Max(),
+		)
 	}
+	durableCursor := checkpoints.Max()
 
 	if cursor <= durableCursor {
 		slog.Warn("[PreAggregateAdapter] Skipping stale/no-op flush",
@@ -177,25 +461,310 @@ func (a *PreAggregateAdapter) Flush(
 		return nil
 	}
 
+	if err := checkRuleFingerprints(ctx, tx, aggregates, bucketSize); err != nil {
+		return fmt.Errorf("pre_aggregate flush: %w", err)
+	}
+
+	if err := upsertAggregates(ctx, tx, aggregates, bucketSize); err != nil {
+		return fmt.Errorf("pre_aggregate flush: %w", err)
+	}
+
+	// Write the checkpoints — same transaction as the upserts.
+	if err := writeCheckpoints(ctx, tx, bucketSize, partition.Full(), cursor); err != nil {
+		return fmt.Errorf("pre_aggregate flush: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("pre_aggregate flush: commit: %w", err)
+	}
+
+	slog.Info("[PreAggregateAdapter] Flushed",
+		"aggregates", len(aggregates),
+		"cursor", cursor,
+		"bucket_size", bucketSize,
+	)
+	return nil
+}
+
+// FlushPartitions upserts aggregates of one partition range and advances the checkpoints
+// of its partitions in one transaction. The flush is skipped as stale if any checkpoint
+// moved since the batch read from, e.g. because another replica flushed the range first.
+func (a *PreAggregateAdapter) FlushPartitions(
+	ctx context.Context,
+	aggregates map[aggregation.AggregateKey]aggregation.AggregateState,
+	bucketSize string,
+	from aggregation.PartitionCheckpoints,
+	cursor int64,
+) error {
+	if bucketSize == "" {
+		bucketSize = defaultBucketSize
+	}
+
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("pre_aggregate flush: begin tx: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	durable, err := lockCheckpoints(ctx, tx, bucketSize, from.Range)
+	if err != nil {
+		return fmt.Errorf("pre_aggregate flush: %w", err)
+	}
+	for i, durableCursor := range durable.Cursors {
+		if durableCursor != from.Cursors[i] {
+			slog.Warn("[PreAggregateAdapter] Skipping stale flush; partition checkpoint moved",
+				"partition", from.Range.From+i,
+				"cursor", cursor,
+				"read_cursor", from.Cursors[i],
+				"durable_cursor", durableCursor,
+				"aggregates", len(aggregates))
+			return nil
+		}
+	}
+
+	for key := range aggregates {
+		if !from.Range.Contains(key.PartitionID) {
+			return fmt.Errorf("pre_aggregate flush: aggregate partition %d outside range %s", key.PartitionID, from.Range)
+		}
+	}
+
+	if err := checkRuleFingerprints(ctx, tx, aggregates, bucketSize); err != nil {
+		return fmt.Errorf("pre_aggregate flush: %w", err)
+	}
+	if err := upsertAggregates(ctx, tx, aggregates, bucketSize); err != nil {
+		return fmt.Errorf("pre_aggregate flush: %w", err)
+	}
+	if err := writeCheckpoints(ctx, tx, bucketSize, from.Range, cursor); err != nil {
+		return fmt.Errorf("pre_aggregate flush: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("pre_aggregate flush: commit: %w", err)
+	}
+
+	slog.Info("[PreAggregateAdapter] Flushed",
+		"aggregates", len(aggregates),
+		"cursor", cursor,
+		"bucket_size", bucketSize,
+		"partitions", from.Range.String(),
+	)
+	return nil
+}
+
+// ReadPartitionCheckpoints returns the checkpoint of every partition in partitions.
+// Partitions without a checkpoint row yet report 0.
+func (a *PreAggregateAdapter) ReadPartitionCheckpoints(
+	ctx context.Context,
+	bucketSize string,
+	partitions partition.Range,
+) (aggregation.PartitionCheckpoints, error) {
+	if bucketSize == "" {
+		bucketSize = defaultBucketSize
+	}
+
+	rows, err := a.db.QueryContext(ctx, queryReadPartitionCheckpoints, bucketSize, partitions.From, partitions.To)
+	if err != nil {
+		return aggregation.PartitionCheckpoints{}, fmt.Errorf("read partition checkpoints: %w", err)
+	}
+	checkpoints, _, err := scanCheckpoints(rows, partitions)
+	if err != nil {
+		return aggregation.PartitionCheckpoints{}, fmt.Errorf("read partition checkpoints: %w", err)
+	}
+	return checkpoints, nil
+}
+
+// RepairAggregates replaces the rows of keys with aggregates in one transaction; keys
+// without an aggregate are deleted. It fails with aggregation.ErrCheckpointMoved unless
+// every partition is still at the checkpoint the replacements were computed at.
+func (a *PreAggregateAdapter) RepairAggregates(
+	ctx context.Context,
+	bucketSize string,
+	checkpoints map[int]int64,
+	keys []aggregation.AggregateKey,
+	aggregates map[aggregation.AggregateKey]aggregation.AggregateState,
+) error {
+	if bucketSize == "" {
+		bucketSize = defaultBucketSize
+	}
+
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("pre_aggregate repair: begin tx: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	// Partitions are locked in ascending order, like flushes lock them.
+	partitionIDs := make([]int, 0, len(checkpoints))
+	for partitionID := range checkpoints {
+		partitionIDs = append(partitionIDs, partitionID)
+	}
+	sort.Ints(partitionIDs)
+	for _, partitionID := range partitionIDs {
+		durable, err := lockCheckpoints(ctx, tx, bucketSize, partition.Range{From: partitionID, To: partitionID + 1})
+		if err != nil {
+			return fmt.Errorf("pre_aggregate repair: %w", err)
+		}
+		if durable.Cursors[0] != checkpoints[partitionID] {
+			return fmt.Errorf("pre_aggregate repair: partition %d at %d, verified at %d: %w",
+				partitionID, durable.Cursors[0], checkpoints[partitionID], aggregation.ErrCheckpointMoved)
+		}
+	}
+
+	for _, key := range keys {
+		if _, ok := checkpoints[key.PartitionID]; !ok {
+			return fmt.Errorf("pre_aggregate repair: partition %d of %v was not verified", key.PartitionID, key)
+		}
+	}
+	if err := checkRuleFingerprints(ctx, tx, aggregates, bucketSize); err != nil {
+		return fmt.Errorf("pre_aggregate repair: %w", err)
+	}
+
+	deleteStmt, err := tx.PrepareContext(ctx, queryDeletePreAggregate)
+	if err != nil {
+		return fmt.Errorf("pre_aggregate repair: prepare delete: %w", err)
+	}
+	defer deleteStmt.Close()
+	for _, key := range keys {
+		if _, err := deleteStmt.ExecContext(ctx,
+			key.PartitionID, key.PrincipalID, key.RuleName, bucketSize, key.WindowStart, key.Dimensions,
+		); err != nil {
+			return fmt.Errorf("pre_aggregate repair: delete %v: %w", key, err)
+		}
+	}
+	if err := upsertAggregates(ctx, tx, aggregates, bucketSize); err != nil {
+		return fmt.Errorf("pre_aggregate repair: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("pre_aggregate repair: commit: %w", err)
+	}
+
+	slog.Info("[PreAggregateAdapter] Repaired",
+		"rows", len(keys),
+		"replaced", len(aggregates),
+		"bucket_size", bucketSize,
+	)
+	return nil
+}
+
+// lockCheckpoints locks the checkpoint rows of partitions inside tx, creating the rows of
+// the bucket size first if it has none yet.
+func lockCheckpoints(
+	ctx context.Context,
+	tx *sql.Tx,
+	bucketSize string,
+	partitions partition.Range,
+) (aggregation.PartitionCheckpoints, error) {
+	rows, err := tx.QueryContext(ctx, querySelectCheckpointForUpdate, bucketSize, partitions.From, partitions.To)
+	if err != nil {
+		return aggregation.PartitionCheckpoints{}, fmt.Errorf("read checkpoint for update: %w", err)
+	}
+	checkpoints, found, err := scanCheckpoints(rows, partitions)
+	if err != nil {
+		return aggregation.PartitionCheckpoints{}, fmt.Errorf("read checkpoint for update: %w", err)
+	}
+	if found == partitions.Len() {
+		return checkpoints, nil
+	}
+
+	if _, err := tx.ExecContext(ctx, queryInitCheckpointRows, bucketSize, time.Now().UTC(), partition.Count); err != nil {
+		return aggregation.PartitionCheckpoints{}, fmt.Errorf("init checkpoint rows: %w", err)
+	}
+	rows, err = tx.QueryContext(ctx, querySelectCheckpointForUpdate, bucketSize, partitions.From, partitions.To)
+	if err != nil {
+		return aggregation.PartitionCheckpoints{}, fmt.Errorf("read initialized checkpoint for update: %w", err)
+	}
+	checkpoints, found, err = scanCheckpoints(rows, partitions)
+	if err != nil {
+		return aggregation.PartitionCheckpoints{}, fmt.Errorf("read initialized checkpoint for update: %w", err)
+	}
+	if found != partitions.Len() {
+		return aggregation.PartitionCheckpoints{}, fmt.Errorf("checkpoint rows missing (bucket=%s, partitions=%s)", bucketSize, partitions)
+	}
+	return checkpoints, nil
+}
+
+// scanCheckpoints reads (partition_id, checkpoint_cursor) rows into checkpoints of
+// partitions and closes rows. It also returns the number of rows found.
+func scanCheckpoints(rows *sql.Rows, partitions partition.Range) (aggregation.PartitionCheckpoints, int, error) {
+	defer rows.Close()
+
+	checkpoints := aggregation.PartitionCheckpoints{Range: partitions, Cursors: make([]int64, partitions.Len())}
+	found := 0
+	for rows.Next() {
+		var partitionID int
+		var cursor int64
+		if err := rows.Scan(&partitionID, &cursor); err != nil {
+			return checkpoints, found, fmt.Errorf("scan row: %w", err)
+		}
+		if !partitions.Contains(partitionID) {
+			continue
+		}
+		checkpoints.Cursors[partitionID-partitions.From] = cursor
+		found++
+	}
+	if err := rows.Err(); err != nil {
+		return checkpoints, found, fmt.Errorf("iterate rows: %w", err)
+	}
+	return checkpoints, found, nil
+}
+
+// writeCheckpoints advances the checkpoint of every partition in partitions to cursor.
+func writeCheckpoints(ctx context.Context, tx *sql.Tx, bucketSize string, partitions partition.Range, cursor int64) error {
+	result, err := tx.ExecContext(ctx, queryUpdateCheckpoint, cursor, time.Now().UTC(), bucketSize, partitions.From, partitions.To)
+	if err != nil {
+		return fmt.Errorf("write checkpoint: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("check checkpoint write: %w", err)
+	}
+	if rowsAffected != int64(partitions.Len()) {
+		return fmt.Errorf("checkpoint rows missing (bucket=%s, partitions=%s)", bucketSize, partitions)
+	}
+	return nil
+}
+
+// upsertAggregates merges aggregates into their durable rows inside tx. Rows are written
+// in key order so concurrent flushes of one bucket (scheduler and rule rebuild) lock
+// rows in the same order and cannot deadlock.
+func upsertAggregates(
+	ctx context.Context,
+	tx *sql.Tx,
+	aggregates map[aggregation.AggregateKey]aggregation.AggregateState,
+	bucketSize string,
+) error {
 	upsertStmt, err := tx.PrepareContext(ctx, queryUpsertPreAggregate)
 	if err != nil {
-		return fmt.Errorf("pre_aggregate flush: prepare upsert: %w", err)
+		return fmt.Errorf("prepare upsert: %w", err)
 	}
 	defer upsertStmt.Close()
 
-	for key, state := range aggregates {
+	for _, key := range sortedAggregateKeys(aggregates) {
+		state := aggregates[key]
 		keyBucketSize := key.BucketSize
 		if keyBucketSize == "" {
 			keyBucketSize = defaultBucketSize
 		}
 		if keyBucketSize != bucketSize {
 			return fmt.Errorf(
-				"pre_aggregate flush: aggregate bucket mismatch: expected %s, got %s for key %v",
+				"aggregate bucket mismatch: expected %s, got %s for key %v",
 				bucketSize,
 				keyBucketSize,
 				key,
 			)
 		}
+		if hasSketchState(state.Operator) {
+			state, err = mergeDurableSketch(ctx, tx, key, keyBucketSize, state)
+			if err != nil {
+				return fmt.Errorf("merge sketch %v: %w", key, err)
+			}
+		}
+		sketch, err := marshalSketch(state)
+		if err != nil {
+			return fmt.Errorf("%v: %w", key, err)
+		}
 		if _, err := upsertStmt.ExecContext(ctx,
 			key.PartitionID,
 			key.PrincipalID,
@@ -203,46 +772,79 @@ func (a *PreAggregateAdapter) Flush(
 			state.RuleFingerprint,
 			keyBucketSize,
 			key.WindowStart,
+			key.Dimensions,
 			state.Operator,
 			state.Value,
+			state.Sum,
+			nullTime(state.ValueAt),
+			sketch,
 			state.EventCount,
 			state.LastEventID,
 			state.UpdatedAt,
 		); err != nil {
-			return fmt.Errorf("pre_aggregate flush: upsert %v: %w", key, err)
+			return fmt.Errorf("upsert %v: %w", key, err)
 		}
 	}
+	return nil
+}
 
-	// Write single global checkpoint — same transaction as the upserts.
-	// No partition tracking needed for single-instance deployment.
-	result, err := tx.ExecContext(ctx, queryUpdateCheckpoint, cursor, time.Now().UTC(), bucketSize)
-	if err != nil {
-		return fmt.Errorf("pre_aggregate flush: write checkpoint: %w", err)
+func sortedAggregateKeys(aggregates map[aggregation.AggregateKey]aggregation.AggregateState) []aggregation.AggregateKey {
+	keys := make([]aggregation.AggregateKey, 0, len(aggregates))
+	for key := range aggregates {
+		keys = append(keys, key)
 	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.PartitionID != b.PartitionID {
+			return a.PartitionID < b.PartitionID
+		}
+		if a.PrincipalID != b.PrincipalID {
+			return a.PrincipalID < b.PrincipalID
+		}
+		if a.RuleName != b.RuleName {
+			return a.RuleName < b.RuleName
+		}
+		if !a.WindowStart.Equal(b.WindowStart) {
+			return a.WindowStart.Before(b.WindowStart)
+		}
+		return a.Dimensions < b.Dimensions
+	})
+	return keys
+}
 
-	// Verify singleton row was updated
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		return fmt.Errorf("pre_aggregate flush: check checkpoint write: %w", err)
+// mergeDurableSketch merges the durable count_distinct/quantile sketch for key into state
+// and refreshes the derived value. The row stays locked until the flush commits.
+func mergeDurableSketch(
+	ctx context.Context,
+	tx *sql.Tx,
+	key aggregation.AggregateKey,
+	bucketSize string,
+	state aggregation.AggregateState,
+) (aggregation.AggregateState, error) {
+	var data []byte
+	err := tx.QueryRowContext(ctx, querySelectSketchForUpdate,
+		key.PartitionID, key.PrincipalID, key.RuleName, bucketSize, key.WindowStart, key.Dimensions,
+	).Scan(&data)
+	if err == sql.ErrNoRows {
+		return state, nil
 	}
-	if rowsAffected == 0 {
-		return fmt.Errorf("pre_aggregate flush: checkpoint row missing (bucket=%s)", bucketSize)
+	if err != nil {
+		return state, err
 	}
 
-	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("pre_aggregate flush: commit: %w", err)
+	durable := aggregation.AggregateState{Operator: state.Operator}
+	if err := unmarshalSketch(&durable, data); err != nil {
+		return state, err
 	}
-
-	slog.Info("[PreAggregateAdapter] Flushed",
-		"aggregates", len(aggregates),
-		"cursor", cursor,
-		"bucket_size", bucketSize,
-	)
-	return nil
+	merged := aggregation.Operators[state.Operator].Merge(durable, state)
+	state.Sketch = merged.Sketch
+	state.Digest = merged.Digest
+	state.Value = merged.Value
+	return state, nil
 }
 
-// ReadCheckpoint returns the bucket-scoped checkpoint cursor.
-// Returns 0 if no checkpoint exists yet (meaning "replay from beginning").
+// ReadCheckpoint returns the bucket-scoped checkpoint cursor, the lowest checkpoint of
+// its partitions. Returns 0 if no checkpoint exists yet (meaning "replay from beginning").
 func (a *PreAggregateAdapter) ReadCheckpoint(ctx context.Context, bucketSize string) (int64, error) {
 	if bucketSize == "" {
 		bucketSize = defaultBucketSize
@@ -278,7 +880,9 @@ func (a *PreAggregateAdapter) LoadAggregates(ctx context.Context) (map[aggregati
 	for rows.Next() {
 		var key aggregation.AggregateKey
 		var state aggregation.AggregateState
-		var valueStr string
+		var valueStr, auxSumStr string
+		var valueAt sql.NullTime
+		var sketch []byte
 
 		if err := rows.Scan(
 			&key.PartitionID,
@@ -287,8 +891,12 @@ func (a *PreAggregateAdapter) LoadAggregates(ctx context.Context) (map[aggregati
 			&state.RuleFingerprint,
 			&key.BucketSize,
 			&key.WindowStart,
+			&key.Dimensions,
 			&state.Operator,
 			&valueStr,
+			&auxSumStr,
+			&valueAt,
+			&sketch,
 			&state.EventCount,
 			&state.LastEventID,
 			&state.UpdatedAt,
@@ -300,8 +908,18 @@ func (a *PreAggregateAdapter) LoadAggregates(ctx context.Context) (map[aggregati
 		if err != nil {
 			return nil, fmt.Errorf("load aggregates: parse value %q: %w", valueStr, err)
 		}
+		auxSum, err := decimal.NewFromString(auxSumStr)
+		if err != nil {
+			return nil, fmt.Errorf("load aggregates: parse aux_sum %q: %w", auxSumStr, err)
+		}
+		if err := unmarshalSketch(&state, sketch); err != nil {
+			return nil, fmt.Errorf("load aggregates: %w", err)
+		}
 		state.Value = value
+		state.Sum = auxSum
+		state.ValueAt = valueAt.Time
 		state.WindowStart = key.WindowStart
+		state.Dimensions = key.Dimensions
 
 		aggregates[key] = state
 		count++
@@ -330,8 +948,7 @@ func (a *PreAggregateAdapter) QueryRange(
 		bucketSize = defaultBucketSize
 	}
 
-	// Use fixed partition_id=0 for single-tenant deployments
-	partitionID := 0
+	partitionID := partition.For(principalID)
 
 	rows, err := a.db.QueryContext(ctx, queryRangePreAggregates, partitionID, principalID, ruleName, bucketSize, startTime, endTime)
 
@@ -343,12 +960,18 @@ func (a *PreAggregateAdapter) QueryRange(
 	var results []aggregation.AggregateState
 	for rows.Next() {
 		var state aggregation.AggregateState
-		var valueStr string
+		var valueStr, auxSumStr string
+		var valueAt sql.NullTime
+		var sketch []byte
 
 		err := rows.Scan(
 			&state.WindowStart,
+			&state.Dimensions,
 			&state.Operator,
 			&valueStr,
+			&auxSumStr,
+			&valueAt,
+			&sketch,
 			&state.EventCount,
 			&state.LastEventID,
 			&state.RuleFingerprint,
@@ -362,7 +985,16 @@ func (a *PreAggregateAdapter) QueryRange(
 		if err != nil {
 			return nil, fmt.Errorf("parse value %q: %w", valueStr, err)
 		}
+		auxSum, err := decimal.NewFromString(auxSumStr)
+		if err != nil {
+			return nil, fmt.Errorf("parse aux_sum %q: %w", auxSumStr, err)
+		}
+		if err := unmarshalSketch(&state, sketch); err != nil {
+			return nil, err
+		}
 		state.Value = value
+		state.Sum = auxSum
+		state.ValueAt = valueAt.Time
 
 		results = append(results, state)
 	}
@@ -389,8 +1021,8 @@ func (a *PreAggregateAdapter) QueryRangeWithCheckpoint(
 		bucketSize = defaultBucketSize
 	}
 
-	// Use fixed partition_id=0 for single-tenant deployments
-	partitionID := 0
+	// The checkpoint of the principal's partition pairs with its aggregates.
+	partitionID := partition.For(principalID)
 
 	rows, err := a.db.QueryContext(
 		ctx,
@@ -411,25 +1043,10 @@ func (a *PreAggregateAdapter) QueryRangeWithCheckpoint(
 	for rows.Next() {
 		var (
 			scannedCheckpoint int64
-			windowStart       sql.NullTime
-			operator          sql.NullString
-			valueStr          sql.NullString
-			eventCount        sql.NullInt64
-			lastEventID       sql.NullString
-			ruleFingerprint   sql.NullString
-			updatedAt         sql.NullTime
+			columns           nullableAggregateColumns
 		)
 
-		if err := rows.Scan(
-			&scannedCheckpoint,
-			&windowStart,
-			&operator,
-			&valueStr,
-			&eventCount,
-			&lastEventID,
-			&ruleFingerprint,
-			&updatedAt,
-		); err != nil {
+		if err := rows.Scan(append([]any{&scannedCheckpoint}, columns.dest()...)...); err != nil {
 			return nil, 0, fmt.Errorf("scan row: %w", err)
 		}
 
@@ -438,33 +1055,314 @@ func (a *PreAggregateAdapter) QueryRangeWithCheckpoint(
 			checkpointScanned = true
 		}
 
-		// LEFT JOIN emits one row with NULL aggregate columns when range is empty.
-		if !windowStart.Valid {
-			continue
+		state, ok, err := columns.state()
+		if err != nil {
+			return nil, 0, err
+		}
+		if ok {
+			results = append(results, state)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("iterate rows: %w", err)
+	}
+
+	return results, checkpoint, nil
+}
+
+// QueryRangesWithCheckpoint is the batch form of QueryRangeWithCheckpoint. It reads the
+// pre-aggregates of every principal and rule at one bucket size in a single statement,
+// each paired with the checkpoint of its principal's partition. The result holds an
+// entry for every principal and rule, empty if the range has no pre-aggregates.
+func (a *PreAggregateAdapter) QueryRangesWithCheckpoint(
+	ctx context.Context,
+	principalIDs []string,
+	ruleNames []string,
+	bucketSize string,
+	startTime time.Time,
+	endTime time.Time,
+) (map[aggregation.RangeScope]aggregation.ScopedRange, error) {
+	if bucketSize == "" {
+		bucketSize = defaultBucketSize
+	}
+
+	partitionSet := make(map[int]bool, len(principalIDs))
+	partitionIDs := make([]int64, 0, len(principalIDs))
+	for _, principalID := range principalIDs {
+		partitionID := partition.For(principalID)
+		if !partitionSet[partitionID] {
+			partitionSet[partitionID] = true
+			partitionIDs = append(partitionIDs, int64(partitionID))
+		}
+	}
+	sort.Slice(partitionIDs, func(i, j int) bool { return partitionIDs[i] < partitionIDs[j] })
+
+	rows, err := a.db.QueryContext(
+		ctx,
+		queryRangesPreAggregatesWithCheckpoint,
+		bucketSize, pq.Array(partitionIDs), pq.Array(principalIDs), pq.Array(ruleNames), startTime, endTime,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query pre_aggregates with checkpoints: %w", err)
+	}
+	defer rows.Close()
+
+	checkpoints := make(map[int]int64, len(partitionIDs))
+	states := make(map[aggregation.RangeScope][]aggregation.AggregateState)
+	for rows.Next() {
+		var (
+			partitionID int
+			checkpoint  int64
+			principalID sql.NullString
+			ruleName    sql.NullString
+			columns     nullableAggregateColumns
+		)
+
+		if err := rows.Scan(append([]any{&partitionID, &checkpoint, &principalID, &ruleName}, columns.dest()...)...); err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		checkpoints[partitionID] = checkpoint
+
+		state, ok, err := columns.state()
+		if err != nil {
+			return nil, err
 		}
-		if !valueStr.Valid {
-			return nil, 0, fmt.Errorf("scan row: aggregate value is NULL")
+		if ok {
+			scope := aggregation.RangeScope{PrincipalID: principalID.String, RuleName: ruleName.String}
+			states[scope] = append(states[scope], state)
 		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate rows: %w", err)
+	}
 
-		value, parseErr := decimal.NewFromString(valueStr.String)
-		if parseErr != nil {
-			return nil, 0, fmt.Errorf("parse value %q: %w", valueStr.String, parseErr)
+	results := make(map[aggregation.RangeScope]aggregation.ScopedRange, len(principalIDs)*len(ruleNames))
+	for _, principalID := range principalIDs {
+		checkpoint := checkpoints[partition.For(principalID)]
+		for _, ruleName := range ruleNames {
+			scope := aggregation.RangeScope{PrincipalID: principalID, RuleName: ruleName}
+			results[scope] = aggregation.ScopedRange{States: states[scope], Checkpoint: checkpoint}
 		}
+	}
+	return results, nil
+}
 
-		results = append(results, aggregation.AggregateState{
-			WindowStart:     windowStart.Time,
-			Operator:        operator.String,
+// ScanRuleRangeWithCheckpoints streams the pre-aggregates of one rule over a range for
+// every principal to fn, in one statement with the checkpoint of every partition. The
+// returned checkpoints cover all partitions.
+func (a *PreAggregateAdapter) ScanRuleRangeWithCheckpoints(
+	ctx context.Context,
+	ruleName string,
+	bucketSize string,
+	startTime time.Time,
+	endTime time.Time,
+	fn func(principalID string, state aggregation.AggregateState),
+) (aggregation.PartitionCheckpoints, error) {
+	if bucketSize == "" {
+		bucketSize = defaultBucketSize
+	}
+
+	checkpoints := aggregation.PartitionCheckpoints{
+		Range:   partition.Range{From: 0, To: partition.Count},
+		Cursors: make([]int64, partition.Count),
+	}
+
+	rows, err := a.db.QueryContext(
+		ctx,
+		queryRulePreAggregatesWithCheckpoints,
+		ruleName, bucketSize, startTime, endTime, partition.Count,
+	)
+	if err != nil {
+		return checkpoints, fmt.Errorf("query rule pre_aggregates with checkpoints: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			partitionID int
+			checkpoint  int64
+			principalID sql.NullString
+			columns     nullableAggregateColumns
+		)
+
+		if err := rows.Scan(append([]any{&partitionID, &checkpoint, &principalID}, columns.dest()...)...); err != nil {
+			return checkpoints, fmt.Errorf("scan row: %w", err)
+		}
+		if partitionID < 0 || partitionID >= partition.Count {
+			return checkpoints, fmt.Errorf("scan row: partition %d out of range", partitionID)
+		}
+		checkpoints.Cursors[partitionID] = checkpoint
+
+		state, ok, err := columns.state()
+		if err != nil {
+			return checkpoints, err
+		}
+		if ok {
+			fn(principalID.String, state)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return checkpoints, fmt.Errorf("iterate rows: %w", err)
+	}
+	return checkpoints, nil
+}
+
+// RankRuleRangeWithCheckpoints ranks the principals of one rule by their total over a
+// range in one statement, with the checkpoint of every partition. Only the totals of the
+// top query.Limit principals and of query.PrincipalIDs leave the database. Operators
+// without a SQL merge (count_distinct, quantile) are rejected.
+func (a *PreAggregateAdapter) RankRuleRangeWithCheckpoints(ctx context.Context, query aggregation.RankQuery) (aggregation.Ranking, error) {
+	bucketSize := query.BucketSize
+	if bucketSize == "" {
+		bucketSize = defaultBucketSize
+	}
+	statement, err := buildRankRulePreAggregatesQuery(query.Operator, query.Ascending)
+	if err != nil {
+		return aggregation.Ranking{}, err
+	}
+
+	ranking := aggregation.Ranking{
+		Totals: make(map[string]aggregation.AggregateState),
+		Checkpoints: aggregation.PartitionCheckpoints{
+			Range:   partition.Full(),
+			Cursors: make([]int64, partition.Count),
+		},
+	}
+
+	principalIDs := query.PrincipalIDs
+	if principalIDs == nil {
+		principalIDs = []string{}
+	}
+	rows, err := a.db.QueryContext(
+		ctx,
+		statement,
+		query.RuleName, bucketSize, query.Start, query.End, partition.Count,
+		query.RuleFingerprint, query.Limit, pq.Array(principalIDs),
+	)
+	if err != nil {
+		return aggregation.Ranking{}, fmt.Errorf("rank rule pre_aggregates: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			partitionID int
+			checkpoint  int64
+			principalID sql.NullString
+			valueStr    sql.NullString
+			auxSumStr   sql.NullString
+			valueAt     sql.NullTime
+			eventCount  sql.NullInt64
+			updatedAt   sql.NullTime
+		)
+		if err := rows.Scan(&partitionID, &checkpoint, &ranking.Stale, &principalID,
+			&valueStr, &auxSumStr, &valueAt, &eventCount, &updatedAt); err != nil {
+			return aggregation.Ranking{}, fmt.Errorf("scan row: %w", err)
+		}
+		if partitionID < 0 || partitionID >= partition.Count {
+			return aggregation.Ranking{}, fmt.Errorf("scan row: partition %d out of range", partitionID)
+		}
+		ranking.Checkpoints.Cursors[partitionID] = checkpoint
+		if !principalID.Valid {
+			continue
+		}
+
+		value, err := decimal.NewFromString(valueStr.String)
+		if err != nil {
+			return aggregation.Ranking{}, fmt.Errorf("parse value %q: %w", valueStr.String, err)
+		}
+		auxSum, err := decimal.NewFromString(auxSumStr.String)
+		if err != nil {
+			return aggregation.Ranking{}, fmt.Errorf("parse aux_sum %q: %w", auxSumStr.String, err)
+		}
+		ranking.Totals[principalID.String] = aggregation.AggregateState{
+			Operator:        query.Operator,
 			Value:           value,
+			Sum:             auxSum,
+			ValueAt:         valueAt.Time,
 			EventCount:      eventCount.Int64,
-			LastEventID:     lastEventID.String,
-			RuleFingerprint: ruleFingerprint.String,
+			RuleFingerprint: query.RuleFingerprint,
 			UpdatedAt:       updatedAt.Time,
-		})
+		}
 	}
 
 	if err := rows.Err(); err != nil {
-		return nil, 0, fmt.Errorf("iterate rows: %w", err)
+		return aggregation.Ranking{}, fmt.Errorf("iterate rows: %w", err)
 	}
+	return ranking, nil
+}
 
-	return results, checkpoint, nil
+// nullableAggregateColumns receives the pre-aggregate columns of a LEFT JOIN row, which
+// are all NULL when the joined range is empty.
+type nullableAggregateColumns struct {
+	windowStart     sql.NullTime
+	dimensions      sql.NullString
+	operator        sql.NullString
+	valueStr        sql.NullString
+	auxSumStr       sql.NullString
+	valueAt         sql.NullTime
+	sketch          []byte
+	eventCount      sql.NullInt64
+	lastEventID     sql.NullString
+	ruleFingerprint sql.NullString
+	updatedAt       sql.NullTime
+}
+
+func (c *nullableAggregateColumns) dest() []any {
+	return []any{
+		&c.windowStart,
+		&c.dimensions,
+		&c.operator,
+		&c.valueStr,
+		&c.auxSumStr,
+		&c.valueAt,
+		&c.sketch,
+		&c.eventCount,
+		&c.lastEventID,
+		&c.ruleFingerprint,
+		&c.updatedAt,
+	}
+}
+
+// state converts the columns to an aggregate state. ok is false for the row a LEFT
+// JOIN emits when the range is empty.
+func (c *nullableAggregateColumns) state() (state aggregation.AggregateState, ok bool, err error) {
+	if !c.windowStart.Valid {
+		return aggregation.AggregateState{}, false, nil
+	}
+	if !c.valueStr.Valid {
+		return aggregation.AggregateState{}, false, fmt.Errorf("scan row: aggregate value is NULL")
+	}
+
+	value, err := decimal.NewFromString(c.valueStr.String)
+	if err != nil {
+		return aggregation.AggregateState{}, false, fmt.Errorf("parse value %q: %w", c.valueStr.String, err)
+	}
+	auxSum := decimal.Zero
+	if c.auxSumStr.Valid {
+		auxSum, err = decimal.NewFromString(c.auxSumStr.String)
+		if err != nil {
+			return aggregation.AggregateState{}, false, fmt.Errorf("parse aux_sum %q: %w", c.auxSumStr.String, err)
+		}
+	}
+
+	state = aggregation.AggregateState{
+		WindowStart:     c.windowStart.Time,
+		Dimensions:      c.dimensions.String,
+		Operator:        c.operator.String,
+		Value:           value,
+		Sum:             auxSum,
+		ValueAt:         c.valueAt.Time,
+		EventCount:      c.eventCount.Int64,
+		LastEventID:     c.lastEventID.String,
+		RuleFingerprint: c.ruleFingerprint.String,
+		UpdatedAt:       c.updatedAt.Time,
+	}
+	if err := unmarshalSketch(&state, c.sketch); err != nil {
+		return aggregation.AggregateState{}, false, err
+	}
+	return state, true, nil
 }
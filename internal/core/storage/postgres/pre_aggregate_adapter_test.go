@@ -8,10 +8,21 @@ import (
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/aevon-lab/project-aevon/internal/core/aggregation"
+	"github.com/aevon-lab/project-aevon/internal/core/partition"
+	"github.com/lib/pq"
 	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/require"
 )
 
+// checkpointRows returns one checkpoint row at cursor for every partition in partitions.
+func checkpointRows(cursor int64, partitions partition.Range) *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{"partition_id", "checkpoint_cursor"})
+	for p := partitions.From; p < partitions.To; p++ {
+		rows.AddRow(p, cursor)
+	}
+	return rows
+}
+
 func TestPreAggregateAdapter_FlushSkipsStaleCursor(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	require.NoError(t, err)
@@ -20,12 +31,9 @@ func TestPreAggregateAdapter_FlushSkipsStaleCursor(t *testing.T) {
 	adapter := NewPreAggregateAdapter(db)
 
 	mock.ExpectBegin()
-	mock.ExpectQuery(regexp.QuoteMeta(`
-		SELECT checkpoint_cursor
-		FROM sweep_checkpoints
-		WHERE bucket_size = $1
-		FOR UPDATE
-	`)).WithArgs("1m").WillReturnRows(sqlmock.NewRows([]string{"checkpoint_cursor"}).AddRow(int64(100)))
+	mock.ExpectQuery(regexp.QuoteMeta(querySelectCheckpointForUpdate)).
+		WithArgs("1m", 0, partition.Count).
+		WillReturnRows(checkpointRows(100, partition.Full()))
 	mock.ExpectRollback()
 
 	err = adapter.Flush(context.Background(), map[aggregation.AggregateKey]aggregation.AggregateState{}, 100, "1m")
@@ -33,6 +41,79 @@ func TestPreAggregateAdapter_FlushSkipsStaleCursor(t *testing.T) {
 	require.NoError(t, mock.ExpectationsWereMet())
 }
 
+func TestPreAggregateAdapter_FlushRejectsDivergedPartitionCheckpoints(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	adapter := NewPreAggregateAdapter(db)
+
+	rows := checkpointRows(100, partition.Range{From: 0, To: 128})
+	for p := 128; p < partition.Count; p++ {
+		rows.AddRow(p, int64(150))
+	}
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(querySelectCheckpointForUpdate)).
+		WithArgs("1m", 0, partition.Count).
+		WillReturnRows(rows)
+	mock.ExpectRollback()
+
+	err = adapter.Flush(context.Background(), map[aggregation.AggregateKey]aggregation.AggregateState{}, 200, "1m")
+	require.ErrorContains(t, err, "partition checkpoints of bucket 1m differ")
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPreAggregateAdapter_FlushPartitionsSkipsMovedCheckpoint(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	adapter := NewPreAggregateAdapter(db)
+	shard := partition.Range{From: 0, To: 128}
+
+	// Another replica flushed the shard up to 150 after this batch read it at 100.
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(querySelectCheckpointForUpdate)).
+		WithArgs("1m", 0, 128).
+		WillReturnRows(checkpointRows(150, shard))
+	mock.ExpectRollback()
+
+	from := aggregation.PartitionCheckpoints{Range: shard, Cursors: make([]int64, shard.Len())}
+	for i := range from.Cursors {
+		from.Cursors[i] = 100
+	}
+	err = adapter.FlushPartitions(context.Background(), map[aggregation.AggregateKey]aggregation.AggregateState{}, "1m", from, 200)
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPreAggregateAdapter_FlushPartitionsAdvancesShardCheckpoints(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	adapter := NewPreAggregateAdapter(db)
+	shard := partition.Range{From: 128, To: 256}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(querySelectCheckpointForUpdate)).
+		WithArgs("1m", 128, 256).
+		WillReturnRows(checkpointRows(100, shard))
+	mock.ExpectPrepare(regexp.QuoteMeta(queryUpsertPreAggregate))
+	mock.ExpectExec(regexp.QuoteMeta(queryUpdateCheckpoint)).
+		WithArgs(int64(200), sqlmock.AnyArg(), "1m", 128, 256).
+		WillReturnResult(sqlmock.NewResult(0, int64(shard.Len())))
+	mock.ExpectCommit()
+
+	from := aggregation.PartitionCheckpoints{Range: shard, Cursors: make([]int64, shard.Len())}
+	for i := range from.Cursors {
+		from.Cursors[i] = 100
+	}
+	err = adapter.FlushPartitions(context.Background(), map[aggregation.AggregateKey]aggregation.AggregateState{}, "1m", from, 200)
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
 func TestPreAggregateAdapter_FlushIncludesBucketSize(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	require.NoError(t, err)
@@ -59,27 +140,48 @@ func TestPreAggregateAdapter_FlushIncludesBucketSize(t *testing.T) {
 	}
 
 	mock.ExpectBegin()
-	mock.ExpectQuery(regexp.QuoteMeta(`
-		SELECT checkpoint_cursor
-		FROM sweep_checkpoints
-		WHERE bucket_size = $1
-		FOR UPDATE
-	`)).WithArgs("1m").WillReturnRows(sqlmock.NewRows([]string{"checkpoint_cursor"}).AddRow(int64(10)))
+	mock.ExpectQuery(regexp.QuoteMeta(querySelectCheckpointForUpdate)).
+		WithArgs("1m", 0, partition.Count).
+		WillReturnRows(checkpointRows(10, partition.Full()))
 
+	mock.ExpectQuery(regexp.QuoteMeta(querySelectRuleVersionsForBucket)).
+		WithArgs("1m").
+		WillReturnRows(sqlmock.NewRows([]string{"rule_name", "fingerprint"}))
 	mock.ExpectPrepare(regexp.QuoteMeta(`
 		INSERT INTO pre_aggregates (
 			partition_id, principal_id, rule_name, rule_fingerprint,
-			bucket_size, window_start, operator, value, event_count, last_event_id, updated_at
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
-		ON CONFLICT (partition_id, principal_id, rule_name, bucket_size, window_start)
+			bucket_size, window_start, dimensions, operator, value, aux_sum, value_at, sketch,
+			event_count, last_event_id, updated_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+		ON CONFLICT (partition_id, principal_id, rule_name, bucket_size, window_start, dimensions)
 		DO UPDATE SET
 			value = CASE EXCLUDED.operator
 				WHEN 'count' THEN pre_aggregates.value + EXCLUDED.value
 				WHEN 'sum' THEN pre_aggregates.value + EXCLUDED.value
 				WHEN 'min' THEN LEAST(pre_aggregates.value, EXCLUDED.value)
 				WHEN 'max' THEN GREATEST(pre_aggregates.value, EXCLUDED.value)
+				WHEN 'avg' THEN ROUND(
+					(pre_aggregates.aux_sum + EXCLUDED.aux_sum)
+						/ NULLIF(pre_aggregates.event_count + EXCLUDED.event_count, 0),
+					16
+				)
+				WHEN 'first' THEN CASE
+					WHEN EXCLUDED.value_at < pre_aggregates.value_at THEN EXCLUDED.value
+					ELSE pre_aggregates.value
+				END
+				WHEN 'last' THEN CASE
+					WHEN EXCLUDED.value_at >= pre_aggregates.value_at THEN EXCLUDED.value
+					ELSE pre_aggregates.value
+				END
 				ELSE EXCLUDED.value
 			END,
+			aux_sum = pre_aggregates.aux_sum + EXCLUDED.aux_sum,
+			value_at = CASE EXCLUDED.operator
+				WHEN 'first' THEN LEAST(pre_aggregates.value_at, EXCLUDED.value_at)
+				WHEN 'last' THEN GREATEST(pre_aggregates.value_at, EXCLUDED.value_at)
+				ELSE EXCLUDED.value_at
+			END,
+			sketch           = EXCLUDED.sketch,
 			event_count      = pre_aggregates.event_count + EXCLUDED.event_count,
 			last_event_id    = EXCLUDED.last_event_id,
 			rule_fingerprint = EXCLUDED.rule_fingerprint,
@@ -91,18 +193,98 @@ func TestPreAggregateAdapter_FlushIncludesBucketSize(t *testing.T) {
 		state.RuleFingerprint,
 		key.BucketSize,
 		key.WindowStart,
+		key.Dimensions,
 		state.Operator,
 		state.Value,
+		state.Sum,
+		nil, // value_at is NULL for non first/last operators
+		nil, // sketch is NULL for non count_distinct operators
 		state.EventCount,
 		state.LastEventID,
 		state.UpdatedAt,
 	).WillReturnResult(sqlmock.NewResult(1, 1))
 
-	mock.ExpectExec(regexp.QuoteMeta(`
-		UPDATE sweep_checkpoints
-		SET checkpoint_cursor = $1, updated_at = $2
-		WHERE bucket_size = $3
-	`)).WithArgs(int64(11), sqlmock.AnyArg(), "1m").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(queryUpdateCheckpoint)).
+		WithArgs(int64(11), sqlmock.AnyArg(), "1m", 0, partition.Count).
+		WillReturnResult(sqlmock.NewResult(0, partition.Count))
+	mock.ExpectCommit()
+
+	err = adapter.Flush(context.Background(), map[aggregation.AggregateKey]aggregation.AggregateState{key: state}, 11, "1m")
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPreAggregateAdapter_FlushMergesDurableSketch(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	adapter := NewPreAggregateAdapter(db)
+	now := time.Now().UTC().Truncate(time.Second)
+
+	key := aggregation.AggregateKey{
+		PartitionID: 3,
+		PrincipalID: "user-1",
+		RuleName:    "distinct_endpoints",
+		BucketSize:  "1m",
+		WindowStart: now.Truncate(time.Minute),
+	}
+
+	durable := aggregation.NewSketch()
+	durable.Add("/a")
+	durable.Add("/b")
+	durableBytes, err := durable.MarshalBinary()
+	require.NoError(t, err)
+
+	incoming := aggregation.NewSketch()
+	incoming.Add("/b")
+	incoming.Add("/c")
+	state := aggregation.AggregateState{
+		Operator:        aggregation.OpCountDistinct,
+		Value:           decimal.NewFromInt(2),
+		Sketch:          incoming,
+		EventCount:      2,
+		LastEventID:     "evt-2",
+		RuleFingerprint: "fp-1",
+		UpdatedAt:       now,
+	}
+
+	want := durable.Clone()
+	want.Merge(incoming)
+	wantBytes, err := want.MarshalBinary()
+	require.NoError(t, err)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(querySelectCheckpointForUpdate)).
+		WithArgs("1m", 0, partition.Count).
+		WillReturnRows(checkpointRows(10, partition.Full()))
+	mock.ExpectQuery(regexp.QuoteMeta(querySelectRuleVersionsForBucket)).
+		WithArgs("1m").
+		WillReturnRows(sqlmock.NewRows([]string{"rule_name", "fingerprint"}))
+	upsert := mock.ExpectPrepare(regexp.QuoteMeta(queryUpsertPreAggregate))
+	mock.ExpectQuery(regexp.QuoteMeta(querySelectSketchForUpdate)).
+		WithArgs(key.PartitionID, key.PrincipalID, key.RuleName, "1m", key.WindowStart, key.Dimensions).
+		WillReturnRows(sqlmock.NewRows([]string{"sketch"}).AddRow(durableBytes))
+	upsert.ExpectExec().WithArgs(
+		key.PartitionID,
+		key.PrincipalID,
+		key.RuleName,
+		state.RuleFingerprint,
+		key.BucketSize,
+		key.WindowStart,
+		key.Dimensions,
+		state.Operator,
+		decimal.NewFromInt(3),
+		state.Sum,
+		nil,
+		wantBytes,
+		state.EventCount,
+		state.LastEventID,
+		state.UpdatedAt,
+	).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta(queryUpdateCheckpoint)).
+		WithArgs(int64(11), sqlmock.AnyArg(), "1m", 0, partition.Count).
+		WillReturnResult(sqlmock.NewResult(0, partition.Count))
 	mock.ExpectCommit()
 
 	err = adapter.Flush(context.Background(), map[aggregation.AggregateKey]aggregation.AggregateState{key: state}, 11, "1m")
@@ -137,8 +319,11 @@ func TestPreAggregateAdapter_FlushRejectsMixedBucketSizes(t *testing.T) {
 
 	mock.ExpectBegin()
 	mock.ExpectQuery(regexp.QuoteMeta(querySelectCheckpointForUpdate)).
+		WithArgs("1m", 0, partition.Count).
+		WillReturnRows(checkpointRows(0, partition.Full()))
+	mock.ExpectQuery(regexp.QuoteMeta(querySelectRuleVersionsForBucket)).
 		WithArgs("1m").
-		WillReturnRows(sqlmock.NewRows([]string{"checkpoint_cursor"}).AddRow(int64(0)))
+		WillReturnRows(sqlmock.NewRows([]string{"rule_name", "fingerprint"}))
 	mock.ExpectPrepare(regexp.QuoteMeta(queryUpsertPreAggregate))
 	mock.ExpectRollback()
 
@@ -167,19 +352,27 @@ func TestPreAggregateAdapter_QueryRangeUsesPartitionAndBucket(t *testing.T) {
 
 	rows := sqlmock.NewRows([]string{
 		"window_start",
+		"dimensions",
 		"operator",
 		"value",
+		"aux_sum",
+		"value_at",
+		"sketch",
 		"event_count",
 		"last_event_id",
 		"rule_fingerprint",
 		"updated_at",
-	}).AddRow(start, aggregation.OpCount, "3", int64(3), "evt-3", "fp-1", start.Add(time.Minute))
+	}).AddRow(start, `{"data.model":"gpt-4"}`, aggregation.OpCount, "3", "0", nil, nil, int64(3), "evt-3", "fp-1", start.Add(time.Minute))
 
 	mock.ExpectQuery(regexp.QuoteMeta(`
 		SELECT
 			window_start,
+			dimensions,
 			operator,
 			value,
+			aux_sum,
+			value_at,
+			sketch,
 			event_count,
 			last_event_id,
 			rule_fingerprint,
@@ -191,9 +384,9 @@ func TestPreAggregateAdapter_QueryRangeUsesPartitionAndBucket(t *testing.T) {
 		  AND bucket_size = $4
 		  AND window_start >= $5
 		  AND window_start < $6
-		ORDER BY window_start ASC
+		ORDER BY window_start ASC, dimensions ASC
 	`)).WithArgs(
-		0, // Fixed partition_id for single-tenant
+		partition.For("user-1"),
 		principalID,
 		ruleName,
 		"1m",
@@ -206,6 +399,7 @@ func TestPreAggregateAdapter_QueryRangeUsesPartitionAndBucket(t *testing.T) {
 	require.Len(t, result, 1)
 	require.Equal(t, "3", result[0].Value.String())
 	require.Equal(t, int64(3), result[0].EventCount)
+	require.Equal(t, `{"data.model":"gpt-4"}`, result[0].Dimensions)
 	require.NoError(t, mock.ExpectationsWereMet())
 }
 
@@ -224,8 +418,12 @@ func TestPreAggregateAdapter_QueryRangeDefaultsBucketSize(t *testing.T) {
 	mock.ExpectQuery(regexp.QuoteMeta(`
 		SELECT
 			window_start,
+			dimensions,
 			operator,
 			value,
+			aux_sum,
+			value_at,
+			sketch,
 			event_count,
 			last_event_id,
 			rule_fingerprint,
@@ -237,9 +435,9 @@ func TestPreAggregateAdapter_QueryRangeDefaultsBucketSize(t *testing.T) {
 		  AND bucket_size = $4
 		  AND window_start >= $5
 		  AND window_start < $6
-		ORDER BY window_start ASC
+		ORDER BY window_start ASC, dimensions ASC
 	`)).WithArgs(
-		0, // Fixed partition_id for single-tenant
+		partition.For("user-1"),
 		principalID,
 		ruleName,
 		"1m",
@@ -247,8 +445,12 @@ func TestPreAggregateAdapter_QueryRangeDefaultsBucketSize(t *testing.T) {
 		end,
 	).WillReturnRows(sqlmock.NewRows([]string{
 		"window_start",
+		"dimensions",
 		"operator",
 		"value",
+		"aux_sum",
+		"value_at",
+		"sketch",
 		"event_count",
 		"last_event_id",
 		"rule_fingerprint",
@@ -274,7 +476,7 @@ func TestPreAggregateAdapter_QueryRangeWithCheckpoint(t *testing.T) {
 	ruleName := "sum_bytes"
 
 	mock.ExpectQuery("WITH checkpoint AS").WithArgs(
-		0, // Fixed partition_id for single-tenant
+		partition.For("user-1"),
 		principalID,
 		ruleName,
 		"10m",
@@ -283,8 +485,12 @@ func TestPreAggregateAdapter_QueryRangeWithCheckpoint(t *testing.T) {
 	).WillReturnRows(sqlmock.NewRows([]string{
 		"checkpoint_cursor",
 		"window_start",
+		"dimensions",
 		"operator",
 		"value",
+		"aux_sum",
+		"value_at",
+		"sketch",
 		"event_count",
 		"last_event_id",
 		"rule_fingerprint",
@@ -292,8 +498,12 @@ func TestPreAggregateAdapter_QueryRangeWithCheckpoint(t *testing.T) {
 	}).AddRow(
 		int64(120),
 		start,
+		"",
 		aggregation.OpSum,
 		"8",
+		"0",
+		nil,
+		nil,
 		int64(2),
 		"evt-2",
 		"fp-1",
@@ -329,7 +539,7 @@ func TestPreAggregateAdapter_QueryRangeWithCheckpoint_EmptyRange(t *testing.T) {
 	ruleName := "count_requests"
 
 	mock.ExpectQuery("WITH checkpoint AS").WithArgs(
-		0, // Fixed partition_id for single-tenant
+		partition.For("user-1"),
 		principalID,
 		ruleName,
 		"1m",
@@ -338,8 +548,12 @@ func TestPreAggregateAdapter_QueryRangeWithCheckpoint_EmptyRange(t *testing.T) {
 	).WillReturnRows(sqlmock.NewRows([]string{
 		"checkpoint_cursor",
 		"window_start",
+		"dimensions",
 		"operator",
 		"value",
+		"aux_sum",
+		"value_at",
+		"sketch",
 		"event_count",
 		"last_event_id",
 		"rule_fingerprint",
@@ -353,6 +567,10 @@ func TestPreAggregateAdapter_QueryRangeWithCheckpoint_EmptyRange(t *testing.T) {
 		nil,
 		nil,
 		nil,
+		nil,
+		nil,
+		nil,
+		nil,
 	))
 
 	result, checkpoint, err := adapter.QueryRangeWithCheckpoint(
@@ -368,3 +586,367 @@ func TestPreAggregateAdapter_QueryRangeWithCheckpoint_EmptyRange(t *testing.T) {
 	require.Empty(t, result)
 	require.NoError(t, mock.ExpectationsWereMet())
 }
+
+func TestPreAggregateAdapter_FlushRejectsReplacedRuleFingerprint(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	adapter := NewPreAggregateAdapter(db)
+	now := time.Now().UTC().Truncate(time.Second)
+	key := aggregation.AggregateKey{
+		PrincipalID: "user-1",
+		RuleName:    "count_requests",
+		BucketSize:  "1m",
+		WindowStart: now.Truncate(time.Minute),
+	}
+	state := aggregation.AggregateState{
+		Operator:        aggregation.OpCount,
+		Value:           decimal.NewFromInt(1),
+		EventCount:      1,
+		RuleFingerprint: "fp-old",
+		UpdatedAt:       now,
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(querySelectCheckpointForUpdate)).
+		WithArgs("1m", 0, partition.Count).
+		WillReturnRows(checkpointRows(0, partition.Full()))
+	mock.ExpectQuery(regexp.QuoteMeta(querySelectRuleVersionsForBucket)).
+		WithArgs("1m").
+		WillReturnRows(sqlmock.NewRows([]string{"rule_name", "fingerprint"}).AddRow("count_requests", "fp-new"))
+	mock.ExpectRollback()
+
+	err = adapter.Flush(context.Background(), map[aggregation.AggregateKey]aggregation.AggregateState{key: state}, 1, "1m")
+	require.ErrorIs(t, err, aggregation.ErrRuleFingerprintMismatch)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPreAggregateAdapter_ReconcileRuleVersionKeepsMatchingFingerprint(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	adapter := NewPreAggregateAdapter(db)
+	updatedAt := time.Date(2026, 2, 7, 10, 0, 0, 0, time.UTC)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(querySelectCheckpointForUpdate)).
+		WithArgs("1h", 0, partition.Count).
+		WillReturnRows(checkpointRows(500, partition.Full()))
+	mock.ExpectQuery(regexp.QuoteMeta(querySelectRuleVersionForUpdate)).
+		WithArgs("count_requests", "1h").
+		WillReturnRows(sqlmock.NewRows([]string{"fingerprint", "rebuild_cursor", "rebuild_target", "partition_targets", "updated_at"}).
+			AddRow("fp-1", int64(0), int64(0), nil, updatedAt))
+	mock.ExpectRollback()
+
+	version, err := adapter.ReconcileRuleVersion(context.Background(), "count_requests", "1h", "fp-1")
+	require.NoError(t, err)
+	require.False(t, version.Rebuilding())
+	require.Equal(t, "fp-1", version.Fingerprint)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPreAggregateAdapter_ReconcileRuleVersionStartsRebuildOnDrift(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	adapter := NewPreAggregateAdapter(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(querySelectCheckpointForUpdate)).
+		WithArgs("1m", 0, partition.Count).
+		WillReturnRows(checkpointRows(500, partition.Full()))
+	mock.ExpectQuery(regexp.QuoteMeta(querySelectRuleVersionForUpdate)).
+		WithArgs("count_requests", "1m").
+		WillReturnRows(sqlmock.NewRows([]string{"fingerprint", "rebuild_cursor", "rebuild_target", "partition_targets", "updated_at"}).
+			AddRow("fp-old", int64(0), int64(0), nil, time.Now()))
+	mock.ExpectExec(regexp.QuoteMeta(queryDeleteRulePreAggregates)).
+		WithArgs("count_requests", "1m").
+		WillReturnResult(sqlmock.NewResult(0, 42))
+	mock.ExpectExec(regexp.QuoteMeta(queryUpsertRuleVersion)).
+		WithArgs("count_requests", "1m", "fp-new", int64(500), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	version, err := adapter.ReconcileRuleVersion(context.Background(), "count_requests", "1m", "fp-new")
+	require.NoError(t, err)
+	require.True(t, version.Rebuilding())
+	require.Equal(t, int64(500), version.RebuildTarget)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPreAggregateAdapter_FlushRebuildRejectsReplacedVersion(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	adapter := NewPreAggregateAdapter(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(querySelectRuleVersionForUpdate)).
+		WithArgs("count_requests", "1m").
+		WillReturnRows(sqlmock.NewRows([]string{"fingerprint", "rebuild_cursor", "rebuild_target", "partition_targets", "updated_at"}).
+			AddRow("fp-newer", int64(0), int64(900), nil, time.Now()))
+	mock.ExpectRollback()
+
+	err = adapter.FlushRebuild(context.Background(), nil, aggregation.RuleVersion{
+		RuleName:      "count_requests",
+		BucketSize:    "1m",
+		Fingerprint:   "fp-new",
+		RebuildTarget: 500,
+	}, 100)
+	require.ErrorIs(t, err, aggregation.ErrRuleFingerprintMismatch)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPreAggregateAdapter_QueryRangesWithCheckpoint(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	adapter := NewPreAggregateAdapter(db)
+
+	start := time.Date(2026, 2, 7, 10, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+	principals := []string{"user-1", "user-2"}
+	rules := []string{"count_requests", "sum_bytes"}
+	partition1 := partition.For("user-1")
+	partition2 := partition.For("user-2")
+	require.NotEqual(t, partition1, partition2)
+
+	// Partitions are passed in ascending order; user-2 has no pre-aggregates in range.
+	partitionIDs := []int64{int64(partition1), int64(partition2)}
+	if partition2 < partition1 {
+		partitionIDs = []int64{int64(partition2), int64(partition1)}
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta(queryRangesPreAggregatesWithCheckpoint)).WithArgs(
+		"1m",
+		pq.Array(partitionIDs),
+		pq.Array(principals),
+		pq.Array(rules),
+		start,
+		end,
+	).WillReturnRows(sqlmock.NewRows([]string{
+		"partition_id", "checkpoint_cursor", "principal_id", "rule_name",
+		"window_start", "dimensions", "operator", "value", "aux_sum", "value_at", "sketch",
+		"event_count", "last_event_id", "rule_fingerprint", "updated_at",
+	}).
+		AddRow(partition1, int64(120), "user-1", "count_requests",
+			start, "", aggregation.OpCount, "3", "0", nil, nil, int64(3), "evt-3", "fp-1", start).
+		AddRow(partition1, int64(120), "user-1", "sum_bytes",
+			start, "", aggregation.OpSum, "512", "0", nil, nil, int64(2), "evt-2", "fp-2", start).
+		AddRow(partition2, int64(80), nil, nil,
+			nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil))
+
+	ranges, err := adapter.QueryRangesWithCheckpoint(context.Background(), principals, rules, "1m", start, end)
+	require.NoError(t, err)
+	require.Len(t, ranges, 4)
+
+	counted := ranges[aggregation.RangeScope{PrincipalID: "user-1", RuleName: "count_requests"}]
+	require.Equal(t, int64(120), counted.Checkpoint)
+	require.Len(t, counted.States, 1)
+	require.Equal(t, "3", counted.States[0].Value.String())
+
+	summed := ranges[aggregation.RangeScope{PrincipalID: "user-1", RuleName: "sum_bytes"}]
+	require.Len(t, summed.States, 1)
+	require.Equal(t, "512", summed.States[0].Value.String())
+
+	empty := ranges[aggregation.RangeScope{PrincipalID: "user-2", RuleName: "sum_bytes"}]
+	require.Equal(t, int64(80), empty.Checkpoint)
+	require.Empty(t, empty.States)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPreAggregateAdapter_ScanRuleRangeWithCheckpoints(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	adapter := NewPreAggregateAdapter(db)
+
+	start := time.Date(2026, 2, 7, 10, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+
+	rows := sqlmock.NewRows([]string{
+		"partition_id", "checkpoint_cursor", "principal_id",
+		"window_start", "dimensions", "operator", "value", "aux_sum", "value_at", "sketch",
+		"event_count", "last_event_id", "rule_fingerprint", "updated_at",
+	})
+	for p := 0; p < partition.Count; p++ {
+		if p == partition.For("user-1") {
+			rows.AddRow(p, int64(90), "user-1", start, "", aggregation.OpSum, "7", "0", nil, nil, int64(2), "evt-2", "fp-1", start)
+			continue
+		}
+		rows.AddRow(p, int64(100), nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+	}
+	mock.ExpectQuery(regexp.QuoteMeta(queryRulePreAggregatesWithCheckpoints)).
+		WithArgs("sum_tokens", "1h", start, end, partition.Count).
+		WillReturnRows(rows)
+
+	scanned := make(map[string]aggregation.AggregateState)
+	checkpoints, err := adapter.ScanRuleRangeWithCheckpoints(context.Background(), "sum_tokens", "1h", start, end,
+		func(principalID string, state aggregation.AggregateState) {
+			scanned[principalID] = state
+		})
+	require.NoError(t, err)
+	require.Len(t, scanned, 1)
+	require.Equal(t, "7", scanned["user-1"].Value.String())
+	require.Equal(t, int64(90), checkpoints.Cursor(partition.For("user-1")))
+	require.Equal(t, int64(90), checkpoints.Min())
+	require.Equal(t, int64(100), checkpoints.Max())
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestBuildRankRulePreAggregatesQuery(t *testing.T) {
+	query, err := buildRankRulePreAggregatesQuery(aggregation.OpMax, false)
+	require.NoError(t, err)
+	require.Contains(t, query, "MAX(value) AS value,")
+	require.Contains(t, query, "GROUP BY partition_id, principal_id")
+	require.Contains(t, query, `ORDER BY value DESC, principal_id COLLATE "C" ASC`)
+	require.Contains(t, query, "LIMIT $7")
+	require.Contains(t, query, "OR principal_id = ANY($8::text[])")
+
+	query, err = buildRankRulePreAggregatesQuery(aggregation.OpFirst, true)
+	require.NoError(t, err)
+	require.Contains(t, query, "(ARRAY_AGG(value ORDER BY value_at ASC, window_start ASC))[1] AS value,")
+	require.Contains(t, query, "MIN(value_at) AS value_at,")
+	require.Contains(t, query, `ORDER BY value ASC, principal_id COLLATE "C" ASC`)
+
+	_, err = buildRankRulePreAggregatesQuery(aggregation.OpQuantile, false)
+	require.Error(t, err)
+}
+
+func TestPreAggregateAdapter_RankRuleRangeWithCheckpoints(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	adapter := NewPreAggregateAdapter(db)
+
+	start := time.Date(2026, 2, 7, 10, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+
+	rows := sqlmock.NewRows([]string{
+		"partition_id", "checkpoint_cursor", "stale", "principal_id",
+		"value", "aux_sum", "value_at", "event_count", "updated_at",
+	})
+	for p := 0; p < partition.Count; p++ {
+		if p == partition.For("user-1") {
+			rows.AddRow(p, int64(90), false, "user-1", "70", "0", nil, int64(4), start)
+			continue
+		}
+		rows.AddRow(p, int64(100), false, nil, nil, nil, nil, nil, nil)
+	}
+	statement, err := buildRankRulePreAggregatesQuery(aggregation.OpSum, false)
+	require.NoError(t, err)
+	mock.ExpectQuery(regexp.QuoteMeta(statement)).
+		WithArgs("sum_tokens", "1h", start, end, partition.Count, "fp-1", 12, pq.Array([]string{"user-2"})).
+		WillReturnRows(rows)
+
+	ranking, err := adapter.RankRuleRangeWithCheckpoints(context.Background(), aggregation.RankQuery{
+		RuleName:        "sum_tokens",
+		Operator:        aggregation.OpSum,
+		RuleFingerprint: "fp-1",
+		BucketSize:      "1h",
+		Start:           start,
+		End:             end,
+		Limit:           12,
+		PrincipalIDs:    []string{"user-2"},
+	})
+	require.NoError(t, err)
+	require.False(t, ranking.Stale)
+	require.Len(t, ranking.Totals, 1)
+	require.Equal(t, "70", ranking.Totals["user-1"].Value.String())
+	require.Equal(t, int64(4), ranking.Totals["user-1"].EventCount)
+	require.Equal(t, aggregation.OpSum, ranking.Totals["user-1"].Operator)
+	require.Equal(t, int64(90), ranking.Checkpoints.Cursor(partition.For("user-1")))
+	require.Equal(t, int64(100), ranking.Checkpoints.Max())
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPreAggregateAdapter_RepairAggregatesReplacesRows(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	adapter := NewPreAggregateAdapter(db)
+	now := time.Now().UTC().Truncate(time.Second)
+	mismatched := aggregation.AggregateKey{
+		PartitionID: partition.For("user-1"),
+		PrincipalID: "user-1",
+		RuleName:    "count_requests",
+		BucketSize:  "1m",
+		WindowStart: now.Truncate(time.Minute),
+	}
+	unexpected := mismatched
+	unexpected.WindowStart = mismatched.WindowStart.Add(time.Minute)
+	state := aggregation.AggregateState{
+		Operator:        aggregation.OpCount,
+		Value:           decimal.NewFromInt(3),
+		EventCount:      3,
+		LastEventID:     "evt-3",
+		RuleFingerprint: "fp-1",
+		UpdatedAt:       now,
+	}
+	p := mismatched.PartitionID
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(querySelectCheckpointForUpdate)).
+		WithArgs("1m", p, p+1).
+		WillReturnRows(checkpointRows(42, partition.Range{From: p, To: p + 1}))
+	mock.ExpectQuery(regexp.QuoteMeta(querySelectRuleVersionsForBucket)).
+		WithArgs("1m").
+		WillReturnRows(sqlmock.NewRows([]string{"rule_name", "fingerprint"}).AddRow("count_requests", "fp-1"))
+	del := mock.ExpectPrepare(regexp.QuoteMeta(queryDeletePreAggregate))
+	del.ExpectExec().
+		WithArgs(p, "user-1", "count_requests", "1m", mismatched.WindowStart, "").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	del.ExpectExec().
+		WithArgs(p, "user-1", "count_requests", "1m", unexpected.WindowStart, "").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	upsert := mock.ExpectPrepare(regexp.QuoteMeta(queryUpsertPreAggregate))
+	upsert.ExpectExec().WithArgs(
+		p, "user-1", "count_requests", "fp-1", "1m", mismatched.WindowStart, "",
+		aggregation.OpCount, state.Value, state.Sum, nil, nil, int64(3), "evt-3", now,
+	).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	err = adapter.RepairAggregates(
+		context.Background(),
+		"1m",
+		map[int]int64{p: 42},
+		[]aggregation.AggregateKey{mismatched, unexpected},
+		map[aggregation.AggregateKey]aggregation.AggregateState{mismatched: state},
+	)
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPreAggregateAdapter_RepairAggregatesRejectsMovedCheckpoint(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	adapter := NewPreAggregateAdapter(db)
+	key := aggregation.AggregateKey{
+		PartitionID: partition.For("user-1"),
+		PrincipalID: "user-1",
+		RuleName:    "count_requests",
+		BucketSize:  "1m",
+		WindowStart: time.Now().UTC().Truncate(time.Minute),
+	}
+	p := key.PartitionID
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(querySelectCheckpointForUpdate)).
+		WithArgs("1m", p, p+1).
+		WillReturnRows(checkpointRows(43, partition.Range{From: p, To: p + 1}))
+	mock.ExpectRollback()
+
+	err = adapter.RepairAggregates(context.Background(), "1m", map[int]int64{p: 42}, []aggregation.AggregateKey{key}, nil)
+	require.ErrorIs(t, err, aggregation.ErrCheckpointMoved)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
@@ -1,19 +1,31 @@
 package postgres
 
+import (
+	"fmt"
+	"strings"
+)
+
 // SQL queries for event storage operations with principal tracking
 
 const (
 	// querySaveEvent inserts an event with principal idempotency.
-	// Uses composite key (principal_id, id) to prevent duplicate events.
+	// The key (principal_id, id) is claimed in event_keys first: a unique key of the
+	// partitioned events table would have to include ingested_at. The event row is only
+	// inserted for a claimed key, so a duplicate returns no rows (sql.ErrNoRows).
 	// RETURNING clause retrieves auto-generated ingest_seq for cursor tracking.
-	// ON CONFLICT DO NOTHING returns no rows (sql.ErrNoRows) for duplicates.
 	querySaveEvent = `
+		WITH claimed AS (
+			INSERT INTO event_keys (principal_id, id, ingested_at)
+			VALUES ($2, $1, $6)
+			ON CONFLICT (principal_id, id) DO NOTHING
+			RETURNING principal_id
+		)
 		INSERT INTO events (
 			id, principal_id, type, schema_version,
 			occurred_at, ingested_at, metadata, data
 		)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-		ON CONFLICT (principal_id, id) DO NOTHING
+		SELECT $1, $2, $3::text, $4::integer, $5::timestamptz, $6, $7::jsonb, $8::jsonb
+		FROM claimed
 		RETURNING ingest_seq
 	`
 
@@ -31,6 +43,20 @@ const (
 		LIMIT $2
 	`
 
+	// queryRetrievePartitionEventsAfterCursor fetches events of one partition range after a
+	// cursor. Used by partition-sharded aggregation; served by idx_events_partition_seq.
+	queryRetrievePartitionEventsAfterCursor = `
+		SELECT
+			id, principal_id, type, schema_version,
+			occurred_at, ingested_at, metadata, data, ingest_seq
+		FROM events
+		WHERE ingest_seq > $1
+		  AND partition_id >= $2
+		  AND partition_id < $3
+		ORDER BY ingest_seq ASC
+		LIMIT $4
+	`
+
 	// queryRetrieveEventsAfter - DEPRECATED: Use queryRetrieveEventsAfterCursor
 	// Kept for backwards compatibility during migration.
 	queryRetrieveEventsAfter = `
@@ -57,6 +83,23 @@ const (
 		LIMIT $4
 	`
 
+	// queryFirstIngestSeqAfter finds the first event of a principal ingested after a time.
+	queryFirstIngestSeqAfter = `
+		SELECT COALESCE(MIN(ingest_seq), 0)
+		FROM events
+		WHERE principal_id = $1
+		  AND ingested_at > $2
+	`
+
+	// queryFirstTypeIngestSeqAfter finds the first event of a type ingested after a time.
+	// Served by idx_events_type_ingested.
+	queryFirstTypeIngestSeqAfter = `
+		SELECT COALESCE(MIN(ingest_seq), 0)
+		FROM events
+		WHERE type = $1
+		  AND ingested_at > $2
+	`
+
 	// queryRetrieveScopedEventsAfterCursor fetches unflushed events for one query scope.
 	// Used by projection hybrid read path to merge pre-aggregates with tail raw events.
 	queryRetrieveScopedEventsAfterCursor = `
@@ -72,4 +115,130 @@ const (
 		ORDER BY ingest_seq ASC
 		LIMIT $6
 	`
+
+	// queryRetrieveScopedEventsAfterCursorWithIngested also returns the events ingested in the
+	// range, for rules that bucket late events by ingestion time.
+	queryRetrieveScopedEventsAfterCursorWithIngested = `
+		SELECT
+			id, principal_id, type, schema_version,
+			occurred_at, ingested_at, metadata, data, ingest_seq
+		FROM events
+		WHERE ingest_seq > $1
+		  AND principal_id = $2
+		  AND type = $3
+		  AND ((occurred_at >= $4 AND occurred_at < $5)
+		    OR (ingested_at >= $4 AND ingested_at < $5))
+		ORDER BY ingest_seq ASC
+		LIMIT $6
+	`
+
+	// queryRetrieveTypeScopedEventsAfterCursor is queryRetrieveScopedEventsAfterCursor for
+	// every principal, used by leaderboard queries. Served by idx_events_type_seq.
+	queryRetrieveTypeScopedEventsAfterCursor = `
+		SELECT
+			id, principal_id, type, schema_version,
+			occurred_at, ingested_at, metadata, data, ingest_seq
+		FROM events
+		WHERE ingest_seq > $1
+		  AND type = $2
+		  AND occurred_at >= $3
+		  AND occurred_at < $4
+		ORDER BY ingest_seq ASC
+		LIMIT $5
+	`
+
+	// queryRetrieveTypeScopedEventsAfterCursorWithIngested also returns the events ingested in the
+	// range, for rules that bucket late events by ingestion time.
+	queryRetrieveTypeScopedEventsAfterCursorWithIngested = `
+		SELECT
+			id, principal_id, type, schema_version,
+			occurred_at, ingested_at, metadata, data, ingest_seq
+		FROM events
+		WHERE ingest_seq > $1
+		  AND type = $2
+		  AND ((occurred_at >= $3 AND occurred_at < $4)
+		    OR (ingested_at >= $3 AND ingested_at < $4))
+		ORDER BY ingest_seq ASC
+		LIMIT $5
+	`
+
+	// queryRetrieveBatchScopedEventsAfterCursor is queryRetrieveScopedEventsAfterCursor for
+	// a list of principals, used by batch state queries.
+	queryRetrieveBatchScopedEventsAfterCursor = `
+		SELECT
+			id, principal_id, type, schema_version,
+			occurred_at, ingested_at, metadata, data, ingest_seq
+		FROM events
+		WHERE ingest_seq > $1
+		  AND principal_id = ANY($2::text[])
+		  AND type = $3
+		  AND occurred_at >= $4
+		  AND occurred_at < $5
+		ORDER BY ingest_seq ASC
+		LIMIT $6
+	`
+
+	// queryRetrieveBatchScopedEventsAfterCursorWithIngested also returns the events ingested in the
+	// range, for rules that bucket late events by ingestion time.
+	queryRetrieveBatchScopedEventsAfterCursorWithIngested = `
+		SELECT
+			id, principal_id, type, schema_version,
+			occurred_at, ingested_at, metadata, data, ingest_seq
+		FROM events
+		WHERE ingest_seq > $1
+		  AND principal_id = ANY($2::text[])
+		  AND type = $3
+		  AND ((occurred_at >= $4 AND occurred_at < $5)
+		    OR (ingested_at >= $4 AND ingested_at < $5))
+		ORDER BY ingest_seq ASC
+		LIMIT $6
+	`
 )
+
+// saveEventsColumnCount is the number of bind parameters per row in the batch insert.
+const saveEventsColumnCount = 8
+
+// buildSaveEventsQuery returns a multi-row insert for rowCount events.
+// Same idempotency semantics as querySaveEvent: conflicting rows are skipped and
+// only inserted rows are returned, keyed by (principal_id, id) so callers can map
+// each ingest_seq back to its input event. Of rows repeating a key within the batch,
+// the first is inserted; ingest_seq follows input order.
+func buildSaveEventsQuery(rowCount int) string {
+	var b strings.Builder
+	b.WriteString(`
+		WITH input (ord, id, principal_id, type, schema_version, occurred_at, ingested_at, metadata, data) AS (
+			VALUES `)
+	for i := 0; i < rowCount; i++ {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		base := i * saveEventsColumnCount
+		fmt.Fprintf(&b, "(%d, $%d::text, $%d::text, $%d::text, $%d::integer, $%d::timestamptz, $%d::timestamptz, $%d::jsonb, $%d::jsonb)",
+			i, base+1, base+2, base+3, base+4, base+5, base+6, base+7, base+8)
+	}
+	b.WriteString(`
+		),
+		deduped AS (
+			SELECT DISTINCT ON (principal_id, id) *
+			FROM input
+			ORDER BY principal_id, id, ord
+		),
+		claimed AS (
+			INSERT INTO event_keys (principal_id, id, ingested_at)
+			SELECT principal_id, id, ingested_at FROM deduped
+			ON CONFLICT (principal_id, id) DO NOTHING
+			RETURNING principal_id, id
+		)
+		INSERT INTO events (
+			id, principal_id, type, schema_version,
+			occurred_at, ingested_at, metadata, data
+		)
+		SELECT f.id, f.principal_id, f.type, f.schema_version,
+			f.occurred_at, f.ingested_at, f.metadata, f.data
+		FROM deduped f
+		JOIN claimed c ON c.principal_id = f.principal_id AND c.id = f.id
+		ORDER BY f.ord
+		RETURNING principal_id, id, ingest_seq
+	`)
+	return b.String()
+}
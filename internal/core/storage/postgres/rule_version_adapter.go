@@ -0,0 +1,249 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/aevon-lab/project-aevon/internal/core/aggregation"
+	"github.com/aevon-lab/project-aevon/internal/core/partition"
+	"github.com/lib/pq"
+)
+
+const (
+	querySelectRuleVersionsForBucket = `
+		SELECT rule_name, fingerprint
+		FROM rule_versions
+		WHERE bucket_size = $1
+	`
+
+	querySelectRuleVersionForUpdate = `
+		SELECT fingerprint, rebuild_cursor, rebuild_target, partition_targets, updated_at
+		FROM rule_versions
+		WHERE rule_name = $1
+		  AND bucket_size = $2
+		FOR UPDATE
+	`
+
+	queryUpsertRuleVersion = `
+		INSERT INTO rule_versions (
+			rule_name, bucket_size, fingerprint, rebuild_cursor, rebuild_target, partition_targets, updated_at
+		) VALUES ($1, $2, $3, 0, $4, $5, $6)
+		ON CONFLICT (rule_name, bucket_size)
+		DO UPDATE SET
+			fingerprint       = EXCLUDED.fingerprint,
+			rebuild_cursor    = 0,
+			rebuild_target    = EXCLUDED.rebuild_target,
+			partition_targets = EXCLUDED.partition_targets,
+			updated_at        = EXCLUDED.updated_at
+	`
+
+	queryDeleteRulePreAggregates = `
+		DELETE FROM pre_aggregates
+		WHERE rule_name = $1
+		  AND bucket_size = $2
+	`
+
+	queryUpdateRebuildCursor = `
+		UPDATE rule_versions
+		SET rebuild_cursor = $1, updated_at = $2
+		WHERE rule_name = $3
+		  AND bucket_size = $4
+	`
+
+	queryListRuleVersions = `
+		SELECT rule_name, bucket_size, fingerprint, rebuild_cursor, rebuild_target, partition_targets, updated_at
+		FROM rule_versions
+		WHERE $1 = '' OR rule_name = $1
+		ORDER BY rule_name ASC, bucket_size ASC
+	`
+)
+
+// ReconcileRuleVersion makes fingerprint the active version of ruleName at bucketSize.
+// The bucket checkpoint rows are locked first, so no scheduler flush can interleave between
+// deleting the drifted aggregates and fixing the rebuild targets.
+func (a *PreAggregateAdapter) ReconcileRuleVersion(
+	ctx context.Context,
+	ruleName string,
+	bucketSize string,
+	fingerprint string,
+) (aggregation.RuleVersion, error) {
+	if bucketSize == "" {
+		bucketSize = defaultBucketSize
+	}
+	version := aggregation.RuleVersion{RuleName: ruleName, BucketSize: bucketSize, Fingerprint: fingerprint}
+
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return version, fmt.Errorf("reconcile rule version: begin tx: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	now := time.Now().UTC()
+	checkpoints, err := lockCheckpoints(ctx, tx, bucketSize, partition.Full())
+	if err != nil {
+		return version, fmt.Errorf("reconcile rule version: %w", err)
+	}
+	checkpoint := checkpoints.Max()
+	var partitionTargets []int64
+	if checkpoints.Min() != checkpoint {
+		partitionTargets = checkpoints.Cursors
+	}
+
+	active, err := scanRuleVersion(tx.QueryRowContext(ctx, querySelectRuleVersionForUpdate, ruleName, bucketSize))
+	switch {
+	case err == sql.ErrNoRows:
+		// A rule new to a bucket has missed every event the checkpoint already covers.
+	case err != nil:
+		return version, fmt.Errorf("reconcile rule version: read rule version: %w", err)
+	case active.Fingerprint == fingerprint:
+		active.RuleName = ruleName
+		active.BucketSize = bucketSize
+		return active, nil
+	}
+
+	if _, err := tx.ExecContext(ctx, queryDeleteRulePreAggregates, ruleName, bucketSize); err != nil {
+		return version, fmt.Errorf("reconcile rule version: delete drifted aggregates: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, queryUpsertRuleVersion,
+		ruleName, bucketSize, fingerprint, checkpoint, pq.Array(partitionTargets), now,
+	); err != nil {
+		return version, fmt.Errorf("reconcile rule version: write rule version: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return version, fmt.Errorf("reconcile rule version: commit: %w", err)
+	}
+
+	version.RebuildTarget = checkpoint
+	version.PartitionTargets = partitionTargets
+	version.UpdatedAt = now
+	slog.Info("[PreAggregateAdapter] Rule version changed",
+		"rule", ruleName,
+		"bucket_size", bucketSize,
+		"previous_fingerprint", active.Fingerprint,
+		"rebuild_target", checkpoint,
+	)
+	return version, nil
+}
+
+// ListRuleVersions returns the versions of ruleName, or of every rule if ruleName is empty.
+func (a *PreAggregateAdapter) ListRuleVersions(ctx context.Context, ruleName string) ([]aggregation.RuleVersion, error) {
+	rows, err := a.db.QueryContext(ctx, queryListRuleVersions, ruleName)
+	if err != nil {
+		return nil, fmt.Errorf("list rule versions: %w", err)
+	}
+	defer rows.Close()
+
+	var versions []aggregation.RuleVersion
+	for rows.Next() {
+		var v aggregation.RuleVersion
+		var targets pq.Int64Array
+		if err := rows.Scan(&v.RuleName, &v.BucketSize, &v.Fingerprint, &v.RebuildCursor, &v.RebuildTarget, &targets, &v.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("list rule versions: scan row: %w", err)
+		}
+		v.PartitionTargets = targets
+		versions = append(versions, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list rule versions: iterate rows: %w", err)
+	}
+	return versions, nil
+}
+
+// FlushRebuild upserts rebuilt aggregates of one rule version and advances its rebuild
+// cursor in one transaction. Stale cursors are skipped like in Flush.
+func (a *PreAggregateAdapter) FlushRebuild(
+	ctx context.Context,
+	aggregates map[aggregation.AggregateKey]aggregation.AggregateState,
+	version aggregation.RuleVersion,
+	cursor int64,
+) error {
+	bucketSize := version.BucketSize
+	if bucketSize == "" {
+		bucketSize = defaultBucketSize
+	}
+
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("rebuild flush: begin tx: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	active, err := scanRuleVersion(tx.QueryRowContext(ctx, querySelectRuleVersionForUpdate, version.RuleName, bucketSize))
+	if err == sql.ErrNoRows || (err == nil && active.Fingerprint != version.Fingerprint) {
+		return fmt.Errorf("rebuild flush: rule %s (bucket=%s): %w", version.RuleName, bucketSize, aggregation.ErrRuleFingerprintMismatch)
+	}
+	if err != nil {
+		return fmt.Errorf("rebuild flush: read rule version for update: %w", err)
+	}
+
+	if cursor <= active.RebuildCursor {
+		slog.Warn("[PreAggregateAdapter] Skipping stale/no-op rebuild flush",
+			"rule", version.RuleName,
+			"cursor", cursor,
+			"rebuild_cursor", active.RebuildCursor,
+		)
+		return nil
+	}
+
+	if err := upsertAggregates(ctx, tx, aggregates, bucketSize); err != nil {
+		return fmt.Errorf("rebuild flush: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, queryUpdateRebuildCursor, cursor, time.Now().UTC(), version.RuleName, bucketSize); err != nil {
+		return fmt.Errorf("rebuild flush: write rebuild cursor: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("rebuild flush: commit: %w", err)
+	}
+	return nil
+}
+
+// scanRuleVersion reads one row of querySelectRuleVersionForUpdate.
+func scanRuleVersion(row *sql.Row) (aggregation.RuleVersion, error) {
+	var v aggregation.RuleVersion
+	var targets pq.Int64Array
+	err := row.Scan(&v.Fingerprint, &v.RebuildCursor, &v.RebuildTarget, &targets, &v.UpdatedAt)
+	v.PartitionTargets = targets
+	return v, err
+}
+
+// checkRuleFingerprints rejects aggregates computed with a rule definition other than
+// the active rule version, e.g. a batch that started before a rule reload.
+func checkRuleFingerprints(
+	ctx context.Context,
+	tx *sql.Tx,
+	aggregates map[aggregation.AggregateKey]aggregation.AggregateState,
+	bucketSize string,
+) error {
+	if len(aggregates) == 0 {
+		return nil
+	}
+
+	rows, err := tx.QueryContext(ctx, querySelectRuleVersionsForBucket, bucketSize)
+	if err != nil {
+		return fmt.Errorf("read rule versions: %w", err)
+	}
+	defer rows.Close()
+
+	active := make(map[string]string)
+	for rows.Next() {
+		var ruleName, fingerprint string
+		if err := rows.Scan(&ruleName, &fingerprint); err != nil {
+			return fmt.Errorf("read rule versions: scan row: %w", err)
+		}
+		active[ruleName] = fingerprint
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("read rule versions: iterate rows: %w", err)
+	}
+
+	for key, state := range aggregates {
+		fingerprint, ok := active[key.RuleName]
+		if ok && fingerprint != state.RuleFingerprint {
+			return fmt.Errorf("rule %s (bucket=%s): %w", key.RuleName, bucketSize, aggregation.ErrRuleFingerprintMismatch)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+
+	"github.com/aevon-lab/project-aevon/internal/core/aggregation"
+)
+
+const (
+	queryDeleteShadowAggregates = `
+		DELETE FROM pre_aggregate_shadows
+		WHERE rebuild_id = $1
+	`
+
+	queryInsertShadowAggregate = `
+		INSERT INTO pre_aggregate_shadows (
+			rebuild_id, partition_id, principal_id, rule_name, rule_fingerprint,
+			bucket_size, window_start, dimensions, operator, value, aux_sum, value_at, sketch,
+			event_count, last_event_id, updated_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
+	`
+
+	// queryDeleteRangePreAggregates is served by idx_pre_aggregates_rule_window.
+	queryDeleteRangePreAggregates = `
+		DELETE FROM pre_aggregates
+		WHERE rule_name = $1
+		  AND bucket_size = $2
+		  AND window_start >= $3
+		  AND window_start < $4
+	`
+
+	queryInsertPreAggregatesFromShadow = `
+		INSERT INTO pre_aggregates (
+			partition_id, principal_id, rule_name, rule_fingerprint,
+			bucket_size, window_start, dimensions, operator, value, aux_sum, value_at, sketch,
+			event_count, last_event_id, updated_at
+		)
+		SELECT
+			partition_id, principal_id, rule_name, rule_fingerprint,
+			bucket_size, window_start, dimensions, operator, value, aux_sum, value_at, sketch,
+			event_count, last_event_id, updated_at
+		FROM pre_aggregate_shadows
+		WHERE rebuild_id = $1
+	`
+)
+
+// StageShadowAggregates replaces the shadow rows of rebuildID with aggregates in one
+// transaction.
+func (a *PreAggregateAdapter) StageShadowAggregates(
+	ctx context.Context,
+	rebuildID string,
+	bucketSize string,
+	aggregates map[aggregation.AggregateKey]aggregation.AggregateState,
+) error {
+	if bucketSize == "" {
+		bucketSize = defaultBucketSize
+	}
+
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("stage shadow aggregates: begin tx: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	if _, err := tx.ExecContext(ctx, queryDeleteShadowAggregates, rebuildID); err != nil {
+		return fmt.Errorf("stage shadow aggregates: delete previous rows: %w", err)
+	}
+
+	insertStmt, err := tx.PrepareContext(ctx, queryInsertShadowAggregate)
+	if err != nil {
+		return fmt.Errorf("stage shadow aggregates: prepare insert: %w", err)
+	}
+	defer insertStmt.Close()
+
+	for _, key := range sortedAggregateKeys(aggregates) {
+		state := aggregates[key]
+		if key.BucketSize != "" && key.BucketSize != bucketSize {
+			return fmt.Errorf("stage shadow aggregates: aggregate bucket mismatch: expected %s, got %s for key %v",
+				bucketSize, key.BucketSize, key)
+		}
+		sketch, err := marshalSketch(state)
+		if err != nil {
+			return fmt.Errorf("stage shadow aggregates: %v: %w", key, err)
+		}
+		if _, err := insertStmt.ExecContext(ctx,
+			rebuildID,
+			key.PartitionID,
+			key.PrincipalID,
+			key.RuleName,
+			state.RuleFingerprint,
+			bucketSize,
+			key.WindowStart,
+			key.Dimensions,
+			state.Operator,
+			state.Value,
+			state.Sum,
+			nullTime(state.ValueAt),
+			sketch,
+			state.EventCount,
+			state.LastEventID,
+			state.UpdatedAt,
+		); err != nil {
+			return fmt.Errorf("stage shadow aggregates: insert %v: %w", key, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("stage shadow aggregates: commit: %w", err)
+	}
+	return nil
+}
+
+// SwapShadowAggregates replaces the live pre-aggregates of swap's range with its shadow
+// rows. Every checkpoint row of the bucket size is locked first, like a flush locks
+// them, so no flush can merge into the range between the checkpoint check and the swap.
+func (a *PreAggregateAdapter) SwapShadowAggregates(ctx context.Context, swap aggregation.ShadowSwap) (int64, error) {
+	bucketSize := swap.BucketSize
+	if bucketSize == "" {
+		bucketSize = defaultBucketSize
+	}
+
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("swap shadow aggregates: begin tx: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	durable, err := lockCheckpoints(ctx, tx, bucketSize, swap.Checkpoints.Range)
+	if err != nil {
+		return 0, fmt.Errorf("swap shadow aggregates: %w", err)
+	}
+	for i, durableCursor := range durable.Cursors {
+		if durableCursor != swap.Checkpoints.Cursors[i] {
+			return 0, fmt.Errorf("swap shadow aggregates: partition %d at %d, rebuilt up to %d: %w",
+				swap.Checkpoints.Range.From+i, durableCursor, swap.Checkpoints.Cursors[i], aggregation.ErrCheckpointMoved)
+		}
+	}
+
+	// The rule rebuilder merges into the rule's rows while it replays a changed rule.
+	active, err := scanRuleVersion(tx.QueryRowContext(ctx, querySelectRuleVersionForUpdate, swap.RuleName, bucketSize))
+	switch {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		return 0, fmt.Errorf("swap shadow aggregates: read rule version: %w", err)
+	case active.Fingerprint != swap.RuleFingerprint:
+		return 0, fmt.Errorf("swap shadow aggregates: rule %s (bucket=%s): %w",
+			swap.RuleName, bucketSize, aggregation.ErrRuleFingerprintMismatch)
+	case active.Rebuilding():
+		return 0, fmt.Errorf("swap shadow aggregates: rule %s (bucket=%s): %w",
+			swap.RuleName, bucketSize, aggregation.ErrRuleRebuilding)
+	}
+
+	result, err := tx.ExecContext(ctx, queryDeleteRangePreAggregates, swap.RuleName, bucketSize, swap.Start, swap.End)
+	if err != nil {
+		return 0, fmt.Errorf("swap shadow aggregates: delete live rows: %w", err)
+	}
+	replaced, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("swap shadow aggregates: check live row delete: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, queryInsertPreAggregatesFromShadow, swap.RebuildID); err != nil {
+		return 0, fmt.Errorf("swap shadow aggregates: insert shadow rows: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, queryDeleteShadowAggregates, swap.RebuildID); err != nil {
+		return 0, fmt.Errorf("swap shadow aggregates: delete shadow rows: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("swap shadow aggregates: commit: %w", err)
+	}
+
+	slog.Info("[PreAggregateAdapter] Swapped shadow aggregates",
+		"rebuild_id", swap.RebuildID,
+		"rule", swap.RuleName,
+		"bucket_size", bucketSize,
+		"replaced", replaced,
+	)
+	return replaced, nil
+}
+
+// DiscardShadowAggregates deletes the shadow rows of rebuildID.
+func (a *PreAggregateAdapter) DiscardShadowAggregates(ctx context.Context, rebuildID string) error {
+	if _, err := a.db.ExecContext(ctx, queryDeleteShadowAggregates, rebuildID); err != nil {
+		return fmt.Errorf("discard shadow aggregates: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package postgres
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/aevon-lab/project-aevon/internal/core/aggregation"
+	"github.com/aevon-lab/project-aevon/internal/core/partition"
+	"github.com/lib/pq"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+)
+
+func shadowTestSwap(cursor int64) aggregation.ShadowSwap {
+	start := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	checkpoints := aggregation.PartitionCheckpoints{Range: partition.Full(), Cursors: make([]int64, partition.Count)}
+	for i := range checkpoints.Cursors {
+		checkpoints.Cursors[i] = cursor
+	}
+	return aggregation.ShadowSwap{
+		RebuildID:       "rebuild-1",
+		RuleName:        "count_requests",
+		RuleFingerprint: "fp-1",
+		BucketSize:      "1m",
+		Start:           start,
+		End:             start.Add(time.Hour),
+		Checkpoints:     checkpoints,
+	}
+}
+
+func ruleVersionRows(fingerprint string, rebuildCursor, rebuildTarget int64) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"fingerprint", "rebuild_cursor", "rebuild_target", "partition_targets", "updated_at"}).
+		AddRow(fingerprint, rebuildCursor, rebuildTarget, pq.Int64Array(nil), time.Now())
+}
+
+func TestPreAggregateAdapter_StageShadowAggregates(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	adapter := NewPreAggregateAdapter(db)
+	now := time.Now().UTC().Truncate(time.Second)
+	key := aggregation.AggregateKey{
+		PartitionID: partition.For("user-1"),
+		PrincipalID: "user-1",
+		RuleName:    "count_requests",
+		BucketSize:  "1m",
+		WindowStart: now.Truncate(time.Minute),
+	}
+	state := aggregation.AggregateState{
+		Operator:        aggregation.OpCount,
+		Value:           decimal.NewFromInt(2),
+		EventCount:      2,
+		LastEventID:     "evt-2",
+		RuleFingerprint: "fp-1",
+		UpdatedAt:       now,
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(queryDeleteShadowAggregates)).
+		WithArgs("rebuild-1").
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectPrepare(regexp.QuoteMeta(queryInsertShadowAggregate)).ExpectExec().WithArgs(
+		"rebuild-1", key.PartitionID, "user-1", "count_requests", "fp-1", "1m", key.WindowStart, "",
+		aggregation.OpCount, state.Value, state.Sum, nil, nil, int64(2), "evt-2", now,
+	).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	err = adapter.StageShadowAggregates(context.Background(), "rebuild-1", "1m",
+		map[aggregation.AggregateKey]aggregation.AggregateState{key: state})
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPreAggregateAdapter_SwapShadowAggregates(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	adapter := NewPreAggregateAdapter(db)
+	swap := shadowTestSwap(42)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(querySelectCheckpointForUpdate)).
+		WithArgs("1m", 0, partition.Count).
+		WillReturnRows(checkpointRows(42, partition.Full()))
+	mock.ExpectQuery(regexp.QuoteMeta(querySelectRuleVersionForUpdate)).
+		WithArgs("count_requests", "1m").
+		WillReturnRows(ruleVersionRows("fp-1", 10, 10))
+	mock.ExpectExec(regexp.QuoteMeta(queryDeleteRangePreAggregates)).
+		WithArgs("count_requests", "1m", swap.Start, swap.End).
+		WillReturnResult(sqlmock.NewResult(0, 7))
+	mock.ExpectExec(regexp.QuoteMeta(queryInsertPreAggregatesFromShadow)).
+		WithArgs("rebuild-1").
+		WillReturnResult(sqlmock.NewResult(0, 5))
+	mock.ExpectExec(regexp.QuoteMeta(queryDeleteShadowAggregates)).
+		WithArgs("rebuild-1").
+		WillReturnResult(sqlmock.NewResult(0, 5))
+	mock.ExpectCommit()
+
+	replaced, err := adapter.SwapShadowAggregates(context.Background(), swap)
+	require.NoError(t, err)
+	require.Equal(t, int64(7), replaced)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPreAggregateAdapter_SwapShadowAggregatesRejectsMovedCheckpoint(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	adapter := NewPreAggregateAdapter(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(querySelectCheckpointForUpdate)).
+		WithArgs("1m", 0, partition.Count).
+		WillReturnRows(checkpointRows(43, partition.Full()))
+	mock.ExpectRollback()
+
+	_, err = adapter.SwapShadowAggregates(context.Background(), shadowTestSwap(42))
+	require.ErrorIs(t, err, aggregation.ErrCheckpointMoved)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPreAggregateAdapter_SwapShadowAggregatesRejectsRebuildingRule(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	adapter := NewPreAggregateAdapter(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(querySelectCheckpointForUpdate)).
+		WithArgs("1m", 0, partition.Count).
+		WillReturnRows(checkpointRows(42, partition.Full()))
+	mock.ExpectQuery(regexp.QuoteMeta(querySelectRuleVersionForUpdate)).
+		WithArgs("count_requests", "1m").
+		WillReturnRows(ruleVersionRows("fp-1", 10, 42))
+	mock.ExpectRollback()
+
+	_, err = adapter.SwapShadowAggregates(context.Background(), shadowTestSwap(42))
+	require.ErrorIs(t, err, aggregation.ErrRuleRebuilding)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
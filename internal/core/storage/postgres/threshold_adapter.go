@@ -0,0 +1,267 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/aevon-lab/project-aevon/internal/core/aggregation"
+	"github.com/aevon-lab/project-aevon/internal/core/threshold"
+	"github.com/lib/pq"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	queryInsertThreshold = `
+		INSERT INTO thresholds (id, rule_name, principal_id, period, timezone, value, url, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (id) DO NOTHING
+	`
+
+	// queryDeleteThreshold also drops the threshold's crossings, so a threshold
+	// re-created with the same ID starts armed.
+	queryDeleteThreshold = `
+		WITH deleted AS (
+			DELETE FROM thresholds WHERE id = $1 RETURNING id
+		), crossings AS (
+			DELETE FROM threshold_crossings WHERE threshold_id IN (SELECT id FROM deleted)
+		)
+		SELECT COUNT(*) FROM deleted
+	`
+
+	queryListThresholds = `
+		SELECT id, rule_name, principal_id, period, timezone, value::TEXT, url, created_at
+		FROM thresholds
+		ORDER BY id ASC
+	`
+
+	// queryListThresholdsFor matches (rule_name, principal_id) pairs zipped from $1 and $2.
+	queryListThresholdsFor = `
+		SELECT id, rule_name, principal_id, period, timezone, value::TEXT, url, created_at
+		FROM thresholds
+		WHERE (rule_name, principal_id) IN (SELECT * FROM unnest($1::TEXT[], $2::TEXT[]))
+		ORDER BY id ASC
+	`
+
+	// queryRecordCrossing inserts the crossing and, only if it is new, its outbox delivery,
+	// in one statement.
+	queryRecordCrossing = `
+		WITH crossing AS (
+			INSERT INTO threshold_crossings (threshold_id, period_key, value, crossed_at)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (threshold_id, period_key) DO NOTHING
+			RETURNING crossed_at
+		)
+		INSERT INTO webhook_outbox (url, payload, next_attempt_at, created_at)
+		SELECT $5, $6, crossed_at, crossed_at FROM crossing
+	`
+
+	queryClearCrossing = `
+		DELETE FROM threshold_crossings
+		WHERE threshold_id = $1
+		  AND period_key = $2
+	`
+
+	// queryClaimDeliveries pushes the next attempt of due deliveries to the end of the
+	// claim. SKIP LOCKED lets concurrent dispatchers claim disjoint deliveries.
+	queryClaimDeliveries = `
+		UPDATE webhook_outbox
+		SET next_attempt_at = $2
+		WHERE id IN (
+			SELECT id FROM webhook_outbox
+			WHERE delivered_at IS NULL
+			  AND failed_at IS NULL
+			  AND next_attempt_at <= $1
+			ORDER BY next_attempt_at ASC, id ASC
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, url, payload, attempts, created_at
+	`
+
+	queryCompleteDelivery = `
+		UPDATE webhook_outbox
+		SET delivered_at = $2
+		WHERE id = $1
+	`
+
+	queryRetryDelivery = `
+		UPDATE webhook_outbox
+		SET attempts = attempts + 1, next_attempt_at = $2, last_error = $3
+		WHERE id = $1
+	`
+
+	queryFailDelivery = `
+		UPDATE webhook_outbox
+		SET attempts = attempts + 1, failed_at = $2, last_error = $3
+		WHERE id = $1
+	`
+)
+
+// ThresholdAdapter implements the threshold store and the webhook outbox using PostgreSQL.
+type ThresholdAdapter struct {
+	db *sql.DB
+}
+
+// NewThresholdAdapter creates a new ThresholdAdapter sharing the given connection.
+func NewThresholdAdapter(db *sql.DB) *ThresholdAdapter {
+	return &ThresholdAdapter{db: db}
+}
+
+// CreateThreshold stores t, failing with threshold.ErrThresholdExists if its ID is taken.
+func (a *ThresholdAdapter) CreateThreshold(ctx context.Context, t threshold.Threshold) error {
+	result, err := a.db.ExecContext(ctx, queryInsertThreshold,
+		t.ID, t.Rule, t.PrincipalID, t.Period, t.Timezone, t.Value.String(), t.URL, t.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("insert threshold %s: %w", t.ID, err)
+	}
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("check threshold insert: %w", err)
+	}
+	if inserted == 0 {
+		return threshold.ErrThresholdExists
+	}
+	return nil
+}
+
+// DeleteThreshold removes a threshold and its crossings.
+func (a *ThresholdAdapter) DeleteThreshold(ctx context.Context, id string) error {
+	var deleted int
+	if err := a.db.QueryRowContext(ctx, queryDeleteThreshold, id).Scan(&deleted); err != nil {
+		return fmt.Errorf("delete threshold %s: %w", id, err)
+	}
+	if deleted == 0 {
+		return threshold.ErrThresholdNotFound
+	}
+	return nil
+}
+
+// ListThresholds returns every stored threshold ordered by ID.
+func (a *ThresholdAdapter) ListThresholds(ctx context.Context) ([]threshold.Threshold, error) {
+	return a.queryThresholds(ctx, queryListThresholds)
+}
+
+// ListThresholdsFor returns the stored thresholds of the given (principal, rule) pairs.
+func (a *ThresholdAdapter) ListThresholdsFor(ctx context.Context, scopes []aggregation.RangeScope) ([]threshold.Threshold, error) {
+	if len(scopes) == 0 {
+		return nil, nil
+	}
+	rules := make([]string, len(scopes))
+	principals := make([]string, len(scopes))
+	for i, scope := range scopes {
+		rules[i] = scope.RuleName
+		principals[i] = scope.PrincipalID
+	}
+	return a.queryThresholds(ctx, queryListThresholdsFor, pq.Array(rules), pq.Array(principals))
+}
+
+func (a *ThresholdAdapter) queryThresholds(ctx context.Context, query string, args ...any) ([]threshold.Threshold, error) {
+	rows, err := a.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query thresholds: %w", err)
+	}
+	defer rows.Close()
+
+	var thresholds []threshold.Threshold
+	for rows.Next() {
+		var (
+			t        threshold.Threshold
+			valueStr string
+		)
+		if err := rows.Scan(&t.ID, &t.Rule, &t.PrincipalID, &t.Period, &t.Timezone, &valueStr, &t.URL, &t.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan threshold: %w", err)
+		}
+		t.Value, err = decimal.NewFromString(valueStr)
+		if err != nil {
+			return nil, fmt.Errorf("parse threshold value %q: %w", valueStr, err)
+		}
+		t.Source = threshold.SourceAPI
+		thresholds = append(thresholds, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate thresholds: %w", err)
+	}
+	return thresholds, nil
+}
+
+// RecordCrossing inserts crossing and enqueues its delivery atomically. It reports false
+// if the threshold already crossed in crossing.PeriodKey.
+func (a *ThresholdAdapter) RecordCrossing(ctx context.Context, crossing threshold.Crossing, url string, payload []byte) (bool, error) {
+	result, err := a.db.ExecContext(ctx, queryRecordCrossing,
+		crossing.ThresholdID, crossing.PeriodKey, crossing.Value.String(), crossing.CrossedAt, url, string(payload),
+	)
+	if err != nil {
+		return false, fmt.Errorf("record crossing of %s: %w", crossing.ThresholdID, err)
+	}
+	enqueued, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("check crossing insert: %w", err)
+	}
+	return enqueued > 0, nil
+}
+
+// ClearCrossing re-arms a threshold for periodKey.
+func (a *ThresholdAdapter) ClearCrossing(ctx context.Context, thresholdID string, periodKey string) error {
+	if _, err := a.db.ExecContext(ctx, queryClearCrossing, thresholdID, periodKey); err != nil {
+		return fmt.Errorf("clear crossing of %s: %w", thresholdID, err)
+	}
+	return nil
+}
+
+// ClaimDeliveries returns up to limit deliveries due at now, ordered by ID, and defers
+// their next attempt to now+lease.
+func (a *ThresholdAdapter) ClaimDeliveries(ctx context.Context, now time.Time, limit int, lease time.Duration) ([]threshold.Delivery, error) {
+	rows, err := a.db.QueryContext(ctx, queryClaimDeliveries, now, now.Add(lease), limit)
+	if err != nil {
+		return nil, fmt.Errorf("claim deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	var deliveries []threshold.Delivery
+	for rows.Next() {
+		var (
+			d       threshold.Delivery
+			payload string
+		)
+		if err := rows.Scan(&d.ID, &d.URL, &payload, &d.Attempts, &d.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan delivery: %w", err)
+		}
+		d.Payload = []byte(payload)
+		deliveries = append(deliveries, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate deliveries: %w", err)
+	}
+
+	// RETURNING does not preserve the claim order.
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].ID < deliveries[j].ID })
+	return deliveries, nil
+}
+
+// CompleteDelivery marks a delivery as delivered.
+func (a *ThresholdAdapter) CompleteDelivery(ctx context.Context, id int64, deliveredAt time.Time) error {
+	if _, err := a.db.ExecContext(ctx, queryCompleteDelivery, id, deliveredAt); err != nil {
+		return fmt.Errorf("complete delivery %d: %w", id, err)
+	}
+	return nil
+}
+
+// RetryDelivery records a failed attempt and schedules the next one.
+func (a *ThresholdAdapter) RetryDelivery(ctx context.Context, id int64, nextAttemptAt time.Time, lastError string) error {
+	if _, err := a.db.ExecContext(ctx, queryRetryDelivery, id, nextAttemptAt, lastError); err != nil {
+		return fmt.Errorf("retry delivery %d: %w", id, err)
+	}
+	return nil
+}
+
+// FailDelivery records a failed attempt and gives up on the delivery.
+func (a *ThresholdAdapter) FailDelivery(ctx context.Context, id int64, failedAt time.Time, lastError string) error {
+	if _, err := a.db.ExecContext(ctx, queryFailDelivery, id, failedAt, lastError); err != nil {
+		return fmt.Errorf("fail delivery %d: %w", id, err)
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package postgres
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/aevon-lab/project-aevon/internal/core/aggregation"
+	"github.com/aevon-lab/project-aevon/internal/core/threshold"
+	"github.com/lib/pq"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+)
+
+func TestThresholdAdapter_CreateAndDeleteThreshold(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	adapter := NewThresholdAdapter(db)
+	createdAt := time.Date(2026, 2, 7, 10, 0, 0, 0, time.UTC)
+	thr := threshold.Threshold{
+		ID:          "thr-1",
+		Rule:        "sum_tokens",
+		PrincipalID: "user-1",
+		Period:      "current_month",
+		Value:       decimal.NewFromInt(1000),
+		URL:         "https://example.com/hook",
+		CreatedAt:   createdAt,
+	}
+
+	mock.ExpectExec(regexp.QuoteMeta(queryInsertThreshold)).
+		WithArgs("thr-1", "sum_tokens", "user-1", "current_month", "", "1000", "https://example.com/hook", createdAt).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	require.NoError(t, adapter.CreateThreshold(context.Background(), thr))
+
+	mock.ExpectExec(regexp.QuoteMeta(queryInsertThreshold)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	require.ErrorIs(t, adapter.CreateThreshold(context.Background(), thr), threshold.ErrThresholdExists)
+
+	mock.ExpectQuery(regexp.QuoteMeta(queryDeleteThreshold)).
+		WithArgs("thr-1").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	require.NoError(t, adapter.DeleteThreshold(context.Background(), "thr-1"))
+
+	mock.ExpectQuery(regexp.QuoteMeta(queryDeleteThreshold)).
+		WithArgs("thr-1").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	require.ErrorIs(t, adapter.DeleteThreshold(context.Background(), "thr-1"), threshold.ErrThresholdNotFound)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestThresholdAdapter_ListThresholdsFor(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	adapter := NewThresholdAdapter(db)
+	createdAt := time.Date(2026, 2, 7, 10, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery(regexp.QuoteMeta(queryListThresholdsFor)).
+		WithArgs(pq.Array([]string{"sum_tokens", "sum_tokens"}), pq.Array([]string{"user-1", "user-2"})).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "rule_name", "principal_id", "period", "timezone", "value", "url", "created_at"}).
+			AddRow("thr-1", "sum_tokens", "user-1", "current_day", "Europe/Berlin", "99.5", "https://example.com/hook", createdAt))
+
+	thresholds, err := adapter.ListThresholdsFor(context.Background(), []aggregation.RangeScope{
+		{PrincipalID: "user-1", RuleName: "sum_tokens"},
+		{PrincipalID: "user-2", RuleName: "sum_tokens"},
+	})
+	require.NoError(t, err)
+	require.Len(t, thresholds, 1)
+	require.Equal(t, "Europe/Berlin", thresholds[0].Timezone)
+	require.Equal(t, "99.5", thresholds[0].Value.String())
+	require.Equal(t, threshold.SourceAPI, thresholds[0].Source)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestThresholdAdapter_RecordCrossing(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	adapter := NewThresholdAdapter(db)
+	crossedAt := time.Date(2026, 2, 7, 10, 5, 0, 0, time.UTC)
+	crossing := threshold.Crossing{
+		ThresholdID: "thr-1",
+		PeriodKey:   "2026-02-01T00:00:00Z",
+		Value:       decimal.NewFromInt(1200),
+		CrossedAt:   crossedAt,
+	}
+	payload := []byte(`{"threshold_id":"thr-1"}`)
+
+	mock.ExpectExec(regexp.QuoteMeta(queryRecordCrossing)).
+		WithArgs("thr-1", "2026-02-01T00:00:00Z", "1200", crossedAt, "https://example.com/hook", string(payload)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	recorded, err := adapter.RecordCrossing(context.Background(), crossing, "https://example.com/hook", payload)
+	require.NoError(t, err)
+	require.True(t, recorded)
+
+	// Already crossed in this period: no crossing and no delivery are inserted.
+	mock.ExpectExec(regexp.QuoteMeta(queryRecordCrossing)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	recorded, err = adapter.RecordCrossing(context.Background(), crossing, "https://example.com/hook", payload)
+	require.NoError(t, err)
+	require.False(t, recorded)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestThresholdAdapter_ClaimDeliveries(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	adapter := NewThresholdAdapter(db)
+	now := time.Date(2026, 2, 7, 10, 5, 0, 0, time.UTC)
+
+	mock.ExpectQuery(regexp.QuoteMeta(queryClaimDeliveries)).
+		WithArgs(now, now.Add(time.Minute), 20).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "url", "payload", "attempts", "created_at"}).
+			AddRow(int64(7), "https://example.com/b", `{"b":1}`, 2, now).
+			AddRow(int64(3), "https://example.com/a", `{"a":1}`, 0, now))
+
+	deliveries, err := adapter.ClaimDeliveries(context.Background(), now, 20, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 2)
+	require.Equal(t, int64(3), deliveries[0].ID)
+	require.Equal(t, []byte(`{"a":1}`), deliveries[0].Payload)
+	require.Equal(t, 2, deliveries[1].Attempts)
+
+	mock.ExpectExec(regexp.QuoteMeta(queryRetryDelivery)).
+		WithArgs(int64(7), now.Add(time.Hour), "receiver responded 503").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	require.NoError(t, adapter.RetryDelivery(context.Background(), 7, now.Add(time.Hour), "receiver responded 503"))
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	v1 "github.com/aevon-lab/project-aevon/internal/api/v1"
+	"github.com/aevon-lab/project-aevon/internal/core/partition"
 )
 
 // ErrDuplicate is returned when an event with the same (principal_id, id) already exists.
@@ -15,6 +16,12 @@ var ErrDuplicate = errors.New("event already exists")
 type EventStore interface {
 	SaveEvent(ctx context.Context, event *v1.Event) error
 
+	// SaveEvents persists a batch of events in a single transaction and populates IngestSeq
+	// on every inserted event. The returned slice is index-aligned with events: nil for an
+	// inserted event, ErrDuplicate when (principal_id, id) already existed or repeats an
+	// earlier event in the same batch. A non-nil error means nothing was persisted.
+	SaveEvents(ctx context.Context, events []*v1.Event) ([]error, error)
+
 	// RetrieveEventsAfter - DEPRECATED: Use RetrieveEventsAfterCursor for recovery
 	// Kept for backwards compatibility during migration.
 	RetrieveEventsAfter(ctx context.Context, afterTime time.Time, limit int) ([]*v1.Event, error)
@@ -36,6 +43,10 @@ type EventStore interface {
 
 	// RetrieveScopedEventsAfterCursor fetches events in strict total order for one query scope.
 	// Used by the projection hybrid read path to merge unflushed raw events with pre-aggregates.
+	// Returns events that occurred in [startOccurredAt, endOccurredAt). includeIngested
+	// also returns events ingested in the range, for rules that bucket late events by
+	// ingestion time (see AggregationRule.BucketsByIngestion); callers bucket them by the
+	// rule's event time.
 	RetrieveScopedEventsAfterCursor(
 		ctx context.Context,
 		cursor int64,
@@ -43,6 +54,69 @@ type EventStore interface {
 		eventType string,
 		startOccurredAt time.Time,
 		endOccurredAt time.Time,
+		includeIngested bool,
+		limit int,
+	) ([]*v1.Event, error)
+}
+
+// PartitionedEventReader is implemented by event stores that can read the event stream
+// of a partition range without scanning other partitions. Aggregation shards use it
+// when available and otherwise filter the global stream.
+type PartitionedEventReader interface {
+	// RetrievePartitionEventsAfterCursor fetches events of principals in partitions after
+	// a cursor (ingest_seq) in strict total order.
+	RetrievePartitionEventsAfterCursor(ctx context.Context, cursor int64, partitions partition.Range, limit int) ([]*v1.Event, error)
+}
+
+// IngestCursorReader is implemented by event stores that can map an ingestion time to
+// the event stream of one principal. Point-in-time queries use it to tell whether
+// pre-aggregates already contain events ingested after the requested time.
+type IngestCursorReader interface {
+	// FirstIngestSeqAfter returns the lowest ingest_seq of the principal's events
+	// ingested after t, or 0 if there is none.
+	FirstIngestSeqAfter(ctx context.Context, principalID string, t time.Time) (int64, error)
+}
+
+// TypeIngestCursorReader is implemented by event stores that can map an ingestion time
+// to the event stream of one event type. Leaderboard queries use it to start the scan
+// of a partial edge bucket near the edge instead of at the start of the event log.
+type TypeIngestCursorReader interface {
+	// FirstTypeIngestSeqAfter returns the lowest ingest_seq of the events of eventType
+	// ingested after t, or 0 if there is none.
+	FirstTypeIngestSeqAfter(ctx context.Context, eventType string, t time.Time) (int64, error)
+}
+
+// BatchScopedEventReader is implemented by event stores that can read the raw tail of
+// many principals in one scan. Batch state queries use it instead of one
+// RetrieveScopedEventsAfterCursor scan per principal.
+type BatchScopedEventReader interface {
+	// RetrieveBatchScopedEventsAfterCursor fetches events of the principals in strict
+	// total order, with the same scope as RetrieveScopedEventsAfterCursor.
+	RetrieveBatchScopedEventsAfterCursor(
+		ctx context.Context,
+		cursor int64,
+		principalIDs []string,
+		eventType string,
+		startOccurredAt time.Time,
+		endOccurredAt time.Time,
+		includeIngested bool,
+		limit int,
+	) ([]*v1.Event, error)
+}
+
+// TypeScopedEventReader is implemented by event stores that can read the raw tail of
+// one event type across all principals. Leaderboard queries use it to rank principals
+// including events not yet aggregated.
+type TypeScopedEventReader interface {
+	// RetrieveTypeScopedEventsAfterCursor fetches events of eventType in strict total
+	// order, with the same time scope as RetrieveScopedEventsAfterCursor.
+	RetrieveTypeScopedEventsAfterCursor(
+		ctx context.Context,
+		cursor int64,
+		eventType string,
+		startOccurredAt time.Time,
+		endOccurredAt time.Time,
+		includeIngested bool,
 		limit int,
 	) ([]*v1.Event, error)
 }
@@ -0,0 +1,333 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	v1 "github.com/aevon-lab/project-aevon/internal/api/v1"
+	"github.com/aevon-lab/project-aevon/internal/core/partition"
+	"github.com/aevon-lab/project-aevon/internal/core/storage"
+	_ "github.com/mattn/go-sqlite3" // Registers the sqlite3 driver
+)
+
+const (
+	connectPingTimeout = 5 * time.Second
+
+	// connectionParams are appended to the DSN. WAL lets queries read while a flush
+	// writes, busy_timeout makes writers wait for each other instead of failing with
+	// SQLITE_BUSY, and txlock=immediate takes the write lock when a transaction begins,
+	// so transactions that read before they write (a flush reads the checkpoint first)
+	// are serialized like PostgreSQL's SELECT ... FOR UPDATE serializes them.
+	connectionParams = "_journal_mode=WAL&_busy_timeout=5000&_txlock=immediate"
+)
+
+// Adapter implements storage.EventStore on an embedded SQLite database file.
+type Adapter struct {
+	db *sql.DB
+}
+
+// NewAdapter opens the SQLite database at dsn, a file path or file: URI, and applies
+// the connection pool settings. The file is created if it does not exist.
+//
+// Example DSN: "aevon.db"
+//
+// IMPORTANT: Schema must be initialized separately via migrations.RunSQLiteMigrations.
+//
+// The driver uses cgo; binaries built with CGO_ENABLED=0 fail to open the database.
+func NewAdapter(dsn string, maxOpenConns, maxIdleConns int) (*Adapter, error) {
+	db, err := sql.Open("sqlite3", withConnectionParams(dsn))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sqlite database: %w", err)
+	}
+
+	db.SetMaxOpenConns(maxOpenConns)
+	db.SetMaxIdleConns(maxIdleConns)
+
+	slog.Info("[SQLite] Connection pool configured",
+		"max_open_conns", maxOpenConns,
+		"max_idle_conns", maxIdleConns)
+
+	pingCtx, cancel := context.WithTimeout(context.Background(), connectPingTimeout)
+	defer cancel()
+
+	if err := db.PingContext(pingCtx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to ping sqlite database: %w", err)
+	}
+
+	slog.Info("[SQLite] Adapter initialized", "dsn", dsn)
+	return &Adapter{db: db}, nil
+}
+
+// withConnectionParams appends connectionParams to dsn. Parameters already in dsn win,
+// the driver reads the first value of each.
+func withConnectionParams(dsn string) string {
+	if strings.Contains(dsn, "?") {
+		return dsn + "&" + connectionParams
+	}
+	return dsn + "?" + connectionParams
+}
+
+// SaveEvent persists an event and populates IngestSeq.
+// Uses composite key (principal_id, id) for idempotency.
+// Returns storage.ErrDuplicate if an event with the same key already exists.
+func (a *Adapter) SaveEvent(ctx context.Context, event *v1.Event) error {
+	seq, err := insertEvent(ctx, a.db, event)
+	if err != nil {
+		return err
+	}
+	event.IngestSeq = seq
+
+	slog.Debug("[SQLite] Saved event",
+		"principal_id", event.PrincipalID,
+		"event_id", event.ID,
+		"ingest_seq", event.IngestSeq)
+	return nil
+}
+
+// SaveEvents persists a batch of events in one transaction.
+//
+// The returned slice is index-aligned with events: nil for inserted events (IngestSeq
+// populated), storage.ErrDuplicate for events that already existed or repeat an earlier
+// event in the same batch.
+func (a *Adapter) SaveEvents(ctx context.Context, events []*v1.Event) ([]error, error) {
+	results := make([]error, len(events))
+	if len(events) == 0 {
+		return results, nil
+	}
+
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin save events tx: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	// IngestSeq is only populated once the transaction commits.
+	seqs := make([]int64, len(events))
+	inserted := 0
+	for i, event := range events {
+		seqs[i], err = insertEvent(ctx, tx, event)
+		if err == storage.ErrDuplicate {
+			results[i] = err
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("event %q: %w", event.ID, err)
+		}
+		inserted++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit save events tx: %w", err)
+	}
+	for i, event := range events {
+		if results[i] == nil {
+			event.IngestSeq = seqs[i]
+		}
+	}
+
+	slog.Debug("[SQLite] Saved event batch",
+		"events", len(events),
+		"inserted", inserted,
+		"duplicates", len(events)-inserted)
+	return results, nil
+}
+
+// queryRower is a *sql.DB or *sql.Tx.
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// insertEvent runs querySaveEvent for event and returns its ingest_seq, or
+// storage.ErrDuplicate if (principal_id, id) already exists.
+func insertEvent(ctx context.Context, q queryRower, event *v1.Event) (int64, error) {
+	metadataJSON, dataJSON, err := marshalEventJSON(event)
+	if err != nil {
+		return 0, err
+	}
+
+	var ingestSeq int64
+	err = q.QueryRowContext(ctx, querySaveEvent,
+		event.ID,
+		event.PrincipalID,
+		event.Type,
+		event.SchemaVersion,
+		unixMicro(event.OccurredAt),
+		unixMicro(event.IngestedAt),
+		metadataJSON,
+		dataJSON,
+		partition.For(event.PrincipalID),
+	).Scan(&ingestSeq)
+	if err == sql.ErrNoRows {
+		return 0, storage.ErrDuplicate
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to save event: %w", err)
+	}
+	return ingestSeq, nil
+}
+
+// RetrieveEventsAfter fetches events ingested after a given timestamp, ordered by
+// ingested_at ASC.
+func (a *Adapter) RetrieveEventsAfter(ctx context.Context, afterTime time.Time, limit int) ([]*v1.Event, error) {
+	return a.queryEvents(ctx, "events", queryRetrieveEventsAfter, unixMicro(afterTime), limit)
+}
+
+// RetrieveEventsByPrincipalAndIngestedRange fetches raw events for one principal
+// in an ingested_at time range [start, end].
+func (a *Adapter) RetrieveEventsByPrincipalAndIngestedRange(
+	ctx context.Context,
+	principalID string,
+	startIngestedAt time.Time,
+	endIngestedAt time.Time,
+	limit int,
+) ([]*v1.Event, error) {
+	return a.queryEvents(ctx, "scoped events by ingested range", queryRetrieveEventsByPrincipalIngestedRange,
+		principalID, unixMicro(startIngestedAt), unixMicro(endIngestedAt), limit)
+}
+
+// RetrieveEventsAfterCursor fetches events after a cursor (ingest_seq) in strict total
+// order. cursor=0 means "from the beginning".
+func (a *Adapter) RetrieveEventsAfterCursor(ctx context.Context, cursor int64, limit int) ([]*v1.Event, error) {
+	return a.queryEvents(ctx, "events by cursor", queryRetrieveEventsAfterCursor, cursor, limit)
+}
+
+// RetrievePartitionEventsAfterCursor fetches events of principals in partitions after a
+// cursor (ingest_seq), ordered by ingest_seq ASC.
+func (a *Adapter) RetrievePartitionEventsAfterCursor(
+	ctx context.Context,
+	cursor int64,
+	partitions partition.Range,
+	limit int,
+) ([]*v1.Event, error) {
+	return a.queryEvents(ctx, "partition events by cursor", queryRetrievePartitionEventsAfterCursor,
+		cursor, partitions.From, partitions.To, limit)
+}
+
+// FirstIngestSeqAfter returns the lowest ingest_seq of the principal's events ingested
+// after t, or 0 if there is none.
+func (a *Adapter) FirstIngestSeqAfter(ctx context.Context, principalID string, t time.Time) (int64, error) {
+	var seq int64
+	if err := a.db.QueryRowContext(ctx, queryFirstIngestSeqAfter, principalID, unixMicro(t)).Scan(&seq); err != nil {
+		return 0, fmt.Errorf("failed to query first ingest_seq after %s: %w", t.Format(time.RFC3339), err)
+	}
+	return seq, nil
+}
+
+// FirstTypeIngestSeqAfter returns the lowest ingest_seq of the events of eventType
+// ingested after t, or 0 if there is none.
+func (a *Adapter) FirstTypeIngestSeqAfter(ctx context.Context, eventType string, t time.Time) (int64, error) {
+	var seq int64
+	if err := a.db.QueryRowContext(ctx, queryFirstTypeIngestSeqAfter, eventType, unixMicro(t)).Scan(&seq); err != nil {
+		return 0, fmt.Errorf("failed to query first %s ingest_seq after %s: %w", eventType, t.Format(time.RFC3339), err)
+	}
+	return seq, nil
+}
+
+// RetrieveScopedEventsAfterCursor fetches events in strict order for one projection query scope.
+func (a *Adapter) RetrieveScopedEventsAfterCursor(
+	ctx context.Context,
+	cursor int64,
+	principalID string,
+	eventType string,
+	startOccurredAt time.Time,
+	endOccurredAt time.Time,
+	includeIngested bool,
+	limit int,
+) ([]*v1.Event, error) {
+	query := queryRetrieveScopedEventsAfterCursor
+	if includeIngested {
+		query = queryRetrieveScopedEventsAfterCursorWithIngested
+	}
+	return a.queryEvents(ctx, "scoped events by cursor", query,
+		cursor, principalID, eventType, unixMicro(startOccurredAt), unixMicro(endOccurredAt), limit)
+}
+
+// RetrieveBatchScopedEventsAfterCursor fetches events of several principals in strict
+// order for one batch projection query scope.
+func (a *Adapter) RetrieveBatchScopedEventsAfterCursor(
+	ctx context.Context,
+	cursor int64,
+	principalIDs []string,
+	eventType string,
+	startOccurredAt time.Time,
+	endOccurredAt time.Time,
+	includeIngested bool,
+	limit int,
+) ([]*v1.Event, error) {
+	principals, err := json.Marshal(principalIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode principal IDs: %w", err)
+	}
+	query := queryRetrieveBatchScopedEventsAfterCursor
+	if includeIngested {
+		query = queryRetrieveBatchScopedEventsAfterCursorWithIngested
+	}
+	return a.queryEvents(ctx, "batch scoped events by cursor", query,
+		cursor, string(principals), eventType, unixMicro(startOccurredAt), unixMicro(endOccurredAt), limit)
+}
+
+// RetrieveTypeScopedEventsAfterCursor fetches events of one type of every principal in
+// strict order for a leaderboard query scope.
+func (a *Adapter) RetrieveTypeScopedEventsAfterCursor(
+	ctx context.Context,
+	cursor int64,
+	eventType string,
+	startOccurredAt time.Time,
+	endOccurredAt time.Time,
+	includeIngested bool,
+	limit int,
+) ([]*v1.Event, error) {
+	query := queryRetrieveTypeScopedEventsAfterCursor
+	if includeIngested {
+		query = queryRetrieveTypeScopedEventsAfterCursorWithIngested
+	}
+	return a.queryEvents(ctx, "type scoped events by cursor", query,
+		cursor, eventType, unixMicro(startOccurredAt), unixMicro(endOccurredAt), limit)
+}
+
+// queryEvents runs an events query selecting the columns scanEventRow expects. what
+// names the events in errors.
+func (a *Adapter) queryEvents(ctx context.Context, what string, query string, args ...interface{}) ([]*v1.Event, error) {
+	rows, err := a.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s: %w", what, err)
+	}
+	defer rows.Close()
+
+	var events []*v1.Event
+	for rows.Next() {
+		event, err := scanEventRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating %s: %w", what, err)
+	}
+
+	return events, nil
+}
+
+// DB returns the underlying *sql.DB. The PreAggregateAdapter shares this connection
+// pool rather than opening the file twice.
+func (a *Adapter) DB() *sql.DB {
+	return a.db
+}
+
+// Close closes the database. Should be called during graceful shutdown.
+func (a *Adapter) Close() error {
+	if err := a.db.Close(); err != nil {
+		return fmt.Errorf("failed to close database: %w", err)
+	}
+
+	slog.Info("[SQLite] Adapter closed gracefully")
+	return nil
+}
@@ -0,0 +1,29 @@
+//go:build cgo
+
+package sqlite
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/aevon-lab/project-aevon/internal/core/storage"
+	"github.com/aevon-lab/project-aevon/internal/core/storage/storetest"
+	"github.com/aevon-lab/project-aevon/internal/migrations"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestAdapter opens a migrated database in a temporary file.
+func newTestAdapter(t *testing.T) *Adapter {
+	t.Helper()
+	adapter, err := NewAdapter(filepath.Join(t.TempDir(), "aevon.db"), 4, 4)
+	require.NoError(t, err)
+	t.Cleanup(func() { adapter.Close() })
+	require.NoError(t, migrations.RunSQLiteMigrations(adapter.DB(), true))
+	return adapter
+}
+
+func TestAdapter(t *testing.T) {
+	storetest.RunEventStore(t, func(t *testing.T) storage.EventStore {
+		return newTestAdapter(t)
+	})
+}
@@ -0,0 +1,210 @@
+package sqlite
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	v1 "github.com/aevon-lab/project-aevon/internal/api/v1"
+	"github.com/aevon-lab/project-aevon/internal/core/aggregation"
+	"github.com/shopspring/decimal"
+)
+
+// Timestamp columns hold INTEGER microseconds since the Unix epoch, the precision
+// PostgreSQL keeps, so both backends return the same times.
+
+// unixMicro encodes t for a timestamp column.
+func unixMicro(t time.Time) int64 {
+	return t.UnixMicro()
+}
+
+// fromUnixMicro decodes a timestamp column.
+func fromUnixMicro(v int64) time.Time {
+	return time.UnixMicro(v).UTC()
+}
+
+// nullUnixMicro maps a zero time to SQL NULL so optional timestamp columns stay NULL.
+func nullUnixMicro(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return unixMicro(t)
+}
+
+// marshalEventJSON marshals an event's metadata and data fields to JSON text.
+// Nil metadata produces SQL NULL rather than JSON "null".
+func marshalEventJSON(event *v1.Event) (metadataJSON interface{}, dataJSON string, err error) {
+	if len(event.Metadata) > 0 {
+		metadata, err := json.Marshal(event.Metadata)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to marshal metadata: %w", err)
+		}
+		metadataJSON = string(metadata)
+	}
+
+	data, err := json.Marshal(event.Data)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to marshal data: %w", err)
+	}
+
+	return metadataJSON, string(data), nil
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanEventRow scans an events row selected with eventColumns.
+func scanEventRow(row scanner) (*v1.Event, error) {
+	var (
+		evt                    v1.Event
+		occurredAt, ingestedAt int64
+		metadataJSON           sql.NullString
+		dataJSON               string
+	)
+
+	if err := row.Scan(
+		&evt.ID,
+		&evt.PrincipalID,
+		&evt.Type,
+		&evt.SchemaVersion,
+		&occurredAt,
+		&ingestedAt,
+		&metadataJSON,
+		&dataJSON,
+		&evt.IngestSeq,
+	); err != nil {
+		return nil, fmt.Errorf("failed to scan event row: %w", err)
+	}
+	evt.OccurredAt = fromUnixMicro(occurredAt)
+	evt.IngestedAt = fromUnixMicro(ingestedAt)
+
+	if metadataJSON.Valid && metadataJSON.String != "" {
+		if err := json.Unmarshal([]byte(metadataJSON.String), &evt.Metadata); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal metadata: %w", err)
+		}
+	}
+
+	if err := json.Unmarshal([]byte(dataJSON), &evt.Data); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal data: %w", err)
+	}
+
+	return &evt, nil
+}
+
+// marshalSketch encodes the sketch of a count_distinct or quantile state; states
+// without a sketch stay SQL NULL.
+func marshalSketch(state aggregation.AggregateState) (interface{}, error) {
+	var (
+		data []byte
+		err  error
+	)
+	switch {
+	case state.Sketch != nil:
+		data, err = state.Sketch.MarshalBinary()
+	case state.Digest != nil:
+		data, err = state.Digest.MarshalBinary()
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal sketch: %w", err)
+	}
+	return data, nil
+}
+
+// unmarshalSketch decodes the sketch column into state according to state.Operator.
+// NULL (empty) leaves the state without a sketch.
+func unmarshalSketch(state *aggregation.AggregateState, data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	switch state.Operator {
+	case aggregation.OpCountDistinct:
+		var sketch aggregation.Sketch
+		if err := sketch.UnmarshalBinary(data); err != nil {
+			return fmt.Errorf("failed to unmarshal sketch: %w", err)
+		}
+		state.Sketch = &sketch
+	case aggregation.OpQuantile:
+		var digest aggregation.QuantileSketch
+		if err := digest.UnmarshalBinary(data); err != nil {
+			return fmt.Errorf("failed to unmarshal quantile sketch: %w", err)
+		}
+		state.Digest = &digest
+	}
+	return nil
+}
+
+// aggregateColumns receives the state columns of a pre_aggregates row, selected with
+// stateColumns. They are nullable because range reads LEFT JOIN them to the checkpoint
+// and get a row of NULLs when the range is empty.
+type aggregateColumns struct {
+	windowStart     sql.NullInt64
+	dimensions      sql.NullString
+	operator        sql.NullString
+	value           sql.NullString
+	auxSum          sql.NullString
+	valueAt         sql.NullInt64
+	sketch          []byte
+	eventCount      sql.NullInt64
+	lastEventID     sql.NullString
+	ruleFingerprint sql.NullString
+	updatedAt       sql.NullInt64
+}
+
+func (c *aggregateColumns) dest() []any {
+	return []any{
+		&c.windowStart,
+		&c.dimensions,
+		&c.operator,
+		&c.value,
+		&c.auxSum,
+		&c.valueAt,
+		&c.sketch,
+		&c.eventCount,
+		&c.lastEventID,
+		&c.ruleFingerprint,
+		&c.updatedAt,
+	}
+}
+
+// state converts the columns to an aggregate state. ok is false for the row a LEFT
+// JOIN emits when the range is empty.
+func (c *aggregateColumns) state() (state aggregation.AggregateState, ok bool, err error) {
+	if !c.windowStart.Valid {
+		return aggregation.AggregateState{}, false, nil
+	}
+
+	value, err := decimal.NewFromString(c.value.String)
+	if err != nil {
+		return aggregation.AggregateState{}, false, fmt.Errorf("parse value %q: %w", c.value.String, err)
+	}
+	auxSum := decimal.Zero
+	if c.auxSum.Valid {
+		auxSum, err = decimal.NewFromString(c.auxSum.String)
+		if err != nil {
+			return aggregation.AggregateState{}, false, fmt.Errorf("parse aux_sum %q: %w", c.auxSum.String, err)
+		}
+	}
+
+	state = aggregation.AggregateState{
+		WindowStart:     fromUnixMicro(c.windowStart.Int64),
+		Dimensions:      c.dimensions.String,
+		Operator:        c.operator.String,
+		Value:           value,
+		Sum:             auxSum,
+		EventCount:      c.eventCount.Int64,
+		LastEventID:     c.lastEventID.String,
+		RuleFingerprint: c.ruleFingerprint.String,
+		UpdatedAt:       fromUnixMicro(c.updatedAt.Int64),
+	}
+	if c.valueAt.Valid {
+		state.ValueAt = fromUnixMicro(c.valueAt.Int64)
+	}
+	if err := unmarshalSketch(&state, c.sketch); err != nil {
+		return aggregation.AggregateState{}, false, err
+	}
+	return state, true, nil
+}
@@ -0,0 +1,979 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/aevon-lab/project-aevon/internal/core/aggregation"
+	"github.com/aevon-lab/project-aevon/internal/core/partition"
+)
+
+const (
+	defaultBucketSize = "1m"
+
+	// stateColumns are the pre_aggregates columns aggregateColumns scans.
+	stateColumns = `
+			window_start, dimensions, operator, value, aux_sum, value_at, sketch,
+			event_count, last_event_id, rule_fingerprint, updated_at`
+
+	querySelectPreAggregate = `
+		SELECT` + stateColumns + `
+		FROM pre_aggregates
+		WHERE partition_id = ?1
+		  AND principal_id = ?2
+		  AND rule_name = ?3
+		  AND bucket_size = ?4
+		  AND window_start = ?5
+		  AND dimensions = ?6
+	`
+
+	// queryUpsertPreAggregate writes a row merged in Go (see mergeDurable); SQLite has no
+	// decimal type to merge values in SQL.
+	queryUpsertPreAggregate = `
+		INSERT INTO pre_aggregates (
+			partition_id, principal_id, rule_name, rule_fingerprint,
+			bucket_size, window_start, dimensions, operator, value, aux_sum, value_at, sketch,
+			event_count, last_event_id, updated_at
+		) VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9, ?10, ?11, ?12, ?13, ?14, ?15)
+		ON CONFLICT (partition_id, principal_id, rule_name, bucket_size, window_start, dimensions)
+		DO UPDATE SET
+			rule_fingerprint = excluded.rule_fingerprint,
+			operator         = excluded.operator,
+			value            = excluded.value,
+			aux_sum          = excluded.aux_sum,
+			value_at         = excluded.value_at,
+			sketch           = excluded.sketch,
+			event_count      = excluded.event_count,
+			last_event_id    = excluded.last_event_id,
+			updated_at       = excluded.updated_at
+	`
+
+	queryDeletePreAggregate = `
+		DELETE FROM pre_aggregates
+		WHERE partition_id = ?1
+		  AND principal_id = ?2
+		  AND rule_name = ?3
+		  AND bucket_size = ?4
+		  AND window_start = ?5
+		  AND dimensions = ?6
+	`
+
+	// queryReadCheckpoint returns the lowest partition checkpoint: every event up to it is
+	// aggregated for all partitions.
+	queryReadCheckpoint = `
+		SELECT COALESCE(MIN(checkpoint_cursor), 0)
+		FROM sweep_checkpoints
+		WHERE bucket_size = ?1
+	`
+
+	queryReadPartitionCheckpoints = `
+		SELECT partition_id, checkpoint_cursor
+		FROM sweep_checkpoints
+		WHERE bucket_size = ?1
+		  AND partition_id >= ?2
+		  AND partition_id < ?3
+		ORDER BY partition_id ASC
+	`
+
+	// queryInitCheckpointRows creates the checkpoint row of every partition of a bucket
+	// size. The WHERE clause lets SQLite parse ON CONFLICT after a SELECT.
+	queryInitCheckpointRows = `
+		WITH RECURSIVE partitions(partition_id) AS (
+			SELECT 0
+			UNION ALL
+			SELECT partition_id + 1 FROM partitions WHERE partition_id + 1 < ?3
+		)
+		INSERT INTO sweep_checkpoints (bucket_size, partition_id, checkpoint_cursor, updated_at)
+		SELECT ?1, partition_id, 0, ?2
+		FROM partitions
+		WHERE TRUE
+		ON CONFLICT (bucket_size, partition_id) DO NOTHING
+	`
+
+	// queryUpdateCheckpoint never moves a partition backwards.
+	queryUpdateCheckpoint = `
+		UPDATE sweep_checkpoints
+		SET checkpoint_cursor = MAX(checkpoint_cursor, ?1), updated_at = ?2
+		WHERE bucket_size = ?3
+		  AND partition_id >= ?4
+		  AND partition_id < ?5
+	`
+
+	queryLoadAggregates = `
+		SELECT partition_id, principal_id, rule_name, bucket_size,` + stateColumns + `
+		FROM pre_aggregates
+	`
+
+	queryRangePreAggregates = `
+		SELECT` + stateColumns + `
+		FROM pre_aggregates
+		WHERE partition_id = ?1
+		  AND principal_id = ?2
+		  AND rule_name = ?3
+		  AND bucket_size = ?4
+		  AND window_start >= ?5
+		  AND window_start < ?6
+		ORDER BY window_start ASC, dimensions ASC
+	`
+
+	// queryRangePreAggregatesWithCheckpoint reads the checkpoint of the principal's
+	// partition and the pre-aggregates in one statement, which SQLite runs on one snapshot.
+	queryRangePreAggregatesWithCheckpoint = `
+		WITH checkpoint AS (
+			SELECT COALESCE(
+				(SELECT checkpoint_cursor FROM sweep_checkpoints WHERE bucket_size = ?4 AND partition_id = ?1),
+				0
+			) AS checkpoint_cursor
+		),
+		scoped AS (
+			SELECT` + stateColumns + `
+			FROM pre_aggregates
+			WHERE partition_id = ?1
+			  AND principal_id = ?2
+			  AND rule_name = ?3
+			  AND bucket_size = ?4
+			  AND window_start >= ?5
+			  AND window_start < ?6
+		)
+		SELECT
+			checkpoint.checkpoint_cursor,
+			scoped.window_start,
+			scoped.dimensions,
+			scoped.operator,
+			scoped.value,
+			scoped.aux_sum,
+			scoped.value_at,
+			scoped.sketch,
+			scoped.event_count,
+			scoped.last_event_id,
+			scoped.rule_fingerprint,
+			scoped.updated_at
+		FROM checkpoint
+		LEFT JOIN scoped ON TRUE
+		ORDER BY scoped.window_start ASC NULLS LAST, scoped.dimensions ASC
+	`
+
+	// queryRangesPreAggregatesWithCheckpoint is the batch form of
+	// queryRangePreAggregatesWithCheckpoint: it returns one checkpoint row per partition
+	// of the JSON array ?2, joined with the pre-aggregates of every principal in ?3 and
+	// rule in ?4.
+	queryRangesPreAggregatesWithCheckpoint = `
+		WITH checkpoints AS (
+			SELECT
+				p.value AS partition_id,
+				COALESCE(c.checkpoint_cursor, 0) AS checkpoint_cursor
+			FROM json_each(?2) AS p
+			LEFT JOIN sweep_checkpoints c
+			  ON c.bucket_size = ?1
+			 AND c.partition_id = p.value
+		),
+		scoped AS (
+			SELECT partition_id, principal_id, rule_name,` + stateColumns + `
+			FROM pre_aggregates
+			WHERE partition_id IN (SELECT value FROM json_each(?2))
+			  AND principal_id IN (SELECT value FROM json_each(?3))
+			  AND rule_name IN (SELECT value FROM json_each(?4))
+			  AND bucket_size = ?1
+			  AND window_start >= ?5
+			  AND window_start < ?6
+		)
+		SELECT
+			checkpoints.partition_id,
+			checkpoints.checkpoint_cursor,
+			scoped.principal_id,
+			scoped.rule_name,
+			scoped.window_start,
+			scoped.dimensions,
+			scoped.operator,
+			scoped.value,
+			scoped.aux_sum,
+			scoped.value_at,
+			scoped.sketch,
+			scoped.event_count,
+			scoped.last_event_id,
+			scoped.rule_fingerprint,
+			scoped.updated_at
+		FROM checkpoints
+		LEFT JOIN scoped ON scoped.partition_id = checkpoints.partition_id
+		ORDER BY checkpoints.partition_id ASC, scoped.principal_id ASC, scoped.rule_name ASC,
+			scoped.window_start ASC NULLS LAST, scoped.dimensions ASC
+	`
+
+	// queryRulePreAggregatesWithCheckpoints reads one rule's pre-aggregates of every
+	// principal together with the checkpoint of every partition (?5 partitions).
+	// Served by idx_pre_aggregates_rule_window.
+	queryRulePreAggregatesWithCheckpoints = `
+		WITH RECURSIVE partitions(partition_id) AS (
+			SELECT 0
+			UNION ALL
+			SELECT partition_id + 1 FROM partitions WHERE partition_id + 1 < ?5
+		),
+		checkpoints AS (
+			SELECT
+				p.partition_id,
+				COALESCE(c.checkpoint_cursor, 0) AS checkpoint_cursor
+			FROM partitions p
+			LEFT JOIN sweep_checkpoints c
+			  ON c.bucket_size = ?2
+			 AND c.partition_id = p.partition_id
+		),
+		scoped AS (
+			SELECT partition_id, principal_id,` + stateColumns + `
+			FROM pre_aggregates
+			WHERE rule_name = ?1
+			  AND bucket_size = ?2
+			  AND window_start >= ?3
+			  AND window_start < ?4
+		)
+		SELECT
+			checkpoints.partition_id,
+			checkpoints.checkpoint_cursor,
+			scoped.principal_id,
+			scoped.window_start,
+			scoped.dimensions,
+			scoped.operator,
+			scoped.value,
+			scoped.aux_sum,
+			scoped.value_at,
+			scoped.sketch,
+			scoped.event_count,
+			scoped.last_event_id,
+			scoped.rule_fingerprint,
+			scoped.updated_at
+		FROM checkpoints
+		LEFT JOIN scoped ON scoped.partition_id = checkpoints.partition_id
+		ORDER BY checkpoints.partition_id ASC, scoped.principal_id ASC,
+			scoped.window_start ASC NULLS LAST, scoped.dimensions ASC
+	`
+)
+
+// PreAggregateAdapter implements aggregation.PreAggregateStore on SQLite, together with
+// the partition checkpoints, verification, repair and shadow rebuild extensions of the
+// PostgreSQL store. Flush and checkpoint writes are in a single transaction — the
+// atomicity contract that makes crash recovery safe.
+//
+// Like on PostgreSQL, checkpoints are kept per bucket size and partition. SQLite
+// serializes writers, so aggregation runs one shard per bucket size, which flushes all
+// partitions at once.
+type PreAggregateAdapter struct {
+	db *sql.DB
+}
+
+// NewPreAggregateAdapter creates a new PreAggregateAdapter sharing the given connection
+// pool, which must have been opened by NewAdapter.
+func NewPreAggregateAdapter(db *sql.DB) *PreAggregateAdapter {
+	return &PreAggregateAdapter{db: db}
+}
+
+// Flush merges all pre-aggregates into their durable rows and advances the checkpoint of
+// every partition to cursor in one transaction. cursor is the last ingest_seq included
+// in this state snapshot. The transaction holds the database write lock from its start,
+// so no other flush can move a checkpoint between the stale check and the commit.
+// Partitions at different checkpoints must be flushed per range with FlushPartitions.
+func (a *PreAggregateAdapter) Flush(
+	ctx context.Context,
+	aggregates map[aggregation.AggregateKey]aggregation.AggregateState,
+	cursor int64,
+	bucketSize string,
+) error {
+	if bucketSize == "" {
+		bucketSize = defaultBucketSize
+	}
+
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("pre_aggregate flush: begin tx: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	checkpoints, err := checkpointsForWrite(ctx, tx, bucketSize, partition.Full())
+	if err != nil {
+		return fmt.Errorf("pre_aggregate flush: %w", err)
+	}
+	if checkpoints.Min() != checkpoints.Max() {
+		return fmt.Errorf(
+			"pre_aggregate flush: partition checkpoints of bucket %s differ (%d..%d); flush per partition range",
+			bucketSize, checkpoints.Min(), checkpoints.Max(),
+		)
+	}
+	if durableCursor := checkpoints.Max(); cursor <= durableCursor {
+		slog.Warn("[PreAggregateAdapter] Skipping stale/no-op flush",
+			"cursor", cursor,
+			"durable_cursor", durableCursor,
+			"aggregates", len(aggregates))
+		return nil
+	}
+
+	if err := checkRuleFingerprints(ctx, tx, aggregates, bucketSize); err != nil {
+		return fmt.Errorf("pre_aggregate flush: %w", err)
+	}
+	if err := upsertAggregates(ctx, tx, aggregates, bucketSize); err != nil {
+		return fmt.Errorf("pre_aggregate flush: %w", err)
+	}
+
+	// Write the checkpoints — same transaction as the upserts.
+	if err := writeCheckpoints(ctx, tx, bucketSize, partition.Full(), cursor); err != nil {
+		return fmt.Errorf("pre_aggregate flush: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("pre_aggregate flush: commit: %w", err)
+	}
+
+	slog.Info("[PreAggregateAdapter] Flushed",
+		"aggregates", len(aggregates),
+		"cursor", cursor,
+		"bucket_size", bucketSize,
+	)
+	return nil
+}
+
+// FlushPartitions merges aggregates of one partition range into their durable rows and
+// advances the checkpoints of its partitions in one transaction. The flush is skipped as
+// stale if any checkpoint moved since the batch read from.
+func (a *PreAggregateAdapter) FlushPartitions(
+	ctx context.Context,
+	aggregates map[aggregation.AggregateKey]aggregation.AggregateState,
+	bucketSize string,
+	from aggregation.PartitionCheckpoints,
+	cursor int64,
+) error {
+	if bucketSize == "" {
+		bucketSize = defaultBucketSize
+	}
+
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("pre_aggregate flush: begin tx: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	durable, err := checkpointsForWrite(ctx, tx, bucketSize, from.Range)
+	if err != nil {
+		return fmt.Errorf("pre_aggregate flush: %w", err)
+	}
+	for i, durableCursor := range durable.Cursors {
+		if durableCursor != from.Cursors[i] {
+			slog.Warn("[PreAggregateAdapter] Skipping stale flush; partition checkpoint moved",
+				"partition", from.Range.From+i,
+				"cursor", cursor,
+				"read_cursor", from.Cursors[i],
+				"durable_cursor", durableCursor,
+				"aggregates", len(aggregates))
+			return nil
+		}
+	}
+
+	for key := range aggregates {
+		if !from.Range.Contains(key.PartitionID) {
+			return fmt.Errorf("pre_aggregate flush: aggregate partition %d outside range %s", key.PartitionID, from.Range)
+		}
+	}
+	if err := checkRuleFingerprints(ctx, tx, aggregates, bucketSize); err != nil {
+		return fmt.Errorf("pre_aggregate flush: %w", err)
+	}
+	if err := upsertAggregates(ctx, tx, aggregates, bucketSize); err != nil {
+		return fmt.Errorf("pre_aggregate flush: %w", err)
+	}
+	if err := writeCheckpoints(ctx, tx, bucketSize, from.Range, cursor); err != nil {
+		return fmt.Errorf("pre_aggregate flush: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("pre_aggregate flush: commit: %w", err)
+	}
+
+	slog.Info("[PreAggregateAdapter] Flushed",
+		"aggregates", len(aggregates),
+		"cursor", cursor,
+		"bucket_size", bucketSize,
+		"partitions", from.Range.String(),
+	)
+	return nil
+}
+
+// ReadPartitionCheckpoints returns the checkpoint of every partition in partitions.
+// Partitions without a checkpoint row yet report 0.
+func (a *PreAggregateAdapter) ReadPartitionCheckpoints(
+	ctx context.Context,
+	bucketSize string,
+	partitions partition.Range,
+) (aggregation.PartitionCheckpoints, error) {
+	if bucketSize == "" {
+		bucketSize = defaultBucketSize
+	}
+
+	rows, err := a.db.QueryContext(ctx, queryReadPartitionCheckpoints, bucketSize, partitions.From, partitions.To)
+	if err != nil {
+		return aggregation.PartitionCheckpoints{}, fmt.Errorf("read partition checkpoints: %w", err)
+	}
+	checkpoints, _, err := scanCheckpoints(rows, partitions)
+	if err != nil {
+		return aggregation.PartitionCheckpoints{}, fmt.Errorf("read partition checkpoints: %w", err)
+	}
+	return checkpoints, nil
+}
+
+// RepairAggregates replaces the rows of keys with aggregates in one transaction; keys
+// without an aggregate are deleted. It fails with aggregation.ErrCheckpointMoved unless
+// every partition is still at the checkpoint the replacements were computed at.
+func (a *PreAggregateAdapter) RepairAggregates(
+	ctx context.Context,
+	bucketSize string,
+	checkpoints map[int]int64,
+	keys []aggregation.AggregateKey,
+	aggregates map[aggregation.AggregateKey]aggregation.AggregateState,
+) error {
+	if bucketSize == "" {
+		bucketSize = defaultBucketSize
+	}
+
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("pre_aggregate repair: begin tx: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	durable, err := checkpointsForWrite(ctx, tx, bucketSize, partition.Full())
+	if err != nil {
+		return fmt.Errorf("pre_aggregate repair: %w", err)
+	}
+	partitionIDs := make([]int, 0, len(checkpoints))
+	for partitionID := range checkpoints {
+		partitionIDs = append(partitionIDs, partitionID)
+	}
+	sort.Ints(partitionIDs)
+	for _, partitionID := range partitionIDs {
+		if !durable.Range.Contains(partitionID) {
+			return fmt.Errorf("pre_aggregate repair: partition %d out of range", partitionID)
+		}
+		if durable.Cursors[partitionID] != checkpoints[partitionID] {
+			return fmt.Errorf("pre_aggregate repair: partition %d at %d, verified at %d: %w",
+				partitionID, durable.Cursors[partitionID], checkpoints[partitionID], aggregation.ErrCheckpointMoved)
+		}
+	}
+
+	for _, key := range keys {
+		if _, ok := checkpoints[key.PartitionID]; !ok {
+			return fmt.Errorf("pre_aggregate repair: partition %d of %v was not verified", key.PartitionID, key)
+		}
+	}
+	if err := checkRuleFingerprints(ctx, tx, aggregates, bucketSize); err != nil {
+		return fmt.Errorf("pre_aggregate repair: %w", err)
+	}
+
+	for _, key := range keys {
+		if _, err := tx.ExecContext(ctx, queryDeletePreAggregate,
+			key.PartitionID, key.PrincipalID, key.RuleName, bucketSize, unixMicro(key.WindowStart), key.Dimensions,
+		); err != nil {
+			return fmt.Errorf("pre_aggregate repair: delete %v: %w", key, err)
+		}
+	}
+	if err := upsertAggregates(ctx, tx, aggregates, bucketSize); err != nil {
+		return fmt.Errorf("pre_aggregate repair: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("pre_aggregate repair: commit: %w", err)
+	}
+
+	slog.Info("[PreAggregateAdapter] Repaired",
+		"rows", len(keys),
+		"replaced", len(aggregates),
+		"bucket_size", bucketSize,
+	)
+	return nil
+}
+
+// checkpointsForWrite reads the checkpoints of partitions inside tx, creating the rows
+// of the bucket size first if it has none yet. tx holds the write lock, so they cannot
+// move before it commits.
+func checkpointsForWrite(
+	ctx context.Context,
+	tx *sql.Tx,
+	bucketSize string,
+	partitions partition.Range,
+) (aggregation.PartitionCheckpoints, error) {
+	rows, err := tx.QueryContext(ctx, queryReadPartitionCheckpoints, bucketSize, partitions.From, partitions.To)
+	if err != nil {
+		return aggregation.PartitionCheckpoints{}, fmt.Errorf("read checkpoint: %w", err)
+	}
+	checkpoints, found, err := scanCheckpoints(rows, partitions)
+	if err != nil {
+		return aggregation.PartitionCheckpoints{}, fmt.Errorf("read checkpoint: %w", err)
+	}
+	if found == partitions.Len() {
+		return checkpoints, nil
+	}
+
+	if _, err := tx.ExecContext(ctx, queryInitCheckpointRows, bucketSize, unixMicro(time.Now()), partition.Count); err != nil {
+		return aggregation.PartitionCheckpoints{}, fmt.Errorf("init checkpoint rows: %w", err)
+	}
+	rows, err = tx.QueryContext(ctx, queryReadPartitionCheckpoints, bucketSize, partitions.From, partitions.To)
+	if err != nil {
+		return aggregation.PartitionCheckpoints{}, fmt.Errorf("read initialized checkpoint: %w", err)
+	}
+	checkpoints, found, err = scanCheckpoints(rows, partitions)
+	if err != nil {
+		return aggregation.PartitionCheckpoints{}, fmt.Errorf("read initialized checkpoint: %w", err)
+	}
+	if found != partitions.Len() {
+		return aggregation.PartitionCheckpoints{}, fmt.Errorf("checkpoint rows missing (bucket=%s, partitions=%s)", bucketSize, partitions)
+	}
+	return checkpoints, nil
+}
+
+// scanCheckpoints reads (partition_id, checkpoint_cursor) rows into checkpoints of
+// partitions and closes rows. It also returns the number of rows found.
+func scanCheckpoints(rows *sql.Rows, partitions partition.Range) (aggregation.PartitionCheckpoints, int, error) {
+	defer rows.Close()
+
+	checkpoints := aggregation.PartitionCheckpoints{Range: partitions, Cursors: make([]int64, partitions.Len())}
+	found := 0
+	for rows.Next() {
+		var partitionID int
+		var cursor int64
+		if err := rows.Scan(&partitionID, &cursor); err != nil {
+			return checkpoints, found, fmt.Errorf("scan row: %w", err)
+		}
+		if !partitions.Contains(partitionID) {
+			continue
+		}
+		checkpoints.Cursors[partitionID-partitions.From] = cursor
+		found++
+	}
+	if err := rows.Err(); err != nil {
+		return checkpoints, found, fmt.Errorf("iterate rows: %w", err)
+	}
+	return checkpoints, found, nil
+}
+
+// writeCheckpoints advances the checkpoint of every partition in partitions to cursor.
+func writeCheckpoints(ctx context.Context, tx *sql.Tx, bucketSize string, partitions partition.Range, cursor int64) error {
+	result, err := tx.ExecContext(ctx, queryUpdateCheckpoint, cursor, unixMicro(time.Now()), bucketSize, partitions.From, partitions.To)
+	if err != nil {
+		return fmt.Errorf("write checkpoint: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("check checkpoint write: %w", err)
+	}
+	if rowsAffected != int64(partitions.Len()) {
+		return fmt.Errorf("checkpoint rows missing (bucket=%s, partitions=%s)", bucketSize, partitions)
+	}
+	return nil
+}
+
+// upsertAggregates merges aggregates into their durable rows inside tx.
+func upsertAggregates(
+	ctx context.Context,
+	tx *sql.Tx,
+	aggregates map[aggregation.AggregateKey]aggregation.AggregateState,
+	bucketSize string,
+) error {
+	for _, key := range sortedAggregateKeys(aggregates) {
+		state := aggregates[key]
+		keyBucketSize := key.BucketSize
+		if keyBucketSize == "" {
+			keyBucketSize = defaultBucketSize
+		}
+		if keyBucketSize != bucketSize {
+			return fmt.Errorf(
+				"aggregate bucket mismatch: expected %s, got %s for key %v",
+				bucketSize,
+				keyBucketSize,
+				key,
+			)
+		}
+
+		var durable aggregateColumns
+		err := tx.QueryRowContext(ctx, querySelectPreAggregate,
+			key.PartitionID, key.PrincipalID, key.RuleName, keyBucketSize, unixMicro(key.WindowStart), key.Dimensions,
+		).Scan(durable.dest()...)
+		switch {
+		case err == sql.ErrNoRows:
+		case err != nil:
+			return fmt.Errorf("read %v: %w", key, err)
+		default:
+			durableState, _, err := durable.state()
+			if err != nil {
+				return fmt.Errorf("read %v: %w", key, err)
+			}
+			state = mergeDurable(durableState, state)
+		}
+
+		if err := writeAggregate(ctx, tx, key, keyBucketSize, state); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeDurable merges a flushed partial into the durable state of its row, the way the
+// PostgreSQL upsert merges them: values by operator, counts added, the partial's
+// metadata winning.
+func mergeDurable(durable, partial aggregation.AggregateState) aggregation.AggregateState {
+	merged := partial
+	if agg, ok := aggregation.Operators[partial.Operator]; ok {
+		merged = agg.Merge(durable, partial)
+		merged.Operator = partial.Operator
+	}
+	merged.EventCount = durable.EventCount + partial.EventCount
+	merged.LastEventID = partial.LastEventID
+	merged.RuleFingerprint = partial.RuleFingerprint
+	merged.UpdatedAt = partial.UpdatedAt
+	return merged
+}
+
+// writeAggregate replaces the row of key with state.
+func writeAggregate(
+	ctx context.Context,
+	tx *sql.Tx,
+	key aggregation.AggregateKey,
+	bucketSize string,
+	state aggregation.AggregateState,
+) error {
+	sketch, err := marshalSketch(state)
+	if err != nil {
+		return fmt.Errorf("%v: %w", key, err)
+	}
+	if _, err := tx.ExecContext(ctx, queryUpsertPreAggregate,
+		key.PartitionID,
+		key.PrincipalID,
+		key.RuleName,
+		state.RuleFingerprint,
+		bucketSize,
+		unixMicro(key.WindowStart),
+		key.Dimensions,
+		state.Operator,
+		state.Value.String(),
+		state.Sum.String(),
+		nullUnixMicro(state.ValueAt),
+		sketch,
+		state.EventCount,
+		state.LastEventID,
+		unixMicro(state.UpdatedAt),
+	); err != nil {
+		return fmt.Errorf("upsert %v: %w", key, err)
+	}
+	return nil
+}
+
+func sortedAggregateKeys(aggregates map[aggregation.AggregateKey]aggregation.AggregateState) []aggregation.AggregateKey {
+	keys := make([]aggregation.AggregateKey, 0, len(aggregates))
+	for key := range aggregates {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.PartitionID != b.PartitionID {
+			return a.PartitionID < b.PartitionID
+		}
+		if a.PrincipalID != b.PrincipalID {
+			return a.PrincipalID < b.PrincipalID
+		}
+		if a.RuleName != b.RuleName {
+			return a.RuleName < b.RuleName
+		}
+		if !a.WindowStart.Equal(b.WindowStart) {
+			return a.WindowStart.Before(b.WindowStart)
+		}
+		return a.Dimensions < b.Dimensions
+	})
+	return keys
+}
+
+// ReadCheckpoint returns the bucket-scoped checkpoint cursor, the lowest checkpoint of
+// its partitions. Returns 0 if no checkpoint exists yet (meaning "replay from beginning").
+func (a *PreAggregateAdapter) ReadCheckpoint(ctx context.Context, bucketSize string) (int64, error) {
+	if bucketSize == "" {
+		bucketSize = defaultBucketSize
+	}
+
+	var cursor int64
+	if err := a.db.QueryRowContext(ctx, queryReadCheckpoint, bucketSize).Scan(&cursor); err != nil {
+		return 0, fmt.Errorf("read global checkpoint: %w", err)
+	}
+	return cursor, nil
+}
+
+// LoadAggregates loads all durable pre-aggregates from the database.
+// Used during recovery to bootstrap StateMap before replaying delta events.
+func (a *PreAggregateAdapter) LoadAggregates(ctx context.Context) (map[aggregation.AggregateKey]aggregation.AggregateState, error) {
+	rows, err := a.db.QueryContext(ctx, queryLoadAggregates)
+	if err != nil {
+		return nil, fmt.Errorf("load aggregates: %w", err)
+	}
+	defer rows.Close()
+
+	aggregates := make(map[aggregation.AggregateKey]aggregation.AggregateState)
+	for rows.Next() {
+		var (
+			key     aggregation.AggregateKey
+			columns aggregateColumns
+		)
+		dest := append([]any{&key.PartitionID, &key.PrincipalID, &key.RuleName, &key.BucketSize}, columns.dest()...)
+		if err := rows.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("load aggregates: scan row: %w", err)
+		}
+		state, _, err := columns.state()
+		if err != nil {
+			return nil, fmt.Errorf("load aggregates: %w", err)
+		}
+		key.WindowStart = state.WindowStart
+		key.Dimensions = state.Dimensions
+		aggregates[key] = state
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("load aggregates: iterate rows: %w", err)
+	}
+
+	slog.Info("[PreAggregateAdapter] Loaded aggregates from database", "count", len(aggregates))
+	return aggregates, nil
+}
+
+// QueryRange fetches pre-aggregates for a time range.
+// Used by projection API to serve usage queries.
+// Returns aggregates ordered by window_start ASC.
+func (a *PreAggregateAdapter) QueryRange(
+	ctx context.Context,
+	principalID string,
+	ruleName string,
+	bucketSize string,
+	startTime time.Time,
+	endTime time.Time,
+) ([]aggregation.AggregateState, error) {
+	if bucketSize == "" {
+		bucketSize = defaultBucketSize
+	}
+
+	rows, err := a.db.QueryContext(ctx, queryRangePreAggregates,
+		partition.For(principalID), principalID, ruleName, bucketSize, unixMicro(startTime), unixMicro(endTime),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query pre_aggregates: %w", err)
+	}
+	defer rows.Close()
+
+	var results []aggregation.AggregateState
+	for rows.Next() {
+		var columns aggregateColumns
+		if err := rows.Scan(columns.dest()...); err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		state, _, err := columns.state()
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, state)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate rows: %w", err)
+	}
+
+	return results, nil
+}
+
+// QueryRangeWithCheckpoint fetches pre-aggregates and the checkpoint of the principal's
+// partition from one statement snapshot. This prevents races where checkpoint and aggregates are read
+// from different flush versions.
+func (a *PreAggregateAdapter) QueryRangeWithCheckpoint(
+	ctx context.Context,
+	principalID string,
+	ruleName string,
+	bucketSize string,
+	startTime time.Time,
+	endTime time.Time,
+) ([]aggregation.AggregateState, int64, error) {
+	if bucketSize == "" {
+		bucketSize = defaultBucketSize
+	}
+
+	rows, err := a.db.QueryContext(ctx, queryRangePreAggregatesWithCheckpoint,
+		partition.For(principalID), principalID, ruleName, bucketSize, unixMicro(startTime), unixMicro(endTime),
+	)
+	if err != nil {
+		return nil, 0, fmt.Errorf("query pre_aggregates with checkpoint: %w", err)
+	}
+	defer rows.Close()
+
+	var (
+		results    []aggregation.AggregateState
+		checkpoint int64
+	)
+	for rows.Next() {
+		var columns aggregateColumns
+		if err := rows.Scan(append([]any{&checkpoint}, columns.dest()...)...); err != nil {
+			return nil, 0, fmt.Errorf("scan row: %w", err)
+		}
+		state, ok, err := columns.state()
+		if err != nil {
+			return nil, 0, err
+		}
+		if ok {
+			results = append(results, state)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("iterate rows: %w", err)
+	}
+
+	return results, checkpoint, nil
+}
+
+// QueryRangesWithCheckpoint is the batch form of QueryRangeWithCheckpoint. It reads the
+// pre-aggregates of every principal and rule at one bucket size in a single statement,
+// each paired with the checkpoint of its principal's partition. The result holds an
+// entry for every principal and rule, empty if the range has no pre-aggregates.
+func (a *PreAggregateAdapter) QueryRangesWithCheckpoint(
+	ctx context.Context,
+	principalIDs []string,
+	ruleNames []string,
+	bucketSize string,
+	startTime time.Time,
+	endTime time.Time,
+) (map[aggregation.RangeScope]aggregation.ScopedRange, error) {
+	if bucketSize == "" {
+		bucketSize = defaultBucketSize
+	}
+
+	partitionSet := make(map[int]bool, len(principalIDs))
+	partitionIDs := make([]int, 0, len(principalIDs))
+	for _, principalID := range principalIDs {
+		partitionID := partition.For(principalID)
+		if !partitionSet[partitionID] {
+			partitionSet[partitionID] = true
+			partitionIDs = append(partitionIDs, partitionID)
+		}
+	}
+	sort.Ints(partitionIDs)
+
+	partitionsJSON, err := json.Marshal(partitionIDs)
+	if err != nil {
+		return nil, fmt.Errorf("encode partition IDs: %w", err)
+	}
+	principalsJSON, err := json.Marshal(principalIDs)
+	if err != nil {
+		return nil, fmt.Errorf("encode principal IDs: %w", err)
+	}
+	rulesJSON, err := json.Marshal(ruleNames)
+	if err != nil {
+		return nil, fmt.Errorf("encode rule names: %w", err)
+	}
+
+	rows, err := a.db.QueryContext(ctx, queryRangesPreAggregatesWithCheckpoint,
+		bucketSize, string(partitionsJSON), string(principalsJSON), string(rulesJSON), unixMicro(startTime), unixMicro(endTime),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query pre_aggregates with checkpoints: %w", err)
+	}
+	defer rows.Close()
+
+	checkpoints := make(map[int]int64, len(partitionIDs))
+	states := make(map[aggregation.RangeScope][]aggregation.AggregateState)
+	for rows.Next() {
+		var (
+			partitionID int
+			checkpoint  int64
+			principalID sql.NullString
+			ruleName    sql.NullString
+			columns     aggregateColumns
+		)
+
+		if err := rows.Scan(append([]any{&partitionID, &checkpoint, &principalID, &ruleName}, columns.dest()...)...); err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		checkpoints[partitionID] = checkpoint
+
+		state, ok, err := columns.state()
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			scope := aggregation.RangeScope{PrincipalID: principalID.String, RuleName: ruleName.String}
+			states[scope] = append(states[scope], state)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate rows: %w", err)
+	}
+
+	results := make(map[aggregation.RangeScope]aggregation.ScopedRange, len(principalIDs)*len(ruleNames))
+	for _, principalID := range principalIDs {
+		checkpoint := checkpoints[partition.For(principalID)]
+		for _, ruleName := range ruleNames {
+			scope := aggregation.RangeScope{PrincipalID: principalID, RuleName: ruleName}
+			results[scope] = aggregation.ScopedRange{States: states[scope], Checkpoint: checkpoint}
+		}
+	}
+	return results, nil
+}
+
+// ScanRuleRangeWithCheckpoints streams the pre-aggregates of one rule over a range for
+// every principal to fn, in one statement with the checkpoint of every partition. The
+// returned checkpoints cover all partitions.
+func (a *PreAggregateAdapter) ScanRuleRangeWithCheckpoints(
+	ctx context.Context,
+	ruleName string,
+	bucketSize string,
+	startTime time.Time,
+	endTime time.Time,
+	fn func(principalID string, state aggregation.AggregateState),
+) (aggregation.PartitionCheckpoints, error) {
+	if bucketSize == "" {
+		bucketSize = defaultBucketSize
+	}
+
+	checkpoints := aggregation.PartitionCheckpoints{
+		Range:   partition.Full(),
+		Cursors: make([]int64, partition.Count),
+	}
+
+	rows, err := a.db.QueryContext(ctx, queryRulePreAggregatesWithCheckpoints,
+		ruleName, bucketSize, unixMicro(startTime), unixMicro(endTime), partition.Count,
+	)
+	if err != nil {
+		return checkpoints, fmt.Errorf("query rule pre_aggregates with checkpoints: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			partitionID int
+			checkpoint  int64
+			principalID sql.NullString
+			columns     aggregateColumns
+		)
+
+		if err := rows.Scan(append([]any{&partitionID, &checkpoint, &principalID}, columns.dest()...)...); err != nil {
+			return checkpoints, fmt.Errorf("scan row: %w", err)
+		}
+		if partitionID < 0 || partitionID >= partition.Count {
+			return checkpoints, fmt.Errorf("scan row: partition %d out of range", partitionID)
+		}
+		checkpoints.Cursors[partitionID] = checkpoint
+
+		state, ok, err := columns.state()
+		if err != nil {
+			return checkpoints, err
+		}
+		if ok {
+			fn(principalID.String, state)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return checkpoints, fmt.Errorf("iterate rows: %w", err)
+	}
+	return checkpoints, nil
+}
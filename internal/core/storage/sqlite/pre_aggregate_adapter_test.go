@@ -0,0 +1,15 @@
+//go:build cgo
+
+package sqlite
+
+import (
+	"testing"
+
+	"github.com/aevon-lab/project-aevon/internal/core/storage/storetest"
+)
+
+func TestPreAggregateAdapter(t *testing.T) {
+	storetest.RunPreAggregateStore(t, func(t *testing.T) storetest.PreAggregateStore {
+		return NewPreAggregateAdapter(newTestAdapter(t).DB())
+	})
+}
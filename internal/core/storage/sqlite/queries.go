@@ -0,0 +1,178 @@
+package sqlite
+
+// SQL queries for event storage operations. Parameters are numbered (?NNN) so a query
+// can reference one argument several times. Timestamps are bound as Unix microseconds.
+
+const (
+	// querySaveEvent inserts an event with principal idempotency. ON CONFLICT DO NOTHING
+	// returns no rows (sql.ErrNoRows) for duplicates.
+	querySaveEvent = `
+		INSERT INTO events (
+			id, principal_id, type, schema_version,
+			occurred_at, ingested_at, metadata, data, partition_id
+		)
+		VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9)
+		ON CONFLICT (principal_id, id) DO NOTHING
+		RETURNING ingest_seq
+	`
+
+	// queryRetrieveEventsAfterCursor fetches events of all principals after a cursor
+	// (ingest_seq) in strict total order.
+	queryRetrieveEventsAfterCursor = `
+		SELECT
+			id, principal_id, type, schema_version,
+			occurred_at, ingested_at, metadata, data, ingest_seq
+		FROM events
+		WHERE ingest_seq > ?1
+		ORDER BY ingest_seq ASC
+		LIMIT ?2
+	`
+
+	// queryRetrievePartitionEventsAfterCursor fetches events of one partition range after
+	// a cursor. Served by idx_events_partition_seq.
+	queryRetrievePartitionEventsAfterCursor = `
+		SELECT
+			id, principal_id, type, schema_version,
+			occurred_at, ingested_at, metadata, data, ingest_seq
+		FROM events
+		WHERE ingest_seq > ?1
+		  AND partition_id >= ?2
+		  AND partition_id < ?3
+		ORDER BY ingest_seq ASC
+		LIMIT ?4
+	`
+
+	// queryRetrieveEventsAfter - DEPRECATED: Use queryRetrieveEventsAfterCursor
+	queryRetrieveEventsAfter = `
+		SELECT
+			id, principal_id, type, schema_version,
+			occurred_at, ingested_at, metadata, data, ingest_seq
+		FROM events
+		WHERE ingested_at > ?1
+		ORDER BY ingested_at ASC, ingest_seq ASC
+		LIMIT ?2
+	`
+
+	// queryRetrieveEventsByPrincipalIngestedRange fetches raw events for one principal
+	// in an ingested_at time range.
+	queryRetrieveEventsByPrincipalIngestedRange = `
+		SELECT
+			id, principal_id, type, schema_version,
+			occurred_at, ingested_at, metadata, data, ingest_seq
+		FROM events
+		WHERE principal_id = ?1
+		  AND ingested_at >= ?2
+		  AND ingested_at <= ?3
+		ORDER BY ingested_at ASC, ingest_seq ASC
+		LIMIT ?4
+	`
+
+	// queryFirstIngestSeqAfter finds the first event of a principal ingested after a time.
+	queryFirstIngestSeqAfter = `
+		SELECT COALESCE(MIN(ingest_seq), 0)
+		FROM events
+		WHERE principal_id = ?1
+		  AND ingested_at > ?2
+	`
+
+	// queryFirstTypeIngestSeqAfter finds the first event of a type ingested after a time.
+	queryFirstTypeIngestSeqAfter = `
+		SELECT COALESCE(MIN(ingest_seq), 0)
+		FROM events
+		WHERE type = ?1
+		  AND ingested_at > ?2
+	`
+
+	// queryRetrieveScopedEventsAfterCursor fetches unflushed events for one query scope.
+	queryRetrieveScopedEventsAfterCursor = `
+		SELECT
+			id, principal_id, type, schema_version,
+			occurred_at, ingested_at, metadata, data, ingest_seq
+		FROM events
+		WHERE ingest_seq > ?1
+		  AND principal_id = ?2
+		  AND type = ?3
+		  AND occurred_at >= ?4
+		  AND occurred_at < ?5
+		ORDER BY ingest_seq ASC
+		LIMIT ?6
+	`
+
+	// queryRetrieveScopedEventsAfterCursorWithIngested also returns the events ingested in the
+	// range, for rules that bucket late events by ingestion time.
+	queryRetrieveScopedEventsAfterCursorWithIngested = `
+		SELECT
+			id, principal_id, type, schema_version,
+			occurred_at, ingested_at, metadata, data, ingest_seq
+		FROM events
+		WHERE ingest_seq > ?1
+		  AND principal_id = ?2
+		  AND type = ?3
+		  AND ((occurred_at >= ?4 AND occurred_at < ?5)
+		    OR (ingested_at >= ?4 AND ingested_at < ?5))
+		ORDER BY ingest_seq ASC
+		LIMIT ?6
+	`
+
+	// queryRetrieveTypeScopedEventsAfterCursor is queryRetrieveScopedEventsAfterCursor for
+	// every principal. Served by idx_events_type_seq.
+	queryRetrieveTypeScopedEventsAfterCursor = `
+		SELECT
+			id, principal_id, type, schema_version,
+			occurred_at, ingested_at, metadata, data, ingest_seq
+		FROM events
+		WHERE ingest_seq > ?1
+		  AND type = ?2
+		  AND occurred_at >= ?3
+		  AND occurred_at < ?4
+		ORDER BY ingest_seq ASC
+		LIMIT ?5
+	`
+
+	// queryRetrieveTypeScopedEventsAfterCursorWithIngested also returns the events ingested in the
+	// range, for rules that bucket late events by ingestion time.
+	queryRetrieveTypeScopedEventsAfterCursorWithIngested = `
+		SELECT
+			id, principal_id, type, schema_version,
+			occurred_at, ingested_at, metadata, data, ingest_seq
+		FROM events
+		WHERE ingest_seq > ?1
+		  AND type = ?2
+		  AND ((occurred_at >= ?3 AND occurred_at < ?4)
+		    OR (ingested_at >= ?3 AND ingested_at < ?4))
+		ORDER BY ingest_seq ASC
+		LIMIT ?5
+	`
+
+	// queryRetrieveBatchScopedEventsAfterCursor is queryRetrieveScopedEventsAfterCursor for
+	// the principals of the JSON array ?2.
+	queryRetrieveBatchScopedEventsAfterCursor = `
+		SELECT
+			id, principal_id, type, schema_version,
+			occurred_at, ingested_at, metadata, data, ingest_seq
+		FROM events
+		WHERE ingest_seq > ?1
+		  AND principal_id IN (SELECT value FROM json_each(?2))
+		  AND type = ?3
+		  AND occurred_at >= ?4
+		  AND occurred_at < ?5
+		ORDER BY ingest_seq ASC
+		LIMIT ?6
+	`
+
+	// queryRetrieveBatchScopedEventsAfterCursorWithIngested also returns the events ingested in the
+	// range, for rules that bucket late events by ingestion time.
+	queryRetrieveBatchScopedEventsAfterCursorWithIngested = `
+		SELECT
+			id, principal_id, type, schema_version,
+			occurred_at, ingested_at, metadata, data, ingest_seq
+		FROM events
+		WHERE ingest_seq > ?1
+		  AND principal_id IN (SELECT value FROM json_each(?2))
+		  AND type = ?3
+		  AND ((occurred_at >= ?4 AND occurred_at < ?5)
+		    OR (ingested_at >= ?4 AND ingested_at < ?5))
+		ORDER BY ingest_seq ASC
+		LIMIT ?6
+	`
+)
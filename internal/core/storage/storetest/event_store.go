@@ -0,0 +1,211 @@
+// Package storetest holds the behavior every event and pre-aggregate store shares, as
+// tests that run against any implementation. A store's own tests call RunEventStore and
+// RunPreAggregateStore with a constructor of an empty store; parts of the contract that
+// rest on optional interfaces are skipped for stores that do not implement them.
+package storetest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v1 "github.com/aevon-lab/project-aevon/internal/api/v1"
+	"github.com/aevon-lab/project-aevon/internal/core/partition"
+	"github.com/aevon-lab/project-aevon/internal/core/storage"
+	"github.com/stretchr/testify/require"
+)
+
+// RunEventStore runs the event store contract against stores made by newStore.
+func RunEventStore(t *testing.T, newStore func(t *testing.T) storage.EventStore) {
+	t.Run("SaveEventsAssignsSequenceAndReportsDuplicates", func(t *testing.T) {
+		testSaveEventsAssignsSequenceAndReportsDuplicates(t, newStore(t))
+	})
+	t.Run("ScopedEventReads", func(t *testing.T) {
+		testScopedEventReads(t, newStore(t))
+	})
+}
+
+func testEvent(id, principalID, eventType string, occurredAt time.Time) *v1.Event {
+	return &v1.Event{
+		ID:            id,
+		PrincipalID:   principalID,
+		Type:          eventType,
+		SchemaVersion: 1,
+		OccurredAt:    occurredAt,
+		IngestedAt:    occurredAt.Add(time.Second),
+		Data:          map[string]interface{}{"tokens": float64(12)},
+	}
+}
+
+func eventIDs(events []*v1.Event) []string {
+	var result []string
+	for _, evt := range events {
+		result = append(result, evt.ID)
+	}
+	return result
+}
+
+func testSaveEventsAssignsSequenceAndReportsDuplicates(t *testing.T, store storage.EventStore) {
+	ctx := context.Background()
+	at := time.Date(2026, 3, 1, 10, 0, 0, 123456000, time.UTC)
+
+	first := testEvent("evt-1", "user:alice", "api.request", at)
+	first.Metadata = map[string]string{"source": "sdk"}
+	require.NoError(t, store.SaveEvent(ctx, first))
+	require.Positive(t, first.IngestSeq)
+	require.ErrorIs(t, store.SaveEvent(ctx, testEvent("evt-1", "user:alice", "api.request", at)), storage.ErrDuplicate)
+
+	batch := []*v1.Event{
+		testEvent("evt-2", "user:alice", "api.request", at),
+		testEvent("evt-1", "user:alice", "api.request", at), // already stored
+		testEvent("evt-1", "user:bob", "api.request", at),
+		testEvent("evt-2", "user:alice", "api.request", at), // repeats the batch
+	}
+	results, err := store.SaveEvents(ctx, batch)
+	require.NoError(t, err)
+	require.Equal(t, []error{nil, storage.ErrDuplicate, nil, storage.ErrDuplicate}, results)
+	require.Less(t, first.IngestSeq, batch[0].IngestSeq)
+	require.Less(t, batch[0].IngestSeq, batch[2].IngestSeq)
+	require.Zero(t, batch[1].IngestSeq)
+
+	events, err := store.RetrieveEventsAfterCursor(ctx, 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, []string{"evt-1", "evt-2", "evt-1"}, eventIDs(events))
+	require.Equal(t, first, events[0])
+
+	// Saved events are copies: modifying the saved or the read event changes neither.
+	first.Data["tokens"] = float64(99)
+	events[0].Data["tokens"] = float64(7)
+	events, err = store.RetrieveEventsAfterCursor(ctx, 0, 1)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.Equal(t, float64(12), events[0].Data["tokens"])
+
+	events, err = store.RetrieveEventsAfterCursor(ctx, first.IngestSeq, 1)
+	require.NoError(t, err)
+	require.Equal(t, []string{"evt-2"}, eventIDs(events))
+	require.Equal(t, batch[0].IngestSeq, events[0].IngestSeq)
+}
+
+func testScopedEventReads(t *testing.T, store storage.EventStore) {
+	ctx := context.Background()
+	start := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+
+	// evt-4 occurred before the range but was ingested in it.
+	late := testEvent("evt-4", "user:carol", "api.request", start.Add(-time.Hour))
+	late.IngestedAt = start.Add(3 * time.Minute)
+	_, err := store.SaveEvents(ctx, []*v1.Event{
+		testEvent("evt-1", "user:alice", "api.request", start),
+		testEvent("evt-2", "user:alice", "api.login", start),
+		testEvent("evt-3", "user:bob", "api.request", start.Add(time.Minute)),
+		late,
+		testEvent("evt-5", "user:alice", "api.request", start.Add(2*time.Minute)),
+	})
+	require.NoError(t, err)
+	all, err := store.RetrieveEventsAfterCursor(ctx, 0, 10)
+	require.NoError(t, err)
+	require.Len(t, all, 5)
+	seq := func(id string) int64 {
+		for _, evt := range all {
+			if evt.ID == id {
+				return evt.IngestSeq
+			}
+		}
+		t.Fatalf("event %s not stored", id)
+		return 0
+	}
+
+	t.Run("Scoped", func(t *testing.T) {
+		scoped, err := store.RetrieveScopedEventsAfterCursor(ctx, seq("evt-1"), "user:alice", "api.request", start, end, false, 10)
+		require.NoError(t, err)
+		require.Equal(t, []string{"evt-5"}, eventIDs(scoped))
+	})
+
+	t.Run("ScopedIncludingIngested", func(t *testing.T) {
+		scoped, err := store.RetrieveScopedEventsAfterCursor(ctx, 0, "user:carol", "api.request", start, end, false, 10)
+		require.NoError(t, err)
+		require.Empty(t, scoped)
+		scoped, err = store.RetrieveScopedEventsAfterCursor(ctx, 0, "user:carol", "api.request", start, end, true, 10)
+		require.NoError(t, err)
+		require.Equal(t, []string{"evt-4"}, eventIDs(scoped))
+	})
+
+	t.Run("Ingested", func(t *testing.T) {
+		ingested, err := store.RetrieveEventsByPrincipalAndIngestedRange(ctx, "user:alice", start, end, 10)
+		require.NoError(t, err)
+		require.Equal(t, []string{"evt-1", "evt-2", "evt-5"}, eventIDs(ingested))
+	})
+
+	t.Run("BatchScoped", func(t *testing.T) {
+		reader, ok := store.(storage.BatchScopedEventReader)
+		if !ok {
+			t.Skip("store does not implement storage.BatchScopedEventReader")
+		}
+		batch, err := reader.RetrieveBatchScopedEventsAfterCursor(ctx, 0, []string{"user:alice", "user:bob"}, "api.request", start, end, false, 10)
+		require.NoError(t, err)
+		require.Equal(t, []string{"evt-1", "evt-3", "evt-5"}, eventIDs(batch))
+	})
+
+	t.Run("TypeScoped", func(t *testing.T) {
+		reader, ok := store.(storage.TypeScopedEventReader)
+		if !ok {
+			t.Skip("store does not implement storage.TypeScopedEventReader")
+		}
+		typed, err := reader.RetrieveTypeScopedEventsAfterCursor(ctx, 0, "api.request", start, end, false, 2)
+		require.NoError(t, err)
+		require.Equal(t, []string{"evt-1", "evt-3"}, eventIDs(typed))
+	})
+
+	t.Run("TypeScopedIncludingIngested", func(t *testing.T) {
+		reader, ok := store.(storage.TypeScopedEventReader)
+		if !ok {
+			t.Skip("store does not implement storage.TypeScopedEventReader")
+		}
+		typed, err := reader.RetrieveTypeScopedEventsAfterCursor(ctx, 0, "api.request", start, end, true, 10)
+		require.NoError(t, err)
+		require.Equal(t, []string{"evt-1", "evt-3", "evt-4", "evt-5"}, eventIDs(typed))
+		if batchReader, ok := store.(storage.BatchScopedEventReader); ok {
+			batch, err := batchReader.RetrieveBatchScopedEventsAfterCursor(
+				ctx, 0, []string{"user:bob", "user:carol"}, "api.request", start, end, true, 10)
+			require.NoError(t, err)
+			require.Equal(t, []string{"evt-3", "evt-4"}, eventIDs(batch))
+		}
+	})
+
+	t.Run("Partitioned", func(t *testing.T) {
+		reader, ok := store.(storage.PartitionedEventReader)
+		if !ok {
+			t.Skip("store does not implement storage.PartitionedEventReader")
+		}
+		bob := partition.For("user:bob")
+		partitioned, err := reader.RetrievePartitionEventsAfterCursor(ctx, 0, partition.Range{From: bob, To: bob + 1}, 10)
+		require.NoError(t, err)
+		require.Equal(t, []string{"evt-3"}, eventIDs(partitioned))
+	})
+
+	t.Run("FirstIngestSeqAfter", func(t *testing.T) {
+		reader, ok := store.(storage.IngestCursorReader)
+		if !ok {
+			t.Skip("store does not implement storage.IngestCursorReader")
+		}
+		first, err := reader.FirstIngestSeqAfter(ctx, "user:alice", start.Add(time.Minute))
+		require.NoError(t, err)
+		require.Equal(t, seq("evt-5"), first)
+		first, err = reader.FirstIngestSeqAfter(ctx, "user:alice", end)
+		require.NoError(t, err)
+		require.Zero(t, first)
+	})
+	t.Run("FirstTypeIngestSeqAfter", func(t *testing.T) {
+		reader, ok := store.(storage.TypeIngestCursorReader)
+		if !ok {
+			t.Skip("store does not implement storage.TypeIngestCursorReader")
+		}
+		first, err := reader.FirstTypeIngestSeqAfter(ctx, "api.request", start.Add(time.Minute))
+		require.NoError(t, err)
+		require.Equal(t, seq("evt-3"), first)
+		first, err = reader.FirstTypeIngestSeqAfter(ctx, "api.login", start.Add(time.Minute))
+		require.NoError(t, err)
+		require.Zero(t, first)
+	})
+}
@@ -0,0 +1,426 @@
+package storetest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	aggstore "github.com/aevon-lab/project-aevon/internal/aggregation"
+	"github.com/aevon-lab/project-aevon/internal/core/aggregation"
+	"github.com/aevon-lab/project-aevon/internal/core/partition"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+)
+
+// PreAggregateStore is the pre-aggregate store contract. Every store a database.type
+// selects implements all of it, so leaderboards, verification and range rebuilds work on
+// each of them.
+type PreAggregateStore interface {
+	aggstore.PreAggregateStore
+	aggstore.RuleVersionStore
+	aggstore.PartitionedPreAggregateStore
+	aggstore.CheckpointedRangeReader
+	aggstore.AggregateRepairer
+	aggstore.ShadowAggregateStore
+	QueryRangeWithCheckpoint(
+		ctx context.Context,
+		principalID string,
+		ruleName string,
+		bucketSize string,
+		startTime time.Time,
+		endTime time.Time,
+	) ([]aggregation.AggregateState, int64, error)
+	ScanRuleRangeWithCheckpoints(
+		ctx context.Context,
+		ruleName string,
+		bucketSize string,
+		startTime time.Time,
+		endTime time.Time,
+		fn func(principalID string, state aggregation.AggregateState),
+	) (aggregation.PartitionCheckpoints, error)
+}
+
+// RunPreAggregateStore runs the pre-aggregate store contract against stores made by
+// newStore.
+func RunPreAggregateStore(t *testing.T, newStore func(t *testing.T) PreAggregateStore) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, store PreAggregateStore)
+	}{
+		{"FlushMergesIntoStoredRows", testFlushMergesIntoStoredRows},
+		{"FlushSkipsStaleCursor", testFlushSkipsStaleCursor},
+		{"QueryRangeWithCheckpoint", testQueryRangeWithCheckpoint},
+		{"RuleVersionChangeRejectsOldFingerprint", testRuleVersionChangeRejectsOldFingerprint},
+		{"PartitionCheckpoints", testPartitionCheckpoints},
+		{"QueryRangesWithCheckpoint", testQueryRangesWithCheckpoint},
+		{"ScanRuleRangeWithCheckpoints", testScanRuleRangeWithCheckpoints},
+		{"RepairAggregates", testRepairAggregates},
+		{"ShadowAggregates", testShadowAggregates},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, newStore(t))
+		})
+	}
+}
+
+var testWindow = time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+
+func testAggregateKey(principalID, ruleName string, windowStart time.Time) aggregation.AggregateKey {
+	return aggregation.AggregateKey{
+		PartitionID: partition.For(principalID),
+		PrincipalID: principalID,
+		RuleName:    ruleName,
+		BucketSize:  "1m",
+		WindowStart: windowStart,
+	}
+}
+
+func countState(n int64, fingerprint string) aggregation.AggregateState {
+	return aggregation.AggregateState{
+		Operator:        aggregation.OpCount,
+		Value:           decimal.NewFromInt(n),
+		EventCount:      n,
+		RuleFingerprint: fingerprint,
+		UpdatedAt:       testWindow,
+	}
+}
+
+// principalsInTwoPartitions returns two principals of different partitions.
+func principalsInTwoPartitions(t *testing.T) (string, string) {
+	t.Helper()
+	require.NotEqual(t, partition.For("user:alice"), partition.For("user:bob"))
+	return "user:alice", "user:bob"
+}
+
+func testFlushMergesIntoStoredRows(t *testing.T, store PreAggregateStore) {
+	ctx := context.Background()
+	countKey := testAggregateKey("user:alice", "count_requests", testWindow)
+	avgKey := testAggregateKey("user:alice", "avg_latency", testWindow)
+	distinctKey := testAggregateKey("user:alice", "distinct_models", testWindow)
+
+	sketch := func(keys ...string) *aggregation.Sketch {
+		s := aggregation.NewSketch()
+		for _, key := range keys {
+			s.Add(key)
+		}
+		return s
+	}
+
+	flushed := sketch("a", "b")
+	require.NoError(t, store.Flush(ctx, map[aggregation.AggregateKey]aggregation.AggregateState{
+		countKey:    {Operator: aggregation.OpCount, Value: decimal.NewFromInt(2), EventCount: 2, LastEventID: "evt-2", UpdatedAt: testWindow},
+		avgKey:      {Operator: aggregation.OpAvg, Value: decimal.NewFromInt(10), Sum: decimal.NewFromInt(20), EventCount: 2, UpdatedAt: testWindow},
+		distinctKey: {Operator: aggregation.OpCountDistinct, Value: decimal.NewFromInt(2), Sketch: flushed, EventCount: 2, UpdatedAt: testWindow},
+	}, 2, "1m"))
+	// The flushed sketch is the caller's to keep modifying.
+	flushed.Add("z")
+
+	// A window in another location is the same row.
+	countKey.WindowStart = testWindow.In(time.FixedZone("CET", 3600))
+	require.NoError(t, store.Flush(ctx, map[aggregation.AggregateKey]aggregation.AggregateState{
+		countKey:    {Operator: aggregation.OpCount, Value: decimal.NewFromInt(1), EventCount: 1, LastEventID: "evt-3", UpdatedAt: testWindow},
+		avgKey:      {Operator: aggregation.OpAvg, Value: decimal.NewFromInt(40), Sum: decimal.NewFromInt(40), EventCount: 1, UpdatedAt: testWindow},
+		distinctKey: {Operator: aggregation.OpCountDistinct, Value: decimal.NewFromInt(2), Sketch: sketch("b", "c"), EventCount: 2, UpdatedAt: testWindow},
+	}, 3, "1m"))
+	countKey.WindowStart = testWindow
+
+	checkpoint, err := store.ReadCheckpoint(ctx, "1m")
+	require.NoError(t, err)
+	require.Equal(t, int64(3), checkpoint)
+
+	aggregates, err := store.LoadAggregates(ctx)
+	require.NoError(t, err)
+	require.Len(t, aggregates, 3)
+	require.Equal(t, "3", aggregates[countKey].Value.String())
+	require.Equal(t, int64(3), aggregates[countKey].EventCount)
+	require.Equal(t, "evt-3", aggregates[countKey].LastEventID)
+	require.Equal(t, "20", aggregates[avgKey].Value.String())
+	require.Equal(t, "60", aggregates[avgKey].Sum.String())
+	require.Equal(t, "3", aggregates[distinctKey].Value.String())
+	require.Equal(t, uint64(3), aggregates[distinctKey].Sketch.Estimate())
+}
+
+func testFlushSkipsStaleCursor(t *testing.T, store PreAggregateStore) {
+	ctx := context.Background()
+	key := testAggregateKey("user:alice", "count_requests", testWindow)
+	flush := func(cursor int64) error {
+		return store.Flush(ctx, map[aggregation.AggregateKey]aggregation.AggregateState{key: countState(1, "")}, cursor, "1m")
+	}
+
+	require.NoError(t, flush(5))
+	require.NoError(t, flush(5))
+	require.NoError(t, flush(4))
+
+	aggregates, err := store.LoadAggregates(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), aggregates[key].EventCount)
+	checkpoint, err := store.ReadCheckpoint(ctx, "1m")
+	require.NoError(t, err)
+	require.Equal(t, int64(5), checkpoint)
+}
+
+func testQueryRangeWithCheckpoint(t *testing.T, store PreAggregateStore) {
+	ctx := context.Background()
+	start := testWindow
+
+	states, checkpoint, err := store.QueryRangeWithCheckpoint(ctx, "user:alice", "count_requests", "1m", start, start.Add(time.Hour))
+	require.NoError(t, err)
+	require.Empty(t, states)
+	require.Zero(t, checkpoint)
+
+	aggregates := make(map[aggregation.AggregateKey]aggregation.AggregateState)
+	for i, at := range []time.Time{start.Add(time.Minute), start, start.Add(time.Hour)} {
+		aggregates[testAggregateKey("user:alice", "count_requests", at)] = countState(int64(i+1), "")
+	}
+	aggregates[testAggregateKey("user:bob", "count_requests", start)] = countState(7, "")
+	require.NoError(t, store.Flush(ctx, aggregates, 42, "1m"))
+
+	states, checkpoint, err = store.QueryRangeWithCheckpoint(ctx, "user:alice", "count_requests", "1m", start, start.Add(time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, int64(42), checkpoint)
+	require.Len(t, states, 2)
+	require.Equal(t, start, states[0].WindowStart)
+	require.Equal(t, "2", states[0].Value.String())
+	require.Equal(t, start.Add(time.Minute), states[1].WindowStart)
+	require.Equal(t, "1", states[1].Value.String())
+}
+
+func testRuleVersionChangeRejectsOldFingerprint(t *testing.T, store PreAggregateStore) {
+	ctx := context.Background()
+	key := testAggregateKey("user:alice", "count_requests", testWindow)
+	state := countState(1, "fp-1")
+
+	_, err := store.ReconcileRuleVersion(ctx, "count_requests", "1m", "fp-1")
+	require.NoError(t, err)
+	require.NoError(t, store.Flush(ctx, map[aggregation.AggregateKey]aggregation.AggregateState{key: state}, 10, "1m"))
+
+	version, err := store.ReconcileRuleVersion(ctx, "count_requests", "1m", "fp-2")
+	require.NoError(t, err)
+	require.Equal(t, int64(10), version.RebuildTarget)
+	require.True(t, version.Rebuilding())
+
+	aggregates, err := store.LoadAggregates(ctx)
+	require.NoError(t, err)
+	require.Empty(t, aggregates)
+
+	// A rejected flush writes neither rows nor the checkpoint.
+	err = store.Flush(ctx, map[aggregation.AggregateKey]aggregation.AggregateState{key: state}, 11, "1m")
+	require.ErrorIs(t, err, aggregation.ErrRuleFingerprintMismatch)
+	checkpoint, err := store.ReadCheckpoint(ctx, "1m")
+	require.NoError(t, err)
+	require.Equal(t, int64(10), checkpoint)
+
+	state.RuleFingerprint = "fp-2"
+	require.NoError(t, store.FlushRebuild(ctx, map[aggregation.AggregateKey]aggregation.AggregateState{key: state}, version, 10))
+	versions, err := store.ListRuleVersions(ctx, "count_requests")
+	require.NoError(t, err)
+	require.Len(t, versions, 1)
+	require.False(t, versions[0].Rebuilding())
+}
+
+func testPartitionCheckpoints(t *testing.T, store PreAggregateStore) {
+	ctx := context.Background()
+	alice, bob := principalsInTwoPartitions(t)
+	aliceRange := partition.Range{From: partition.For(alice), To: partition.For(alice) + 1}
+	aliceKey := testAggregateKey(alice, "count_requests", testWindow)
+
+	from, err := store.ReadPartitionCheckpoints(ctx, "1m", aliceRange)
+	require.NoError(t, err)
+	require.Equal(t, []int64{0}, from.Cursors)
+
+	// An aggregate outside the range is rejected.
+	err = store.FlushPartitions(ctx, map[aggregation.AggregateKey]aggregation.AggregateState{
+		testAggregateKey(bob, "count_requests", testWindow): countState(1, ""),
+	}, "1m", from, 5)
+	require.Error(t, err)
+
+	flush := map[aggregation.AggregateKey]aggregation.AggregateState{aliceKey: countState(2, "")}
+	require.NoError(t, store.FlushPartitions(ctx, flush, "1m", from, 5))
+	// The range moved since from was read: skipped as stale.
+	require.NoError(t, store.FlushPartitions(ctx, flush, "1m", from, 6))
+
+	states, checkpoint, err := store.QueryRangeWithCheckpoint(ctx, alice, "count_requests", "1m", testWindow, testWindow.Add(time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, int64(5), checkpoint)
+	require.Len(t, states, 1)
+	require.Equal(t, int64(2), states[0].EventCount)
+	_, checkpoint, err = store.QueryRangeWithCheckpoint(ctx, bob, "count_requests", "1m", testWindow, testWindow.Add(time.Hour))
+	require.NoError(t, err)
+	require.Zero(t, checkpoint)
+
+	// The bucket checkpoint is the lowest partition checkpoint, and partitions at
+	// different checkpoints cannot be flushed together.
+	checkpoint, err = store.ReadCheckpoint(ctx, "1m")
+	require.NoError(t, err)
+	require.Zero(t, checkpoint)
+	require.Error(t, store.Flush(ctx, nil, 7, "1m"))
+
+	version, err := store.ReconcileRuleVersion(ctx, "count_requests", "1m", "fp-1")
+	require.NoError(t, err)
+	require.Equal(t, int64(5), version.RebuildTarget)
+	require.Equal(t, int64(5), version.TargetFor(partition.For(alice)))
+	require.Zero(t, version.TargetFor(partition.For(bob)))
+}
+
+func testQueryRangesWithCheckpoint(t *testing.T, store PreAggregateStore) {
+	ctx := context.Background()
+	alice, bob := principalsInTwoPartitions(t)
+
+	require.NoError(t, store.Flush(ctx, map[aggregation.AggregateKey]aggregation.AggregateState{
+		testAggregateKey(alice, "count_requests", testWindow.Add(time.Minute)): countState(2, ""),
+		testAggregateKey(alice, "count_requests", testWindow):                  countState(1, ""),
+		testAggregateKey(alice, "count_requests", testWindow.Add(time.Hour)):   countState(9, ""), // after the range
+		testAggregateKey(bob, "count_requests", testWindow):                    countState(3, ""),
+	}, 8, "1m"))
+
+	results, err := store.QueryRangesWithCheckpoint(ctx,
+		[]string{alice, bob, "user:nobody"}, []string{"count_requests", "sum_tokens"}, "1m", testWindow, testWindow.Add(time.Hour))
+	require.NoError(t, err)
+	require.Len(t, results, 6)
+
+	aliceCount := results[aggregation.RangeScope{PrincipalID: alice, RuleName: "count_requests"}]
+	require.Equal(t, int64(8), aliceCount.Checkpoint)
+	require.Len(t, aliceCount.States, 2)
+	require.Equal(t, testWindow, aliceCount.States[0].WindowStart)
+	require.Equal(t, testWindow.Add(time.Minute), aliceCount.States[1].WindowStart)
+
+	bobCount := results[aggregation.RangeScope{PrincipalID: bob, RuleName: "count_requests"}]
+	require.Len(t, bobCount.States, 1)
+	require.Equal(t, "3", bobCount.States[0].Value.String())
+
+	nobody := results[aggregation.RangeScope{PrincipalID: "user:nobody", RuleName: "sum_tokens"}]
+	require.Empty(t, nobody.States)
+	require.Equal(t, int64(8), nobody.Checkpoint)
+}
+
+func testScanRuleRangeWithCheckpoints(t *testing.T, store PreAggregateStore) {
+	ctx := context.Background()
+	alice, bob := principalsInTwoPartitions(t)
+
+	require.NoError(t, store.Flush(ctx, map[aggregation.AggregateKey]aggregation.AggregateState{
+		testAggregateKey(alice, "count_requests", testWindow):                countState(1, ""),
+		testAggregateKey(alice, "count_requests", testWindow.Add(time.Hour)): countState(9, ""), // after the range
+		testAggregateKey(bob, "count_requests", testWindow.Add(time.Minute)): countState(3, ""),
+		testAggregateKey(bob, "sum_tokens", testWindow):                      countState(5, ""), // another rule
+	}, 4, "1m"))
+
+	totals := make(map[string]int64)
+	checkpoints, err := store.ScanRuleRangeWithCheckpoints(ctx, "count_requests", "1m", testWindow, testWindow.Add(time.Hour),
+		func(principalID string, state aggregation.AggregateState) {
+			totals[principalID] += state.EventCount
+		})
+	require.NoError(t, err)
+	require.Equal(t, map[string]int64{alice: 1, bob: 3}, totals)
+	require.True(t, checkpoints.Range.IsFull())
+	require.Equal(t, int64(4), checkpoints.Min())
+	require.Equal(t, int64(4), checkpoints.Max())
+}
+
+func testRepairAggregates(t *testing.T, store PreAggregateStore) {
+	ctx := context.Background()
+	alice, bob := principalsInTwoPartitions(t)
+	drifted := testAggregateKey(alice, "count_requests", testWindow)
+	orphaned := testAggregateKey(alice, "count_requests", testWindow.Add(time.Minute))
+	untouched := testAggregateKey(bob, "count_requests", testWindow)
+
+	require.NoError(t, store.Flush(ctx, map[aggregation.AggregateKey]aggregation.AggregateState{
+		drifted:   countState(5, ""),
+		orphaned:  countState(1, ""),
+		untouched: countState(3, ""),
+	}, 10, "1m"))
+	verified := map[int]int64{partition.For(alice): 10}
+	keys := []aggregation.AggregateKey{drifted, orphaned}
+	repaired := map[aggregation.AggregateKey]aggregation.AggregateState{drifted: countState(2, "")}
+
+	err := store.RepairAggregates(ctx, "1m", map[int]int64{partition.For(alice): 9}, keys, repaired)
+	require.ErrorIs(t, err, aggregation.ErrCheckpointMoved)
+	err = store.RepairAggregates(ctx, "1m", verified, append(keys, untouched), repaired)
+	require.Error(t, err, "keys of unverified partitions are rejected")
+
+	require.NoError(t, store.RepairAggregates(ctx, "1m", verified, keys, repaired))
+	aggregates, err := store.LoadAggregates(ctx)
+	require.NoError(t, err)
+	require.Len(t, aggregates, 2)
+	require.Equal(t, int64(2), aggregates[drifted].EventCount)
+	require.Equal(t, int64(3), aggregates[untouched].EventCount)
+}
+
+func testShadowAggregates(t *testing.T, store PreAggregateStore) {
+	ctx := context.Background()
+	alice, bob := principalsInTwoPartitions(t)
+	inside := testAggregateKey(alice, "count_requests", testWindow)
+	outside := testAggregateKey(alice, "count_requests", testWindow.Add(time.Hour))
+	otherRule := testAggregateKey(bob, "sum_tokens", testWindow)
+
+	_, err := store.ReconcileRuleVersion(ctx, "count_requests", "1m", "fp-1")
+	require.NoError(t, err)
+	require.NoError(t, store.Flush(ctx, map[aggregation.AggregateKey]aggregation.AggregateState{
+		inside:    countState(5, "fp-1"),
+		outside:   countState(1, "fp-1"),
+		otherRule: countState(4, ""),
+	}, 10, "1m"))
+	checkpoints, err := store.ReadPartitionCheckpoints(ctx, "1m", partition.Full())
+	require.NoError(t, err)
+
+	rebuilt := testAggregateKey(bob, "count_requests", testWindow.Add(time.Minute))
+	require.NoError(t, store.StageShadowAggregates(ctx, "rebuild-1", "1m", map[aggregation.AggregateKey]aggregation.AggregateState{
+		inside:  countState(2, "fp-1"),
+		rebuilt: countState(3, "fp-1"),
+	}))
+	swap := aggregation.ShadowSwap{
+		RebuildID:       "rebuild-1",
+		RuleName:        "count_requests",
+		RuleFingerprint: "fp-1",
+		BucketSize:      "1m",
+		Start:           testWindow,
+		End:             testWindow.Add(time.Hour),
+		Checkpoints:     checkpoints,
+	}
+
+	moved := swap
+	moved.Checkpoints.Cursors = append([]int64(nil), checkpoints.Cursors...)
+	moved.Checkpoints.Cursors[0] = 9
+	_, err = store.SwapShadowAggregates(ctx, moved)
+	require.ErrorIs(t, err, aggregation.ErrCheckpointMoved)
+	changed := swap
+	changed.RuleFingerprint = "fp-0"
+	_, err = store.SwapShadowAggregates(ctx, changed)
+	require.ErrorIs(t, err, aggregation.ErrRuleFingerprintMismatch)
+
+	replaced, err := store.SwapShadowAggregates(ctx, swap)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), replaced)
+
+	aggregates, err := store.LoadAggregates(ctx)
+	require.NoError(t, err)
+	require.Len(t, aggregates, 4)
+	require.Equal(t, int64(2), aggregates[inside].EventCount)
+	require.Equal(t, int64(3), aggregates[rebuilt].EventCount)
+	require.Equal(t, int64(1), aggregates[outside].EventCount)
+	require.Equal(t, int64(4), aggregates[otherRule].EventCount)
+
+	// Swapped shadow rows are gone; staged ones can be discarded.
+	replaced, err = store.SwapShadowAggregates(ctx, swap)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), replaced)
+	aggregates, err = store.LoadAggregates(ctx)
+	require.NoError(t, err)
+	require.Len(t, aggregates, 2)
+
+	require.NoError(t, store.StageShadowAggregates(ctx, "rebuild-2", "1m", map[aggregation.AggregateKey]aggregation.AggregateState{
+		inside: countState(7, "fp-1"),
+	}))
+	require.NoError(t, store.DiscardShadowAggregates(ctx, "rebuild-2"))
+	swap.RebuildID = "rebuild-2"
+	replaced, err = store.SwapShadowAggregates(ctx, swap)
+	require.NoError(t, err)
+	require.Zero(t, replaced)
+
+	version, err := store.ReconcileRuleVersion(ctx, "count_requests", "1m", "fp-2")
+	require.NoError(t, err)
+	require.True(t, version.Rebuilding())
+	swap.RuleFingerprint = "fp-2"
+	_, err = store.SwapShadowAggregates(ctx, swap)
+	require.ErrorIs(t, err, aggregation.ErrRuleRebuilding)
+}
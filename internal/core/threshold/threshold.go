@@ -0,0 +1,75 @@
+package threshold
+
+import (
+	"errors"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Sources of a threshold definition.
+const (
+	SourceConfig = "config" // declared under webhooks.thresholds, read-only at runtime
+	SourceAPI    = "api"    // registered through POST /v1/thresholds
+)
+
+// EventThresholdCrossed is the type of the webhook notification sent on a crossing.
+const EventThresholdCrossed = "threshold.crossed"
+
+var (
+	ErrThresholdNotFound = errors.New("threshold not found")
+	ErrThresholdExists   = errors.New("threshold already exists")
+)
+
+// Threshold notifies URL the first time the value of Rule for PrincipalID reaches Value
+// within a period. Periods are the specs of quota checks: calendar periods
+// (current_day, ...) cross at most once per period, rolling periods (last_24h, ...)
+// re-arm once the value falls back below Value.
+type Threshold struct {
+	ID          string          `json:"id"`
+	Rule        string          `json:"rule"`
+	PrincipalID string          `json:"principal_id"`
+	Period      string          `json:"period"`
+	Timezone    string          `json:"timezone,omitempty"` // IANA zone of calendar periods; default UTC
+	Value       decimal.Decimal `json:"value"`
+	URL         string          `json:"url"`
+	Source      string          `json:"source"`
+	CreatedAt   time.Time       `json:"created_at"`
+}
+
+// Crossing records that a threshold was reached. PeriodKey identifies the period it was
+// reached in: the period start of calendar periods, a constant for rolling periods.
+type Crossing struct {
+	ThresholdID string
+	PeriodKey   string
+	Value       decimal.Decimal
+	CrossedAt   time.Time
+}
+
+// Delivery is a pending webhook notification of the outbox. Payload is sent verbatim,
+// so retries carry the same bytes and signature input.
+type Delivery struct {
+	ID        int64
+	URL       string
+	Payload   []byte
+	Attempts  int // failed attempts so far
+	CreatedAt time.Time
+}
+
+// Notification is the JSON payload of a threshold.crossed webhook. Deliveries are at
+// least once; receivers deduplicate on EventID.
+type Notification struct {
+	EventID     string          `json:"event_id"`
+	Type        string          `json:"type"`
+	ThresholdID string          `json:"threshold_id"`
+	Rule        string          `json:"rule"`
+	PrincipalID string          `json:"principal_id"`
+	Period      string          `json:"period"`
+	Timezone    string          `json:"timezone,omitempty"`
+	Threshold   decimal.Decimal `json:"threshold"`
+	Value       decimal.Decimal `json:"value"`
+	PeriodStart time.Time       `json:"period_start"`
+	PeriodEnd   time.Time       `json:"period_end"`
+	CrossedAt   time.Time       `json:"crossed_at"`
+	DataThrough time.Time       `json:"data_through"`
+}
@@ -0,0 +1,225 @@
+package ingestion
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	v1 "github.com/aevon-lab/project-aevon/internal/api/v1"
+	httperr "github.com/aevon-lab/project-aevon/internal/core/errors"
+	"github.com/aevon-lab/project-aevon/internal/core/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// Per-event statuses reported by POST /v1/events:batch.
+const (
+	BatchStatusAccepted  = "accepted"
+	BatchStatusDuplicate = "duplicate"
+	BatchStatusRejected  = "rejected"
+)
+
+const (
+	maxBatchEvents = 1000
+
+	// batchRouteSuffix is the custom-method suffix of POST /v1/events:batch.
+	// Gin cannot register a literal ':' inside a static segment, so the route is
+	// registered as "/v1/events:batch" (a param named "batch") and the handler
+	// rejects any other suffix.
+	batchRouteSuffix = ":batch"
+
+	msgEmptyBatch    = "Batch must contain at least one event"
+	msgBatchTooLarge = "Batch exceeds maximum number of events"
+)
+
+// BatchEventResult reports the outcome for one event of a batch request.
+// Index is the event's zero-based position in the request body.
+type BatchEventResult struct {
+	Index  int                    `json:"index"`
+	ID     string                 `json:"id,omitempty"`
+	Status string                 `json:"status"` // accepted | duplicate | rejected
+	Error  *httperr.ErrorResponse `json:"error,omitempty"`
+}
+
+// BatchIngestResponse is the response body of POST /v1/events:batch.
+type BatchIngestResponse struct {
+	Accepted   int                `json:"accepted"`
+	Duplicates int                `json:"duplicates"`
+	Rejected   int                `json:"rejected"`
+	Results    []BatchEventResult `json:"results"`
+}
+
+// BatchIngestHandler handles POST /v1/events:batch.
+// The body is either a JSON array of events or NDJSON (one event per line).
+// Every event goes through the same validation as single ingestion; valid events are
+// persisted together in one transaction and each event gets its own status.
+func (s *Service) BatchIngestHandler(c *gin.Context) {
+	if c.Param("batch") != batchRouteSuffix {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	bodyBytes, readErr := s.readBody(c)
+	if readErr != nil {
+		writeError(c, readErr)
+		return
+	}
+
+	items, parseErr := splitBatchBody(bodyBytes)
+	if parseErr != nil {
+		writeError(c, parseErr)
+		return
+	}
+
+	ctx := c.Request.Context()
+	ingestedAt := time.Now().UTC()
+	resp := BatchIngestResponse{Results: make([]BatchEventResult, len(items))}
+
+	pending := make([]*v1.Event, 0, len(items))
+	pendingIdx := make([]int, 0, len(items))
+	for i, raw := range items {
+		resp.Results[i].Index = i
+
+		var evt v1.Event
+		if err := json.Unmarshal(raw, &evt); err != nil {
+			slog.Warn("Invalid JSON event in batch", "index", i, "error", err)
+			resp.Results[i].reject(&ingestionError{
+				errorType: httperr.HttpInvalidJsonError,
+				message:   msgInvalidJSON,
+			})
+			continue
+		}
+		resp.Results[i].ID = evt.ID
+
+		// All events of one request share the same receive time.
+		evt.IngestedAt = ingestedAt
+		if err := s.validateEvent(ctx, &evt); err != nil {
+			resp.Results[i].reject(err)
+			continue
+		}
+		if err := s.checkLateness(&evt); err != nil {
+			resp.Results[i].reject(err)
+			continue
+		}
+
+		pending = append(pending, &evt)
+		pendingIdx = append(pendingIdx, i)
+	}
+
+	if len(pending) > 0 {
+		saveResults, err := s.store.SaveEvents(ctx, pending)
+		if err != nil {
+			slog.Error("Failed to persist event batch", "error", err, "events", len(pending))
+			writeError(c, &ingestionError{
+				statusCode: http.StatusInternalServerError,
+				errorType:  httperr.HttpInternalError,
+				message:    msgPersistFailed,
+			})
+			return
+		}
+
+		accepted := make([]*v1.Event, 0, len(pending))
+		for j, saveErr := range saveResults {
+			result := &resp.Results[pendingIdx[j]]
+			switch {
+			case saveErr == nil:
+				result.Status = BatchStatusAccepted
+				accepted = append(accepted, pending[j])
+			case errors.Is(saveErr, storage.ErrDuplicate):
+				result.Status = BatchStatusDuplicate
+				result.Error = &httperr.ErrorResponse{
+					ErrorType: httperr.HttpDuplicateEventError,
+					Message:   msgDuplicateEvent,
+				}
+			default:
+				slog.Error("Failed to persist event", "error", saveErr, "event_id", result.ID)
+				result.reject(&ingestionError{
+					errorType: httperr.HttpInternalError,
+					message:   msgPersistFailed,
+				})
+			}
+		}
+		s.notifyIngested(accepted)
+	}
+
+	for _, result := range resp.Results {
+		switch result.Status {
+		case BatchStatusAccepted:
+			resp.Accepted++
+		case BatchStatusDuplicate:
+			resp.Duplicates++
+		default:
+			resp.Rejected++
+		}
+	}
+
+	slog.Info("Received Event Batch",
+		"events", len(items),
+		"accepted", resp.Accepted,
+		"duplicates", resp.Duplicates,
+		"rejected", resp.Rejected,
+		"payload_size", len(bodyBytes))
+
+	// Accepted events are persisted; cron batch job will pick them up on next cycle.
+	c.JSON(http.StatusAccepted, resp)
+}
+
+func (r *BatchEventResult) reject(err *ingestionError) {
+	r.Status = BatchStatusRejected
+	r.Error = &httperr.ErrorResponse{
+		ErrorType: err.errorType,
+		Message:   err.message,
+		Details:   err.details,
+	}
+}
+
+// splitBatchBody splits a batch body into raw per-event JSON documents.
+// A body whose first non-whitespace byte is '[' is decoded as a JSON array;
+// anything else is treated as NDJSON with blank lines ignored. Individual
+// documents are not decoded here so one malformed event only rejects itself.
+func splitBatchBody(body []byte) ([]json.RawMessage, *ingestionError) {
+	trimmed := bytes.TrimSpace(body)
+
+	var items []json.RawMessage
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &items); err != nil {
+			slog.Warn("Invalid JSON array batch body", "error", err, "payload_size", len(body))
+			return nil, &ingestionError{
+				statusCode: http.StatusBadRequest,
+				errorType:  httperr.HttpInvalidJsonError,
+				message:    msgInvalidJSON,
+			}
+		}
+	} else {
+		for _, line := range bytes.Split(trimmed, []byte("\n")) {
+			line = bytes.TrimSpace(line)
+			if len(line) == 0 {
+				continue
+			}
+			items = append(items, json.RawMessage(line))
+		}
+	}
+
+	if len(items) == 0 {
+		return nil, &ingestionError{
+			statusCode: http.StatusBadRequest,
+			errorType:  httperr.HttpInvalidJsonError,
+			message:    msgEmptyBatch,
+		}
+	}
+	if len(items) > maxBatchEvents {
+		return nil, &ingestionError{
+			statusCode: http.StatusBadRequest,
+			errorType:  httperr.HttpInvalidJsonError,
+			message:    msgBatchTooLarge,
+			details: map[string]interface{}{
+				"max_events": maxBatchEvents,
+				"events":     len(items),
+			},
+		}
+	}
+
+	return items, nil
+}
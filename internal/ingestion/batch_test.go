@@ -0,0 +1,250 @@
+package ingestion
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	v1 "github.com/aevon-lab/project-aevon/internal/api/v1"
+	httperr "github.com/aevon-lab/project-aevon/internal/core/errors"
+	"github.com/aevon-lab/project-aevon/internal/core/storage"
+	storagemocks "github.com/aevon-lab/project-aevon/internal/mocks/storage"
+	internalschema "github.com/aevon-lab/project-aevon/internal/schema"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func newBatchTestRouter(t *testing.T, store storage.EventStore) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	registry := internalschema.NewRegistry(nil)
+	validator := internalschema.NewValidator(internalschema.NewFormatRegistry())
+	svc := NewService(registry, validator, store, 1)
+
+	r := gin.New()
+	svc.RegisterRoutes(r)
+	return r
+}
+
+func postBatch(r *gin.Engine, path, contentType, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+	return resp
+}
+
+func TestBatchIngestHandler_JSONArrayPerEventStatus(t *testing.T) {
+	mockStore := storagemocks.NewEventStore(t)
+	mockStore.EXPECT().
+		SaveEvents(mock.Anything, mock.MatchedBy(func(events []*v1.Event) bool {
+			return len(events) == 2 &&
+				events[0].ID == "evt-1" &&
+				events[1].ID == "evt-2" &&
+				!events[0].IngestedAt.IsZero() &&
+				events[0].IngestedAt.Equal(events[1].IngestedAt)
+		})).
+		Return([]error{nil, storage.ErrDuplicate}, nil).
+		Once()
+
+	r := newBatchTestRouter(t, mockStore)
+
+	body := `[
+		{"id":"evt-1","principal_id":"user-1","type":"api.request","occurred_at":"2026-02-11T10:30:00Z","data":{}},
+		{"id":"evt-2","principal_id":"user-1","type":"api.request","occurred_at":"2026-02-11T10:31:00Z","data":{}},
+		{"id":"evt-3","type":"api.request","occurred_at":"2026-02-11T10:32:00Z","data":{}}
+	]`
+	resp := postBatch(r, "/v1/events:batch", "application/json", body)
+
+	require.Equal(t, http.StatusAccepted, resp.Code)
+
+	var result BatchIngestResponse
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &result))
+	require.Equal(t, 1, result.Accepted)
+	require.Equal(t, 1, result.Duplicates)
+	require.Equal(t, 1, result.Rejected)
+	require.Len(t, result.Results, 3)
+
+	require.Equal(t, BatchStatusAccepted, result.Results[0].Status)
+	require.Nil(t, result.Results[0].Error)
+
+	require.Equal(t, BatchStatusDuplicate, result.Results[1].Status)
+	require.Equal(t, httperr.HttpDuplicateEventError, result.Results[1].Error.ErrorType)
+
+	require.Equal(t, 2, result.Results[2].Index)
+	require.Equal(t, "evt-3", result.Results[2].ID)
+	require.Equal(t, BatchStatusRejected, result.Results[2].Status)
+	require.Equal(t, httperr.HttpInvalidJsonError, result.Results[2].Error.ErrorType)
+	require.Equal(t, "principal_id is required", result.Results[2].Error.Message)
+}
+
+func TestBatchIngestHandler_NDJSONRejectsMalformedLineOnly(t *testing.T) {
+	mockStore := storagemocks.NewEventStore(t)
+	mockStore.EXPECT().
+		SaveEvents(mock.Anything, mock.MatchedBy(func(events []*v1.Event) bool {
+			return len(events) == 2 && events[0].ID == "evt-1" && events[1].ID == "evt-3"
+		})).
+		Return([]error{nil, nil}, nil).
+		Once()
+
+	r := newBatchTestRouter(t, mockStore)
+
+	body := strings.Join([]string{
+		`{"id":"evt-1","principal_id":"user-1","type":"api.request","occurred_at":"2026-02-11T10:30:00Z","data":{}}`,
+		`{not json`,
+		``,
+		`{"id":"evt-3","principal_id":"user-1","type":"api.request","occurred_at":"2026-02-11T10:32:00Z","data":{}}`,
+	}, "\n")
+	resp := postBatch(r, "/v1/events:batch", "application/x-ndjson", body)
+
+	require.Equal(t, http.StatusAccepted, resp.Code)
+
+	var result BatchIngestResponse
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &result))
+	require.Equal(t, 2, result.Accepted)
+	require.Equal(t, 1, result.Rejected)
+	require.Equal(t, BatchStatusRejected, result.Results[1].Status)
+	require.Equal(t, httperr.HttpInvalidJsonError, result.Results[1].Error.ErrorType)
+	require.Equal(t, 2, result.Results[2].Index)
+	require.Equal(t, "evt-3", result.Results[2].ID)
+}
+
+func TestBatchIngestHandler_AllRejectedSkipsStore(t *testing.T) {
+	mockStore := storagemocks.NewEventStore(t)
+	r := newBatchTestRouter(t, mockStore)
+
+	resp := postBatch(r, "/v1/events:batch", "application/json", `[{"id":"evt-1"}]`)
+
+	require.Equal(t, http.StatusAccepted, resp.Code)
+
+	var result BatchIngestResponse
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &result))
+	require.Equal(t, 0, result.Accepted)
+	require.Equal(t, 1, result.Rejected)
+}
+
+func TestBatchIngestHandler_RequestLevelErrors(t *testing.T) {
+	tooMany := make([]map[string]interface{}, maxBatchEvents+1)
+	for i := range tooMany {
+		tooMany[i] = map[string]interface{}{}
+	}
+	tooManyBody, _ := json.Marshal(tooMany)
+
+	tests := []struct {
+		name           string
+		body           string
+		expectedStatus int
+		expectedMsg    string
+	}{
+		{
+			name:           "malformed array",
+			body:           `[{"id":"evt-1"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedMsg:    msgInvalidJSON,
+		},
+		{
+			name:           "empty body",
+			body:           "  \n ",
+			expectedStatus: http.StatusBadRequest,
+			expectedMsg:    msgEmptyBatch,
+		},
+		{
+			name:           "empty array",
+			body:           `[]`,
+			expectedStatus: http.StatusBadRequest,
+			expectedMsg:    msgEmptyBatch,
+		},
+		{
+			name:           "too many events",
+			body:           string(tooManyBody),
+			expectedStatus: http.StatusBadRequest,
+			expectedMsg:    msgBatchTooLarge,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := newBatchTestRouter(t, storagemocks.NewEventStore(t))
+			resp := postBatch(r, "/v1/events:batch", "application/json", tc.body)
+
+			require.Equal(t, tc.expectedStatus, resp.Code)
+			var errResp httperr.ErrorResponse
+			require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &errResp))
+			require.Equal(t, httperr.HttpInvalidJsonError, errResp.ErrorType)
+			require.Equal(t, tc.expectedMsg, errResp.Message)
+		})
+	}
+}
+
+func TestBatchIngestHandler_StorageError(t *testing.T) {
+	mockStore := storagemocks.NewEventStore(t)
+	mockStore.EXPECT().
+		SaveEvents(mock.Anything, mock.Anything).
+		Return(nil, errors.New("db down")).
+		Once()
+
+	r := newBatchTestRouter(t, mockStore)
+
+	body := `[{"id":"evt-1","principal_id":"user-1","type":"api.request","occurred_at":"2026-02-11T10:30:00Z","data":{}}]`
+	resp := postBatch(r, "/v1/events:batch", "application/json", body)
+
+	require.Equal(t, http.StatusInternalServerError, resp.Code)
+	var errResp httperr.ErrorResponse
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &errResp))
+	require.Equal(t, httperr.HttpInternalError, errResp.ErrorType)
+}
+
+func TestBatchIngestHandler_UnknownSuffixNotFound(t *testing.T) {
+	r := newBatchTestRouter(t, storagemocks.NewEventStore(t))
+
+	resp := postBatch(r, "/v1/events:import", "application/json", `[]`)
+	require.Equal(t, http.StatusNotFound, resp.Code)
+}
+
+func TestBatchIngestHandler_BodySizeLimit(t *testing.T) {
+	r := newBatchTestRouter(t, storagemocks.NewEventStore(t))
+
+	oversized := bytes.Repeat([]byte(" "), 1024*1024+1)
+	resp := postBatch(r, "/v1/events:batch", "application/json", string(oversized))
+	require.Equal(t, http.StatusRequestEntityTooLarge, resp.Code)
+}
+
+type recordingEventObserver struct {
+	ids []string
+}
+
+func (o *recordingEventObserver) EventsIngested(events []*v1.Event) {
+	for _, evt := range events {
+		o.ids = append(o.ids, evt.ID)
+	}
+}
+
+func TestBatchIngestHandler_NotifiesObserverOfAcceptedEvents(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockStore := storagemocks.NewEventStore(t)
+	mockStore.EXPECT().
+		SaveEvents(mock.Anything, mock.Anything).
+		Return([]error{storage.ErrDuplicate, nil}, nil).
+		Once()
+
+	svc := NewService(internalschema.NewRegistry(nil), internalschema.NewValidator(internalschema.NewFormatRegistry()), mockStore, 1)
+	observer := &recordingEventObserver{}
+	svc.UseEventObserver(observer)
+	r := gin.New()
+	svc.RegisterRoutes(r)
+
+	body := `[
+		{"id":"evt-1","principal_id":"user-1","type":"api.request","occurred_at":"2026-02-11T10:30:00Z","data":{}},
+		{"id":"evt-2","principal_id":"user-1","type":"api.request","occurred_at":"2026-02-11T10:31:00Z","data":{}}
+	]`
+	resp := postBatch(r, "/v1/events:batch", "application/json", body)
+	require.Equal(t, http.StatusAccepted, resp.Code)
+	require.Equal(t, []string{"evt-2"}, observer.ids)
+}
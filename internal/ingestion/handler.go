@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/aevon-lab/project-aevon/internal/core/aggregation"
 	httperr "github.com/aevon-lab/project-aevon/internal/core/errors"
 	"github.com/aevon-lab/project-aevon/internal/core/storage"
 	"github.com/aevon-lab/project-aevon/internal/schema"
@@ -22,6 +23,7 @@ const (
 	msgInvalidJSON    = "Invalid JSON body"
 	msgPersistFailed  = "Failed to persist event"
 	msgDuplicateEvent = "Event already exists"
+	msgLateEvent      = "Event arrived after the allowed lateness of an aggregation rule"
 
 	defaultRawQueryLimit = 1000
 	maxRawQueryLimit     = 5000
@@ -53,6 +55,11 @@ func (s *Service) IngestHandler(c *gin.Context) {
 		return
 	}
 
+	if err := s.checkLateness(evt); err != nil {
+		writeError(c, err)
+		return
+	}
+
 	slog.Info("Received Event",
 		"event_id", evt.ID,
 		"principal_id", evt.PrincipalID,
@@ -64,6 +71,7 @@ func (s *Service) IngestHandler(c *gin.Context) {
 		writeError(c, err)
 		return
 	}
+	s.notifyIngested([]*v1.Event{evt})
 
 	// Event persisted to DB. Cron batch job will pick it up on next cycle.
 	c.JSON(http.StatusAccepted, gin.H{"status": "accepted"})
@@ -143,6 +151,30 @@ func (s *Service) ListEventsHandler(c *gin.Context) {
 // parseEvent reads the raw request body and binds it into an Event struct.
 // Returns the parsed event and the raw payload size (used for structured logging upstream).
 func (s *Service) parseEvent(c *gin.Context) (*v1.Event, int, *ingestionError) {
+	bodyBytes, readErr := s.readBody(c)
+	if readErr != nil {
+		return nil, len(bodyBytes), readErr
+	}
+
+	c.Request.Body = io.NopCloser(bytes.NewReader(bodyBytes))
+
+	var evt v1.Event
+	if err := c.ShouldBindJSON(&evt); err != nil {
+		slog.Warn("Invalid JSON body received", "error", err, "payload_size", len(bodyBytes))
+		return nil, len(bodyBytes), &ingestionError{
+			statusCode: http.StatusBadRequest,
+			errorType:  httperr.HttpInvalidJsonError,
+			message:    msgInvalidJSON,
+		}
+	}
+
+	// set IngestedAt to be the time we receive the request
+	evt.IngestedAt = time.Now().UTC()
+	return &evt, len(bodyBytes), nil
+}
+
+// readBody reads the request body, enforcing the configured maximum size.
+func (s *Service) readBody(c *gin.Context) ([]byte, *ingestionError) {
 	// Enforce maximum body size to prevent OOM attacks
 	maxBytes := int64(s.maxBodySizeBytes)
 	limitedBody := io.LimitReader(c.Request.Body, maxBytes+1) // +1 to detect oversized requests
@@ -150,7 +182,7 @@ func (s *Service) parseEvent(c *gin.Context) (*v1.Event, int, *ingestionError) {
 	bodyBytes, err := io.ReadAll(limitedBody)
 	if err != nil {
 		slog.Error("Failed to read request body", "error", err)
-		return nil, 0, &ingestionError{
+		return nil, &ingestionError{
 			statusCode: http.StatusInternalServerError,
 			errorType:  httperr.HttpInternalError,
 			message:    msgReadBodyFailed,
@@ -160,7 +192,7 @@ func (s *Service) parseEvent(c *gin.Context) (*v1.Event, int, *ingestionError) {
 	// Check if body exceeds maximum size
 	if int64(len(bodyBytes)) > maxBytes {
 		slog.Warn("Request body exceeds maximum size", "size", len(bodyBytes), "max", maxBytes)
-		return nil, len(bodyBytes), &ingestionError{
+		return bodyBytes, &ingestionError{
 			statusCode: http.StatusRequestEntityTooLarge,
 			errorType:  httperr.HttpInvalidJsonError,
 			message:    "Request body exceeds maximum allowed size",
@@ -170,21 +202,7 @@ func (s *Service) parseEvent(c *gin.Context) (*v1.Event, int, *ingestionError) {
 		}
 	}
 
-	c.Request.Body = io.NopCloser(bytes.NewReader(bodyBytes))
-
-	var evt v1.Event
-	if err := c.ShouldBindJSON(&evt); err != nil {
-		slog.Warn("Invalid JSON body received", "error", err, "payload_size", len(bodyBytes))
-		return nil, len(bodyBytes), &ingestionError{
-			statusCode: http.StatusBadRequest,
-			errorType:  httperr.HttpInvalidJsonError,
-			message:    msgInvalidJSON,
-		}
-	}
-
-	// set IngestedAt to be the time we receive the request
-	evt.IngestedAt = time.Now().UTC()
-	return &evt, len(bodyBytes), nil
+	return bodyBytes, nil
 }
 
 // validateEvent runs envelope validation, then schema validation if a registry is configured
@@ -242,6 +260,32 @@ func (s *Service) validateEvent(ctx context.Context, evt *v1.Event) *ingestionEr
 	return nil
 }
 
+// checkLateness applies the late event policy of every rule the event feeds. It rejects
+// the event if a rule with the reject policy considers it late; other late events are
+// only logged, since aggregation applies their policy.
+func (s *Service) checkLateness(evt *v1.Event) *ingestionError {
+	for _, rule := range s.currentRules() {
+		if rule.SourceEvent != evt.Type || !rule.IsLate(evt.OccurredAt, evt.IngestedAt) || !rule.Matches(evt.Data, evt.Metadata) {
+			continue
+		}
+		if rule.LatePolicy == aggregation.LatePolicyReject {
+			slog.Warn("Late event rejected", "event_id", evt.ID, "rule", rule.Name, "occurred_at", evt.OccurredAt)
+			return &ingestionError{
+				statusCode: http.StatusUnprocessableEntity,
+				errorType:  httperr.HttpLateEventError,
+				message:    msgLateEvent,
+				details: map[string]interface{}{
+					"rule":             rule.Name,
+					"allowed_lateness": aggregation.BucketLabel(rule.AllowedLateness),
+					"occurred_at":      evt.OccurredAt,
+				},
+			}
+		}
+		slog.Info("Late event accepted", "event_id", evt.ID, "rule", rule.Name, "late_policy", rule.LatePolicy, "occurred_at", evt.OccurredAt)
+	}
+	return nil
+}
+
 // persistEvent saves the event to the backing store.
 func (s *Service) persistEvent(ctx context.Context, evt *v1.Event) *ingestionError {
 	if err := s.store.SaveEvent(ctx, evt); err != nil {
@@ -12,6 +12,7 @@ import (
 	"time"
 
 	v1 "github.com/aevon-lab/project-aevon/internal/api/v1"
+	"github.com/aevon-lab/project-aevon/internal/core/aggregation"
 	httperr "github.com/aevon-lab/project-aevon/internal/core/errors"
 	"github.com/aevon-lab/project-aevon/internal/core/storage"
 	storagemocks "github.com/aevon-lab/project-aevon/internal/mocks/storage"
@@ -72,6 +73,56 @@ func TestIngestHandler_Success(t *testing.T) {
 	require.Equal(t, "accepted", result["status"])
 }
 
+func TestIngestHandler_RejectsLateEvent(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockStore := storagemocks.NewEventStore(t)
+	mockStore.EXPECT().
+		SaveEvent(mock.Anything, mock.MatchedBy(func(e *v1.Event) bool {
+			return e.ID == "evt-on-time"
+		})).
+		Return(nil).
+		Once()
+
+	registry := internalschema.NewRegistry(nil)
+	validator := internalschema.NewValidator(internalschema.NewFormatRegistry())
+	svc := NewService(registry, validator, mockStore, 1)
+	svc.SetRules([]aggregation.AggregationRule{{
+		Name:            "count_requests",
+		SourceEvent:     "api.request",
+		Operator:        aggregation.OpCount,
+		AllowedLateness: 72 * time.Hour,
+		LatePolicy:      aggregation.LatePolicyReject,
+	}})
+
+	r := gin.New()
+	svc.RegisterRoutes(r)
+
+	post := func(id string, occurredAt time.Time) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(&v1.Event{
+			ID:          id,
+			PrincipalID: "user-1",
+			Type:        "api.request",
+			OccurredAt:  occurredAt,
+			Data:        map[string]interface{}{},
+		})
+		req := httptest.NewRequest(http.MethodPost, "/v1/events", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+		return resp
+	}
+
+	resp := post("evt-late", time.Now().UTC().Add(-21*24*time.Hour))
+	require.Equal(t, http.StatusUnprocessableEntity, resp.Code)
+	var errResp httperr.ErrorResponse
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &errResp))
+	require.Equal(t, httperr.HttpLateEventError, errResp.ErrorType)
+
+	resp = post("evt-on-time", time.Now().UTC().Add(-time.Hour))
+	require.Equal(t, http.StatusAccepted, resp.Code)
+}
+
 func TestIngestHandler_InvalidJSON(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 
@@ -1,16 +1,30 @@
 package ingestion
 
 import (
+	"sync"
+
+	v1 "github.com/aevon-lab/project-aevon/internal/api/v1"
+	"github.com/aevon-lab/project-aevon/internal/core/aggregation"
 	"github.com/aevon-lab/project-aevon/internal/core/storage"
 	"github.com/aevon-lab/project-aevon/internal/schema"
 	"github.com/gin-gonic/gin"
 )
 
+// EventObserver is notified of events after ingestion persisted them. It runs on the
+// request goroutine and must not block.
+type EventObserver interface {
+	EventsIngested(events []*v1.Event)
+}
+
 type Service struct {
 	registry         *schema.Registry
 	validator        *schema.Validator
 	store            storage.EventStore
 	maxBodySizeBytes int
+	observers        []EventObserver
+
+	mu    sync.RWMutex // guards rules, which are swapped on rule reload
+	rules []aggregation.AggregationRule
 }
 
 func NewService(reg *schema.Registry, val *schema.Validator, repo storage.EventStore, maxBodySizeMB int) *Service {
@@ -34,10 +48,39 @@ func NewService(reg *schema.Registry, val *schema.Validator, repo storage.EventS
 	}
 }
 
+// SetRules replaces the aggregation rules whose late event policy applies at ingestion.
+func (s *Service) SetRules(rules []aggregation.AggregationRule) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rules = rules
+}
+
+func (s *Service) currentRules() []aggregation.AggregationRule {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.rules
+}
+
+// UseEventObserver adds an observer notified of every persisted event. Must be called
+// before the routes serve requests.
+func (s *Service) UseEventObserver(observer EventObserver) {
+	s.observers = append(s.observers, observer)
+}
+
+func (s *Service) notifyIngested(events []*v1.Event) {
+	if len(events) == 0 {
+		return
+	}
+	for _, observer := range s.observers {
+		observer.EventsIngested(events)
+	}
+}
+
 // RegisterRoutes registers the ingestion service routes.
 func (s *Service) RegisterRoutes(r gin.IRouter) {
 	// Canonical ingestion endpoint.
 	r.POST("/v1/events", s.IngestHandler)
+	r.POST("/v1/events"+batchRouteSuffix, s.BatchIngestHandler)
 	r.GET("/v1/events/:principal_id", s.ListEventsHandler)
 
 	// Backward-compatible alias. Can be removed after clients migrate.
@@ -0,0 +1,23 @@
+-- Composite aggregate state for avg, first and last operators
+--
+-- Migration: 002_add_composite_aggregate_state
+-- Date: 2026-10-16
+--
+-- avg keeps its running sum in aux_sum; the mean in value is derived from
+-- aux_sum / event_count on every merge.
+-- first/last keep the occurred_at of the event that produced value in value_at.
+
+ALTER TABLE pre_aggregates
+    ADD COLUMN IF NOT EXISTS aux_sum NUMERIC NOT NULL DEFAULT 0;
+
+ALTER TABLE pre_aggregates
+    ADD COLUMN IF NOT EXISTS value_at TIMESTAMPTZ;
+
+COMMENT ON COLUMN pre_aggregates.operator IS
+    'Aggregation operator: count | sum | min | max | avg | first | last';
+
+COMMENT ON COLUMN pre_aggregates.aux_sum IS
+    'avg only: running sum of the aggregated field. value = aux_sum / event_count. 0 for other operators.';
+
+COMMENT ON COLUMN pre_aggregates.value_at IS
+    'first/last only: occurred_at of the event that produced value. NULL for other operators.';
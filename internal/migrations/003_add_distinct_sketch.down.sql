@@ -0,0 +1,6 @@
+-- Rollback 003_add_distinct_sketch
+
+ALTER TABLE pre_aggregates DROP COLUMN IF EXISTS sketch;
+
+COMMENT ON COLUMN pre_aggregates.operator IS
+    'Aggregation operator: count | sum | min | max | avg | first | last';
@@ -0,0 +1,7 @@
+-- Rollback 004_add_quantile_operator
+
+COMMENT ON COLUMN pre_aggregates.operator IS
+    'Aggregation operator: count | sum | min | max | avg | first | last | count_distinct';
+
+COMMENT ON COLUMN pre_aggregates.sketch IS
+    'count_distinct only: serialized HyperLogLog sketch. value is its estimate. NULL for other operators.';
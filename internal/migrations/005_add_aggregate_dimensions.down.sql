@@ -0,0 +1,13 @@
+-- Rollback 005_add_aggregate_dimensions
+--
+-- Grouped rows cannot be represented without the dimensions column and are removed.
+
+DELETE FROM pre_aggregates WHERE dimensions <> '';
+
+ALTER TABLE pre_aggregates DROP CONSTRAINT IF EXISTS pre_aggregates_pkey;
+ALTER TABLE pre_aggregates DROP COLUMN IF EXISTS dimensions;
+ALTER TABLE pre_aggregates
+    ADD PRIMARY KEY (partition_id, principal_id, rule_name, bucket_size, window_start);
+
+COMMENT ON TABLE pre_aggregates IS
+    'Pre-computed usage buckets. One row per (partition, principal, rule, bucket_size, window).';
@@ -0,0 +1,23 @@
+-- Leader election for aggregation streams
+--
+-- Migration: 007_add_aggregation_leases
+-- Date: 2026-10-16
+--
+-- Replicas sharing one database campaign for one lease per aggregation stream
+-- (e.g. "scheduler/1m", "rule_rebuilder"). Only the holder of an unexpired lease
+-- drains the stream; when it dies, the lease expires and another replica takes over.
+
+CREATE TABLE IF NOT EXISTS aggregation_leases
+(
+    lease_name  TEXT PRIMARY KEY,
+    holder      TEXT        NOT NULL,
+    acquired_at TIMESTAMPTZ NOT NULL,
+    renewed_at  TIMESTAMPTZ NOT NULL,
+    expires_at  TIMESTAMPTZ NOT NULL
+);
+
+COMMENT ON TABLE aggregation_leases IS
+    'One lease per aggregation stream. The holder renews it; an expired lease may be taken over.';
+
+COMMENT ON COLUMN aggregation_leases.holder IS
+    'Replica ID (aggregation.replica_id) of the current leader.';
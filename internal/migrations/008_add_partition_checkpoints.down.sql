@@ -0,0 +1,37 @@
+-- Rollback 008_add_partition_checkpoints
+--
+-- Drain every partition range to a common cursor before rolling back: the collapsed
+-- checkpoint keeps the lowest partition cursor, so events after it are re-aggregated
+-- for partitions that were ahead.
+
+ALTER TABLE rule_versions
+    DROP COLUMN IF EXISTS partition_targets;
+
+UPDATE sweep_checkpoints c
+SET checkpoint_cursor = m.checkpoint_cursor
+FROM (
+    SELECT bucket_size, MIN(checkpoint_cursor) AS checkpoint_cursor
+    FROM sweep_checkpoints
+    GROUP BY bucket_size
+) m
+WHERE c.bucket_size = m.bucket_size
+  AND c.partition_id = 0;
+
+DELETE FROM sweep_checkpoints
+WHERE partition_id <> 0;
+
+ALTER TABLE sweep_checkpoints
+    DROP CONSTRAINT IF EXISTS sweep_checkpoints_pkey;
+
+ALTER TABLE sweep_checkpoints
+    DROP COLUMN IF EXISTS partition_id;
+
+ALTER TABLE sweep_checkpoints
+    ADD PRIMARY KEY (bucket_size);
+
+DROP INDEX IF EXISTS idx_events_partition_seq;
+
+ALTER TABLE events
+    DROP COLUMN IF EXISTS partition_id;
+
+DROP FUNCTION IF EXISTS aevon_partition_for(TEXT);
@@ -0,0 +1,10 @@
+-- Ingestion time lookups per principal
+--
+-- Migration: 009_add_events_ingested_index
+-- Date: 2026-10-16
+--
+-- Point-in-time ("as_of") state queries look up the first event of a principal
+-- ingested after a given time; the raw event listing filters on the same columns.
+
+CREATE INDEX IF NOT EXISTS idx_events_principal_ingested
+    ON events (principal_id, ingested_at);
@@ -0,0 +1,4 @@
+-- Rollback 010_add_rule_leaderboard_indexes
+
+DROP INDEX IF EXISTS idx_events_type_seq;
+DROP INDEX IF EXISTS idx_pre_aggregates_rule_window;
@@ -0,0 +1,76 @@
+-- Rollback 013_partition_events_by_ingested_at
+--
+-- Copies the attached partitions back into one unpartitioned table. Partitions detached
+-- by retention stay standalone tables and are not copied back.
+
+ALTER SEQUENCE events_ingest_seq_seq OWNED BY NONE;
+
+ALTER TABLE events
+    RENAME TO events_partitioned;
+
+DROP INDEX IF EXISTS idx_events_projection_tail;
+DROP INDEX IF EXISTS idx_events_partition_seq;
+DROP INDEX IF EXISTS idx_events_principal_ingested;
+DROP INDEX IF EXISTS idx_events_type_seq;
+
+CREATE TABLE events
+(
+    id             TEXT        NOT NULL,
+    principal_id   TEXT        NOT NULL,
+    type           TEXT        NOT NULL,
+    schema_version INTEGER     NOT NULL,
+    occurred_at    TIMESTAMPTZ NOT NULL,
+    ingested_at    TIMESTAMPTZ NOT NULL,
+    metadata       JSONB,
+    data           JSONB       NOT NULL,
+    ingest_seq     BIGINT      NOT NULL DEFAULT nextval('events_ingest_seq_seq'),
+    partition_id   INT GENERATED ALWAYS AS (aevon_partition_for(principal_id)) STORED,
+
+    PRIMARY KEY (principal_id, id)
+);
+
+INSERT INTO events (id, principal_id, type, schema_version, occurred_at, ingested_at, metadata, data, ingest_seq)
+SELECT id, principal_id, type, schema_version, occurred_at, ingested_at, metadata, data, ingest_seq
+FROM events_partitioned
+ON CONFLICT (principal_id, id) DO NOTHING;
+
+DROP TABLE events_partitioned;
+
+ALTER SEQUENCE events_ingest_seq_seq OWNED BY events.ingest_seq;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_events_ingest_seq ON events (ingest_seq);
+
+CREATE INDEX IF NOT EXISTS idx_events_projection_tail
+    ON events (principal_id, type, ingest_seq);
+
+CREATE INDEX IF NOT EXISTS idx_events_partition_seq
+    ON events (partition_id, ingest_seq);
+
+CREATE INDEX IF NOT EXISTS idx_events_principal_ingested
+    ON events (principal_id, ingested_at);
+
+CREATE INDEX IF NOT EXISTS idx_events_type_seq
+    ON events (type, ingest_seq);
+
+DROP TABLE IF EXISTS event_keys;
+
+COMMENT ON TABLE events IS
+    'Event store for event sourcing. Composite key (principal_id, id) ensures idempotency per principal.';
+
+COMMENT ON COLUMN events.id IS
+    'Client-provided unique event ID (unique per principal)';
+
+COMMENT ON COLUMN events.principal_id IS
+    'Principal/actor identifier (user, account, apikey). Primary dimension for usage aggregation.';
+
+COMMENT ON COLUMN events.occurred_at IS
+    'When event happened (client clock, business timestamp)';
+
+COMMENT ON COLUMN events.ingested_at IS
+    'When event was received by Aevon (server clock, audit timestamp)';
+
+COMMENT ON COLUMN events.ingest_seq IS
+    'Monotonic sequence for cursor-based pagination. Provides strict total order for crash-safe checkpointing.';
+
+COMMENT ON COLUMN events.partition_id IS
+    'Logical partition (0-255) of principal_id. Aggregation shards read their partition range by it.';
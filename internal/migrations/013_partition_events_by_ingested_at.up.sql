@@ -0,0 +1,131 @@
+-- Monthly partitions of the event log
+--
+-- Migration: 013_partition_events_by_ingested_at
+-- Date: 2026-10-16
+--
+-- events is range-partitioned by ingested_at, one partition per UTC month, so old months
+-- can be detached or dropped by the retention job instead of deleted row by row. A unique
+-- index of a partitioned table must include the partition key, so idempotency on
+-- (principal_id, id) moves to event_keys, which ingestion inserts into first.
+--
+-- The migration rewrites the events table once; run it in a maintenance window.
+
+CREATE TABLE IF NOT EXISTS event_keys
+(
+    principal_id TEXT        NOT NULL,
+    id           TEXT        NOT NULL,
+    ingested_at  TIMESTAMPTZ NOT NULL,
+
+    PRIMARY KEY (principal_id, id)
+);
+
+-- Retention deletes the keys of a retired month by it.
+CREATE INDEX IF NOT EXISTS idx_event_keys_ingested
+    ON event_keys (ingested_at);
+
+INSERT INTO event_keys (principal_id, id, ingested_at)
+SELECT principal_id, id, ingested_at
+FROM events
+ON CONFLICT (principal_id, id) DO NOTHING;
+
+-- The sequence outlives the old table, so cursors continue where they were.
+ALTER SEQUENCE events_ingest_seq_seq OWNED BY NONE;
+
+ALTER TABLE events
+    RENAME TO events_unpartitioned;
+
+ALTER TABLE events_unpartitioned
+    DROP CONSTRAINT IF EXISTS events_pkey;
+
+DROP INDEX IF EXISTS idx_events_ingest_seq;
+DROP INDEX IF EXISTS idx_events_projection_tail;
+DROP INDEX IF EXISTS idx_events_partition_seq;
+DROP INDEX IF EXISTS idx_events_principal_ingested;
+DROP INDEX IF EXISTS idx_events_type_seq;
+
+CREATE TABLE events
+(
+    id             TEXT        NOT NULL,
+    principal_id   TEXT        NOT NULL,
+    type           TEXT        NOT NULL,
+    schema_version INTEGER     NOT NULL,
+    occurred_at    TIMESTAMPTZ NOT NULL,
+    ingested_at    TIMESTAMPTZ NOT NULL,
+    metadata       JSONB,
+    data           JSONB       NOT NULL,
+    ingest_seq     BIGINT      NOT NULL DEFAULT nextval('events_ingest_seq_seq'),
+    partition_id   INT GENERATED ALWAYS AS (aevon_partition_for(principal_id)) STORED,
+
+    PRIMARY KEY (ingest_seq, ingested_at)
+) PARTITION BY RANGE (ingested_at);
+
+-- Catches events outside every monthly partition (clock skew, a stalled maintenance job).
+-- Retention never touches it; move its rows into a monthly partition by hand.
+CREATE TABLE events_default PARTITION OF events DEFAULT;
+
+-- One partition per UTC month from the oldest event through three months ahead. The
+-- maintenance job keeps creating partitions ahead from here on. Months are stepped as
+-- UTC timestamps so the session time zone cannot shift the bounds.
+DO
+$$
+DECLARE
+    month_start TIMESTAMP := date_trunc('month',
+        COALESCE((SELECT MIN(ingested_at) FROM events_unpartitioned), now()) AT TIME ZONE 'UTC');
+    last_start  TIMESTAMP := date_trunc('month', now() AT TIME ZONE 'UTC') + INTERVAL '3 months';
+BEGIN
+    WHILE month_start <= last_start
+        LOOP
+            EXECUTE format('CREATE TABLE IF NOT EXISTS %I PARTITION OF events FOR VALUES FROM (%L) TO (%L)',
+                           'events_p' || to_char(month_start, 'YYYYMM'),
+                           month_start AT TIME ZONE 'UTC',
+                           (month_start + INTERVAL '1 month') AT TIME ZONE 'UTC');
+            month_start := month_start + INTERVAL '1 month';
+        END LOOP;
+END
+$$;
+
+INSERT INTO events (id, principal_id, type, schema_version, occurred_at, ingested_at, metadata, data, ingest_seq)
+SELECT id, principal_id, type, schema_version, occurred_at, ingested_at, metadata, data, ingest_seq
+FROM events_unpartitioned;
+
+DROP TABLE events_unpartitioned;
+
+ALTER SEQUENCE events_ingest_seq_seq OWNED BY events.ingest_seq;
+
+-- Indexes are created on every partition, including the ones created later. The primary
+-- key serves cursor reads in place of idx_events_ingest_seq.
+CREATE INDEX IF NOT EXISTS idx_events_projection_tail
+    ON events (principal_id, type, ingest_seq);
+
+CREATE INDEX IF NOT EXISTS idx_events_partition_seq
+    ON events (partition_id, ingest_seq);
+
+CREATE INDEX IF NOT EXISTS idx_events_principal_ingested
+    ON events (principal_id, ingested_at);
+
+CREATE INDEX IF NOT EXISTS idx_events_type_seq
+    ON events (type, ingest_seq);
+
+COMMENT ON TABLE events IS
+    'Event store for event sourcing, range-partitioned by ingested_at month. Idempotency on (principal_id, id) is enforced by event_keys.';
+
+COMMENT ON COLUMN events.id IS
+    'Client-provided unique event ID (unique per principal)';
+
+COMMENT ON COLUMN events.principal_id IS
+    'Principal/actor identifier (user, account, apikey). Primary dimension for usage aggregation.';
+
+COMMENT ON COLUMN events.occurred_at IS
+    'When event happened (client clock, business timestamp)';
+
+COMMENT ON COLUMN events.ingested_at IS
+    'When event was received by Aevon (server clock, audit timestamp). Partition key.';
+
+COMMENT ON COLUMN events.ingest_seq IS
+    'Monotonic sequence for cursor-based pagination. Provides strict total order for crash-safe checkpointing.';
+
+COMMENT ON COLUMN events.partition_id IS
+    'Logical partition (0-255) of principal_id. Aggregation shards read their partition range by it.';
+
+COMMENT ON TABLE event_keys IS
+    'Ingested (principal_id, id) pairs. Makes ingestion idempotent across the monthly partitions of events.';
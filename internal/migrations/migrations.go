@@ -3,17 +3,28 @@ package migrations
 import (
 	"database/sql"
 	"embed"
+	"errors"
 	"fmt"
 	"log/slog"
 
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/database/sqlite3"
 	"github.com/golang-migrate/migrate/v4/source/iofs"
 )
 
 //go:embed *.sql
 var MigrationFiles embed.FS
 
+// SQLiteMigrationFiles holds the schema of the embedded SQLite backend.
+//
+//go:embed sqlite/*.sql
+var SQLiteMigrationFiles embed.FS
+
+// ErrDirtyMigration is returned when a previous migration did not complete. Aevon does not
+// start until the migration state is repaired by hand.
+var ErrDirtyMigration = errors.New("database migration state is dirty")
+
 // RunMigrations executes all pending migrations against the provided database.
 // If autoMigrate is false, it only logs the pending migrations but doesn't apply them.
 func RunMigrations(db *sql.DB, autoMigrate bool) error {
@@ -35,6 +46,32 @@ func RunMigrations(db *sql.DB, autoMigrate bool) error {
 		return fmt.Errorf("failed to create migrate instance: %w", err)
 	}
 
+	return run(m, autoMigrate)
+}
+
+// RunSQLiteMigrations is RunMigrations for a database of the SQLite backend.
+func RunSQLiteMigrations(db *sql.DB, autoMigrate bool) error {
+	sourceDriver, err := iofs.New(SQLiteMigrationFiles, "sqlite")
+	if err != nil {
+		return fmt.Errorf("failed to create migration source: %w", err)
+	}
+
+	dbDriver, err := sqlite3.WithInstance(db, &sqlite3.Config{})
+	if err != nil {
+		return fmt.Errorf("failed to create database driver: %w", err)
+	}
+
+	m, err := migrate.NewWithInstance("iofs", sourceDriver, "sqlite3", dbDriver)
+	if err != nil {
+		return fmt.Errorf("failed to create migrate instance: %w", err)
+	}
+
+	return run(m, autoMigrate)
+}
+
+// run recovers a dirty migration state and, if autoMigrate is set, applies all pending
+// migrations of m.
+func run(m *migrate.Migrate, autoMigrate bool) error {
 	// Get current version
 	version, dirty, err := m.Version()
 	if err != nil && err != migrate.ErrNilVersion {
@@ -42,16 +79,12 @@ func RunMigrations(db *sql.DB, autoMigrate bool) error {
 	}
 
 	if dirty {
-		slog.Warn("Database is in dirty state - migration was interrupted",
-			"version", version,
-			"action", "attempting automatic recovery",
-		)
-
-		// Single baseline migration in MVP allows safe force-to-current-version recovery.
-		if err := m.Force(int(version)); err != nil {
-			return fmt.Errorf("failed to recover dirty migration state at version %d: %w", version, err)
-		}
-		slog.Info("Recovered dirty migration state", "version", version)
+		// A failed migration may have left the schema anywhere between two versions, so
+		// which version it is at can only be decided by looking at it.
+		return fmt.Errorf("%w: migration %d failed or was interrupted. Check its changes in the database: "+
+			"if none were applied, run `UPDATE schema_migrations SET version = %d, dirty = false`; "+
+			"if all were applied, run `UPDATE schema_migrations SET dirty = false`; otherwise revert "+
+			"them by hand first. Then restart aevon", ErrDirtyMigration, version, int(version)-1)
 	}
 
 	if !autoMigrate {
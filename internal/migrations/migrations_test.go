@@ -0,0 +1,32 @@
+package migrations
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunSQLiteMigrations_RefusesDirtyState(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "aevon.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, RunSQLiteMigrations(db, true))
+
+	_, err = db.Exec(`UPDATE schema_migrations SET dirty = 1`)
+	require.NoError(t, err)
+	err = RunSQLiteMigrations(db, true)
+	require.ErrorIs(t, err, ErrDirtyMigration)
+
+	// The state is left for the operator to repair.
+	var dirty bool
+	require.NoError(t, db.QueryRow(`SELECT dirty FROM schema_migrations`).Scan(&dirty))
+	require.True(t, dirty)
+
+	_, err = db.Exec(`UPDATE schema_migrations SET dirty = 0`)
+	require.NoError(t, err)
+	require.NoError(t, RunSQLiteMigrations(db, true))
+}
@@ -0,0 +1,6 @@
+-- Rollback 001_create_schema
+
+DROP TABLE IF EXISTS rule_versions;
+DROP TABLE IF EXISTS sweep_checkpoints;
+DROP TABLE IF EXISTS pre_aggregates;
+DROP TABLE IF EXISTS events;
@@ -0,0 +1,102 @@
+-- Baseline schema of the embedded SQLite backend
+--
+-- Migration: 001_create_schema
+-- Date: 2026-10-16
+--
+-- Mirrors the PostgreSQL schema after migration 012 for a single process owning the
+-- database file. Timestamps are INTEGER microseconds since the Unix epoch (PostgreSQL's
+-- TIMESTAMPTZ precision), decimals are TEXT so they round-trip exactly. Checkpoints are
+-- kept per bucket size only: SQLite serializes writers, so partition shards would not
+-- aggregate in parallel.
+
+-- =============================================================================
+-- Events Table - Event sourcing store with strict total ordering
+-- =============================================================================
+
+CREATE TABLE IF NOT EXISTS events
+(
+    id             TEXT    NOT NULL,
+    principal_id   TEXT    NOT NULL,
+    type           TEXT    NOT NULL,
+    schema_version INTEGER NOT NULL,
+    occurred_at    INTEGER NOT NULL,
+    ingested_at    INTEGER NOT NULL,
+    metadata       TEXT,             -- JSON
+    data           TEXT    NOT NULL, -- JSON
+
+    -- AUTOINCREMENT never reuses a sequence, and SQLite commits writers one at a time,
+    -- so ingest_seq order is commit order.
+    ingest_seq     INTEGER PRIMARY KEY AUTOINCREMENT,
+
+    -- partition.For(principal_id), computed on insert.
+    partition_id   INTEGER NOT NULL,
+
+    UNIQUE (principal_id, id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_events_projection_tail
+    ON events (principal_id, type, ingest_seq);
+
+CREATE INDEX IF NOT EXISTS idx_events_partition_seq
+    ON events (partition_id, ingest_seq);
+
+CREATE INDEX IF NOT EXISTS idx_events_principal_ingested
+    ON events (principal_id, ingested_at);
+
+CREATE INDEX IF NOT EXISTS idx_events_type_seq
+    ON events (type, ingest_seq);
+
+-- =============================================================================
+-- Pre-Aggregates Table - Computed usage buckets
+-- =============================================================================
+
+CREATE TABLE IF NOT EXISTS pre_aggregates
+(
+    partition_id     INTEGER NOT NULL,
+    principal_id     TEXT    NOT NULL,
+    rule_name        TEXT    NOT NULL,
+    rule_fingerprint TEXT    NOT NULL,
+    bucket_size      TEXT    NOT NULL DEFAULT '1m',
+    window_start     INTEGER NOT NULL,
+    dimensions       TEXT    NOT NULL DEFAULT '',
+    operator         TEXT    NOT NULL,
+    value            TEXT    NOT NULL,
+    aux_sum          TEXT    NOT NULL DEFAULT '0',
+    value_at         INTEGER,
+    sketch           BLOB,
+    event_count      INTEGER NOT NULL DEFAULT 0,
+    last_event_id    TEXT,
+    updated_at       INTEGER NOT NULL,
+
+    PRIMARY KEY (partition_id, principal_id, rule_name, bucket_size, window_start, dimensions)
+);
+
+CREATE INDEX IF NOT EXISTS idx_pre_aggregates_rule_window
+    ON pre_aggregates (rule_name, bucket_size, window_start);
+
+-- =============================================================================
+-- Sweep Checkpoints Table - Cursor tracking per bucket size
+-- =============================================================================
+
+CREATE TABLE IF NOT EXISTS sweep_checkpoints
+(
+    bucket_size       TEXT PRIMARY KEY,
+    checkpoint_cursor INTEGER NOT NULL DEFAULT 0,
+    updated_at        INTEGER NOT NULL
+);
+
+-- =============================================================================
+-- Rule Versions Table - Active fingerprint and rebuild progress per rule
+-- =============================================================================
+
+CREATE TABLE IF NOT EXISTS rule_versions
+(
+    rule_name      TEXT    NOT NULL,
+    bucket_size    TEXT    NOT NULL,
+    fingerprint    TEXT    NOT NULL,
+    rebuild_cursor INTEGER NOT NULL DEFAULT 0,
+    rebuild_target INTEGER NOT NULL DEFAULT 0,
+    updated_at     INTEGER NOT NULL,
+
+    PRIMARY KEY (rule_name, bucket_size)
+);
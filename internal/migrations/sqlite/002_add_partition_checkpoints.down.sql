@@ -0,0 +1,24 @@
+-- Rollback 002_add_partition_checkpoints
+
+DROP TABLE IF EXISTS pre_aggregate_shadows;
+
+ALTER TABLE rule_versions
+    DROP COLUMN partition_targets;
+
+-- Every event up to the lowest partition checkpoint is aggregated for all partitions.
+CREATE TABLE sweep_checkpoints_bucket
+(
+    bucket_size       TEXT PRIMARY KEY,
+    checkpoint_cursor INTEGER NOT NULL DEFAULT 0,
+    updated_at        INTEGER NOT NULL
+);
+
+INSERT INTO sweep_checkpoints_bucket (bucket_size, checkpoint_cursor, updated_at)
+SELECT bucket_size, MIN(checkpoint_cursor), MAX(updated_at)
+FROM sweep_checkpoints
+GROUP BY bucket_size;
+
+DROP TABLE sweep_checkpoints;
+
+ALTER TABLE sweep_checkpoints_bucket
+    RENAME TO sweep_checkpoints;
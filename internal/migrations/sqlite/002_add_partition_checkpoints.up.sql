@@ -0,0 +1,67 @@
+-- Partition checkpoints and range rebuild shadows of the embedded SQLite backend
+--
+-- Migration: 002_add_partition_checkpoints
+-- Date: 2026-10-16
+--
+-- Brings the SQLite schema up to PostgreSQL migration 012, so the SQLite store serves
+-- leaderboards, verification, repair and range rebuilds like the PostgreSQL store.
+-- Checkpoints are kept per (bucket_size, partition_id); aggregation still runs one
+-- shard per bucket size, which advances all partitions together.
+
+-- SQLite cannot change a primary key, so the table is rebuilt with one row per partition,
+-- seeded from the existing bucket-wide checkpoint.
+CREATE TABLE sweep_checkpoints_partitioned
+(
+    bucket_size       TEXT    NOT NULL,
+    partition_id      INTEGER NOT NULL,
+    checkpoint_cursor INTEGER NOT NULL DEFAULT 0,
+    updated_at        INTEGER NOT NULL,
+
+    PRIMARY KEY (bucket_size, partition_id)
+);
+
+WITH RECURSIVE partitions(partition_id) AS (
+    SELECT 0
+    UNION ALL
+    SELECT partition_id + 1 FROM partitions WHERE partition_id < 255
+)
+INSERT INTO sweep_checkpoints_partitioned (bucket_size, partition_id, checkpoint_cursor, updated_at)
+SELECT c.bucket_size, p.partition_id, c.checkpoint_cursor, c.updated_at
+FROM sweep_checkpoints c
+         CROSS JOIN partitions p;
+
+DROP TABLE sweep_checkpoints;
+
+ALTER TABLE sweep_checkpoints_partitioned
+    RENAME TO sweep_checkpoints;
+
+-- Rebuild targets per partition as a JSON array; NULL when all partitions shared one
+-- checkpoint.
+ALTER TABLE rule_versions
+    ADD COLUMN partition_targets TEXT;
+
+-- =============================================================================
+-- Pre-Aggregate Shadows Table - Rows recomputed by `aevon rebuild`
+-- =============================================================================
+
+CREATE TABLE IF NOT EXISTS pre_aggregate_shadows
+(
+    rebuild_id       TEXT    NOT NULL,
+    partition_id     INTEGER NOT NULL,
+    principal_id     TEXT    NOT NULL,
+    rule_name        TEXT    NOT NULL,
+    rule_fingerprint TEXT    NOT NULL,
+    bucket_size      TEXT    NOT NULL,
+    window_start     INTEGER NOT NULL,
+    dimensions       TEXT    NOT NULL DEFAULT '',
+    operator         TEXT    NOT NULL,
+    value            TEXT    NOT NULL,
+    aux_sum          TEXT    NOT NULL DEFAULT '0',
+    value_at         INTEGER,
+    sketch           BLOB,
+    event_count      INTEGER NOT NULL DEFAULT 0,
+    last_event_id    TEXT,
+    updated_at       INTEGER NOT NULL,
+
+    PRIMARY KEY (rebuild_id, partition_id, principal_id, rule_name, bucket_size, window_start, dimensions)
+);
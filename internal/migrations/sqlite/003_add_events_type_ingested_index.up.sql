@@ -0,0 +1,9 @@
+-- Ingestion time lookups per event type
+--
+-- Migration: 003_add_events_type_ingested_index
+-- Date: 2026-10-16
+--
+-- Brings the SQLite schema up to PostgreSQL migration 014.
+
+CREATE INDEX IF NOT EXISTS idx_events_type_ingested
+    ON events (type, ingested_at);
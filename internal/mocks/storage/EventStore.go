@@ -85,29 +85,29 @@ func (_c *EventStore_RetrieveEventsAfter_Call) RunAndReturn(run func(context.Con
 	return _c
 }
 
-// RetrieveEventsByPrincipalAndIngestedRange provides a mock function with given fields: ctx, principalID, startIngestedAt, endIngestedAt, limit
-func (_m *EventStore) RetrieveEventsByPrincipalAndIngestedRange(ctx context.Context, principalID string, startIngestedAt time.Time, endIngestedAt time.Time, limit int) ([]*v1.Event, error) {
-	ret := _m.Called(ctx, principalID, startIngestedAt, endIngestedAt, limit)
+// RetrieveEventsAfterCursor provides a mock function with given fields: ctx, cursor, limit
+func (_m *EventStore) RetrieveEventsAfterCursor(ctx context.Context, cursor int64, limit int) ([]*v1.Event, error) {
+	ret := _m.Called(ctx, cursor, limit)
 
 	if len(ret) == 0 {
-		panic("no return value specified for RetrieveEventsByPrincipalAndIngestedRange")
+		panic("no return value specified for RetrieveEventsAfterCursor")
 	}
 
 	var r0 []*v1.Event
 	var r1 error
-	if rf, ok := ret.Get(0).(func(context.Context, string, time.Time, time.Time, int) ([]*v1.Event, error)); ok {
-		return rf(ctx, principalID, startIngestedAt, endIngestedAt, limit)
+	if rf, ok := ret.Get(0).(func(context.Context, int64, int) ([]*v1.Event, error)); ok {
+		return rf(ctx, cursor, limit)
 	}
-	if rf, ok := ret.Get(0).(func(context.Context, string, time.Time, time.Time, int) []*v1.Event); ok {
-		r0 = rf(ctx, principalID, startIngestedAt, endIngestedAt, limit)
+	if rf, ok := ret.Get(0).(func(context.Context, int64, int) []*v1.Event); ok {
+		r0 = rf(ctx, cursor, limit)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).([]*v1.Event)
 		}
 	}
 
-	if rf, ok := ret.Get(1).(func(context.Context, string, time.Time, time.Time, int) error); ok {
-		r1 = rf(ctx, principalID, startIngestedAt, endIngestedAt, limit)
+	if rf, ok := ret.Get(1).(func(context.Context, int64, int) error); ok {
+		r1 = rf(ctx, cursor, limit)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -115,61 +115,59 @@ func (_m *EventStore) RetrieveEventsByPrincipalAndIngestedRange(ctx context.Cont
 	return r0, r1
 }
 
-// EventStore_RetrieveEventsByPrincipalAndIngestedRange_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'RetrieveEventsByPrincipalAndIngestedRange'
-type EventStore_RetrieveEventsByPrincipalAndIngestedRange_Call struct {
+// EventStore_RetrieveEventsAfterCursor_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'RetrieveEventsAfterCursor'
+type EventStore_RetrieveEventsAfterCursor_Call struct {
 	*mock.Call
 }
 
-// RetrieveEventsByPrincipalAndIngestedRange is a helper method to define mock.On call
+// RetrieveEventsAfterCursor is a helper method to define mock.On call
 //   - ctx context.Context
-//   - principalID string
-//   - startIngestedAt time.Time
-//   - endIngestedAt time.Time
+//   - cursor int64
 //   - limit int
-func (_e *EventStore_Expecter) RetrieveEventsByPrincipalAndIngestedRange(ctx interface{}, principalID interface{}, startIngestedAt interface{}, endIngestedAt interface{}, limit interface{}) *EventStore_RetrieveEventsByPrincipalAndIngestedRange_Call {
-	return &EventStore_RetrieveEventsByPrincipalAndIngestedRange_Call{Call: _e.mock.On("RetrieveEventsByPrincipalAndIngestedRange", ctx, principalID, startIngestedAt, endIngestedAt, limit)}
+func (_e *EventStore_Expecter) RetrieveEventsAfterCursor(ctx interface{}, cursor interface{}, limit interface{}) *EventStore_RetrieveEventsAfterCursor_Call {
+	return &EventStore_RetrieveEventsAfterCursor_Call{Call: _e.mock.On("RetrieveEventsAfterCursor", ctx, cursor, limit)}
 }
 
-func (_c *EventStore_RetrieveEventsByPrincipalAndIngestedRange_Call) Run(run func(ctx context.Context, principalID string, startIngestedAt time.Time, endIngestedAt time.Time, limit int)) *EventStore_RetrieveEventsByPrincipalAndIngestedRange_Call {
+func (_c *EventStore_RetrieveEventsAfterCursor_Call) Run(run func(ctx context.Context, cursor int64, limit int)) *EventStore_RetrieveEventsAfterCursor_Call {
 	_c.Call.Run(func(args mock.Arguments) {
-		run(args[0].(context.Context), args[1].(string), args[2].(time.Time), args[3].(time.Time), args[4].(int))
+		run(args[0].(context.Context), args[1].(int64), args[2].(int))
 	})
 	return _c
 }
 
-func (_c *EventStore_RetrieveEventsByPrincipalAndIngestedRange_Call) Return(_a0 []*v1.Event, _a1 error) *EventStore_RetrieveEventsByPrincipalAndIngestedRange_Call {
+func (_c *EventStore_RetrieveEventsAfterCursor_Call) Return(_a0 []*v1.Event, _a1 error) *EventStore_RetrieveEventsAfterCursor_Call {
 	_c.Call.Return(_a0, _a1)
 	return _c
 }
 
-func (_c *EventStore_RetrieveEventsByPrincipalAndIngestedRange_Call) RunAndReturn(run func(context.Context, string, time.Time, time.Time, int) ([]*v1.Event, error)) *EventStore_RetrieveEventsByPrincipalAndIngestedRange_Call {
+func (_c *EventStore_RetrieveEventsAfterCursor_Call) RunAndReturn(run func(context.Context, int64, int) ([]*v1.Event, error)) *EventStore_RetrieveEventsAfterCursor_Call {
 	_c.Call.Return(run)
 	return _c
 }
 
-// RetrieveEventsAfterCursor provides a mock function with given fields: ctx, cursor, limit
-func (_m *EventStore) RetrieveEventsAfterCursor(ctx context.Context, cursor int64, limit int) ([]*v1.Event, error) {
-	ret := _m.Called(ctx, cursor, limit)
+// RetrieveEventsByPrincipalAndIngestedRange provides a mock function with given fields: ctx, principalID, startIngestedAt, endIngestedAt, limit
+func (_m *EventStore) RetrieveEventsByPrincipalAndIngestedRange(ctx context.Context, principalID string, startIngestedAt time.Time, endIngestedAt time.Time, limit int) ([]*v1.Event, error) {
+	ret := _m.Called(ctx, principalID, startIngestedAt, endIngestedAt, limit)
 
 	if len(ret) == 0 {
-		panic("no return value specified for RetrieveEventsAfterCursor")
+		panic("no return value specified for RetrieveEventsByPrincipalAndIngestedRange")
 	}
 
 	var r0 []*v1.Event
 	var r1 error
-	if rf, ok := ret.Get(0).(func(context.Context, int64, int) ([]*v1.Event, error)); ok {
-		return rf(ctx, cursor, limit)
+	if rf, ok := ret.Get(0).(func(context.Context, string, time.Time, time.Time, int) ([]*v1.Event, error)); ok {
+		return rf(ctx, principalID, startIngestedAt, endIngestedAt, limit)
 	}
-	if rf, ok := ret.Get(0).(func(context.Context, int64, int) []*v1.Event); ok {
-		r0 = rf(ctx, cursor, limit)
+	if rf, ok := ret.Get(0).(func(context.Context, string, time.Time, time.Time, int) []*v1.Event); ok {
+		r0 = rf(ctx, principalID, startIngestedAt, endIngestedAt, limit)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).([]*v1.Event)
 		}
 	}
 
-	if rf, ok := ret.Get(1).(func(context.Context, int64, int) error); ok {
-		r1 = rf(ctx, cursor, limit)
+	if rf, ok := ret.Get(1).(func(context.Context, string, time.Time, time.Time, int) error); ok {
+		r1 = rf(ctx, principalID, startIngestedAt, endIngestedAt, limit)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -177,39 +175,41 @@ func (_m *EventStore) RetrieveEventsAfterCursor(ctx context.Context, cursor int6
 	return r0, r1
 }
 
-// EventStore_RetrieveEventsAfterCursor_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'RetrieveEventsAfterCursor'
-type EventStore_RetrieveEventsAfterCursor_Call struct {
+// EventStore_RetrieveEventsByPrincipalAndIngestedRange_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'RetrieveEventsByPrincipalAndIngestedRange'
+type EventStore_RetrieveEventsByPrincipalAndIngestedRange_Call struct {
 	*mock.Call
 }
 
-// RetrieveEventsAfterCursor is a helper method to define mock.On call
+// RetrieveEventsByPrincipalAndIngestedRange is a helper method to define mock.On call
 //   - ctx context.Context
-//   - cursor int64
+//   - principalID string
+//   - startIngestedAt time.Time
+//   - endIngestedAt time.Time
 //   - limit int
-func (_e *EventStore_Expecter) RetrieveEventsAfterCursor(ctx interface{}, cursor interface{}, limit interface{}) *EventStore_RetrieveEventsAfterCursor_Call {
-	return &EventStore_RetrieveEventsAfterCursor_Call{Call: _e.mock.On("RetrieveEventsAfterCursor", ctx, cursor, limit)}
+func (_e *EventStore_Expecter) RetrieveEventsByPrincipalAndIngestedRange(ctx interface{}, principalID interface{}, startIngestedAt interface{}, endIngestedAt interface{}, limit interface{}) *EventStore_RetrieveEventsByPrincipalAndIngestedRange_Call {
+	return &EventStore_RetrieveEventsByPrincipalAndIngestedRange_Call{Call: _e.mock.On("RetrieveEventsByPrincipalAndIngestedRange", ctx, principalID, startIngestedAt, endIngestedAt, limit)}
 }
 
-func (_c *EventStore_RetrieveEventsAfterCursor_Call) Run(run func(ctx context.Context, cursor int64, limit int)) *EventStore_RetrieveEventsAfterCursor_Call {
+func (_c *EventStore_RetrieveEventsByPrincipalAndIngestedRange_Call) Run(run func(ctx context.Context, principalID string, startIngestedAt time.Time, endIngestedAt time.Time, limit int)) *EventStore_RetrieveEventsByPrincipalAndIngestedRange_Call {
 	_c.Call.Run(func(args mock.Arguments) {
-		run(args[0].(context.Context), args[1].(int64), args[2].(int))
+		run(args[0].(context.Context), args[1].(string), args[2].(time.Time), args[3].(time.Time), args[4].(int))
 	})
 	return _c
 }
 
-func (_c *EventStore_RetrieveEventsAfterCursor_Call) Return(_a0 []*v1.Event, _a1 error) *EventStore_RetrieveEventsAfterCursor_Call {
+func (_c *EventStore_RetrieveEventsByPrincipalAndIngestedRange_Call) Return(_a0 []*v1.Event, _a1 error) *EventStore_RetrieveEventsByPrincipalAndIngestedRange_Call {
 	_c.Call.Return(_a0, _a1)
 	return _c
 }
 
-func (_c *EventStore_RetrieveEventsAfterCursor_Call) RunAndReturn(run func(context.Context, int64, int) ([]*v1.Event, error)) *EventStore_RetrieveEventsAfterCursor_Call {
+func (_c *EventStore_RetrieveEventsByPrincipalAndIngestedRange_Call) RunAndReturn(run func(context.Context, string, time.Time, time.Time, int) ([]*v1.Event, error)) *EventStore_RetrieveEventsByPrincipalAndIngestedRange_Call {
 	_c.Call.Return(run)
 	return _c
 }
 
-// RetrieveScopedEventsAfterCursor provides a mock function with given fields: ctx, cursor, principalID, eventType, startOccurredAt, endOccurredAt, limit
-func (_m *EventStore) RetrieveScopedEventsAfterCursor(ctx context.Context, cursor int64, principalID string, eventType string, startOccurredAt time.Time, endOccurredAt time.Time, limit int) ([]*v1.Event, error) {
-	ret := _m.Called(ctx, cursor, principalID, eventType, startOccurredAt, endOccurredAt, limit)
+// RetrieveScopedEventsAfterCursor provides a mock function with given fields: ctx, cursor, principalID, eventType, startOccurredAt, endOccurredAt, includeIngested, limit
+func (_m *EventStore) RetrieveScopedEventsAfterCursor(ctx context.Context, cursor int64, principalID string, eventType string, startOccurredAt time.Time, endOccurredAt time.Time, includeIngested bool, limit int) ([]*v1.Event, error) {
+	ret := _m.Called(ctx, cursor, principalID, eventType, startOccurredAt, endOccurredAt, includeIngested, limit)
 
 	if len(ret) == 0 {
 		panic("no return value specified for RetrieveScopedEventsAfterCursor")
@@ -217,19 +217,19 @@ func (_m *EventStore) RetrieveScopedEventsAfterCursor(ctx context.Context, curso
 
 	var r0 []*v1.Event
 	var r1 error
-	if rf, ok := ret.Get(0).(func(context.Context, int64, string, string, time.Time, time.Time, int) ([]*v1.Event, error)); ok {
-		return rf(ctx, cursor, principalID, eventType, startOccurredAt, endOccurredAt, limit)
+	if rf, ok := ret.Get(0).(func(context.Context, int64, string, string, time.Time, time.Time, bool, int) ([]*v1.Event, error)); ok {
+		return rf(ctx, cursor, principalID, eventType, startOccurredAt, endOccurredAt, includeIngested, limit)
 	}
-	if rf, ok := ret.Get(0).(func(context.Context, int64, string, string, time.Time, time.Time, int) []*v1.Event); ok {
-		r0 = rf(ctx, cursor, principalID, eventType, startOccurredAt, endOccurredAt, limit)
+	if rf, ok := ret.Get(0).(func(context.Context, int64, string, string, time.Time, time.Time, bool, int) []*v1.Event); ok {
+		r0 = rf(ctx, cursor, principalID, eventType, startOccurredAt, endOccurredAt, includeIngested, limit)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).([]*v1.Event)
 		}
 	}
 
-	if rf, ok := ret.Get(1).(func(context.Context, int64, string, string, time.Time, time.Time, int) error); ok {
-		r1 = rf(ctx, cursor, principalID, eventType, startOccurredAt, endOccurredAt, limit)
+	if rf, ok := ret.Get(1).(func(context.Context, int64, string, string, time.Time, time.Time, bool, int) error); ok {
+		r1 = rf(ctx, cursor, principalID, eventType, startOccurredAt, endOccurredAt, includeIngested, limit)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -249,14 +249,15 @@ type EventStore_RetrieveScopedEventsAfterCursor_Call struct {
 //   - eventType string
 //   - startOccurredAt time.Time
 //   - endOccurredAt time.Time
+//   - includeIngested bool
 //   - limit int
-func (_e *EventStore_Expecter) RetrieveScopedEventsAfterCursor(ctx interface{}, cursor interface{}, principalID interface{}, eventType interface{}, startOccurredAt interface{}, endOccurredAt interface{}, limit interface{}) *EventStore_RetrieveScopedEventsAfterCursor_Call {
-	return &EventStore_RetrieveScopedEventsAfterCursor_Call{Call: _e.mock.On("RetrieveScopedEventsAfterCursor", ctx, cursor, principalID, eventType, startOccurredAt, endOccurredAt, limit)}
+func (_e *EventStore_Expecter) RetrieveScopedEventsAfterCursor(ctx interface{}, cursor interface{}, principalID interface{}, eventType interface{}, startOccurredAt interface{}, endOccurredAt interface{}, includeIngested interface{}, limit interface{}) *EventStore_RetrieveScopedEventsAfterCursor_Call {
+	return &EventStore_RetrieveScopedEventsAfterCursor_Call{Call: _e.mock.On("RetrieveScopedEventsAfterCursor", ctx, cursor, principalID, eventType, startOccurredAt, endOccurredAt, includeIngested, limit)}
 }
 
-func (_c *EventStore_RetrieveScopedEventsAfterCursor_Call) Run(run func(ctx context.Context, cursor int64, principalID string, eventType string, startOccurredAt time.Time, endOccurredAt time.Time, limit int)) *EventStore_RetrieveScopedEventsAfterCursor_Call {
+func (_c *EventStore_RetrieveScopedEventsAfterCursor_Call) Run(run func(ctx context.Context, cursor int64, principalID string, eventType string, startOccurredAt time.Time, endOccurredAt time.Time, includeIngested bool, limit int)) *EventStore_RetrieveScopedEventsAfterCursor_Call {
 	_c.Call.Run(func(args mock.Arguments) {
-		run(args[0].(context.Context), args[1].(int64), args[2].(string), args[3].(string), args[4].(time.Time), args[5].(time.Time), args[6].(int))
+		run(args[0].(context.Context), args[1].(int64), args[2].(string), args[3].(string), args[4].(time.Time), args[5].(time.Time), args[6].(bool), args[7].(int))
 	})
 	return _c
 }
@@ -266,7 +267,7 @@ func (_c *EventStore_RetrieveScopedEventsAfterCursor_Call) Return(_a0 []*v1.Even
 	return _c
 }
 
-func (_c *EventStore_RetrieveScopedEventsAfterCursor_Call) RunAndReturn(run func(context.Context, int64, string, string, time.Time, time.Time, int) ([]*v1.Event, error)) *EventStore_RetrieveScopedEventsAfterCursor_Call {
+func (_c *EventStore_RetrieveScopedEventsAfterCursor_Call) RunAndReturn(run func(context.Context, int64, string, string, time.Time, time.Time, bool, int) ([]*v1.Event, error)) *EventStore_RetrieveScopedEventsAfterCursor_Call {
 	_c.Call.Return(run)
 	return _c
 }
@@ -318,6 +319,65 @@ func (_c *EventStore_SaveEvent_Call) RunAndReturn(run func(context.Context, *v1.
 	return _c
 }
 
+// SaveEvents provides a mock function with given fields: ctx, events
+func (_m *EventStore) SaveEvents(ctx context.Context, events []*v1.Event) ([]error, error) {
+	ret := _m.Called(ctx, events)
+
+	if len(ret) == 0 {
+		panic("no return value specified for SaveEvents")
+	}
+
+	var r0 []error
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, []*v1.Event) ([]error, error)); ok {
+		return rf(ctx, events)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, []*v1.Event) []error); ok {
+		r0 = rf(ctx, events)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]error)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, []*v1.Event) error); ok {
+		r1 = rf(ctx, events)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// EventStore_SaveEvents_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'SaveEvents'
+type EventStore_SaveEvents_Call struct {
+	*mock.Call
+}
+
+// SaveEvents is a helper method to define mock.On call
+//   - ctx context.Context
+//   - events []*v1.Event
+func (_e *EventStore_Expecter) SaveEvents(ctx interface{}, events interface{}) *EventStore_SaveEvents_Call {
+	return &EventStore_SaveEvents_Call{Call: _e.mock.On("SaveEvents", ctx, events)}
+}
+
+func (_c *EventStore_SaveEvents_Call) Run(run func(ctx context.Context, events []*v1.Event)) *EventStore_SaveEvents_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].([]*v1.Event))
+	})
+	return _c
+}
+
+func (_c *EventStore_SaveEvents_Call) Return(_a0 []error, _a1 error) *EventStore_SaveEvents_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *EventStore_SaveEvents_Call) RunAndReturn(run func(context.Context, []*v1.Event) ([]error, error)) *EventStore_SaveEvents_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
 // NewEventStore creates a new instance of EventStore. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
 // The first argument is typically a *testing.T value.
 func NewEventStore(t interface {
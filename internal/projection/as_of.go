@@ -0,0 +1,66 @@
+package projection
+
+import (
+	"context"
+	"math"
+	"time"
+
+	v1 "github.com/aevon-lab/project-aevon/internal/api/v1"
+	"github.com/aevon-lab/project-aevon/internal/core/storage"
+)
+
+// asOfBound limits a point-in-time query to the events that were ingested by then.
+type asOfBound struct {
+	set    bool
+	cursor int64     // highest ingest_seq included; 0 if the query is bounded by time only
+	at     time.Time // latest ingested_at included; zero if the query is bounded by cursor only
+
+	// checkpointLimit is the highest checkpoint whose pre-aggregates hold no event past the bound.
+	checkpointLimit int64
+}
+
+// resolveAsOf turns the as_of and as_of_cursor parameters of req into an event bound.
+// With as_of, pre-aggregates flushed past the first event of the principal ingested after
+// it hold events the query must not see; an event store that cannot tell where that is
+// puts the limit at 0.
+func (s *Service) resolveAsOf(ctx context.Context, req AggregateQueryRequest) (asOfBound, error) {
+	if req.AsOf.IsZero() && req.AsOfCursor == 0 {
+		return asOfBound{}, nil
+	}
+
+	bound := asOfBound{set: true, cursor: req.AsOfCursor, at: req.AsOf, checkpointLimit: math.MaxInt64}
+	if req.AsOfCursor > 0 {
+		bound.checkpointLimit = req.AsOfCursor
+	}
+	if req.AsOf.IsZero() {
+		return bound, nil
+	}
+
+	reader, ok := s.eventStore.(storage.IngestCursorReader)
+	if !ok {
+		bound.checkpointLimit = 0
+		return bound, nil
+	}
+	firstAfter, err := reader.FirstIngestSeqAfter(ctx, req.PrincipalID, req.AsOf)
+	if err != nil {
+		return asOfBound{}, err
+	}
+	if firstAfter > 0 && firstAfter-1 < bound.checkpointLimit {
+		bound.checkpointLimit = firstAfter - 1
+	}
+	return bound, nil
+}
+
+// includes reports whether evt was ingested within the bound.
+func (b asOfBound) includes(evt *v1.Event) bool {
+	if b.cursor > 0 && evt.IngestSeq > b.cursor {
+		return false
+	}
+	return b.at.IsZero() || !evt.IngestedAt.After(b.at)
+}
+
+// allowsCheckpoint reports whether pre-aggregates flushed up to checkpoint contain only
+// events within the bound.
+func (b asOfBound) allowsCheckpoint(checkpoint int64) bool {
+	return !b.set || checkpoint <= b.checkpointLimit
+}
@@ -0,0 +1,518 @@
+package projection
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"time"
+
+	v1 "github.com/aevon-lab/project-aevon/internal/api/v1"
+	coreagg "github.com/aevon-lab/project-aevon/internal/core/aggregation"
+	httperr "github.com/aevon-lab/project-aevon/internal/core/errors"
+	"github.com/aevon-lab/project-aevon/internal/core/storage"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// maxBatchQueryPairs caps principals × rules of one batch state query.
+	maxBatchQueryPairs = 10000
+
+	// queryRouteSuffix is the custom-method suffix of POST /v1/state:query.
+	// Gin cannot register a literal ':' inside a static segment, so the route is
+	// registered as "/v1/state:query" (a param named "query") and the handler
+	// rejects any other suffix.
+	queryRouteSuffix = ":query"
+)
+
+// BatchQueryRequest is the request body of POST /v1/state:query. Every principal is
+// queried for every rule over the same range and granularity.
+type BatchQueryRequest struct {
+	Principals  []string  `json:"principals"`
+	Rules       []string  `json:"rules"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	Granularity string    `json:"granularity"` // default: "total"
+}
+
+// BatchQueryResponse is the response body of POST /v1/state:query.
+// Results is keyed by principal, then by rule.
+type BatchQueryResponse struct {
+	Start       time.Time                                     `json:"start"`
+	End         time.Time                                     `json:"end"`
+	Granularity string                                        `json:"granularity"`
+	Results     map[string]map[string]*AggregateQueryResponse `json:"results"`
+}
+
+// batchSnapshotReader is the batch form of checkpointSnapshotReader: it reads the
+// pre-aggregates of many principals and rules at one bucket size in one statement.
+type batchSnapshotReader interface {
+	QueryRangesWithCheckpoint(
+		ctx context.Context,
+		principalIDs []string,
+		ruleNames []string,
+		bucketSize string,
+		startTime time.Time,
+		endTime time.Time,
+	) (map[coreagg.RangeScope]coreagg.ScopedRange, error)
+}
+
+// batchPair is the query state of one principal and rule of a batch query.
+type batchPair struct {
+	req        AggregateQueryRequest
+	rule       coreagg.AggregationRule
+	inner      timeRange   // whole buckets of the range, served from pre-aggregates
+	edges      []timeRange // folded from raw events (see splitRange)
+	candidates []string    // usable bucket sizes, coarsest first; tried in order
+	bucketSize string
+	checkpoint int64
+	rebuilding bool
+	preAggs    []coreagg.AggregateState
+	tail       map[bucketKey]coreagg.AggregateState
+}
+
+// HandleBatchQuery handles POST /v1/state:query.
+func (s *Service) HandleBatchQuery(c *gin.Context) {
+	if c.Param("query") != queryRouteSuffix {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	var req BatchQueryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, httperr.ErrorResponse{
+			ErrorType: httperr.HttpInvalidJsonError,
+			Message:   "Invalid request body",
+			Details:   err.Error(),
+		})
+		return
+	}
+
+	queryCtx, cancel := context.WithTimeout(c.Request.Context(), s.queryTimeout)
+	defer cancel()
+	resp, err := s.QueryAggregatesBatch(queryCtx, req)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			c.JSON(http.StatusGatewayTimeout, httperr.ErrorResponse{
+				ErrorType: httperr.HttpInternalError,
+				Message:   fmt.Sprintf("Projection query timed out after %s", s.queryTimeout),
+				Details:   map[string]interface{}{"timeout": s.queryTimeout.String()},
+			})
+			return
+		}
+
+		if errors.Is(err, ErrInvalidQuery) {
+			c.JSON(http.StatusBadRequest, httperr.ErrorResponse{
+				ErrorType: httperr.HttpInvalidJsonError,
+				Message:   "Invalid aggregate query",
+				Details:   err.Error(),
+			})
+			return
+		}
+
+		c.JSON(http.StatusInternalServerError, httperr.ErrorResponse{
+			ErrorType: httperr.HttpInternalError,
+			Message:   "Failed to query aggregates",
+			Details:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// QueryAggregatesBatch answers QueryAggregates for every principal and rule of req.
+// Stores that support batch reads serve it with one pre-aggregate statement per bucket
+// size and one raw tail scan per event type; others get one query per pair.
+func (s *Service) QueryAggregatesBatch(ctx context.Context, req BatchQueryRequest) (*BatchQueryResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	req, err := normalizeBatchRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	pairs := make([]*batchPair, 0, len(req.Principals)*len(req.Rules))
+	for _, ruleName := range req.Rules {
+		rule, ok := s.rule(ruleName)
+		if !ok {
+			return nil, invalidQueryf("unknown rule: %s", ruleName)
+		}
+
+		// Validation and bucket choice only depend on the rule, so the first principal
+		// stands in for all of them.
+		probe, err := s.normalizeAndValidate(AggregateQueryRequest{
+			PrincipalID: req.Principals[0],
+			Rule:        ruleName,
+			Start:       req.Start,
+			End:         req.End,
+			Granularity: req.Granularity,
+		})
+		if err != nil {
+			return nil, err
+		}
+		inner, edges := splitRange(probe.Start, probe.End, rule.Buckets()[0])
+		innerProbe := probe
+		innerProbe.Start, innerProbe.End = inner.start, inner.end
+		candidates, err := bucketCandidates(rule, innerProbe)
+		if err != nil {
+			return nil, err
+		}
+		if inner.empty() {
+			candidates = nil
+		} else {
+			candidates, err = s.excludeRebuildingBuckets(ctx, rule, candidates)
+			if err != nil {
+				return nil, fmt.Errorf("read rule versions: %w", err)
+			}
+		}
+
+		for _, principalID := range req.Principals {
+			pairReq := probe
+			pairReq.PrincipalID = principalID
+			pairs = append(pairs, &batchPair{
+				req:        pairReq,
+				rule:       rule,
+				inner:      inner,
+				edges:      edges,
+				candidates: candidates,
+				bucketSize: coreagg.BucketLabel(rule.Buckets()[0]),
+				rebuilding: !inner.empty() && len(candidates) == 0,
+				tail:       make(map[bucketKey]coreagg.AggregateState),
+			})
+		}
+	}
+
+	resp := &BatchQueryResponse{
+		Start:       req.Start,
+		End:         req.End,
+		Granularity: pairs[0].req.Granularity,
+		Results:     make(map[string]map[string]*AggregateQueryResponse, len(req.Principals)),
+	}
+
+	snapshotReader, batchPreAggs := s.preAggStore.(batchSnapshotReader)
+	eventReader, batchEvents := s.eventStore.(storage.BatchScopedEventReader)
+	if !batchPreAggs || !batchEvents {
+		for _, pair := range pairs {
+			result, err := s.QueryAggregates(ctx, pair.req)
+			if err != nil {
+				return nil, err
+			}
+			resp.add(result)
+		}
+		return resp, nil
+	}
+
+	if err := loadBatchPreAggregates(ctx, snapshotReader, pairs); err != nil {
+		return nil, fmt.Errorf("query pre-aggregates: %w", err)
+	}
+	for _, pair := range pairs {
+		if hasStaleFingerprint(pair.preAggs, pair.rule) {
+			pair.preAggs, pair.checkpoint, pair.rebuilding = nil, 0, true
+		}
+	}
+	if err := s.loadBatchRawEvents(ctx, eventReader, pairs); err != nil {
+		return nil, fmt.Errorf("query raw event tail: %w", err)
+	}
+
+	for _, pair := range pairs {
+		tail := make([]coreagg.AggregateState, 0, len(pair.tail))
+		for _, state := range pair.tail {
+			tail = append(tail, state)
+		}
+		sortByWindowAndDimensions(tail)
+
+		merged := mergeAggregateStates(pair.preAggs, tail, pair.rule.Operator)
+		result, err := s.buildResponse(pair.req, pair.rule, merged, pair.bucketSize, asOfBound{})
+		if err != nil {
+			return nil, err
+		}
+		result.Rebuilding = pair.rebuilding
+		resp.add(result)
+	}
+	return resp, nil
+}
+
+func (r *BatchQueryResponse) add(result *AggregateQueryResponse) {
+	byRule, ok := r.Results[result.PrincipalID]
+	if !ok {
+		byRule = make(map[string]*AggregateQueryResponse)
+		r.Results[result.PrincipalID] = byRule
+	}
+	byRule[result.Rule] = result
+}
+
+// normalizeBatchRequest drops duplicate principals and rules and checks the batch size.
+// Per-pair validation happens as for single queries.
+func normalizeBatchRequest(req BatchQueryRequest) (BatchQueryRequest, error) {
+	var err error
+	if req.Principals, err = uniqueNonEmpty(req.Principals, "principals"); err != nil {
+		return req, err
+	}
+	if req.Rules, err = uniqueNonEmpty(req.Rules, "rules"); err != nil {
+		return req, err
+	}
+	if pairs := len(req.Principals) * len(req.Rules); pairs > maxBatchQueryPairs {
+		return req, invalidQueryf("batch query has %d principal/rule pairs (max %d)", pairs, maxBatchQueryPairs)
+	}
+	return req, nil
+}
+
+func uniqueNonEmpty(values []string, field string) ([]string, error) {
+	if len(values) == 0 {
+		return nil, invalidQueryf("%s is required", field)
+	}
+	seen := make(map[string]bool, len(values))
+	unique := make([]string, 0, len(values))
+	for _, v := range values {
+		if v == "" {
+			return nil, invalidQueryf("%s must not contain empty values", field)
+		}
+		if !seen[v] {
+			seen[v] = true
+			unique = append(unique, v)
+		}
+	}
+	return unique, nil
+}
+
+// loadBatchPreAggregates resolves the bucket size and pre-aggregates of every pair.
+// Like loadPreAggregates, a pair without pre-aggregates at a bucket size falls back to
+// its next finer candidate; each round reads all pairs at one bucket size and inner
+// range together.
+func loadBatchPreAggregates(
+	ctx context.Context,
+	reader batchSnapshotReader,
+	pairs []*batchPair,
+) error {
+	type readKey struct {
+		bucketSize string
+		window     timeRange
+	}
+
+	pending := make([]*batchPair, 0, len(pairs))
+	for _, pair := range pairs {
+		if len(pair.candidates) > 0 {
+			pending = append(pending, pair)
+		}
+	}
+
+	for len(pending) > 0 {
+		byRead := make(map[readKey][]*batchPair)
+		var reads []readKey
+		for _, pair := range pending {
+			key := readKey{bucketSize: pair.candidates[0], window: pair.inner}
+			if _, ok := byRead[key]; !ok {
+				reads = append(reads, key)
+			}
+			byRead[key] = append(byRead[key], pair)
+		}
+
+		pending = pending[:0]
+		for _, key := range reads {
+			group := byRead[key]
+			principals, rules := pairScopes(group)
+			ranges, err := reader.QueryRangesWithCheckpoint(ctx, principals, rules, key.bucketSize, key.window.start, key.window.end)
+			if err != nil {
+				return err
+			}
+
+			for _, pair := range group {
+				scoped := ranges[coreagg.RangeScope{PrincipalID: pair.req.PrincipalID, RuleName: pair.rule.Name}]
+				if len(scoped.States) == 0 && len(pair.candidates) > 1 {
+					pair.candidates = pair.candidates[1:]
+					pending = append(pending, pair)
+					continue
+				}
+				pair.bucketSize = key.bucketSize
+				pair.preAggs = scoped.States
+				pair.checkpoint = scoped.Checkpoint
+			}
+		}
+	}
+	return nil
+}
+
+// batchScan is one raw event scan of a batch query: the events of one type in one range.
+// A scan of an inner range only folds the events past each pair's checkpoint (the raw
+// tail); a scan of an edge folds them all. Rules that bucket late events by ingestion
+// time scan separately, since they also read the events ingested in the range.
+type batchScan struct {
+	eventType       string
+	window          timeRange
+	tail            bool
+	includeIngested bool
+}
+
+// loadBatchRawEvents folds the raw events of every pair into its tail: the unflushed
+// events of its inner range and all events of its edges, with one scan per event type
+// and range over all principals. A tail scan starts at the lowest checkpoint of its pairs,
+// an edge scan where the edge starts (see edgeCursor). Like scanScopedRawEvents, only a
+// tail scan of rebuilding pairs pages through its whole range; other scans give up after
+// maxRawQueryIterations.
+func (s *Service) loadBatchRawEvents(
+	ctx context.Context,
+	reader storage.BatchScopedEventReader,
+	pairs []*batchPair,
+) error {
+	byScan := make(map[batchScan][]*batchPair)
+	var scans []batchScan
+	add := func(scan batchScan, pair *batchPair) {
+		if _, ok := byScan[scan]; !ok {
+			scans = append(scans, scan)
+		}
+		byScan[scan] = append(byScan[scan], pair)
+	}
+	for _, pair := range pairs {
+		if !pair.inner.empty() {
+			add(batchScan{eventType: pair.rule.SourceEvent, window: pair.inner, tail: true, includeIngested: pair.rule.BucketsByIngestion()}, pair)
+		}
+		for _, edge := range pair.edges {
+			add(batchScan{eventType: pair.rule.SourceEvent, window: edge, includeIngested: pair.rule.BucketsByIngestion()}, pair)
+		}
+	}
+
+	cursorReader, hasCursorReader := s.eventStore.(storage.TypeIngestCursorReader)
+
+	for _, scan := range scans {
+		group := byScan[scan]
+
+		byPrincipal := make(map[string][]*batchPair)
+		var cursor int64
+		if scan.tail {
+			cursor = group[0].checkpoint
+		} else {
+			var firstAfter func(time.Time) (int64, error)
+			if hasCursorReader {
+				firstAfter = func(t time.Time) (int64, error) {
+					return cursorReader.FirstTypeIngestSeqAfter(ctx, scan.eventType, t)
+				}
+			}
+			edgeStart, found, err := edgeCursor(scan.window, firstAfter)
+			if err != nil {
+				return fmt.Errorf("find range edge cursor: %w", err)
+			}
+			if !found {
+				continue
+			}
+			cursor = edgeStart
+		}
+		replay := false
+		for _, pair := range group {
+			byPrincipal[pair.req.PrincipalID] = append(byPrincipal[pair.req.PrincipalID], pair)
+			if scan.tail && pair.checkpoint < cursor {
+				cursor = pair.checkpoint
+			}
+			if scan.tail && pair.rebuilding {
+				replay = true
+			}
+		}
+		principals, _ := pairScopes(group)
+
+		iterations := 0
+		totalEvents := 0
+		for {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			// Safety limit: prevent unbounded scanning if checkpoints are far behind
+			if !replay && iterations >= maxRawQueryIterations {
+				slog.Warn("Batch raw event tail scan reached maximum iteration limit",
+					"event_type", scan.eventType,
+					"principals", len(principals),
+					"iterations", iterations,
+					"events_scanned", totalEvents,
+					"max_iterations", maxRawQueryIterations,
+				)
+				return fmt.Errorf("raw event scan exceeded maximum iterations (%d batches, %d events total) - aggregation may be too far behind",
+					maxRawQueryIterations, totalEvents)
+			}
+
+			events, err := reader.RetrieveBatchScopedEventsAfterCursor(ctx, cursor, principals, scan.eventType, scan.window.start, scan.window.end, scan.includeIngested, rawQueryBatchSize)
+			if err != nil {
+				return err
+			}
+			if len(events) == 0 {
+				break
+			}
+
+			if err := s.foldBatchEvents(events, byPrincipal, scan); err != nil {
+				return err
+			}
+			totalEvents += len(events)
+			iterations++
+
+			cursor = events[len(events)-1].IngestSeq
+			if len(events) < rawQueryBatchSize {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Service) foldBatchEvents(
+	events []*v1.Event,
+	byPrincipal map[string][]*batchPair,
+	scan batchScan,
+) error {
+	eventsByPrincipal := make(map[string][]*v1.Event)
+	for _, evt := range events {
+		eventsByPrincipal[evt.PrincipalID] = append(eventsByPrincipal[evt.PrincipalID], evt)
+	}
+
+	for principalID, principalEvents := range eventsByPrincipal {
+		for _, pair := range byPrincipal[principalID] {
+			reducer, ok := coreagg.Operators[pair.rule.Operator]
+			if !ok {
+				return fmt.Errorf("unknown rule operator: %s", pair.rule.Operator)
+			}
+			bucketDuration, err := parseBucketSize(pair.bucketSize)
+			if err != nil {
+				return err
+			}
+
+			folded := principalEvents
+			if scan.tail {
+				folded = make([]*v1.Event, 0, len(principalEvents))
+				for _, evt := range principalEvents {
+					if evt.IngestSeq > pair.checkpoint {
+						folded = append(folded, evt)
+					}
+				}
+			}
+			s.foldRawEventsIntoBuckets(folded, pair.tail, pair.rule, reducer, bucketDuration, scan.window.start, scan.window.end)
+		}
+	}
+	return nil
+}
+
+// pairScopes lists the distinct principals and rules of pairs in first-seen order.
+func pairScopes(pairs []*batchPair) (principals []string, rules []string) {
+	seenPrincipals := make(map[string]bool)
+	seenRules := make(map[string]bool)
+	for _, pair := range pairs {
+		if !seenPrincipals[pair.req.PrincipalID] {
+			seenPrincipals[pair.req.PrincipalID] = true
+			principals = append(principals, pair.req.PrincipalID)
+		}
+		if !seenRules[pair.rule.Name] {
+			seenRules[pair.rule.Name] = true
+			rules = append(rules, pair.rule.Name)
+		}
+	}
+	return principals, rules
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
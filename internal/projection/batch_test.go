@@ -0,0 +1,283 @@
+package projection
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	v1 "github.com/aevon-lab/project-aevon/internal/api/v1"
+	coreagg "github.com/aevon-lab/project-aevon/internal/core/aggregation"
+	aggregationmocks "github.com/aevon-lab/project-aevon/internal/mocks/aggregation"
+	storagemocks "github.com/aevon-lab/project-aevon/internal/mocks/storage"
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// batchPreAggStore adds the optional batch snapshot reader to the generated mock.
+type batchPreAggStore struct {
+	*aggregationmocks.PreAggregateStore
+	states      map[string]map[coreagg.RangeScope][]coreagg.AggregateState // by bucket size
+	checkpoints map[string]int64                                           // by principal
+	calls       []string                                                   // bucket sizes read
+}
+
+func (s *batchPreAggStore) QueryRangesWithCheckpoint(
+	_ context.Context,
+	principalIDs []string,
+	ruleNames []string,
+	bucketSize string,
+	_ time.Time,
+	_ time.Time,
+) (map[coreagg.RangeScope]coreagg.ScopedRange, error) {
+	s.calls = append(s.calls, bucketSize)
+	results := make(map[coreagg.RangeScope]coreagg.ScopedRange)
+	for _, principalID := range principalIDs {
+		for _, ruleName := range ruleNames {
+			scope := coreagg.RangeScope{PrincipalID: principalID, RuleName: ruleName}
+			results[scope] = coreagg.ScopedRange{States: s.states[bucketSize][scope], Checkpoint: s.checkpoints[principalID]}
+		}
+	}
+	return results, nil
+}
+
+// batchEventStore adds the optional batch scoped event reader to the generated mock.
+type batchEventStore struct {
+	*storagemocks.EventStore
+	events []*v1.Event
+	scans  []string // event type and cursor of every scan
+}
+
+func (s *batchEventStore) RetrieveBatchScopedEventsAfterCursor(
+	_ context.Context,
+	cursor int64,
+	principalIDs []string,
+	eventType string,
+	_ time.Time,
+	_ time.Time,
+	_ bool,
+	limit int,
+) ([]*v1.Event, error) {
+	s.scans = append(s.scans, fmt.Sprintf("%s>%d", eventType, cursor))
+	var events []*v1.Event
+	for _, evt := range s.events {
+		if evt.IngestSeq > cursor && evt.Type == eventType && containsString(principalIDs, evt.PrincipalID) && len(events) < limit {
+			events = append(events, evt)
+		}
+	}
+	return events, nil
+}
+
+func TestService_QueryAggregatesBatch_BatchedReads(t *testing.T) {
+	start := time.Date(2026, 2, 7, 10, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+
+	rules := []coreagg.AggregationRule{
+		{
+			Name:        "count_requests",
+			SourceEvent: "api.request",
+			Operator:    coreagg.OpCount,
+			BucketSizes: []time.Duration{time.Minute, time.Hour},
+		},
+		{
+			Name:        "count_logins",
+			SourceEvent: "auth.login",
+			Operator:    coreagg.OpCount,
+			WindowSize:  time.Minute,
+		},
+	}
+	durable := func(value int64) []coreagg.AggregateState {
+		return []coreagg.AggregateState{{
+			Operator:    coreagg.OpCount,
+			Value:       decimal.NewFromInt(value),
+			EventCount:  value,
+			WindowStart: start,
+		}}
+	}
+
+	// user-2 has no hourly pre-aggregates yet and falls back to 1m buckets.
+	preAggStore := &batchPreAggStore{
+		PreAggregateStore: aggregationmocks.NewPreAggregateStore(t),
+		states: map[string]map[coreagg.RangeScope][]coreagg.AggregateState{
+			"1h": {
+				{PrincipalID: "user-1", RuleName: "count_requests"}: durable(10),
+			},
+			"1m": {
+				{PrincipalID: "user-2", RuleName: "count_requests"}: durable(4),
+				{PrincipalID: "user-1", RuleName: "count_logins"}:   durable(2),
+			},
+		},
+		checkpoints: map[string]int64{"user-1": 100, "user-2": 90},
+	}
+	eventStore := &batchEventStore{
+		EventStore: storagemocks.NewEventStore(t),
+		events: []*v1.Event{
+			{ID: "evt-1", PrincipalID: "user-2", Type: "api.request", OccurredAt: start, IngestSeq: 91, Data: map[string]interface{}{}},
+			{ID: "evt-2", PrincipalID: "user-2", Type: "auth.login", OccurredAt: start, IngestSeq: 93, Data: map[string]interface{}{}},
+			{ID: "evt-3", PrincipalID: "user-1", Type: "api.request", OccurredAt: start, IngestSeq: 95, Data: map[string]interface{}{}}, // flushed for user-1
+			{ID: "evt-4", PrincipalID: "user-1", Type: "api.request", OccurredAt: start, IngestSeq: 101, Data: map[string]interface{}{}},
+			{ID: "evt-5", PrincipalID: "user-3", Type: "api.request", OccurredAt: start, IngestSeq: 102, Data: map[string]interface{}{}},
+		},
+	}
+
+	svc := NewService(preAggStore, eventStore, rules)
+	svc.nowFn = func() time.Time { return end.Add(time.Hour) }
+
+	resp, err := svc.QueryAggregatesBatch(context.Background(), BatchQueryRequest{
+		Principals: []string{"user-1", "user-2", "user-1"},
+		Rules:      []string{"count_requests", "count_logins"},
+		Start:      start,
+		End:        end,
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, []string{"1h", "1m", "1m"}, preAggStore.calls)
+	require.Equal(t, []string{"api.request>90", "auth.login>90"}, eventStore.scans)
+
+	require.Equal(t, "total", resp.Granularity)
+	require.Len(t, resp.Results, 2)
+	want := map[string]map[string]string{
+		"user-1": {"count_requests": "11", "count_logins": "2"},
+		"user-2": {"count_requests": "5", "count_logins": "1"},
+	}
+	for principalID, byRule := range want {
+		for ruleName, value := range byRule {
+			result := resp.Results[principalID][ruleName]
+			require.NotNil(t, result, "%s/%s", principalID, ruleName)
+			require.Equal(t, principalID, result.PrincipalID)
+			require.Equal(t, ruleName, result.Rule)
+			require.Len(t, result.Values, 1)
+			require.Equal(t, value, result.Values[0].Value.String(), "%s/%s", principalID, ruleName)
+		}
+	}
+}
+
+func TestService_QueryAggregatesBatch_FallsBackToQueryPerPair(t *testing.T) {
+	start := time.Date(2026, 2, 7, 10, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+
+	preAggStore := aggregationmocks.NewPreAggregateStore(t)
+	eventStore := storagemocks.NewEventStore(t)
+	for i, principalID := range []string{"user-1", "user-2"} {
+		preAggStore.EXPECT().
+			QueryRange(mock.Anything, principalID, "count_requests", "1m", start, end).
+			Return([]coreagg.AggregateState{{
+				Operator:    coreagg.OpCount,
+				Value:       decimal.NewFromInt(int64(i + 1)),
+				EventCount:  int64(i + 1),
+				WindowStart: start,
+			}}, nil).
+			Once()
+		eventStore.EXPECT().
+			RetrieveScopedEventsAfterCursor(mock.Anything, int64(0), principalID, "api.request", start, end, false, rawQueryBatchSize).
+			Return([]*v1.Event{}, nil).
+			Once()
+	}
+	preAggStore.EXPECT().ReadCheckpoint(mock.Anything, "1m").Return(int64(0), nil).Times(2)
+
+	svc := NewService(preAggStore, eventStore, []coreagg.AggregationRule{{
+		Name:        "count_requests",
+		SourceEvent: "api.request",
+		Operator:    coreagg.OpCount,
+		WindowSize:  time.Minute,
+	}})
+
+	resp, err := svc.QueryAggregatesBatch(context.Background(), BatchQueryRequest{
+		Principals: []string{"user-1", "user-2"},
+		Rules:      []string{"count_requests"},
+		Start:      start,
+		End:        end,
+	})
+	require.NoError(t, err)
+	require.Equal(t, "1", resp.Results["user-1"]["count_requests"].Values[0].Value.String())
+	require.Equal(t, "2", resp.Results["user-2"]["count_requests"].Values[0].Value.String())
+}
+
+func TestService_QueryAggregatesBatch_Validation(t *testing.T) {
+	start := time.Date(2026, 2, 7, 10, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+
+	svc := NewService(aggregationmocks.NewPreAggregateStore(t), storagemocks.NewEventStore(t), []coreagg.AggregationRule{{
+		Name:        "count_requests",
+		SourceEvent: "api.request",
+		Operator:    coreagg.OpCount,
+		WindowSize:  time.Minute,
+	}})
+
+	tooMany := make([]string, maxBatchQueryPairs+1)
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprintf("user-%d", i)
+	}
+
+	tests := []struct {
+		name string
+		req  BatchQueryRequest
+	}{
+		{name: "no principals", req: BatchQueryRequest{Rules: []string{"count_requests"}, Start: start, End: end}},
+		{name: "no rules", req: BatchQueryRequest{Principals: []string{"user-1"}, Start: start, End: end}},
+		{name: "empty principal", req: BatchQueryRequest{Principals: []string{"user-1", ""}, Rules: []string{"count_requests"}, Start: start, End: end}},
+		{name: "too many pairs", req: BatchQueryRequest{Principals: tooMany, Rules: []string{"count_requests"}, Start: start, End: end}},
+		{name: "unknown rule", req: BatchQueryRequest{Principals: []string{"user-1"}, Rules: []string{"missing_rule"}, Start: start, End: end}},
+		{name: "end before start", req: BatchQueryRequest{Principals: []string{"user-1"}, Rules: []string{"count_requests"}, Start: end, End: start}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := svc.QueryAggregatesBatch(context.Background(), tc.req)
+			require.ErrorIs(t, err, ErrInvalidQuery)
+		})
+	}
+}
+
+func TestService_HandleBatchQuery(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	start := time.Date(2026, 2, 7, 10, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+
+	preAggStore := aggregationmocks.NewPreAggregateStore(t)
+	preAggStore.EXPECT().
+		QueryRange(mock.Anything, "user-1", "count_requests", "1m", start, end).
+		Return([]coreagg.AggregateState(nil), nil).
+		Once()
+	preAggStore.EXPECT().ReadCheckpoint(mock.Anything, "1m").Return(int64(0), nil).Once()
+	eventStore := storagemocks.NewEventStore(t)
+	eventStore.EXPECT().
+		RetrieveScopedEventsAfterCursor(mock.Anything, int64(0), "user-1", "api.request", start, end, false, rawQueryBatchSize).
+		Return([]*v1.Event{}, nil).
+		Once()
+
+	svc := NewService(preAggStore, eventStore, []coreagg.AggregationRule{{
+		Name:        "count_requests",
+		SourceEvent: "api.request",
+		Operator:    coreagg.OpCount,
+		WindowSize:  time.Minute,
+	}})
+	r := gin.New()
+	svc.RegisterRoutes(r)
+
+	body := fmt.Sprintf(`{"principals":["user-1"],"rules":["count_requests"],"start":%q,"end":%q}`,
+		start.Format(time.RFC3339), end.Format(time.RFC3339))
+
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/v1/state:query", bytes.NewBufferString(body)))
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	var decoded BatchQueryResponse
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &decoded))
+	require.Equal(t, "count_requests", decoded.Results["user-1"]["count_requests"].Rule)
+
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/v1/state:other", bytes.NewBufferString(body)))
+	require.Equal(t, http.StatusNotFound, resp.Code)
+
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/v1/state:query", bytes.NewBufferString(`{"principals":[],"rules":["count_requests"],"start":"2026-02-07T10:00:00Z","end":"2026-02-07T11:00:00Z"}`)))
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+}
@@ -0,0 +1,227 @@
+package projection
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	coreagg "github.com/aevon-lab/project-aevon/internal/core/aggregation"
+	httperr "github.com/aevon-lab/project-aevon/internal/core/errors"
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+)
+
+// Calendar periods of a quota check. A rolling period is written "last_<duration>",
+// e.g. last_24h or last_30d.
+const (
+	PeriodCurrentHour  = "current_hour"
+	PeriodCurrentDay   = "current_day"
+	PeriodCurrentWeek  = "current_week" // ISO week, starting Monday
+	PeriodCurrentMonth = "current_month"
+
+	rollingPeriodPrefix = "last_"
+)
+
+var hundred = decimal.NewFromInt(100)
+
+// additiveOperators are the operators whose value only grows with usage, so used +
+// increment against a limit is meaningful.
+var additiveOperators = map[string]bool{
+	coreagg.OpCount:         true,
+	coreagg.OpSum:           true,
+	coreagg.OpCountDistinct: true,
+}
+
+// AdditiveOperator reports whether a rule's operator measures usage that only grows, so
+// limits such as quotas and thresholds are meaningful for it.
+func AdditiveOperator(operator string) bool {
+	return additiveOperators[operator]
+}
+
+// QuotaCheckRequest is the request body of POST /v1/state/{principal_id}/check.
+type QuotaCheckRequest struct {
+	Rule   string `json:"rule"`
+	Period string `json:"period"`
+	// Timezone is the IANA zone calendar periods are evaluated in; default UTC.
+	Timezone string          `json:"timezone,omitempty"`
+	Limit    decimal.Decimal `json:"limit"`
+	// Increment is the usage the caller is about to add, e.g. the tokens of a request.
+	Increment decimal.Decimal `json:"increment"`
+}
+
+// QuotaCheckResponse reports the usage of a rule against a limit over a period.
+type QuotaCheckResponse struct {
+	PrincipalID string          `json:"principal_id"`
+	Rule        string          `json:"rule"`
+	Period      string          `json:"period"`
+	Start       time.Time       `json:"start"`
+	End         time.Time       `json:"end"`
+	Limit       decimal.Decimal `json:"limit"`
+	Used        decimal.Decimal `json:"used"`
+	Increment   decimal.Decimal `json:"increment"`
+	// Remaining is the headroom left after the increment, never below zero.
+	Remaining   decimal.Decimal `json:"remaining"`
+	PercentUsed decimal.Decimal `json:"percent_used"` // of the limit, before the increment
+	// Exceeded reports whether used + increment is over the limit.
+	Exceeded bool `json:"exceeded"`
+	// DataThrough and StalenessSeconds bound the freshness of Used, exactly as for
+	// GET /v1/state/{principal_id}.
+	DataThrough      time.Time `json:"data_through"`
+	StalenessSeconds int       `json:"staleness_seconds"`
+}
+
+// HandleCheckQuota handles POST /v1/state/:principal_id/check
+func (s *Service) HandleCheckQuota(c *gin.Context) {
+	var req QuotaCheckRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, httperr.ErrorResponse{
+			ErrorType: httperr.HttpInvalidJsonError,
+			Message:   "Invalid request body",
+			Details:   err.Error(),
+		})
+		return
+	}
+
+	queryCtx, cancel := context.WithTimeout(c.Request.Context(), s.queryTimeout)
+	defer cancel()
+	resp, err := s.CheckQuota(queryCtx, c.Param("principal_id"), req)
+	if err != nil {
+		switch {
+		case errors.Is(err, context.DeadlineExceeded):
+			c.JSON(http.StatusGatewayTimeout, httperr.ErrorResponse{
+				ErrorType: httperr.HttpInternalError,
+				Message:   fmt.Sprintf("Projection query timed out after %s", s.queryTimeout),
+				Details:   map[string]interface{}{"timeout": s.queryTimeout.String()},
+			})
+		case errors.Is(err, ErrInvalidQuery):
+			c.JSON(http.StatusBadRequest, httperr.ErrorResponse{
+				ErrorType: httperr.HttpInvalidJsonError,
+				Message:   "Invalid quota check",
+				Details:   err.Error(),
+			})
+		default:
+			c.JSON(http.StatusInternalServerError, httperr.ErrorResponse{
+				ErrorType: httperr.HttpInternalError,
+				Message:   "Failed to check quota",
+				Details:   err.Error(),
+			})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// CheckQuota evaluates the principal's usage of a rule over the current period against
+// a limit. Usage comes from QueryAggregates, so freshness is the same as for state queries.
+func (s *Service) CheckQuota(ctx context.Context, principalID string, req QuotaCheckRequest) (*QuotaCheckResponse, error) {
+	if !req.Limit.IsPositive() {
+		return nil, invalidQueryf("limit must be greater than 0")
+	}
+	if req.Increment.IsNegative() {
+		return nil, invalidQueryf("increment must not be negative")
+	}
+
+	rule, ok := s.rule(req.Rule)
+	if !ok {
+		return nil, invalidQueryf("unknown rule: %s", req.Rule)
+	}
+	if !AdditiveOperator(rule.Operator) {
+		return nil, invalidQueryf("rule %s uses %s; quota checks need a count, sum or count_distinct rule", req.Rule, rule.Operator)
+	}
+
+	start, end, err := ResolvePeriod(req.Period, req.Timezone, rule.Buckets()[0], s.nowFn())
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := s.QueryAggregates(ctx, AggregateQueryRequest{
+		PrincipalID: principalID,
+		Rule:        req.Rule,
+		Start:       start,
+		End:         end,
+		Granularity: "total",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	used := decimal.Zero
+	if len(state.Values) > 0 {
+		used = state.Values[0].Value
+	}
+	projected := used.Add(req.Increment)
+	remaining := req.Limit.Sub(projected)
+	if remaining.IsNegative() {
+		remaining = decimal.Zero
+	}
+
+	return &QuotaCheckResponse{
+		PrincipalID:      principalID,
+		Rule:             req.Rule,
+		Period:           req.Period,
+		Start:            start,
+		End:              end,
+		Limit:            req.Limit,
+		Used:             used,
+		Increment:        req.Increment,
+		Remaining:        remaining,
+		PercentUsed:      used.Mul(hundred).Div(req.Limit).Round(2),
+		Exceeded:         projected.GreaterThan(req.Limit),
+		DataThrough:      state.DataThrough,
+		StalenessSeconds: state.StalenessSeconds,
+	}, nil
+}
+
+// ResolvePeriod returns the [start, end) range of a period spec at time now for a rule
+// whose finest bucket size is bucket. Calendar periods are evaluated in timezone; rolling
+// periods end at the end of the current bucket. A range that does not start or end on a
+// bucket boundary, e.g. a day in a +05:30 zone for a 1h rule, has its partial edge
+// buckets folded from raw events by QueryAggregates.
+func ResolvePeriod(period, timezone string, bucket time.Duration, now time.Time) (time.Time, time.Time, error) {
+	loc := time.UTC
+	if timezone != "" {
+		var err error
+		loc, err = time.LoadLocation(timezone)
+		if err != nil {
+			return time.Time{}, time.Time{}, invalidQueryf("invalid timezone: %q", timezone)
+		}
+	}
+
+	local := now.In(loc)
+	year, month, day := local.Date()
+	var start, end time.Time
+	switch period {
+	case PeriodCurrentHour:
+		start = time.Date(year, month, day, local.Hour(), 0, 0, 0, loc)
+		end = start.Add(time.Hour)
+	case PeriodCurrentDay:
+		start = time.Date(year, month, day, 0, 0, 0, 0, loc)
+		end = start.AddDate(0, 0, 1)
+	case PeriodCurrentWeek:
+		daysSinceMonday := (int(local.Weekday()) + 6) % 7
+		start = time.Date(year, month, day-daysSinceMonday, 0, 0, 0, 0, loc)
+		end = start.AddDate(0, 0, 7)
+	case PeriodCurrentMonth:
+		start = time.Date(year, month, 1, 0, 0, 0, 0, loc)
+		end = start.AddDate(0, 1, 0)
+	case "":
+		return time.Time{}, time.Time{}, invalidQueryf("period is required")
+	default:
+		raw, ok := strings.CutPrefix(period, rollingPeriodPrefix)
+		if !ok {
+			return time.Time{}, time.Time{}, invalidQueryf(
+				"invalid period: %s (must be current_hour, current_day, current_week, current_month or last_<duration>)", period)
+		}
+		spec, err := coreagg.ParseWindowSize(raw)
+		if err != nil {
+			return time.Time{}, time.Time{}, invalidQueryf("invalid rolling period %q (e.g. last_24h, last_30d)", period)
+		}
+		end = coreagg.BucketFor(now, bucket).Add(bucket)
+		start = end.Add(-spec.Size)
+	}
+	return start.UTC(), end.UTC(), nil
+}
@@ -0,0 +1,255 @@
+package projection
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	v1 "github.com/aevon-lab/project-aevon/internal/api/v1"
+	coreagg "github.com/aevon-lab/project-aevon/internal/core/aggregation"
+	aggregationmocks "github.com/aevon-lab/project-aevon/internal/mocks/aggregation"
+	storagemocks "github.com/aevon-lab/project-aevon/internal/mocks/storage"
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolvePeriod(t *testing.T) {
+	now := time.Date(2026, 2, 7, 11, 20, 30, 0, time.UTC) // a Saturday
+
+	tests := []struct {
+		period    string
+		timezone  string
+		wantStart time.Time
+		wantEnd   time.Time
+	}{
+		{period: PeriodCurrentHour, wantStart: time.Date(2026, 2, 7, 11, 0, 0, 0, time.UTC), wantEnd: time.Date(2026, 2, 7, 12, 0, 0, 0, time.UTC)},
+		{period: PeriodCurrentDay, wantStart: time.Date(2026, 2, 7, 0, 0, 0, 0, time.UTC), wantEnd: time.Date(2026, 2, 8, 0, 0, 0, 0, time.UTC)},
+		{period: PeriodCurrentWeek, wantStart: time.Date(2026, 2, 2, 0, 0, 0, 0, time.UTC), wantEnd: time.Date(2026, 2, 9, 0, 0, 0, 0, time.UTC)},
+		{period: PeriodCurrentMonth, wantStart: time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC), wantEnd: time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{period: PeriodCurrentDay, timezone: "America/New_York", wantStart: time.Date(2026, 2, 7, 5, 0, 0, 0, time.UTC), wantEnd: time.Date(2026, 2, 8, 5, 0, 0, 0, time.UTC)},
+		{period: "last_24h", wantStart: time.Date(2026, 2, 6, 11, 21, 0, 0, time.UTC), wantEnd: time.Date(2026, 2, 7, 11, 21, 0, 0, time.UTC)},
+	}
+	for _, tc := range tests {
+		t.Run(tc.period+tc.timezone, func(t *testing.T) {
+			start, end, err := ResolvePeriod(tc.period, tc.timezone, time.Minute, now)
+			require.NoError(t, err)
+			require.Equal(t, tc.wantStart, start)
+			require.Equal(t, tc.wantEnd, end)
+		})
+	}
+
+	for _, period := range []string{"", "this_month", "last_soon"} {
+		_, _, err := ResolvePeriod(period, "", time.Minute, now)
+		require.ErrorIs(t, err, ErrInvalidQuery, period)
+	}
+	_, _, err := ResolvePeriod(PeriodCurrentDay, "Mars/Olympus", time.Minute, now)
+	require.ErrorIs(t, err, ErrInvalidQuery)
+}
+
+func TestResolvePeriod_UnalignedToRuleBucket(t *testing.T) {
+	now := time.Date(2026, 2, 7, 11, 20, 30, 0, time.UTC)
+
+	start, end, err := ResolvePeriod("last_24h", "", time.Hour, now)
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2026, 2, 6, 12, 0, 0, 0, time.UTC), start)
+	require.Equal(t, time.Date(2026, 2, 7, 12, 0, 0, 0, time.UTC), end)
+
+	// A day in India starts at 18:30 UTC, inside an hourly bucket.
+	start, end, err = ResolvePeriod(PeriodCurrentDay, "Asia/Kolkata", time.Hour, now)
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2026, 2, 6, 18, 30, 0, 0, time.UTC), start)
+	require.Equal(t, time.Date(2026, 2, 7, 18, 30, 0, 0, time.UTC), end)
+
+	start, end, err = ResolvePeriod("last_90m", "", time.Hour, now)
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2026, 2, 7, 10, 30, 0, 0, time.UTC), start)
+	require.Equal(t, time.Date(2026, 2, 7, 12, 0, 0, 0, time.UTC), end)
+}
+
+func newCheckTestService(t *testing.T, now time.Time, used int64) *Service {
+	start := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	preAggStore := aggregationmocks.NewPreAggregateStore(t)
+	preAggStore.EXPECT().
+		QueryRange(mock.Anything, "user-1", "sum_tokens", "1m", start, end).
+		Return([]coreagg.AggregateState{{
+			Operator:    coreagg.OpSum,
+			Value:       decimal.NewFromInt(used),
+			EventCount:  3,
+			WindowStart: now.Truncate(time.Minute).Add(-time.Minute),
+		}}, nil).
+		Once()
+	preAggStore.EXPECT().ReadCheckpoint(mock.Anything, "1m").Return(int64(10), nil).Once()
+
+	eventStore := storagemocks.NewEventStore(t)
+	eventStore.EXPECT().
+		RetrieveScopedEventsAfterCursor(mock.Anything, int64(10), "user-1", "llm.call", start, end, false, rawQueryBatchSize).
+		Return([]*v1.Event{}, nil).
+		Once()
+
+	svc := NewService(preAggStore, eventStore, []coreagg.AggregationRule{{
+		Name:        "sum_tokens",
+		SourceEvent: "llm.call",
+		Operator:    coreagg.OpSum,
+		Field:       "tokens",
+		WindowSize:  time.Minute,
+	}})
+	svc.nowFn = func() time.Time { return now }
+	return svc
+}
+
+func TestService_CheckQuota(t *testing.T) {
+	now := time.Date(2026, 2, 7, 11, 0, 30, 0, time.UTC)
+
+	t.Run("increment within limit", func(t *testing.T) {
+		svc := newCheckTestService(t, now, 800)
+		resp, err := svc.CheckQuota(context.Background(), "user-1", QuotaCheckRequest{
+			Rule:      "sum_tokens",
+			Period:    PeriodCurrentMonth,
+			Limit:     decimal.NewFromInt(1000),
+			Increment: decimal.NewFromInt(150),
+		})
+		require.NoError(t, err)
+		require.Equal(t, "800", resp.Used.String())
+		require.Equal(t, "50", resp.Remaining.String())
+		require.Equal(t, "80", resp.PercentUsed.String())
+		require.False(t, resp.Exceeded)
+		require.Equal(t, now.Truncate(time.Minute), resp.DataThrough)
+		require.Equal(t, 30, resp.StalenessSeconds)
+	})
+
+	t.Run("increment over limit", func(t *testing.T) {
+		svc := newCheckTestService(t, now, 800)
+		resp, err := svc.CheckQuota(context.Background(), "user-1", QuotaCheckRequest{
+			Rule:      "sum_tokens",
+			Period:    PeriodCurrentMonth,
+			Limit:     decimal.NewFromInt(1000),
+			Increment: decimal.NewFromInt(250),
+		})
+		require.NoError(t, err)
+		require.True(t, resp.Exceeded)
+		require.True(t, resp.Remaining.IsZero())
+	})
+}
+
+func TestService_CheckQuota_HalfHourOffsetTimezone(t *testing.T) {
+	now := time.Date(2026, 2, 7, 11, 20, 30, 0, time.UTC)
+	// The current day in Asia/Kolkata (+05:30), split at the hourly buckets.
+	start := time.Date(2026, 2, 6, 18, 30, 0, 0, time.UTC)
+	end := time.Date(2026, 2, 7, 18, 30, 0, 0, time.UTC)
+	innerStart, innerEnd := start.Add(30*time.Minute), end.Add(-30*time.Minute)
+
+	preAggStore := aggregationmocks.NewPreAggregateStore(t)
+	preAggStore.EXPECT().
+		QueryRange(mock.Anything, "user-1", "hourly_tokens", "1h", innerStart, innerEnd).
+		Return([]coreagg.AggregateState{{
+			Operator:    coreagg.OpSum,
+			Value:       decimal.NewFromInt(600),
+			EventCount:  2,
+			WindowStart: time.Date(2026, 2, 7, 10, 0, 0, 0, time.UTC),
+		}}, nil).
+		Once()
+	preAggStore.EXPECT().ReadCheckpoint(mock.Anything, "1h").Return(int64(10), nil).Once()
+
+	eventStore := storagemocks.NewEventStore(t)
+	eventStore.EXPECT().
+		RetrieveScopedEventsAfterCursor(mock.Anything, int64(10), "user-1", "llm.call", innerStart, innerEnd, false, rawQueryBatchSize).
+		Return([]*v1.Event{}, nil).
+		Once()
+	// Events of the previous UTC hour before the day started are not counted.
+	eventStore.EXPECT().
+		RetrieveScopedEventsAfterCursor(mock.Anything, int64(0), "user-1", "llm.call", start, innerStart, false, rawQueryBatchSize).
+		Return([]*v1.Event{
+			{ID: "evt-1", PrincipalID: "user-1", Type: "llm.call", OccurredAt: start.Add(-time.Minute), IngestSeq: 3, Data: map[string]interface{}{"tokens": 500}},
+			{ID: "evt-2", PrincipalID: "user-1", Type: "llm.call", OccurredAt: start.Add(time.Minute), IngestSeq: 4, Data: map[string]interface{}{"tokens": 100}},
+		}, nil).
+		Once()
+	eventStore.EXPECT().
+		RetrieveScopedEventsAfterCursor(mock.Anything, int64(0), "user-1", "llm.call", innerEnd, end, false, rawQueryBatchSize).
+		Return([]*v1.Event{}, nil).
+		Once()
+
+	svc := NewService(preAggStore, eventStore, []coreagg.AggregationRule{{
+		Name:        "hourly_tokens",
+		SourceEvent: "llm.call",
+		Operator:    coreagg.OpSum,
+		Field:       "tokens",
+		WindowSize:  time.Hour,
+		BucketSizes: []time.Duration{time.Hour},
+	}})
+	svc.nowFn = func() time.Time { return now }
+
+	resp, err := svc.CheckQuota(context.Background(), "user-1", QuotaCheckRequest{
+		Rule:     "hourly_tokens",
+		Period:   PeriodCurrentDay,
+		Timezone: "Asia/Kolkata",
+		Limit:    decimal.NewFromInt(1000),
+	})
+	require.NoError(t, err)
+	require.Equal(t, start, resp.Start)
+	require.Equal(t, end, resp.End)
+	require.Equal(t, "700", resp.Used.String())
+	require.False(t, resp.Exceeded)
+}
+
+func TestService_CheckQuota_Validation(t *testing.T) {
+	svc := NewService(aggregationmocks.NewPreAggregateStore(t), storagemocks.NewEventStore(t), []coreagg.AggregationRule{{
+		Name:        "sum_tokens",
+		SourceEvent: "llm.call",
+		Operator:    coreagg.OpSum,
+		Field:       "tokens",
+	}, {
+		Name:        "max_latency",
+		SourceEvent: "llm.call",
+		Operator:    coreagg.OpMax,
+		Field:       "latency_ms",
+	}})
+
+	tests := []struct {
+		name string
+		req  QuotaCheckRequest
+	}{
+		{name: "zero limit", req: QuotaCheckRequest{Rule: "sum_tokens", Period: PeriodCurrentDay}},
+		{name: "negative increment", req: QuotaCheckRequest{Rule: "sum_tokens", Period: PeriodCurrentDay, Limit: decimal.NewFromInt(1), Increment: decimal.NewFromInt(-1)}},
+		{name: "unknown period", req: QuotaCheckRequest{Rule: "sum_tokens", Period: "fortnight", Limit: decimal.NewFromInt(1)}},
+		{name: "unknown rule", req: QuotaCheckRequest{Rule: "missing_rule", Period: PeriodCurrentDay, Limit: decimal.NewFromInt(1)}},
+		{name: "non-additive operator", req: QuotaCheckRequest{Rule: "max_latency", Period: PeriodCurrentDay, Limit: decimal.NewFromInt(1)}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := svc.CheckQuota(context.Background(), "user-1", tc.req)
+			require.ErrorIs(t, err, ErrInvalidQuery)
+		})
+	}
+}
+
+func TestService_HandleCheckQuota(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	now := time.Date(2026, 2, 7, 11, 0, 30, 0, time.UTC)
+	svc := newCheckTestService(t, now, 800)
+	r := gin.New()
+	svc.RegisterRoutes(r)
+
+	body := `{"rule":"sum_tokens","period":"current_month","limit":"1000","increment":50}`
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/v1/state/user-1/check", bytes.NewBufferString(body)))
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	var decoded map[string]interface{}
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &decoded))
+	require.Equal(t, "user-1", decoded["principal_id"])
+	require.Equal(t, "150", decoded["remaining"])
+	require.Equal(t, false, decoded["exceeded"])
+
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/v1/state/user-1/check", bytes.NewBufferString(`{"rule":"sum_tokens","period":"current_month"}`)))
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+}
@@ -15,10 +15,14 @@ import (
 func (s *Service) RegisterRoutes(r gin.IRouter) {
 	// Canonical state query endpoint.
 	r.GET("/v1/state/:principal_id", s.HandleQueryAggregates)
+	r.POST("/v1/state"+queryRouteSuffix, s.HandleBatchQuery)
+	r.POST("/v1/state/:principal_id/check", s.HandleCheckQuota)
+	r.GET("/v1/state/:principal_id/stream", s.HandleStateStream)
+	r.GET("/v1/rules/:rule/top", s.HandleTopPrincipals)
 }
 
 // HandleQueryAggregates handles GET /v1/state/:principal_id
-// Query parameters: rule, start, end, granularity
+// Query parameters: rule, start, end, granularity, quantiles, breakdown, dim.<path>, as_of, as_of_cursor
 func (s *Service) HandleQueryAggregates(c *gin.Context) {
 	var uri struct {
 		PrincipalID string `uri:"principal_id" binding:"required"`
@@ -28,6 +32,10 @@ func (s *Service) HandleQueryAggregates(c *gin.Context) {
 		Start       time.Time `form:"start" binding:"required" time_format:"2006-01-02T15:04:05Z07:00"`
 		End         time.Time `form:"end" binding:"required" time_format:"2006-01-02T15:04:05Z07:00"`
 		Granularity string    `form:"granularity"`
+		Quantiles   string    `form:"quantiles"`
+		Breakdown   bool      `form:"breakdown"`
+		AsOf        time.Time `form:"as_of" time_format:"2006-01-02T15:04:05Z07:00"`
+		AsOfCursor  int64     `form:"as_of_cursor"`
 	}
 
 	// Bind URI parameters (principal_id)
@@ -50,12 +58,37 @@ func (s *Service) HandleQueryAggregates(c *gin.Context) {
 		return
 	}
 
+	quantiles, err := ParseQuantiles(query.Quantiles)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, httperr.ErrorResponse{
+			ErrorType: httperr.HttpInvalidJsonError,
+			Message:   "Invalid query parameters",
+			Details:   err.Error(),
+		})
+		return
+	}
+
+	dimensions, err := ParseDimensionFilters(c.Request.URL.Query())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, httperr.ErrorResponse{
+			ErrorType: httperr.HttpInvalidJsonError,
+			Message:   "Invalid query parameters",
+			Details:   err.Error(),
+		})
+		return
+	}
+
 	req := AggregateQueryRequest{
 		PrincipalID: uri.PrincipalID,
 		Rule:        query.Rule,
 		Start:       query.Start,
 		End:         query.End,
 		Granularity: query.Granularity,
+		Quantiles:   quantiles,
+		Dimensions:  dimensions,
+		Breakdown:   query.Breakdown,
+		AsOf:        query.AsOf,
+		AsOfCursor:  query.AsOfCursor,
 	}
 
 	// Execute query
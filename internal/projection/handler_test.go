@@ -91,7 +91,7 @@ func TestService_HandleQueryAggregates_StatusMapping(t *testing.T) {
 			},
 			configureEvent: func(eventStore *storagemocks.EventStore) {
 				eventStore.EXPECT().
-					RetrieveScopedEventsAfterCursor(mock.Anything, int64(42), "user-1", "api.request", start, end, rawQueryBatchSize).
+					RetrieveScopedEventsAfterCursor(mock.Anything, int64(42), "user-1", "api.request", start, end, false, rawQueryBatchSize).
 					Return(nil, fmt.Errorf("event store failure")).
 					Once()
 			},
@@ -167,8 +167,8 @@ func TestService_HandleQueryAggregates_TimeoutReturnsGatewayTimeout(t *testing.T
 
 	eventStore := storagemocks.NewEventStore(t)
 	eventStore.EXPECT().
-		RetrieveScopedEventsAfterCursor(mock.Anything, int64(0), "user-1", "api.request", start, end, rawQueryBatchSize).
-		RunAndReturn(func(ctx context.Context, _ int64, _ string, _ string, _ time.Time, _ time.Time, _ int) ([]*v1.Event, error) {
+		RetrieveScopedEventsAfterCursor(mock.Anything, int64(0), "user-1", "api.request", start, end, false, rawQueryBatchSize).
+		RunAndReturn(func(ctx context.Context, _ int64, _ string, _ string, _ time.Time, _ time.Time, _ bool, _ int) ([]*v1.Event, error) {
 			<-ctx.Done()
 			return nil, ctx.Err()
 		}).
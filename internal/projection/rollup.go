@@ -7,50 +7,20 @@ import (
 	"github.com/shopspring/decimal"
 )
 
-// rollupTotal sums all aggregates into a single value for the entire range.
-// For count/sum: adds all values together.
-// For min/max: finds the global min/max across all buckets.
+// rollupTotal folds all aggregates into a single value for the entire range
+// using the operator's Merge semantics (see foldBuckets).
 func (s *Service) rollupTotal(
 	aggregates []aggregation.AggregateState,
 	start, end time.Time,
 ) []AggregateValue {
-	if len(aggregates) == 0 {
-		return []AggregateValue{{
-			WindowStart: start,
-			WindowEnd:   end,
-			Value:       decimal.Zero,
-			EventCount:  0,
-		}}
-	}
-
-	operator := aggregates[0].Operator
-	total := decimal.Zero
-	totalEvents := int64(0)
-	initialized := false
-
-	for _, agg := range aggregates {
-		switch operator {
-		case "count", "sum":
-			total = total.Add(agg.Value)
-		case "min":
-			if !initialized || agg.Value.LessThan(total) {
-				total = agg.Value
-				initialized = true
-			}
-		case "max":
-			if !initialized || agg.Value.GreaterThan(total) {
-				total = agg.Value
-				initialized = true
-			}
-		}
-		totalEvents += agg.EventCount
-	}
+	total := foldBuckets(aggregates)
 
 	return []AggregateValue{{
 		WindowStart: start,
 		WindowEnd:   end,
-		Value:       total,
-		EventCount:  totalEvents,
+		Value:       total.Value,
+		EventCount:  total.EventCount,
+		digest:      total.Digest,
 	}}
 }
 
@@ -68,15 +38,15 @@ func (s *Service) convertToValues(aggregates []aggregation.AggregateState, bucke
 			WindowEnd:   agg.WindowStart.Add(bucketDuration),
 			Value:       agg.Value,
 			EventCount:  agg.EventCount,
+			digest:      agg.Digest,
 		})
 	}
 
 	return values
 }
 
-// rollupToHour groups 1-minute buckets into hourly buckets.
-// For count/sum: adds values within each hour.
-// For min/max: finds min/max within each hour.
+// rollupToHour groups 1-minute buckets into hourly buckets,
+// folding each hour with the operator's Merge semantics.
 func (s *Service) rollupToHour(
 	aggregates []aggregation.AggregateState,
 	start, end time.Time,
@@ -85,8 +55,6 @@ func (s *Service) rollupToHour(
 		return s.emptyHourlyBuckets(start, end)
 	}
 
-	operator := aggregates[0].Operator
-
 	// Group aggregates by hour
 	hourlyBuckets := make(map[time.Time][]aggregation.AggregateState)
 	for _, agg := range aggregates {
@@ -98,37 +66,14 @@ func (s *Service) rollupToHour(
 	var results []AggregateValue
 	currentHour := start.Truncate(time.Hour)
 	for currentHour.Before(end) {
-		bucket := hourlyBuckets[currentHour]
-
-		value := decimal.Zero
-		eventCount := int64(0)
-		initialized := false
-
-		if len(bucket) > 0 {
-			for _, agg := range bucket {
-				switch operator {
-				case "count", "sum":
-					value = value.Add(agg.Value)
-				case "min":
-					if !initialized || agg.Value.LessThan(value) {
-						value = agg.Value
-						initialized = true
-					}
-				case "max":
-					if !initialized || agg.Value.GreaterThan(value) {
-						value = agg.Value
-						initialized = true
-					}
-				}
-				eventCount += agg.EventCount
-			}
-		}
+		hour := foldBuckets(hourlyBuckets[currentHour])
 
 		results = append(results, AggregateValue{
 			WindowStart: currentHour,
 			WindowEnd:   currentHour.Add(time.Hour),
-			Value:       value,
-			EventCount:  eventCount,
+			Value:       hour.Value,
+			EventCount:  hour.EventCount,
+			digest:      hour.Digest,
 		})
 
 		currentHour = currentHour.Add(time.Hour)
@@ -137,9 +82,8 @@ func (s *Service) rollupToHour(
 	return results
 }
 
-// rollupToDay groups 1-minute buckets into daily buckets.
-// For count/sum: adds values within each day.
-// For min/max: finds min/max within each day.
+// rollupToDay groups 1-minute buckets into daily buckets,
+// folding each day with the operator's Merge semantics.
 func (s *Service) rollupToDay(
 	aggregates []aggregation.AggregateState,
 	start, end time.Time,
@@ -148,8 +92,6 @@ func (s *Service) rollupToDay(
 		return s.emptyDailyBuckets(start, end)
 	}
 
-	operator := aggregates[0].Operator
-
 	// Group aggregates by day
 	dailyBuckets := make(map[time.Time][]aggregation.AggregateState)
 	for _, agg := range aggregates {
@@ -166,37 +108,14 @@ func (s *Service) rollupToDay(
 	}
 
 	for currentDay.Before(endDayExclusive) {
-		bucket := dailyBuckets[currentDay]
-
-		value := decimal.Zero
-		eventCount := int64(0)
-		initialized := false
-
-		if len(bucket) > 0 {
-			for _, agg := range bucket {
-				switch operator {
-				case "count", "sum":
-					value = value.Add(agg.Value)
-				case "min":
-					if !initialized || agg.Value.LessThan(value) {
-						value = agg.Value
-						initialized = true
-					}
-				case "max":
-					if !initialized || agg.Value.GreaterThan(value) {
-						value = agg.Value
-						initialized = true
-					}
-				}
-				eventCount += agg.EventCount
-			}
-		}
+		day := foldBuckets(dailyBuckets[currentDay])
 
 		results = append(results, AggregateValue{
 			WindowStart: currentDay,
 			WindowEnd:   currentDay.Add(24 * time.Hour),
-			Value:       value,
-			EventCount:  eventCount,
+			Value:       day.Value,
+			EventCount:  day.EventCount,
+			digest:      day.Digest,
 		})
 
 		currentDay = currentDay.Add(24 * time.Hour)
@@ -247,3 +166,44 @@ func truncateToDay(t time.Time) time.Time {
 	year, month, day := t.Date()
 	return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
 }
+
+// foldBuckets merges bucket states into one state whose Value and EventCount cover
+// all buckets. count/sum add, min/max pick the extreme, avg re-derives the mean from
+// the summed Sum and EventCount, first/last compare ValueAt, and count_distinct/quantile
+// merge their sketches. Buckets are expected in WindowStart order so first/last ties
+// resolve the same way as the batch job. Inputs are not mutated.
+func foldBuckets(buckets []aggregation.AggregateState) aggregation.AggregateState {
+	if len(buckets) == 0 {
+		return aggregation.AggregateState{Value: decimal.Zero}
+	}
+
+	agg, ok := aggregation.Operators[buckets[0].Operator]
+	if !ok {
+		var eventCount int64
+		for _, bucket := range buckets {
+			eventCount += bucket.EventCount
+		}
+		return aggregation.AggregateState{Value: decimal.Zero, EventCount: eventCount}
+	}
+
+	acc := buckets[0]
+	for _, bucket := range buckets[1:] {
+		acc = agg.Merge(acc, bucket)
+		acc.EventCount += bucket.EventCount
+	}
+	return acc
+}
+
+// attachQuantiles fills AggregateValue.Quantiles from each window's merged digest.
+func attachQuantiles(values []AggregateValue, quantiles []float64) {
+	if len(quantiles) == 0 {
+		return
+	}
+	for i := range values {
+		out := make(map[string]decimal.Decimal, len(quantiles))
+		for _, q := range quantiles {
+			out[formatQuantile(q)] = aggregation.QuantileValue(values[i].digest, q)
+		}
+		values[i].Quantiles = out
+	}
+}
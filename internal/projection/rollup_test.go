@@ -236,3 +236,42 @@ func TestService_RollupToDay_Boundaries(t *testing.T) {
 		})
 	}
 }
+
+func TestService_Rollups_CountDistinctMergesSketches(t *testing.T) {
+	svc := &Service{}
+	start := time.Date(2026, 2, 1, 10, 0, 0, 0, time.UTC)
+	end := start.Add(2 * time.Hour)
+
+	bucket := func(windowStart time.Time, keys ...string) coreagg.AggregateState {
+		sketch := coreagg.NewSketch()
+		for _, key := range keys {
+			sketch.Add(key)
+		}
+		return coreagg.AggregateState{
+			Operator:    coreagg.OpCountDistinct,
+			Value:       decimal.NewFromInt(int64(sketch.Estimate())),
+			Sketch:      sketch,
+			EventCount:  int64(len(keys)),
+			WindowStart: windowStart,
+		}
+	}
+
+	// The same endpoint in two minutes must not be counted twice.
+	aggregates := []coreagg.AggregateState{
+		bucket(start, "/a", "/b"),
+		bucket(start.Add(time.Minute), "/b", "/c"),
+		bucket(start.Add(time.Hour), "/a", "/d"),
+	}
+
+	total := svc.rollupTotal(aggregates, start, end)
+	require.Equal(t, "4", total[0].Value.String())
+	require.Equal(t, int64(6), total[0].EventCount)
+
+	hourly := svc.rollupToHour(aggregates, start, end)
+	require.Len(t, hourly, 2)
+	require.Equal(t, "3", hourly[0].Value.String())
+	require.Equal(t, "2", hourly[1].Value.String())
+
+	require.Equal(t, "2", aggregates[0].Value.String(), "rollups must not mutate bucket sketches")
+	require.Equal(t, uint64(2), aggregates[0].Sketch.Estimate())
+}
@@ -5,34 +5,39 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"net/url"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	aggstore "github.com/aevon-lab/project-aevon/internal/aggregation"
 	v1 "github.com/aevon-lab/project-aevon/internal/api/v1"
 	coreagg "github.com/aevon-lab/project-aevon/internal/core/aggregation"
 	"github.com/aevon-lab/project-aevon/internal/core/storage"
-	"github.com/shopspring/decimal"
 )
 
 const (
 	defaultBucketSize     = "1m"
 	rawQueryBatchSize     = 5000
-	maxRawQueryIterations = 20 // Limit to prevent timeout/OOM when checkpoint is far behind
+	maxRawQueryIterations = 20 // Limit of raw tail scans, to fail fast when the checkpoint is far behind
 	defaultQueryTimeout   = 10 * time.Second
+	edgeClockSkew         = time.Hour // How far occurred_at may run ahead of ingestion for edge scans to find an event
+	dimensionParamPrefix  = "dim."
 )
 
 var (
 	// ErrInvalidQuery marks request validation errors that should return HTTP 400.
 	ErrInvalidQuery = errors.New("invalid aggregate query")
 
-	bucketCandidatesByGranularity = map[string][]string{
-		"total": {"1m"},
-		"1m":    {"1m"},
-		"1h":    {"1m"},
-		"1d":    {"1m"},
+	// granularityDurations bounds the bucket sizes usable for each granularity.
+	// total has no bound: any bucket aligned with the query range works.
+	granularityDurations = map[string]time.Duration{
+		"total": 0,
+		"1m":    time.Minute,
+		"1h":    time.Hour,
+		"1d":    24 * time.Hour,
 	}
 )
 
@@ -41,9 +46,12 @@ var (
 type Service struct {
 	preAggStore  aggstore.PreAggregateStore
 	eventStore   storage.EventStore
-	rules        map[string]coreagg.AggregationRule
 	nowFn        func() time.Time
 	queryTimeout time.Duration
+	streams      *streamHub
+
+	mu    sync.RWMutex // guards rules, which are swapped on rule reload
+	rules map[string]coreagg.AggregationRule
 }
 
 // checkpointSnapshotReader allows projection reads to fetch checkpoint + aggregates
@@ -59,30 +67,57 @@ type checkpointSnapshotReader interface {
 	) ([]coreagg.AggregateState, int64, error)
 }
 
+// ruleVersionReader exposes rule rebuild progress so queries avoid buckets whose
+// pre-aggregates are incomplete after a rule change.
+type ruleVersionReader interface {
+	ListRuleVersions(ctx context.Context, ruleName string) ([]coreagg.RuleVersion, error)
+}
+
 // NewService creates a new projection service.
 func NewService(
 	preAggStore aggstore.PreAggregateStore,
 	eventStore storage.EventStore,
 	rules []coreagg.AggregationRule,
 ) *Service {
-	ruleMap := make(map[string]coreagg.AggregationRule, len(rules))
-	for _, rule := range rules {
-		ruleMap[rule.Name] = rule
-	}
-
 	return &Service{
 		preAggStore: preAggStore,
 		eventStore:  eventStore,
-		rules:       ruleMap,
+		rules:       rulesByName(rules),
 		nowFn: func() time.Time {
 			return time.Now().UTC()
 		},
 		queryTimeout: defaultQueryTimeout,
+		streams:      newStreamHub(),
 	}
 }
 
+// SetRules replaces the queryable rules. Queries already running keep the rule
+// definition they started with.
+func (s *Service) SetRules(rules []coreagg.AggregationRule) {
+	ruleMap := rulesByName(rules)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rules = ruleMap
+}
+
+func rulesByName(rules []coreagg.AggregationRule) map[string]coreagg.AggregationRule {
+	ruleMap := make(map[string]coreagg.AggregationRule, len(rules))
+	for _, rule := range rules {
+		ruleMap[rule.Name] = rule
+	}
+	return ruleMap
+}
+
+func (s *Service) rule(ruleName string) (coreagg.AggregationRule, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	rule, ok := s.rules[ruleName]
+	return rule, ok
+}
+
 func (s *Service) hasPreAggregationRule(ruleName string) bool {
-	_, ok := s.rules[ruleName]
+	_, ok := s.rule(ruleName)
 	return ok
 }
 
@@ -97,41 +132,172 @@ func (s *Service) QueryAggregates(ctx context.Context, req AggregateQueryRequest
 		return nil, err
 	}
 
-	rule, ok := s.rules[req.Rule]
+	rule, ok := s.rule(req.Rule)
 	if !ok {
 		return nil, invalidQueryf("unknown rule: %s", req.Rule)
 	}
+	if len(req.Quantiles) > 0 && rule.Operator != coreagg.OpQuantile {
+		return nil, invalidQueryf("quantiles requires a quantile rule (rule %s uses %s)", req.Rule, rule.Operator)
+	}
+	if err := validateDimensionQuery(req, rule); err != nil {
+		return nil, err
+	}
+
+	// Pre-aggregates only serve the whole buckets of the range; the edges are folded
+	// from raw events below.
+	inner, edges := splitRange(req.Start, req.End, rule.Buckets()[0])
+	innerReq := req
+	innerReq.Start, innerReq.End = inner.start, inner.end
+
+	candidates, err := bucketCandidates(rule, innerReq)
+	if err != nil {
+		return nil, err
+	}
+	if inner.empty() {
+		candidates = nil
+	} else {
+		candidates, err = s.excludeRebuildingBuckets(ctx, rule, candidates)
+		if err != nil {
+			return nil, fmt.Errorf("read rule versions: %w", err)
+		}
+	}
 
-	preAggregates, bucketSize, checkpoint, err := s.loadPreAggregates(ctx, req)
+	asOf, err := s.resolveAsOf(ctx, req)
 	if err != nil {
-		return nil, fmt.Errorf("query pre-aggregates: %w", err)
+		return nil, fmt.Errorf("resolve as_of: %w", err)
+	}
+
+	var (
+		preAggregates []coreagg.AggregateState
+		bucketSize    = coreagg.BucketLabel(rule.Buckets()[0])
+		checkpoint    int64
+		rebuilding    = !inner.empty() && len(candidates) == 0
+	)
+	if len(candidates) > 0 {
+		preAggregates, bucketSize, checkpoint, err = s.loadPreAggregates(ctx, innerReq, candidates)
+		if err != nil {
+			return nil, fmt.Errorf("query pre-aggregates: %w", err)
+		}
+	}
+	if hasStaleFingerprint(preAggregates, rule) {
+		// The rule changed and its rebuild has not started yet: durable buckets mix
+		// definitions, so the whole range is served from the event log.
+		preAggregates, checkpoint, rebuilding = nil, 0, true
+	}
+	replayed, retract := false, false
+	if !asOf.allowsCheckpoint(checkpoint) {
+		// Pre-aggregates already contain events ingested after as_of. Operators that can
+		// retract take those events back out; the others replay the range from the
+		// event log.
+		if _, ok := coreagg.Operators[rule.Operator].(coreagg.Retractor); ok && len(preAggregates) > 0 {
+			retract = true
+		} else {
+			preAggregates, checkpoint, replayed = nil, 0, true
+		}
 	}
 
 	merged := preAggregates
-	rawAggregates, rawErr := s.loadRawEvents(ctx, req, rule, bucketSize, checkpoint)
-	if rawErr != nil {
-		return nil, fmt.Errorf("query raw event tail: %w", rawErr)
+	if retract {
+		tail, retracted, rawErr := s.loadRetractedRawEvents(ctx, innerReq, rule, bucketSize, checkpoint, asOf)
+		if rawErr != nil {
+			return nil, fmt.Errorf("query raw events after as_of: %w", rawErr)
+		}
+		merged = retractAggregateStates(merged, retracted, rule.Operator)
+		merged = mergeAggregateStates(merged, tail, rule.Operator)
+	} else if !inner.empty() {
+		rawAggregates, rawErr := s.loadRawEvents(ctx, innerReq, rule, bucketSize, checkpoint, rebuilding || asOf.set, asOf)
+		if rawErr != nil {
+			return nil, fmt.Errorf("query raw event tail: %w", rawErr)
+		}
+		merged = mergeAggregateStates(merged, rawAggregates, rule.Operator)
+	}
+	var firstAfter func(time.Time) (int64, error)
+	if reader, ok := s.eventStore.(storage.IngestCursorReader); ok {
+		firstAfter = func(t time.Time) (int64, error) {
+			return reader.FirstIngestSeqAfter(ctx, req.PrincipalID, t)
+		}
+	}
+	for _, edge := range edges {
+		// An edge bucket straddles the range, so its pre-aggregate cannot serve it; the
+		// edge is folded from the raw events ingested since it started.
+		cursor, found, cursorErr := edgeCursor(edge, firstAfter)
+		if cursorErr != nil {
+			return nil, fmt.Errorf("find range edge cursor: %w", cursorErr)
+		}
+		if !found {
+			continue
+		}
+		edgeReq := req
+		edgeReq.Start, edgeReq.End = edge.start, edge.end
+		edgeAggregates, rawErr := s.loadRawEvents(ctx, edgeReq, rule, bucketSize, cursor, false, asOf)
+		if rawErr != nil {
+			return nil, fmt.Errorf("query raw events at range edge: %w", rawErr)
+		}
+		merged = mergeAggregateStates(merged, edgeAggregates, rule.Operator)
+	}
+
+	resp, err := s.buildResponse(req, rule, merged, bucketSize, asOf)
+	if err != nil {
+		return nil, err
+	}
+	resp.Rebuilding = rebuilding
+	resp.Replayed = replayed
+	return resp, nil
+}
+
+// buildResponse rolls merged pre-aggregate and raw tail states of one principal and rule
+// up into the response for req.
+func (s *Service) buildResponse(
+	req AggregateQueryRequest,
+	rule coreagg.AggregationRule,
+	merged []coreagg.AggregateState,
+	bucketSize string,
+	asOf asOfBound,
+) (*AggregateQueryResponse, error) {
+	merged, err := filterByDimensions(merged, req.Dimensions)
+	if err != nil {
+		return nil, err
 	}
-	merged = mergeAggregateStates(merged, rawAggregates, rule.Operator)
 
 	bucketDuration, parseErr := parseBucketSize(bucketSize)
 	if parseErr != nil {
 		return nil, fmt.Errorf("invalid bucket size %q: %w", bucketSize, parseErr)
 	}
 
-	values := s.rollupForGranularity(merged, req.Granularity, bucketDuration, req.Start, req.End)
+	values := s.rollupForGranularity(collapseDimensions(merged, rule.Operator), req.Granularity, bucketDuration, req.Start, req.End)
+	attachQuantiles(values, req.Quantiles)
+
+	var groups []AggregateGroup
+	if req.Breakdown {
+		groups, err = s.breakdownByDimensions(merged, req, bucketDuration)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// A point-in-time query answers as of the requested ingestion time.
+	now := s.nowFn()
+	if !asOf.at.IsZero() {
+		now = minTime(now, asOf.at)
+	}
 
 	// Compute accurate data_through based on actual data
 	dataThrough := s.computeDataThrough(req.End, merged, bucketDuration)
 
 	// Cap at current time - can't have data from the future
-	dataThrough = minTime(dataThrough, s.nowFn())
+	dataThrough = minTime(dataThrough, now)
 
-	staleness := int(s.nowFn().Sub(dataThrough).Seconds())
+	staleness := int(now.Sub(dataThrough).Seconds())
 	if staleness < 0 {
 		staleness = 0
 	}
 
+	var finalizedThrough *time.Time
+	if t, ok := rule.FinalizedThrough(now); ok {
+		t = minTime(t, req.End)
+		finalizedThrough = &t
+	}
+
 	return &AggregateQueryResponse{
 		PrincipalID:      req.PrincipalID,
 		Rule:             req.Rule,
@@ -140,11 +306,101 @@ func (s *Service) QueryAggregates(ctx context.Context, req AggregateQueryRequest
 		End:              req.End,
 		Granularity:      req.Granularity,
 		DataThrough:      dataThrough,
+		FinalizedThrough: finalizedThrough,
 		StalenessSeconds: staleness,
 		Values:           values,
+		Groups:           groups,
+		AsOf:             asOfTime(req.AsOf),
+		AsOfCursor:       req.AsOfCursor,
 	}, nil
 }
 
+// excludeRebuildingBuckets drops candidate buckets whose pre-aggregates are still being
+// rebuilt. An empty result means every bucket of the rule is rebuilding.
+func (s *Service) excludeRebuildingBuckets(ctx context.Context, rule coreagg.AggregationRule, candidates []string) ([]string, error) {
+	reader, ok := s.preAggStore.(ruleVersionReader)
+	if !ok {
+		return candidates, nil
+	}
+	versions, err := reader.ListRuleVersions(ctx, rule.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	rebuilding := make(map[string]bool, len(versions))
+	for _, version := range versions {
+		rebuilding[version.BucketSize] = version.Rebuilding() || version.Fingerprint != rule.Fingerprint
+	}
+
+	usable := make([]string, 0, len(candidates))
+	for _, bucketSize := range candidates {
+		if !rebuilding[bucketSize] {
+			usable = append(usable, bucketSize)
+		}
+	}
+	return usable, nil
+}
+
+// hasStaleFingerprint reports whether any pre-aggregate was built with another rule definition.
+func hasStaleFingerprint(aggregates []coreagg.AggregateState, rule coreagg.AggregationRule) bool {
+	if rule.Fingerprint == "" {
+		return false
+	}
+	for _, state := range aggregates {
+		if state.RuleFingerprint != "" && state.RuleFingerprint != rule.Fingerprint {
+			return true
+		}
+	}
+	return false
+}
+
+// validateDimensionQuery checks that dimension filters and breakdowns only target
+// group_by paths of the rule.
+func validateDimensionQuery(req AggregateQueryRequest, rule coreagg.AggregationRule) error {
+	if !req.Breakdown && len(req.Dimensions) == 0 {
+		return nil
+	}
+	if len(rule.GroupBy) == 0 {
+		return invalidQueryf("rule %s has no group_by dimensions", req.Rule)
+	}
+	for path := range req.Dimensions {
+		if !containsString(rule.GroupBy, path) {
+			return invalidQueryf("unknown dimension %q for rule %s (group_by: %s)", path, req.Rule, strings.Join(rule.GroupBy, ", "))
+		}
+	}
+	return nil
+}
+
+// breakdownByDimensions rolls up each dimension tuple separately, ordered by its encoding.
+func (s *Service) breakdownByDimensions(
+	aggregates []coreagg.AggregateState,
+	req AggregateQueryRequest,
+	bucketDuration time.Duration,
+) ([]AggregateGroup, error) {
+	byDimensions := make(map[string][]coreagg.AggregateState)
+	for _, state := range aggregates {
+		byDimensions[state.Dimensions] = append(byDimensions[state.Dimensions], state)
+	}
+
+	encodings := make([]string, 0, len(byDimensions))
+	for encoded := range byDimensions {
+		encodings = append(encodings, encoded)
+	}
+	sort.Strings(encodings)
+
+	groups := make([]AggregateGroup, 0, len(encodings))
+	for _, encoded := range encodings {
+		dimensions, err := coreagg.DecodeDimensions(encoded)
+		if err != nil {
+			return nil, err
+		}
+		values := s.rollupForGranularity(byDimensions[encoded], req.Granularity, bucketDuration, req.Start, req.End)
+		attachQuantiles(values, req.Quantiles)
+		groups = append(groups, AggregateGroup{Dimensions: dimensions, Values: values})
+	}
+	return groups, nil
+}
+
 func (s *Service) normalizeAndValidate(req AggregateQueryRequest) (AggregateQueryRequest, error) {
 	if req.Granularity == "" {
 		req.Granularity = "total"
@@ -166,15 +422,82 @@ func (s *Service) normalizeAndValidate(req AggregateQueryRequest) (AggregateQuer
 		return req, invalidQueryf("invalid granularity: %s (must be total, 1m, 1h, or 1d)", req.Granularity)
 	}
 
+	if req.AsOfCursor < 0 {
+		return req, invalidQueryf("as_of_cursor must be >= 0")
+	}
+
+	for _, q := range req.Quantiles {
+		if q < 0 || q > 1 {
+			return req, invalidQueryf("invalid quantile: %s (must be between 0 and 1)", formatQuantile(q))
+		}
+	}
+
 	return req, nil
 }
 
-func (s *Service) loadPreAggregates(ctx context.Context, req AggregateQueryRequest) ([]coreagg.AggregateState, string, int64, error) {
-	candidates := bucketCandidatesByGranularity[req.Granularity]
-	if len(candidates) == 0 {
-		candidates = []string{defaultBucketSize}
+// timeRange is the half-open interval [start, end).
+type timeRange struct {
+	start, end time.Time
+}
+
+func (r timeRange) empty() bool {
+	return !r.start.Before(r.end)
+}
+
+// splitRange splits [start, end) into inner, the whole buckets of size finest it covers,
+// and the edges before and after inner. Pre-aggregates of a bucket straddling a range
+// bound cannot be split at it, so edges are always folded from raw events; inner is
+// empty if the range covers no whole bucket, and its only edge is then the range itself.
+func splitRange(start, end time.Time, finest time.Duration) (inner timeRange, edges []timeRange) {
+	inner = timeRange{start: coreagg.BucketFor(start, finest), end: coreagg.BucketFor(end, finest)}
+	if inner.start.Before(start) {
+		inner.start = inner.start.Add(finest)
+	}
+	if inner.empty() {
+		return timeRange{}, []timeRange{{start: start, end: end}}
+	}
+	if start.Before(inner.start) {
+		edges = append(edges, timeRange{start: start, end: inner.start})
+	}
+	if inner.end.Before(end) {
+		edges = append(edges, timeRange{start: inner.end, end: end})
+	}
+	return inner, edges
+}
+
+// bucketCandidates lists the rule's bucket sizes usable for the query, coarsest first.
+// A bucket fits when it evenly divides the granularity and the query range starts and
+// ends on its boundaries, so no bucket straddles the range. The finest bucket is always
+// the last candidate; callers pass the inner range of splitRange, which is aligned to it.
+// A granularity finer than the finest bucket is rejected.
+func bucketCandidates(rule coreagg.AggregationRule, req AggregateQueryRequest) ([]string, error) {
+	buckets := rule.Buckets()
+	finest := buckets[0]
+	granularity := granularityDurations[req.Granularity]
+	if granularity > 0 && granularity < finest {
+		return nil, invalidQueryf("granularity %s is finer than the finest bucket of rule %s (%s)",
+			req.Granularity, rule.Name, coreagg.BucketLabel(finest))
 	}
 
+	candidates := make([]string, 0, len(buckets))
+	for i := len(buckets) - 1; i > 0; i-- {
+		bucket := buckets[i]
+		if granularity > 0 && granularity%bucket != 0 {
+			continue
+		}
+		if !coreagg.BucketFor(req.Start, bucket).Equal(req.Start) || !coreagg.BucketFor(req.End, bucket).Equal(req.End) {
+			continue
+		}
+		candidates = append(candidates, coreagg.BucketLabel(bucket))
+	}
+	return append(candidates, coreagg.BucketLabel(finest)), nil
+}
+
+func (s *Service) loadPreAggregates(
+	ctx context.Context,
+	req AggregateQueryRequest,
+	candidates []string,
+) ([]coreagg.AggregateState, string, int64, error) {
 	snapshotReader, hasSnapshotReader := s.preAggStore.(checkpointSnapshotReader)
 
 	for idx, bucketSize := range candidates {
@@ -223,12 +546,34 @@ func (s *Service) loadPreAggregates(ctx context.Context, req AggregateQueryReque
 	return nil, defaultBucketSize, 0, nil
 }
 
+// edgeCursor returns the cursor the raw event scan of a range edge starts after. No event
+// occurs more than edgeClockSkew after it is ingested, so no event of the edge was
+// ingested before its start less the skew; firstAfter maps that time to the first
+// ingest_seq of the scanned events. found is false if no event was ingested since. Without
+// firstAfter the scan starts at the beginning of the event log.
+func edgeCursor(edge timeRange, firstAfter func(time.Time) (int64, error)) (cursor int64, found bool, err error) {
+	if firstAfter == nil {
+		return 0, true, nil
+	}
+	first, err := firstAfter(edge.start.Add(-edgeClockSkew))
+	if err != nil || first == 0 {
+		return 0, false, err
+	}
+	return first - 1, true, nil
+}
+
+// loadRawEvents folds the raw events of req's range past checkpoint into buckets. With
+// replay set the events replace pre-aggregates rather than extend them, so the scan pages
+// through the whole range instead of giving up after maxRawQueryIterations (see
+// scanScopedRawEvents).
 func (s *Service) loadRawEvents(
 	ctx context.Context,
 	req AggregateQueryRequest,
 	rule coreagg.AggregationRule,
 	bucketSize string,
 	checkpoint int64,
+	replay bool,
+	asOf asOfBound,
 ) ([]coreagg.AggregateState, error) {
 	bucketDuration, err := parseBucketSize(bucketSize)
 	if err != nil {
@@ -240,39 +585,108 @@ func (s *Service) loadRawEvents(
 		return nil, fmt.Errorf("unknown rule operator: %s", rule.Operator)
 	}
 
-	buckets := make(map[time.Time]coreagg.AggregateState)
-	err = s.scanScopedRawEvents(ctx, checkpoint, req, rule.SourceEvent, func(events []*v1.Event) {
-		s.foldRawEventsIntoBuckets(events, buckets, rule, reducer, bucketDuration)
+	buckets := make(map[bucketKey]coreagg.AggregateState)
+	err = s.scanScopedRawEvents(ctx, checkpoint, asOf.cursor, replay, req, rule, func(events []*v1.Event) {
+		if asOf.set {
+			included := make([]*v1.Event, 0, len(events))
+			for _, evt := range events {
+				if asOf.includes(evt) {
+					included = append(included, evt)
+				}
+			}
+			events = included
+		}
+		s.foldRawEventsIntoBuckets(events, buckets, rule, reducer, bucketDuration, req.Start, req.End)
 	})
 	if err != nil {
 		return nil, err
 	}
+	return sortedStates(buckets), nil
+}
+
+// loadRetractedRawEvents folds the raw events an as_of query needs on top of
+// pre-aggregates flushed up to checkpoint, which lies past the bound's checkpoint limit.
+// Events up to checkpoint that the bound excludes are already in the pre-aggregates and
+// come back as retracted; events past checkpoint that it includes come back as the tail.
+// Only events after the checkpoint limit can be either, so the scan starts there.
+func (s *Service) loadRetractedRawEvents(
+	ctx context.Context,
+	req AggregateQueryRequest,
+	rule coreagg.AggregationRule,
+	bucketSize string,
+	checkpoint int64,
+	asOf asOfBound,
+) (tail, retracted []coreagg.AggregateState, err error) {
+	bucketDuration, err := parseBucketSize(bucketSize)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	reducer, ok := coreagg.Operators[rule.Operator]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown rule operator: %s", rule.Operator)
+	}
+
+	var untilCursor int64
+	if asOf.cursor > 0 {
+		untilCursor = max(checkpoint, asOf.cursor)
+	}
 
+	tailBuckets := make(map[bucketKey]coreagg.AggregateState)
+	retractedBuckets := make(map[bucketKey]coreagg.AggregateState)
+	err = s.scanScopedRawEvents(ctx, asOf.checkpointLimit, untilCursor, true, req, rule, func(events []*v1.Event) {
+		var added, excluded []*v1.Event
+		for _, evt := range events {
+			switch included := asOf.includes(evt); {
+			case evt.IngestSeq <= checkpoint && !included:
+				excluded = append(excluded, evt)
+			case evt.IngestSeq > checkpoint && included:
+				added = append(added, evt)
+			}
+		}
+		s.foldRawEventsIntoBuckets(added, tailBuckets, rule, reducer, bucketDuration, req.Start, req.End)
+		s.foldRawEventsIntoBuckets(excluded, retractedBuckets, rule, reducer, bucketDuration, req.Start, req.End)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return sortedStates(tailBuckets), sortedStates(retractedBuckets), nil
+}
+
+func sortedStates(buckets map[bucketKey]coreagg.AggregateState) []coreagg.AggregateState {
 	results := make([]coreagg.AggregateState, 0, len(buckets))
 	for _, state := range buckets {
 		results = append(results, state)
 	}
 
-	sort.Slice(results, func(i, j int) bool {
-		return results[i].WindowStart.Before(results[j].WindowStart)
-	})
+	sortByWindowAndDimensions(results)
 
-	return results, nil
+	return results
 }
 
+// scanScopedRawEvents hands the events of req's principal, eventType and range after
+// cursor to consume, one page at a time. A raw tail longer than maxRawQueryIterations
+// pages means aggregation fell behind and fails the scan; a replay pages through the
+// whole range, bounded only by ctx. Folding keeps one state per bucket, so neither
+// holds more than a page of events at once.
 func (s *Service) scanScopedRawEvents(
 	ctx context.Context,
 	cursor int64,
+	untilCursor int64, // stop after this ingest_seq; 0 scans to the end
+	replay bool,
 	req AggregateQueryRequest,
-	eventType string,
+	rule coreagg.AggregationRule,
 	consume func(events []*v1.Event),
 ) error {
 	iterations := 0
 	totalEvents := 0
 
 	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		// Safety limit: prevent unbounded scanning if checkpoint is far behind
-		if iterations >= maxRawQueryIterations {
+		if !replay && iterations >= maxRawQueryIterations {
 			slog.Warn("Raw event tail scan reached maximum iteration limit",
 				"principal", req.PrincipalID,
 				"iterations", iterations,
@@ -287,9 +701,10 @@ func (s *Service) scanScopedRawEvents(
 			ctx,
 			cursor,
 			req.PrincipalID,
-			eventType,
+			rule.SourceEvent,
 			req.Start,
 			req.End,
+			rule.BucketsByIngestion(),
 			rawQueryBatchSize,
 		)
 		if queryErr != nil {
@@ -304,7 +719,7 @@ func (s *Service) scanScopedRawEvents(
 		iterations++
 
 		cursor = events[len(events)-1].IngestSeq
-		if len(events) < rawQueryBatchSize {
+		if len(events) < rawQueryBatchSize || (untilCursor > 0 && cursor >= untilCursor) {
 			return nil
 		}
 	}
@@ -312,34 +727,54 @@ func (s *Service) scanScopedRawEvents(
 
 func (s *Service) foldRawEventsIntoBuckets(
 	events []*v1.Event,
-	buckets map[time.Time]coreagg.AggregateState,
+	buckets map[bucketKey]coreagg.AggregateState,
 	rule coreagg.AggregationRule,
 	reducer coreagg.Aggregator,
 	bucketDuration time.Duration,
+	start, end time.Time,
 ) {
 	for _, evt := range events {
-		windowStart := coreagg.BucketFor(evt.OccurredAt, bucketDuration)
-		fieldValue := coreagg.ExtractDecimal(evt.Data, rule.Field)
+		if !rule.Matches(evt.Data, evt.Metadata) {
+			continue
+		}
+		// Scans of correction rules also return events ingested in the range, which
+		// only count here if the late policy moved them into it.
+		eventTime, ok := rule.EventTime(evt.OccurredAt, evt.IngestedAt)
+		if !ok || eventTime.Before(start) || !eventTime.Before(end) {
+			continue
+		}
+		windowStart := coreagg.BucketFor(eventTime, bucketDuration)
+		key := bucketKey{windowStart: windowStart, dimensions: rule.DimensionsFor(evt.Data, evt.Metadata)}
+		obs := coreagg.Observation{
+			Value:      coreagg.ExtractDecimal(evt.Data, rule.Field),
+			OccurredAt: evt.OccurredAt,
+			Key:        coreagg.ExtractKey(evt.Data, rule.Field),
+		}
 
-		state, exists := buckets[windowStart]
+		state, exists := buckets[key]
 		if !exists {
-			buckets[windowStart] = coreagg.AggregateState{
-				Operator:        rule.Operator,
-				Value:           reducer.Initial(fieldValue),
-				EventCount:      1,
-				LastEventID:     evt.ID,
-				RuleFingerprint: rule.Fingerprint,
-				WindowStart:     windowStart,
-				UpdatedAt:       resolveEventUpdatedAt(evt, s.nowFn()),
-			}
+			state = reducer.Initial(obs)
+			state.Operator = rule.Operator
+			state.EventCount = 1
+			state.LastEventID = evt.ID
+			state.RuleFingerprint = rule.Fingerprint
+			state.WindowStart = windowStart
+			state.Dimensions = key.dimensions
+			state.UpdatedAt = resolveEventUpdatedAt(evt, s.nowFn())
+			buckets[key] = state
 			continue
 		}
 
-		state.Value = reducer.Apply(state.Value, fieldValue)
+		state = reducer.Apply(state, obs)
 		state.EventCount++
 		state.LastEventID = evt.ID
 		state.UpdatedAt = maxTime(state.UpdatedAt, resolveEventUpdatedAt(evt, s.nowFn()))
-		buckets[windowStart] = state
+		buckets[key] = state
+	}
+
+	// Values that Apply left stale are derived once per bucket.
+	for key, state := range buckets {
+		buckets[key] = coreagg.Finalize(state)
 	}
 }
 
@@ -393,19 +828,20 @@ func mergeAggregateStates(
 	tail []coreagg.AggregateState,
 	operator string,
 ) []coreagg.AggregateState {
-	merged := make(map[time.Time]coreagg.AggregateState, len(base)+len(tail))
+	merged := make(map[bucketKey]coreagg.AggregateState, len(base)+len(tail))
 	for _, state := range base {
-		merged[state.WindowStart] = state
+		merged[bucketKeyOf(state)] = state
 	}
 
 	for _, incoming := range tail {
-		current, exists := merged[incoming.WindowStart]
+		key := bucketKeyOf(incoming)
+		current, exists := merged[key]
 		if !exists {
-			merged[incoming.WindowStart] = incoming
+			merged[key] = incoming
 			continue
 		}
 
-		current.Value = mergeAggregateValue(operator, current.Value, incoming.Value)
+		current = mergeAggregateValue(operator, current, incoming)
 		current.EventCount += incoming.EventCount
 		if incoming.LastEventID != "" {
 			current.LastEventID = incoming.LastEventID
@@ -414,7 +850,7 @@ func mergeAggregateStates(
 			current.RuleFingerprint = incoming.RuleFingerprint
 		}
 		current.UpdatedAt = maxTime(current.UpdatedAt, incoming.UpdatedAt)
-		merged[incoming.WindowStart] = current
+		merged[key] = current
 	}
 
 	results := make([]coreagg.AggregateState, 0, len(merged))
@@ -422,30 +858,175 @@ func mergeAggregateStates(
 		results = append(results, state)
 	}
 
-	sort.Slice(results, func(i, j int) bool {
-		return results[i].WindowStart.Before(results[j].WindowStart)
+	sortByWindowAndDimensions(results)
+
+	return results
+}
+
+// retractAggregateStates takes the events folded into retracted back out of base. A
+// bucket left without events is dropped, as if it had never been flushed.
+func retractAggregateStates(
+	base []coreagg.AggregateState,
+	retracted []coreagg.AggregateState,
+	operator string,
+) []coreagg.AggregateState {
+	retractor, ok := coreagg.Operators[operator].(coreagg.Retractor)
+	if !ok || len(retracted) == 0 {
+		return base
+	}
+
+	byKey := make(map[bucketKey]coreagg.AggregateState, len(retracted))
+	for _, state := range retracted {
+		byKey[bucketKeyOf(state)] = state
+	}
+
+	results := make([]coreagg.AggregateState, 0, len(base))
+	for _, state := range base {
+		if outgoing, exists := byKey[bucketKeyOf(state)]; exists {
+			state = retractor.Retract(state, outgoing)
+			state.EventCount -= outgoing.EventCount
+			if state.EventCount <= 0 {
+				continue
+			}
+		}
+		results = append(results, state)
+	}
+	return results
+}
+
+// bucketKey identifies one bucket of a rule: its window and group_by dimension tuple.
+type bucketKey struct {
+	windowStart time.Time
+	dimensions  string
+}
+
+func bucketKeyOf(state coreagg.AggregateState) bucketKey {
+	return bucketKey{windowStart: state.WindowStart, dimensions: state.Dimensions}
+}
+
+func sortByWindowAndDimensions(states []coreagg.AggregateState) {
+	sort.Slice(states, func(i, j int) bool {
+		if !states[i].WindowStart.Equal(states[j].WindowStart) {
+			return states[i].WindowStart.Before(states[j].WindowStart)
+		}
+		return states[i].Dimensions < states[j].Dimensions
 	})
+}
 
+// collapseDimensions merges the dimension tuples of each window into one state, so
+// the overall series of a group_by rule matches the same rule without group_by.
+// Input must be sorted by sortByWindowAndDimensions.
+func collapseDimensions(states []coreagg.AggregateState, operator string) []coreagg.AggregateState {
+	results := make([]coreagg.AggregateState, 0, len(states))
+	for _, state := range states {
+		last := len(results) - 1
+		if last < 0 || !results[last].WindowStart.Equal(state.WindowStart) {
+			state.Dimensions = ""
+			results = append(results, state)
+			continue
+		}
+		current := mergeAggregateValue(operator, results[last], state)
+		current.EventCount += state.EventCount
+		current.UpdatedAt = maxTime(current.UpdatedAt, state.UpdatedAt)
+		results[last] = current
+	}
 	return results
 }
 
-func mergeAggregateValue(operator string, current, incoming decimal.Decimal) decimal.Decimal {
-	switch operator {
-	case coreagg.OpCount, coreagg.OpSum:
-		return current.Add(incoming)
-	case coreagg.OpMin:
-		if incoming.LessThan(current) {
-			return incoming
+// filterByDimensions keeps the states whose dimension tuple matches every filter.
+// Events that lacked a group_by field never match a filter on that field.
+func filterByDimensions(states []coreagg.AggregateState, filters map[string]string) ([]coreagg.AggregateState, error) {
+	if len(filters) == 0 {
+		return states, nil
+	}
+
+	results := make([]coreagg.AggregateState, 0, len(states))
+	for _, state := range states {
+		dimensions, err := coreagg.DecodeDimensions(state.Dimensions)
+		if err != nil {
+			return nil, err
 		}
-		return current
-	case coreagg.OpMax:
-		if incoming.GreaterThan(current) {
-			return incoming
+		if matchesDimensions(dimensions, filters) {
+			results = append(results, state)
+		}
+	}
+	return results, nil
+}
+
+func matchesDimensions(dimensions map[string]interface{}, filters map[string]string) bool {
+	for path, want := range filters {
+		got, ok := dimensions[path].(string)
+		if !ok || got != want {
+			return false
 		}
+	}
+	return true
+}
+
+func containsString(values []string, target string) bool {
+	for _, v := range values {
+		if v == target {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeAggregateValue merges the value fields of two partial bucket states using the
+// operator's registered Merge semantics. incoming is the more recent partial.
+func mergeAggregateValue(operator string, current, incoming coreagg.AggregateState) coreagg.AggregateState {
+	agg, ok := coreagg.Operators[operator]
+	if !ok {
+		current.Value = incoming.Value
 		return current
-	default:
-		return incoming
 	}
+	return agg.Merge(current, incoming)
+}
+
+// ParseDimensionFilters collects "dim.<path>=value" query parameters into a filter map
+// keyed by group_by path, e.g. dim.data.model=gpt-4 -> {"data.model": "gpt-4"}.
+func ParseDimensionFilters(query url.Values) (map[string]string, error) {
+	var filters map[string]string
+	for name, values := range query {
+		path, ok := strings.CutPrefix(name, dimensionParamPrefix)
+		if !ok {
+			continue
+		}
+		if path == "" {
+			return nil, invalidQueryf("dimension filter %q is missing a path", name)
+		}
+		if len(values) != 1 {
+			return nil, invalidQueryf("dimension filter %q must be given once", name)
+		}
+		if filters == nil {
+			filters = make(map[string]string)
+		}
+		filters[path] = values[0]
+	}
+	return filters, nil
+}
+
+// ParseQuantiles parses a comma-separated quantile list such as "0.5,0.99".
+// An empty string yields no quantiles; range checks happen during query validation.
+func ParseQuantiles(raw string) ([]float64, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+
+	parts := strings.Split(raw, ",")
+	quantiles := make([]float64, 0, len(parts))
+	for _, part := range parts {
+		q, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, invalidQueryf("invalid quantile: %q", part)
+		}
+		quantiles = append(quantiles, q)
+	}
+	return quantiles, nil
+}
+
+func formatQuantile(q float64) string {
+	return strconv.FormatFloat(q, 'f', -1, 64)
 }
 
 func parseBucketSize(label string) (time.Duration, error) {
@@ -491,6 +1072,13 @@ func invalidQueryf(format string, args ...interface{}) error {
 	return fmt.Errorf("%w: %s", ErrInvalidQuery, fmt.Sprintf(format, args...))
 }
 
+func asOfTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
 func minTime(a, b time.Time) time.Time {
 	if a.Before(b) {
 		return a
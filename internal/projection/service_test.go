@@ -2,6 +2,8 @@ package projection
 
 import (
 	"context"
+	"fmt"
+	"net/url"
 	"testing"
 	"time"
 
@@ -61,6 +63,26 @@ func TestService_QueryAggregates_Validation(t *testing.T) {
 				Granularity: "total",
 			},
 		},
+		{
+			name: "quantile out of range",
+			req: AggregateQueryRequest{
+				PrincipalID: "user-1",
+				Rule:        "count_requests",
+				Start:       now.Add(-time.Hour),
+				End:         now,
+				Quantiles:   []float64{1.5},
+			},
+		},
+		{
+			name: "quantiles on non-quantile rule",
+			req: AggregateQueryRequest{
+				PrincipalID: "user-1",
+				Rule:        "count_requests",
+				Start:       now.Add(-time.Hour),
+				End:         now,
+				Quantiles:   []float64{0.99},
+			},
+		},
 	}
 
 	for _, tc := range tests {
@@ -86,7 +108,7 @@ func TestService_QueryAggregates_EmptyResultSetsDataThroughToEnd(t *testing.T) {
 
 	eventStore := storagemocks.NewEventStore(t)
 	eventStore.EXPECT().
-		RetrieveScopedEventsAfterCursor(mock.Anything, int64(0), "user-1", "api.request", start, end, rawQueryBatchSize).
+		RetrieveScopedEventsAfterCursor(mock.Anything, int64(0), "user-1", "api.request", start, end, false, rawQueryBatchSize).
 		Return([]*v1.Event{}, nil).
 		Once()
 
@@ -139,7 +161,7 @@ func TestService_QueryAggregates_HybridMergesRawTail(t *testing.T) {
 
 	eventStore := storagemocks.NewEventStore(t)
 	eventStore.EXPECT().
-		RetrieveScopedEventsAfterCursor(mock.Anything, int64(100), "user-1", "api.request", start, end, rawQueryBatchSize).
+		RetrieveScopedEventsAfterCursor(mock.Anything, int64(100), "user-1", "api.request", start, end, false, rawQueryBatchSize).
 		Return([]*v1.Event{
 			{
 				ID:          "evt-11",
@@ -214,7 +236,7 @@ func TestService_QueryAggregates_UsesFixed1mBucket(t *testing.T) {
 
 	eventStore := storagemocks.NewEventStore(t)
 	eventStore.EXPECT().
-		RetrieveScopedEventsAfterCursor(mock.Anything, int64(0), "user-1", "api.request", start, end, rawQueryBatchSize).
+		RetrieveScopedEventsAfterCursor(mock.Anything, int64(0), "user-1", "api.request", start, end, false, rawQueryBatchSize).
 		Return([]*v1.Event{}, nil).
 		Once()
 
@@ -241,3 +263,837 @@ func TestService_QueryAggregates_UsesFixed1mBucket(t *testing.T) {
 	require.Equal(t, "8", resp.Values[0].Value.String())
 	require.Equal(t, int64(2), resp.Values[0].EventCount)
 }
+
+func TestService_QueryAggregates_PicksCoarsestFittingBucket(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+
+	rules := []coreagg.AggregationRule{{
+		Name:        "count_requests",
+		SourceEvent: "api.request",
+		Operator:    coreagg.OpCount,
+		WindowSize:  time.Minute,
+		BucketSizes: []time.Duration{time.Minute, time.Hour, 24 * time.Hour},
+		Fingerprint: "fp-1",
+	}}
+
+	tests := []struct {
+		name        string
+		start       time.Time
+		end         time.Time
+		granularity string
+		wantBucket  string
+	}{
+		{name: "month by day uses 1d", start: start, end: end, granularity: "1d", wantBucket: "1d"},
+		{name: "month total uses 1d", start: start, end: end, granularity: "total", wantBucket: "1d"},
+		{name: "hourly granularity uses 1h", start: start, end: end, granularity: "1h", wantBucket: "1h"},
+		{name: "unaligned range falls back to 1h", start: start.Add(time.Hour), end: end, granularity: "total", wantBucket: "1h"},
+		{name: "minute range falls back to 1m", start: start.Add(time.Minute), end: end, granularity: "1d", wantBucket: "1m"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			preAggStore := aggregationmocks.NewPreAggregateStore(t)
+			preAggStore.EXPECT().
+				QueryRange(mock.Anything, "user-1", "count_requests", tc.wantBucket, tc.start, tc.end).
+				Return([]coreagg.AggregateState{{
+					Operator:        coreagg.OpCount,
+					Value:           decimal.NewFromInt(5),
+					EventCount:      5,
+					RuleFingerprint: "fp-1",
+					WindowStart:     tc.start,
+				}}, nil).
+				Once()
+			preAggStore.EXPECT().ReadCheckpoint(mock.Anything, tc.wantBucket).Return(int64(7), nil).Once()
+
+			eventStore := storagemocks.NewEventStore(t)
+			eventStore.EXPECT().
+				RetrieveScopedEventsAfterCursor(mock.Anything, int64(7), "user-1", "api.request", tc.start, tc.end, false, rawQueryBatchSize).
+				Return([]*v1.Event{}, nil).
+				Once()
+
+			svc := NewService(preAggStore, eventStore, rules)
+			resp, err := svc.QueryAggregates(context.Background(), AggregateQueryRequest{
+				PrincipalID: "user-1",
+				Rule:        "count_requests",
+				Start:       tc.start,
+				End:         tc.end,
+				Granularity: tc.granularity,
+			})
+			require.NoError(t, err)
+
+			var total int64
+			for _, v := range resp.Values {
+				total += v.EventCount
+			}
+			require.Equal(t, int64(5), total)
+		})
+	}
+}
+
+func TestService_QueryAggregates_UnalignedRangeFoldsEdgesFromRawEvents(t *testing.T) {
+	rules := []coreagg.AggregationRule{{
+		Name:        "count_requests",
+		SourceEvent: "api.request",
+		Operator:    coreagg.OpCount,
+		WindowSize:  time.Minute,
+		Fingerprint: "fp-1",
+	}}
+	start := time.Date(2026, 1, 1, 10, 0, 30, 0, time.UTC)
+	end := time.Date(2026, 1, 1, 10, 5, 30, 0, time.UTC)
+	innerStart, innerEnd := start.Add(30*time.Second), end.Add(-30*time.Second)
+
+	// Whole minutes come from pre-aggregates and the raw tail past the checkpoint.
+	preAggStore := aggregationmocks.NewPreAggregateStore(t)
+	preAggStore.EXPECT().
+		QueryRange(mock.Anything, "user-1", "count_requests", "1m", innerStart, innerEnd).
+		Return([]coreagg.AggregateState{{
+			Operator:        coreagg.OpCount,
+			Value:           decimal.NewFromInt(5),
+			EventCount:      5,
+			RuleFingerprint: "fp-1",
+			WindowStart:     innerStart,
+		}}, nil).
+		Once()
+	preAggStore.EXPECT().ReadCheckpoint(mock.Anything, "1m").Return(int64(7), nil).Once()
+
+	// Partial minutes are folded from every raw event, flushed or not.
+	eventStore := storagemocks.NewEventStore(t)
+	eventStore.EXPECT().
+		RetrieveScopedEventsAfterCursor(mock.Anything, int64(7), "user-1", "api.request", innerStart, innerEnd, false, rawQueryBatchSize).
+		Return([]*v1.Event{}, nil).
+		Once()
+	eventStore.EXPECT().
+		RetrieveScopedEventsAfterCursor(mock.Anything, int64(0), "user-1", "api.request", start, innerStart, false, rawQueryBatchSize).
+		Return([]*v1.Event{{
+			ID:          "evt-1",
+			PrincipalID: "user-1",
+			Type:        "api.request",
+			OccurredAt:  start.Add(10 * time.Second),
+			IngestSeq:   3,
+		}}, nil).
+		Once()
+	eventStore.EXPECT().
+		RetrieveScopedEventsAfterCursor(mock.Anything, int64(0), "user-1", "api.request", innerEnd, end, false, rawQueryBatchSize).
+		Return([]*v1.Event{}, nil).
+		Once()
+
+	svc := NewService(preAggStore, eventStore, rules)
+	resp, err := svc.QueryAggregates(context.Background(), AggregateQueryRequest{
+		PrincipalID: "user-1",
+		Rule:        "count_requests",
+		Start:       start,
+		End:         end,
+	})
+	require.NoError(t, err)
+	require.Len(t, resp.Values, 1)
+	require.Equal(t, int64(6), resp.Values[0].EventCount)
+	require.Equal(t, start, resp.Values[0].WindowStart)
+}
+
+func TestService_QueryAggregates_RejectsGranularityFinerThanBucket(t *testing.T) {
+	rules := []coreagg.AggregationRule{{
+		Name:        "daily_requests",
+		SourceEvent: "api.request",
+		Operator:    coreagg.OpCount,
+		WindowSize:  24 * time.Hour,
+		BucketSizes: []time.Duration{24 * time.Hour},
+	}}
+
+	svc := NewService(aggregationmocks.NewPreAggregateStore(t), storagemocks.NewEventStore(t), rules)
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	_, err := svc.QueryAggregates(context.Background(), AggregateQueryRequest{
+		PrincipalID: "user-1",
+		Rule:        "daily_requests",
+		Start:       start,
+		End:         start.Add(24 * time.Hour),
+		Granularity: "1h",
+	})
+	require.ErrorIs(t, err, ErrInvalidQuery)
+}
+
+func TestService_QueryAggregates_QuantilesMergeDurableAndRawTail(t *testing.T) {
+	start := time.Date(2026, 2, 7, 10, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+
+	durable := coreagg.Operators[coreagg.OpQuantile].Initial(coreagg.Observation{Value: decimal.NewFromInt(10)})
+	for v := int64(11); v <= 98; v++ {
+		durable = coreagg.Operators[coreagg.OpQuantile].Apply(durable, coreagg.Observation{Value: decimal.NewFromInt(v)})
+	}
+	durable.Operator = coreagg.OpQuantile
+	durable.EventCount = 89
+	durable.WindowStart = start
+
+	preAggStore := aggregationmocks.NewPreAggregateStore(t)
+	preAggStore.EXPECT().
+		QueryRange(mock.Anything, "user-1", "latency_p", "1m", start, end).
+		Return([]coreagg.AggregateState{durable}, nil).
+		Once()
+	preAggStore.EXPECT().ReadCheckpoint(mock.Anything, "1m").Return(int64(100), nil).Once()
+
+	eventStore := storagemocks.NewEventStore(t)
+	eventStore.EXPECT().
+		RetrieveScopedEventsAfterCursor(mock.Anything, int64(100), "user-1", "api.request", start, end, false, rawQueryBatchSize).
+		Return([]*v1.Event{
+			{ID: "evt-a", PrincipalID: "user-1", Type: "api.request", OccurredAt: start.Add(5 * time.Minute), IngestSeq: 101, Data: map[string]interface{}{"latency_ms": float64(1000)}},
+			{ID: "evt-b", PrincipalID: "user-1", Type: "api.request", OccurredAt: start.Add(6 * time.Minute), IngestSeq: 102, Data: map[string]interface{}{"latency_ms": float64(1000)}},
+		}, nil).
+		Once()
+
+	rules := []coreagg.AggregationRule{{
+		Name:        "latency_p",
+		SourceEvent: "api.request",
+		Operator:    coreagg.OpQuantile,
+		Field:       "latency_ms",
+		WindowSize:  time.Minute,
+	}}
+
+	svc := NewService(preAggStore, eventStore, rules)
+	svc.nowFn = func() time.Time { return end }
+
+	resp, err := svc.QueryAggregates(context.Background(), AggregateQueryRequest{
+		PrincipalID: "user-1",
+		Rule:        "latency_p",
+		Start:       start,
+		End:         end,
+		Granularity: "total",
+		Quantiles:   []float64{0.5, 0.99},
+	})
+	require.NoError(t, err)
+	require.Len(t, resp.Values, 1)
+	require.Equal(t, int64(91), resp.Values[0].EventCount)
+
+	p50 := resp.Values[0].Quantiles["0.5"].InexactFloat64()
+	p99 := resp.Values[0].Quantiles["0.99"].InexactFloat64()
+	require.InDelta(t, 55, p50, 55*0.02)
+	require.InDelta(t, 1000, p99, 1000*0.02)
+	require.True(t, resp.Values[0].Value.Equal(resp.Values[0].Quantiles["0.5"]))
+}
+
+func TestParseQuantiles(t *testing.T) {
+	quantiles, err := ParseQuantiles(" 0.5, 0.99 ")
+	require.NoError(t, err)
+	require.Equal(t, []float64{0.5, 0.99}, quantiles)
+
+	quantiles, err = ParseQuantiles("")
+	require.NoError(t, err)
+	require.Nil(t, quantiles)
+
+	_, err = ParseQuantiles("0.5,p99")
+	require.ErrorIs(t, err, ErrInvalidQuery)
+}
+
+func TestService_QueryAggregates_RawTailAppliesRuleFilter(t *testing.T) {
+	start := time.Date(2026, 2, 7, 10, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+
+	preAggStore := aggregationmocks.NewPreAggregateStore(t)
+	preAggStore.EXPECT().
+		QueryRange(mock.Anything, "user-1", "count_errors", "1m", start, end).
+		Return([]coreagg.AggregateState(nil), nil).
+		Once()
+	preAggStore.EXPECT().ReadCheckpoint(mock.Anything, "1m").Return(int64(0), nil).Once()
+
+	eventStore := storagemocks.NewEventStore(t)
+	eventStore.EXPECT().
+		RetrieveScopedEventsAfterCursor(mock.Anything, int64(0), "user-1", "api.request", start, end, false, rawQueryBatchSize).
+		Return([]*v1.Event{
+			{ID: "evt-1", PrincipalID: "user-1", Type: "api.request", OccurredAt: start, IngestSeq: 1, Data: map[string]interface{}{"status": float64(200)}},
+			{ID: "evt-2", PrincipalID: "user-1", Type: "api.request", OccurredAt: start, IngestSeq: 2, Data: map[string]interface{}{"status": float64(500)}},
+		}, nil).
+		Once()
+
+	filter, err := coreagg.CompileFilter("data.status >= 500")
+	require.NoError(t, err)
+	rules := []coreagg.AggregationRule{{
+		Name:        "count_errors",
+		SourceEvent: "api.request",
+		Operator:    coreagg.OpCount,
+		WindowSize:  time.Minute,
+		Filter:      filter,
+	}}
+
+	svc := NewService(preAggStore, eventStore, rules)
+	svc.nowFn = func() time.Time { return end }
+
+	resp, err := svc.QueryAggregates(context.Background(), AggregateQueryRequest{
+		PrincipalID: "user-1",
+		Rule:        "count_errors",
+		Start:       start,
+		End:         end,
+	})
+	require.NoError(t, err)
+	require.Equal(t, "1", resp.Values[0].Value.String())
+	require.Equal(t, int64(1), resp.Values[0].EventCount)
+}
+
+func TestService_QueryAggregates_CorrectionPolicyMovesLateEvents(t *testing.T) {
+	start := time.Date(2026, 2, 7, 10, 0, 0, 0, time.UTC)
+	end := start.Add(2 * time.Hour)
+
+	preAggStore := aggregationmocks.NewPreAggregateStore(t)
+	preAggStore.EXPECT().
+		QueryRange(mock.Anything, "user-1", "billable_requests", "1m", start, end).
+		Return([]coreagg.AggregateState(nil), nil).
+		Once()
+	preAggStore.EXPECT().ReadCheckpoint(mock.Anything, "1m").Return(int64(0), nil).Once()
+
+	eventStore := storagemocks.NewEventStore(t)
+	eventStore.EXPECT().
+		RetrieveScopedEventsAfterCursor(mock.Anything, int64(0), "user-1", "api.request", start, end, true, rawQueryBatchSize).
+		Return([]*v1.Event{
+			// Three weeks late: counted in the bucket it arrived in.
+			{ID: "evt-late", PrincipalID: "user-1", Type: "api.request", OccurredAt: start.Add(-21 * 24 * time.Hour),
+				IngestedAt: start.Add(10 * time.Minute), IngestSeq: 1, Data: map[string]interface{}{}},
+			// Within the watermark and before the range: not part of this query.
+			{ID: "evt-before", PrincipalID: "user-1", Type: "api.request", OccurredAt: start.Add(-30 * time.Minute),
+				IngestedAt: start.Add(time.Minute), IngestSeq: 2, Data: map[string]interface{}{}},
+			{ID: "evt-on-time", PrincipalID: "user-1", Type: "api.request", OccurredAt: start.Add(20 * time.Minute),
+				IngestedAt: start.Add(21 * time.Minute), IngestSeq: 3, Data: map[string]interface{}{}},
+		}, nil).
+		Once()
+
+	rules := []coreagg.AggregationRule{{
+		Name:            "billable_requests",
+		SourceEvent:     "api.request",
+		Operator:        coreagg.OpCount,
+		WindowSize:      time.Minute,
+		AllowedLateness: time.Hour,
+		LatePolicy:      coreagg.LatePolicyCorrection,
+	}}
+
+	svc := NewService(preAggStore, eventStore, rules)
+	svc.nowFn = func() time.Time { return start.Add(90*time.Minute + 30*time.Second) }
+
+	resp, err := svc.QueryAggregates(context.Background(), AggregateQueryRequest{
+		PrincipalID: "user-1",
+		Rule:        "billable_requests",
+		Start:       start,
+		End:         end,
+		Granularity: "1m",
+	})
+	require.NoError(t, err)
+	require.Len(t, resp.Values, 2)
+	require.Equal(t, start.Add(10*time.Minute), resp.Values[0].WindowStart)
+	require.Equal(t, start.Add(20*time.Minute), resp.Values[1].WindowStart)
+
+	require.NotNil(t, resp.FinalizedThrough)
+	require.Equal(t, start.Add(30*time.Minute), *resp.FinalizedThrough)
+}
+
+func TestService_QueryAggregates_GroupByBreakdownAndFilter(t *testing.T) {
+	start := time.Date(2026, 2, 7, 10, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+	gpt4 := `{"data.model":"gpt-4"}`
+	claude := `{"data.model":"claude"}`
+
+	newService := func(t *testing.T) *Service {
+		preAggStore := aggregationmocks.NewPreAggregateStore(t)
+		preAggStore.EXPECT().
+			QueryRange(mock.Anything, "user-1", "tokens_by_model", "1m", start, end).
+			Return([]coreagg.AggregateState{
+				{WindowStart: start, Dimensions: claude, Operator: coreagg.OpSum, Value: decimal.NewFromInt(5), EventCount: 1},
+				{WindowStart: start, Dimensions: gpt4, Operator: coreagg.OpSum, Value: decimal.NewFromInt(10), EventCount: 1},
+			}, nil).
+			Once()
+		preAggStore.EXPECT().ReadCheckpoint(mock.Anything, "1m").Return(int64(2), nil).Once()
+
+		eventStore := storagemocks.NewEventStore(t)
+		eventStore.EXPECT().
+			RetrieveScopedEventsAfterCursor(mock.Anything, int64(2), "user-1", "llm.completion", start, end, false, rawQueryBatchSize).
+			Return([]*v1.Event{
+				{ID: "evt-3", PrincipalID: "user-1", Type: "llm.completion", OccurredAt: start.Add(time.Minute), IngestSeq: 3,
+					Data: map[string]interface{}{"model": "gpt-4", "tokens": float64(7)}},
+			}, nil).
+			Once()
+
+		svc := NewService(preAggStore, eventStore, []coreagg.AggregationRule{{
+			Name:        "tokens_by_model",
+			SourceEvent: "llm.completion",
+			Operator:    coreagg.OpSum,
+			Field:       "tokens",
+			WindowSize:  time.Minute,
+			GroupBy:     []string{"data.model"},
+		}})
+		svc.nowFn = func() time.Time { return end }
+		return svc
+	}
+
+	t.Run("breakdown", func(t *testing.T) {
+		resp, err := newService(t).QueryAggregates(context.Background(), AggregateQueryRequest{
+			PrincipalID: "user-1",
+			Rule:        "tokens_by_model",
+			Start:       start,
+			End:         end,
+			Breakdown:   true,
+		})
+		require.NoError(t, err)
+		require.Equal(t, "22", resp.Values[0].Value.String())
+		require.Equal(t, int64(3), resp.Values[0].EventCount)
+
+		require.Len(t, resp.Groups, 2)
+		require.Equal(t, map[string]interface{}{"data.model": "claude"}, resp.Groups[0].Dimensions)
+		require.Equal(t, "5", resp.Groups[0].Values[0].Value.String())
+		require.Equal(t, map[string]interface{}{"data.model": "gpt-4"}, resp.Groups[1].Dimensions)
+		require.Equal(t, "17", resp.Groups[1].Values[0].Value.String())
+	})
+
+	t.Run("filter", func(t *testing.T) {
+		resp, err := newService(t).QueryAggregates(context.Background(), AggregateQueryRequest{
+			PrincipalID: "user-1",
+			Rule:        "tokens_by_model",
+			Start:       start,
+			End:         end,
+			Granularity: "1m",
+			Dimensions:  map[string]string{"data.model": "gpt-4"},
+		})
+		require.NoError(t, err)
+		require.Len(t, resp.Values, 2)
+		require.Equal(t, "10", resp.Values[0].Value.String())
+		require.Equal(t, "7", resp.Values[1].Value.String())
+		require.Empty(t, resp.Groups)
+	})
+}
+
+func TestService_QueryAggregates_DimensionValidation(t *testing.T) {
+	start := time.Date(2026, 2, 7, 10, 0, 0, 0, time.UTC)
+	svc := NewService(nil, nil, []coreagg.AggregationRule{
+		{Name: "count_requests", SourceEvent: "api.request", Operator: coreagg.OpCount},
+		{Name: "tokens_by_model", SourceEvent: "llm.completion", Operator: coreagg.OpSum, Field: "tokens", GroupBy: []string{"data.model"}},
+	})
+
+	base := AggregateQueryRequest{PrincipalID: "user-1", Start: start, End: start.Add(time.Hour)}
+
+	req := base
+	req.Rule = "count_requests"
+	req.Breakdown = true
+	_, err := svc.QueryAggregates(context.Background(), req)
+	require.ErrorIs(t, err, ErrInvalidQuery)
+
+	req = base
+	req.Rule = "tokens_by_model"
+	req.Dimensions = map[string]string{"metadata.region": "eu"}
+	_, err = svc.QueryAggregates(context.Background(), req)
+	require.ErrorIs(t, err, ErrInvalidQuery)
+}
+
+func TestParseDimensionFilters(t *testing.T) {
+	filters, err := ParseDimensionFilters(url.Values{
+		"rule":            {"tokens_by_model"},
+		"dim.data.model":  {"gpt-4"},
+		"dim.metadata.az": {"eu-1"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"data.model": "gpt-4", "metadata.az": "eu-1"}, filters)
+
+	_, err = ParseDimensionFilters(url.Values{"dim.data.model": {"a", "b"}})
+	require.ErrorIs(t, err, ErrInvalidQuery)
+
+	_, err = ParseDimensionFilters(url.Values{"dim.": {"a"}})
+	require.ErrorIs(t, err, ErrInvalidQuery)
+}
+
+func TestService_SetRulesSwapsQueryableRules(t *testing.T) {
+	svc := NewService(aggregationmocks.NewPreAggregateStore(t), storagemocks.NewEventStore(t), nil)
+	require.False(t, svc.hasPreAggregationRule("count_requests"))
+
+	svc.SetRules([]coreagg.AggregationRule{{
+		Name:        "count_requests",
+		SourceEvent: "api.request",
+		Operator:    coreagg.OpCount,
+		WindowSize:  time.Minute,
+	}})
+	require.True(t, svc.hasPreAggregationRule("count_requests"))
+
+	svc.SetRules(nil)
+	require.False(t, svc.hasPreAggregationRule("count_requests"))
+}
+
+// rebuildAwarePreAggStore adds rule version reads to the generated store mock.
+type rebuildAwarePreAggStore struct {
+	*aggregationmocks.PreAggregateStore
+	versions []coreagg.RuleVersion
+}
+
+func (s rebuildAwarePreAggStore) ListRuleVersions(_ context.Context, ruleName string) ([]coreagg.RuleVersion, error) {
+	var out []coreagg.RuleVersion
+	for _, v := range s.versions {
+		if v.RuleName == ruleName {
+			out = append(out, v)
+		}
+	}
+	return out, nil
+}
+
+func TestService_QueryAggregates_SkipsRebuildingBucket(t *testing.T) {
+	start := time.Date(2026, 2, 7, 0, 0, 0, 0, time.UTC)
+	end := start.Add(24 * time.Hour)
+
+	mockStore := aggregationmocks.NewPreAggregateStore(t)
+	mockStore.EXPECT().
+		QueryRange(mock.Anything, "user-1", "count_requests", "1m", start, end).
+		Return([]coreagg.AggregateState{{Operator: coreagg.OpCount, Value: decimal.NewFromInt(3), EventCount: 3, RuleFingerprint: "fp-2", WindowStart: start}}, nil).
+		Once()
+	mockStore.EXPECT().ReadCheckpoint(mock.Anything, "1m").Return(int64(10), nil).Once()
+
+	eventStore := storagemocks.NewEventStore(t)
+	eventStore.EXPECT().
+		RetrieveScopedEventsAfterCursor(mock.Anything, int64(10), "user-1", "api.request", start, end, false, rawQueryBatchSize).
+		Return([]*v1.Event{}, nil).
+		Once()
+
+	store := rebuildAwarePreAggStore{PreAggregateStore: mockStore, versions: []coreagg.RuleVersion{
+		{RuleName: "count_requests", BucketSize: "1m", Fingerprint: "fp-2"},
+		{RuleName: "count_requests", BucketSize: "1h", Fingerprint: "fp-2", RebuildCursor: 5, RebuildTarget: 10},
+	}}
+	svc := NewService(store, eventStore, []coreagg.AggregationRule{{
+		Name:        "count_requests",
+		SourceEvent: "api.request",
+		Operator:    coreagg.OpCount,
+		WindowSize:  time.Minute,
+		BucketSizes: []time.Duration{time.Minute, time.Hour},
+		Fingerprint: "fp-2",
+	}})
+
+	resp, err := svc.QueryAggregates(context.Background(), AggregateQueryRequest{
+		PrincipalID: "user-1",
+		Rule:        "count_requests",
+		Start:       start,
+		End:         end,
+		Granularity: "1h",
+	})
+	require.NoError(t, err)
+	require.False(t, resp.Rebuilding)
+}
+
+func TestService_QueryAggregates_FallsBackToRawEventsWhileRebuilding(t *testing.T) {
+	start := time.Date(2026, 2, 7, 10, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+
+	rawEvents := []*v1.Event{
+		{ID: "evt-1", PrincipalID: "user-1", Type: "api.request", OccurredAt: start.Add(time.Minute), IngestSeq: 1, Data: map[string]interface{}{}},
+		{ID: "evt-2", PrincipalID: "user-1", Type: "api.request", OccurredAt: start.Add(2 * time.Minute), IngestSeq: 2, Data: map[string]interface{}{}},
+	}
+	rule := coreagg.AggregationRule{
+		Name:        "count_requests",
+		SourceEvent: "api.request",
+		Operator:    coreagg.OpCount,
+		WindowSize:  time.Minute,
+		Fingerprint: "fp-2",
+	}
+
+	t.Run("rebuild in progress", func(t *testing.T) {
+		eventStore := storagemocks.NewEventStore(t)
+		eventStore.EXPECT().
+			RetrieveScopedEventsAfterCursor(mock.Anything, int64(0), "user-1", "api.request", start, end, false, rawQueryBatchSize).
+			Return(rawEvents, nil).
+			Once()
+
+		store := rebuildAwarePreAggStore{PreAggregateStore: aggregationmocks.NewPreAggregateStore(t), versions: []coreagg.RuleVersion{
+			{RuleName: "count_requests", BucketSize: "1m", Fingerprint: "fp-2", RebuildCursor: 0, RebuildTarget: 100},
+		}}
+		svc := NewService(store, eventStore, []coreagg.AggregationRule{rule})
+
+		resp, err := svc.QueryAggregates(context.Background(), AggregateQueryRequest{
+			PrincipalID: "user-1", Rule: "count_requests", Start: start, End: end,
+		})
+		require.NoError(t, err)
+		require.True(t, resp.Rebuilding)
+		require.Equal(t, "2", resp.Values[0].Value.String())
+	})
+
+	t.Run("aggregates built with previous definition", func(t *testing.T) {
+		preAggStore := aggregationmocks.NewPreAggregateStore(t)
+		preAggStore.EXPECT().
+			QueryRange(mock.Anything, "user-1", "count_requests", "1m", start, end).
+			Return([]coreagg.AggregateState{{Operator: coreagg.OpCount, Value: decimal.NewFromInt(50), EventCount: 50, RuleFingerprint: "fp-1", WindowStart: start}}, nil).
+			Once()
+		preAggStore.EXPECT().ReadCheckpoint(mock.Anything, "1m").Return(int64(2), nil).Once()
+
+		eventStore := storagemocks.NewEventStore(t)
+		eventStore.EXPECT().
+			RetrieveScopedEventsAfterCursor(mock.Anything, int64(0), "user-1", "api.request", start, end, false, rawQueryBatchSize).
+			Return(rawEvents, nil).
+			Once()
+
+		svc := NewService(preAggStore, eventStore, []coreagg.AggregationRule{rule})
+		resp, err := svc.QueryAggregates(context.Background(), AggregateQueryRequest{
+			PrincipalID: "user-1", Rule: "count_requests", Start: start, End: end,
+		})
+		require.NoError(t, err)
+		require.True(t, resp.Rebuilding)
+		require.Equal(t, "2", resp.Values[0].Value.String())
+	})
+}
+
+// pagedEventStore serves total events of one principal and type, occurring at start, in
+// pages after the requested cursor, and counts the pages read.
+type pagedEventStore struct {
+	*storagemocks.EventStore
+	start time.Time
+	total int64
+	pages int
+}
+
+func (s *pagedEventStore) RetrieveScopedEventsAfterCursor(
+	_ context.Context, cursor int64, principalID, eventType string, _, _ time.Time, _ bool, limit int,
+) ([]*v1.Event, error) {
+	s.pages++
+	var events []*v1.Event
+	for seq := cursor + 1; seq <= s.total && len(events) < limit; seq++ {
+		events = append(events, &v1.Event{
+			ID:          fmt.Sprintf("evt-%d", seq),
+			PrincipalID: principalID,
+			Type:        eventType,
+			OccurredAt:  s.start,
+			IngestSeq:   seq,
+			Data:        map[string]interface{}{},
+		})
+	}
+	return events, nil
+}
+
+func TestService_QueryAggregates_RebuildReplayIsNotCapped(t *testing.T) {
+	start := time.Date(2026, 2, 7, 10, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+	total := int64(maxRawQueryIterations*rawQueryBatchSize + 1)
+	rule := coreagg.AggregationRule{
+		Name:        "count_requests",
+		SourceEvent: "api.request",
+		Operator:    coreagg.OpCount,
+		WindowSize:  time.Minute,
+		Fingerprint: "fp-2",
+	}
+
+	store := rebuildAwarePreAggStore{PreAggregateStore: aggregationmocks.NewPreAggregateStore(t), versions: []coreagg.RuleVersion{
+		{RuleName: "count_requests", BucketSize: "1m", Fingerprint: "fp-2", RebuildCursor: 0, RebuildTarget: total},
+	}}
+	eventStore := &pagedEventStore{EventStore: storagemocks.NewEventStore(t), start: start, total: total}
+	svc := NewService(store, eventStore, []coreagg.AggregationRule{rule})
+
+	resp, err := svc.QueryAggregates(context.Background(), AggregateQueryRequest{
+		PrincipalID: "user-1", Rule: "count_requests", Start: start, End: end,
+	})
+	require.NoError(t, err)
+	require.True(t, resp.Rebuilding)
+	require.Equal(t, total, resp.Values[0].EventCount)
+	require.Greater(t, eventStore.pages, maxRawQueryIterations)
+}
+
+// edgeCursorEventStore adds the optional IngestCursorReader to pagedEventStore and
+// records the ingestion times looked up.
+type edgeCursorEventStore struct {
+	*pagedEventStore
+	firstAfter int64
+	lookups    []time.Time
+}
+
+func (s *edgeCursorEventStore) FirstIngestSeqAfter(_ context.Context, _ string, t time.Time) (int64, error) {
+	s.lookups = append(s.lookups, t)
+	return s.firstAfter, nil
+}
+
+func TestService_QueryAggregates_EdgeScanStartsAtEdge(t *testing.T) {
+	bucket := time.Date(2026, 2, 7, 10, 0, 0, 0, time.UTC)
+	start, end := bucket.Add(10*time.Second), bucket.Add(40*time.Second)
+	rule := coreagg.AggregationRule{
+		Name:        "count_requests",
+		SourceEvent: "api.request",
+		Operator:    coreagg.OpCount,
+		WindowSize:  time.Minute,
+	}
+	query := func(eventStore *edgeCursorEventStore) (*AggregateQueryResponse, error) {
+		svc := NewService(aggregationmocks.NewPreAggregateStore(t), eventStore, []coreagg.AggregationRule{rule})
+		return svc.QueryAggregates(context.Background(), AggregateQueryRequest{
+			PrincipalID: "user-1", Rule: "count_requests", Start: start, End: end,
+		})
+	}
+
+	t.Run("scan starts at the first event ingested near the edge", func(t *testing.T) {
+		eventStore := &edgeCursorEventStore{
+			pagedEventStore: &pagedEventStore{EventStore: storagemocks.NewEventStore(t), start: start.Add(time.Second), total: 100},
+			firstAfter:      91,
+		}
+		resp, err := query(eventStore)
+		require.NoError(t, err)
+		require.Equal(t, []time.Time{start.Add(-edgeClockSkew)}, eventStore.lookups)
+		require.Equal(t, int64(10), resp.Values[0].EventCount)
+	})
+
+	t.Run("nothing ingested since the edge", func(t *testing.T) {
+		eventStore := &edgeCursorEventStore{
+			pagedEventStore: &pagedEventStore{EventStore: storagemocks.NewEventStore(t), start: start.Add(time.Second), total: 100},
+		}
+		resp, err := query(eventStore)
+		require.NoError(t, err)
+		require.Zero(t, eventStore.pages)
+		require.Zero(t, resp.Values[0].EventCount)
+	})
+
+	t.Run("edge scan is capped", func(t *testing.T) {
+		eventStore := &edgeCursorEventStore{
+			pagedEventStore: &pagedEventStore{
+				EventStore: storagemocks.NewEventStore(t),
+				start:      start.Add(time.Second),
+				total:      int64(maxRawQueryIterations*rawQueryBatchSize + 1),
+			},
+			firstAfter: 1,
+		}
+		_, err := query(eventStore)
+		require.ErrorContains(t, err, "exceeded maximum iterations")
+		require.Equal(t, maxRawQueryIterations, eventStore.pages)
+	})
+}
+
+// ingestCursorEventStore adds the optional IngestCursorReader to the generated mock.
+type ingestCursorEventStore struct {
+	*storagemocks.EventStore
+	firstAfter int64
+}
+
+func (s ingestCursorEventStore) FirstIngestSeqAfter(_ context.Context, _ string, _ time.Time) (int64, error) {
+	return s.firstAfter, nil
+}
+
+func TestService_QueryAggregates_AsOf(t *testing.T) {
+	start := time.Date(2026, 2, 7, 10, 0, 0, 0, time.UTC)
+	end := start.Add(10 * time.Minute)
+	asOf := start.Add(20 * time.Minute)
+
+	durable := []coreagg.AggregateState{{
+		Operator:    coreagg.OpCount,
+		Value:       decimal.NewFromInt(10),
+		EventCount:  10,
+		WindowStart: start,
+	}}
+	// Seq 101 was ingested before as_of, seq 102 and 103 after it.
+	tail := []*v1.Event{
+		{ID: "evt-11", PrincipalID: "user-1", Type: "api.request", OccurredAt: start, IngestedAt: asOf.Add(-time.Minute), IngestSeq: 101, Data: map[string]interface{}{}},
+		{ID: "evt-12", PrincipalID: "user-1", Type: "api.request", OccurredAt: start, IngestedAt: asOf.Add(time.Minute), IngestSeq: 102, Data: map[string]interface{}{}},
+		{ID: "evt-13", PrincipalID: "user-1", Type: "api.request", OccurredAt: start, IngestedAt: asOf.Add(2 * time.Minute), IngestSeq: 103, Data: map[string]interface{}{}},
+	}
+	rules := []coreagg.AggregationRule{{
+		Name:        "count_requests",
+		SourceEvent: "api.request",
+		Operator:    coreagg.OpCount,
+		WindowSize:  time.Minute,
+	}}
+	query := func(svc *Service, asOfTime time.Time, asOfCursor int64) *AggregateQueryResponse {
+		resp, err := svc.QueryAggregates(context.Background(), AggregateQueryRequest{
+			PrincipalID: "user-1",
+			Rule:        "count_requests",
+			Start:       start,
+			End:         end,
+			AsOf:        asOfTime,
+			AsOfCursor:  asOfCursor,
+		})
+		require.NoError(t, err)
+		return resp
+	}
+
+	t.Run("pre-aggregates before the cursor are used", func(t *testing.T) {
+		preAggStore := aggregationmocks.NewPreAggregateStore(t)
+		preAggStore.EXPECT().QueryRange(mock.Anything, "user-1", "count_requests", "1m", start, end).Return(durable, nil).Once()
+		preAggStore.EXPECT().ReadCheckpoint(mock.Anything, "1m").Return(int64(100), nil).Once()
+		eventStore := storagemocks.NewEventStore(t)
+		eventStore.EXPECT().
+			RetrieveScopedEventsAfterCursor(mock.Anything, int64(100), "user-1", "api.request", start, end, false, rawQueryBatchSize).
+			Return(tail, nil).
+			Once()
+
+		svc := NewService(preAggStore, eventStore, rules)
+		resp := query(svc, time.Time{}, 102)
+		require.Equal(t, "12", resp.Values[0].Value.String())
+		require.Equal(t, int64(102), resp.AsOfCursor)
+		require.False(t, resp.Replayed)
+	})
+
+	t.Run("checkpoint past the cursor retracts later events", func(t *testing.T) {
+		flushed := []coreagg.AggregateState{{
+			Operator:    coreagg.OpCount,
+			Value:       decimal.NewFromInt(13),
+			EventCount:  13,
+			WindowStart: start,
+		}}
+		preAggStore := aggregationmocks.NewPreAggregateStore(t)
+		preAggStore.EXPECT().QueryRange(mock.Anything, "user-1", "count_requests", "1m", start, end).Return(flushed, nil).Once()
+		preAggStore.EXPECT().ReadCheckpoint(mock.Anything, "1m").Return(int64(103), nil).Once()
+		eventStore := storagemocks.NewEventStore(t)
+		eventStore.EXPECT().
+			RetrieveScopedEventsAfterCursor(mock.Anything, int64(101), "user-1", "api.request", start, end, false, rawQueryBatchSize).
+			Return(tail[1:], nil).
+			Once()
+
+		svc := NewService(preAggStore, eventStore, rules)
+		resp := query(svc, time.Time{}, 101)
+		require.Equal(t, "11", resp.Values[0].Value.String())
+		require.False(t, resp.Replayed)
+	})
+
+	t.Run("checkpoint past the cursor replays operators that cannot retract", func(t *testing.T) {
+		maxRules := []coreagg.AggregationRule{{
+			Name:        "count_requests",
+			SourceEvent: "api.request",
+			Operator:    coreagg.OpMax,
+			Field:       "n",
+			WindowSize:  time.Minute,
+		}}
+		maxTail := make([]*v1.Event, len(tail))
+		for i, evt := range tail {
+			withValue := *evt
+			withValue.Data = map[string]interface{}{"n": float64(i + 1)}
+			maxTail[i] = &withValue
+		}
+		flushed := []coreagg.AggregateState{{
+			Operator:    coreagg.OpMax,
+			Value:       decimal.NewFromInt(3),
+			EventCount:  3,
+			WindowStart: start,
+		}}
+		preAggStore := aggregationmocks.NewPreAggregateStore(t)
+		preAggStore.EXPECT().QueryRange(mock.Anything, "user-1", "count_requests", "1m", start, end).Return(flushed, nil).Once()
+		preAggStore.EXPECT().ReadCheckpoint(mock.Anything, "1m").Return(int64(103), nil).Once()
+		eventStore := storagemocks.NewEventStore(t)
+		eventStore.EXPECT().
+			RetrieveScopedEventsAfterCursor(mock.Anything, int64(0), "user-1", "api.request", start, end, false, rawQueryBatchSize).
+			Return(maxTail, nil).
+			Once()
+
+		svc := NewService(preAggStore, eventStore, maxRules)
+		resp := query(svc, time.Time{}, 101)
+		require.Equal(t, "1", resp.Values[0].Value.String())
+		require.True(t, resp.Replayed)
+	})
+
+	t.Run("as_of replays page past the tail scan limit", func(t *testing.T) {
+		preAggStore := aggregationmocks.NewPreAggregateStore(t)
+		preAggStore.EXPECT().QueryRange(mock.Anything, "user-1", "count_requests", "1m", start, end).Return(durable, nil).Once()
+		preAggStore.EXPECT().ReadCheckpoint(mock.Anything, "1m").Return(int64(100), nil).Once()
+		total := int64(100 + maxRawQueryIterations*rawQueryBatchSize + 1)
+		eventStore := &pagedEventStore{EventStore: storagemocks.NewEventStore(t), start: start, total: total}
+
+		svc := NewService(preAggStore, eventStore, rules)
+		resp := query(svc, time.Time{}, total)
+		require.Equal(t, decimal.NewFromInt(total-90).String(), resp.Values[0].Value.String())
+		require.Greater(t, eventStore.pages, maxRawQueryIterations)
+	})
+
+	t.Run("as_of time bounds pre-aggregates by the first later event", func(t *testing.T) {
+		preAggStore := aggregationmocks.NewPreAggregateStore(t)
+		preAggStore.EXPECT().QueryRange(mock.Anything, "user-1", "count_requests", "1m", start, end).Return(durable, nil).Once()
+		preAggStore.EXPECT().ReadCheckpoint(mock.Anything, "1m").Return(int64(100), nil).Once()
+		eventStore := ingestCursorEventStore{EventStore: storagemocks.NewEventStore(t), firstAfter: 102}
+		eventStore.EXPECT().
+			RetrieveScopedEventsAfterCursor(mock.Anything, int64(100), "user-1", "api.request", start, end, false, rawQueryBatchSize).
+			Return(tail, nil).
+			Once()
+
+		svc := NewService(preAggStore, eventStore, rules)
+		svc.nowFn = func() time.Time { return asOf.Add(time.Hour) }
+		resp := query(svc, asOf, 0)
+		require.Equal(t, "11", resp.Values[0].Value.String())
+		require.False(t, resp.Replayed)
+		require.NotNil(t, resp.AsOf)
+		require.Equal(t, asOf, *resp.AsOf)
+	})
+}
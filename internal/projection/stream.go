@@ -0,0 +1,375 @@
+package projection
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "github.com/aevon-lab/project-aevon/internal/api/v1"
+	coreagg "github.com/aevon-lab/project-aevon/internal/core/aggregation"
+	httperr "github.com/aevon-lab/project-aevon/internal/core/errors"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maxStreamRules = 20
+
+	// Server-sent event names.
+	streamEventState = "state"
+	streamEventError = "error"
+)
+
+// StateStreamRequest subscribes to the state of several rules of one principal.
+type StateStreamRequest struct {
+	PrincipalID string
+	Rules       []string
+	Period      string // as for quota checks; default: current_day
+	Timezone    string
+	Granularity string // default: total
+}
+
+// streamHub routes change notifications to the state streams of a principal. Changes
+// are only marked; each stream re-reads the state of its changed rules at most once
+// per coalesce window.
+type streamHub struct {
+	coalesce  time.Duration // bursts of changes within this window cause one push
+	refresh   time.Duration // every rule is re-read at least this often, for changes made by other replicas
+	heartbeat time.Duration // keeps idle connections open through proxies
+
+	mu   sync.Mutex
+	subs map[string]map[*stateSubscription]struct{} // by principal ID
+
+	closeOnce sync.Once
+	closed    chan struct{} // closed on server shutdown; ends every stream
+}
+
+type stateSubscription struct {
+	principalID string
+	rules       map[string]struct{}
+	notify      chan struct{} // capacity 1: a pending signal covers any number of changes
+
+	mu    sync.Mutex
+	dirty map[string]struct{}
+}
+
+func newStreamHub() *streamHub {
+	return &streamHub{
+		coalesce:  time.Second,
+		refresh:   30 * time.Second,
+		heartbeat: 15 * time.Second,
+		subs:      make(map[string]map[*stateSubscription]struct{}),
+		closed:    make(chan struct{}),
+	}
+}
+
+// close ends every stream, open or opened later.
+func (h *streamHub) close() {
+	h.closeOnce.Do(func() { close(h.closed) })
+}
+
+func (h *streamHub) subscribe(principalID string, rules []string) *stateSubscription {
+	sub := &stateSubscription{
+		principalID: principalID,
+		rules:       make(map[string]struct{}, len(rules)),
+		notify:      make(chan struct{}, 1),
+		dirty:       make(map[string]struct{}, len(rules)),
+	}
+	for _, rule := range rules {
+		sub.rules[rule] = struct{}{}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.subs[principalID] == nil {
+		h.subs[principalID] = make(map[*stateSubscription]struct{})
+	}
+	h.subs[principalID][sub] = struct{}{}
+	return sub
+}
+
+func (h *streamHub) unsubscribe(sub *stateSubscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subs[sub.principalID], sub)
+	if len(h.subs[sub.principalID]) == 0 {
+		delete(h.subs, sub.principalID)
+	}
+}
+
+func (h *streamHub) hasSubscribers(principalID string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subs[principalID]) > 0
+}
+
+// publish marks rule as changed for every stream of principalID subscribed to it.
+func (h *streamHub) publish(principalID string, rule string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subs[principalID] {
+		if _, ok := sub.rules[rule]; ok {
+			sub.markDirty(rule)
+		}
+	}
+}
+
+func (s *stateSubscription) markDirty(rule string) {
+	s.mu.Lock()
+	s.dirty[rule] = struct{}{}
+	s.mu.Unlock()
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// takeDirty returns the changed rules and resets them.
+func (s *stateSubscription) takeDirty() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rules := sortedKeys(s.dirty)
+	s.dirty = make(map[string]struct{}, len(s.rules))
+	return rules
+}
+
+// CloseStreams ends every state stream. http.Server.Shutdown does not cancel the
+// contexts of running requests, so it is registered as a shutdown hook; without it an
+// open stream would hold shutdown until its timeout.
+func (s *Service) CloseStreams() {
+	s.streams.close()
+}
+
+// AfterFlush marks the flushed rules of every streamed principal as changed.
+// It implements aggregation.FlushObserver.
+func (s *Service) AfterFlush(_ context.Context, _ string, scopes []coreagg.RangeScope) {
+	for _, scope := range scopes {
+		s.streams.publish(scope.PrincipalID, scope.RuleName)
+	}
+}
+
+// EventsIngested marks the rules sourcing the ingested events as changed for streamed
+// principals. It implements ingestion.EventObserver.
+func (s *Service) EventsIngested(events []*v1.Event) {
+	var rules map[string]coreagg.AggregationRule
+	for _, evt := range events {
+		if !s.streams.hasSubscribers(evt.PrincipalID) {
+			continue
+		}
+		if rules == nil {
+			s.mu.RLock()
+			rules = s.rules
+			s.mu.RUnlock()
+		}
+		for _, rule := range rules {
+			if rule.SourceEvent == evt.Type {
+				s.streams.publish(evt.PrincipalID, rule.Name)
+			}
+		}
+	}
+}
+
+// HandleStateStream handles GET /v1/state/:principal_id/stream
+// Query parameters: rule (repeatable or comma separated), period, timezone, granularity
+//
+// The response is a server-sent event stream. A "state" event carries the
+// AggregateQueryResponse of one rule: first for every rule, then whenever a scheduler
+// flush or an ingested event changed its values.
+func (s *Service) HandleStateStream(c *gin.Context) {
+	var rules []string
+	for _, value := range c.QueryArray("rule") {
+		rules = append(rules, strings.Split(value, ",")...)
+	}
+	req, err := s.normalizeStreamRequest(StateStreamRequest{
+		PrincipalID: c.Param("principal_id"),
+		Rules:       rules,
+		Period:      c.Query("period"),
+		Timezone:    c.Query("timezone"),
+		Granularity: c.Query("granularity"),
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, httperr.ErrorResponse{
+			ErrorType: httperr.HttpInvalidJsonError,
+			Message:   "Invalid state stream",
+			Details:   err.Error(),
+		})
+		return
+	}
+
+	ctx := c.Request.Context()
+	sub := s.streams.subscribe(req.PrincipalID, req.Rules)
+	defer s.streams.unsubscribe(sub)
+
+	// The initial snapshot is read after subscribing, so no change falls in between.
+	initial := make([]*AggregateQueryResponse, 0, len(req.Rules))
+	for _, rule := range req.Rules {
+		state, err := s.streamState(ctx, req, rule)
+		if err != nil {
+			if errors.Is(err, ErrInvalidQuery) {
+				c.JSON(http.StatusBadRequest, httperr.ErrorResponse{
+					ErrorType: httperr.HttpInvalidJsonError,
+					Message:   "Invalid state stream",
+					Details:   err.Error(),
+				})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, httperr.ErrorResponse{
+				ErrorType: httperr.HttpInternalError,
+				Message:   "Failed to read state",
+				Details:   err.Error(),
+			})
+			return
+		}
+		initial = append(initial, state)
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // disable proxy buffering
+	c.Status(http.StatusOK)
+
+	last := make(map[string][]byte, len(req.Rules))
+	for _, state := range initial {
+		if err := s.pushState(c, state, last); err != nil {
+			return
+		}
+	}
+	c.Writer.Flush()
+
+	refresh := time.NewTicker(s.streams.refresh)
+	defer refresh.Stop()
+	heartbeat := time.NewTicker(s.streams.heartbeat)
+	defer heartbeat.Stop()
+
+	var coalesced <-chan time.Time // non-nil while changes are pending
+	for {
+		var changed []string
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.streams.closed:
+			return
+		case <-sub.notify:
+			if coalesced == nil {
+				coalesced = time.After(s.streams.coalesce)
+			}
+			continue
+		case <-coalesced:
+			coalesced = nil
+			changed = sub.takeDirty()
+		case <-refresh.C:
+			changed = req.Rules
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(c.Writer, ": keepalive\n\n"); err != nil {
+				return
+			}
+			c.Writer.Flush()
+			continue
+		}
+
+		for _, rule := range changed {
+			state, err := s.streamState(ctx, req, rule)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				slog.Warn("State stream read failed", "principal_id", req.PrincipalID, "rule", rule, "error", err)
+				data, _ := json.Marshal(httperr.ErrorResponse{
+					ErrorType: httperr.HttpInternalError,
+					Message:   "Failed to read state of rule " + rule,
+					Details:   err.Error(),
+				})
+				if err := writeServerSentEvent(c.Writer, streamEventError, data); err != nil {
+					return
+				}
+				continue
+			}
+			if err := s.pushState(c, state, last); err != nil {
+				return
+			}
+		}
+		c.Writer.Flush()
+	}
+}
+
+func (s *Service) normalizeStreamRequest(req StateStreamRequest) (StateStreamRequest, error) {
+	if req.Period == "" {
+		req.Period = PeriodCurrentDay
+	}
+	if req.Granularity == "" {
+		req.Granularity = "total"
+	}
+
+	rules, err := uniqueNonEmpty(req.Rules, "rule")
+	if err != nil {
+		return req, err
+	}
+	if len(rules) > maxStreamRules {
+		return req, invalidQueryf("too many rules: %d (at most %d)", len(rules), maxStreamRules)
+	}
+	for _, name := range rules {
+		rule, ok := s.rule(name)
+		if !ok {
+			return req, invalidQueryf("unknown rule: %s", name)
+		}
+		if _, _, err := ResolvePeriod(req.Period, req.Timezone, rule.Buckets()[0], s.nowFn()); err != nil {
+			return req, fmt.Errorf("rule %s: %w", name, err)
+		}
+	}
+	req.Rules = rules
+	return req, nil
+}
+
+// streamState reads the state of one streamed rule over the period as of now.
+func (s *Service) streamState(ctx context.Context, req StateStreamRequest, rule string) (*AggregateQueryResponse, error) {
+	definition, ok := s.rule(rule)
+	if !ok {
+		return nil, invalidQueryf("unknown rule: %s", rule)
+	}
+	start, end, err := ResolvePeriod(req.Period, req.Timezone, definition.Buckets()[0], s.nowFn())
+	if err != nil {
+		return nil, err
+	}
+
+	queryCtx, cancel := context.WithTimeout(ctx, s.queryTimeout)
+	defer cancel()
+	return s.QueryAggregates(queryCtx, AggregateQueryRequest{
+		PrincipalID: req.PrincipalID,
+		Rule:        rule,
+		Start:       start,
+		End:         end,
+		Granularity: req.Granularity,
+	})
+}
+
+// pushState writes state as a "state" event unless its values equal the last ones sent
+// for the rule.
+func (s *Service) pushState(c *gin.Context, state *AggregateQueryResponse, last map[string][]byte) error {
+	values, err := json.Marshal(state.Values)
+	if err != nil {
+		return err
+	}
+	if previous, ok := last[state.Rule]; ok && bytes.Equal(previous, values) {
+		return nil
+	}
+	last[state.Rule] = values
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return writeServerSentEvent(c.Writer, streamEventState, data)
+}
+
+func writeServerSentEvent(w gin.ResponseWriter, event string, data []byte) error {
+	_, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	return err
+}
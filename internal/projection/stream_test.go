@@ -0,0 +1,198 @@
+package projection
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "github.com/aevon-lab/project-aevon/internal/api/v1"
+	coreagg "github.com/aevon-lab/project-aevon/internal/core/aggregation"
+	aggregationmocks "github.com/aevon-lab/project-aevon/internal/mocks/aggregation"
+	storagemocks "github.com/aevon-lab/project-aevon/internal/mocks/storage"
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+var streamTestRules = []coreagg.AggregationRule{
+	{Name: "sum_tokens", SourceEvent: "llm.call", Operator: coreagg.OpSum, Field: "tokens", WindowSize: time.Minute},
+	{Name: "count_calls", SourceEvent: "llm.call", Operator: coreagg.OpCount, WindowSize: time.Minute},
+	{Name: "count_logins", SourceEvent: "user.login", Operator: coreagg.OpCount, WindowSize: time.Minute},
+}
+
+func TestStreamHub_CoalescesChanges(t *testing.T) {
+	hub := newStreamHub()
+	sub := hub.subscribe("user-1", []string{"sum_tokens", "count_calls"})
+
+	hub.publish("user-1", "sum_tokens")
+	hub.publish("user-1", "sum_tokens")
+	hub.publish("user-1", "count_calls")
+	hub.publish("user-1", "count_logins") // not subscribed
+	hub.publish("user-2", "sum_tokens")   // other principal
+
+	// One pending signal for any number of changes.
+	require.Len(t, sub.notify, 1)
+	require.Equal(t, []string{"count_calls", "sum_tokens"}, sub.takeDirty())
+	require.Empty(t, sub.takeDirty())
+
+	hub.unsubscribe(sub)
+	require.False(t, hub.hasSubscribers("user-1"))
+}
+
+func TestService_EventsIngested_MarksSourcedRules(t *testing.T) {
+	svc := NewService(aggregationmocks.NewPreAggregateStore(t), storagemocks.NewEventStore(t), streamTestRules)
+	sub := svc.streams.subscribe("user-1", []string{"sum_tokens", "count_logins"})
+
+	svc.EventsIngested([]*v1.Event{
+		{PrincipalID: "user-1", Type: "llm.call"},
+		{PrincipalID: "user-2", Type: "user.login"},
+	})
+	require.Equal(t, []string{"sum_tokens"}, sub.takeDirty())
+
+	svc.AfterFlush(context.Background(), "1m", []coreagg.RangeScope{
+		{PrincipalID: "user-1", RuleName: "count_logins"},
+		{PrincipalID: "user-1", RuleName: "count_calls"},
+	})
+	require.Equal(t, []string{"count_logins"}, sub.takeDirty())
+}
+
+// readStateEvent returns the data of the next "state" event of an SSE stream.
+func readStateEvent(t *testing.T, scanner *bufio.Scanner) AggregateQueryResponse {
+	t.Helper()
+	event := ""
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: ") && event == streamEventState:
+			var state AggregateQueryResponse
+			require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &state))
+			return state
+		}
+	}
+	require.NoError(t, scanner.Err())
+	t.Fatal("stream closed before a state event")
+	return AggregateQueryResponse{}
+}
+
+func TestService_HandleStateStream(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	now := time.Date(2026, 2, 7, 11, 0, 30, 0, time.UTC)
+	state := func(value int64) []coreagg.AggregateState {
+		return []coreagg.AggregateState{{
+			Operator:    coreagg.OpSum,
+			Value:       decimal.NewFromInt(value),
+			EventCount:  1,
+			WindowStart: now.Truncate(time.Minute).Add(-time.Minute),
+		}}
+	}
+
+	preAggStore := aggregationmocks.NewPreAggregateStore(t)
+	preAggStore.EXPECT().
+		QueryRange(mock.Anything, "user-1", "sum_tokens", mock.Anything, mock.Anything, mock.Anything).
+		Return(state(800), nil).
+		Once()
+	preAggStore.EXPECT().
+		QueryRange(mock.Anything, "user-1", "sum_tokens", mock.Anything, mock.Anything, mock.Anything).
+		Return(state(900), nil)
+	preAggStore.EXPECT().ReadCheckpoint(mock.Anything, mock.Anything).Return(int64(10), nil)
+
+	eventStore := storagemocks.NewEventStore(t)
+	eventStore.EXPECT().
+		RetrieveScopedEventsAfterCursor(mock.Anything, int64(10), "user-1", "llm.call", mock.Anything, mock.Anything, false, rawQueryBatchSize).
+		Return([]*v1.Event{}, nil)
+
+	svc := NewService(preAggStore, eventStore, streamTestRules)
+	svc.nowFn = func() time.Time { return now }
+	svc.streams.coalesce = 10 * time.Millisecond
+	svc.streams.refresh = time.Hour
+	svc.streams.heartbeat = time.Hour
+
+	r := gin.New()
+	svc.RegisterRoutes(r)
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/v1/state/user-1/stream?rule=sum_tokens", nil)
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	scanner := bufio.NewScanner(resp.Body)
+	initial := readStateEvent(t, scanner)
+	require.Equal(t, "sum_tokens", initial.Rule)
+	require.Equal(t, "total", initial.Granularity)
+	require.Len(t, initial.Values, 1)
+	require.Equal(t, "800", initial.Values[0].Value.String())
+
+	svc.EventsIngested([]*v1.Event{{PrincipalID: "user-1", Type: "llm.call"}})
+	updated := readStateEvent(t, scanner)
+	require.Equal(t, "900", updated.Values[0].Value.String())
+}
+
+func TestService_HandleStateStream_Validation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	svc := NewService(aggregationmocks.NewPreAggregateStore(t), storagemocks.NewEventStore(t), streamTestRules)
+	r := gin.New()
+	svc.RegisterRoutes(r)
+
+	for _, query := range []string{"", "?rule=missing_rule", "?rule=sum_tokens&period=fortnight"} {
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/v1/state/user-1/stream"+query, nil))
+		require.Equal(t, http.StatusBadRequest, resp.Code, query)
+	}
+}
+
+func TestService_HandleStateStream_EndsOnServerShutdown(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	preAggStore := aggregationmocks.NewPreAggregateStore(t)
+	preAggStore.EXPECT().
+		QueryRange(mock.Anything, "user-1", "sum_tokens", mock.Anything, mock.Anything, mock.Anything).
+		Return([]coreagg.AggregateState{}, nil)
+	preAggStore.EXPECT().ReadCheckpoint(mock.Anything, mock.Anything).Return(int64(10), nil)
+
+	eventStore := storagemocks.NewEventStore(t)
+	eventStore.EXPECT().
+		RetrieveScopedEventsAfterCursor(mock.Anything, int64(10), "user-1", "llm.call", mock.Anything, mock.Anything, false, rawQueryBatchSize).
+		Return([]*v1.Event{}, nil)
+
+	svc := NewService(preAggStore, eventStore, streamTestRules)
+	svc.streams.refresh = time.Hour
+	svc.streams.heartbeat = time.Hour
+
+	r := gin.New()
+	svc.RegisterRoutes(r)
+	server := httptest.NewUnstartedServer(r)
+	server.Config.RegisterOnShutdown(svc.CloseStreams)
+	server.Start()
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/v1/state/user-1/stream?rule=sum_tokens")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	scanner := bufio.NewScanner(resp.Body)
+	readStateEvent(t, scanner)
+
+	// Shutdown waits for running requests; the open stream must end well before the timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	require.NoError(t, server.Config.Shutdown(ctx))
+	for scanner.Scan() {
+	}
+	require.NoError(t, scanner.Err())
+}
@@ -0,0 +1,517 @@
+package projection
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"time"
+
+	v1 "github.com/aevon-lab/project-aevon/internal/api/v1"
+	coreagg "github.com/aevon-lab/project-aevon/internal/core/aggregation"
+	httperr "github.com/aevon-lab/project-aevon/internal/core/errors"
+	"github.com/aevon-lab/project-aevon/internal/core/partition"
+	"github.com/aevon-lab/project-aevon/internal/core/storage"
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+)
+
+// Leaderboard orders.
+const (
+	TopOrderDesc = "desc"
+	TopOrderAsc  = "asc"
+)
+
+const (
+	defaultTopLimit = 10
+	maxTopLimit     = 1000
+)
+
+// ErrTopUnsupported is returned when the configured stores cannot read a rule across
+// all principals.
+var ErrTopUnsupported = errors.New("leaderboard queries are not supported by the configured stores")
+
+// TopQueryRequest ranks the principals of one rule by their value over a range.
+type TopQueryRequest struct {
+	Rule  string
+	Start time.Time
+	End   time.Time
+	Limit int    // default: 10, at most 1000
+	Order string // desc (default) or asc
+}
+
+// TopPrincipal is one entry of a leaderboard.
+type TopPrincipal struct {
+	PrincipalID string          `json:"principal_id"`
+	Value       decimal.Decimal `json:"value"`
+	EventCount  int64           `json:"event_count"`
+}
+
+// TopQueryResponse is the response of GET /v1/rules/{rule}/top.
+type TopQueryResponse struct {
+	Rule       string         `json:"rule"`
+	Operator   string         `json:"operator"`
+	Start      time.Time      `json:"start"`
+	End        time.Time      `json:"end"`
+	Order      string         `json:"order"`
+	Limit      int            `json:"limit"`
+	Principals []TopPrincipal `json:"principals"`
+	// Rebuilding is set when the rule's pre-aggregates are being rebuilt after a rule
+	// change; values were then computed from raw events.
+	Rebuilding bool `json:"rebuilding,omitempty"`
+}
+
+// ruleRangeScanner reads one rule's pre-aggregates of every principal together with
+// the checkpoint of every partition, from one statement snapshot.
+type ruleRangeScanner interface {
+	ScanRuleRangeWithCheckpoints(
+		ctx context.Context,
+		ruleName string,
+		bucketSize string,
+		startTime time.Time,
+		endTime time.Time,
+		fn func(principalID string, state coreagg.AggregateState),
+	) (coreagg.PartitionCheckpoints, error)
+}
+
+// ruleRangeRanker ranks one rule's principals by their pre-aggregated total in the
+// store, so only the totals of the top principals leave it. It is used for operators
+// whose states merge in SQL; sketch operators are folded from a ruleRangeScanner.
+type ruleRangeRanker interface {
+	ReadPartitionCheckpoints(ctx context.Context, bucketSize string, partitions partition.Range) (coreagg.PartitionCheckpoints, error)
+	RankRuleRangeWithCheckpoints(ctx context.Context, query coreagg.RankQuery) (coreagg.Ranking, error)
+}
+
+// rankableOperators are the operators a ruleRangeRanker can rank.
+var rankableOperators = map[string]bool{
+	coreagg.OpCount: true,
+	coreagg.OpSum:   true,
+	coreagg.OpMin:   true,
+	coreagg.OpMax:   true,
+	coreagg.OpAvg:   true,
+	coreagg.OpFirst: true,
+	coreagg.OpLast:  true,
+}
+
+// rankAttempts bounds how often a ranking is retried because a sweeper flush moved the
+// checkpoints between the raw tail scan and the ranking.
+const rankAttempts = 3
+
+// HandleTopPrincipals handles GET /v1/rules/:rule/top
+// Query parameters: start, end, limit, order
+func (s *Service) HandleTopPrincipals(c *gin.Context) {
+	var query struct {
+		Start time.Time `form:"start" binding:"required" time_format:"2006-01-02T15:04:05Z07:00"`
+		End   time.Time `form:"end" binding:"required" time_format:"2006-01-02T15:04:05Z07:00"`
+		Limit int       `form:"limit"`
+		Order string    `form:"order"`
+	}
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, httperr.ErrorResponse{
+			ErrorType: httperr.HttpInvalidJsonError,
+			Message:   "Invalid query parameters",
+			Details:   err.Error(),
+		})
+		return
+	}
+
+	queryCtx, cancel := context.WithTimeout(c.Request.Context(), s.queryTimeout)
+	defer cancel()
+	resp, err := s.QueryTop(queryCtx, TopQueryRequest{
+		Rule:  c.Param("rule"),
+		Start: query.Start,
+		End:   query.End,
+		Limit: query.Limit,
+		Order: query.Order,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, context.DeadlineExceeded):
+			c.JSON(http.StatusGatewayTimeout, httperr.ErrorResponse{
+				ErrorType: httperr.HttpInternalError,
+				Message:   fmt.Sprintf("Projection query timed out after %s", s.queryTimeout),
+				Details:   map[string]interface{}{"timeout": s.queryTimeout.String()},
+			})
+		case errors.Is(err, ErrInvalidQuery):
+			c.JSON(http.StatusBadRequest, httperr.ErrorResponse{
+				ErrorType: httperr.HttpInvalidJsonError,
+				Message:   "Invalid leaderboard query",
+				Details:   err.Error(),
+			})
+		case errors.Is(err, ErrTopUnsupported):
+			c.JSON(http.StatusNotImplemented, httperr.ErrorResponse{
+				ErrorType: httperr.HttpUnsupportedError,
+				Message:   "Leaderboard queries are not supported",
+				Details:   err.Error(),
+			})
+		default:
+			c.JSON(http.StatusInternalServerError, httperr.ErrorResponse{
+				ErrorType: httperr.HttpInternalError,
+				Message:   "Failed to query leaderboard",
+				Details:   err.Error(),
+			})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// QueryTop ranks the principals of a rule by their total value over the range. Like
+// QueryAggregates, it merges durable pre-aggregates with the raw tail after the
+// checkpoint, so rankings are as fresh as per-principal queries. Ties are ordered by
+// principal ID; principals without events in the range are not ranked. Stores with a
+// ruleRangeRanker rank the pre-aggregates of rankable operators themselves; otherwise
+// every principal's pre-aggregates are scanned and merged here.
+func (s *Service) QueryTop(ctx context.Context, req TopQueryRequest) (*TopQueryResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	req, err := normalizeTopRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	rule, ok := s.rule(req.Rule)
+	if !ok {
+		return nil, invalidQueryf("unknown rule: %s", req.Rule)
+	}
+
+	scanner, hasScanner := s.preAggStore.(ruleRangeScanner)
+	eventReader, hasEventReader := s.eventStore.(storage.TypeScopedEventReader)
+	if !hasScanner || !hasEventReader {
+		return nil, ErrTopUnsupported
+	}
+
+	// As for QueryAggregates, pre-aggregates only serve the whole buckets of the range.
+	inner, edges := splitRange(req.Start, req.End, rule.Buckets()[0])
+	candidates, err := bucketCandidates(rule, AggregateQueryRequest{
+		Rule:        req.Rule,
+		Start:       inner.start,
+		End:         inner.end,
+		Granularity: "total",
+	})
+	if err != nil {
+		return nil, err
+	}
+	if inner.empty() {
+		candidates = nil
+	} else {
+		candidates, err = s.excludeRebuildingBuckets(ctx, rule, candidates)
+		if err != nil {
+			return nil, fmt.Errorf("read rule versions: %w", err)
+		}
+	}
+
+	// Edges have no checkpoint: every event of an edge is folded, from where the edge starts.
+	edgeTotals := make(map[string]coreagg.AggregateState)
+	var firstAfter func(time.Time) (int64, error)
+	if reader, ok := s.eventStore.(storage.TypeIngestCursorReader); ok {
+		firstAfter = func(t time.Time) (int64, error) {
+			return reader.FirstTypeIngestSeqAfter(ctx, rule.SourceEvent, t)
+		}
+	}
+	for _, edge := range edges {
+		cursor, found, err := edgeCursor(edge, firstAfter)
+		if err != nil {
+			return nil, fmt.Errorf("find range edge cursor: %w", err)
+		}
+		if !found {
+			continue
+		}
+		if err := s.foldTopRawTail(ctx, eventReader, rule, coreagg.BucketLabel(rule.Buckets()[0]), cursor,
+			coreagg.PartitionCheckpoints{}, false, edge, edgeTotals); err != nil {
+			return nil, fmt.Errorf("query raw events at range edge: %w", err)
+		}
+	}
+
+	ranker, hasRanker := s.preAggStore.(ruleRangeRanker)
+	hasRanker = hasRanker && rankableOperators[rule.Operator]
+
+	var (
+		totals      = make(map[string]coreagg.AggregateState)
+		checkpoints coreagg.PartitionCheckpoints
+		bucketSize  = coreagg.BucketLabel(rule.Buckets()[0])
+		rebuilding  = !inner.empty() && len(candidates) == 0
+		tailFolded  = false
+	)
+	for idx, candidate := range candidates {
+		totals = make(map[string]coreagg.AggregateState)
+		stale := false
+		ranked := false
+		var tail map[string]coreagg.AggregateState
+		if hasRanker {
+			var ranking coreagg.Ranking
+			ranking, tail, ranked, err = s.rankRuleRange(ctx, ranker, eventReader, rule, candidate, req, inner, edgeTotals)
+			if err != nil {
+				return nil, err
+			}
+			if ranked {
+				totals, stale = ranking.Totals, ranking.Stale
+			}
+		}
+		if !ranked {
+			checkpoints, err = scanner.ScanRuleRangeWithCheckpoints(ctx, rule.Name, candidate, inner.start, inner.end,
+				func(principalID string, state coreagg.AggregateState) {
+					if hasStaleFingerprint([]coreagg.AggregateState{state}, rule) {
+						stale = true
+					}
+					accumulateTotal(totals, principalID, state)
+				})
+			if err != nil {
+				return nil, fmt.Errorf("query pre-aggregates: %w", err)
+			}
+		}
+		if stale {
+			// Durable buckets mix rule definitions; rank from the event log instead.
+			rebuilding = true
+			break
+		}
+		if len(totals) > 0 || idx == len(candidates)-1 {
+			bucketSize = candidate
+			if ranked {
+				for principalID, state := range tail {
+					accumulateTotal(totals, principalID, state)
+				}
+				tailFolded = true
+			}
+			break
+		}
+	}
+	if rebuilding {
+		totals = make(map[string]coreagg.AggregateState)
+		checkpoints = coreagg.PartitionCheckpoints{}
+	}
+
+	if !inner.empty() && !tailFolded {
+		var cursor int64
+		if len(checkpoints.Cursors) > 0 {
+			cursor = checkpoints.Min()
+		}
+		if err := s.foldTopRawTail(ctx, eventReader, rule, bucketSize, cursor, checkpoints, rebuilding, inner, totals); err != nil {
+			return nil, fmt.Errorf("query raw event tail: %w", err)
+		}
+	}
+	for principalID, state := range edgeTotals {
+		accumulateTotal(totals, principalID, state)
+	}
+
+	ranked := make([]TopPrincipal, 0, len(totals))
+	for principalID, total := range totals {
+		if total.EventCount == 0 {
+			continue
+		}
+		ranked = append(ranked, TopPrincipal{PrincipalID: principalID, Value: total.Value, EventCount: total.EventCount})
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if cmp := ranked[i].Value.Cmp(ranked[j].Value); cmp != 0 {
+			if req.Order == TopOrderAsc {
+				return cmp < 0
+			}
+			return cmp > 0
+		}
+		return ranked[i].PrincipalID < ranked[j].PrincipalID
+	})
+	if len(ranked) > req.Limit {
+		ranked = ranked[:req.Limit]
+	}
+
+	return &TopQueryResponse{
+		Rule:       rule.Name,
+		Operator:   rule.Operator,
+		Start:      req.Start,
+		End:        req.End,
+		Order:      req.Order,
+		Limit:      req.Limit,
+		Principals: ranked,
+		Rebuilding: rebuilding,
+	}, nil
+}
+
+// rankRuleRange ranks the pre-aggregates of inner at bucketSize in the store and folds
+// the raw tail past the checkpoints the ranking was read at. The ranking covers the top
+// req.Limit principals plus every principal of the tail or of edgeTotals, whose totals
+// the tail and edges may still move. It reports false if sweeper flushes kept moving the
+// checkpoints of the tail's partitions; the caller then scans the pre-aggregates instead.
+func (s *Service) rankRuleRange(
+	ctx context.Context,
+	ranker ruleRangeRanker,
+	reader storage.TypeScopedEventReader,
+	rule coreagg.AggregationRule,
+	bucketSize string,
+	req TopQueryRequest,
+	inner timeRange,
+	edgeTotals map[string]coreagg.AggregateState,
+) (coreagg.Ranking, map[string]coreagg.AggregateState, bool, error) {
+	for attempt := 0; attempt < rankAttempts; attempt++ {
+		checkpoints, err := ranker.ReadPartitionCheckpoints(ctx, bucketSize, partition.Full())
+		if err != nil {
+			return coreagg.Ranking{}, nil, false, fmt.Errorf("read checkpoints: %w", err)
+		}
+		tail := make(map[string]coreagg.AggregateState)
+		if err := s.foldTopRawTail(ctx, reader, rule, bucketSize, checkpoints.Min(), checkpoints, false, inner, tail); err != nil {
+			return coreagg.Ranking{}, nil, false, fmt.Errorf("query raw event tail: %w", err)
+		}
+
+		principalIDs := make([]string, 0, len(tail)+len(edgeTotals))
+		for principalID := range tail {
+			principalIDs = append(principalIDs, principalID)
+		}
+		for principalID := range edgeTotals {
+			if _, ok := tail[principalID]; !ok {
+				principalIDs = append(principalIDs, principalID)
+			}
+		}
+		sort.Strings(principalIDs)
+
+		ranking, err := ranker.RankRuleRangeWithCheckpoints(ctx, coreagg.RankQuery{
+			RuleName:        rule.Name,
+			Operator:        rule.Operator,
+			RuleFingerprint: rule.Fingerprint,
+			BucketSize:      bucketSize,
+			Start:           inner.start,
+			End:             inner.end,
+			Ascending:       req.Order == TopOrderAsc,
+			// Principals the tail or edges move can push as many others out of the top.
+			Limit:        req.Limit + len(principalIDs),
+			PrincipalIDs: principalIDs,
+		})
+		if err != nil {
+			return coreagg.Ranking{}, nil, false, fmt.Errorf("rank pre-aggregates: %w", err)
+		}
+
+		// A flush between the tail scan and the ranking would count the tail twice.
+		moved := false
+		for principalID := range tail {
+			p := partition.For(principalID)
+			if ranking.Checkpoints.Cursor(p) != checkpoints.Cursor(p) {
+				moved = true
+				break
+			}
+		}
+		if !moved {
+			return ranking, tail, true, nil
+		}
+	}
+	slog.Warn("Leaderboard ranking fell back to a pre-aggregate scan; checkpoints kept moving",
+		"rule", rule.Name,
+		"bucket_size", bucketSize,
+		"attempts", rankAttempts,
+	)
+	return coreagg.Ranking{}, nil, false, nil
+}
+
+func normalizeTopRequest(req TopQueryRequest) (TopQueryRequest, error) {
+	if req.Order == "" {
+		req.Order = TopOrderDesc
+	}
+	if req.Limit == 0 {
+		req.Limit = defaultTopLimit
+	}
+
+	if req.Rule == "" {
+		return req, invalidQueryf("rule is required")
+	}
+	if !req.End.After(req.Start) {
+		return req, invalidQueryf("end time must be after start time")
+	}
+	if req.Order != TopOrderDesc && req.Order != TopOrderAsc {
+		return req, invalidQueryf("invalid order: %s (must be desc or asc)", req.Order)
+	}
+	if req.Limit < 0 || req.Limit > maxTopLimit {
+		return req, invalidQueryf("invalid limit: %d (must be between 1 and %d)", req.Limit, maxTopLimit)
+	}
+	return req, nil
+}
+
+// foldTopRawTail adds the raw events of the rule's source event type in window after
+// cursor that are past their partition's checkpoint to totals, in one scan across all
+// principals. Empty checkpoints fold every event of window after cursor. A replay pages through the whole window; a tail
+// scan gives up after maxRawQueryIterations, like scanScopedRawEvents.
+func (s *Service) foldTopRawTail(
+	ctx context.Context,
+	reader storage.TypeScopedEventReader,
+	rule coreagg.AggregationRule,
+	bucketSize string,
+	cursor int64,
+	checkpoints coreagg.PartitionCheckpoints,
+	replay bool,
+	window timeRange,
+	totals map[string]coreagg.AggregateState,
+) error {
+	bucketDuration, err := parseBucketSize(bucketSize)
+	if err != nil {
+		return err
+	}
+	reducer, ok := coreagg.Operators[rule.Operator]
+	if !ok {
+		return fmt.Errorf("unknown rule operator: %s", rule.Operator)
+	}
+
+	checkpointFor := func(principalID string) int64 {
+		if len(checkpoints.Cursors) == 0 {
+			return 0
+		}
+		return checkpoints.Cursor(partition.For(principalID))
+	}
+
+	iterations := 0
+	totalEvents := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		// Safety limit: prevent unbounded scanning if checkpoints are far behind
+		if !replay && iterations >= maxRawQueryIterations {
+			slog.Warn("Leaderboard raw event tail scan reached maximum iteration limit",
+				"rule", rule.Name,
+				"iterations", iterations,
+				"events_scanned", totalEvents,
+				"max_iterations", maxRawQueryIterations,
+			)
+			return fmt.Errorf("raw event scan exceeded maximum iterations (%d batches, %d events total) - aggregation may be too far behind",
+				maxRawQueryIterations, totalEvents)
+		}
+
+		events, err := reader.RetrieveTypeScopedEventsAfterCursor(ctx, cursor, rule.SourceEvent, window.start, window.end, rule.BucketsByIngestion(), rawQueryBatchSize)
+		if err != nil {
+			return err
+		}
+		if len(events) == 0 {
+			return nil
+		}
+
+		unflushed := make(map[string][]*v1.Event)
+		for _, evt := range events {
+			if evt.IngestSeq > checkpointFor(evt.PrincipalID) {
+				unflushed[evt.PrincipalID] = append(unflushed[evt.PrincipalID], evt)
+			}
+		}
+		for principalID, principalEvents := range unflushed {
+			buckets := make(map[bucketKey]coreagg.AggregateState)
+			s.foldRawEventsIntoBuckets(principalEvents, buckets, rule, reducer, bucketDuration, window.start, window.end)
+			for _, state := range buckets {
+				accumulateTotal(totals, principalID, state)
+			}
+		}
+		totalEvents += len(events)
+		iterations++
+
+		cursor = events[len(events)-1].IngestSeq
+		if len(events) < rawQueryBatchSize {
+			return nil
+		}
+	}
+}
+
+// accumulateTotal merges state into the running total of principalID.
+func accumulateTotal(totals map[string]coreagg.AggregateState, principalID string, state coreagg.AggregateState) {
+	current, ok := totals[principalID]
+	if !ok {
+		totals[principalID] = state
+		return
+	}
+	totals[principalID] = foldBuckets([]coreagg.AggregateState{current, state})
+}
@@ -0,0 +1,328 @@
+package projection
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sort"
+	"testing"
+	"time"
+
+	v1 "github.com/aevon-lab/project-aevon/internal/api/v1"
+	coreagg "github.com/aevon-lab/project-aevon/internal/core/aggregation"
+	"github.com/aevon-lab/project-aevon/internal/core/partition"
+	aggregationmocks "github.com/aevon-lab/project-aevon/internal/mocks/aggregation"
+	storagemocks "github.com/aevon-lab/project-aevon/internal/mocks/storage"
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+)
+
+type scannedState struct {
+	principalID string
+	state       coreagg.AggregateState
+}
+
+// ruleScanPreAggStore adds the optional rule range scanner to the generated mock.
+type ruleScanPreAggStore struct {
+	*aggregationmocks.PreAggregateStore
+	states      map[string][]scannedState // by bucket size
+	checkpoints coreagg.PartitionCheckpoints
+	scans       []string // bucket sizes scanned
+}
+
+func (s *ruleScanPreAggStore) ScanRuleRangeWithCheckpoints(
+	_ context.Context,
+	_ string,
+	bucketSize string,
+	_ time.Time,
+	_ time.Time,
+	fn func(principalID string, state coreagg.AggregateState),
+) (coreagg.PartitionCheckpoints, error) {
+	s.scans = append(s.scans, bucketSize)
+	for _, scanned := range s.states[bucketSize] {
+		fn(scanned.principalID, scanned.state)
+	}
+	return s.checkpoints, nil
+}
+
+// typeScopedEventStore adds the optional type scoped event reader to the generated mock.
+type typeScopedEventStore struct {
+	*storagemocks.EventStore
+	events  []*v1.Event
+	cursors []int64
+}
+
+func (s *typeScopedEventStore) RetrieveTypeScopedEventsAfterCursor(
+	_ context.Context,
+	cursor int64,
+	eventType string,
+	_ time.Time,
+	_ time.Time,
+	_ bool,
+	limit int,
+) ([]*v1.Event, error) {
+	s.cursors = append(s.cursors, cursor)
+	var events []*v1.Event
+	for _, evt := range s.events {
+		if evt.IngestSeq > cursor && evt.Type == eventType && len(events) < limit {
+			events = append(events, evt)
+		}
+	}
+	return events, nil
+}
+
+func newTopTestService(t *testing.T) (*Service, *ruleScanPreAggStore, *typeScopedEventStore, time.Time, time.Time) {
+	start := time.Date(2026, 2, 7, 10, 0, 0, 0, time.UTC)
+	end := start.Add(2 * time.Hour)
+
+	sum := func(value int64, window time.Time) coreagg.AggregateState {
+		return coreagg.AggregateState{Operator: coreagg.OpSum, Value: decimal.NewFromInt(value), EventCount: 1, WindowStart: window}
+	}
+
+	checkpoints := coreagg.PartitionCheckpoints{
+		Range:   partition.Range{From: 0, To: partition.Count},
+		Cursors: make([]int64, partition.Count),
+	}
+	for i := range checkpoints.Cursors {
+		checkpoints.Cursors[i] = 200
+	}
+	checkpoints.Cursors[partition.For("user-3")] = 150
+
+	preAggStore := &ruleScanPreAggStore{
+		PreAggregateStore: aggregationmocks.NewPreAggregateStore(t),
+		states: map[string][]scannedState{
+			"1h": {
+				{principalID: "user-1", state: sum(100, start)},
+				{principalID: "user-1", state: sum(50, start.Add(time.Hour))},
+				{principalID: "user-2", state: sum(120, start)},
+				{principalID: "user-3", state: sum(10, start)},
+			},
+		},
+		checkpoints: checkpoints,
+	}
+	tokens := func(n int) map[string]interface{} { return map[string]interface{}{"tokens": n} }
+	eventStore := &typeScopedEventStore{
+		EventStore: storagemocks.NewEventStore(t),
+		events: []*v1.Event{
+			{ID: "evt-1", PrincipalID: "user-3", Type: "llm.call", OccurredAt: start, IngestSeq: 160, Data: tokens(500)},
+			{ID: "evt-2", PrincipalID: "user-2", Type: "llm.call", OccurredAt: start, IngestSeq: 190, Data: tokens(1000)}, // flushed
+			{ID: "evt-3", PrincipalID: "user-4", Type: "llm.call", OccurredAt: start, IngestSeq: 201, Data: tokens(5)},
+			{ID: "evt-4", PrincipalID: "user-4", Type: "llm.call", OccurredAt: end, IngestSeq: 202, Data: tokens(7)}, // out of range
+		},
+	}
+
+	svc := NewService(preAggStore, eventStore, []coreagg.AggregationRule{{
+		Name:        "sum_tokens",
+		SourceEvent: "llm.call",
+		Operator:    coreagg.OpSum,
+		Field:       "tokens",
+		BucketSizes: []time.Duration{time.Minute, time.Hour},
+	}})
+	return svc, preAggStore, eventStore, start, end
+}
+
+func TestService_QueryTop_RanksDurableAndRawTail(t *testing.T) {
+	svc, preAggStore, eventStore, start, end := newTopTestService(t)
+
+	resp, err := svc.QueryTop(context.Background(), TopQueryRequest{Rule: "sum_tokens", Start: start, End: end, Limit: 3})
+	require.NoError(t, err)
+	require.Equal(t, []string{"1h"}, preAggStore.scans)
+	require.Equal(t, []int64{150}, eventStore.cursors)
+	require.Equal(t, TopOrderDesc, resp.Order)
+	require.Equal(t, []TopPrincipal{
+		{PrincipalID: "user-3", Value: decimal.NewFromInt(510), EventCount: 2},
+		{PrincipalID: "user-1", Value: decimal.NewFromInt(150), EventCount: 2},
+		{PrincipalID: "user-2", Value: decimal.NewFromInt(120), EventCount: 1},
+	}, resp.Principals)
+}
+
+func TestService_QueryTop_AscendingOrder(t *testing.T) {
+	svc, _, _, start, end := newTopTestService(t)
+
+	resp, err := svc.QueryTop(context.Background(), TopQueryRequest{Rule: "sum_tokens", Start: start, End: end, Limit: 2, Order: TopOrderAsc})
+	require.NoError(t, err)
+	require.Len(t, resp.Principals, 2)
+	require.Equal(t, "user-4", resp.Principals[0].PrincipalID)
+	require.Equal(t, "5", resp.Principals[0].Value.String())
+	require.Equal(t, "user-2", resp.Principals[1].PrincipalID)
+}
+
+func TestService_QueryTop_Validation(t *testing.T) {
+	svc, _, _, start, end := newTopTestService(t)
+
+	tests := []struct {
+		name string
+		req  TopQueryRequest
+	}{
+		{name: "unknown rule", req: TopQueryRequest{Rule: "missing_rule", Start: start, End: end}},
+		{name: "end before start", req: TopQueryRequest{Rule: "sum_tokens", Start: end, End: start}},
+		{name: "invalid order", req: TopQueryRequest{Rule: "sum_tokens", Start: start, End: end, Order: "sideways"}},
+		{name: "limit too large", req: TopQueryRequest{Rule: "sum_tokens", Start: start, End: end, Limit: maxTopLimit + 1}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := svc.QueryTop(context.Background(), tc.req)
+			require.ErrorIs(t, err, ErrInvalidQuery)
+		})
+	}
+}
+
+func TestService_HandleTopPrincipals(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	svc, _, _, start, end := newTopTestService(t)
+	r := gin.New()
+	svc.RegisterRoutes(r)
+
+	url := fmt.Sprintf("/v1/rules/sum_tokens/top?start=%s&end=%s&limit=1",
+		start.Format(time.RFC3339), end.Format(time.RFC3339))
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, url, nil))
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.Contains(t, resp.Body.String(), `"principal_id":"user-3"`)
+
+	// Stores without cross-principal reads cannot serve leaderboards.
+	plain := NewService(aggregationmocks.NewPreAggregateStore(t), storagemocks.NewEventStore(t), []coreagg.AggregationRule{{
+		Name:        "sum_tokens",
+		SourceEvent: "llm.call",
+		Operator:    coreagg.OpSum,
+		Field:       "tokens",
+	}})
+	r = gin.New()
+	plain.RegisterRoutes(r)
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, url, nil))
+	require.Equal(t, http.StatusNotImplemented, resp.Code)
+	require.Contains(t, resp.Body.String(), `"error_type":"unsupported"`)
+}
+
+// typeCursorEventStore adds the optional type ingest cursor reader to typeScopedEventStore.
+type typeCursorEventStore struct {
+	*typeScopedEventStore
+	firstAfter int64
+	lookups    []time.Time
+}
+
+func (s *typeCursorEventStore) FirstTypeIngestSeqAfter(_ context.Context, _ string, t time.Time) (int64, error) {
+	s.lookups = append(s.lookups, t)
+	return s.firstAfter, nil
+}
+
+func TestService_QueryTop_EdgeScanStartsAtEdge(t *testing.T) {
+	bucket := time.Date(2026, 2, 7, 10, 0, 0, 0, time.UTC)
+	start, end := bucket.Add(10*time.Second), bucket.Add(40*time.Second)
+
+	tokens := func(n int) map[string]interface{} { return map[string]interface{}{"tokens": n} }
+	eventStore := &typeCursorEventStore{
+		typeScopedEventStore: &typeScopedEventStore{
+			EventStore: storagemocks.NewEventStore(t),
+			events: []*v1.Event{
+				{ID: "evt-1", PrincipalID: "user-1", Type: "llm.call", OccurredAt: start, IngestSeq: 5, Data: tokens(1000)}, // ingested before the edge
+				{ID: "evt-2", PrincipalID: "user-2", Type: "llm.call", OccurredAt: start, IngestSeq: 6, Data: tokens(20)},
+				{ID: "evt-3", PrincipalID: "user-1", Type: "llm.call", OccurredAt: end.Add(-time.Second), IngestSeq: 7, Data: tokens(10)},
+			},
+		},
+		firstAfter: 6,
+	}
+	preAggStore := &ruleScanPreAggStore{PreAggregateStore: aggregationmocks.NewPreAggregateStore(t)}
+	svc := NewService(preAggStore, eventStore, []coreagg.AggregationRule{{
+		Name:        "sum_tokens",
+		SourceEvent: "llm.call",
+		Operator:    coreagg.OpSum,
+		Field:       "tokens",
+	}})
+
+	resp, err := svc.QueryTop(context.Background(), TopQueryRequest{Rule: "sum_tokens", Start: start, End: end})
+	require.NoError(t, err)
+	require.Empty(t, preAggStore.scans)
+	require.Equal(t, []time.Time{start.Add(-edgeClockSkew)}, eventStore.lookups)
+	require.Equal(t, []int64{5}, eventStore.cursors)
+	require.Equal(t, []TopPrincipal{
+		{PrincipalID: "user-2", Value: decimal.NewFromInt(20), EventCount: 1},
+		{PrincipalID: "user-1", Value: decimal.NewFromInt(10), EventCount: 1},
+	}, resp.Principals)
+}
+
+// ruleRankPreAggStore adds the optional rule range ranker to ruleScanPreAggStore. It ranks
+// the scanner's states and answers with rankCheckpoints in turn, repeating the last.
+type ruleRankPreAggStore struct {
+	*ruleScanPreAggStore
+	rankCheckpoints []coreagg.PartitionCheckpoints
+	ranks           []coreagg.RankQuery
+}
+
+func (s *ruleRankPreAggStore) ReadPartitionCheckpoints(context.Context, string, partition.Range) (coreagg.PartitionCheckpoints, error) {
+	return s.checkpoints, nil
+}
+
+func (s *ruleRankPreAggStore) RankRuleRangeWithCheckpoints(_ context.Context, query coreagg.RankQuery) (coreagg.Ranking, error) {
+	s.ranks = append(s.ranks, query)
+	checkpoints := s.checkpoints
+	if len(s.rankCheckpoints) > 0 {
+		checkpoints = s.rankCheckpoints[min(len(s.ranks), len(s.rankCheckpoints))-1]
+	}
+
+	totals := make(map[string]coreagg.AggregateState)
+	for _, scanned := range s.states[query.BucketSize] {
+		accumulateTotal(totals, scanned.principalID, scanned.state)
+	}
+	principalIDs := make([]string, 0, len(totals))
+	for principalID := range totals {
+		principalIDs = append(principalIDs, principalID)
+	}
+	sort.Slice(principalIDs, func(i, j int) bool {
+		if cmp := totals[principalIDs[i]].Value.Cmp(totals[principalIDs[j]].Value); cmp != 0 {
+			return (cmp < 0) == query.Ascending
+		}
+		return principalIDs[i] < principalIDs[j]
+	})
+
+	ranking := coreagg.Ranking{Totals: make(map[string]coreagg.AggregateState), Checkpoints: checkpoints}
+	for i, principalID := range principalIDs {
+		if i < query.Limit || slices.Contains(query.PrincipalIDs, principalID) {
+			ranking.Totals[principalID] = totals[principalID]
+		}
+	}
+	return ranking, nil
+}
+
+func TestService_QueryTop_RanksInStore(t *testing.T) {
+	svc, scanStore, eventStore, start, end := newTopTestService(t)
+	preAggStore := &ruleRankPreAggStore{ruleScanPreAggStore: scanStore}
+	svc.preAggStore = preAggStore
+
+	resp, err := svc.QueryTop(context.Background(), TopQueryRequest{Rule: "sum_tokens", Start: start, End: end, Limit: 1})
+	require.NoError(t, err)
+	require.Empty(t, scanStore.scans)
+	require.Equal(t, []int64{150}, eventStore.cursors)
+	require.Len(t, preAggStore.ranks, 1)
+	// The tail moves user-3 and user-4, so the store returns one principal past each of them.
+	require.Equal(t, 3, preAggStore.ranks[0].Limit)
+	require.Equal(t, []string{"user-3", "user-4"}, preAggStore.ranks[0].PrincipalIDs)
+	require.Equal(t, []TopPrincipal{
+		{PrincipalID: "user-3", Value: decimal.NewFromInt(510), EventCount: 2},
+	}, resp.Principals)
+}
+
+func TestService_QueryTop_RankFallsBackToScanWhenCheckpointsMove(t *testing.T) {
+	svc, scanStore, eventStore, start, end := newTopTestService(t)
+	moved := coreagg.PartitionCheckpoints{
+		Range:   scanStore.checkpoints.Range,
+		Cursors: slices.Clone(scanStore.checkpoints.Cursors),
+	}
+	moved.Cursors[partition.For("user-3")] = 170 // a flush folded evt-1 between the tail scan and the ranking
+	preAggStore := &ruleRankPreAggStore{ruleScanPreAggStore: scanStore, rankCheckpoints: []coreagg.PartitionCheckpoints{moved}}
+	svc.preAggStore = preAggStore
+
+	resp, err := svc.QueryTop(context.Background(), TopQueryRequest{Rule: "sum_tokens", Start: start, End: end, Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, preAggStore.ranks, rankAttempts)
+	require.Equal(t, []string{"1h"}, scanStore.scans)
+	require.Len(t, eventStore.cursors, rankAttempts+1)
+	require.Equal(t, []TopPrincipal{
+		{PrincipalID: "user-3", Value: decimal.NewFromInt(510), EventCount: 2},
+	}, resp.Principals)
+}
@@ -3,6 +3,7 @@ package projection
 import (
 	"time"
 
+	coreagg "github.com/aevon-lab/project-aevon/internal/core/aggregation"
 	"github.com/shopspring/decimal"
 )
 
@@ -13,6 +14,16 @@ type AggregateQueryRequest struct {
 	Start       time.Time `form:"start" binding:"required" time_format:"2006-01-02T15:04:05Z07:00"`
 	End         time.Time `form:"end" binding:"required" time_format:"2006-01-02T15:04:05Z07:00"`
 	Granularity string    `form:"granularity"` // default: "total"
+	Quantiles   []float64 // optional; quantile rules only, e.g. 0.5, 0.99
+	// Dimensions restricts the query to buckets whose group_by values match,
+	// keyed by group_by path (e.g. "data.model" -> "gpt-4"). group_by rules only.
+	Dimensions map[string]string
+	// Breakdown additionally returns one value series per dimension tuple.
+	Breakdown bool
+	// AsOf and AsOfCursor restrict the query to events ingested at or before a time or
+	// ingest_seq, reproducing the answer a caller saw back then. Zero means now.
+	AsOf       time.Time
+	AsOfCursor int64
 }
 
 // AggregateValue represents a single aggregate data point in the response.
@@ -21,6 +32,11 @@ type AggregateValue struct {
 	WindowEnd   time.Time       `json:"window_end"`
 	Value       decimal.Decimal `json:"value"`
 	EventCount  int64           `json:"event_count"`
+	// Quantiles maps each requested quantile (e.g. "0.99") to its estimate.
+	// Only set when the query asks for quantiles on a quantile rule.
+	Quantiles map[string]decimal.Decimal `json:"quantiles,omitempty"`
+
+	digest *coreagg.QuantileSketch // merged sketch of the window; quantile rules only
 }
 
 // AggregateQueryResponse represents the response for an aggregate query.
@@ -34,4 +50,24 @@ type AggregateQueryResponse struct {
 	DataThrough      time.Time        `json:"data_through"`
 	StalenessSeconds int              `json:"staleness_seconds"`
 	Values           []AggregateValue `json:"values"`
+	// FinalizedThrough is set for rules with a late event watermark that keeps late
+	// events out of closed buckets: values before it will not change anymore.
+	FinalizedThrough *time.Time `json:"finalized_through,omitempty"`
+	// Groups is set when the query asks for a breakdown by group_by dimensions.
+	Groups []AggregateGroup `json:"groups,omitempty"`
+	// Rebuilding is set when the rule's pre-aggregates are being rebuilt after a rule
+	// change; values were then computed from raw events.
+	Rebuilding bool `json:"rebuilding,omitempty"`
+	// AsOf and AsOfCursor echo the point in time of the query.
+	AsOf       *time.Time `json:"as_of,omitempty"`
+	AsOfCursor int64      `json:"as_of_cursor,omitempty"`
+	// Replayed is set when pre-aggregates already contained later events, so values
+	// were replayed from raw events.
+	Replayed bool `json:"replayed,omitempty"`
+}
+
+// AggregateGroup is the value series of one group_by dimension tuple.
+type AggregateGroup struct {
+	Dimensions map[string]interface{} `json:"dimensions"`
+	Values     []AggregateValue       `json:"values"`
 }
@@ -0,0 +1,193 @@
+package retention
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	aggjob "github.com/aevon-lab/project-aevon/internal/aggregation"
+	"github.com/aevon-lab/project-aevon/internal/core/aggregation"
+	"github.com/aevon-lab/project-aevon/internal/core/retention"
+)
+
+// PartitionStore manages the monthly partitions of the event log.
+type PartitionStore interface {
+	// ListEventPartitions returns the monthly partitions of the event log, oldest first.
+	ListEventPartitions(ctx context.Context) ([]retention.EventPartition, error)
+
+	// CreateEventPartition creates the partition of the UTC month of month unless it
+	// exists.
+	CreateEventPartition(ctx context.Context, month time.Time) (retention.EventPartition, error)
+
+	// RetireEventPartition detaches p, or drops it if drop is set. It fails with
+	// retention.ErrPartitionNotAggregated unless the checkpoints of all bucketSizes and
+	// every running rule rebuild have passed the events of p.
+	RetireEventPartition(ctx context.Context, p retention.EventPartition, drop bool, bucketSizes []string) error
+}
+
+// Archiver exports event partitions to cold storage.
+type Archiver interface {
+	// Archived reports whether p has a complete archive.
+	Archived(p retention.EventPartition) bool
+
+	// ArchivePartition exports every event of p.
+	ArchivePartition(ctx context.Context, p retention.EventPartition) error
+}
+
+// archiveGrace is how long after its month a partition is archived, so events ingested
+// just before the month ended have committed.
+const archiveGrace = time.Hour
+
+// Options configures partition maintenance.
+type Options struct {
+	PartitionsAhead int           // months created ahead of the current one
+	RetentionMonths int           // full months kept before the current one; 0 keeps every partition
+	Drop            bool          // drop retired partitions instead of detaching them
+	Interval        time.Duration // how often partitions are maintained
+}
+
+// Maintainer pre-creates the partitions of upcoming months and retires partitions older
+// than the retention period. A partition is only retired once every bucket size of the
+// current rules has aggregated all of its events, so retention never loses events that
+// pre-aggregates do not contain yet.
+type Maintainer struct {
+	store    PartitionStore
+	archiver Archiver
+	opts     Options
+	nowFn    func() time.Time
+
+	mu          sync.RWMutex
+	bucketSizes []string
+}
+
+// NewMaintainer creates a maintainer of store for rules.
+func NewMaintainer(store PartitionStore, rules []aggregation.AggregationRule, opts Options) *Maintainer {
+	m := &Maintainer{
+		store: store,
+		opts:  opts,
+		nowFn: time.Now,
+	}
+	m.SetRules(rules)
+	return m
+}
+
+// UseArchiver archives every partition once its month has ended. Partitions are then
+// only retired after they were archived.
+func (m *Maintainer) UseArchiver(archiver Archiver) {
+	m.archiver = archiver
+}
+
+// SetRules replaces the rules whose bucket checkpoints gate retention.
+func (m *Maintainer) SetRules(rules []aggregation.AggregationRule) {
+	var labels []string
+	for _, bucketSize := range aggjob.BucketSizes(rules) {
+		labels = append(labels, aggregation.BucketLabel(bucketSize))
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.bucketSizes = labels
+}
+
+// Start maintains partitions now and then every Interval until ctx is cancelled.
+func (m *Maintainer) Start(ctx context.Context) error {
+	ticker := time.NewTicker(m.opts.Interval)
+	defer ticker.Stop()
+
+	slog.Info("[Retention] Starting event partition maintenance",
+		"interval", m.opts.Interval,
+		"partitions_ahead", m.opts.PartitionsAhead,
+		"retention_months", m.opts.RetentionMonths,
+		"drop", m.opts.Drop,
+	)
+
+	for {
+		if err := m.RunOnce(ctx); err != nil {
+			slog.Error("[Retention] Partition maintenance failed", "error", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			slog.Info("[Retention] Stopping (context cancelled)")
+			return nil
+		}
+	}
+}
+
+// RunOnce creates the partitions of the current month and PartitionsAhead months after
+// it, archives ended partitions if an archiver is set, then retires partitions that ended
+// more than RetentionMonths before the current month. Retirement stops at the first
+// partition that is not fully aggregated or not archived.
+func (m *Maintainer) RunOnce(ctx context.Context) error {
+	now := m.nowFn()
+	current := retention.MonthStart(now)
+	partitions, err := m.store.ListEventPartitions(ctx)
+	if err != nil {
+		return err
+	}
+
+	existing := make(map[time.Time]struct{}, len(partitions))
+	for _, p := range partitions {
+		existing[p.From] = struct{}{}
+	}
+	for i := 0; i <= m.opts.PartitionsAhead; i++ {
+		month := current.AddDate(0, i, 0)
+		if _, ok := existing[month]; ok {
+			continue
+		}
+		p, err := m.store.CreateEventPartition(ctx, month)
+		if err != nil {
+			return err
+		}
+		slog.Info("[Retention] Created event partition", "partition", p.Name, "from", p.From, "to", p.To)
+	}
+
+	if m.archiver != nil {
+		for _, p := range partitions {
+			if p.To.Add(archiveGrace).After(now) {
+				break
+			}
+			if m.archiver.Archived(p) {
+				continue
+			}
+			if err := m.archiver.ArchivePartition(ctx, p); err != nil {
+				return fmt.Errorf("archive %s: %w", p.Name, err)
+			}
+		}
+	}
+
+	if m.opts.RetentionMonths <= 0 {
+		return nil
+	}
+	m.mu.RLock()
+	bucketSizes := m.bucketSizes
+	m.mu.RUnlock()
+	if len(bucketSizes) == 0 {
+		slog.Warn("[Retention] No aggregation rules; skipping retention, partitions cannot be checked against checkpoints")
+		return nil
+	}
+
+	cutoff := current.AddDate(0, -m.opts.RetentionMonths, 0)
+	for _, p := range partitions {
+		if p.To.After(cutoff) {
+			break
+		}
+		if m.archiver != nil && !m.archiver.Archived(p) {
+			slog.Warn("[Retention] Keeping event partition until it is archived", "partition", p.Name)
+			return nil
+		}
+		err := m.store.RetireEventPartition(ctx, p, m.opts.Drop, bucketSizes)
+		if errors.Is(err, retention.ErrPartitionNotAggregated) {
+			slog.Warn("[Retention] Keeping event partition until it is aggregated", "partition", p.Name, "error", err)
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("retire %s: %w", p.Name, err)
+		}
+		slog.Info("[Retention] Retired event partition", "partition", p.Name, "dropped", m.opts.Drop)
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package retention
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aevon-lab/project-aevon/internal/core/aggregation"
+	"github.com/aevon-lab/project-aevon/internal/core/retention"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeStore keeps partitions in memory. Partitions ending after aggregatedUntil are not
+// aggregated yet.
+type fakeStore struct {
+	partitions      []retention.EventPartition
+	aggregatedUntil time.Time
+	retired         []string
+	bucketSizes     []string
+}
+
+func (s *fakeStore) ListEventPartitions(ctx context.Context) ([]retention.EventPartition, error) {
+	return append([]retention.EventPartition(nil), s.partitions...), nil
+}
+
+func (s *fakeStore) CreateEventPartition(ctx context.Context, month time.Time) (retention.EventPartition, error) {
+	p := monthPartition(month)
+	s.partitions = append(s.partitions, p)
+	return p, nil
+}
+
+func (s *fakeStore) RetireEventPartition(ctx context.Context, p retention.EventPartition, drop bool, bucketSizes []string) error {
+	if p.To.After(s.aggregatedUntil) {
+		return retention.ErrPartitionNotAggregated
+	}
+	s.retired = append(s.retired, p.Name)
+	s.bucketSizes = bucketSizes
+	return nil
+}
+
+func monthPartition(month time.Time) retention.EventPartition {
+	from := retention.MonthStart(month)
+	return retention.EventPartition{Name: fmt.Sprintf("events_p%s", from.Format("200601")), From: from, To: from.AddDate(0, 1, 0)}
+}
+
+func partitionNames(partitions []retention.EventPartition) []string {
+	var names []string
+	for _, p := range partitions {
+		names = append(names, p.Name)
+	}
+	return names
+}
+
+var testRules = []aggregation.AggregationRule{
+	{Name: "count_requests", SourceEvent: "api.request", Operator: aggregation.OpCount, WindowSize: time.Minute},
+	{Name: "hourly_tokens", SourceEvent: "api.request", Operator: aggregation.OpSum, Field: "tokens", WindowSize: time.Hour},
+}
+
+func newTestMaintainer(store *fakeStore, rules []aggregation.AggregationRule, retentionMonths int) *Maintainer {
+	m := NewMaintainer(store, rules, Options{PartitionsAhead: 2, RetentionMonths: retentionMonths, Interval: time.Hour})
+	m.nowFn = func() time.Time { return time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC) }
+	return m
+}
+
+func TestMaintainer_CreatesPartitionsAhead(t *testing.T) {
+	store := &fakeStore{partitions: []retention.EventPartition{
+		monthPartition(time.Date(2026, 9, 1, 0, 0, 0, 0, time.UTC)),
+		monthPartition(time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)),
+	}}
+
+	require.NoError(t, newTestMaintainer(store, testRules, 0).RunOnce(context.Background()))
+	require.Equal(t, []string{"events_p202609", "events_p202610", "events_p202611", "events_p202612"}, partitionNames(store.partitions))
+	require.Empty(t, store.retired)
+}
+
+func TestMaintainer_RetiresAggregatedPartitionsPastRetention(t *testing.T) {
+	store := &fakeStore{aggregatedUntil: time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)}
+	for month := 3; month <= 12; month++ {
+		store.partitions = append(store.partitions, monthPartition(time.Date(2026, time.Month(month), 1, 0, 0, 0, 0, time.UTC)))
+	}
+
+	// Three months are kept before October: July through September. June has ended
+	// before the cutoff but is not aggregated yet, so it and later partitions stay.
+	require.NoError(t, newTestMaintainer(store, testRules, 3).RunOnce(context.Background()))
+	require.Equal(t, []string{"events_p202603", "events_p202604", "events_p202605"}, store.retired)
+	require.Equal(t, []string{"1m", "1h"}, store.bucketSizes)
+}
+
+func TestMaintainer_SkipsRetentionWithoutRules(t *testing.T) {
+	store := &fakeStore{
+		partitions:      []retention.EventPartition{monthPartition(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))},
+		aggregatedUntil: time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	m := newTestMaintainer(store, nil, 3)
+	require.NoError(t, m.RunOnce(context.Background()))
+	require.Empty(t, store.retired)
+
+	m.SetRules(testRules)
+	require.NoError(t, m.RunOnce(context.Background()))
+	require.Equal(t, []string{"events_p202501"}, store.retired)
+}
+
+// fakeArchiver archives every partition except failing.
+type fakeArchiver struct {
+	archived map[string]bool
+	failing  string
+}
+
+func (a *fakeArchiver) Archived(p retention.EventPartition) bool { return a.archived[p.Name] }
+
+func (a *fakeArchiver) ArchivePartition(ctx context.Context, p retention.EventPartition) error {
+	if p.Name == a.failing {
+		return fmt.Errorf("disk full")
+	}
+	a.archived[p.Name] = true
+	return nil
+}
+
+func TestMaintainer_ArchivesEndedPartitionsBeforeRetiring(t *testing.T) {
+	store := &fakeStore{aggregatedUntil: time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)}
+	for month := 5; month <= 10; month++ {
+		store.partitions = append(store.partitions, monthPartition(time.Date(2026, time.Month(month), 1, 0, 0, 0, 0, time.UTC)))
+	}
+	archiver := &fakeArchiver{archived: map[string]bool{"events_p202605": true}, failing: "events_p202607"}
+	m := newTestMaintainer(store, testRules, 3)
+	m.UseArchiver(archiver)
+
+	// July fails to archive: the run stops before later months are archived and before
+	// anything is retired.
+	require.ErrorContains(t, m.RunOnce(context.Background()), "archive events_p202607: disk full")
+	require.Equal(t, map[string]bool{"events_p202605": true, "events_p202606": true}, archiver.archived)
+	require.Empty(t, store.retired)
+
+	archiver.failing = ""
+	require.NoError(t, m.RunOnce(context.Background()))
+	require.Equal(t, []string{"events_p202605", "events_p202606"}, store.retired)
+	require.True(t, archiver.archived["events_p202609"])
+	require.False(t, archiver.archived["events_p202610"])
+}
@@ -14,6 +14,9 @@ type Server struct {
 	Engine *gin.Engine
 	Addr   string
 	db     *sql.DB
+
+	healthReporters map[string]HealthReporter
+	onShutdown      []func()
 }
 
 // HealthChecker is an interface for components that can report their health status.
@@ -21,6 +24,12 @@ type HealthChecker interface {
 	Ping(ctx context.Context) error
 }
 
+// HealthReporter is a component that adds a section to the /health response.
+// A reporter error is shown in its section but does not mark the service unhealthy.
+type HealthReporter interface {
+	Health(ctx context.Context) (interface{}, error)
+}
+
 func New(addr string, db *sql.DB, mode string) *Server {
 	// Set Gin mode based on configuration
 	if mode == "debug" {
@@ -32,9 +41,10 @@ func New(addr string, db *sql.DB, mode string) *Server {
 	r := gin.Default()
 
 	s := &Server{
-		Engine: r,
-		Addr:   addr,
-		db:     db,
+		Engine:          r,
+		Addr:            addr,
+		db:              db,
+		healthReporters: make(map[string]HealthReporter),
 	}
 
 	// Health check endpoint with database connectivity verification
@@ -43,6 +53,18 @@ func New(addr string, db *sql.DB, mode string) *Server {
 	return s
 }
 
+// RegisterHealthReporter adds reporter's output to the /health response under name.
+func (s *Server) RegisterHealthReporter(name string, reporter HealthReporter) {
+	s.healthReporters[name] = reporter
+}
+
+// RegisterOnShutdown registers fn to run when the server starts shutting down. Shutdown
+// does not cancel the contexts of running requests, so long-lived handlers such as state
+// streams register a hook that ends them.
+func (s *Server) RegisterOnShutdown(fn func()) {
+	s.onShutdown = append(s.onShutdown, fn)
+}
+
 func (s *Server) healthHandler(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(c.Request.Context(), 2*time.Second)
 	defer cancel()
@@ -59,10 +81,21 @@ func (s *Server) healthHandler(c *gin.Context) {
 		}
 	}
 
-	c.JSON(http.StatusOK, gin.H{
+	resp := gin.H{
 		"status":   "healthy",
 		"database": "connected",
-	})
+	}
+	for name, reporter := range s.healthReporters {
+		details, err := reporter.Health(ctx)
+		if err != nil {
+			slog.Warn("Health reporter failed", "section", name, "error", err)
+			resp[name] = gin.H{"error": err.Error()}
+			continue
+		}
+		resp[name] = details
+	}
+
+	c.JSON(http.StatusOK, resp)
 }
 
 func (s *Server) Run(ctx context.Context) error {
@@ -70,6 +103,9 @@ func (s *Server) Run(ctx context.Context) error {
 		Addr:    s.Addr,
 		Handler: s.Engine,
 	}
+	for _, fn := range s.onShutdown {
+		srv.RegisterOnShutdown(fn)
+	}
 
 	slog.Info("Starting HTTP Server...", "address", s.Addr)
 
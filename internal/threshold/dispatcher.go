@@ -0,0 +1,170 @@
+package threshold
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aevon-lab/project-aevon/internal/core/threshold"
+)
+
+const (
+	dispatchBatchSize = 20
+	retryBaseDelay    = 5 * time.Second
+	maxRetryDelay     = time.Hour
+	maxErrorBodyBytes = 512
+)
+
+// DispatcherOptions configures webhook delivery.
+type DispatcherOptions struct {
+	Secret       []byte        // HMAC key of the X-Aevon-Signature header
+	Timeout      time.Duration // per delivery attempt
+	PollInterval time.Duration // how often the outbox is polled for due deliveries
+	MaxAttempts  int           // attempts before a delivery is given up
+}
+
+// Dispatcher sends the deliveries of the outbox. A delivery is completed only after the
+// receiver answered 2xx, so every notification is delivered at least once; failed
+// attempts are retried with exponential backoff until MaxAttempts.
+type Dispatcher struct {
+	outbox Outbox
+	client *http.Client
+	opts   DispatcherOptions
+	nowFn  func() time.Time
+}
+
+// NewDispatcher creates a dispatcher for outbox.
+func NewDispatcher(outbox Outbox, opts DispatcherOptions) *Dispatcher {
+	return &Dispatcher{
+		outbox: outbox,
+		client: &http.Client{Timeout: opts.Timeout},
+		opts:   opts,
+		nowFn:  time.Now,
+	}
+}
+
+// Start polls the outbox until ctx is cancelled.
+func (d *Dispatcher) Start(ctx context.Context) error {
+	ticker := time.NewTicker(d.opts.PollInterval)
+	defer ticker.Stop()
+
+	slog.Info("[Webhooks] Starting webhook dispatcher",
+		"poll_interval", d.opts.PollInterval,
+		"timeout", d.opts.Timeout,
+		"max_attempts", d.opts.MaxAttempts,
+	)
+
+	for {
+		select {
+		case <-ticker.C:
+			// Keep dispatching while full batches are due.
+			for {
+				sent, err := d.DispatchDue(ctx)
+				if err != nil {
+					slog.Error("[Webhooks] Dispatch failed", "error", err)
+					break
+				}
+				if sent < dispatchBatchSize {
+					break
+				}
+			}
+		case <-ctx.Done():
+			slog.Info("[Webhooks] Stopping (context cancelled)")
+			return nil
+		}
+	}
+}
+
+// DispatchDue claims the deliveries due now and attempts each once. It returns the
+// number of deliveries attempted.
+func (d *Dispatcher) DispatchDue(ctx context.Context) (int, error) {
+	// A claim outlives the attempts of one batch, so deliveries are not sent twice
+	// concurrently unless a dispatcher stalls.
+	lease := time.Duration(dispatchBatchSize)*d.opts.Timeout + time.Minute
+	deliveries, err := d.outbox.ClaimDeliveries(ctx, d.nowFn(), dispatchBatchSize, lease)
+	if err != nil {
+		return 0, fmt.Errorf("claim deliveries: %w", err)
+	}
+
+	for _, delivery := range deliveries {
+		if err := ctx.Err(); err != nil {
+			// Unattempted deliveries are handed back when their claim expires.
+			return 0, err
+		}
+		if err := d.deliver(ctx, delivery); err != nil {
+			slog.Error("[Webhooks] Failed to record delivery outcome", "delivery_id", delivery.ID, "error", err)
+		}
+	}
+	return len(deliveries), nil
+}
+
+// deliver attempts one delivery and records the outcome in the outbox.
+func (d *Dispatcher) deliver(ctx context.Context, delivery threshold.Delivery) error {
+	sendErr := d.send(ctx, delivery)
+	now := d.nowFn()
+	if sendErr == nil {
+		return d.outbox.CompleteDelivery(ctx, delivery.ID, now)
+	}
+
+	attempts := delivery.Attempts + 1
+	if attempts >= d.opts.MaxAttempts {
+		slog.Warn("[Webhooks] Giving up on delivery",
+			"delivery_id", delivery.ID,
+			"url", delivery.URL,
+			"attempts", attempts,
+			"error", sendErr,
+		)
+		return d.outbox.FailDelivery(ctx, delivery.ID, now, sendErr.Error())
+	}
+
+	next := now.Add(retryDelay(attempts))
+	slog.Warn("[Webhooks] Delivery failed, will retry",
+		"delivery_id", delivery.ID,
+		"url", delivery.URL,
+		"attempts", attempts,
+		"next_attempt_at", next,
+		"error", sendErr,
+	)
+	return d.outbox.RetryDelivery(ctx, delivery.ID, next, sendErr.Error())
+}
+
+func (d *Dispatcher) send(ctx context.Context, delivery threshold.Delivery) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "aevon-webhooks")
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(SignatureHeader, Sign(d.opts.Secret, d.nowFn(), delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodyBytes))
+		return fmt.Errorf("receiver responded %d: %s", resp.StatusCode, bytes.TrimSpace(body))
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// retryDelay is the backoff before attempt+1: 5s, 10s, 20s, ... capped at one hour.
+func retryDelay(attempt int) time.Duration {
+	delay := retryBaseDelay
+	for i := 1; i < attempt && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		return maxRetryDelay
+	}
+	return delay
+}
@@ -0,0 +1,169 @@
+package threshold
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aevon-lab/project-aevon/internal/core/threshold"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+)
+
+var testSecret = []byte("whsec-test")
+
+// receiver is a local webhook endpoint that fails the first failures requests.
+type receiver struct {
+	mu         sync.Mutex
+	failures   int
+	bodies     [][]byte
+	deliveries []string
+	signErrs   []error
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.bodies = append(r.bodies, body)
+	r.deliveries = append(r.deliveries, req.Header.Get(DeliveryHeader))
+	r.signErrs = append(r.signErrs, Verify(testSecret, req.Header.Get(SignatureHeader), body, time.Now(), time.Minute))
+	if r.failures > 0 {
+		r.failures--
+		http.Error(w, "try again", http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func enqueue(t *testing.T, store *memoryStore, url string, crossedAt time.Time) {
+	recorded, err := store.RecordCrossing(context.Background(), threshold.Crossing{
+		ThresholdID: "thr-1",
+		PeriodKey:   crossedAt.Format(time.RFC3339),
+		Value:       decimal.NewFromInt(1),
+		CrossedAt:   crossedAt,
+	}, url, []byte(`{"event_id":"thr-1/1","type":"threshold.crossed"}`))
+	require.NoError(t, err)
+	require.True(t, recorded)
+}
+
+func newTestDispatcher(store *memoryStore, now *time.Time, maxAttempts int) *Dispatcher {
+	dispatcher := NewDispatcher(store, DispatcherOptions{
+		Secret:       testSecret,
+		Timeout:      5 * time.Second,
+		PollInterval: time.Second,
+		MaxAttempts:  maxAttempts,
+	})
+	dispatcher.nowFn = func() time.Time { return *now }
+	return dispatcher
+}
+
+func TestDispatcher_DeliversSignedWebhook(t *testing.T) {
+	recv := &receiver{}
+	server := httptest.NewServer(recv)
+	defer server.Close()
+
+	now := time.Now().UTC()
+	store := newMemoryStore()
+	enqueue(t, store, server.URL, now)
+
+	sent, err := newTestDispatcher(store, &now, 3).DispatchDue(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 1, sent)
+
+	require.Len(t, recv.bodies, 1)
+	require.JSONEq(t, `{"event_id":"thr-1/1","type":"threshold.crossed"}`, string(recv.bodies[0]))
+	require.Equal(t, "1", recv.deliveries[0])
+	require.NoError(t, recv.signErrs[0])
+	require.False(t, store.entry(1).deliveredAt.IsZero())
+
+	// Delivered entries are not claimed again.
+	sent, err = newTestDispatcher(store, &now, 3).DispatchDue(context.Background())
+	require.NoError(t, err)
+	require.Zero(t, sent)
+}
+
+func TestDispatcher_RetriesWithBackoff(t *testing.T) {
+	recv := &receiver{failures: 2}
+	server := httptest.NewServer(recv)
+	defer server.Close()
+
+	now := time.Now().UTC()
+	store := newMemoryStore()
+	enqueue(t, store, server.URL, now)
+	dispatcher := newTestDispatcher(store, &now, 5)
+
+	_, err := dispatcher.DispatchDue(context.Background())
+	require.NoError(t, err)
+	entry := store.entry(1)
+	require.Equal(t, 1, entry.delivery.Attempts)
+	require.Equal(t, now.Add(retryBaseDelay), entry.nextAttemptAt)
+	require.Contains(t, entry.lastError, "503")
+
+	// Not due yet.
+	sent, err := dispatcher.DispatchDue(context.Background())
+	require.NoError(t, err)
+	require.Zero(t, sent)
+
+	now = now.Add(retryBaseDelay)
+	_, err = dispatcher.DispatchDue(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, now.Add(2*retryBaseDelay), store.entry(1).nextAttemptAt)
+
+	now = now.Add(2 * retryBaseDelay)
+	_, err = dispatcher.DispatchDue(context.Background())
+	require.NoError(t, err)
+	require.False(t, store.entry(1).deliveredAt.IsZero())
+	require.Len(t, recv.bodies, 3)
+	require.Equal(t, []string{"1", "1", "1"}, recv.deliveries)
+}
+
+func TestDispatcher_GivesUpAfterMaxAttempts(t *testing.T) {
+	recv := &receiver{failures: 10}
+	server := httptest.NewServer(recv)
+	defer server.Close()
+
+	now := time.Now().UTC()
+	store := newMemoryStore()
+	enqueue(t, store, server.URL, now)
+	dispatcher := newTestDispatcher(store, &now, 2)
+
+	_, err := dispatcher.DispatchDue(context.Background())
+	require.NoError(t, err)
+	now = now.Add(time.Hour)
+	_, err = dispatcher.DispatchDue(context.Background())
+	require.NoError(t, err)
+
+	entry := store.entry(1)
+	require.Equal(t, 2, entry.delivery.Attempts)
+	require.False(t, entry.failedAt.IsZero())
+	require.True(t, entry.deliveredAt.IsZero())
+
+	now = now.Add(time.Hour)
+	sent, err := dispatcher.DispatchDue(context.Background())
+	require.NoError(t, err)
+	require.Zero(t, sent)
+}
+
+func TestRetryDelay(t *testing.T) {
+	require.Equal(t, 5*time.Second, retryDelay(1))
+	require.Equal(t, 20*time.Second, retryDelay(3))
+	require.Equal(t, maxRetryDelay, retryDelay(30))
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Date(2026, 2, 7, 11, 0, 0, 0, time.UTC)
+	body := []byte(`{"type":"threshold.crossed"}`)
+	header := Sign(testSecret, now, body)
+
+	require.NoError(t, Verify(testSecret, header, body, now.Add(10*time.Second), time.Minute))
+	require.ErrorIs(t, Verify([]byte("other"), header, body, now, time.Minute), ErrInvalidSignature)
+	require.ErrorIs(t, Verify(testSecret, header, []byte(`{}`), now, time.Minute), ErrInvalidSignature)
+	require.ErrorIs(t, Verify(testSecret, header, body, now.Add(time.Hour), time.Minute), ErrInvalidSignature)
+	require.ErrorIs(t, Verify(testSecret, "v1=abc", body, now, time.Minute), ErrInvalidSignature)
+}
@@ -0,0 +1,136 @@
+package threshold
+
+import (
+	"errors"
+	"net/http"
+
+	httperr "github.com/aevon-lab/project-aevon/internal/core/errors"
+	"github.com/aevon-lab/project-aevon/internal/core/threshold"
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+)
+
+// CreateThresholdRequest is the request body of POST /v1/thresholds.
+type CreateThresholdRequest struct {
+	ID          string          `json:"id"` // optional; generated if empty
+	Rule        string          `json:"rule"`
+	PrincipalID string          `json:"principal_id"`
+	Period      string          `json:"period"`
+	Timezone    string          `json:"timezone"`
+	Value       decimal.Decimal `json:"value"`
+	URL         string          `json:"url"`
+}
+
+// ListThresholdsResponse is the response of GET /v1/thresholds.
+type ListThresholdsResponse struct {
+	Thresholds []threshold.Threshold `json:"thresholds"`
+}
+
+// RegisterRoutes registers the threshold API routes on the given router.
+func (s *Service) RegisterRoutes(r gin.IRouter) {
+	r.POST("/v1/thresholds", s.HandleCreateThreshold)
+	r.GET("/v1/thresholds", s.HandleListThresholds)
+	r.DELETE("/v1/thresholds/:id", s.HandleDeleteThreshold)
+}
+
+// HandleCreateThreshold handles POST /v1/thresholds
+func (s *Service) HandleCreateThreshold(c *gin.Context) {
+	var req CreateThresholdRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, httperr.ErrorResponse{
+			ErrorType: httperr.HttpInvalidJsonError,
+			Message:   "Invalid request body",
+			Details:   err.Error(),
+		})
+		return
+	}
+
+	created, err := s.CreateThreshold(c.Request.Context(), threshold.Threshold{
+		ID:          req.ID,
+		Rule:        req.Rule,
+		PrincipalID: req.PrincipalID,
+		Period:      req.Period,
+		Timezone:    req.Timezone,
+		Value:       req.Value,
+		URL:         req.URL,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrInvalidThreshold):
+			c.JSON(http.StatusBadRequest, httperr.ErrorResponse{
+				ErrorType: httperr.HttpThresholdValidationError,
+				Message:   "Invalid threshold",
+				Details:   err.Error(),
+			})
+		case errors.Is(err, threshold.ErrThresholdExists):
+			c.JSON(http.StatusConflict, httperr.ErrorResponse{
+				ErrorType: httperr.HttpThresholdConflictError,
+				Message:   "Threshold already exists",
+				Details:   map[string]interface{}{"id": req.ID},
+			})
+		default:
+			c.JSON(http.StatusInternalServerError, httperr.ErrorResponse{
+				ErrorType: httperr.HttpInternalError,
+				Message:   "Failed to create threshold",
+				Details:   err.Error(),
+			})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, created)
+}
+
+// HandleListThresholds handles GET /v1/thresholds
+// Query parameters: rule, principal_id
+func (s *Service) HandleListThresholds(c *gin.Context) {
+	thresholds, err := s.ListThresholds(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, httperr.ErrorResponse{
+			ErrorType: httperr.HttpInternalError,
+			Message:   "Failed to list thresholds",
+			Details:   err.Error(),
+		})
+		return
+	}
+
+	rule, principalID := c.Query("rule"), c.Query("principal_id")
+	filtered := make([]threshold.Threshold, 0, len(thresholds))
+	for _, t := range thresholds {
+		if (rule == "" || t.Rule == rule) && (principalID == "" || t.PrincipalID == principalID) {
+			filtered = append(filtered, t)
+		}
+	}
+
+	c.JSON(http.StatusOK, ListThresholdsResponse{Thresholds: filtered})
+}
+
+// HandleDeleteThreshold handles DELETE /v1/thresholds/:id
+func (s *Service) HandleDeleteThreshold(c *gin.Context) {
+	id := c.Param("id")
+	if err := s.DeleteThreshold(c.Request.Context(), id); err != nil {
+		switch {
+		case errors.Is(err, threshold.ErrThresholdNotFound):
+			c.JSON(http.StatusNotFound, httperr.ErrorResponse{
+				ErrorType: httperr.HttpThresholdNotFoundError,
+				Message:   "Threshold not found",
+				Details:   map[string]interface{}{"id": id},
+			})
+		case errors.Is(err, threshold.ErrThresholdExists):
+			c.JSON(http.StatusConflict, httperr.ErrorResponse{
+				ErrorType: httperr.HttpThresholdConflictError,
+				Message:   "Threshold is declared in config and cannot be deleted",
+				Details:   map[string]interface{}{"id": id},
+			})
+		default:
+			c.JSON(http.StatusInternalServerError, httperr.ErrorResponse{
+				ErrorType: httperr.HttpInternalError,
+				Message:   "Failed to delete threshold",
+				Details:   err.Error(),
+			})
+		}
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
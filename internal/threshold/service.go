@@ -0,0 +1,471 @@
+package threshold
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	coreagg "github.com/aevon-lab/project-aevon/internal/core/aggregation"
+	"github.com/aevon-lab/project-aevon/internal/core/config"
+	"github.com/aevon-lab/project-aevon/internal/core/threshold"
+	"github.com/aevon-lab/project-aevon/internal/projection"
+	"github.com/shopspring/decimal"
+)
+
+// ErrInvalidThreshold is returned for threshold definitions that cannot be evaluated.
+var ErrInvalidThreshold = errors.New("invalid threshold")
+
+const (
+	// rollingPeriodKey is the crossing period of rolling thresholds, which re-arm instead
+	// of starting a new period.
+	rollingPeriodKey = "rolling"
+
+	// maxPendingScopes caps the flushed scopes waiting for evaluation. Scopes flushed while
+	// the queue is full are dropped; they are evaluated again on their next flush.
+	maxPendingScopes = 100000
+
+	// maxBatchPrincipals caps the principals of one batch state query.
+	maxBatchPrincipals = 1000
+)
+
+// StateReader computes the state of principals and rules, including the raw tail.
+// It is implemented by *projection.Service.
+type StateReader interface {
+	QueryAggregatesBatch(ctx context.Context, req projection.BatchQueryRequest) (*projection.BatchQueryResponse, error)
+}
+
+// Service manages thresholds and evaluates them after every scheduler flush. Thresholds
+// come from config (static, read-only) and from the API (stored in Store); crossings of
+// both are recorded in Store together with their webhook delivery.
+type Service struct {
+	store  Store
+	state  StateReader
+	static []threshold.Threshold
+	nowFn  func() time.Time
+
+	mu    sync.RWMutex // guards rules, which are swapped on rule reload
+	rules map[string]coreagg.AggregationRule
+
+	pendingMu sync.Mutex // guards pending
+	pending   map[coreagg.RangeScope]struct{}
+	wake      chan struct{} // signals Start that pending gained scopes
+}
+
+// NewService creates a threshold service. static are the thresholds declared in config;
+// they must have been validated with ValidateThreshold.
+func NewService(store Store, state StateReader, static []threshold.Threshold, rules []coreagg.AggregationRule) *Service {
+	s := &Service{
+		store:   store,
+		state:   state,
+		static:  static,
+		nowFn:   time.Now,
+		pending: make(map[coreagg.RangeScope]struct{}),
+		wake:    make(chan struct{}, 1),
+	}
+	s.SetRules(rules)
+	return s
+}
+
+// SetRules replaces the rules thresholds are evaluated against.
+func (s *Service) SetRules(rules []coreagg.AggregationRule) {
+	ruleMap := make(map[string]coreagg.AggregationRule, len(rules))
+	for _, rule := range rules {
+		ruleMap[rule.Name] = rule
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rules = ruleMap
+}
+
+func (s *Service) rule(name string) (coreagg.AggregationRule, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	rule, ok := s.rules[name]
+	return rule, ok
+}
+
+// ValidateThreshold checks that t can be evaluated: required fields are set, the period
+// and timezone resolve, the value is positive and the URL is an absolute http(s) URL.
+// The rule itself is checked with validateRule once it is known.
+func ValidateThreshold(t threshold.Threshold) error {
+	switch {
+	case strings.TrimSpace(t.ID) == "":
+		return fmt.Errorf("%w: id is required", ErrInvalidThreshold)
+	case t.Rule == "":
+		return fmt.Errorf("%w: rule is required", ErrInvalidThreshold)
+	case t.PrincipalID == "":
+		return fmt.Errorf("%w: principal_id is required", ErrInvalidThreshold)
+	case !t.Value.IsPositive():
+		return fmt.Errorf("%w: value must be greater than 0", ErrInvalidThreshold)
+	}
+	if _, _, err := projection.ResolvePeriod(t.Period, t.Timezone, time.Minute, time.Now()); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidThreshold, err)
+	}
+	target, err := url.Parse(t.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return fmt.Errorf("%w: url must be an absolute http or https URL", ErrInvalidThreshold)
+	}
+	return nil
+}
+
+// validateRule checks that t can be evaluated against rule: the rule measures usage that
+// only grows, so crossing a limit means something, and the period resolves for its buckets.
+func validateRule(t threshold.Threshold, rule coreagg.AggregationRule, now time.Time) error {
+	if !projection.AdditiveOperator(rule.Operator) {
+		return fmt.Errorf("%w: rule %s uses %s; thresholds need a count, sum or count_distinct rule",
+			ErrInvalidThreshold, rule.Name, rule.Operator)
+	}
+	if _, _, err := projection.ResolvePeriod(t.Period, t.Timezone, rule.Buckets()[0], now); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidThreshold, err)
+	}
+	return nil
+}
+
+// StaticThresholds converts and validates the thresholds declared in config. Thresholds
+// of a rule in rules are also checked against it; others are checked when evaluated.
+func StaticThresholds(configs []config.ThresholdConfig, rules []coreagg.AggregationRule) ([]threshold.Threshold, error) {
+	ruleMap := make(map[string]coreagg.AggregationRule, len(rules))
+	for _, rule := range rules {
+		ruleMap[rule.Name] = rule
+	}
+	thresholds := make([]threshold.Threshold, 0, len(configs))
+	for _, cfg := range configs {
+		value, err := decimal.NewFromString(cfg.Value)
+		if err != nil {
+			return nil, fmt.Errorf("threshold %s: %w: invalid value %q", cfg.ID, ErrInvalidThreshold, cfg.Value)
+		}
+		t := threshold.Threshold{
+			ID:          cfg.ID,
+			Rule:        cfg.Rule,
+			PrincipalID: cfg.PrincipalID,
+			Period:      cfg.Period,
+			Timezone:    cfg.Timezone,
+			Value:       value,
+			URL:         cfg.URL,
+			Source:      threshold.SourceConfig,
+		}
+		if err := ValidateThreshold(t); err != nil {
+			return nil, fmt.Errorf("threshold %s: %w", cfg.ID, err)
+		}
+		if rule, ok := ruleMap[t.Rule]; ok {
+			if err := validateRule(t, rule, time.Now()); err != nil {
+				return nil, fmt.Errorf("threshold %s: %w", cfg.ID, err)
+			}
+		}
+		thresholds = append(thresholds, t)
+	}
+	return thresholds, nil
+}
+
+// CreateThreshold validates and stores a threshold registered through the API. An empty
+// ID is generated.
+func (s *Service) CreateThreshold(ctx context.Context, t threshold.Threshold) (threshold.Threshold, error) {
+	if t.ID == "" {
+		t.ID = newThresholdID()
+	}
+	t.Source = threshold.SourceAPI
+	t.CreatedAt = s.nowFn().UTC()
+
+	if err := ValidateThreshold(t); err != nil {
+		return threshold.Threshold{}, err
+	}
+	rule, ok := s.rule(t.Rule)
+	if !ok {
+		return threshold.Threshold{}, fmt.Errorf("%w: unknown rule: %s", ErrInvalidThreshold, t.Rule)
+	}
+	if err := validateRule(t, rule, s.nowFn()); err != nil {
+		return threshold.Threshold{}, err
+	}
+	if _, ok := s.staticThreshold(t.ID); ok {
+		return threshold.Threshold{}, threshold.ErrThresholdExists
+	}
+	if err := s.store.CreateThreshold(ctx, t); err != nil {
+		return threshold.Threshold{}, err
+	}
+	return t, nil
+}
+
+// DeleteThreshold removes a threshold registered through the API. Thresholds declared in
+// config cannot be deleted at runtime and fail with threshold.ErrThresholdExists.
+func (s *Service) DeleteThreshold(ctx context.Context, id string) error {
+	if _, ok := s.staticThreshold(id); ok {
+		return threshold.ErrThresholdExists
+	}
+	return s.store.DeleteThreshold(ctx, id)
+}
+
+// ListThresholds returns the thresholds from config and from the store, ordered by ID.
+func (s *Service) ListThresholds(ctx context.Context) ([]threshold.Threshold, error) {
+	stored, err := s.store.ListThresholds(ctx)
+	if err != nil {
+		return nil, err
+	}
+	all := append(append([]threshold.Threshold{}, s.static...), stored...)
+	sort.Slice(all, func(i, j int) bool { return all[i].ID < all[j].ID })
+	return all, nil
+}
+
+func (s *Service) staticThreshold(id string) (threshold.Threshold, bool) {
+	for _, t := range s.static {
+		if t.ID == id {
+			return t, true
+		}
+	}
+	return threshold.Threshold{}, false
+}
+
+// AfterFlush queues the flushed scopes for evaluation by Start and returns at once, so
+// slow evaluation never holds up aggregation. Only flushes of a rule's finest bucket size
+// are considered, so every rule is evaluated once per batch of events. Scopes already
+// queued are evaluated once.
+func (s *Service) AfterFlush(_ context.Context, bucketLabel string, scopes []coreagg.RangeScope) {
+	dropped := 0
+	s.pendingMu.Lock()
+	for _, scope := range scopes {
+		rule, ok := s.rule(scope.RuleName)
+		if !ok || coreagg.BucketLabel(rule.Buckets()[0]) != bucketLabel {
+			continue
+		}
+		if _, queued := s.pending[scope]; !queued && len(s.pending) >= maxPendingScopes {
+			dropped++
+			continue
+		}
+		s.pending[scope] = struct{}{}
+	}
+	queued := len(s.pending) > 0
+	s.pendingMu.Unlock()
+
+	if dropped > 0 {
+		slog.Warn("[Thresholds] Evaluation queue full; scopes are evaluated on their next flush",
+			"bucket_size", bucketLabel,
+			"dropped", dropped,
+		)
+	}
+	if queued {
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Start evaluates the queued scopes until ctx is cancelled.
+func (s *Service) Start(ctx context.Context) error {
+	for {
+		select {
+		case <-s.wake:
+			s.evaluatePending(ctx)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// evaluatePending evaluates the thresholds of the queued scopes. Values come from
+// QueryAggregatesBatch and include the raw tail past the checkpoint; thresholds of the
+// same rule and period share one query. Failures are logged.
+func (s *Service) evaluatePending(ctx context.Context) {
+	s.pendingMu.Lock()
+	pending := s.pending
+	s.pending = make(map[coreagg.RangeScope]struct{})
+	s.pendingMu.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+
+	scopes := make([]coreagg.RangeScope, 0, len(pending))
+	for scope := range pending {
+		scopes = append(scopes, scope)
+	}
+	sort.Slice(scopes, func(i, j int) bool {
+		if scopes[i].RuleName != scopes[j].RuleName {
+			return scopes[i].RuleName < scopes[j].RuleName
+		}
+		return scopes[i].PrincipalID < scopes[j].PrincipalID
+	})
+
+	thresholds, err := s.store.ListThresholdsFor(ctx, scopes)
+	if err != nil {
+		slog.Error("[Thresholds] Failed to list thresholds", "scopes", len(scopes), "error", err)
+		return
+	}
+	for _, t := range s.static {
+		if _, ok := pending[coreagg.RangeScope{PrincipalID: t.PrincipalID, RuleName: t.Rule}]; ok {
+			thresholds = append(thresholds, t)
+		}
+	}
+
+	now := s.nowFn()
+	groups := make(map[valueKey][]threshold.Threshold)
+	var keys []valueKey
+	for _, t := range thresholds {
+		rule, ok := s.rule(t.Rule)
+		if !ok {
+			logEvaluationError(t, fmt.Errorf("unknown rule: %s", t.Rule))
+			continue
+		}
+		if !projection.AdditiveOperator(rule.Operator) {
+			// The rule was reloaded with an operator thresholds cannot be evaluated for.
+			logEvaluationError(t, fmt.Errorf("rule %s uses %s; thresholds need a count, sum or count_distinct rule", rule.Name, rule.Operator))
+			continue
+		}
+		start, end, err := projection.ResolvePeriod(t.Period, t.Timezone, rule.Buckets()[0], now)
+		if err != nil {
+			logEvaluationError(t, err)
+			continue
+		}
+		key := valueKey{rule: t.Rule, start: start, end: end}
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], t)
+	}
+
+	for _, key := range keys {
+		group := groups[key]
+		states, err := s.queryStates(ctx, key, group)
+		if err != nil {
+			for _, t := range group {
+				logEvaluationError(t, fmt.Errorf("query state: %w", err))
+			}
+			continue
+		}
+		for _, t := range group {
+			if err := s.evaluate(ctx, t, key.start, key.end, now, states[t.PrincipalID]); err != nil {
+				logEvaluationError(t, err)
+			}
+		}
+	}
+}
+
+// valueKey identifies one batch state query shared by thresholds of the same rule and period.
+type valueKey struct {
+	rule       string
+	start, end time.Time
+}
+
+// queryStates reads the total of key's rule and period for the principals of thresholds,
+// keyed by principal.
+func (s *Service) queryStates(ctx context.Context, key valueKey, thresholds []threshold.Threshold) (map[string]*projection.AggregateQueryResponse, error) {
+	var principals []string
+	seen := make(map[string]bool, len(thresholds))
+	for _, t := range thresholds {
+		if !seen[t.PrincipalID] {
+			seen[t.PrincipalID] = true
+			principals = append(principals, t.PrincipalID)
+		}
+	}
+
+	states := make(map[string]*projection.AggregateQueryResponse, len(principals))
+	for len(principals) > 0 {
+		chunk := principals[:min(len(principals), maxBatchPrincipals)]
+		principals = principals[len(chunk):]
+
+		resp, err := s.state.QueryAggregatesBatch(ctx, projection.BatchQueryRequest{
+			Principals:  chunk,
+			Rules:       []string{key.rule},
+			Start:       key.start,
+			End:         key.end,
+			Granularity: "total",
+		})
+		if err != nil {
+			return nil, err
+		}
+		for principalID, byRule := range resp.Results {
+			states[principalID] = byRule[key.rule]
+		}
+	}
+	return states, nil
+}
+
+func logEvaluationError(t threshold.Threshold, err error) {
+	slog.Error("[Thresholds] Failed to evaluate threshold",
+		"threshold_id", t.ID,
+		"rule", t.Rule,
+		"principal_id", t.PrincipalID,
+		"error", err,
+	)
+}
+
+func (s *Service) evaluate(ctx context.Context, t threshold.Threshold, start, end, now time.Time, state *projection.AggregateQueryResponse) error {
+	if state == nil {
+		return fmt.Errorf("no state returned for principal %s", t.PrincipalID)
+	}
+
+	periodKey := start.Format(time.RFC3339)
+	if isRollingPeriod(t.Period) {
+		periodKey = rollingPeriodKey
+	}
+
+	value := decimal.Zero
+	if len(state.Values) > 0 {
+		value = state.Values[0].Value
+	}
+	if value.LessThan(t.Value) {
+		if periodKey == rollingPeriodKey {
+			return s.store.ClearCrossing(ctx, t.ID, periodKey)
+		}
+		return nil
+	}
+
+	crossedAt := now.UTC()
+	payload, err := json.Marshal(threshold.Notification{
+		EventID:     t.ID + "/" + periodKey + "/" + crossedAt.Format(time.RFC3339Nano),
+		Type:        threshold.EventThresholdCrossed,
+		ThresholdID: t.ID,
+		Rule:        t.Rule,
+		PrincipalID: t.PrincipalID,
+		Period:      t.Period,
+		Timezone:    t.Timezone,
+		Threshold:   t.Value,
+		Value:       value,
+		PeriodStart: start,
+		PeriodEnd:   end,
+		CrossedAt:   crossedAt,
+		DataThrough: state.DataThrough,
+	})
+	if err != nil {
+		return fmt.Errorf("encode notification: %w", err)
+	}
+
+	recorded, err := s.store.RecordCrossing(ctx, threshold.Crossing{
+		ThresholdID: t.ID,
+		PeriodKey:   periodKey,
+		Value:       value,
+		CrossedAt:   crossedAt,
+	}, t.URL, payload)
+	if err != nil {
+		return fmt.Errorf("record crossing: %w", err)
+	}
+	if recorded {
+		slog.Info("[Thresholds] Threshold crossed",
+			"threshold_id", t.ID,
+			"rule", t.Rule,
+			"principal_id", t.PrincipalID,
+			"period", t.Period,
+			"value", value.String(),
+			"threshold", t.Value.String(),
+		)
+	}
+	return nil
+}
+
+func isRollingPeriod(period string) bool {
+	return strings.HasPrefix(period, "last_")
+}
+
+func newThresholdID() string {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return "thr_" + hex.EncodeToString(b[:])
+}
@@ -0,0 +1,407 @@
+package threshold
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	coreagg "github.com/aevon-lab/project-aevon/internal/core/aggregation"
+	"github.com/aevon-lab/project-aevon/internal/core/config"
+	"github.com/aevon-lab/project-aevon/internal/core/threshold"
+	"github.com/aevon-lab/project-aevon/internal/projection"
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+)
+
+type outboxEntry struct {
+	delivery      threshold.Delivery
+	nextAttemptAt time.Time
+	deliveredAt   time.Time
+	failedAt      time.Time
+	lastError     string
+}
+
+// memoryStore is an in-memory Store and Outbox.
+type memoryStore struct {
+	mu         sync.Mutex
+	thresholds map[string]threshold.Threshold
+	crossings  map[string]threshold.Crossing // by threshold ID + period key
+	outbox     []*outboxEntry
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		thresholds: make(map[string]threshold.Threshold),
+		crossings:  make(map[string]threshold.Crossing),
+	}
+}
+
+func (m *memoryStore) CreateThreshold(_ context.Context, t threshold.Threshold) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.thresholds[t.ID]; ok {
+		return threshold.ErrThresholdExists
+	}
+	m.thresholds[t.ID] = t
+	return nil
+}
+
+func (m *memoryStore) DeleteThreshold(_ context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.thresholds[id]; !ok {
+		return threshold.ErrThresholdNotFound
+	}
+	delete(m.thresholds, id)
+	return nil
+}
+
+func (m *memoryStore) ListThresholds(_ context.Context) ([]threshold.Threshold, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var thresholds []threshold.Threshold
+	for _, t := range m.thresholds {
+		thresholds = append(thresholds, t)
+	}
+	sort.Slice(thresholds, func(i, j int) bool { return thresholds[i].ID < thresholds[j].ID })
+	return thresholds, nil
+}
+
+func (m *memoryStore) ListThresholdsFor(ctx context.Context, scopes []coreagg.RangeScope) ([]threshold.Threshold, error) {
+	all, _ := m.ListThresholds(ctx)
+	var thresholds []threshold.Threshold
+	for _, t := range all {
+		for _, scope := range scopes {
+			if scope.RuleName == t.Rule && scope.PrincipalID == t.PrincipalID {
+				thresholds = append(thresholds, t)
+				break
+			}
+		}
+	}
+	return thresholds, nil
+}
+
+func (m *memoryStore) RecordCrossing(_ context.Context, crossing threshold.Crossing, url string, payload []byte) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := crossing.ThresholdID + "/" + crossing.PeriodKey
+	if _, ok := m.crossings[key]; ok {
+		return false, nil
+	}
+	m.crossings[key] = crossing
+	m.outbox = append(m.outbox, &outboxEntry{
+		delivery:      threshold.Delivery{ID: int64(len(m.outbox) + 1), URL: url, Payload: payload, CreatedAt: crossing.CrossedAt},
+		nextAttemptAt: crossing.CrossedAt,
+	})
+	return true, nil
+}
+
+func (m *memoryStore) ClearCrossing(_ context.Context, thresholdID string, periodKey string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.crossings, thresholdID+"/"+periodKey)
+	return nil
+}
+
+func (m *memoryStore) ClaimDeliveries(_ context.Context, now time.Time, limit int, lease time.Duration) ([]threshold.Delivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var deliveries []threshold.Delivery
+	for _, entry := range m.outbox {
+		if len(deliveries) == limit {
+			break
+		}
+		if entry.deliveredAt.IsZero() && entry.failedAt.IsZero() && !entry.nextAttemptAt.After(now) {
+			entry.nextAttemptAt = now.Add(lease)
+			deliveries = append(deliveries, entry.delivery)
+		}
+	}
+	return deliveries, nil
+}
+
+func (m *memoryStore) entry(id int64) *outboxEntry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.outbox[id-1]
+}
+
+func (m *memoryStore) CompleteDelivery(_ context.Context, id int64, deliveredAt time.Time) error {
+	m.entry(id).deliveredAt = deliveredAt
+	return nil
+}
+
+func (m *memoryStore) RetryDelivery(_ context.Context, id int64, nextAttemptAt time.Time, lastError string) error {
+	entry := m.entry(id)
+	entry.delivery.Attempts++
+	entry.nextAttemptAt = nextAttemptAt
+	entry.lastError = lastError
+	return nil
+}
+
+func (m *memoryStore) FailDelivery(_ context.Context, id int64, failedAt time.Time, lastError string) error {
+	entry := m.entry(id)
+	entry.delivery.Attempts++
+	entry.failedAt = failedAt
+	entry.lastError = lastError
+	return nil
+}
+
+// fakeState serves a fixed total per principal.
+type fakeState struct {
+	values  map[string]int64
+	queries []projection.BatchQueryRequest
+}
+
+func (f *fakeState) QueryAggregatesBatch(_ context.Context, req projection.BatchQueryRequest) (*projection.BatchQueryResponse, error) {
+	f.queries = append(f.queries, req)
+	resp := &projection.BatchQueryResponse{Results: make(map[string]map[string]*projection.AggregateQueryResponse)}
+	for _, principalID := range req.Principals {
+		resp.Results[principalID] = make(map[string]*projection.AggregateQueryResponse)
+		for _, rule := range req.Rules {
+			state := &projection.AggregateQueryResponse{PrincipalID: principalID, Rule: rule, DataThrough: req.Start}
+			if value, ok := f.values[principalID]; ok {
+				state.Values = []projection.AggregateValue{{WindowStart: req.Start, WindowEnd: req.End, Value: decimal.NewFromInt(value)}}
+			}
+			resp.Results[principalID][rule] = state
+		}
+	}
+	return resp, nil
+}
+
+var testRules = []coreagg.AggregationRule{{
+	Name:        "sum_tokens",
+	SourceEvent: "llm.call",
+	Operator:    coreagg.OpSum,
+	Field:       "tokens",
+	BucketSizes: []time.Duration{time.Minute, time.Hour},
+}, {
+	Name:        "p95_latency",
+	SourceEvent: "llm.call",
+	Operator:    coreagg.OpQuantile,
+	Field:       "latency_ms",
+}}
+
+func newTestService(t *testing.T, state *fakeState, static ...threshold.Threshold) (*Service, *memoryStore) {
+	store := newMemoryStore()
+	svc := NewService(store, state, static, testRules)
+	svc.nowFn = func() time.Time { return time.Date(2026, 2, 7, 11, 20, 0, 0, time.UTC) }
+	return svc, store
+}
+
+func TestService_AfterFlush_RecordsCrossingOncePerPeriod(t *testing.T) {
+	state := &fakeState{values: map[string]int64{"user-1": 1200, "user-2": 10}}
+	svc, store := newTestService(t, state, threshold.Threshold{
+		ID:          "cfg-monthly",
+		Rule:        "sum_tokens",
+		PrincipalID: "user-1",
+		Period:      projection.PeriodCurrentMonth,
+		Value:       decimal.NewFromInt(1000),
+		URL:         "https://example.com/hook",
+		Source:      threshold.SourceConfig,
+	})
+	_, err := svc.CreateThreshold(context.Background(), threshold.Threshold{
+		ID:          "api-low",
+		Rule:        "sum_tokens",
+		PrincipalID: "user-2",
+		Period:      projection.PeriodCurrentMonth,
+		Value:       decimal.NewFromInt(100),
+		URL:         "https://example.com/hook",
+	})
+	require.NoError(t, err)
+
+	scopes := []coreagg.RangeScope{
+		{PrincipalID: "user-1", RuleName: "sum_tokens"},
+		{PrincipalID: "user-2", RuleName: "sum_tokens"},
+	}
+
+	// Coarser buckets do not trigger evaluation.
+	svc.AfterFlush(context.Background(), "1h", scopes)
+	svc.evaluatePending(context.Background())
+	require.Empty(t, state.queries)
+
+	// Flushes queue their scopes; thresholds of one rule and period share a batch query.
+	svc.AfterFlush(context.Background(), "1m", scopes)
+	svc.AfterFlush(context.Background(), "1m", scopes[:1])
+	require.Empty(t, state.queries)
+	svc.evaluatePending(context.Background())
+	require.Len(t, state.queries, 1)
+	require.ElementsMatch(t, []string{"user-1", "user-2"}, state.queries[0].Principals)
+	require.Equal(t, "total", state.queries[0].Granularity)
+	require.Equal(t, time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC), state.queries[0].Start)
+	require.Len(t, store.outbox, 1)
+
+	var notification threshold.Notification
+	require.NoError(t, json.Unmarshal(store.outbox[0].delivery.Payload, &notification))
+	require.Equal(t, threshold.EventThresholdCrossed, notification.Type)
+	require.Equal(t, "cfg-monthly", notification.ThresholdID)
+	require.Equal(t, "1200", notification.Value.String())
+	require.Equal(t, "1000", notification.Threshold.String())
+
+	// Still above the threshold in the same period: no second notification.
+	svc.AfterFlush(context.Background(), "1m", scopes)
+	svc.evaluatePending(context.Background())
+	require.Len(t, store.outbox, 1)
+}
+
+func TestService_StartEvaluatesQueuedScopes(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	svc, store := newTestService(t, &fakeState{values: map[string]int64{"user-1": 1200}}, threshold.Threshold{
+		ID:          "cfg-monthly",
+		Rule:        "sum_tokens",
+		PrincipalID: "user-1",
+		Period:      projection.PeriodCurrentMonth,
+		Value:       decimal.NewFromInt(1000),
+		URL:         "https://example.com/hook",
+		Source:      threshold.SourceConfig,
+	})
+	go func() { _ = svc.Start(ctx) }()
+
+	svc.AfterFlush(ctx, "1m", []coreagg.RangeScope{{PrincipalID: "user-1", RuleName: "sum_tokens"}})
+	require.Eventually(t, func() bool {
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		return len(store.outbox) == 1
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestService_AfterFlush_RollingPeriodRearms(t *testing.T) {
+	state := &fakeState{values: map[string]int64{"user-1": 500}}
+	svc, store := newTestService(t, state, threshold.Threshold{
+		ID:          "cfg-rolling",
+		Rule:        "sum_tokens",
+		PrincipalID: "user-1",
+		Period:      "last_1h",
+		Value:       decimal.NewFromInt(100),
+		URL:         "https://example.com/hook",
+		Source:      threshold.SourceConfig,
+	})
+	scopes := []coreagg.RangeScope{{PrincipalID: "user-1", RuleName: "sum_tokens"}}
+
+	svc.AfterFlush(context.Background(), "1m", scopes)
+	svc.evaluatePending(context.Background())
+	svc.AfterFlush(context.Background(), "1m", scopes)
+	svc.evaluatePending(context.Background())
+	require.Len(t, store.outbox, 1)
+
+	state.values["user-1"] = 50
+	svc.AfterFlush(context.Background(), "1m", scopes)
+	svc.evaluatePending(context.Background())
+	require.Empty(t, store.crossings)
+
+	state.values["user-1"] = 150
+	svc.AfterFlush(context.Background(), "1m", scopes)
+	svc.evaluatePending(context.Background())
+	require.Len(t, store.outbox, 2)
+}
+
+func TestService_CreateThreshold_Validation(t *testing.T) {
+	svc, _ := newTestService(t, &fakeState{}, threshold.Threshold{ID: "cfg-1"})
+
+	valid := threshold.Threshold{
+		Rule:        "sum_tokens",
+		PrincipalID: "user-1",
+		Period:      projection.PeriodCurrentDay,
+		Value:       decimal.NewFromInt(10),
+		URL:         "https://example.com/hook",
+	}
+	created, err := svc.CreateThreshold(context.Background(), valid)
+	require.NoError(t, err)
+	require.NotEmpty(t, created.ID)
+	require.Equal(t, threshold.SourceAPI, created.Source)
+
+	tests := []struct {
+		name   string
+		mutate func(*threshold.Threshold)
+	}{
+		{name: "unknown rule", mutate: func(t *threshold.Threshold) { t.Rule = "missing_rule" }},
+		{name: "non-additive rule", mutate: func(t *threshold.Threshold) { t.Rule = "p95_latency" }},
+		{name: "missing principal", mutate: func(t *threshold.Threshold) { t.PrincipalID = "" }},
+		{name: "invalid period", mutate: func(t *threshold.Threshold) { t.Period = "fortnight" }},
+		{name: "invalid timezone", mutate: func(t *threshold.Threshold) { t.Timezone = "Mars/Olympus" }},
+		{name: "zero value", mutate: func(t *threshold.Threshold) { t.Value = decimal.Zero }},
+		{name: "relative url", mutate: func(t *threshold.Threshold) { t.URL = "/hook" }},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := valid
+			tc.mutate(&req)
+			_, err := svc.CreateThreshold(context.Background(), req)
+			require.ErrorIs(t, err, ErrInvalidThreshold)
+		})
+	}
+
+	conflicting := valid
+	conflicting.ID = "cfg-1"
+	_, err = svc.CreateThreshold(context.Background(), conflicting)
+	require.ErrorIs(t, err, threshold.ErrThresholdExists)
+}
+
+func TestService_ThresholdRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	svc, _ := newTestService(t, &fakeState{}, threshold.Threshold{
+		ID:          "cfg-1",
+		Rule:        "sum_tokens",
+		PrincipalID: "user-9",
+		Source:      threshold.SourceConfig,
+	})
+	r := gin.New()
+	svc.RegisterRoutes(r)
+
+	body := `{"id":"thr-1","rule":"sum_tokens","principal_id":"user-1","period":"current_day","value":"100","url":"https://example.com/hook"}`
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/v1/thresholds", bytes.NewBufferString(body)))
+	require.Equal(t, http.StatusCreated, resp.Code)
+
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/v1/thresholds", bytes.NewBufferString(body)))
+	require.Equal(t, http.StatusConflict, resp.Code)
+
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/v1/thresholds?principal_id=user-1", nil))
+	require.Equal(t, http.StatusOK, resp.Code)
+	var listed ListThresholdsResponse
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &listed))
+	require.Len(t, listed.Thresholds, 1)
+	require.Equal(t, "thr-1", listed.Thresholds[0].ID)
+
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, httptest.NewRequest(http.MethodDelete, "/v1/thresholds/cfg-1", nil))
+	require.Equal(t, http.StatusConflict, resp.Code)
+
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, httptest.NewRequest(http.MethodDelete, "/v1/thresholds/thr-1", nil))
+	require.Equal(t, http.StatusNoContent, resp.Code)
+
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, httptest.NewRequest(http.MethodDelete, "/v1/thresholds/thr-1", nil))
+	require.Equal(t, http.StatusNotFound, resp.Code)
+}
+
+func TestStaticThresholds_RejectsNonAdditiveRule(t *testing.T) {
+	cfg := config.ThresholdConfig{
+		ID:          "cfg-1",
+		Rule:        "sum_tokens",
+		PrincipalID: "user-1",
+		Period:      projection.PeriodCurrentDay,
+		Value:       "10",
+		URL:         "https://example.com/hook",
+	}
+	thresholds, err := StaticThresholds([]config.ThresholdConfig{cfg}, testRules)
+	require.NoError(t, err)
+	require.Len(t, thresholds, 1)
+
+	cfg.Rule = "p95_latency"
+	_, err = StaticThresholds([]config.ThresholdConfig{cfg}, testRules)
+	require.ErrorIs(t, err, ErrInvalidThreshold)
+	require.ErrorContains(t, err, "uses quantile")
+}
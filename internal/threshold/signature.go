@@ -0,0 +1,67 @@
+package threshold
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Webhook request headers.
+const (
+	SignatureHeader = "X-Aevon-Signature" // t=<unix seconds>,v1=<hex HMAC-SHA256>
+	DeliveryHeader  = "X-Aevon-Delivery"  // outbox ID, stable across retries
+)
+
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+
+// Sign returns the X-Aevon-Signature value of body sent at timestamp: the HMAC-SHA256,
+// keyed by secret, of "<unix seconds>.<body>".
+func Sign(secret []byte, timestamp time.Time, body []byte) string {
+	unix := timestamp.Unix()
+	return fmt.Sprintf("t=%d,v1=%s", unix, hex.EncodeToString(signature(secret, unix, body)))
+}
+
+// Verify checks an X-Aevon-Signature header against body and rejects signatures older
+// than tolerance, which protects receivers against replays.
+func Verify(secret []byte, header string, body []byte, now time.Time, tolerance time.Duration) error {
+	var (
+		unix int64
+		sig  []byte
+		err  error
+	)
+	for _, part := range strings.Split(header, ",") {
+		name, value, _ := strings.Cut(part, "=")
+		switch name {
+		case "t":
+			if unix, err = strconv.ParseInt(value, 10, 64); err != nil {
+				return fmt.Errorf("%w: bad timestamp", ErrInvalidSignature)
+			}
+		case "v1":
+			if sig, err = hex.DecodeString(value); err != nil {
+				return fmt.Errorf("%w: bad signature encoding", ErrInvalidSignature)
+			}
+		}
+	}
+	if unix == 0 || sig == nil {
+		return fmt.Errorf("%w: missing timestamp or signature", ErrInvalidSignature)
+	}
+	if age := now.Sub(time.Unix(unix, 0)); age > tolerance || age < -tolerance {
+		return fmt.Errorf("%w: timestamp outside tolerance", ErrInvalidSignature)
+	}
+	if !hmac.Equal(sig, signature(secret, unix, body)) {
+		return fmt.Errorf("%w: signature mismatch", ErrInvalidSignature)
+	}
+	return nil
+}
+
+func signature(secret []byte, unix int64, body []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strconv.FormatInt(unix, 10) + "."))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,52 @@
+package threshold
+
+import (
+	"context"
+	"time"
+
+	coreagg "github.com/aevon-lab/project-aevon/internal/core/aggregation"
+	"github.com/aevon-lab/project-aevon/internal/core/threshold"
+)
+
+// Store persists thresholds registered through the API and the crossings of all
+// thresholds.
+type Store interface {
+	// CreateThreshold stores t, failing with threshold.ErrThresholdExists if its ID is taken.
+	CreateThreshold(ctx context.Context, t threshold.Threshold) error
+
+	// DeleteThreshold removes a threshold, failing with threshold.ErrThresholdNotFound if
+	// it does not exist. Deliveries already in the outbox are still sent.
+	DeleteThreshold(ctx context.Context, id string) error
+
+	// ListThresholds returns every stored threshold, ordered by ID.
+	ListThresholds(ctx context.Context) ([]threshold.Threshold, error)
+
+	// ListThresholdsFor returns the stored thresholds of the given (principal, rule) pairs.
+	ListThresholdsFor(ctx context.Context, scopes []coreagg.RangeScope) ([]threshold.Threshold, error)
+
+	// RecordCrossing inserts crossing and enqueues a delivery of payload to url in one
+	// transaction. It reports false, and enqueues nothing, if the threshold already
+	// crossed in crossing.PeriodKey.
+	RecordCrossing(ctx context.Context, crossing threshold.Crossing, url string, payload []byte) (bool, error)
+
+	// ClearCrossing re-arms a threshold for periodKey. Clearing a period that has no
+	// crossing is a no-op.
+	ClearCrossing(ctx context.Context, thresholdID string, periodKey string) error
+}
+
+// Outbox is the durable queue of webhook deliveries. A delivery stays in the outbox
+// until it is completed or failed, so it survives restarts of the dispatcher.
+type Outbox interface {
+	// ClaimDeliveries returns up to limit deliveries due at now and hides them from other
+	// claims until now+lease, so a dispatcher that dies mid-delivery hands them back.
+	ClaimDeliveries(ctx context.Context, now time.Time, limit int, lease time.Duration) ([]threshold.Delivery, error)
+
+	// CompleteDelivery marks a delivery as delivered.
+	CompleteDelivery(ctx context.Context, id int64, deliveredAt time.Time) error
+
+	// RetryDelivery records a failed attempt and schedules the next one at nextAttemptAt.
+	RetryDelivery(ctx context.Context, id int64, nextAttemptAt time.Time, lastError string) error
+
+	// FailDelivery records a failed attempt and gives up on the delivery.
+	FailDelivery(ctx context.Context, id int64, failedAt time.Time, lastError string) error
+}
@@ -0,0 +1,4 @@
+-- Rollback 002_add_composite_aggregate_state
+
+ALTER TABLE pre_aggregates DROP COLUMN IF EXISTS value_at;
+ALTER TABLE pre_aggregates DROP COLUMN IF EXISTS aux_sum;
@@ -0,0 +1,17 @@
+-- HyperLogLog sketch state for the count_distinct operator
+--
+-- Migration: 003_add_distinct_sketch
+-- Date: 2026-10-16
+--
+-- count_distinct keeps a serialized HLL sketch per bucket. Sketches are merged
+-- in the application (register-wise max) inside the flush transaction; value
+-- holds the sketch's distinct-count estimate.
+
+ALTER TABLE pre_aggregates
+    ADD COLUMN IF NOT EXISTS sketch BYTEA;
+
+COMMENT ON COLUMN pre_aggregates.operator IS
+    'Aggregation operator: count | sum | min | max | avg | first | last | count_distinct';
+
+COMMENT ON COLUMN pre_aggregates.sketch IS
+    'count_distinct only: serialized HyperLogLog sketch. value is its estimate. NULL for other operators.';
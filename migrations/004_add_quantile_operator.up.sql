@@ -0,0 +1,13 @@
+-- Quantile operator state
+--
+-- Migration: 004_add_quantile_operator
+-- Date: 2026-10-16
+--
+-- quantile reuses the sketch column for a serialized DDSketch; value holds the
+-- median. No structural change is needed, only the column documentation.
+
+COMMENT ON COLUMN pre_aggregates.operator IS
+    'Aggregation operator: count | sum | min | max | avg | first | last | count_distinct | quantile';
+
+COMMENT ON COLUMN pre_aggregates.sketch IS
+    'count_distinct: serialized HyperLogLog sketch, value is its estimate. quantile: serialized DDSketch, value is the median. NULL for other operators.';
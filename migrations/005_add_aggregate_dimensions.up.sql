@@ -0,0 +1,21 @@
+-- Group-by dimensions for pre-aggregates
+--
+-- Migration: 005_add_aggregate_dimensions
+-- Date: 2026-10-16
+--
+-- Rules with group_by keep one row per dimension tuple. The tuple is stored as a
+-- canonical JSON object (sorted keys) so it can be part of the primary key; rules
+-- without group_by use the empty string.
+
+ALTER TABLE pre_aggregates
+    ADD COLUMN IF NOT EXISTS dimensions TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE pre_aggregates DROP CONSTRAINT IF EXISTS pre_aggregates_pkey;
+ALTER TABLE pre_aggregates
+    ADD PRIMARY KEY (partition_id, principal_id, rule_name, bucket_size, window_start, dimensions);
+
+COMMENT ON TABLE pre_aggregates IS
+    'Pre-computed usage buckets. One row per (partition, principal, rule, bucket_size, window, dimensions).';
+
+COMMENT ON COLUMN pre_aggregates.dimensions IS
+    'Canonical JSON object of the rule group_by values, e.g. {"data.model":"gpt-4"}. Empty string for rules without group_by.';
@@ -0,0 +1,3 @@
+-- Rollback 006_add_rule_versions
+
+DROP TABLE IF EXISTS rule_versions;
@@ -0,0 +1,37 @@
+-- Active rule definitions and rebuild progress
+--
+-- Migration: 006_add_rule_versions
+-- Date: 2026-10-16
+--
+-- One row per (rule, bucket_size) holds the fingerprint the durable aggregates were
+-- built with. When a rule definition changes, its aggregates are deleted and rebuilt
+-- from the event log; rebuild_cursor tracks that replay up to rebuild_target.
+
+CREATE TABLE IF NOT EXISTS rule_versions
+(
+    rule_name      TEXT        NOT NULL,
+    bucket_size    TEXT        NOT NULL,
+    fingerprint    TEXT        NOT NULL,
+    rebuild_cursor BIGINT      NOT NULL DEFAULT 0,
+    rebuild_target BIGINT      NOT NULL DEFAULT 0,
+    updated_at     TIMESTAMPTZ NOT NULL,
+
+    PRIMARY KEY (rule_name, bucket_size)
+);
+
+-- Seed from existing aggregates so the first startup only rebuilds rules that really drifted.
+INSERT INTO rule_versions (rule_name, bucket_size, fingerprint, rebuild_cursor, rebuild_target, updated_at)
+SELECT DISTINCT ON (rule_name, bucket_size)
+    rule_name, bucket_size, rule_fingerprint, 0, 0, NOW()
+FROM pre_aggregates
+ORDER BY rule_name, bucket_size, updated_at DESC
+ON CONFLICT (rule_name, bucket_size) DO NOTHING;
+
+COMMENT ON TABLE rule_versions IS
+    'Active fingerprint per (rule, bucket_size). Aggregates flushed with another fingerprint are rejected.';
+
+COMMENT ON COLUMN rule_versions.rebuild_cursor IS
+    'Last ingest_seq replayed into the rule aggregates by the rebuild after a fingerprint change.';
+
+COMMENT ON COLUMN rule_versions.rebuild_target IS
+    'Bucket checkpoint when the rebuild started. The rebuild is complete once rebuild_cursor reaches it.';
@@ -0,0 +1,3 @@
+-- Rollback 007_add_aggregation_leases
+
+DROP TABLE IF EXISTS aggregation_leases;
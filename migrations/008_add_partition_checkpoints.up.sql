@@ -0,0 +1,67 @@
+-- Partition-sharded aggregation
+--
+-- Migration: 008_add_partition_checkpoints
+-- Date: 2026-10-16
+--
+-- Aggregation of one bucket size can be split into partition ranges that are drained
+-- independently. Each range needs its own checkpoint, so checkpoints are kept per
+-- (bucket_size, partition_id); a range's checkpoint is that of its partitions.
+-- Events get a partition_id so a range can read its stream without scanning the others.
+
+-- FNV-1a (32 bit) of the principal modulo 256. Must match partition.For and partition.Count.
+CREATE OR REPLACE FUNCTION aevon_partition_for(principal TEXT) RETURNS INT
+    LANGUAGE plpgsql
+    IMMUTABLE STRICT PARALLEL SAFE
+AS
+$$
+DECLARE
+    bytes BYTEA  := convert_to(principal, 'UTF8');
+    hash  BIGINT := 2166136261;
+BEGIN
+    FOR i IN 0 .. length(bytes) - 1
+        LOOP
+            hash := ((hash # get_byte(bytes, i)) * 16777619) % 4294967296;
+        END LOOP;
+    RETURN (hash % 256)::INT;
+END
+$$;
+
+-- Rewrites the events table once; ingestion keeps working without supplying the column.
+ALTER TABLE events
+    ADD COLUMN IF NOT EXISTS partition_id INT GENERATED ALWAYS AS (aevon_partition_for(principal_id)) STORED;
+
+CREATE INDEX IF NOT EXISTS idx_events_partition_seq
+    ON events (partition_id, ingest_seq);
+
+COMMENT ON COLUMN events.partition_id IS
+    'Logical partition (0-255) of principal_id. Aggregation shards read their partition range by it.';
+
+-- One checkpoint row per partition, seeded from the existing bucket-wide checkpoint.
+ALTER TABLE sweep_checkpoints
+    ADD COLUMN IF NOT EXISTS partition_id INT NOT NULL DEFAULT 0;
+
+ALTER TABLE sweep_checkpoints
+    DROP CONSTRAINT IF EXISTS sweep_checkpoints_pkey;
+
+ALTER TABLE sweep_checkpoints
+    ADD PRIMARY KEY (bucket_size, partition_id);
+
+INSERT INTO sweep_checkpoints (bucket_size, partition_id, checkpoint_cursor, updated_at)
+SELECT c.bucket_size, p, c.checkpoint_cursor, c.updated_at
+FROM sweep_checkpoints c
+         CROSS JOIN generate_series(1, 255) AS p
+WHERE c.partition_id = 0
+ON CONFLICT (bucket_size, partition_id) DO NOTHING;
+
+COMMENT ON TABLE sweep_checkpoints IS
+    'Checkpoint cursor per (bucket_size, partition). Cursor N means all events <= N of the partition are durably aggregated.';
+
+COMMENT ON COLUMN sweep_checkpoints.partition_id IS
+    'Logical partition (0-255). Partition ranges of a bucket size are aggregated independently.';
+
+-- Rebuild targets per partition; NULL when all partitions shared one checkpoint.
+ALTER TABLE rule_versions
+    ADD COLUMN IF NOT EXISTS partition_targets BIGINT[];
+
+COMMENT ON COLUMN rule_versions.partition_targets IS
+    'Checkpoint of every partition (indexed by partition_id) when the rebuild started; NULL if all equal rebuild_target.';
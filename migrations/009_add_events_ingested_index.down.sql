@@ -0,0 +1,3 @@
+-- Rollback 009_add_events_ingested_index
+
+DROP INDEX IF EXISTS idx_events_principal_ingested;
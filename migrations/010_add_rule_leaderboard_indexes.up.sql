@@ -0,0 +1,13 @@
+-- Per-rule reads across all principals
+--
+-- Migration: 010_add_rule_leaderboard_indexes
+-- Date: 2026-10-16
+--
+-- Top-N leaderboard queries read one rule's pre-aggregates for every principal and
+-- the unflushed events of one type; the primary keys lead with the principal.
+
+CREATE INDEX IF NOT EXISTS idx_pre_aggregates_rule_window
+    ON pre_aggregates (rule_name, bucket_size, window_start);
+
+CREATE INDEX IF NOT EXISTS idx_events_type_seq
+    ON events (type, ingest_seq);
@@ -0,0 +1,5 @@
+-- Rollback 011_add_threshold_webhooks
+
+DROP TABLE IF EXISTS webhook_outbox;
+DROP TABLE IF EXISTS threshold_crossings;
+DROP TABLE IF EXISTS thresholds;
@@ -0,0 +1,57 @@
+-- Threshold-crossing webhooks
+--
+-- Migration: 011_add_threshold_webhooks
+-- Date: 2026-10-16
+--
+-- thresholds holds thresholds registered through the API (config thresholds are not
+-- stored). threshold_crossings records the periods a threshold already crossed in, so
+-- it notifies once per period. webhook_outbox is the durable delivery queue: a crossing
+-- and its delivery are inserted in one transaction and the delivery row stays until the
+-- receiver acknowledged it or the dispatcher gave up.
+
+CREATE TABLE IF NOT EXISTS thresholds
+(
+    id           TEXT PRIMARY KEY,
+    rule_name    TEXT        NOT NULL,
+    principal_id TEXT        NOT NULL,
+    period       TEXT        NOT NULL,
+    timezone     TEXT        NOT NULL DEFAULT '',
+    value        NUMERIC     NOT NULL,
+    url          TEXT        NOT NULL,
+    created_at   TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_thresholds_rule_principal
+    ON thresholds (rule_name, principal_id);
+
+CREATE TABLE IF NOT EXISTS threshold_crossings
+(
+    threshold_id TEXT        NOT NULL,
+    period_key   TEXT        NOT NULL,
+    value        NUMERIC     NOT NULL,
+    crossed_at   TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (threshold_id, period_key)
+);
+
+COMMENT ON COLUMN threshold_crossings.period_key IS
+    'Period start (RFC 3339) of calendar periods; ''rolling'' for rolling periods, deleted when the value falls back below the threshold.';
+
+CREATE TABLE IF NOT EXISTS webhook_outbox
+(
+    id              BIGSERIAL PRIMARY KEY,
+    url             TEXT        NOT NULL,
+    payload         TEXT        NOT NULL,
+    attempts        INT         NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL,
+    delivered_at    TIMESTAMPTZ,
+    failed_at       TIMESTAMPTZ,
+    last_error      TEXT        NOT NULL DEFAULT '',
+    created_at      TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_outbox_due
+    ON webhook_outbox (next_attempt_at)
+    WHERE delivered_at IS NULL AND failed_at IS NULL;
+
+COMMENT ON COLUMN webhook_outbox.payload IS
+    'JSON body, stored as text so every attempt sends and signs the same bytes.';
@@ -0,0 +1,3 @@
+-- Rollback 012_add_pre_aggregate_shadows
+
+DROP TABLE IF EXISTS pre_aggregate_shadows;
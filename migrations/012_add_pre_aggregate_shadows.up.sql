@@ -0,0 +1,34 @@
+-- Shadow rows of range rebuilds
+--
+-- Migration: 012_add_pre_aggregate_shadows
+-- Date: 2026-10-16
+--
+-- `aevon rebuild` recomputes a rule's pre-aggregates over a time range from the event
+-- log into shadow rows, then replaces the live rows of the range with them in one
+-- transaction. Rows are keyed by rebuild_id so concurrent rebuilds do not collide; rows
+-- of a rebuild that died before its swap can be deleted at any time.
+
+CREATE TABLE IF NOT EXISTS pre_aggregate_shadows
+(
+    rebuild_id       TEXT        NOT NULL,
+    partition_id     INT         NOT NULL,
+    principal_id     TEXT        NOT NULL,
+    rule_name        TEXT        NOT NULL,
+    rule_fingerprint TEXT        NOT NULL,
+    bucket_size      TEXT        NOT NULL,
+    window_start     TIMESTAMPTZ NOT NULL,
+    dimensions       TEXT        NOT NULL DEFAULT '',
+    operator         TEXT        NOT NULL,
+    value            NUMERIC     NOT NULL,
+    aux_sum          NUMERIC     NOT NULL DEFAULT 0,
+    value_at         TIMESTAMPTZ,
+    sketch           BYTEA,
+    event_count      BIGINT      NOT NULL DEFAULT 0,
+    last_event_id    TEXT,
+    updated_at       TIMESTAMPTZ NOT NULL,
+
+    PRIMARY KEY (rebuild_id, partition_id, principal_id, rule_name, bucket_size, window_start, dimensions)
+);
+
+COMMENT ON TABLE pre_aggregate_shadows IS
+    'Pre-aggregates recomputed by a range rebuild, swapped into pre_aggregates atomically.';
@@ -0,0 +1,3 @@
+-- Rollback 014_add_events_type_ingested_index
+
+DROP INDEX IF EXISTS idx_events_type_ingested;
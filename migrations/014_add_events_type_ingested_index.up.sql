@@ -0,0 +1,11 @@
+-- Ingestion time lookups per event type
+--
+-- Migration: 014_add_events_type_ingested_index
+-- Date: 2026-10-16
+--
+-- Leaderboard queries over a range with a partial edge bucket look up the first event
+-- of the rule's type ingested near the edge, so the edge scan starts there instead of
+-- at the start of the event log.
+
+CREATE INDEX IF NOT EXISTS idx_events_type_ingested
+    ON events (type, ingested_at);
@@ -20,6 +20,7 @@ import (
 	"github.com/aevon-lab/project-aevon/internal/aggregation"
 	v1 "github.com/aevon-lab/project-aevon/internal/api/v1"
 	coreagg "github.com/aevon-lab/project-aevon/internal/core/aggregation"
+	"github.com/aevon-lab/project-aevon/internal/core/partition"
 	"github.com/aevon-lab/project-aevon/internal/core/storage/postgres"
 	"github.com/aevon-lab/project-aevon/internal/ingestion"
 	"github.com/aevon-lab/project-aevon/internal/projection"
@@ -135,6 +136,20 @@ func TestCoreAPI_DuplicateEventReturnsConflict(t *testing.T) {
 	require.Equal(t, http.StatusConflict, status, string(body))
 }
 
+func TestPartitionFunctionMatchesPartitionFor(t *testing.T) {
+	h := startHarnessWithoutScheduler(t)
+	defer h.close(t)
+
+	require.NoError(t, resetDatabase(t, h.db))
+
+	ctx := context.Background()
+	for _, principalID := range []string{"", "user-integration", "tenant-42", "ünïcode-principal"} {
+		var partitionID int
+		require.NoError(t, h.db.QueryRowContext(ctx, `SELECT aevon_partition_for($1)`, principalID).Scan(&partitionID))
+		require.Equal(t, partition.For(principalID), partitionID, "principal %q", principalID)
+	}
+}
+
 func startHarness(t *testing.T) *integrationHarness {
 	t.Helper()
 	return startHarnessWithOptions(t, true, 200*time.Millisecond)
@@ -262,7 +277,7 @@ func resetDatabase(t *testing.T, db *sql.DB) error {
 	if err != nil {
 		return err
 	}
-	_, err = db.ExecContext(ctx, `TRUNCATE TABLE events`)
+	_, err = db.ExecContext(ctx, `TRUNCATE TABLE events, event_keys`)
 	if err != nil {
 		return err
 	}
@@ -300,7 +315,7 @@ func readCheckpoint(t *testing.T, db *sql.DB) int64 {
 	defer cancel()
 
 	var cursor int64
-	err := db.QueryRowContext(ctx, `SELECT checkpoint_cursor FROM sweep_checkpoints WHERE bucket_size='1m'`).Scan(&cursor)
+	err := db.QueryRowContext(ctx, `SELECT COALESCE(MIN(checkpoint_cursor), 0) FROM sweep_checkpoints WHERE bucket_size='1m'`).Scan(&cursor)
 	require.NoError(t, err)
 	return cursor
 }